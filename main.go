@@ -1,12 +1,24 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"os"
 
 	"github.com/harvester/harvester-installer/pkg/console"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "preflight" {
+		if err := runPreflight(os.Args[2:]); errors.Is(err, errPreflightCancelled) {
+			log.Println(err)
+			os.Exit(exitCancelled)
+		} else if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	if err := console.RunConsole(); err != nil {
 		log.Panicln(err)
 	}
@@ -116,6 +116,12 @@ type Addon struct {
 	ValuesContent string `json:"valuesContent,omitempty"`
 }
 
+type PCIDevice struct {
+	Address  string `json:"address,omitempty"`
+	VendorID string `json:"vendorId,omitempty"`
+	DeviceID string `json:"deviceId,omitempty"`
+}
+
 type LHDefaultSettings struct {
 	// 0 is valid, means not setting CPU resources, use pointer to check if it is set
 	GuaranteedEngineManagerCPU  *uint32 `json:"guaranteedEngineManagerCPU,omitempty"`
@@ -141,10 +147,11 @@ type HarvesterChartValues struct {
 }
 
 type Install struct {
-	Automatic           bool    `json:"automatic,omitempty"`
-	SkipChecks          bool    `json:"skipchecks,omitempty"`
-	Mode                string  `json:"mode,omitempty"`
-	ManagementInterface Network `json:"managementInterface,omitempty"`
+	Automatic           bool     `json:"automatic,omitempty"`
+	SkipChecks          bool     `json:"skipchecks,omitempty"`
+	SkipCheckList       []string `json:"skipCheckList,omitempty"`
+	Mode                string   `json:"mode,omitempty"`
+	ManagementInterface Network  `json:"managementInterface,omitempty"`
 
 	Vip       string `json:"vip,omitempty"`
 	VipHwAddr string `json:"vipHwAddr,omitempty"`
@@ -159,6 +166,7 @@ type Install struct {
 	ConfigURL     string   `json:"configUrl,omitempty"`
 	Silent        bool     `json:"silent,omitempty"`
 	ISOURL        string   `json:"isoUrl,omitempty"`
+	ISOChecksum   string   `json:"isoChecksum,omitempty"`
 	PowerOff      bool     `json:"powerOff,omitempty"`
 	NoFormat      bool     `json:"noFormat,omitempty"`
 	Debug         bool     `json:"debug,omitempty"`
@@ -177,7 +185,37 @@ type Install struct {
 	Addons                  map[string]Addon     `json:"addons,omitempty"`
 	Harvester               HarvesterChartValues `json:"harvester,omitempty"`
 	RawDiskImagePath        string               `json:"rawDiskImagePath,omitempty"`
+	RawDiskImageChecksum    string               `json:"rawDiskImageChecksum,omitempty"`
 	PersistentPartitionSize string               `json:"persistentPartitionSize,omitempty"`
+	PCIPassthrough          []PCIDevice          `json:"pciPassthrough,omitempty"`
+	// AirGapped means the site has no internet access, so preflight
+	// checks don't probe anything on the internet
+	AirGapped bool `json:"airGapped,omitempty"`
+	// RequireTPM means the install seals its disk key to a TPM 2.0, so the
+	// preflight checks treat a host without one as unable to install
+	RequireTPM bool `json:"requireTpm,omitempty"`
+	// Telemetry is where, if anywhere, anonymised preflight outcomes are
+	// reported to.  Nothing is sent unless it's enabled.
+	Telemetry Telemetry `json:"telemetry,omitempty"`
+	// ReportSigning is how the persisted preflight report is signed, so
+	// that it can be shown not to have been edited since.
+	ReportSigning ReportSigning `json:"reportSigning,omitempty"`
+}
+
+// Telemetry is the opt-in reporting of which preflight checks passed or
+// failed, on what class of hardware, so that their defaults can be tuned.
+type Telemetry struct {
+	Enabled  bool   `json:"enabled,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// ReportSigning is the signing of preflight reports with an Ed25519 key.
+// Key is the base64 of the key's 32 byte seed, or of the whole 64 byte
+// private key.  If it's empty and signing is enabled, a key is generated
+// for the live session, and its public half shown on the console.
+type ReportSigning struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Key     string `json:"key,omitempty"`
 }
 
 type Wifi struct {
@@ -202,6 +240,9 @@ type OS struct {
 	Modules        []string          `json:"modules,omitempty"`
 	Sysctls        map[string]string `json:"sysctls,omitempty"`
 	NTPServers     []string          `json:"ntpServers,omitempty"`
+	Timezone       string            `json:"timezone,omitempty"`
+	Locale         string            `json:"locale,omitempty"`
+	Keymap         string            `json:"keymap,omitempty"`
 	DNSNameservers []string          `json:"dnsNameservers,omitempty"`
 	Wifi           []Wifi            `json:"wifi,omitempty"`
 	Password       string            `json:"password,omitempty"`
@@ -159,24 +159,8 @@ func (c *Console) doRun() error {
 	}
 
 	if preflightCheck {
-		checks := []preflight.Check{
-			preflight.CPUCheck{},
-			preflight.MemoryCheck{},
-			preflight.VirtCheck{},
-			preflight.KVMHostCheck{},
-		}
-		for _, c := range checks {
-			msg, err := c.Run()
-			if err != nil {
-				// Preflight checks that fail to run at all are
-				// logged, rather than killing the installer
-				logrus.Error(err)
-				continue
-			}
-			if len(msg) > 0 {
-				preflightWarnings = append(preflightWarnings, msg)
-			}
-		}
+		// The NICs' speeds are checked once they've been chosen
+		preflightWarnings = runPreflightChecks(preflight.DefaultChecks(nil))
 	}
 
 	c.SetManagerFunc(dashboard)
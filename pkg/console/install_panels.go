@@ -57,23 +57,16 @@ var (
 	alreadyInstalled  bool
 	installModeOnly   bool
 	diskConfirmed     bool
-	preflightWarnings []string
+	preflightWarnings []preflight.Result
 )
 
-func (c *Console) doNetworkSpeedCheck(interfaces []config.NetworkInterface) (warnings []string) {
+func (c *Console) doNetworkSpeedCheck(interfaces []config.NetworkInterface) []preflight.Result {
+	checks := make([]preflight.ResultCheck, 0, len(interfaces))
 	for _, iface := range interfaces {
-		msg, err := preflight.NetworkSpeedCheck{Dev: iface.Name}.Run()
-		if err != nil {
-			// Preflight checks that fail to run at all are
-			// logged, rather than killing the installer
-			logrus.Error(err)
-			continue
-		}
-		if len(msg) > 0 {
-			warnings = append(warnings, msg)
-		}
+		checks = append(checks, preflight.WithRetry(preflight.NetworkSpeedCheck{Dev: iface.Name},
+			preflight.DefaultRetryAttempts, preflight.DefaultRetryDelay))
 	}
-	return
+	return runPreflightChecks(checks)
 }
 
 func (c *Console) layoutInstall(_ *gocui.Gui) error {
@@ -798,7 +791,7 @@ func addPreflightCheckPanel(c *Console) error {
 	preflightCheckV.PreShow = func() error {
 		var warnings string
 		for _, w := range preflightWarnings {
-			warnings += w + "\n"
+			warnings += w.Message + "\n"
 		}
 		preflightCheckV.SetContent(warnings +
 			"\nDo you wish to proceed?\n")
@@ -1394,7 +1387,7 @@ func addNetworkPanel(c *Console) error {
 		bondNoteMsg := bondNote
 		// This is just for display purposes on the network screen
 		for _, warning := range c.doNetworkSpeedCheck(mgmtNetwork.Interfaces) {
-			bondNoteMsg += "\n" + warning
+			bondNoteMsg += "\n" + warning.Message
 		}
 		return c.setContentByName(bondNotePanel, bondNoteMsg)
 	}
@@ -2432,25 +2425,24 @@ func addInstallPanel(c *Console) error {
 				// Have to handle preflight warnings here because we can't check
 				// the NIC speed until we've got the correct set of interfaces.
 				preflightWarnings = append(preflightWarnings, c.doNetworkSpeedCheck(c.config.ManagementInterface.Interfaces)...)
-				if len(preflightWarnings) > 0 {
-					if c.config.SkipChecks {
-						// User is happy to skip checks so let installation proceed,
-						// but still log the warning messages (this happens for both
-						// interactive and automatic/PXE install)
-						for _, warning := range preflightWarnings {
-							logrus.Warning(warning)
-						}
-						logrus.Info("Installation will proceed (harvester.install.skipchecks = true)")
-					} else {
-						// Checks were not explicitly skipped, fail the install
-						// (this will happen when PXE booted if checks fail and
-						// you don't set harvester.install.skipcheck=true)
-						for _, warning := range preflightWarnings {
-							logrus.Error(warning)
-							printToPanel(c.Gui, warning, installPanel)
-						}
-						return
+				blocking, overridden := gatePreflightWarnings(c.config, preflightWarnings)
+				// User is happy to skip these checks so let installation
+				// proceed, but still log the warning messages (this happens
+				// for both interactive and automatic/PXE install)
+				for _, warning := range overridden {
+					logrus.Warning(warning.Message)
+					logrus.Infof("Installation will proceed despite the %s preflight check warning, as configured", warning.Name)
+				}
+				if len(blocking) > 0 {
+					// Checks were not explicitly skipped, fail the install
+					// (this will happen when PXE booted if checks fail and
+					// you don't set harvester.install.skipchecks=true, or
+					// list them in install.skip_check_list)
+					for _, warning := range blocking {
+						logrus.Error(warning.Message)
+						printToPanel(c.Gui, warning.Message, installPanel)
 					}
+					return
 				}
 			}
 
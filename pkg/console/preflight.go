@@ -0,0 +1,173 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
+)
+
+// runPreflightChecks runs the checks, and returns the results of those
+// which warned or failed.  Preflight checks that fail to run at all are
+// only logged, by the Runner, rather than killing the installer.
+func runPreflightChecks(checks []preflight.ResultCheck) (warnings []preflight.Result) {
+	report := preflight.NewRunner(checks...).Run(context.Background())
+	for _, result := range report.Results {
+		if result.Error == "" && result.Severity >= preflight.SeverityWarning {
+			warnings = append(warnings, result)
+		}
+	}
+	return
+}
+
+// gatePreflightWarnings splits the warnings of the hardware checks into
+// those which stop the installation, and those the configuration
+// overrides, with skipchecks, or by naming their checks in skipCheckList,
+// as installPreflight does for the results which failed.
+func gatePreflightWarnings(cfg *config.HarvesterConfig, warnings []preflight.Result) (blocking, overridden []preflight.Result) {
+	for _, warning := range warnings {
+		skipped := slices.ContainsFunc(cfg.Install.SkipCheckList, func(name string) bool {
+			return strings.EqualFold(name, warning.Name)
+		})
+		if cfg.Install.SkipChecks || skipped {
+			overridden = append(overridden, warning)
+		} else {
+			blocking = append(blocking, warning)
+		}
+	}
+	return
+}
+
+// installPreflight runs the preflight checks before an automatic
+// installation, which has nobody to ask whether to proceed when checks
+// fail.
+type installPreflight struct {
+	// checks returns the checks to run for an install configuration
+	checks func(*config.HarvesterConfig) []preflight.ResultCheck
+	// reportPath is where the report is persisted, and nodeStatusPath
+	// where it's persisted as node conditions and annotations
+	reportPath     string
+	nodeStatusPath string
+	// activeConsoles lists the kernel's consoles, whose devices are in
+	// devDir, so the report can be copied to the serial ones
+	activeConsoles string
+	devDir         string
+}
+
+var automaticInstallPreflight = installPreflight{
+	checks:         preflight.ConfigChecks,
+	reportPath:     preflight.DefaultReportPath,
+	nodeStatusPath: preflight.DefaultNodeStatusPath,
+	activeConsoles: "/sys/class/tty/console/active",
+	devDir:         "/dev",
+}
+
+// run runs the checks, printing the report to out, the log and the serial
+// consoles, and persisting it.  It returns an error if any checks failed,
+// unless the configuration overrides them with skipchecks, or by naming
+// them in skipCheckList, in which case the report records that.
+func (p installPreflight) run(ctx context.Context, cfg *config.HarvesterConfig, out io.Writer) error {
+	runner := preflight.Runner{Checks: p.checks(cfg), Options: preflight.OptionsFromConfig(cfg)}
+	report := runner.Run(ctx)
+	report.Override(cfg.Install.SkipChecks, cfg.Install.SkipCheckList)
+	// The installation doesn't wait for telemetry, if it's enabled
+	preflight.NewTelemetrySender(cfg).SendInBackground(ctx, report)
+
+	// The Runner logged the results as they came in
+	var text bytes.Buffer
+	report.WriteText(&text)
+	if _, err := out.Write(text.Bytes()); err != nil {
+		logrus.Errorf("failed to print the preflight report: %v", err)
+	}
+	p.writeSerialConsoles(text.Bytes())
+	key, generated, err := preflight.ReportSigningKey(cfg)
+	if err != nil {
+		// It's still worth having, unsigned
+		logrus.Errorf("failed to load the preflight report signing key: %v", err)
+	} else if generated {
+		msg := fmt.Sprintf("Preflight reports are signed with a key generated for this session, whose public key is %s",
+			base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+		logrus.Infof("preflight: %s", msg)
+		if _, err := io.WriteString(out, msg+"\n"); err != nil {
+			logrus.Errorf("failed to print the preflight report signing key: %v", err)
+		}
+	}
+	if err := report.WriteSignedFile(p.reportPath, key); err != nil {
+		logrus.Errorf("failed to persist the preflight report: %v", err)
+	}
+	if err := report.WriteNodeStatusFile(p.nodeStatusPath, preflight.DefaultAnnotationLimit); err != nil {
+		logrus.Errorf("failed to persist the preflight node status: %v", err)
+	}
+
+	if report.Cancelled {
+		return errors.New("preflight checks were cancelled before they had all run")
+	}
+	fatal := report.Fatal()
+	if len(fatal) == 0 {
+		for _, result := range report.Results {
+			if result.Overridden {
+				logrus.Warnf("Installation will proceed despite the %s preflight check failing, as configured", result.Name)
+			}
+		}
+		return nil
+	}
+	names := make([]string, 0, len(fatal))
+	for _, result := range fatal {
+		names = append(names, result.Name)
+	}
+	return fmt.Errorf("preflight checks failed: %s (set install.skipchecks, or list them in install.skip_check_list, to install regardless)",
+		strings.Join(names, ", "))
+}
+
+// writeSerialConsoles copies text to the serial consoles, other than the
+// one the installer is running on, so that it's captured by serial
+// console logging.
+func (p installPreflight) writeSerialConsoles(text []byte) {
+	active, err := os.ReadFile(p.activeConsoles)
+	if err != nil {
+		logrus.Errorf("failed to list the consoles: %v", err)
+		return
+	}
+	ownTTY := strings.TrimPrefix(os.Getenv("TTY"), "/dev/")
+	crlf := bytes.ReplaceAll(text, []byte("\n"), []byte("\r\n"))
+	for _, name := range strings.Fields(string(active)) {
+		if name == ownTTY || !(strings.HasPrefix(name, "ttyS") || strings.HasPrefix(name, "ttyAMA")) {
+			continue
+		}
+		f, err := os.OpenFile(filepath.Join(p.devDir, name), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			logrus.Errorf("failed to open serial console %s: %v", name, err)
+			continue
+		}
+		if _, err := f.Write(crlf); err != nil {
+			logrus.Errorf("failed to write to serial console %s: %v", name, err)
+		}
+		f.Close()
+	}
+}
+
+// panelWriter prints to a panel, a line at a time.
+type panelWriter struct {
+	g     *gocui.Gui
+	panel string
+}
+
+func (w panelWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		printToPanel(w.g, line, w.panel)
+	}
+	return len(p), nil
+}
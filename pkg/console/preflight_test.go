@@ -0,0 +1,189 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+// fakeCheck returns a canned result
+type fakeCheck struct {
+	result preflight.Result
+}
+
+func (c fakeCheck) Evaluate(context.Context, *preflight.Env) (preflight.Result, error) {
+	return c.result, nil
+}
+
+func TestAutomaticInstallPreflight(t *testing.T) {
+	t.Setenv("TTY", "/dev/tty1")
+	checks := func(*config.HarvesterConfig) []preflight.ResultCheck {
+		return []preflight.ResultCheck{
+			fakeCheck{preflight.Result{Name: "CPU", Message: "Enough CPUs."}},
+			fakeCheck{preflight.Result{Name: "Residue", Severity: preflight.SeverityFatal, Message: "/dev/sda has data on it."}},
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		config      string
+		expectError string
+		overridden  bool
+		signed      bool
+	}{
+		{
+			name:        "Failed check aborts",
+			config:      "automatic.yaml",
+			expectError: "preflight checks failed: Residue (set install.skipchecks, or list them in install.skip_check_list, to install regardless)",
+		},
+		{
+			name:       "All checks skipped",
+			config:     "skipchecks.yaml",
+			overridden: true,
+		},
+		{
+			name:       "Failed check skipped",
+			config:     "skip-check-list.yaml",
+			overridden: true,
+		},
+		{
+			name:       "Report signed",
+			config:     "signed.yaml",
+			overridden: true,
+			signed:     true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := config.LoadHarvesterConfig(util.LoadFixture(t, filepath.Join("preflight", tc.config)))
+			require.NoError(t, err)
+
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "ttyS0"), nil, 0600))
+			gate := installPreflight{
+				checks:         checks,
+				reportPath:     filepath.Join(dir, "preflight.json"),
+				nodeStatusPath: filepath.Join(dir, "preflight-node.json"),
+				activeConsoles: "testdata/preflight/active",
+				devDir:         dir,
+			}
+			var out strings.Builder
+			err = gate.run(context.Background(), cfg, &out)
+			if tc.expectError != "" {
+				assert.EqualError(t, err, tc.expectError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			suffix := ""
+			if tc.overridden {
+				suffix = " (overridden)"
+			}
+			text := "pass  CPU               Enough CPUs.\n" +
+				"fail  Residue           /dev/sda has data on it." + suffix + "\n"
+			assert.Equal(t, text, out.String())
+			serial, err := os.ReadFile(filepath.Join(dir, "ttyS0"))
+			require.NoError(t, err)
+			assert.Equal(t, strings.ReplaceAll(text, "\n", "\r\n"), string(serial))
+
+			// The report is always hashed, and signed if there's a key
+			var publicKey ed25519.PublicKey
+			if tc.signed {
+				publicKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+			}
+			report, err := preflight.VerifyReport(gate.reportPath, publicKey)
+			require.NoError(t, err)
+			assert.Equal(t, tc.signed, report.Integrity.Signature != "")
+			// How long the checks took varies from run to run
+			for i := range report.Results {
+				assert.GreaterOrEqual(t, report.Results[i].Duration, time.Duration(0))
+				report.Results[i].Duration = 0
+			}
+			assert.Equal(t, []preflight.Result{
+				{Name: "CPU", Message: "Enough CPUs."},
+				{Name: "Residue", Severity: preflight.SeverityFatal, Message: "/dev/sda has data on it.", Overridden: tc.overridden},
+			}, report.Results)
+
+			data, err := os.ReadFile(gate.nodeStatusPath)
+			require.NoError(t, err)
+			var status preflight.NodeStatus
+			require.NoError(t, json.Unmarshal(data, &status))
+			reason := "Failed"
+			if tc.overridden {
+				reason = "Overridden"
+			}
+			require.Len(t, status.Conditions, 2)
+			assert.Equal(t, "PreflightResidue", status.Conditions[1].Type)
+			assert.Equal(t, preflight.ConditionFalse, status.Conditions[1].Status)
+			assert.Equal(t, reason, status.Conditions[1].Reason)
+			assert.Contains(t, status.Annotations, preflight.AnnotationKey)
+		})
+	}
+}
+
+// The warnings of the hardware checks the installer runs itself, such as
+// the NICs' speeds, are overridden as the preflight checks' failures are.
+func TestGatePreflightWarnings(t *testing.T) {
+	warnings := []preflight.Result{
+		{Name: "NetworkSpeed", Severity: preflight.SeverityWarning, Message: "eth0 is only 1Gbps."},
+		{Name: "Memory", Severity: preflight.SeverityFatal, Message: "Only 16GiB of memory."},
+	}
+	testCases := []struct {
+		name       string
+		install    config.Install
+		blocking   []string
+		overridden []string
+	}{
+		{
+			name:     "no overrides",
+			blocking: []string{"NetworkSpeed", "Memory"},
+		},
+		{
+			name:       "skipchecks",
+			install:    config.Install{SkipChecks: true},
+			overridden: []string{"NetworkSpeed", "Memory"},
+		},
+		{
+			name:       "skip list",
+			install:    config.Install{SkipCheckList: []string{"networkspeed"}},
+			blocking:   []string{"Memory"},
+			overridden: []string{"NetworkSpeed"},
+		},
+	}
+	names := func(results []preflight.Result) (names []string) {
+		for _, result := range results {
+			names = append(names, result.Name)
+		}
+		return
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.NewHarvesterConfig()
+			cfg.Install = tc.install
+			blocking, overridden := gatePreflightWarnings(cfg, warnings)
+			assert.Equal(t, tc.blocking, names(blocking))
+			assert.Equal(t, tc.overridden, names(overridden))
+		})
+	}
+}
+
+// The node status outlives the live environment, because harv-install
+// copies it to where the installed system looks for it.
+func TestPreflightNodeStatusInstalled(t *testing.T) {
+	script, err := os.ReadFile("../../package/harvester-os/files/usr/sbin/harv-install")
+	require.Nil(t, err)
+	assert.Contains(t, string(script), "cp $HARVESTER_PREFLIGHT_NODE_STATUS ${TARGET}"+preflight.InstalledNodeStatusPath+"\n")
+}
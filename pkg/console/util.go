@@ -508,6 +508,15 @@ func roleSetup(c *config.HarvesterConfig) error {
 
 func doInstall(g *gocui.Gui, hvstConfig *config.HarvesterConfig, webhooks RendererWebhooks) error {
 	ctx := context.TODO()
+
+	// Nobody is watching an automatic installation, so failed preflight
+	// checks stop it here, before any disk is touched
+	if hvstConfig.Install.Automatic {
+		if err := automaticInstallPreflight.run(ctx, hvstConfig, panelWriter{g, installPanel}); err != nil {
+			return err
+		}
+	}
+
 	webhooks.Handle(EventInstallStarted)
 
 	err := updateSystemSettings(hvstConfig)
@@ -1042,6 +1051,8 @@ func generateEnvAndConfig(g *gocui.Gui, hvstConfig *config.HarvesterConfig) ([]s
 	env := append(os.Environ(), ev...)
 	env = append(env, fmt.Sprintf("HARVESTER_CONFIG=%s", hvstConfigFile))
 	env = append(env, fmt.Sprintf("HARVESTER_INSTALLATION_LOG=%s", defaultLogFilePath))
+	// harv-install copies it to preflight.InstalledNodeStatusPath
+	env = append(env, fmt.Sprintf("HARVESTER_PREFLIGHT_NODE_STATUS=%s", automaticInstallPreflight.nodeStatusPath))
 	env = append(env, fmt.Sprintf("HARVESTER_STREAMDISK_CLOUDINIT_URL=%s", userDataURL))
 	return env, elementalConfig, nil
 }
@@ -0,0 +1,60 @@
+package preflight
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// airGappedMode is how results changed by Options.AirGapped say so
+const airGappedMode = "air-gapped mode"
+
+// internetDomains are those of well-known services on the internet,
+// which an air-gapped site can't reach.  Other names are assumed to be on
+// site, since somebody configured them for it.
+var internetDomains = []string{
+	"amazonaws.com",
+	"apple.com",
+	"azurecr.io",
+	"cloudflare.com",
+	"docker.com",
+	"docker.io",
+	"ecr.aws",
+	"gcr.io",
+	"ghcr.io",
+	"github.com",
+	"githubusercontent.com",
+	"gitlab.com",
+	"google.com",
+	"k8s.io",
+	"launchpad.net",
+	"ntp.org",
+	"opensuse.org",
+	"pkg.dev",
+	"quay.io",
+	"rancher.com",
+	"rancher.io",
+	"suse.com",
+	"windows.com",
+}
+
+// onInternet returns whether host, a name or an address, is on the
+// internet rather than on site.
+func onInternet(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsGlobalUnicast() && !ip.IsPrivate()
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range internetDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// notContacted describes an endpoint which wasn't contacted because it's
+// on the internet and the host is air-gapped.
+func notContacted(endpoint string) string {
+	return fmt.Sprintf("%s is on the internet, so it was not contacted in %s.", endpoint, airGappedMode)
+}
@@ -0,0 +1,207 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http/httpproxy"
+)
+
+func TestOnInternet(t *testing.T) {
+	for host, expected := range map[string]bool{
+		"registry-1.docker.io":  true,
+		"0.suse.pool.ntp.org":   true,
+		"GitHub.com.":           true,
+		"8.8.8.8":               true,
+		"2001:4860:4860::8888":  true,
+		"registry.example.com":  false,
+		"notdocker.io":          false,
+		"10.0.0.2":              false,
+		"192.168.1.10":          false,
+		"127.0.0.1":             false,
+		"fd00::1":               false,
+		"ntp.corp.internal":     false,
+		"mirror.suse.com.local": false,
+	} {
+		assert.Equal(t, expected, onInternet(host), host)
+	}
+}
+
+// internetProxy stands in for the internet, as an HTTP proxy which
+// records the hosts requests were for.  It serves a registry's /v2/ and
+// alice's SSH keys, whatever the host.
+type internetProxy struct {
+	*httptest.Server
+	mu    sync.Mutex
+	hosts []string
+}
+
+func newInternetProxy(t *testing.T) *internetProxy {
+	keys, err := os.ReadFile("./testdata/ssh-keys/alice.keys")
+	assert.Nil(t, err)
+	p := &internetProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.hosts = append(p.hosts, r.URL.Host)
+		p.mu.Unlock()
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/alice.keys":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(keys)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *internetProxy) contacted() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hosts := p.hosts
+	p.hosts = nil
+	return hosts
+}
+
+// Air-gapped mode must stop the network checks contacting anything on
+// the internet, and say so, while endpoints on site are still probed.
+func TestAirGappedMode(t *testing.T) {
+	h := testHost()
+	fixed := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	h.now = func() time.Time { return fixed }
+
+	internet := newInternetProxy(t)
+	proxy := httpproxy.Config{HTTPProxy: internet.URL}
+	onSite := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer onSite.Close()
+	onSiteHost := onSite.Listener.Addr().String()
+	registries, _ := json.Marshal(map[string]interface{}{
+		"mirrors": map[string]interface{}{
+			"docker.io": map[string]interface{}{"endpoint": []string{onSite.URL, "http://registry-1.docker.io"}},
+		},
+	})
+	// The on-site server's responses are empty
+	onSiteArtifact := onSite.URL + "/saftos.iso has no checksum to verify against. " +
+		"Its SHA256 is e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855."
+	port := freeUDPPort(t)
+	newFakeSNTPServer(t, "127.0.0.1", port, sntpOK, fixed)
+
+	tests := []struct {
+		name              string
+		check             ResultCheck
+		connected         Result
+		airGapped         Result
+		internetContacted []string
+	}{
+		{
+			name: "NTP",
+			check: ConfiguredNTPCheck{
+				Servers:  []string{"ntp.example.com", "pool.ntp.org"},
+				Resolver: fakeResolver{"ntp.example.com": {"127.0.0.1"}},
+				Port:     port,
+				Timeout:  200 * time.Millisecond,
+			},
+			connected: Result{Name: "ConfiguredNTP", Severity: SeverityWarning,
+				Message: "1 of 2 queried NTP servers responded (names resolved via the system resolver). " +
+					"At least two are needed to detect a server with the wrong time. " +
+					"ntp.example.com (127.0.0.1): reachable, offset +0s. " +
+					"pool.ntp.org: cannot resolve: no such host."},
+			airGapped: Result{Name: "ConfiguredNTP", Severity: SeverityWarning, AirGapped: true,
+				Message: "1 of 1 queried NTP servers responded (names resolved via the system resolver). " +
+					"At least two are needed to detect a server with the wrong time. " +
+					"ntp.example.com (127.0.0.1): reachable, offset +0s. " +
+					"pool.ntp.org is on the internet, so it was not contacted in air-gapped mode."},
+		},
+		{
+			name: "DNS",
+			check: ConfiguredDNSCheck{
+				Servers: []string{"10.0.0.2", "8.8.8.8"},
+				Client: fakeDNSClient{
+					"10.0.0.2": {response: dnsResponse{RCode: dnsRCodeRefused, Latency: 40 * time.Millisecond}},
+					"8.8.8.8":  {response: dnsResponse{RecursionAvailable: true, Answers: 1, Latency: 12 * time.Millisecond}},
+				},
+			},
+			connected: Result{Name: "ConfiguredDNS", Severity: SeverityWarning,
+				Message: "1 of 2 queried DNS servers answered a query for docker.io. " +
+					"10.0.0.2: responded in 40ms, but refuses to recurse, so it cannot resolve external names such as docker.io. " +
+					"8.8.8.8: answered NOERROR in 12ms."},
+			airGapped: Result{Name: "ConfiguredDNS", AirGapped: true,
+				Message: "1 of 1 queried DNS servers answered a query for docker.io. " +
+					"In air-gapped mode, docker.io is not expected to resolve. " +
+					"10.0.0.2: answered REFUSED in 40ms. " +
+					"8.8.8.8 is on the internet, so it was not contacted in air-gapped mode."},
+		},
+		{
+			name:  "registry mirrors",
+			check: RegistryMirrorCheck{Registries: string(registries), Proxy: proxy},
+			connected: Result{Name: "RegistryMirror",
+				Message: "docker.io mirror " + onSiteHost + " is reachable, and allows anonymous access. " +
+					"docker.io mirror registry-1.docker.io is reachable, and allows anonymous access."},
+			airGapped: Result{Name: "RegistryMirror", AirGapped: true,
+				Message: "docker.io mirror " + onSiteHost + " is reachable, and allows anonymous access. " +
+					"docker.io mirror registry-1.docker.io is on the internet, so it was not contacted in air-gapped mode."},
+			internetContacted: []string{"registry-1.docker.io"},
+		},
+		{
+			name:  "SSH key URLs",
+			check: SSHKeyCheck{Keys: []string{"http://gitlab.com/alice.keys"}, Proxy: proxy, Timeout: 200 * time.Millisecond},
+			connected: Result{Name: "SSHKey",
+				Message: "http://gitlab.com/alice.keys: 2 valid keys."},
+			airGapped: Result{Name: "SSHKey", Severity: SeverityFatal, AirGapped: true,
+				Message: "http://gitlab.com/alice.keys: no usable keys, because http://gitlab.com/alice.keys cannot be fetched when the host is air-gapped."},
+			internetContacted: []string{"gitlab.com"},
+		},
+		{
+			name:      "proxy",
+			check:     ProxyCoverageCheck{},
+			connected: Result{Name: "ProxyCoverage", Message: "Skipped: no proxy is configured."},
+			airGapped: Result{Name: "ProxyCoverage", AirGapped: true,
+				Message: "Skipped: no proxy is configured, as expected when air-gapped."},
+		},
+		{
+			name:      "artifacts on site",
+			check:     ArtifactChecksumCheck{Artifacts: []Artifact{{Location: onSite.URL + "/saftos.iso"}}},
+			connected: Result{Name: "ArtifactChecksum", Severity: SeverityInfo, Message: onSiteArtifact},
+			airGapped: Result{Name: "ArtifactChecksum", Severity: SeverityInfo, Message: onSiteArtifact},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.check.Evaluate(context.Background(), &Env{machine: h})
+			assert.Nil(t, err)
+			assert.Equal(t, tt.connected, result)
+			assert.Equal(t, tt.internetContacted, internet.contacted())
+
+			result, err = tt.check.Evaluate(context.Background(), &Env{Options: Options{AirGapped: true}, machine: h})
+			assert.Nil(t, err)
+			assert.Equal(t, tt.airGapped, result)
+			assert.Empty(t, internet.contacted(), "nothing on the internet may be contacted when air-gapped")
+		})
+	}
+}
+
+// Artifacts on the internet aren't downloaded when air-gapped, which is
+// only tested in that mode, since ArtifactChecksumCheck doesn't take a
+// proxy.
+func TestAirGappedArtifacts(t *testing.T) {
+	check := ArtifactChecksumCheck{
+		Artifacts: []Artifact{{Location: "https://releases.rancher.com/saftos/v1.4.1/saftos.iso"}},
+	}
+	result, err := check.Evaluate(context.Background(), &Env{Options: Options{AirGapped: true}})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "ArtifactChecksum", AirGapped: true,
+		Message: "Skipped: https://releases.rancher.com/saftos/v1.4.1/saftos.iso is on the internet, so it was not contacted in air-gapped mode."}, result)
+}
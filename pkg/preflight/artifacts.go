@@ -0,0 +1,336 @@
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// artifactTimeout is how long a server may take to connect and answer,
+// or go without sending anything of a download, by default.  The whole
+// download may take as long as the check may.
+const artifactTimeout = 30 * time.Second
+
+var sha256Regexp = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// An Artifact is a file the installer will consume, such as the ISO.
+type Artifact struct {
+	// Location is a local path, or an http or https URL.
+	Location string
+	// SHA256 is the expected digest.  If it's empty, the digest is read
+	// from Location with .sha256 appended, if that exists.
+	SHA256 string
+}
+
+// ArtifactChecksumCheck verifies that the artifacts the installer will
+// consume are intact, because corruption or truncation in transit
+// otherwise shows up as random errors part way through installation.
+// Local files are hashed in place.  Remote files are hashed as they're
+// downloaded, without keeping them, as they'd only fill up memory in the
+// live environment, checking that the whole Content-Length arrived.
+// Progress, if set, is called as hashing proceeds; total is -1 if the
+// size isn't known.  Mismatches are fatal, and give the expected and
+// actual digests.  Artifacts without an expected digest are only checked
+// for truncation.  When the host is air-gapped, artifacts on the internet
+// aren't downloaded.
+type ArtifactChecksumCheck struct {
+	Artifacts []Artifact
+	Progress  func(location string, done, total int64)
+	// Timeout is how long a server may take to answer, or stall part way
+	// through a download, or artifactTimeout if zero.
+	Timeout time.Duration
+}
+
+// NewArtifactChecksumCheck returns an ArtifactChecksumCheck for the ISO
+// and raw disk image in the given install configuration, which logs its
+// progress.
+func NewArtifactChecksumCheck(cfg *config.HarvesterConfig) ArtifactChecksumCheck {
+	check := ArtifactChecksumCheck{Progress: artifactProgressLogger(), Timeout: artifactTimeout}
+	if cfg.Install.ISOURL != "" {
+		check.Artifacts = append(check.Artifacts, Artifact{Location: cfg.Install.ISOURL, SHA256: cfg.Install.ISOChecksum})
+	}
+	if cfg.Install.RawDiskImagePath != "" {
+		check.Artifacts = append(check.Artifacts, Artifact{Location: cfg.Install.RawDiskImagePath, SHA256: cfg.Install.RawDiskImageChecksum})
+	}
+	return check
+}
+
+// artifactProgressLogger returns a Progress function which logs every 10%
+// of progress.
+func artifactProgressLogger() func(location string, done, total int64) {
+	logged := map[string]int64{}
+	return func(location string, done, total int64) {
+		if total <= 0 {
+			return
+		}
+		if tenths := done * 10 / total; tenths > logged[location] {
+			logged[location] = tenths
+			logrus.Infof("Verifying %s: %d%%", location, tenths*10)
+		}
+	}
+}
+
+const artifactChunkSize = 1 << 20
+
+func (c ArtifactChecksumCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "ArtifactChecksum"
+	var findings []string
+	verified := 0
+	for _, artifact := range c.Artifacts {
+		if u, err := url.Parse(artifact.Location); err == nil && env.Options.AirGapped &&
+			(u.Scheme == "http" || u.Scheme == "https") && onInternet(u.Hostname()) {
+			findings = append(findings, notContacted(u.Redacted()))
+			result.AirGapped = true
+			continue
+		}
+		verified++
+		severity, finding, err := c.verify(ctx, artifact)
+		if err != nil {
+			return result, err
+		}
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, finding)
+	}
+	result.Message = strings.Join(findings, " ")
+	if verified == 0 && result.AirGapped {
+		result.Message = "Skipped: " + result.Message
+	}
+	return
+}
+
+// verify checks a single artifact, returning the severity and a
+// description of the outcome.  Errors are only returned if the check was
+// interrupted.
+func (c ArtifactChecksumCheck) verify(ctx context.Context, artifact Artifact) (Severity, string, error) {
+	u, err := url.Parse(artifact.Location)
+	if err != nil {
+		return SeverityFatal, fmt.Sprintf("%s is not a valid location.", artifact.Location), nil
+	}
+	name := u.Redacted()
+	switch u.Scheme {
+	case "", "file":
+		return c.verifyFile(ctx, u.Path, artifact.SHA256)
+	case "http", "https":
+	default:
+		return SeverityInfo, fmt.Sprintf("%s cannot be verified in advance, because %s is not supported.", name, u.Scheme), nil
+	}
+
+	client := c.client()
+	expected := artifact.SHA256
+	if expected == "" {
+		if expected, err = fetchChecksum(ctx, client, u); err != nil {
+			if ctx.Err() != nil {
+				return SeverityOK, "", ctx.Err()
+			}
+			return SeverityFatal, fmt.Sprintf("Cannot download the checksum of %s: %v.", name, redactedError(err)), nil
+		}
+	}
+
+	// The download is given up on if it stalls for the timeout
+	downloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stall := time.AfterFunc(c.timeout(), cancel)
+	defer stall.Stop()
+	req, err := http.NewRequestWithContext(downloadCtx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return SeverityFatal, fmt.Sprintf("Cannot download %s: %v.", name, redactedError(err)), nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return SeverityOK, "", ctx.Err()
+		case downloadCtx.Err() != nil || isTimeout(err):
+			return SeverityFatal, fmt.Sprintf("Cannot download %s: the server did not answer within %s.", name, c.timeout()), nil
+		}
+		return SeverityFatal, fmt.Sprintf("Cannot download %s: %v.", name, redactedError(err)), nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return SeverityFatal, fmt.Sprintf("Cannot download %s: the server returned %s.", name, resp.Status), nil
+	}
+
+	h := sha256.New()
+	progress := func(string, int64, int64) { stall.Reset(c.timeout()) }
+	if c.Progress != nil {
+		progress = func(location string, done, total int64) {
+			stall.Reset(c.timeout())
+			c.Progress(location, done, total)
+		}
+	}
+	size, err := copyWithProgress(downloadCtx, h, resp.Body, name, resp.ContentLength, progress)
+	switch {
+	case ctx.Err() != nil:
+		return SeverityOK, "", ctx.Err()
+	case downloadCtx.Err() != nil:
+		return SeverityFatal, fmt.Sprintf("Cannot download %s: nothing was received for %s, after %d bytes.", name, c.timeout(), size), nil
+	case errors.Is(err, io.ErrUnexpectedEOF) || (err == nil && resp.ContentLength >= 0 && size < resp.ContentLength):
+		if resp.ContentLength < 0 {
+			return SeverityFatal, fmt.Sprintf("%s is truncated: the download stopped after %d bytes.", name, size), nil
+		}
+		return SeverityFatal, fmt.Sprintf("%s is truncated: only %d of %d bytes were downloaded.", name, size, resp.ContentLength), nil
+	case err != nil:
+		return SeverityFatal, fmt.Sprintf("Cannot download %s: %v.", name, redactedError(err)), nil
+	}
+	severity, finding := compareDigest(name, expected, hex.EncodeToString(h.Sum(nil)))
+	return severity, finding, nil
+}
+
+// timeout returns the check's Timeout, or the default.
+func (c ArtifactChecksumCheck) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return artifactTimeout
+}
+
+// client returns the HTTP client artifacts are downloaded with, which
+// gives up on servers which don't connect or answer within the timeout.
+// The body may take longer, so stalls are up to the caller.
+func (c ArtifactChecksumCheck) client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: c.timeout()}).DialContext,
+			TLSHandshakeTimeout:   c.timeout(),
+			ResponseHeaderTimeout: c.timeout(),
+		},
+	}
+}
+
+// verifyFile checks a local artifact.
+func (c ArtifactChecksumCheck) verifyFile(ctx context.Context, path, expected string) (Severity, string, error) {
+	if expected == "" {
+		data, err := os.ReadFile(path + ".sha256")
+		if err == nil {
+			expected = parseChecksumFile(data, filepath.Base(path))
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return SeverityFatal, fmt.Sprintf("Cannot read the checksum of %s: %v.", path, err), nil
+		}
+	}
+	digest, err := c.hashFile(ctx, path, path)
+	if ctx.Err() != nil {
+		return SeverityOK, "", ctx.Err()
+	} else if err != nil {
+		return SeverityFatal, fmt.Sprintf("Cannot read %s: %v.", path, err), nil
+	}
+	severity, finding := compareDigest(path, expected, digest)
+	return severity, finding, nil
+}
+
+// hashFile returns the SHA256 digest of a file.
+func (c ArtifactChecksumCheck) hashFile(ctx context.Context, path, name string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	total := int64(-1)
+	if info, err := f.Stat(); err == nil {
+		total = info.Size()
+	}
+	h := sha256.New()
+	if _, err := copyWithProgress(ctx, h, f, name, total, c.Progress); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyWithProgress is io.Copy, except that it reports progress, if
+// progress isn't nil, and stops if ctx is cancelled.
+func copyWithProgress(ctx context.Context, dst io.Writer, src io.Reader, name string, total int64, progress func(location string, done, total int64)) (int64, error) {
+	buf := make([]byte, artifactChunkSize)
+	var done int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return done, err
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return done, err
+			}
+			done += int64(n)
+			if progress != nil {
+				progress(name, done, total)
+			}
+		}
+		if err == io.EOF {
+			return done, nil
+		} else if err != nil {
+			return done, err
+		}
+	}
+}
+
+// compareDigest describes how digest compares to the expected one.
+func compareDigest(name, expected, digest string) (Severity, string) {
+	switch {
+	case expected == "":
+		return SeverityInfo, fmt.Sprintf("%s has no checksum to verify against. Its SHA256 is %s.", name, digest)
+	case !strings.EqualFold(expected, digest):
+		return SeverityFatal, fmt.Sprintf("%s is corrupt: its SHA256 should be %s, but it is %s.", name, strings.ToLower(expected), digest)
+	}
+	return SeverityOK, fmt.Sprintf("%s verified.", name)
+}
+
+// fetchChecksum downloads the checksum file which accompanies u with
+// client, and returns the digest for it, or "" if there isn't a checksum
+// file.
+func fetchChecksum(ctx context.Context, client *http.Client, u *url.URL) (string, error) {
+	sum := *u
+	sum.Path += ".sha256"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sum.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	} else if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the server returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return "", err
+	}
+	return parseChecksumFile(data, path.Base(u.Path)), nil
+}
+
+// parseChecksumFile returns the digest for name from the output of
+// sha256sum, or the digest in a file which contains only that.
+func parseChecksumFile(data []byte, name string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !sha256Regexp.MatchString(fields[0]) {
+			continue
+		}
+		if len(fields) == 1 || strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0]
+		}
+	}
+	return ""
+}
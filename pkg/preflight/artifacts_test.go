@@ -0,0 +1,172 @@
+package preflight
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	artifactSHA256        = "953ebbb80c24a2c3178a76409c01ac7f1f0706cfd4056d14665139f395499227"
+	corruptArtifactSHA256 = "b5603b1ff9a14aad9354992ba0f3fa200aa9bcffdba5873148e56e5b371246f4"
+)
+
+func TestNewArtifactChecksumCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.ISOURL = "http://pxe/saftos.iso"
+	cfg.Install.ISOChecksum = artifactSHA256
+	cfg.Install.RawDiskImagePath = "/run/saftos.raw"
+	check := NewArtifactChecksumCheck(cfg)
+	assert.Equal(t, []Artifact{
+		{Location: "http://pxe/saftos.iso", SHA256: artifactSHA256},
+		{Location: "/run/saftos.raw"},
+	}, check.Artifacts)
+	assert.Equal(t, artifactTimeout, check.Timeout)
+}
+
+func TestArtifactChecksumCheck(t *testing.T) {
+	files := http.FileServer(http.Dir("./testdata/artifacts"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/truncated.iso":
+			// Promise the whole file, but only send some of it
+			data, _ := os.ReadFile("./testdata/artifacts/saftos.iso")
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write(data[:1000])
+			return
+		case "/stalled.iso":
+			// Send some of the file, and then nothing more
+			data, _ := os.ReadFile("./testdata/artifacts/saftos.iso")
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write(data[:1000])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		artifact Artifact
+		severity Severity
+		message  string
+		// Whether hashing the whole artifact was reported
+		hashed bool
+	}{
+		{
+			name:     "local",
+			artifact: Artifact{Location: "./testdata/artifacts/saftos.iso"},
+			message:  "./testdata/artifacts/saftos.iso verified.",
+		},
+		{
+			name:     "local corrupt",
+			artifact: Artifact{Location: "./testdata/artifacts/corrupt.iso"},
+			severity: SeverityFatal,
+			message: "./testdata/artifacts/corrupt.iso is corrupt: its SHA256 should be " + artifactSHA256 +
+				", but it is " + corruptArtifactSHA256 + ".",
+		},
+		{
+			name:     "local with digest from config",
+			artifact: Artifact{Location: "file://" + mustAbs(t, "./testdata/artifacts/corrupt.iso"), SHA256: corruptArtifactSHA256},
+			message:  mustAbs(t, "./testdata/artifacts/corrupt.iso") + " verified.",
+		},
+		{
+			name:     "local without checksum",
+			artifact: Artifact{Location: "./testdata/artifacts/nosum.img"},
+			severity: SeverityInfo,
+			message:  "./testdata/artifacts/nosum.img has no checksum to verify against. Its SHA256 is 933665107085fae154499e06ab9a9864a7ccde86435d811c5bd6738a66248aa3.",
+		},
+		{
+			name:     "local missing",
+			artifact: Artifact{Location: "./testdata/artifacts/missing.iso"},
+			severity: SeverityFatal,
+			message:  "Cannot read ./testdata/artifacts/missing.iso: open ./testdata/artifacts/missing.iso: no such file or directory.",
+		},
+		{
+			name:     "remote",
+			artifact: Artifact{Location: server.URL + "/saftos.iso"},
+			message:  server.URL + "/saftos.iso verified.",
+			hashed:   true,
+		},
+		{
+			name:     "remote corrupt",
+			artifact: Artifact{Location: server.URL + "/corrupt.iso", SHA256: artifactSHA256},
+			severity: SeverityFatal,
+			message: server.URL + "/corrupt.iso is corrupt: its SHA256 should be " + artifactSHA256 +
+				", but it is " + corruptArtifactSHA256 + ".",
+		},
+		{
+			name:     "remote truncated",
+			artifact: Artifact{Location: server.URL + "/truncated.iso", SHA256: artifactSHA256},
+			severity: SeverityFatal,
+			message:  server.URL + "/truncated.iso is truncated: only 1000 of 4096 bytes were downloaded.",
+		},
+		{
+			name:     "remote stalled",
+			artifact: Artifact{Location: server.URL + "/stalled.iso", SHA256: artifactSHA256},
+			severity: SeverityFatal,
+			message:  "Cannot download " + server.URL + "/stalled.iso: nothing was received for 200ms, after 1000 bytes.",
+		},
+		{
+			name:     "remote missing",
+			artifact: Artifact{Location: server.URL + "/missing.iso", SHA256: artifactSHA256},
+			severity: SeverityFatal,
+			message:  "Cannot download " + server.URL + "/missing.iso: the server returned 404 Not Found.",
+		},
+		{
+			name:     "tftp",
+			artifact: Artifact{Location: "tftp://pxe/saftos.iso"},
+			severity: SeverityInfo,
+			message:  "tftp://pxe/saftos.iso cannot be verified in advance, because tftp is not supported.",
+		},
+	}
+
+	for _, test := range tests {
+		var progress []int64
+		check := ArtifactChecksumCheck{
+			Artifacts: []Artifact{test.artifact},
+			Progress: func(_ string, done, total int64) {
+				progress = append(progress, done, total)
+			},
+			Timeout: 200 * time.Millisecond,
+		}
+		result, err := check.Evaluate(context.Background(), &Env{})
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "ArtifactChecksum", Severity: test.severity, Message: test.message}, result, test.name)
+		if test.hashed {
+			assert.Equal(t, []int64{4096, 4096}, progress[len(progress)-2:], test.name)
+		}
+	}
+}
+
+func TestArtifactChecksumCheckCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	check := ArtifactChecksumCheck{Artifacts: []Artifact{{Location: "./testdata/artifacts/saftos.iso"}}}
+	_, err := check.Evaluate(ctx, &Env{})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestParseChecksumFile(t *testing.T) {
+	sums := []byte(corruptArtifactSHA256 + "  corrupt.iso\n" + artifactSHA256 + " *saftos.iso\n")
+	assert.Equal(t, artifactSHA256, parseChecksumFile(sums, "saftos.iso"))
+	assert.Equal(t, "", parseChecksumFile(sums, "other.iso"))
+	assert.Equal(t, artifactSHA256, parseChecksumFile([]byte(artifactSHA256+"\n"), "saftos.iso"))
+}
+
+func mustAbs(t *testing.T, path string) string {
+	abs, err := filepath.Abs(path)
+	assert.Nil(t, err)
+	return abs
+}
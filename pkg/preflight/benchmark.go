@@ -0,0 +1,82 @@
+package preflight
+
+import (
+	"time"
+)
+
+// DefaultBenchmarkBudget is how long the benchmarks may take altogether,
+// unless Options.BenchmarkBudget says otherwise.  It's short enough that
+// an unattended install isn't held up for long.
+const DefaultBenchmarkBudget = 5 * time.Minute
+
+// A BenchmarkCheck is a ResultCheck which measures how the host performs
+// by loading it, e.g. its disks' fsync latency or IOPS, which takes a
+// while.  Benchmarks only run if Options.Benchmarks is set, and then
+// within Options.BenchmarkBudget, as the Runner allots it.  The context
+// a benchmark is evaluated with expires when its allotment does, which
+// is never before Estimate, so that it can scale its work to fit.
+type BenchmarkCheck interface {
+	ResultCheck
+	// Estimate returns how long the benchmark expects to take.
+	Estimate() time.Duration
+}
+
+// budgetExhausted is why benchmarks are skipped when they don't fit in
+// what's left of the time budget.
+const budgetExhausted = "time budget exhausted"
+
+// isBenchmark returns whether check is a BenchmarkCheck.
+func isBenchmark(check ResultCheck) bool {
+	_, ok := check.(BenchmarkCheck)
+	return ok
+}
+
+// benchmarkBudget returns the Options' BenchmarkBudget, or the default.
+func (o Options) benchmarkBudget() time.Duration {
+	if o.BenchmarkBudget > 0 {
+		return o.BenchmarkBudget
+	}
+	return DefaultBenchmarkBudget
+}
+
+// A timeBudget allots the time the benchmarks of a run may take between
+// them.  It starts when the first of them does.
+type timeBudget struct {
+	total    time.Duration
+	deadline time.Time
+	now      func() time.Time
+	// pending are the estimates of the benchmarks which haven't been
+	// allotted any time yet, in the order they run in.
+	pending []time.Duration
+}
+
+func newTimeBudget(total time.Duration, checks []ResultCheck, now func() time.Time) *timeBudget {
+	b := &timeBudget{total: total, now: now}
+	for _, check := range checks {
+		if benchmark, ok := check.(BenchmarkCheck); ok {
+			b.pending = append(b.pending, benchmark.Estimate())
+		}
+	}
+	return b
+}
+
+// allot returns how long the next benchmark may run for, or false if it
+// expects to take longer than what's left.  That's what's left less the
+// estimates of the benchmarks after it, so that one which overruns
+// doesn't crowd them out, but never less than its own estimate.
+func (b *timeBudget) allot() (time.Duration, bool) {
+	if b.deadline.IsZero() {
+		b.deadline = b.now().Add(b.total)
+	}
+	estimate := b.pending[0]
+	b.pending = b.pending[1:]
+	remaining := b.deadline.Sub(b.now())
+	if estimate > remaining {
+		return 0, false
+	}
+	var reserved time.Duration
+	for _, later := range b.pending {
+		reserved += later
+	}
+	return max(estimate, remaining-reserved), true
+}
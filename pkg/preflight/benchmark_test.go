@@ -0,0 +1,142 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBenchmark takes took, according to the faked clock, which it
+// advances, whatever it estimated.  If took is negative, it runs until
+// its allotment runs out, in real time.
+type fakeBenchmark struct {
+	name     string
+	estimate time.Duration
+	took     time.Duration
+	clock    *time.Time
+	ran      *[]string
+}
+
+func (c fakeBenchmark) Evaluate(ctx context.Context, _ *Env) (Result, error) {
+	*c.ran = append(*c.ran, c.name)
+	if c.took < 0 {
+		<-ctx.Done()
+		return Result{Name: c.name, Message: "Half done."}, ctx.Err()
+	}
+	*c.clock = c.clock.Add(c.took)
+	return Result{Name: c.name, Message: "Done."}, nil
+}
+
+func (c fakeBenchmark) Estimate() time.Duration {
+	return c.estimate
+}
+
+func TestTimeBudget(t *testing.T) {
+	clock := testRunTime
+	now := func() time.Time { return clock }
+
+	benchmark := func(estimate time.Duration) ResultCheck {
+		return fakeBenchmark{estimate: estimate}
+	}
+	budget := newTimeBudget(10*time.Minute, []ResultCheck{
+		benchmark(2 * time.Minute),
+		fakeCheck{},
+		benchmark(3 * time.Minute),
+		benchmark(4 * time.Minute),
+		benchmark(time.Minute),
+	}, now)
+	assert.Equal(t, []time.Duration{2 * time.Minute, 3 * time.Minute, 4 * time.Minute, time.Minute}, budget.pending)
+
+	// The clock starts with the first benchmark, which may have whatever
+	// the others don't expect to need
+	clock = clock.Add(time.Hour)
+	allotted, ok := budget.allot()
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, allotted)
+
+	// It overran, so the next gets no more than its estimate
+	clock = clock.Add(3 * time.Minute)
+	allotted, ok = budget.allot()
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Minute, allotted)
+
+	// The next doesn't fit in what's left, and the last gets the lot
+	clock = clock.Add(4 * time.Minute)
+	_, ok = budget.allot()
+	assert.False(t, ok)
+	allotted, ok = budget.allot()
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Minute, allotted)
+}
+
+func TestRunnerBenchmarks(t *testing.T) {
+	h := testHost()
+	clock := testRunTime
+	h.now = func() time.Time { return clock }
+
+	var ran []string
+	benchmark := func(name string, estimate, took time.Duration) ResultCheck {
+		return fakeBenchmark{name: name, estimate: estimate, took: took, clock: &clock, ran: &ran}
+	}
+	checks := []ResultCheck{
+		fakeCheck{result: Result{Name: "First"}},
+		benchmark("Fsync", 30*time.Second, 40*time.Second),
+		benchmark("IOPS", 2*time.Minute, 2*time.Minute),
+		benchmark("Throughput", 90*time.Second, time.Minute),
+		benchmark("Latency", 20*time.Second, 10*time.Second),
+		fakeCheck{result: Result{Name: "Last"}},
+	}
+
+	// Benchmarks don't run unless they're asked for
+	runner := Runner{Checks: checks, machine: h}
+	report := runner.Run(context.Background())
+	assert.Equal(t, []Result{{Name: "First"}, {Name: "Last"}}, report.Results)
+	assert.Empty(t, ran)
+
+	// Those which would overrun what's left are skipped, and the rest run
+	runner.Options = Options{Benchmarks: true, BenchmarkBudget: 4 * time.Minute}
+	report = runner.Run(context.Background())
+	assert.Equal(t, []string{"Fsync", "IOPS", "Latency"}, ran)
+	assert.Equal(t, []Result{
+		{Name: "First"},
+		{Name: "Fsync", Message: "Done.", Duration: 40 * time.Second},
+		{Name: "IOPS", Message: "Done.", Duration: 2 * time.Minute},
+		// As it never ran, it's named after its type
+		{Name: "fakeBenchmark", Message: "Skipped: time budget exhausted."},
+		{Name: "Latency", Message: "Done.", Duration: 10 * time.Second},
+		{Name: "Last"},
+	}, report.Results)
+	assert.False(t, report.Cancelled)
+
+	// The default budget fits them all
+	ran = nil
+	runner.Options = Options{Benchmarks: true}
+	report = runner.Run(context.Background())
+	assert.Equal(t, []string{"Fsync", "IOPS", "Throughput", "Latency"}, ran)
+}
+
+func TestRunnerBenchmarkOverrun(t *testing.T) {
+	var ran []string
+	clock := time.Now()
+	runner := Runner{
+		Checks: []ResultCheck{
+			fakeBenchmark{name: "Endless", estimate: 20 * time.Millisecond, took: -1, clock: &clock, ran: &ran},
+			fakeCheck{result: Result{Name: "Last"}},
+		},
+		Options: Options{Benchmarks: true, BenchmarkBudget: 50 * time.Millisecond},
+	}
+
+	// A benchmark which runs on is stopped when its allotment runs out,
+	// without stopping the run
+	report := runner.Run(context.Background())
+	assert.Equal(t, []string{"Endless"}, ran)
+	assert.Len(t, report.Results, 2)
+	assert.Equal(t, "Endless", report.Results[0].Name)
+	assert.Equal(t, "Skipped: time budget exhausted.", report.Results[0].Message)
+	assert.GreaterOrEqual(t, report.Results[0].Duration, 50*time.Millisecond)
+	assert.Equal(t, "Last", report.Results[1].Name)
+	assert.Empty(t, report.Results[1].Message)
+	assert.False(t, report.Cancelled)
+}
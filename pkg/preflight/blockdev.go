@@ -0,0 +1,158 @@
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A blockDevice is an entry in /sys/block.  DMName is only set for
+// device mapper devices, and Slaves lists the devices underlying a
+// device mapper or md device.
+type blockDevice struct {
+	Name       string
+	SizeBytes  uint64
+	Model      string
+	Partitions []string
+	DMName     string
+	Slaves     []string
+}
+
+// listBlockDevices returns an inventory of the block devices in sysfs.
+func (e *Env) listBlockDevices() ([]blockDevice, error) {
+	entries, err := e.readDir(e.host().sysBlock)
+	if err != nil {
+		return nil, err
+	}
+	devs := make([]blockDevice, 0, len(entries))
+	for _, entry := range entries {
+		dev := blockDevice{Name: entry.Name()}
+		if dev.Partitions, err = e.partitions(dev.Name); err != nil {
+			return nil, err
+		}
+		// size is always in 512 byte sectors
+		if size, err := e.readSysfsUint(filepath.Join(e.host().sysBlock, dev.Name, "size")); err == nil {
+			dev.SizeBytes = size * 512
+		}
+		if out, err := e.readFile(filepath.Join(e.host().sysBlock, dev.Name, "device", "model")); err == nil {
+			dev.Model = strings.TrimSpace(string(out))
+		}
+		if out, err := e.readFile(filepath.Join(e.host().sysBlock, dev.Name, "dm", "name")); err == nil {
+			dev.DMName = strings.TrimSpace(string(out))
+		}
+		if slaves, err := e.readDir(filepath.Join(e.host().sysBlock, dev.Name, "slaves")); err == nil {
+			for _, slave := range slaves {
+				dev.Slaves = append(dev.Slaves, slave.Name())
+			}
+		}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// isDisk returns true if the device looks like a real disk, as opposed to
+// a loop device, ramdisk, optical drive or device mapper/md device.
+func (d blockDevice) isDisk() bool {
+	for _, prefix := range []string{"loop", "ram", "zram", "sr", "fd", "dm-", "md", "nbd"} {
+		if strings.HasPrefix(d.Name, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// diskOf returns the name of the disk that dev is, or is a partition of,
+// or an empty string if dev isn't found.
+func diskOf(devs []blockDevice, dev string) string {
+	for _, d := range devs {
+		if d.Name == dev {
+			return d.Name
+		}
+		for _, part := range d.Partitions {
+			if part == dev {
+				return d.Name
+			}
+		}
+	}
+	return ""
+}
+
+// resolveDisk returns the name of the disk that dev is on, if it's a
+// partition, e.g. sda for sda2 or nvme0n1 for nvme0n1p2, or dev.
+func (e *Env) resolveDisk(dev string) (string, error) {
+	if _, err := e.stat(filepath.Join(e.host().sysBlock, dev)); !errors.Is(err, fs.ErrNotExist) {
+		return dev, nil
+	}
+	devs, err := e.listBlockDevices()
+	if err != nil {
+		return "", err
+	}
+	if disk := diskOf(devs, dev); disk != "" {
+		return disk, nil
+	}
+	return dev, nil
+}
+
+// partitions returns the names of the partitions of dev, in the order
+// sysfs lists them.
+func (e *Env) partitions(dev string) ([]string, error) {
+	entries, err := e.readDir(filepath.Join(e.host().sysBlock, dev))
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, entry := range entries {
+		if _, err := e.stat(filepath.Join(e.host().sysBlock, dev, entry.Name(), "partition")); err == nil {
+			parts = append(parts, entry.Name())
+		}
+	}
+	return parts, nil
+}
+
+// errInvalidBlockDeviceName is returned, wrapped, for a block device name
+// which can't be that of an entry in /sys/block.
+var errInvalidBlockDeviceName = errors.New("invalid block device name")
+
+// blockDevPath returns the path of file in the sysfs directory of the
+// block device dev, e.g. /sys/block/nvme0n1/queue/rotational.  Like
+// netDevPath, it's for names which may come from the install
+// configuration, so dev has to be a single path component.
+func (e *Env) blockDevPath(dev, file string) (string, error) {
+	reason := ""
+	switch {
+	case dev == "":
+		reason = "it is empty"
+	case dev == "." || dev == "..":
+		reason = "it is a directory"
+	case strings.ContainsAny(dev, "/\x00"):
+		reason = "it contains a slash or NUL"
+	default:
+		return filepath.Join(e.host().sysBlock, dev, file), nil
+	}
+	return "", fmt.Errorf("%w %q: %s", errInvalidBlockDeviceName, dev, reason)
+}
+
+// readSysfsUint returns the number in the sysfs attribute at path.
+func (e *Env) readSysfsUint(path string) (uint64, error) {
+	out, err := e.readFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return value, nil
+}
+
+func containsDev(devs []string, dev string) bool {
+	for _, d := range devs {
+		if strings.TrimPrefix(d, "/dev/") == dev {
+			return true
+		}
+	}
+	return false
+}
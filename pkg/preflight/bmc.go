@@ -0,0 +1,159 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ipmiLANChannels are the channels the BMC's LAN interface is usually
+// on.  Most vendors use 1, but some use 2 or 8.
+var ipmiLANChannels = []int{1, 2, 8}
+
+// BMC describes the host's baseboard management controller.
+type BMC struct {
+	// Interface is how we found the BMC, "ipmi" or "redfish"
+	Interface       string
+	FirmwareVersion string
+	IPAddress       string
+	IPSource        string
+}
+
+// BMCCheck looks for a BMC, and if it's accessible via IPMI, whether its
+// LAN channel has been configured with a routable address.  Finding out
+// the LAN was never set up after the host's been racked is expensive,
+// so this warns in production mode if there's no BMC or it's not
+// configured.  In testing mode the result is informational.
+type BMCCheck struct{}
+
+func (c BMCCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "BMC"
+
+	ipmi, err := hasIPMIDevice(env)
+	if err != nil {
+		return
+	}
+
+	if !ipmi {
+		// The BMC may only offer Redfish (via the host interface in
+		// DMI type 42), or its IPMI driver may not be loaded (in which
+		// case it should still show up in DMI type 38).  Without SMBIOS,
+		// we just can't tell.
+		var redfish, ipmiDMI []dmiRecord
+		if redfish, err = env.dmi(42); err != nil && !errors.Is(err, errNoSMBIOS) {
+			return
+		}
+		if ipmiDMI, err = env.dmi(38); err != nil && !errors.Is(err, errNoSMBIOS) {
+			return
+		}
+		err = nil
+		switch {
+		case len(ipmiDMI) > 0:
+			env.Inventory.BMC = &BMC{Interface: "ipmi"}
+			result.Message = "BMC detected, but the IPMI driver is not loaded, so its LAN configuration could not be checked."
+		case len(redfish) > 0:
+			env.Inventory.BMC = &BMC{Interface: "redfish"}
+			result.Message = "BMC detected via its Redfish host interface, but its LAN configuration could not be checked without IPMI."
+		default:
+			result.Message = "No BMC detected."
+			if env.Options.Production {
+				result.Severity = SeverityWarning
+				result.Message += " Out-of-band management is strongly recommended for production use."
+			}
+		}
+		return
+	}
+
+	bmc := &BMC{Interface: "ipmi"}
+	env.Inventory.BMC = bmc
+	desc := "BMC"
+	if out, err := env.output("/usr/bin/ipmitool", "mc", "info"); err == nil {
+		bmc.FirmwareVersion = parseIPMIToolFields(string(out))["Firmware Revision"]
+		if bmc.FirmwareVersion != "" {
+			desc += fmt.Sprintf(" (firmware %s)", bmc.FirmwareVersion)
+		}
+	}
+
+	var queried bool
+	for _, channel := range ipmiLANChannels {
+		out, err := env.output("/usr/bin/ipmitool", "lan", "print", strconv.Itoa(channel))
+		if err != nil {
+			continue
+		}
+		fields := parseIPMIToolFields(string(out))
+		if _, ok := fields["IP Address"]; !ok {
+			continue
+		}
+		queried = true
+		if ip := net.ParseIP(fields["IP Address"]); isRoutable(ip) {
+			bmc.IPAddress = ip.String()
+			bmc.IPSource = fields["IP Address Source"]
+			break
+		}
+	}
+
+	switch {
+	case !queried:
+		result.Message = desc + " detected, but its LAN configuration could not be queried."
+	case bmc.IPAddress == "":
+		result.Message = desc + " detected, but it has no routable LAN address configured."
+		if env.Options.Production {
+			result.Severity = SeverityWarning
+			result.Message += " Please configure the BMC LAN channel for out-of-band management."
+		}
+	default:
+		result.Message = fmt.Sprintf("%s detected, with LAN address %s", desc, bmc.IPAddress)
+		if bmc.IPSource != "" {
+			result.Message += fmt.Sprintf(" (%s)", strings.ToLower(bmc.IPSource))
+		}
+		result.Message += "."
+	}
+	return
+}
+
+// hasIPMIDevice returns true if there's an IPMI device node, or the
+// kernel has found an IPMI system interface (via ACPI, or otherwise),
+// even if its driver isn't loaded.
+func hasIPMIDevice(env *Env) (bool, error) {
+	if _, err := env.stat(filepath.Join(env.host().devDir, "ipmi0")); err == nil {
+		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	entries, err := env.readDir(env.host().sysBusPlatformDevices)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "IPI0001:") || strings.HasPrefix(entry.Name(), "ipmi_si") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parseIPMIToolFields parses the "Name : Value" lines ipmitool prints.
+// Continuation lines (which have no name) are ignored.
+func parseIPMIToolFields(out string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+// isRoutable returns true if ip could be reached from another network.
+func isRoutable(ip net.IP) bool {
+	return ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
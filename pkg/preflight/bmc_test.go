@@ -0,0 +1,113 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBMCCheck(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+
+	tests := []struct {
+		name       string
+		fixture    string
+		lan        string
+		dmidecode  string
+		production bool
+		bmc        *BMC
+		severity   Severity
+		message    string
+	}{
+		{
+			name:       "configured",
+			fixture:    "ipmi",
+			lan:        "ipmitool-lan-static",
+			production: true,
+			bmc:        &BMC{Interface: "ipmi", FirmwareVersion: "2.80", IPAddress: "10.20.30.40", IPSource: "Static Address"},
+			message:    "BMC (firmware 2.80) detected, with LAN address 10.20.30.40 (static address).",
+		},
+		{
+			name:    "unconfigured testing",
+			fixture: "ipmi",
+			lan:     "ipmitool-lan-unset",
+			bmc:     &BMC{Interface: "ipmi", FirmwareVersion: "2.80"},
+			message: "BMC (firmware 2.80) detected, but it has no routable LAN address configured.",
+		},
+		{
+			name:       "unconfigured production",
+			fixture:    "ipmi",
+			lan:        "ipmitool-lan-unset",
+			production: true,
+			bmc:        &BMC{Interface: "ipmi", FirmwareVersion: "2.80"},
+			severity:   SeverityWarning,
+			message: "BMC (firmware 2.80) detected, but it has no routable LAN address configured. " +
+				"Please configure the BMC LAN channel for out-of-band management.",
+		},
+		{
+			name:       "no ipmitool",
+			fixture:    "acpi",
+			lan:        "ipmitool-fail",
+			production: true,
+			bmc:        &BMC{Interface: "ipmi"},
+			message:    "BMC detected, but its LAN configuration could not be queried.",
+		},
+		{
+			name:       "redfish only",
+			fixture:    "none",
+			dmidecode:  "dmidecode-redfish",
+			production: true,
+			bmc:        &BMC{Interface: "redfish"},
+			message:    "BMC detected via its Redfish host interface, but its LAN configuration could not be checked without IPMI.",
+		},
+		{
+			name:      "none testing",
+			fixture:   "none",
+			dmidecode: "dmidecode-bios",
+			message:   "No BMC detected.",
+		},
+		{
+			name:       "none production",
+			fixture:    "none",
+			dmidecode:  "dmidecode-bios",
+			production: true,
+			severity:   SeverityWarning,
+			message:    "No BMC detected. Out-of-band management is strongly recommended for production use.",
+		},
+	}
+
+	for _, test := range tests {
+		h.devDir = "./testdata/bmc/" + test.fixture + "/dev"
+		h.sysBusPlatformDevices = "./testdata/bmc/" + test.fixture + "/sys/bus/platform/devices"
+		command := func(name string, args ...string) *exec.Cmd {
+			switch {
+			case strings.HasSuffix(name, "dmidecode"):
+				return fakeExecCommand(test.dmidecode)
+			case strings.Join(args, " ") == "mc info" && test.lan != "ipmitool-fail":
+				return fakeExecCommand("ipmitool-mc-info")
+			case strings.Join(args, " ") == "lan print 1":
+				return fakeExecCommand(test.lan)
+			}
+			return fakeExecCommand("ipmitool-fail")
+		}
+		env := &Env{Options: Options{Production: test.production}, execCommand: command, machine: h}
+		result, err := BMCCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "BMC", Severity: test.severity, Message: test.message}, result, test.name)
+		assert.Equal(t, test.bmc, env.Inventory.BMC, test.name)
+	}
+}
+
+func TestBMCCheckNoSMBIOS(t *testing.T) {
+	h := testHost()
+	h.devDir = "./testdata/bmc/none/dev"
+	h.sysBusPlatformDevices = "./testdata/bmc/none/sys/bus/platform/devices"
+	h.sysFirmwareDMITables = "./testdata/dmi/none"
+	result, err := BMCCheck{}.Evaluate(context.Background(), &Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "BMC", Message: "No BMC detected."}, result)
+}
@@ -0,0 +1,133 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// bondModes are the modes the bonding driver supports.
+var bondModes = []string{
+	config.BondModeBalanceRR,
+	config.BondModeActiveBackup,
+	config.BondModeBalnaceXOR,
+	config.BondModeBroadcast,
+	config.BondModeIEEE802_3ad,
+	config.BondModeBalanceTLB,
+	config.BondModeBalanceALB,
+}
+
+// aggregatingBondModes are the bond modes which spread traffic across
+// the members as one link, and so need the switch to treat them as one.
+var aggregatingBondModes = []string{
+	config.BondModeBalanceRR,
+	config.BondModeBalnaceXOR,
+	config.BondModeIEEE802_3ad,
+}
+
+// BondModeCheck verifies that the management bond's mode suits its
+// members, since a mode they can't go along with gives a bond that mostly
+// works until it doesn't.  An invalid mode is fatal.  Everything else is
+// advisory: members of aggregating modes should have the same speed and
+// be full duplex.  Whether the switch is set up to match can't be known
+// from the host, as nothing in the installer collects LLDP data.
+type BondModeCheck struct {
+	// Members are interface names or MAC addresses.
+	Members  []string
+	BondMode string
+}
+
+// NewBondModeCheck returns a BondModeCheck for the management bond in the
+// given install configuration.
+func NewBondModeCheck(cfg *config.HarvesterConfig) BondModeCheck {
+	check := BondModeCheck{BondMode: cfg.Install.ManagementInterface.BondOptions["mode"]}
+	for _, iface := range cfg.Install.ManagementInterface.Interfaces {
+		member := iface.Name
+		if member == "" {
+			member = iface.HwAddr
+		}
+		check.Members = append(check.Members, member)
+	}
+	return check
+}
+
+// bondMode returns the bond mode the installer uses for mode.
+func bondMode(mode string) string {
+	if mode == "" {
+		return config.BondModeBalanceTLB
+	}
+	return mode
+}
+
+const bondModePath = topologyPath + ".bond_options.mode"
+
+func (c BondModeCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "BondMode"
+	if len(c.Members) == 0 {
+		return
+	}
+	mode := bondMode(c.BondMode)
+	if !slices.Contains(bondModes, mode) {
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("%s: %s is not a bonding mode. Valid modes are %s.", bondModePath, mode, strings.Join(bondModes, ", "))
+		return
+	}
+	if env.Inventory.NICs == nil {
+		if env.Inventory.NICs, err = env.listNICs(); err != nil {
+			return
+		}
+	}
+
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf("%s: %s", bondModePath, fmt.Sprintf(format, args...)))
+	}
+
+	// Members which don't exist are NetworkTopologyCheck's business
+	var members []NIC
+	for _, member := range c.Members {
+		index := slices.IndexFunc(env.Inventory.NICs, func(nic NIC) bool {
+			return nic.Name == member || strings.EqualFold(nic.HwAddr, member)
+		})
+		if index >= 0 && !slices.ContainsFunc(members, func(nic NIC) bool { return nic.Name == env.Inventory.NICs[index].Name }) {
+			members = append(members, env.Inventory.NICs[index])
+		}
+	}
+
+	if slices.Contains(aggregatingBondModes, mode) {
+		var speeds []string
+		for _, nic := range members {
+			if nic.SpeedMbps > 0 && nic.SpeedMbps != members[0].SpeedMbps {
+				for _, nic := range members {
+					speeds = append(speeds, fmt.Sprintf("%s is %gGbps", nic.Name, float32(nic.SpeedMbps)/1000))
+				}
+				break
+			}
+		}
+		if len(speeds) > 0 {
+			finding(SeverityWarning, "%s only aggregates links of the same speed, but %s.", mode, strings.Join(speeds, ", "))
+		}
+		var halfDuplex []string
+		for _, nic := range members {
+			if nic.Duplex == "half" {
+				halfDuplex = append(halfDuplex, nic.Name)
+			}
+		}
+		if len(halfDuplex) == 1 {
+			finding(SeverityWarning, "%s needs full-duplex links, but %s is half duplex.", mode, halfDuplex[0])
+		} else if len(halfDuplex) > 1 {
+			finding(SeverityWarning, "%s needs full-duplex links, but %s are half duplex.", mode, strings.Join(halfDuplex, ", "))
+		}
+	}
+
+	if len(findings) > 0 {
+		result.Message = strings.Join(findings, " ")
+		return
+	}
+	result.Message = fmt.Sprintf("%s mode suits the members of the management bond.", mode)
+	return
+}
@@ -0,0 +1,80 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewBondModeCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.ManagementInterface = config.Network{
+		Interfaces:  []config.NetworkInterface{{Name: "eno1"}, {HwAddr: "3c:ec:ef:12:34:57"}},
+		BondOptions: map[string]string{"mode": "802.3ad", "miimon": "100"},
+	}
+	assert.Equal(t, BondModeCheck{
+		Members:  []string{"eno1", "3c:ec:ef:12:34:57"},
+		BondMode: "802.3ad",
+	}, NewBondModeCheck(cfg))
+}
+
+func TestBondModeCheck(t *testing.T) {
+	nics := []NIC{
+		{Name: "eno1", HwAddr: "3c:ec:ef:12:34:56", SpeedMbps: 10000, Duplex: "full"},
+		{Name: "eno2", HwAddr: "3c:ec:ef:12:34:57", SpeedMbps: 10000, Duplex: "full"},
+		{Name: "eno3", HwAddr: "3c:ec:ef:12:34:58", SpeedMbps: 1000, Duplex: "full"},
+		{Name: "eno4", HwAddr: "3c:ec:ef:12:34:59", SpeedMbps: 10000, Duplex: "half"},
+	}
+	tests := []struct {
+		name     string
+		check    BondModeCheck
+		severity Severity
+		message  string
+	}{
+		{
+			name: "nothing configured",
+		},
+		{
+			name:     "invalid mode",
+			check:    BondModeCheck{Members: []string{"eno1", "eno2"}, BondMode: "lacp"},
+			severity: SeverityFatal,
+			message: "install.management_interface.bond_options.mode: lacp is not a bonding mode. " +
+				"Valid modes are balance-rr, active-backup, balance-xor, broadcast, 802.3ad, balance-tlb, balance-alb.",
+		},
+		{
+			name:    "default mode",
+			check:   BondModeCheck{Members: []string{"eno1", "eno3"}},
+			message: "balance-tlb mode suits the members of the management bond.",
+		},
+		{
+			name:    "802.3ad",
+			check:   BondModeCheck{Members: []string{"eno1", "3C:EC:EF:12:34:57"}, BondMode: "802.3ad"},
+			message: "802.3ad mode suits the members of the management bond.",
+		},
+		{
+			name:     "802.3ad with mismatched members",
+			check:    BondModeCheck{Members: []string{"eno1", "eno2", "eno3", "eno4"}, BondMode: "802.3ad"},
+			severity: SeverityWarning,
+			message: "install.management_interface.bond_options.mode: 802.3ad only aggregates links of the same speed, " +
+				"but eno1 is 10Gbps, eno2 is 10Gbps, eno3 is 1Gbps, eno4 is 10Gbps. " +
+				"install.management_interface.bond_options.mode: 802.3ad needs full-duplex links, but eno4 is half duplex.",
+		},
+		{
+			// Speeds don't matter when only one member is used at a time
+			name:    "active-backup",
+			check:   BondModeCheck{Members: []string{"eno1", "eno3"}, BondMode: "active-backup"},
+			message: "active-backup mode suits the members of the management bond.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &Env{Inventory: Inventory{NICs: nics}}
+			result, err := tt.check.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "BondMode", Severity: tt.severity, Message: tt.message}, result)
+		})
+	}
+}
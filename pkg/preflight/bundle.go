@@ -0,0 +1,317 @@
+package preflight
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxBundleSize is the most bytes a support bundle holds,
+	// before compression, unless BundleOptions.MaxBytes says otherwise.
+	// It has to be copied off the host somehow, possibly over a serial
+	// console.
+	DefaultMaxBundleSize = 32 << 20
+
+	// bundleManifestName is the name of the manifest in a support bundle,
+	// which is its first file.
+	bundleManifestName = "manifest.json"
+)
+
+// bundleFiles are the files, under the host's root, which support bundles
+// include, as far as there's room for them.  They're put at the same
+// paths in the bundle, and the tools' output in dumps, named by
+// dumpName, so that an unpacked bundle can be replayed like the captured
+// machines in testdata/machines.
+var bundleFiles = []string{
+	"proc/cmdline",
+	"proc/meminfo",
+	"proc/cpuinfo",
+	"proc/modules",
+	"proc/sys/kernel/osrelease",
+	"proc/sys/kernel/arch",
+	"sys/devices/system/cpu/present",
+	"sys/devices/system/cpu/online",
+	"etc/os-release",
+	"etc/resolv.conf",
+}
+
+// bundleNetFiles are the files of each network interface, under
+// sys/class/net, which support bundles include.
+var bundleNetFiles = []string{"address", "operstate", "mtu", "speed", "duplex"}
+
+// bundleLogs are the logs, under the host's root, which support bundles include.
+// Only the end of a log is kept, if it doesn't fit.
+var bundleLogs = []string{"var/log/console.log"}
+
+// bundleTools are the external tools whose output support bundles
+// include.
+var bundleTools = []toolCall{
+	{"/usr/sbin/dmidecode"},
+	{"/usr/sbin/ip", "-details", "address", "show"},
+	{"/usr/sbin/ip", "route", "show", "table", "all"},
+}
+
+// BundleOptions are the settings for collecting a support bundle.
+type BundleOptions struct {
+	// ReportPath is where the report of the run is persisted, if not
+	// DefaultReportPath.
+	ReportPath string
+	// MaxBytes is the most the bundle may hold, before compression, if
+	// not DefaultMaxBundleSize.
+	MaxBytes int64
+	// Secrets are masked wherever they turn up, such as the cluster token
+	// and node password, even if they don't look like credentials.
+	Secrets []string
+	// ExecCommand makes the commands for the tools whose output is
+	// bundled, or is exec.Command if nil.
+	ExecCommand func(name string, args ...string) *exec.Cmd
+
+	// machine is what the bundle is of, or the live host if nil.
+	machine *host
+}
+
+// host returns the machine the bundle is of.
+func (o BundleOptions) host() *host {
+	if o.machine == nil {
+		return &liveHost
+	}
+	return o.machine
+}
+
+// A BundleManifest lists what a support bundle holds.  It's the first
+// file in the bundle.
+type BundleManifest struct {
+	Created time.Time     `json:"created"`
+	Entries []BundleEntry `json:"entries"`
+}
+
+// A BundleEntry is a file in a support bundle, or one which should have
+// been, in which case Error says why it isn't.  Source is the path it
+// was read from, or the command line it's the output of.  Size and
+// SHA256 are of the file as it is in the bundle, after anything which
+// looked like a credential was masked, when Redacted is set, and it was
+// cut to fit, when Truncated is.
+type BundleEntry struct {
+	Name      string `json:"name"`
+	Source    string `json:"source"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256,omitempty"`
+	Redacted  bool   `json:"redacted,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// A supportBundle is a support bundle being collected.
+type supportBundle struct {
+	opts     BundleOptions
+	room     int64
+	manifest BundleManifest
+	contents [][]byte
+}
+
+// CollectSupportBundle writes a gzip'd tarball to path with what support
+// needs to see of a preflight run: the persisted report, split into the
+// results and inventory, and the evidence the checks captured, if any,
+// followed by what the host says about itself, and the end of the
+// installer's logs.  Anything which looks like a credential is masked,
+// as it is in evidence.  Sources which are missing, or which don't fit in
+// what's left of opts.MaxBytes, are left out, and the manifest says so,
+// so the bundle is still written.
+func CollectSupportBundle(path string, opts BundleOptions) (*BundleManifest, error) {
+	if opts.ReportPath == "" {
+		opts.ReportPath = DefaultReportPath
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBundleSize
+	}
+	b := &supportBundle{opts: opts, room: opts.MaxBytes, manifest: BundleManifest{Created: opts.host().now().UTC()}}
+
+	b.addReport()
+	for _, name := range bundleFiles {
+		b.addFile(name, false)
+	}
+	root := opts.host().root
+	nics, _ := filepath.Glob(filepath.Join(root, "sys/class/net/*"))
+	for _, nic := range nics {
+		for _, file := range bundleNetFiles {
+			rel, _ := filepath.Rel(root, filepath.Join(nic, file))
+			if _, err := os.Stat(filepath.Join(root, rel)); err == nil {
+				b.addFile(rel, false)
+			}
+		}
+	}
+	for _, call := range bundleTools {
+		b.addTool(call)
+	}
+	for _, name := range bundleLogs {
+		b.addFile(name, true)
+	}
+
+	if err := b.write(path); err != nil {
+		return nil, err
+	}
+	return &b.manifest, nil
+}
+
+// add adds a file to the bundle, masking credentials, and cutting it to
+// fit, from its end if keepTail is set.
+func (b *supportBundle) add(name, source string, data []byte, keepTail bool) {
+	if b.room == 0 && len(data) > 0 {
+		b.missing(name, source, errors.New("no room left in the bundle"))
+		return
+	}
+	entry := BundleEntry{Name: name, Source: source}
+	text, redacted := redact(string(data), b.opts.Secrets)
+	entry.Redacted = redacted
+	if int64(len(text)) > b.room {
+		entry.Truncated = true
+		if keepTail {
+			text = text[int64(len(text))-b.room:]
+		} else {
+			text = text[:b.room]
+		}
+	}
+	b.room -= int64(len(text))
+	sum := sha256.Sum256([]byte(text))
+	entry.Size = int64(len(text))
+	entry.SHA256 = hex.EncodeToString(sum[:])
+	b.manifest.Entries = append(b.manifest.Entries, entry)
+	b.contents = append(b.contents, []byte(text))
+}
+
+// missing records in the manifest that name couldn't be collected.
+func (b *supportBundle) missing(name, source string, err error) {
+	msg, _ := redact(err.Error(), b.opts.Secrets)
+	b.manifest.Entries = append(b.manifest.Entries, BundleEntry{Name: name, Source: source, Error: msg})
+}
+
+// addReport adds the persisted report, as report.json without the
+// evidence, which goes in evidence.json, by check.
+func (b *supportBundle) addReport() {
+	data, err := os.ReadFile(b.opts.ReportPath)
+	if err != nil {
+		b.missing("report.json", b.opts.ReportPath, err)
+		return
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		// Still worth having, as it is
+		b.add("report.json", b.opts.ReportPath, data, false)
+		return
+	}
+	type checkEvidence struct {
+		Check    string     `json:"check"`
+		Evidence []Evidence `json:"evidence"`
+	}
+	var evidence []checkEvidence
+	for i := range report.Results {
+		if report.Results[i].Evidence != nil {
+			evidence = append(evidence, checkEvidence{report.Results[i].Name, report.Results[i].Evidence})
+			report.Results[i].Evidence = nil
+		}
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	b.add("report.json", b.opts.ReportPath, out, false)
+	if evidence != nil {
+		out, _ := json.MarshalIndent(evidence, "", "  ")
+		b.add("evidence.json", b.opts.ReportPath, out, false)
+	}
+}
+
+// addFile adds the file at name under the host's root.
+func (b *supportBundle) addFile(name string, keepTail bool) {
+	path := filepath.Join(b.opts.host().root, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		b.missing(name, path, err)
+		return
+	}
+	b.add(name, path, data, keepTail)
+}
+
+// addTool adds the output of running call, and its exit status, if it
+// wasn't zero, alongside.
+func (b *supportBundle) addTool(call toolCall) {
+	name := "dumps/" + dumpName(call[0], call[1:]...)
+	source := strings.Join(call, " ")
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCheckTimeout)
+	defer cancel()
+	out, _, err := boundedOutput(call.command(ctx, b.opts.ExecCommand), max(b.room, 1))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		b.add(strings.TrimSuffix(name, ".txt")+".exit", source, []byte(strconv.Itoa(exitErr.ExitCode())+"\n"), false)
+	} else if err != nil {
+		b.missing(name, source, toolError(call[0], err))
+		return
+	}
+	b.add(name, source, out, false)
+}
+
+// write writes the bundle as a gzip'd tarball at path, with the manifest
+// first.
+func (b *supportBundle) write(path string) (err error) {
+	manifest, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	put := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: b.manifest.Created}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := put(bundleManifestName, manifest); err != nil {
+		return err
+	}
+	i := 0
+	for _, entry := range b.manifest.Entries {
+		if entry.Error != "" {
+			continue
+		}
+		if err := put(entry.Name, b.contents[i]); err != nil {
+			return fmt.Errorf("writing %s to the support bundle: %w", entry.Name, err)
+		}
+		i++
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// dumpName returns the name of the file the output of running the tool
+// name with args is kept in: the tool's base name, followed by each
+// argument, without leading dashes, separated by dashes, e.g.
+// "systemctl-version.txt" for "/usr/bin/systemctl --version".
+func dumpName(name string, args ...string) string {
+	parts := []string{filepath.Base(name)}
+	for _, arg := range args {
+		parts = append(parts, strings.TrimLeft(arg, "-"))
+	}
+	return strings.Join(parts, "-") + ".txt"
+}
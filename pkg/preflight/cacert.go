@@ -0,0 +1,146 @@
+package preflight
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	// DefaultCAExpiryWindow is how soon before they expire additional CA
+	// certificates are warned about.
+	DefaultCAExpiryWindow = 30 * 24 * time.Hour
+
+	pemBegin = "-----BEGIN "
+)
+
+// CACertCheck verifies the additional CA certificates in the install
+// configuration, which are trusted for private registries and the join
+// server.  A truncated or mangled paste otherwise only shows up after
+// installation, as TLS errors which don't say why.  Every PEM block must
+// be a certificate that can be parsed, or the check fails, naming the
+// block by its position.  Certificates which are expired, not yet valid,
+// or which expire within Options.CAExpiryWindow are warned about, as are
+// certificates which aren't CAs, which is usually the registry's own
+// certificate pasted by mistake.
+type CACertCheck struct {
+	// CACerts are the PEM encoded certificates.
+	CACerts string
+}
+
+// NewCACertCheck returns a CACertCheck for the additional CA in the given
+// install configuration.
+func NewCACertCheck(cfg *config.HarvesterConfig) CACertCheck {
+	return CACertCheck{CACerts: cfg.SystemSettings["additional-ca"]}
+}
+
+func (c CACertCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c CACertCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "CACert"
+	if strings.TrimSpace(c.CACerts) == "" {
+		result.Message = "Skipped: the install configuration does not set an additional CA."
+		return
+	}
+
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+	defer func() { result.Message = strings.Join(findings, " ") }()
+
+	blocks := pemBlocks(c.CACerts)
+	if len(blocks) == 0 {
+		finding(SeverityFatal, "The additional CA does not contain any PEM encoded certificates.")
+		return
+	}
+	window := env.Options.CAExpiryWindow
+	if window == 0 {
+		window = DefaultCAExpiryWindow
+	}
+	current := env.host().now()
+	for i, block := range blocks {
+		position := fmt.Sprintf("Block %d of the additional CA (at byte %d)", i+1, block.offset)
+		switch {
+		case block.Block == nil:
+			finding(SeverityFatal, "%s is not valid PEM; it may have been truncated when it was pasted.", position)
+			continue
+		case block.Type != "CERTIFICATE":
+			// The type is safe to repeat, but not the contents, which
+			// may be a private key
+			finding(SeverityFatal, "%s is a %s, not a CERTIFICATE.", position, block.Type)
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			finding(SeverityFatal, "%s is not a valid certificate: %v.", position, err)
+			continue
+		}
+
+		subject := cert.Subject.String()
+		notAfter := cert.NotAfter.UTC().Format(time.DateOnly)
+		switch {
+		case current.After(cert.NotAfter):
+			finding(SeverityWarning, "%s expired on %s.", subject, notAfter)
+		case current.Before(cert.NotBefore):
+			finding(SeverityWarning, "%s is not valid until %s; check the clock.", subject, cert.NotBefore.UTC().Format(time.DateOnly))
+		case current.Add(window).After(cert.NotAfter):
+			finding(SeverityWarning, "%s expires soon, on %s.", subject, notAfter)
+		default:
+			finding(SeverityOK, "%s is valid until %s.", subject, notAfter)
+		}
+		if !cert.BasicConstraintsValid || !cert.IsCA {
+			finding(SeverityWarning, "%s is not a CA certificate, so it can only be used to verify itself. "+
+				"Supply the CA which issued it instead.", subject)
+		}
+	}
+	return
+}
+
+// positionedBlock is a PEM block, or nil if the text at offset looks
+// like one, but isn't valid.
+type positionedBlock struct {
+	*pem.Block
+	offset int
+}
+
+// pemBlocks returns the PEM blocks in data, including any which can't be
+// decoded.  pem.Decode would skip those, and carry on with the next.
+func pemBlocks(data string) []positionedBlock {
+	var blocks []positionedBlock
+	for offset := strings.Index(data, pemBegin); offset >= 0; {
+		end := len(data)
+		next := strings.Index(data[offset+len(pemBegin):], pemBegin)
+		if next >= 0 {
+			end = offset + len(pemBegin) + next
+		}
+		block, _ := pem.Decode([]byte(data[offset:end]))
+		blocks = append(blocks, positionedBlock{Block: block, offset: offset})
+		if next < 0 {
+			break
+		}
+		offset = end
+	}
+	return blocks
+}
+
+// rootCAs returns the system CAs plus caCerts.  ok is false if caCerts
+// is set, but doesn't contain any certificates.
+func rootCAs(caCerts string) (roots *x509.CertPool, ok bool) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if caCerts == "" {
+		return roots, true
+	}
+	return roots, roots.AppendCertsFromPEM([]byte(caCerts))
+}
@@ -0,0 +1,103 @@
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CgroupMode is how the cgroup hierarchy is set up.
+type CgroupMode string
+
+const (
+	CgroupModeUnified CgroupMode = "unified"
+	CgroupModeHybrid  CgroupMode = "hybrid"
+	CgroupModeLegacy  CgroupMode = "legacy"
+)
+
+// requiredCgroupControllers are the controllers the container runtime
+// needs enabled at the root of the unified hierarchy
+var requiredCgroupControllers = []string{"cpu", "memory", "io", "pids"}
+
+// CgroupCheck makes sure the system is using the unified cgroup v2
+// hierarchy, which our container runtime configuration assumes, and
+// that the controllers it needs are available.  The mode is recorded in
+// the inventory.
+type CgroupCheck struct{}
+
+func (c CgroupCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Cgroup"
+
+	mounts, err := readMountTypes(env, filepath.Join(env.host().root, "proc/self/mountinfo"))
+	if err != nil {
+		return
+	}
+
+	var mode CgroupMode
+	switch {
+	case mounts["/sys/fs/cgroup"] == "cgroup2":
+		mode = CgroupModeUnified
+	case mounts["/sys/fs/cgroup/unified"] == "cgroup2":
+		mode = CgroupModeHybrid
+	default:
+		mode = CgroupModeLegacy
+	}
+	env.Inventory.CgroupMode = mode
+
+	if mode != CgroupModeUnified {
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("The system is using the %s cgroup hierarchy, but SaftOS requires the unified cgroup v2 hierarchy. "+
+			"Please boot without systemd.unified_cgroup_hierarchy=0 or systemd.legacy_systemd_cgroup_controller.", mode)
+		return
+	}
+
+	out, err := env.readFile(filepath.Join(env.host().root, "sys/fs/cgroup/cgroup.controllers"))
+	if err != nil {
+		return
+	}
+	controllers := strings.Fields(string(out))
+	var missing []string
+	for _, controller := range requiredCgroupControllers {
+		if !slices.Contains(controllers, controller) {
+			missing = append(missing, controller)
+		}
+	}
+	if len(missing) > 0 {
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("The unified cgroup hierarchy is missing the %s controllers, which are needed by the container runtime. Available controllers: %s.",
+			strings.Join(missing, ", "), strings.Join(controllers, " "))
+		return
+	}
+	result.Message = fmt.Sprintf("Unified cgroup v2 hierarchy, with controllers: %s.", strings.Join(controllers, " "))
+	return
+}
+
+// readMountTypes reads a mountinfo file, returning the filesystem types
+// by mount point.  Lines look like this:
+//
+//	35 24 0:30 / /sys/fs/cgroup rw,nosuid,nodev shared:9 - cgroup2 cgroup2 rw
+//
+// where the mount point is the fifth field, and the type is the first
+// after the "-" separator.
+func readMountTypes(env *Env, path string) (map[string]string, error) {
+	data, err := env.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	types := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := slices.Index(fields, "-")
+		if len(fields) < 5 || sep < 0 || sep+1 >= len(fields) {
+			continue
+		}
+		types[fields[4]] = fields[sep+1]
+	}
+	return types, scanner.Err()
+}
@@ -0,0 +1,55 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroupCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture  string
+		mode     CgroupMode
+		severity Severity
+		message  string
+	}{
+		{
+			fixture: "v2",
+			mode:    CgroupModeUnified,
+			message: "Unified cgroup v2 hierarchy, with controllers: cpuset cpu io memory hugetlb pids rdma misc.",
+		},
+		{
+			fixture:  "v2-limited",
+			mode:     CgroupModeUnified,
+			severity: SeverityWarning,
+			message: "The unified cgroup hierarchy is missing the io, pids controllers, which are needed by the container runtime. " +
+				"Available controllers: cpuset cpu memory.",
+		},
+		{
+			fixture:  "hybrid",
+			mode:     CgroupModeHybrid,
+			severity: SeverityFatal,
+			message: "The system is using the hybrid cgroup hierarchy, but SaftOS requires the unified cgroup v2 hierarchy. " +
+				"Please boot without systemd.unified_cgroup_hierarchy=0 or systemd.legacy_systemd_cgroup_controller.",
+		},
+		{
+			fixture:  "v1",
+			mode:     CgroupModeLegacy,
+			severity: SeverityFatal,
+			message: "The system is using the legacy cgroup hierarchy, but SaftOS requires the unified cgroup v2 hierarchy. " +
+				"Please boot without systemd.unified_cgroup_hierarchy=0 or systemd.legacy_systemd_cgroup_controller.",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/cgroup/" + test.fixture
+		env := &Env{machine: h}
+		result, err := CgroupCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "Cgroup", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Equal(t, test.mode, env.Inventory.CgroupMode, test.fixture)
+	}
+}
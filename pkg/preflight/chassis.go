@@ -0,0 +1,174 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// chassisTypes are the SMBIOS chassis type names, as dmidecode prints
+// them, indexed by the number in /sys/class/dmi/id/chassis_type
+var chassisTypes = map[int]string{
+	1:  "Other",
+	2:  "Unknown",
+	3:  "Desktop",
+	4:  "Low Profile Desktop",
+	5:  "Pizza Box",
+	6:  "Mini Tower",
+	7:  "Tower",
+	8:  "Portable",
+	9:  "Laptop",
+	10: "Notebook",
+	11: "Hand Held",
+	12: "Docking Station",
+	13: "All In One",
+	14: "Sub Notebook",
+	15: "Space-saving",
+	16: "Lunch Box",
+	17: "Main Server Chassis",
+	18: "Expansion Chassis",
+	19: "Sub Chassis",
+	20: "Bus Expansion Chassis",
+	21: "Peripheral Chassis",
+	22: "RAID Chassis",
+	23: "Rack Mount Chassis",
+	24: "Sealed-case PC",
+	25: "Multi-system",
+	26: "CompactPCI",
+	27: "AdvancedTCA",
+	28: "Blade",
+	29: "Blade Enclosing",
+	30: "Tablet",
+	31: "Convertible",
+	32: "Detachable",
+	33: "IoT Gateway",
+	34: "Embedded PC",
+	35: "Mini PC",
+	36: "Stick PC",
+}
+
+// portableChassisTypes are the chassis types which run on battery, and
+// probably have a lid
+var portableChassisTypes = []string{
+	"Portable", "Laptop", "Notebook", "Hand Held", "Sub Notebook", "Tablet", "Convertible", "Detachable",
+}
+
+// lidSleepActions are the logind HandleLidSwitch actions which take the
+// system down
+var lidSleepActions = []string{"suspend", "hibernate", "hybrid-sleep", "suspend-then-hibernate", "poweroff", "halt"}
+
+// ChassisCheck looks for signs that the host is a laptop: a portable
+// chassis type, or a battery.  People do try to run SaftOS on laptops,
+// and then closing the lid takes the "server" down, so in production
+// mode this is warned about.  What logind will do when the lid is closed
+// is included.  The chassis type is recorded in the inventory.
+type ChassisCheck struct{}
+
+func (c ChassisCheck) probes(env *Env) []toolCall {
+	return dmiProbes(env)
+}
+
+func (c ChassisCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Chassis"
+	chassis, err := readChassisType(env)
+	if err != nil {
+		return
+	}
+	env.Inventory.ChassisType = chassis
+	battery, err := batteryPresent(env)
+	if err != nil {
+		return
+	}
+
+	if chassis != "" {
+		result.Message = fmt.Sprintf("Chassis type is %s.", chassis)
+	}
+	portable := slices.Contains(portableChassisTypes, chassis)
+	if !portable && !battery {
+		return
+	}
+
+	if battery {
+		result.Message += " A battery is present."
+	}
+	if action := lidSwitchAction(env); slices.Contains(lidSleepActions, action) {
+		result.Message += fmt.Sprintf(" Closing the lid will take the system down (HandleLidSwitch=%s in logind.conf).", action)
+	}
+	result.Message = strings.TrimSpace(result.Message + " This looks like a laptop, which is not suitable as a server.")
+	result.Severity = SeverityInfo
+	if env.Options.Production {
+		result.Severity = SeverityWarning
+	}
+	return
+}
+
+// readChassisType returns the chassis type name, from sysfs if possible,
+// otherwise from dmidecode.  It returns "" on platforms without SMBIOS.
+func readChassisType(env *Env) (string, error) {
+	if n, err := strconv.Atoi(env.readTrimmed(filepath.Join(env.host().root, "sys/class/dmi/id/chassis_type"))); err == nil {
+		if name, ok := chassisTypes[n]; ok {
+			return name, nil
+		}
+		return fmt.Sprintf("type %d", n), nil
+	}
+	records, err := env.dmi(3)
+	if errors.Is(err, errNoSMBIOS) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, record := range records {
+		if chassis := record.Fields["Type"]; chassis != "" {
+			return chassis, nil
+		}
+	}
+	return "", nil
+}
+
+// batteryPresent returns true if any power supply is a battery.
+func batteryPresent(env *Env) (bool, error) {
+	dir := filepath.Join(env.host().root, "sys/class/power_supply")
+	entries, err := env.readDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if env.readTrimmed(filepath.Join(dir, entry.Name(), "type")) == "Battery" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lidSwitchAction returns logind's HandleLidSwitch setting, from
+// logind.conf and its drop-ins (later files override earlier ones).  The
+// default is "suspend".
+func lidSwitchAction(env *Env) string {
+	files := []string{filepath.Join(env.host().root, "etc/systemd/logind.conf")}
+	dropIns, _ := env.glob(filepath.Join(env.host().root, "etc/systemd/logind.conf.d/*.conf"))
+	sort.Strings(dropIns)
+	files = append(files, dropIns...)
+
+	action := "suspend"
+	for _, file := range files {
+		out, err := env.readFile(file)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if ok && strings.TrimSpace(key) == "HandleLidSwitch" {
+				action = strings.TrimSpace(value)
+			}
+		}
+	}
+	return action
+}
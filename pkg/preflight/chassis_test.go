@@ -0,0 +1,74 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChassisCheck(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+
+	const laptop = " This looks like a laptop, which is not suitable as a server."
+
+	tests := []struct {
+		fixture    string
+		production bool
+		severity   Severity
+		message    string
+		chassis    string
+	}{
+		{
+			fixture: "server",
+			message: "Chassis type is Rack Mount Chassis.",
+			chassis: "Rack Mount Chassis",
+		},
+		{
+			fixture:    "desktop",
+			production: true,
+			message:    "Chassis type is Desktop.",
+			chassis:    "Desktop",
+		},
+		{
+			fixture:  "laptop",
+			severity: SeverityInfo,
+			message: "Chassis type is Notebook. A battery is present. " +
+				"Closing the lid will take the system down (HandleLidSwitch=suspend in logind.conf)." + laptop,
+			chassis: "Notebook",
+		},
+		{
+			fixture:    "laptop",
+			production: true,
+			severity:   SeverityWarning,
+			message: "Chassis type is Notebook. A battery is present. " +
+				"Closing the lid will take the system down (HandleLidSwitch=suspend in logind.conf)." + laptop,
+			chassis: "Notebook",
+		},
+		{
+			fixture:    "laptop-lid-ignore",
+			production: true,
+			severity:   SeverityWarning,
+			message:    "Chassis type is Laptop. A battery is present." + laptop,
+			chassis:    "Laptop",
+		},
+		{
+			fixture:    "no-sysfs",
+			production: true,
+			severity:   SeverityWarning,
+			message: "Chassis type is Notebook. " +
+				"Closing the lid will take the system down (HandleLidSwitch=suspend in logind.conf)." + laptop,
+			chassis: "Notebook",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/chassis/" + test.fixture
+		env := &Env{Options: Options{Production: test.production}, execCommand: fakeCommand("dmidecode-notebook"), machine: h}
+		result, err := ChassisCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "Chassis", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Equal(t, test.chassis, env.Inventory.ChassisType, test.fixture)
+	}
+}
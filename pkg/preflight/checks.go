@@ -1,13 +1,14 @@
 package preflight
 
 import (
-	"bufio"
+	"bytes"
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
-	"os"
 	"os/exec"
-	"strconv"
+	"slices"
 	"strings"
 
 	"github.com/sirupsen/logrus"
@@ -16,6 +17,8 @@ import (
 const (
 	// Constants here from Hardware Requirements in the documentation
 	// https://docs.harvesterhci.io/v1.3/install/requirements/#hardware-requirements
+	// They're the defaults, which Options.Thresholds, or the installer
+	// image's DefaultThresholdsPath, can override.
 	MinCPUTest         = 8
 	MinCPUProd         = 16
 	MinMemoryTest      = 32
@@ -24,57 +27,193 @@ const (
 	MinNetworkGbpsProd = 10
 )
 
-var (
-	// So that we can fake this stuff up for unit tests
-	execCommand         = exec.Command
-	procMemInfo         = "/proc/meminfo"
-	devKvm              = "/dev/kvm"
-	sysClassNetDevSpeed = "/sys/class/net/%s/speed"
+const (
+	// Where the checks look by default
+	defaultProcMemInfo = "/proc/meminfo"
+	defaultDevKvm      = "/dev/kvm"
 )
 
+// nestedVirtHints say how to enable nested virtualization on each
+// hypervisor, by the name systemd-detect-virt gives it.
+var nestedVirtHints = map[string]string{
+	"kvm":       "Enable the nested parameter of kvm_intel or kvm_amd on the KVM host, and give the VM the host-passthrough CPU model.",
+	"qemu":      "Run the VM with KVM acceleration, rather than QEMU's emulation, on a host with nested virtualization enabled.",
+	"vmware":    "Enable \"Expose hardware assisted virtualization to the guest OS\" in the VM's CPU settings.",
+	"microsoft": "Run Set-VMProcessor -VMName <VM> -ExposeVirtualizationExtensions $true on the Hyper-V host while the VM is off.",
+	"oracle":    "Run VBoxManage modifyvm <VM> --nested-hw-virt on while the VM is off.",
+	"xen":       "Set nestedhvm=1 in the VM's configuration.",
+}
+
 // The Run() method of a preflight.Check returns a string.  If the string
 // is empty, it means the check passed.  Otherwise, the string contains
 // some text explaining why the check failed.  The error value will be set
 // if the check itself failed to run at all for some reason.
+//
+// The hardware requirement checks are ResultChecks as well, whose
+// Results say how bad a failure is; Run is kept for callers which only
+// want the message.
 type Check interface {
 	Run() (string, error)
 }
 
 type CPUCheck struct{}
-type MemoryCheck struct{}
-type VirtCheck struct{}
-type KVMHostCheck struct{}
+
+type MemoryCheck struct {
+	// MemInfoPath is where meminfo is read from, if dmidecode has nothing
+	// to say, or /proc/meminfo if empty.
+	MemInfoPath string
+}
+
+type VirtCheck struct {
+	// ExecCommand makes the command for systemd-detect-virt, or is
+	// exec.Command if nil.
+	ExecCommand func(name string, args ...string) *exec.Cmd
+	// DevicePath is the KVM device, or /dev/kvm if empty.
+	DevicePath string
+}
+
+type KVMHostCheck struct {
+	// DevicePath is the KVM device, or /dev/kvm if empty.
+	DevicePath string
+}
+
 type NetworkSpeedCheck struct {
 	Dev string
 }
 
-func (c CPUCheck) Run() (msg string, err error) {
-	out, err := execCommand("/usr/bin/nproc", "--all").Output()
-	if err != nil {
-		return
+// DiskCheck looks at whether the disk Dev, e.g. sda or nvme0n1, is
+// rotational.  If Dev is empty, the installation device from the
+// inventory is checked.  Its size is DiskSizeCheck's business.
+type DiskCheck struct {
+	Dev string
+}
+
+// NewMemoryCheck returns a MemoryCheck which reads /proc/meminfo.
+func NewMemoryCheck() MemoryCheck {
+	return MemoryCheck{MemInfoPath: defaultProcMemInfo}
+}
+
+// NewVirtCheck returns a VirtCheck which runs systemd-detect-virt, and
+// looks for /dev/kvm.
+func NewVirtCheck() VirtCheck {
+	return VirtCheck{ExecCommand: exec.Command, DevicePath: defaultDevKvm}
+}
+
+// NewKVMHostCheck returns a KVMHostCheck which looks for /dev/kvm.
+func NewKVMHostCheck() KVMHostCheck {
+	return KVMHostCheck{DevicePath: defaultDevKvm}
+}
+
+func (c CPUCheck) Run() (string, error) {
+	result, err := c.Evaluate(context.Background(), &Env{})
+	return result.Message, err
+}
+
+func (c CPUCheck) readsFrom() []string {
+	return []string{"Cmdline"}
+}
+
+// Evaluate is like Run, except that CPUs isolated from the scheduler by
+// isolcpus or nohz_full (as recorded in the inventory by CmdlineCheck)
+// aren't counted, because workloads can't use them.  The CPUs present are
+// counted, whether or not they're online, but both counts are recorded in
+// the result's Facts, since they differ on hosts with CPU hotplug.  The
+// architecture is recorded too, as the minima depend on it (see
+// ArchThresholds), and one SaftOS doesn't run on is fatal.
+func (c CPUCheck) Evaluate(ctx context.Context, env *Env) (Result, error) {
+	if !slices.Contains(supportedArchs, env.host().goarch) {
+		return Result{
+			Name:     "CPU",
+			Severity: cpuUnsupportedArchMessage.Severity,
+			Message:  cpuUnsupportedArchMessage.render(env.host().goarch, strings.Join(supportedArchs, " and ")),
+			Facts:    map[string]any{"arch": env.host().goarch},
+		}, nil
+	}
+	check := cpuThresholdCheck
+	check.Measure = c.measure
+	return check.Evaluate(ctx, env)
+}
+
+func (c CPUCheck) measure(_ context.Context, env *Env) (m measurement, err error) {
+	online := env.host().onlineCPUs()
+	m.Facts = map[string]any{"arch": env.host().goarch, "onlineCPUs": online}
+	count, presentErr := presentCPUs(env)
+	if presentErr != nil {
+		// Without sysfs, /proc/cpuinfo still lists those present
+		count, presentErr = cpuinfoCPUs(env)
 	}
-	nproc, _ := strconv.Atoi(strings.TrimSpace(string(out)))
-	if nproc < MinCPUTest {
-		msg = fmt.Sprintf("Only %d CPU cores detected. SaftOS requires at least %d cores for testing and %d for production use.",
-			nproc, MinCPUTest, MinCPUProd)
-	} else if nproc < MinCPUProd {
-		msg = fmt.Sprintf("%d CPU cores detected. SaftOS requires at least %d cores for production use.",
-			nproc, MinCPUProd)
+	if presentErr != nil {
+		logrus.Warnf("Counting the %d CPUs online rather than those present: %v", online, presentErr)
+		count = online
+	} else {
+		m.Facts["presentCPUs"] = count
 	}
+	if env.Options.Debug {
+		crossCheckNproc(env, count)
+	}
+	env.Inventory.LogicalCPUs = count
+	usable := count - env.Inventory.IsolatedCPUs
+	cores := cpuCoresMessage.render(usable)
+	if env.Inventory.IsolatedCPUs > 0 {
+		cores = cpuCoresIsolatedMessage.render(usable, env.Inventory.IsolatedCPUs)
+	}
+	m.Value = float64(usable)
+	m.Subject = []any{cores}
 	return
 }
 
 func (c MemoryCheck) Run() (string, error) {
+	result, err := c.Evaluate(context.Background(), &Env{})
+	return result.Message, err
+}
+
+// PhysicalBytes returns the RAM installed, as Run measures it, for
+// sizing things by it elsewhere in the installer: the total of the
+// memory ranges in the DMI tables, or MemTotal from /proc/meminfo where
+// those can't be had.  The latter is a bit less than what's installed.
+func (c MemoryCheck) PhysicalBytes() (uint64, error) {
+	return c.physicalBytes(&Env{})
+}
+
+func (c MemoryCheck) physicalBytes(env *Env) (uint64, error) {
+	if _, err := c.measure(context.Background(), env); err != nil {
+		return 0, err
+	}
+	return env.Inventory.MemoryBytes, nil
+}
+
+func (c MemoryCheck) readsFrom() []string {
+	return []string{"Kdump"}
+}
+
+func (c MemoryCheck) probes(env *Env) []toolCall {
+	return dmiProbes(env)
+}
+
+// Evaluate is like Run, except that memory reserved for the crash kernel
+// (as recorded in the inventory by KdumpCheck) isn't counted, because
+// workloads can't use it.  The usable amount is recorded in the inventory.
+func (c MemoryCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	check := memoryThresholdCheck
+	check.Measure = c.measure
+	result, err = check.Evaluate(ctx, env)
+	if result.Message != "" && env.Inventory.CrashKernelBytes > 0 {
+		result.Message += memoryCrashKernelMessage.render(formatBytes(env.Inventory.CrashKernelBytes))
+	}
+	return
+}
+
+func (c MemoryCheck) measure(_ context.Context, env *Env) (m measurement, err error) {
 	// We're working in KiB because that's what the fallback /proc/meminfo uses
-	var memTotalKiB uint
-	var wiggleRoom float32 = 1.0
+	var memTotalKiB uint64
+	var wiggleRoom = 1.0
 
 	// dmidecode is part of sle-micro-rancher, see e.g.
 	// https://build.opensuse.org/projects/SUSE:SLE-15-SP4:Update:Products:Micro54/packages/SLE-Micro-Rancher/files/SLE-Micro-Rancher.kiwi?expand=1
 	//
-	// The output of `dmidecode -t 19` will include one or more
-	// Memory Array Mapped Address blocks, for example on a system
-	// with 512GiB RAM, we might see this:
+	// The DMI type 19 records, which come from the same dmidecode run
+	// as all the other DMI data, are Memory Array Mapped Address
+	// blocks, for example on a system with 512GiB RAM, we might see:
 	//
 	//	# dmidecode 3.5
 	//	Getting SMBIOS data from sysfs.
@@ -96,70 +235,54 @@ func (c MemoryCheck) Run() (string, error) {
 	//		Physical Array Handle: 0x000B
 	//		Partition Width: 1
 	//
-	// By adding together all the "Range Size" lines we can determine
+	// By adding together all the "Range Size" fields we can determine
 	// the amount of physical RAM installed.  Note that it's possible
 	// for units to be specified in any of "bytes", "kB", "MB", "GB",
 	// "TB", "PB", "EB", "ZB", so we have to handle all of them...
 	// (see http://git.savannah.nongnu.org/cgit/dmidecode.git/tree/dmidecode.c#n283)
-	out, err := execCommand("/usr/sbin/dmidecode", "-t", "19").Output()
-	if err == nil {
-		rangeSizeToKiB := func(rangeSize uint, unit string) uint {
-			switch unit {
-			case "GB":
-				// We're probably usually going to see GB
-				return rangeSize << 20
-			case "MB":
-				// This seems unlikely
-				return rangeSize << 10
-			case "kB":
-				// This seems even more unlikely
-				return rangeSize
-			case "bytes":
-				// Seriously, are you kidding me?
-				return rangeSize >> 10
-			}
-			return 0
-		}
-
-		for _, line := range strings.Split(string(out), "\n") {
-			var rangeSize uint
-			var unit string
-			if n, _ := fmt.Sscanf(strings.TrimSpace(line), "Range Size: %d %s", &rangeSize, &unit); n == 2 {
-				if unit == "TB" || unit == "PB" || unit == "EB" || unit == "ZB" {
-					// If we've somehow got a Memory Array Mapped Address
-					// with one of these enormous units, let's just pretend
-					// we've got a terabyte of RAM and be done with it ;-)
-					logrus.Infof("Found Memory Array Mapped Address with Range Size %d %s, assuming 1 TiB RAM for preflight check", rangeSize, unit)
-					memTotalKiB = 1 << 30
-					break
+	// Some platforms (many arm64 boards, some VMs) don't have SMBIOS at
+	// all, in which case we go straight to the fallback.
+	if out, dmiErr := env.dmidecodeOutput(); dmiErr == nil {
+		kib, rangesErr := ParseDmidecodeMemoryKiB(out)
+		var ignored interface{ Unwrap() []error }
+		if errors.As(rangesErr, &ignored) {
+			for _, e := range ignored.Unwrap() {
+				// Rather than silently counting it as nothing
+				var parseErr *ParseError
+				if errors.As(e, &parseErr) {
+					logrus.Warnf("Ignoring %s with %q: %s", parseErr.Where, parseErr.Text, parseErr.Reason)
 				}
-				memTotalKiB += rangeSizeToKiB(rangeSize, unit)
 			}
 		}
+		memTotalKiB = uint64(kib)
+		// The crash kernel reservation is carved out of physical RAM.
+		// (MemTotal in /proc/meminfo already excludes it.)
+		memTotalKiB -= min(memTotalKiB, env.Inventory.CrashKernelBytes>>10)
 	}
 
 	if memTotalKiB == 0 {
 		// Somehow, we didn't get anything out of dmidecode, fall back to
 		// parsing /proc/meminfo
 
-		meminfo, err := os.Open(procMemInfo)
+		var meminfo []byte
+		memInfoPath := cmp.Or(c.MemInfoPath, defaultProcMemInfo)
+		meminfo, err = env.readFile(memInfoPath)
 
 		if err != nil {
-			return "", err
-		}
-
-		defer meminfo.Close()
-		scanner := bufio.NewScanner(meminfo)
-
-		for scanner.Scan() {
-			if n, _ := fmt.Sscanf(scanner.Text(), "MemTotal: %d kB", &memTotalKiB); n == 1 {
-				break
-			}
+			return
 		}
 
-		if memTotalKiB == 0 {
-			return "", errors.New("unable to extract MemTotal from /proc/meminfo")
+		var kib uint
+		if kib, err = ParseProcMeminfoKiB(bytes.NewReader(meminfo)); errors.Is(err, errNoMemTotal) {
+			err = parseErrorf(memoryNoMemTotalError.Format, memInfoPath)
+			return
+		} else if errors.Is(err, ErrParseFailure) {
+			err = parseErrorf(memoryMalformedMemTotalError.Format, memInfoPath, err)
+			return
+		} else if err != nil {
+			return
 		}
+		memTotalKiB = uint64(kib)
 
 		// MemTotal from /proc/cpuinfo is a bit less than the actual physical
 		// memory in the system, due to reserved RAM not being included, so
@@ -175,11 +298,12 @@ func (c MemoryCheck) Run() (string, error) {
 
 		wiggleRoom = 0.9
 
-		// Note that the above also means the warning messages below will be a
+		// Note that the above also means the warning messages will be a
 		// bit off (e.g. something like "System reports 31GiB RAM" on a 32GiB
 		// system).
 	}
 
+	env.Inventory.MemoryBytes = uint64(memTotalKiB) << 10
 	memTotalMiB := memTotalKiB / (1 << 10)
 	memTotalGiB := memTotalKiB / (1 << 20)
 	memReported := fmt.Sprintf("%dGiB", memTotalGiB)
@@ -189,19 +313,37 @@ func (c MemoryCheck) Run() (string, error) {
 		memReported = fmt.Sprintf("%dMiB", memTotalMiB)
 	}
 
-	if float32(memTotalGiB) < (MinMemoryTest * wiggleRoom) {
-		return fmt.Sprintf("Only %s RAM detected. SaftOS requires at least %dGiB for testing and %dGiB for production use.",
-			memReported, MinMemoryTest, MinMemoryProd), nil
-	} else if float32(memTotalGiB) < (MinMemoryProd * wiggleRoom) {
-		return fmt.Sprintf("%s RAM detected. SaftOS requires at least %dGiB for production use.",
-			memReported, MinMemoryProd), nil
+	if env.Inventory.CrashKernelBytes > 0 {
+		memReported = fmt.Sprintf("%s usable", memReported)
 	}
 
-	return "", nil
+	m.Value = float64(memTotalGiB)
+	m.Tolerance = wiggleRoom
+	m.Facts = map[string]any{"memoryGiB": memTotalGiB}
+	m.Subject = []any{memReported}
+	return
 }
 
-func (c VirtCheck) Run() (msg string, err error) {
-	out, err := execCommand("/usr/bin/systemd-detect-virt", "--vm").Output()
+func (c VirtCheck) Run() (string, error) {
+	result, err := c.Evaluate(context.Background(), &Env{})
+	return result.Message, err
+}
+
+// Evaluate is like Run, except that systemd-detect-virt is run by the
+// Env's ExecCommand, if the check doesn't have its own.  Plenty of test
+// installs are virtualized, so what matters then is whether the
+// hypervisor passes virtualization through, i.e. whether there's a
+// /dev/kvm, as without it SaftOS's VMs are emulated, and unusably slow,
+// which is fatal if the host is meant for production.  How to enable
+// nested virtualization depends on the hypervisor, which the message
+// names.
+func (c VirtCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "Virt"
+	command := execFunc(c.ExecCommand)
+	if command == nil {
+		command = env.execCommand
+	}
+	out, err := command.cmdContext(ctx, "/usr/bin/systemd-detect-virt", "--vm").Output()
 	virt := strings.TrimSpace(string(out))
 	if err != nil {
 		// systemd-detect-virt will return a non-zero exit code
@@ -213,45 +355,226 @@ func (c VirtCheck) Run() (msg string, err error) {
 		if virt == "none" {
 			err = nil
 		}
+		err = toolError("/usr/bin/systemd-detect-virt", err)
+		return
+	}
+	nested := true
+	if _, statErr := env.stat(cmp.Or(c.DevicePath, defaultDevKvm)); errors.Is(statErr, fs.ErrNotExist) {
+		nested = false
+	} else if statErr != nil {
+		err = statErr
+		return
+	}
+	result.Facts = map[string]any{"hypervisor": virt, "nestedVirt": nested}
+	for _, path := range []string{env.host().sysModuleKVMIntelNested, env.host().sysModuleKVMAMDNested} {
+		if out, readErr := env.readFile(path); readErr == nil {
+			// Whether this host could pass virtualization on in turn
+			result.Facts["kvmNested"] = strings.TrimSpace(string(out))
+			break
+		}
+	}
+	if !nested {
+		message := virtNoNestedMessage
+		if env.Options.Production {
+			message = virtNoNestedProductionMessage
+		}
+		result.Severity = message.Severity
+		result.Message = message.render(virt)
+		if hint, ok := nestedVirtHints[virt]; ok {
+			result.Remediation = &Remediation{Hint: hint}
+		}
 		return
 	}
-	msg = fmt.Sprintf("System is virtualized (%s) which is not supported in production.", virt)
+	result.Severity = virtVirtualizedMessage.Severity
+	result.Message = virtVirtualizedMessage.render(virt)
 	return
 }
 
-func (c KVMHostCheck) Run() (msg string, err error) {
-	if _, err = os.Stat(devKvm); errors.Is(err, fs.ErrNotExist) {
-		msg = "SaftOS requires hardware-assisted virtualization, but /dev/kvm does not exist."
+func (c KVMHostCheck) Run() (string, error) {
+	result, err := c.Evaluate(context.Background(), &Env{})
+	return result.Message, err
+}
+
+func (c KVMHostCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "KVMHost"
+	if _, err = env.stat(cmp.Or(c.DevicePath, defaultDevKvm)); errors.Is(err, fs.ErrNotExist) {
+		result.Severity = kvmHostNoKVMMessage.Severity
+		result.Message = kvmHostNoKVMMessage.Format
 		err = nil
 	}
 	return
 }
 
-func (c NetworkSpeedCheck) Run() (msg string, err error) {
-	speedPath := fmt.Sprintf(sysClassNetDevSpeed, c.Dev)
-	out, err := os.ReadFile(speedPath)
+func (c NetworkSpeedCheck) Run() (string, error) {
+	result, err := c.Evaluate(context.Background(), &Env{})
+	return result.Message, err
+}
+
+// Evaluate is like Run, except that the thresholds depend on the node's
+// role.
+func (c NetworkSpeedCheck) Evaluate(ctx context.Context, env *Env) (Result, error) {
+	check := networkSpeedThresholdCheck
+	check.Measure = c.measure
+	return check.Evaluate(ctx, env)
+}
+
+// transient returns whether result is of a NIC not knowing its speed,
+// which those whose link is still being negotiated don't, for a moment.
+func (c NetworkSpeedCheck) transient(result Result) bool {
+	_, ok := result.Facts["speedUnknown"]
+	return ok
+}
+
+// measure reads the link speed of Dev, or, if it's a bond, bridge or
+// VLAN, of the NICs it's on, as linkNetDevs says, taking the slowest.
+// The speed can't be verified if any of them has no link, or doesn't know
+// its speed, which the message says rather than failing the check, and a
+// wireless NIC won't do whatever its speed.
+func (c NetworkSpeedCheck) measure(_ context.Context, env *Env) (m measurement, err error) {
+	links, err := linkNetDevs(env, c.Dev)
 	if err != nil {
 		return
 	}
-	speedMbps, _ := strconv.Atoi(strings.TrimSpace(string(out)))
-	if speedMbps < 1 {
-		// speedMbps will be 0 if strconv.Atoi fails for some reason,
-		// or -1 (if you can believe that) when using virtio NICs when
-		// testing under virtualization.
-		err = fmt.Errorf("unable to determine NIC speed from %s (got %d)", speedPath, speedMbps)
-		return
+	m.Device = c.Dev
+	if len(links) > 1 || links[0] != c.Dev {
+		m.Facts = map[string]any{"links": links}
+	}
+	// A bond, bridge or VLAN can be down whatever the state of the NICs
+	// it's on, so it's looked at too
+	for _, dev := range append([]string{c.Dev}, links...) {
+		if m.Unmeasurable, err = netDevState(env, dev); err != nil || m.Unmeasurable != nil {
+			m.Subject = []any{dev}
+			return
+		}
+	}
+	speedMbps := 0
+	for i, link := range links {
+		linkMbps, err := linkSpeedMbps(env, link)
+		if errors.Is(err, errLinkSpeedUnknown) {
+			m.Unmeasurable = &networkSpeedUnknownMessage
+			m.Subject = []any{link}
+			if m.Facts == nil {
+				m.Facts = map[string]any{}
+			}
+			m.Facts["speedUnknown"] = link
+			return m, nil
+		} else if err != nil {
+			return m, err
+		}
+		if i == 0 || linkMbps < speedMbps {
+			speedMbps = linkMbps
+		}
 	}
 	// We need floats because 2.5Gbps ethernet is a thing.
 	var speedGbps = float32(speedMbps) / 1000
-	if speedGbps < MinNetworkGbpsTest {
-		// Does anyone even _have_ < 1Gbps networking kit anymore?
-		// Still, it's theoretically possible someone could have messed
-		// up their switch config and be running 100Mbps...
-		msg = fmt.Sprintf("Link speed of %s is only %dMpbs. SaftOS requires at least %dGbps for testing and %dGbps for production use.",
-			c.Dev, speedMbps, MinNetworkGbpsTest, MinNetworkGbpsProd)
-	} else if speedGbps < MinNetworkGbpsProd {
-		msg = fmt.Sprintf("Link speed of %s is %gGbps. SaftOS requires at least %dGbps for production use.",
-			c.Dev, speedGbps, MinNetworkGbpsProd)
+	m.Value = float64(speedGbps)
+	if m.Facts == nil {
+		m.Facts = map[string]any{}
 	}
+	m.Facts["speedMbps"] = speedMbps
+	// Does anyone even _have_ < 1Gbps networking kit anymore?  Still,
+	// it's theoretically possible someone could have messed up their
+	// switch config and be running 100Mbps, which is worth saying in Mbps.
+	m.Subject = []any{c.Dev, speedMbps}
+	m.ProdSubject = []any{c.Dev, speedGbps}
 	return
 }
+
+// linkSpeedMbps reads the link speed of the interface dev.  The error is
+// errLinkSpeedUnknown if it's -1, which (if you can believe that) is what
+// virtio NICs report when testing under virtualization.
+func linkSpeedMbps(env *Env, dev string) (int, error) {
+	speedPath, err := env.netDevPath(dev, "speed")
+	if err != nil {
+		return 0, err
+	}
+	out, err := env.readFile(speedPath)
+	if err != nil {
+		return 0, err
+	}
+	speedMbps, err := ParseLinkSpeedMbps(string(out))
+	if errors.Is(err, errLinkSpeedUnknown) {
+		return 0, err
+	} else if err != nil {
+		return 0, parseErrorf(networkSpeedMalformedError.Format, speedPath, err)
+	}
+	return speedMbps, nil
+}
+
+func (c DiskCheck) Run() (string, error) {
+	result, err := c.Evaluate(context.Background(), &Env{})
+	return result.Message, err
+}
+
+func (c DiskCheck) readsFrom() []string {
+	return []string{"ConfigDevice"}
+}
+
+// Evaluate is like Run, except that the disk's details are recorded in
+// the Result's Facts.  A rotational disk is warned about, since etcd and
+// the VMs' disks need the random I/O of an SSD or NVMe drive.
+func (c DiskCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Disk"
+	dev := strings.TrimPrefix(c.Dev, "/dev/")
+	if dev == "" {
+		dev = env.Inventory.InstallDevice
+	}
+	if dev == "" {
+		// ConfigDeviceCheck reports installation disks it can't resolve
+		return
+	}
+	if result.Device, result.Facts, err = c.describe(env, dev); err != nil {
+		return
+	}
+	if rotational, _ := result.Facts["rotational"].(bool); rotational {
+		result.Severity = diskRotationalMessage.Severity
+		result.Message = diskRotationalMessage.render(result.Device)
+	}
+	return
+}
+
+// describe returns the disk dev is, or is a partition of, and its size,
+// logical sector size, and whether it's rotational.
+func (c DiskCheck) describe(env *Env, dev string) (disk string, facts map[string]any, err error) {
+	if _, err = env.blockDevPath(dev, ""); err != nil {
+		return
+	}
+	// A partition, e.g. sda2 or nvme0n1p2, is described as the disk it's
+	// on, which is what the installer wipes
+	if dev, err = env.resolveDisk(dev); err != nil {
+		return
+	}
+	read := func(file string) (uint64, error) {
+		path, err := env.blockDevPath(dev, file)
+		if err != nil {
+			return 0, err
+		}
+		out, err := env.readFile(path)
+		if err != nil {
+			return 0, err
+		}
+		value, err := parseCount(strings.TrimSpace(string(out)))
+		if err != nil {
+			return 0, parseErrorf(diskMalformedError.Format, path, err)
+		}
+		return value, nil
+	}
+
+	// The size is in 512 byte units whatever the logical sector size,
+	// which is only recorded, as disks with 4KiB sectors are worth
+	// knowing about when an image is written to them.
+	sectors, err := read("size")
+	if err != nil {
+		return
+	}
+	sectorSize, err := read("queue/hw_sector_size")
+	if err != nil {
+		return
+	}
+	rotational, err := read("queue/rotational")
+	if err != nil {
+		return
+	}
+	facts = map[string]any{"disk": dev, "sizeBytes": sectors * 512, "logicalSectorSize": sectorSize, "rotational": rotational == 1}
+	return dev, facts, nil
+}
@@ -0,0 +1,98 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/harvester/harvester-installer/pkg/version"
+)
+
+const (
+	// DefaultClockMaxAfterBuild is how long after the installer was built
+	// we'll believe the clock.  Anything later is assumed to be wrong.
+	DefaultClockMaxAfterBuild = 5 * 365 * 24 * time.Hour
+)
+
+// ClockSanityCheck catches clocks which are grossly wrong, which would
+// otherwise break certificate validation during bootstrap before NTP has
+// a chance to fix them.  Without network access, the best we can do is
+// check that the time is not before the installer was built, and not
+// implausibly long after.  It also warns if the hardware clock is kept
+// in local time, because the installed system assumes UTC.
+type ClockSanityCheck struct {
+	// BuildDate is when the installer was built.  If zero, only the
+	// hardware clock configuration is checked.
+	BuildDate time.Time
+	// MaxAfterBuild is how far past BuildDate the clock may be.
+	MaxAfterBuild time.Duration
+}
+
+// NewClockSanityCheck returns a ClockSanityCheck using the build date
+// embedded in the installer binary.
+func NewClockSanityCheck() ClockSanityCheck {
+	// This will fail for development builds, which don't have a build
+	// date, leaving it zero
+	buildDate, _ := time.Parse(time.RFC3339, version.BuildDate)
+	return ClockSanityCheck{
+		BuildDate:     buildDate,
+		MaxAfterBuild: DefaultClockMaxAfterBuild,
+	}
+}
+
+func (c ClockSanityCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c ClockSanityCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ClockSanity"
+	var msgs []string
+
+	current := env.host().now().UTC()
+	if !c.BuildDate.IsZero() {
+		if current.Before(c.BuildDate) {
+			result.Severity = SeverityFatal
+			msgs = append(msgs, fmt.Sprintf("The system clock is set to %s, which is before this installer was built (%s).",
+				current.Format(time.RFC3339), c.BuildDate.UTC().Format(time.DateOnly)))
+		} else if current.After(c.BuildDate.Add(c.MaxAfterBuild)) {
+			result.Severity = SeverityFatal
+			msgs = append(msgs, fmt.Sprintf("The system clock is set to %s, which is implausibly far after this installer was built (%s).",
+				current.Format(time.RFC3339), c.BuildDate.UTC().Format(time.DateOnly)))
+		}
+		if result.Severity == SeverityFatal {
+			msgs = append(msgs, "Please correct the clock in the firmware settings, as an incorrect clock will break certificate validation.")
+		}
+	}
+
+	local, err := rtcIsLocal(env)
+	if err != nil {
+		return
+	}
+	if local {
+		if result.Severity < SeverityWarning {
+			result.Severity = SeverityWarning
+		}
+		msgs = append(msgs, "The hardware clock is configured to keep local time, but SaftOS expects UTC. "+
+			"The system time will be wrong after each boot until NTP corrects it.")
+	}
+
+	result.Message = strings.Join(msgs, " ")
+	return
+}
+
+// rtcIsLocal reads the hardware clock mode from the third line of
+// /etc/adjtime.  If the file doesn't exist, the clock is taken to be UTC,
+// as it is by hwclock(8) and systemd.
+func rtcIsLocal(env *Env) (bool, error) {
+	out, err := env.readFile(env.host().etcAdjtime)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	lines := strings.Split(string(out), "\n")
+	return len(lines) >= 3 && strings.TrimSpace(lines[2]) == "LOCAL", nil
+}
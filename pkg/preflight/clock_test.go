@@ -0,0 +1,93 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockSanityCheck(t *testing.T) {
+	h := testHost()
+
+	buildDate := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	check := ClockSanityCheck{BuildDate: buildDate, MaxAfterBuild: DefaultClockMaxAfterBuild}
+
+	tests := []struct {
+		name     string
+		check    ClockSanityCheck
+		now      time.Time
+		adjtime  string
+		severity Severity
+		message  string
+	}{
+		{
+			name:     "ok",
+			check:    check,
+			now:      time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC),
+			adjtime:  "utc",
+			severity: SeverityOK,
+		},
+		{
+			name:     "no adjtime",
+			check:    check,
+			now:      time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC),
+			adjtime:  "missing",
+			severity: SeverityOK,
+		},
+		{
+			name:     "local rtc",
+			check:    check,
+			now:      time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC),
+			adjtime:  "local",
+			severity: SeverityWarning,
+			message: "The hardware clock is configured to keep local time, but SaftOS expects UTC. " +
+				"The system time will be wrong after each boot until NTP corrects it.",
+		},
+		{
+			name:     "before build",
+			check:    check,
+			now:      time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			adjtime:  "utc",
+			severity: SeverityFatal,
+			message: "The system clock is set to 2019-01-01T00:00:00Z, which is before this installer was built (2026-03-01). " +
+				"Please correct the clock in the firmware settings, as an incorrect clock will break certificate validation.",
+		},
+		{
+			name:     "far future and local rtc",
+			check:    check,
+			now:      time.Date(2037, 6, 1, 0, 0, 0, 0, time.UTC),
+			adjtime:  "local",
+			severity: SeverityFatal,
+			message: "The system clock is set to 2037-06-01T00:00:00Z, which is implausibly far after this installer was built (2026-03-01). " +
+				"Please correct the clock in the firmware settings, as an incorrect clock will break certificate validation. " +
+				"The hardware clock is configured to keep local time, but SaftOS expects UTC. " +
+				"The system time will be wrong after each boot until NTP corrects it.",
+		},
+		{
+			name:     "custom window",
+			check:    ClockSanityCheck{BuildDate: buildDate, MaxAfterBuild: 30 * 24 * time.Hour},
+			now:      time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC),
+			adjtime:  "utc",
+			severity: SeverityFatal,
+			message: "The system clock is set to 2026-10-16T09:00:00Z, which is implausibly far after this installer was built (2026-03-01). " +
+				"Please correct the clock in the firmware settings, as an incorrect clock will break certificate validation.",
+		},
+		{
+			name:     "no build date",
+			check:    ClockSanityCheck{},
+			now:      time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+			adjtime:  "utc",
+			severity: SeverityOK,
+		},
+	}
+
+	for _, test := range tests {
+		h.etcAdjtime = "./testdata/adjtime/" + test.adjtime
+		h.now = func() time.Time { return test.now }
+		result, err := test.check.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "ClockSanity", Severity: test.severity, Message: test.message}, result, test.name)
+	}
+}
@@ -0,0 +1,86 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// taintFirmwareWorkaround is the taint bit (I) set when the kernel works
+// around a platform firmware bug, which is often a broken TSC
+const taintFirmwareWorkaround = 11
+
+// ClocksourceCheck warns if an x86 host isn't using the TSC as its
+// clocksource, even though the TSC is available, because the fallbacks
+// (hpet, acpi_pm) cause timekeeping jitter for guests.  Any kernel
+// command line options or taints which explain why the kernel gave up on
+// the TSC are included.  Other architectures have their own preferred
+// clocksources, so the current one is just reported.  The clocksource is
+// recorded in the inventory.
+type ClocksourceCheck struct{}
+
+func (c ClocksourceCheck) readsFrom() []string {
+	return []string{"Cmdline"}
+}
+
+func (c ClocksourceCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Clocksource"
+	dir := filepath.Join(env.host().root, "sys/devices/system/clocksource/clocksource0")
+	current := env.readTrimmed(filepath.Join(dir, "current_clocksource"))
+	if current == "" {
+		return
+	}
+	env.Inventory.Clocksource = current
+	available := strings.Fields(env.readTrimmed(filepath.Join(dir, "available_clocksource")))
+
+	_, machine, err := env.host().unameRelease()
+	if err != nil {
+		return
+	}
+	result.Message = fmt.Sprintf("Clocksource is %s.", current)
+	if (machine != "x86_64" && machine != "i686") || current == "tsc" || !slices.Contains(available, "tsc") {
+		return
+	}
+
+	result.Severity = SeverityWarning
+	result.Message = fmt.Sprintf("Clocksource is %s, although tsc is available, which causes timekeeping jitter for guests.", current)
+	hints, err := c.tscHints(env)
+	if err != nil {
+		return
+	}
+	if len(hints) > 0 {
+		result.Message += fmt.Sprintf(" Possible causes: %s.", strings.Join(hints, ", "))
+	}
+	return
+}
+
+// tscHints returns the kernel command line options and taints which may
+// explain why the kernel isn't using the TSC.
+func (c ClocksourceCheck) tscHints(env *Env) ([]string, error) {
+	cmdline := env.Inventory.Cmdline
+	if cmdline == "" {
+		out, err := env.readFile(env.host().procCmdline)
+		if err != nil {
+			return nil, err
+		}
+		cmdline = string(out)
+	}
+
+	var hints []string
+	for _, param := range strings.Fields(cmdline) {
+		if param == "notsc" || strings.HasPrefix(param, "tsc=") || strings.HasPrefix(param, "clocksource=") {
+			hints = append(hints, fmt.Sprintf("the kernel command line has %s", param))
+		}
+	}
+
+	mask, err := readTaintMask(env)
+	if err != nil {
+		return nil, err
+	}
+	if mask&(1<<taintFirmwareWorkaround) != 0 {
+		hints = append(hints, "the kernel is working around a platform firmware bug")
+	}
+	return hints, nil
+}
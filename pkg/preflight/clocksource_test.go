@@ -0,0 +1,76 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClocksourceCheck(t *testing.T) {
+	h := testHost()
+
+	const jitter = "Clocksource is hpet, although tsc is available, which causes timekeeping jitter for guests."
+
+	tests := []struct {
+		fixture     string
+		machine     string
+		cmdline     string
+		severity    Severity
+		message     string
+		clocksource string
+	}{
+		{
+			fixture:     "tsc",
+			machine:     "x86_64",
+			message:     "Clocksource is tsc.",
+			clocksource: "tsc",
+		},
+		{
+			fixture:     "hpet-with-tsc-available",
+			machine:     "x86_64",
+			cmdline:     "BOOT_IMAGE=/boot/vmlinuz quiet",
+			severity:    SeverityWarning,
+			message:     jitter,
+			clocksource: "hpet",
+		},
+		{
+			fixture:     "hpet-with-tsc-available",
+			machine:     "x86_64",
+			cmdline:     "BOOT_IMAGE=/boot/vmlinuz tsc=unstable clocksource=hpet",
+			severity:    SeverityWarning,
+			message:     jitter + " Possible causes: the kernel command line has tsc=unstable, the kernel command line has clocksource=hpet.",
+			clocksource: "hpet",
+		},
+		{
+			fixture:     "hpet-firmware-bug",
+			machine:     "x86_64",
+			cmdline:     "BOOT_IMAGE=/boot/vmlinuz quiet",
+			severity:    SeverityWarning,
+			message:     jitter + " Possible causes: the kernel is working around a platform firmware bug.",
+			clocksource: "hpet",
+		},
+		{
+			fixture:     "hpet-only",
+			machine:     "x86_64",
+			message:     "Clocksource is hpet.",
+			clocksource: "hpet",
+		},
+		{
+			fixture:     "arm64",
+			machine:     "aarch64",
+			message:     "Clocksource is arch_sys_counter.",
+			clocksource: "arch_sys_counter",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/clocksource/" + test.fixture
+		h.unameRelease = func() (string, string, error) { return "6.4.0", test.machine, nil }
+		env := &Env{Inventory: Inventory{Cmdline: test.cmdline}, machine: h}
+		result, err := ClocksourceCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "Clocksource", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Equal(t, test.clocksource, env.Inventory.Clocksource, test.fixture)
+	}
+}
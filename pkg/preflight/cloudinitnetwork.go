@@ -0,0 +1,313 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	cloudInitConfigFile = "/etc/cloud/cloud.cfg"
+	cloudInitConfigDir  = "/etc/cloud/cloud.cfg.d/"
+	netplanDir          = "/etc/netplan/"
+	// cloudInitNetworkSeed is the name of a NoCloud seed's network
+	// configuration, which needn't be wrapped in a network key
+	cloudInitNetworkSeed = "network-config"
+)
+
+// CloudInitNetworkCheck verifies the network configuration the install
+// configuration embeds for cloud-init or netplan, by writing it with
+// os.write_files, against the host's interfaces.  Both version 1 and
+// version 2 configuration refer to interfaces by name, MAC address or
+// driver, and an interface nothing refers to is silently left
+// unconfigured, so a typo otherwise only shows up as a node without a
+// network after installation.  Each reference is resolved against the
+// inventory, collecting the host's interfaces if no earlier check has.
+// A reference which matches no interface is fatal, with a suggestion for
+// likely typos, and one which matches several, such as a MAC address glob,
+// is warned about, since only one of them is configured.  Configurations
+// without embedded network configuration pass without comment.
+type CloudInitNetworkCheck struct {
+	Files []config.File
+}
+
+// NewCloudInitNetworkCheck returns a CloudInitNetworkCheck for the files
+// the given install configuration writes.
+func NewCloudInitNetworkCheck(cfg *config.HarvesterConfig) CloudInitNetworkCheck {
+	return CloudInitNetworkCheck{Files: cfg.OS.WriteFiles}
+}
+
+// cloudInitNetwork is network configuration in version 1 or 2 of
+// cloud-init's format, the latter being netplan's, reduced to what refers
+// to physical interfaces.
+type cloudInitNetwork struct {
+	Version int `yaml:"version"`
+	// Config is the version 1 list of interfaces and other objects, or
+	// "disabled"
+	Config yaml.Node `yaml:"config"`
+	// Ethernets are the version 2 physical interfaces, by ID
+	Ethernets map[string]struct {
+		Match *cloudInitV2Match `yaml:"match"`
+	} `yaml:"ethernets"`
+}
+
+// A cloudInitV2Match selects the physical interfaces a version 2 ethernet
+// configures.
+type cloudInitV2Match struct {
+	Name       string `yaml:"name"`
+	MACAddress string `yaml:"macaddress"`
+	Driver     string `yaml:"driver"`
+}
+
+// A cloudInitV1Interface is an entry of version 1 configuration.
+type cloudInitV1Interface struct {
+	Type       string `yaml:"type"`
+	Name       string `yaml:"name"`
+	MACAddress string `yaml:"mac_address"`
+}
+
+// An interfaceReference is a reference to physical interfaces by network
+// configuration.  Each criterion set must match, and may be a glob.
+type interfaceReference struct {
+	// source is where the reference is configured, for messages
+	source     string
+	name       string
+	macAddress string
+	driver     string
+}
+
+func (c CloudInitNetworkCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "CloudInitNetwork"
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+	defer func() { result.Message = strings.Join(findings, " ") }()
+
+	var refs []interfaceReference
+	for i, file := range c.Files {
+		if !embedsNetworkConfig(file.Path) {
+			continue
+		}
+		source := fmt.Sprintf("os.write_files[%d] (%s)", i, file.Path)
+		content, err := writeFileContent(file)
+		if err != nil {
+			finding(SeverityWarning, "%s cannot be decoded: %v.", source, err)
+			continue
+		}
+		network, prefix, err := parseCloudInitNetwork(file.Path, content)
+		if err != nil {
+			finding(SeverityWarning, "%s is not valid YAML: %v.", source, err)
+			continue
+		}
+		if network == nil {
+			continue
+		}
+		fileRefs, problem := network.references(source + " " + prefix)
+		if problem != "" {
+			finding(SeverityWarning, "%s: %s.", source, problem)
+		}
+		refs = append(refs, fileRefs...)
+	}
+	if len(refs) == 0 {
+		return
+	}
+
+	if env.Inventory.NICs == nil {
+		if env.Inventory.NICs, err = env.listNICs(); err != nil {
+			return result, err
+		}
+	}
+	if len(env.Inventory.NICs) == 0 {
+		finding(SeverityOK, "Skipped: no network interfaces were found to resolve the embedded network configuration against.")
+		return
+	}
+	for _, ref := range refs {
+		var matched []string
+		for _, nic := range env.Inventory.NICs {
+			if ref.matches(nic) {
+				matched = append(matched, nic.Name)
+			}
+		}
+		switch len(matched) {
+		case 0:
+			entry := fmt.Sprintf("%s: %s matches no interface on this host", ref.source, ref)
+			if suggestions := ref.suggestions(env.Inventory.NICs); len(suggestions) > 0 {
+				entry += fmt.Sprintf(" (did you mean %s?)", joinWithAnd(suggestions))
+			}
+			finding(SeverityFatal, "%s.", entry)
+		case 1:
+			finding(SeverityOK, "%s: %s is %s.", ref.source, ref, matched[0])
+		default:
+			finding(SeverityWarning, "%s: %s matches %s, but only one of them is configured.",
+				ref.source, ref, joinWithAnd(matched))
+		}
+	}
+	return
+}
+
+// embedsNetworkConfig returns whether a file written to path may hold
+// network configuration for cloud-init or netplan.
+func embedsNetworkConfig(file string) bool {
+	switch {
+	case file == cloudInitConfigFile, path.Base(file) == cloudInitNetworkSeed:
+		return true
+	case strings.HasPrefix(file, cloudInitConfigDir):
+		return path.Ext(file) == ".cfg"
+	case strings.HasPrefix(file, netplanDir):
+		return slices.Contains([]string{".yaml", ".yml"}, path.Ext(file))
+	}
+	return false
+}
+
+// parseCloudInitNetwork returns the network configuration in a file
+// written to file, if any, and the prefix of the configuration paths
+// within it.
+func parseCloudInitNetwork(file, content string) (*cloudInitNetwork, string, error) {
+	var wrapped struct {
+		Network *cloudInitNetwork `yaml:"network"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &wrapped); err != nil {
+		return nil, "", err
+	}
+	if wrapped.Network != nil {
+		return wrapped.Network, "network.", nil
+	}
+	if path.Base(file) != cloudInitNetworkSeed {
+		return nil, "", nil
+	}
+	var network cloudInitNetwork
+	if err := yaml.Unmarshal([]byte(content), &network); err != nil {
+		return nil, "", err
+	}
+	if network.Version == 0 {
+		return nil, "", nil
+	}
+	return &network, "", nil
+}
+
+// references returns the references to physical interfaces in the
+// network configuration, with sources prefixed by prefix, or a problem
+// which stops cloud-init applying it.
+func (n cloudInitNetwork) references(prefix string) ([]interfaceReference, string) {
+	if n.Config.Kind == yaml.ScalarNode && n.Config.Value == "disabled" {
+		return nil, ""
+	}
+	var refs []interfaceReference
+	switch n.Version {
+	case 1:
+		var entries []cloudInitV1Interface
+		if n.Config.Kind == 0 {
+			break
+		}
+		if err := n.Config.Decode(&entries); err != nil {
+			return nil, fmt.Sprintf("the version 1 network configuration is not a list of interfaces: %v", err)
+		}
+		for i, entry := range entries {
+			ref := interfaceReference{source: fmt.Sprintf("%sconfig[%d]", prefix, i)}
+			switch {
+			case entry.Type != "physical":
+				continue
+			case entry.MACAddress != "":
+				// The name is what the interface is renamed to
+				ref.macAddress = entry.MACAddress
+			case entry.Name != "":
+				ref.name = entry.Name
+			default:
+				continue
+			}
+			refs = append(refs, ref)
+		}
+	case 2:
+		ids := make([]string, 0, len(n.Ethernets))
+		for id := range n.Ethernets {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		for _, id := range ids {
+			match := n.Ethernets[id].Match
+			if match == nil || *match == (cloudInitV2Match{}) {
+				// Without a match, the ID is the interface's name
+				refs = append(refs, interfaceReference{source: prefix + "ethernets." + id, name: id})
+				continue
+			}
+			refs = append(refs, interfaceReference{
+				source:     prefix + "ethernets." + id + ".match",
+				name:       match.Name,
+				macAddress: match.MACAddress,
+				driver:     match.Driver,
+			})
+		}
+	default:
+		return nil, fmt.Sprintf("network configuration version %d is not supported, so cloud-init ignores it", n.Version)
+	}
+	return refs, ""
+}
+
+func (r interfaceReference) String() string {
+	var criteria []string
+	if r.name != "" {
+		criteria = append(criteria, "name "+r.name)
+	}
+	if r.macAddress != "" {
+		criteria = append(criteria, "MAC address "+r.macAddress)
+	}
+	if r.driver != "" {
+		criteria = append(criteria, "driver "+r.driver)
+	}
+	return strings.Join(criteria, " and ")
+}
+
+// matches returns whether nic meets every criterion of the reference.
+func (r interfaceReference) matches(nic NIC) bool {
+	return globMatches(r.name, nic.Name) &&
+		globMatches(strings.ToLower(r.macAddress), strings.ToLower(nic.HwAddr)) &&
+		globMatches(r.driver, nic.Driver)
+}
+
+// suggestions returns the closest values on this host to each criterion
+// of the reference which no interface meets.
+func (r interfaceReference) suggestions(nics []NIC) []string {
+	var suggestions []string
+	for _, criterion := range []struct {
+		label   string
+		pattern string
+		only    interfaceReference
+		value   func(NIC) string
+	}{
+		{"name", r.name, interfaceReference{name: r.name}, func(nic NIC) string { return nic.Name }},
+		{"MAC address", r.macAddress, interfaceReference{macAddress: r.macAddress}, func(nic NIC) string { return nic.HwAddr }},
+		{"driver", r.driver, interfaceReference{driver: r.driver}, func(nic NIC) string { return nic.Driver }},
+	} {
+		if criterion.pattern == "" || slices.ContainsFunc(nics, criterion.only.matches) {
+			continue
+		}
+		var values []string
+		for _, nic := range nics {
+			if value := criterion.value(nic); value != "" && !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+		if closest := closestMatch(criterion.pattern, values); closest != "" {
+			suggestions = append(suggestions, criterion.label+" "+closest)
+		}
+	}
+	return suggestions
+}
+
+// globMatches returns whether value matches pattern, a shell glob, which
+// matches anything if empty.
+func globMatches(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
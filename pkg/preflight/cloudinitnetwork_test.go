@@ -0,0 +1,119 @@
+package preflight
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func loadCloudInitNetworkCheck(t *testing.T, fixture string) CloudInitNetworkCheck {
+	data, err := os.ReadFile("./testdata/cloud-init-network/" + fixture)
+	assert.Nil(t, err)
+	cfg, err := config.LoadHarvesterConfig(data)
+	assert.Nil(t, err)
+	return NewCloudInitNetworkCheck(cfg)
+}
+
+func TestCloudInitNetworkCheck(t *testing.T) {
+	nics := []NIC{
+		{Name: "eno1", HwAddr: "3c:ec:ef:12:34:56", Driver: "ixgbe"},
+		{Name: "eno2", HwAddr: "3c:ec:ef:12:34:57", Driver: "ixgbe"},
+		{Name: "ens1f0", HwAddr: "b8:59:9f:aa:00:10", Driver: "mlx5_core"},
+	}
+	netplan := func(content string) []config.File {
+		return []config.File{{Path: "/etc/netplan/01-netcfg.yaml", Content: content}}
+	}
+
+	tests := []struct {
+		name     string
+		check    CloudInitNetworkCheck
+		nics     []NIC
+		severity Severity
+		message  string
+	}{
+		{
+			name:     "version 1",
+			check:    loadCloudInitNetworkCheck(t, "v1.yaml"),
+			nics:     nics,
+			severity: SeverityFatal,
+			message: "os.write_files[1] (/etc/cloud/cloud.cfg.d/90-network.cfg) network.config[0]: MAC address 3C:EC:EF:12:34:56 is eno1. " +
+				"os.write_files[1] (/etc/cloud/cloud.cfg.d/90-network.cfg) network.config[1]: name eno3 matches no interface on this host " +
+				"(did you mean name eno1?). " +
+				"os.write_files[1] (/etc/cloud/cloud.cfg.d/90-network.cfg) network.config[2]: MAC address 3c:ec:ef:12:34:5? matches eno1 and eno2, " +
+				"but only one of them is configured. " +
+				"os.write_files[2] (/var/lib/cloud/seed/nocloud/network-config) config[0]: MAC address 3c:ec:ef:12:43:56 matches no interface on this host " +
+				"(did you mean MAC address 3c:ec:ef:12:34:56?).",
+		},
+		{
+			name:     "version 2",
+			check:    loadCloudInitNetworkCheck(t, "v2.yaml"),
+			nics:     nics,
+			severity: SeverityFatal,
+			message: "os.write_files[0] (/etc/netplan/50-cloud-init.yaml) network.ethernets.eno1: name eno1 is eno1. " +
+				"os.write_files[0] (/etc/netplan/50-cloud-init.yaml) network.ethernets.lan.match: MAC address 3c:ec:ef:12:34:* matches eno1 and eno2, " +
+				"but only one of them is configured. " +
+				"os.write_files[0] (/etc/netplan/50-cloud-init.yaml) network.ethernets.storage.match: name ens1f* and driver mlx5_core is ens1f0. " +
+				"os.write_files[0] (/etc/netplan/50-cloud-init.yaml) network.ethernets.uplink.match: driver ixgbee matches no interface on this host " +
+				"(did you mean driver ixgbe?). " +
+				"os.write_files[0] (/etc/netplan/50-cloud-init.yaml) network.ethernets.wan.match: name enp5s0 matches no interface on this host.",
+		},
+		{
+			name: "good, base64",
+			check: CloudInitNetworkCheck{Files: []config.File{{
+				Path:     "/etc/netplan/01-netcfg.yaml",
+				Encoding: "b64",
+				Content: base64.StdEncoding.EncodeToString([]byte(
+					"network:\n  version: 2\n  ethernets:\n    mgmt:\n      match:\n        macaddress: 3C:EC:EF:12:34:57\n")),
+			}}},
+			nics:    nics,
+			message: "os.write_files[0] (/etc/netplan/01-netcfg.yaml) network.ethernets.mgmt.match: MAC address 3C:EC:EF:12:34:57 is eno2.",
+		},
+		{
+			name: "no embedded network configuration",
+			check: CloudInitNetworkCheck{Files: []config.File{
+				{Path: "/etc/sysconfig/network/routes", Content: "default 10.0.0.1 - -\n"},
+				{Path: "/etc/cloud/cloud.cfg.d/99-datasource.cfg", Content: "datasource_list: [NoCloud]\n"},
+			}},
+			nics: nics,
+		},
+		{
+			name:  "disabled",
+			check: CloudInitNetworkCheck{Files: []config.File{{Path: "/etc/cloud/cloud.cfg.d/99-disable-network.cfg", Content: "network: {config: disabled}\n"}}},
+			nics:  nics,
+		},
+		{
+			name:     "unsupported version",
+			check:    CloudInitNetworkCheck{Files: netplan("network:\n  version: 3\n")},
+			nics:     nics,
+			severity: SeverityWarning,
+			message:  "os.write_files[0] (/etc/netplan/01-netcfg.yaml): network configuration version 3 is not supported, so cloud-init ignores it.",
+		},
+		{
+			name:     "invalid YAML",
+			check:    CloudInitNetworkCheck{Files: netplan("network: [\n")},
+			nics:     nics,
+			severity: SeverityWarning,
+			message:  "os.write_files[0] (/etc/netplan/01-netcfg.yaml) is not valid YAML: yaml: line 1: did not find expected node content.",
+		},
+		{
+			name:    "no interfaces",
+			check:   CloudInitNetworkCheck{Files: netplan("network:\n  version: 2\n  ethernets:\n    eno1: {}\n")},
+			nics:    []NIC{},
+			message: "Skipped: no network interfaces were found to resolve the embedded network configuration against.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &Env{Inventory: Inventory{NICs: tt.nics}}
+			result, err := tt.check.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "CloudInitNetwork", Severity: tt.severity, Message: tt.message}, result)
+		})
+	}
+}
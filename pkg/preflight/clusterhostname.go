@@ -0,0 +1,143 @@
+package preflight
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// clusterNodesPath is where the join server lists the cluster's nodes,
+// for clients with an API token
+const clusterNodesPath = "v1/nodes"
+
+// A ClusterNode is a member of the cluster being joined.
+type ClusterNode struct {
+	Name string
+	// Deleting means the node is being deleted, so its name will be free.
+	Deleting bool
+	// Unknown means the cluster has lost contact with the node, so it
+	// may be gone for good, or may come back.
+	Unknown bool
+}
+
+// ClusterHostnameCheck verifies, when joining an existing cluster, that
+// no member of it already has the configured hostname.  Otherwise the new
+// node takes over the old one's node object, and the two fight over it.
+// The members are Options.ClusterNodes if set, or else they're listed by
+// the join server, which needs Options.ClusterAPIToken.  The token is
+// only sent if the server's certificate is trusted.  A member being
+// deleted doesn't count, and one the cluster has lost contact with is
+// only warned about.  If the members can't be listed, the check is
+// skipped, because JoinCheck reports why the server is unreachable.
+type ClusterHostnameCheck struct {
+	Join     JoinCheck
+	Hostname string
+}
+
+// NewClusterHostnameCheck returns a ClusterHostnameCheck for the given
+// install configuration.
+func NewClusterHostnameCheck(cfg *config.HarvesterConfig) ClusterHostnameCheck {
+	return ClusterHostnameCheck{Join: NewJoinCheck(cfg), Hostname: cfg.OS.Hostname}
+}
+
+func (c ClusterHostnameCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "ClusterHostname"
+	if c.Join.Mode != config.ModeJoin {
+		return
+	}
+	if c.Hostname == "" {
+		result.Message = "Skipped: the install configuration does not set a hostname."
+		return
+	}
+
+	nodes := env.Options.ClusterNodes
+	if nodes == nil {
+		if env.Options.ClusterAPIToken == "" {
+			result.Message = "Skipped: the cluster's nodes cannot be listed without an API token."
+			return
+		}
+		if nodes, err = c.clusterNodes(ctx, env.Options.ClusterAPIToken); err != nil {
+			result.Message = fmt.Sprintf("Skipped: unable to list the cluster's nodes: %v.", redactedError(err))
+			return result, nil
+		}
+	}
+
+	i := slices.IndexFunc(nodes, func(node ClusterNode) bool { return strings.EqualFold(node.Name, c.Hostname) })
+	switch {
+	case i < 0:
+		result.Message = fmt.Sprintf("No member of the cluster is named %s.", c.Hostname)
+	case nodes[i].Deleting:
+		result.Severity = SeverityInfo
+		result.Message = fmt.Sprintf("The cluster's node %s is being deleted, so its name can be reused once that's done.", nodes[i].Name)
+	case nodes[i].Unknown:
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("The cluster already has a node named %s, though it has lost contact with it. "+
+			"If that node is gone for good, delete it from the cluster first; otherwise, choose another hostname.", nodes[i].Name)
+	default:
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("The cluster already has a node named %s. Please choose another hostname.", nodes[i].Name)
+	}
+	return
+}
+
+// clusterNodes lists the cluster's nodes through the join server.
+func (c ClusterHostnameCheck) clusterNodes(ctx context.Context, token string) ([]ClusterNode, error) {
+	server, reason := parseServerURL(c.Join.ServerURL)
+	if reason != "" {
+		return nil, fmt.Errorf("the server URL is not valid: %s", reason)
+	}
+	roots, _ := rootCAs(c.Join.CACerts)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.JoinPath(clusterNodesPath).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.Join.client(&tls.Config{RootCAs: roots}, c.Join.Proxy.ProxyFunc()).Do(req)
+	if certErr := certificateError(err); certErr != nil {
+		return nil, errors.New("the join server's certificate is not trusted, so the API token was not sent")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /%s returned %s", clusterNodesPath, resp.Status)
+	}
+
+	var list struct {
+		Data []struct {
+			Metadata struct {
+				Name              string  `json:"name"`
+				DeletionTimestamp *string `json:"deletionTimestamp"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&list); err != nil {
+		return nil, fmt.Errorf("GET /%s returned an invalid node list: %w", clusterNodesPath, err)
+	}
+	nodes := make([]ClusterNode, 0, len(list.Data))
+	for _, item := range list.Data {
+		node := ClusterNode{Name: item.Metadata.Name, Deleting: item.Metadata.DeletionTimestamp != nil, Unknown: true}
+		for _, condition := range item.Status.Conditions {
+			if condition.Type == "Ready" {
+				node.Unknown = condition.Status == "Unknown"
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
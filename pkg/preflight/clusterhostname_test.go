@@ -0,0 +1,159 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const testClusterAPIToken = "token-abcde:secret"
+
+func TestClusterHostnameCheck(t *testing.T) {
+	cluster := newTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/nodes" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+testClusterAPIToken {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"type":"collection","data":[
+			{"id":"node-1","metadata":{"name":"node-1"},"status":{"conditions":[{"type":"Ready","status":"True"}]}},
+			{"id":"node-2","metadata":{"name":"node-2","deletionTimestamp":"2026-10-16T08:00:00Z"},"status":{"conditions":[{"type":"Ready","status":"False"}]}},
+			{"id":"node-3","metadata":{"name":"node-3"},"status":{"conditions":[{"type":"Ready","status":"Unknown"}]}}]}`)
+	}))
+	defer cluster.Close()
+	broken := newTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	tests := []struct {
+		name     string
+		mode     string
+		hostname string
+		server   string
+		caCerts  string
+		nodes    []ClusterNode
+		token    string
+		severity Severity
+		message  string
+	}{
+		{
+			name:     "collision",
+			hostname: "node-1",
+			server:   cluster.URL,
+			caCerts:  certPEM(cluster),
+			token:    testClusterAPIToken,
+			severity: SeverityFatal,
+			message:  "The cluster already has a node named node-1. Please choose another hostname.",
+		},
+		{
+			name:     "collision ignoring case",
+			hostname: "Node-1",
+			server:   cluster.URL,
+			caCerts:  certPEM(cluster),
+			token:    testClusterAPIToken,
+			severity: SeverityFatal,
+			message:  "The cluster already has a node named node-1. Please choose another hostname.",
+		},
+		{
+			name:     "no collision",
+			hostname: "node-4",
+			server:   cluster.URL,
+			caCerts:  certPEM(cluster),
+			token:    testClusterAPIToken,
+			message:  "No member of the cluster is named node-4.",
+		},
+		{
+			name:     "deleting",
+			hostname: "node-2",
+			server:   cluster.URL,
+			caCerts:  certPEM(cluster),
+			token:    testClusterAPIToken,
+			severity: SeverityInfo,
+			message:  "The cluster's node node-2 is being deleted, so its name can be reused once that's done.",
+		},
+		{
+			name:     "unknown",
+			hostname: "node-3",
+			server:   cluster.URL,
+			caCerts:  certPEM(cluster),
+			token:    testClusterAPIToken,
+			severity: SeverityWarning,
+			message: "The cluster already has a node named node-3, though it has lost contact with it. " +
+				"If that node is gone for good, delete it from the cluster first; otherwise, choose another hostname.",
+		},
+		{
+			name:     "known nodes",
+			hostname: "node-5",
+			nodes:    []ClusterNode{{Name: "node-5"}},
+			severity: SeverityFatal,
+			message:  "The cluster already has a node named node-5. Please choose another hostname.",
+		},
+		{
+			name:     "no token",
+			hostname: "node-1",
+			server:   cluster.URL,
+			caCerts:  certPEM(cluster),
+			message:  "Skipped: the cluster's nodes cannot be listed without an API token.",
+		},
+		{
+			name:     "rejected token",
+			hostname: "node-1",
+			server:   cluster.URL,
+			caCerts:  certPEM(cluster),
+			token:    "token-abcde:wrong",
+			message:  "Skipped: unable to list the cluster's nodes: GET /v1/nodes returned 401 Unauthorized.",
+		},
+		{
+			name:     "query failure",
+			hostname: "node-1",
+			server:   broken.URL,
+			caCerts:  certPEM(broken),
+			token:    testClusterAPIToken,
+			message:  "Skipped: unable to list the cluster's nodes: GET /v1/nodes returned 500 Internal Server Error.",
+		},
+		{
+			name:     "untrusted server",
+			hostname: "node-1",
+			server:   cluster.URL,
+			token:    testClusterAPIToken,
+			message:  "Skipped: unable to list the cluster's nodes: the join server's certificate is not trusted, so the API token was not sent.",
+		},
+		{
+			name:    "no hostname",
+			server:  cluster.URL,
+			caCerts: certPEM(cluster),
+			token:   testClusterAPIToken,
+			message: "Skipped: the install configuration does not set a hostname.",
+		},
+		{
+			name:     "create mode",
+			mode:     config.ModeCreate,
+			hostname: "node-1",
+			nodes:    []ClusterNode{{Name: "node-1"}},
+		},
+	}
+
+	for _, test := range tests {
+		mode := test.mode
+		if mode == "" {
+			mode = config.ModeJoin
+		}
+		check := ClusterHostnameCheck{
+			Join:     JoinCheck{Mode: mode, ServerURL: test.server, Token: "token", CACerts: test.caCerts},
+			Hostname: test.hostname,
+		}
+		env := &Env{Options: Options{ClusterNodes: test.nodes, ClusterAPIToken: test.token}}
+		result, err := check.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "ClusterHostname", Severity: test.severity, Message: test.message}, result, test.name)
+	}
+}
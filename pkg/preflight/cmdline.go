@@ -0,0 +1,104 @@
+package preflight
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rules/cmdline.yaml
+var defaultCmdlineRules []byte
+
+// A CmdlineRule is a finding for a kernel command line parameter which
+// matches Param (in path.Match syntax).
+type CmdlineRule struct {
+	Param    string   `yaml:"param"`
+	Severity Severity `yaml:"severity"`
+	Message  string   `yaml:"message"`
+}
+
+// DefaultCmdlineRules returns the rules shipped in rules/cmdline.yaml.
+func DefaultCmdlineRules() ([]CmdlineRule, error) {
+	var rules []CmdlineRule
+	if err := yaml.Unmarshal(defaultCmdlineRules, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse kernel command line rules: %w", err)
+	}
+	return rules, nil
+}
+
+// CmdlineCheck looks for kernel command line parameters, often inherited
+// from PXE templates, which change how the system behaves in ways that
+// should be brought to the user's attention.  The command line is
+// recorded in the inventory, along with the number of CPUs isolated by
+// isolcpus or nohz_full, so that CPUCheck doesn't count them.
+type CmdlineCheck struct {
+	// Rules overrides DefaultCmdlineRules, if set.
+	Rules []CmdlineRule
+}
+
+func (c CmdlineCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Cmdline"
+	rules := c.Rules
+	if rules == nil {
+		if rules, err = DefaultCmdlineRules(); err != nil {
+			return
+		}
+	}
+
+	out, err := env.readFile(env.host().procCmdline)
+	if err != nil {
+		return
+	}
+	cmdline := strings.TrimSpace(string(out))
+	env.Inventory.Cmdline = cmdline
+
+	isolated := map[int]bool{}
+	var msgs []string
+	for _, param := range strings.Fields(cmdline) {
+		if value, ok := strings.CutPrefix(param, "isolcpus="); ok {
+			addCPUList(isolated, value)
+		} else if value, ok := strings.CutPrefix(param, "nohz_full="); ok {
+			addCPUList(isolated, value)
+		}
+		for _, rule := range rules {
+			if matched, _ := path.Match(rule.Param, param); !matched {
+				continue
+			}
+			if rule.Severity > result.Severity {
+				result.Severity = rule.Severity
+			}
+			msgs = append(msgs, fmt.Sprintf("%s: %s", param, rule.Message))
+			break
+		}
+	}
+	env.Inventory.IsolatedCPUs = len(isolated)
+	result.Message = strings.Join(msgs, " ")
+	return
+}
+
+// addCPUList adds the CPUs in a kernel cpu list (e.g. "2-7,10") to cpus.
+// isolcpus may have flags before the list (e.g. "domain,managed_irq,2-7"),
+// which are skipped.
+func addCPUList(cpus map[int]bool, list string) {
+	for _, item := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil {
+				continue
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus[cpu] = true
+		}
+	}
+}
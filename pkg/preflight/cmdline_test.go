@@ -0,0 +1,70 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultCmdlineRules(t *testing.T) {
+	rules, err := DefaultCmdlineRules()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, rules)
+	for _, rule := range rules {
+		assert.Contains(t, []Severity{SeverityInfo, SeverityWarning}, rule.Severity, rule.Param)
+		assert.NotEmpty(t, rule.Message, rule.Param)
+	}
+}
+
+func TestCmdlineCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture  string
+		rules    []CmdlineRule
+		severity Severity
+		message  string
+		isolated int
+	}{
+		{
+			fixture: "clean",
+		},
+		{
+			fixture:  "tuned",
+			severity: SeverityWarning,
+			message: "mitigations=off: CPU vulnerability mitigations are disabled, which exposes VMs to side-channel attacks from each other. " +
+				"isolcpus=domain,managed_irq,2-5: Some CPUs are isolated from the scheduler, and won't be available for workloads. " +
+				"nohz_full=4-7: Some CPUs run without the scheduler tick, and won't be available for general workloads. " +
+				"nomodeset: Kernel mode setting is disabled, so GPU drivers may not load, and the GPU check may not reflect the installed system.",
+			isolated: 6,
+		},
+		{
+			fixture:  "iommu-off",
+			severity: SeverityWarning,
+			message: "intel_iommu=off: The IOMMU is disabled, so PCI passthrough will not work. " +
+				"ipv6.disable=1: IPv6 is disabled, which breaks components that expect to be able to bind to IPv6 addresses.",
+		},
+		{
+			fixture:  "tuned",
+			rules:    []CmdlineRule{{Param: "console=ttyS*", Severity: SeverityInfo, Message: "Serial console."}},
+			severity: SeverityInfo,
+			message:  "console=ttyS0,115200: Serial console.",
+			isolated: 6,
+		},
+		{
+			fixture: "iommu-off",
+			rules:   []CmdlineRule{},
+		},
+	}
+
+	for _, test := range tests {
+		h.procCmdline = "./testdata/cmdline/" + test.fixture
+		env := &Env{machine: h}
+		result, err := CmdlineCheck{Rules: test.rules}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "Cmdline", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Contains(t, env.Inventory.Cmdline, "BOOT_IMAGE=", test.fixture)
+		assert.Equal(t, test.isolated, env.Inventory.IsolatedCPUs, test.fixture)
+	}
+}
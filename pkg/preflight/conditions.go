@@ -0,0 +1,189 @@
+package preflight
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultNodeStatusPath is where the report is persisted as node
+	// conditions and annotations in the live environment.  The installer
+	// copies it to InstalledNodeStatusPath on the target, since the live
+	// environment's /var/log doesn't survive the reboot.
+	DefaultNodeStatusPath = "/var/log/saftos-preflight-node.json"
+	// InstalledNodeStatusPath is where the installed system has the node
+	// status, for the post-install agent to apply to the node once it has
+	// joined the cluster.
+	InstalledNodeStatusPath = "/oem/install/saftos-preflight-node.json"
+
+	// DefaultAnnotationLimit is the most bytes the annotation may take.
+	// Kubernetes allows 256KiB for all of an object's annotations, which
+	// other components need too.
+	DefaultAnnotationLimit = 16 << 10
+
+	// AnnotationKey is the node annotation the compact report is
+	// published under.
+	AnnotationKey = "harvesterhci.io/preflight"
+
+	// conditionTypePrefix is prepended to check names to make condition
+	// types which won't collide with those of the kubelet, e.g.
+	// "PreflightMemory".
+	conditionTypePrefix = "Preflight"
+
+	// maxAnnotationMessage is the most bytes of each result's message the
+	// annotation keeps.  The full messages are in the conditions.
+	maxAnnotationMessage = 256
+
+	// truncationMarker ends messages which have been cut short.
+	truncationMarker = "…"
+)
+
+// A ConditionStatus is whether a Condition holds.
+type ConditionStatus string
+
+const (
+	ConditionTrue    ConditionStatus = "True"
+	ConditionFalse   ConditionStatus = "False"
+	ConditionUnknown ConditionStatus = "Unknown"
+)
+
+// A Condition is a check's result in the shape of a Kubernetes condition,
+// so it can be attached to the node and queried with kubectl.  The JSON
+// encoding matches metav1.Condition's.
+type Condition struct {
+	Type               string          `json:"type"`
+	Status             ConditionStatus `json:"status"`
+	LastTransitionTime time.Time       `json:"lastTransitionTime"`
+	Reason             string          `json:"reason"`
+	Message            string          `json:"message"`
+}
+
+// A NodeStatus is what the post-install agent applies to the node.
+type NodeStatus struct {
+	Conditions  []Condition       `json:"conditions"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Conditions returns a condition for each result, with the run's
+// timestamp.  The status says whether the node is fit to run as far as
+// the check is concerned, and the reason says how sure that is:
+//
+//   - Passed and info results are True, with reason Passed or Noted.
+//   - Warnings are also True, with reason Warning, because the node
+//     works, just not as well as it should.  So "status=False" only finds
+//     checks the node really failed.
+//   - Fatal results are False, with reason Failed, or Overridden if the
+//     installation went ahead regardless.
+//   - Skipped checks, and those which failed to run, are Unknown, with
+//     reason Skipped or CheckError.
+//
+// Checks which didn't apply, and so had nothing to say, are left out.
+func (r Report) Conditions() []Condition {
+	conditions := []Condition{}
+	for _, result := range r.Results {
+		condition := Condition{
+			Type:               conditionTypePrefix + result.Name,
+			LastTransitionTime: r.Timestamp,
+			Message:            result.Message,
+		}
+		switch {
+		case result.Error != "":
+			condition.Status, condition.Reason, condition.Message = ConditionUnknown, "CheckError", result.Error
+		case result.Message == "":
+			continue
+		case strings.HasPrefix(result.Message, "Skipped:"):
+			condition.Status, condition.Reason = ConditionUnknown, "Skipped"
+		case result.Severity == SeverityFatal && result.Overridden:
+			condition.Status, condition.Reason = ConditionFalse, "Overridden"
+		case result.Severity == SeverityFatal:
+			condition.Status, condition.Reason = ConditionFalse, "Failed"
+		case result.Severity == SeverityWarning:
+			condition.Status, condition.Reason = ConditionTrue, "Warning"
+		case result.Severity == SeverityInfo:
+			condition.Status, condition.Reason = ConditionTrue, "Noted"
+		default:
+			condition.Status, condition.Reason = ConditionTrue, "Passed"
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
+
+// annotation is the compact form of a report.  Passed results are only
+// counted.  The others are listed, most severe first, with messages cut
+// to maxAnnotationMessage, and without their Evidence.  If they still don't fit the limit, the least
+// severe are dropped, and counted in Omitted.
+type annotation struct {
+	Mode      RunMode          `json:"mode"`
+	Timestamp time.Time        `json:"timestamp"`
+	Counts    map[Severity]int `json:"counts"`
+	Results   []Result         `json:"results,omitempty"`
+	Omitted   int              `json:"omitted,omitempty"`
+}
+
+// Annotation returns the report as compact JSON of at most limit bytes.
+func (r Report) Annotation(limit int) (string, error) {
+	a := annotation{Mode: r.Mode, Timestamp: r.Timestamp, Counts: map[Severity]int{}}
+	for _, result := range r.Results {
+		a.Counts[result.Severity]++
+		if result.Severity == SeverityOK && result.Error == "" {
+			continue
+		}
+		result.Message = truncate(result.Message, maxAnnotationMessage)
+		result.Error = truncate(result.Error, maxAnnotationMessage)
+		result.Evidence = nil
+		a.Results = append(a.Results, result)
+	}
+	slices.SortStableFunc(a.Results, func(x, y Result) int { return int(y.Severity) - int(x.Severity) })
+
+	for {
+		out, err := json.Marshal(a)
+		if err != nil {
+			return "", err
+		}
+		if len(out) <= limit {
+			return string(out), nil
+		}
+		if len(a.Results) == 0 {
+			return "", fmt.Errorf("the preflight annotation does not fit in %d bytes", limit)
+		}
+		a.Results = a.Results[:len(a.Results)-1]
+		a.Omitted++
+	}
+}
+
+// truncate cuts s to at most n bytes, without splitting a character, and
+// marks it as cut if it was.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - len(truncationMarker)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + truncationMarker
+}
+
+// WriteNodeStatusFile persists the report as node conditions and an
+// annotation of at most annotationLimit bytes.
+func (r Report) WriteNodeStatusFile(path string, annotationLimit int) error {
+	value, err := r.Annotation(annotationLimit)
+	if err != nil {
+		return err
+	}
+	status := NodeStatus{
+		Conditions:  r.Conditions(),
+		Annotations: map[string]string{AnnotationKey: value},
+	}
+	out, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0600)
+}
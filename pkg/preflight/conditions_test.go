@@ -0,0 +1,99 @@
+package preflight
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportConditions(t *testing.T) {
+	report := Report{Timestamp: testRunTime, Results: []Result{
+		{Name: "CPU", Message: "Enough CPUs."},
+		{Name: "GPU", Severity: SeverityInfo, Message: "GPUs: 0000:3b:00.0 NVIDIA Tesla T4."},
+		{Name: "Memory", Severity: SeverityWarning, Message: "Not much memory."},
+		{Name: "Residue", Severity: SeverityFatal, Message: "/dev/sda has data on it."},
+		{Name: "BootMode", Severity: SeverityFatal, Message: "Legacy boot.", Overridden: true},
+		{Name: "SSHKey", Message: "Skipped: no SSH keys are configured."},
+		{Name: "Thermal", Error: "read failed"},
+		{Name: "Join"},
+	}}
+	condition := func(check string, status ConditionStatus, reason, message string) Condition {
+		return Condition{Type: "Preflight" + check, Status: status, LastTransitionTime: testRunTime, Reason: reason, Message: message}
+	}
+	assert.Equal(t, []Condition{
+		condition("CPU", ConditionTrue, "Passed", "Enough CPUs."),
+		condition("GPU", ConditionTrue, "Noted", "GPUs: 0000:3b:00.0 NVIDIA Tesla T4."),
+		condition("Memory", ConditionTrue, "Warning", "Not much memory."),
+		condition("Residue", ConditionFalse, "Failed", "/dev/sda has data on it."),
+		condition("BootMode", ConditionFalse, "Overridden", "Legacy boot."),
+		condition("SSHKey", ConditionUnknown, "Skipped", "Skipped: no SSH keys are configured."),
+		condition("Thermal", ConditionUnknown, "CheckError", "read failed"),
+	}, report.Conditions())
+	assert.Equal(t, []Condition{}, Report{}.Conditions())
+}
+
+func TestReportAnnotation(t *testing.T) {
+	report := Report{Mode: RunModeInstall, Timestamp: testRunTime, Results: []Result{
+		{Name: "CPU", Message: "Enough CPUs."},
+		{Name: "Memory", Severity: SeverityWarning, Message: "Not much memory."},
+		{Name: "Thermal", Error: "read failed"},
+		{Name: "Residue", Severity: SeverityFatal, Message: "/dev/sda has data on it."},
+	}}
+	complete := `{"mode":"install","timestamp":"2026-10-16T09:30:00Z","counts":{"fail":1,"pass":2,"warn":1},"results":[` +
+		`{"name":"Residue","severity":"fail","message":"/dev/sda has data on it."},` +
+		`{"name":"Memory","severity":"warn","message":"Not much memory."},` +
+		`{"name":"Thermal","severity":"pass","error":"read failed"}]}`
+	value, err := report.Annotation(DefaultAnnotationLimit)
+	assert.Nil(t, err)
+	assert.Equal(t, complete, value)
+
+	// The least severe results are dropped first, and counted
+	value, err = report.Annotation(len(complete) - 1)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"mode":"install","timestamp":"2026-10-16T09:30:00Z","counts":{"fail":1,"pass":2,"warn":1},"results":[`+
+		`{"name":"Residue","severity":"fail","message":"/dev/sda has data on it."},`+
+		`{"name":"Memory","severity":"warn","message":"Not much memory."}],"omitted":1}`, value)
+
+	value, err = report.Annotation(120)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"mode":"install","timestamp":"2026-10-16T09:30:00Z","counts":{"fail":1,"pass":2,"warn":1},"omitted":3}`, value)
+
+	_, err = report.Annotation(50)
+	assert.EqualError(t, err, "the preflight annotation does not fit in 50 bytes")
+
+	// Long messages are cut short, without splitting characters
+	long := Report{Timestamp: testRunTime, Results: []Result{
+		{Name: "Memory", Severity: SeverityWarning, Message: strings.Repeat("é", 200)},
+	}}
+	value, err = long.Annotation(DefaultAnnotationLimit)
+	assert.Nil(t, err)
+	var decoded annotation
+	assert.Nil(t, json.Unmarshal([]byte(value), &decoded))
+	message := decoded.Results[0].Message
+	assert.Equal(t, strings.Repeat("é", 126)+truncationMarker, message)
+	assert.LessOrEqual(t, len(message), maxAnnotationMessage)
+}
+
+func TestReportWriteNodeStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.json")
+	report := Report{Mode: RunModeUpgrade, Timestamp: testRunTime, Results: []Result{
+		{Name: "Pressure", Severity: SeverityWarning, Message: "Busy."},
+	}}
+	assert.Nil(t, report.WriteNodeStatusFile(path, DefaultAnnotationLimit))
+
+	out, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var status NodeStatus
+	assert.Nil(t, json.Unmarshal(out, &status))
+	assert.Equal(t, NodeStatus{
+		Conditions: []Condition{{Type: "PreflightPressure", Status: ConditionTrue, LastTransitionTime: testRunTime, Reason: "Warning", Message: "Busy."}},
+		Annotations: map[string]string{AnnotationKey: `{"mode":"upgrade","timestamp":"2026-10-16T09:30:00Z","counts":{"warn":1},"results":[` +
+			`{"name":"Pressure","severity":"warn","message":"Busy."}]}`},
+	}, status)
+
+	assert.NotNil(t, report.WriteNodeStatusFile(path, 10))
+}
@@ -0,0 +1,114 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// ConfigDeviceCheck verifies that the installation device given in the
+// install configuration exists, is a block device, and is a whole disk
+// rather than a partition (unless AllowPartition is set).  Symlinks such as those in
+// /dev/disk/by-id are resolved to the kernel device, which is recorded
+// in the inventory so that the other storage checks look at the same
+// disk.  If the device doesn't exist, the message lists the disks which
+// do, because it's usually a typo or a stale path from another machine.
+type ConfigDeviceCheck struct {
+	Path           string
+	AllowPartition bool
+}
+
+// NewConfigDeviceCheck returns a ConfigDeviceCheck for the installation
+// device in the given install configuration.
+func NewConfigDeviceCheck(cfg *config.HarvesterConfig) ConfigDeviceCheck {
+	return ConfigDeviceCheck{Path: cfg.Install.Device}
+}
+
+func (c ConfigDeviceCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ConfigDevice"
+	if c.Path == "" {
+		// Nothing configured, the device will be chosen interactively
+		return
+	}
+
+	devs, err := env.listBlockDevices()
+	if err != nil {
+		return
+	}
+
+	var dev string
+	resolved, err := env.evalSymlinks(filepath.Join(env.host().devDir, strings.TrimPrefix(c.Path, "/dev/")))
+	if err == nil {
+		dev = filepath.Base(resolved)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	} else {
+		return
+	}
+
+	disk := diskOf(devs, dev)
+	if disk == "" {
+		var available []string
+		for _, d := range devs {
+			if !d.isDisk() {
+				continue
+			}
+			desc := formatBytes(d.SizeBytes)
+			if d.Model != "" {
+				desc += ", " + d.Model
+			}
+			available = append(available, fmt.Sprintf("%s (%s)", d.Name, desc))
+		}
+		if len(available) == 0 {
+			available = []string{"none"}
+		}
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("Installation device %s does not exist. Available disks: %s.",
+			c.Path, strings.Join(available, ", "))
+		return
+	}
+
+	if info, statErr := env.stat(resolved); statErr != nil {
+		err = statErr
+		return
+	} else if !env.host().isBlockDevice(info) {
+		// e.g. a file left behind by writing to the device while the disk
+		// wasn't there
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("Installation device %s is not a block device (its mode is %s).", c.Path, info.Mode())
+		return
+	}
+
+	if disk != dev && !c.AllowPartition {
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("Installation device %s is a partition of %s. Please specify a whole disk.", c.Path, disk)
+		return
+	}
+
+	env.Inventory.InstallDevice = dev
+	return
+}
+
+// isBlockDeviceMode returns whether info is that of a block device,
+// rather than a character device or a file.
+func isBlockDeviceMode(info fs.FileInfo) bool {
+	return info.Mode()&fs.ModeDevice != 0 && info.Mode()&fs.ModeCharDevice == 0
+}
+
+// formatBytes renders a size in bytes in the largest binary unit that
+// gives a value of at least 1, e.g. "500GiB" or "1.8TiB".
+func formatBytes(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.3g%s", value, units[unit])
+}
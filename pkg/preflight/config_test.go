@@ -0,0 +1,109 @@
+package preflight
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestConfigDeviceCheck(t *testing.T) {
+	h := testHost()
+	h.sysBlock = "./testdata/config-device/sys/block"
+	h.devDir = "./testdata/config-device/dev"
+	// The fixture's devices are files, except for nvme0n1, which is a
+	// directory
+	h.isBlockDevice = func(info fs.FileInfo) bool { return info.Mode().IsRegular() }
+
+	testCases := []struct {
+		check             ConfigDeviceCheck
+		expectedResult    Result
+		expectedInventory Inventory
+	}{
+		{
+			check:             ConfigDeviceCheck{Path: "/dev/sda"},
+			expectedResult:    Result{Name: "ConfigDevice"},
+			expectedInventory: Inventory{InstallDevice: "sda"},
+		},
+		{
+			check:             ConfigDeviceCheck{Path: "/dev/disk/by-id/ata-Samsung_SSD_870_EVO_1TB_S6PUNX0R123456"},
+			expectedResult:    Result{Name: "ConfigDevice"},
+			expectedInventory: Inventory{InstallDevice: "sda"},
+		},
+		{
+			check: ConfigDeviceCheck{Path: "/dev/sdb"},
+			expectedResult: Result{
+				Name:     "ConfigDevice",
+				Severity: SeverityFatal,
+				Message:  "Installation device /dev/sdb does not exist. Available disks: nvme0n1 (1.82TiB, INTEL SSDPE2KX020T8), sda (932GiB, Samsung SSD 870 EVO 1TB).",
+			},
+		},
+		{
+			check: ConfigDeviceCheck{Path: "/dev/disk/by-id/ata-Samsung_SSD_870_EVO_1TB_S6PUNX0R123456-part1"},
+			expectedResult: Result{
+				Name:     "ConfigDevice",
+				Severity: SeverityFatal,
+				Message:  "Installation device /dev/disk/by-id/ata-Samsung_SSD_870_EVO_1TB_S6PUNX0R123456-part1 is a partition of sda. Please specify a whole disk.",
+			},
+		},
+		{
+			check: ConfigDeviceCheck{Path: "/dev/nvme0n1"},
+			expectedResult: Result{
+				Name:     "ConfigDevice",
+				Severity: SeverityFatal,
+				Message:  "Installation device /dev/nvme0n1 is not a block device (its mode is drwxr-xr-x).",
+			},
+		},
+		{
+			check:             ConfigDeviceCheck{Path: "/dev/sda1", AllowPartition: true},
+			expectedResult:    Result{Name: "ConfigDevice"},
+			expectedInventory: Inventory{InstallDevice: "sda1"},
+		},
+		{
+			check:          ConfigDeviceCheck{},
+			expectedResult: Result{Name: "ConfigDevice"},
+		},
+	}
+
+	for _, tc := range testCases {
+		env := &Env{machine: h}
+		result, err := tc.check.Evaluate(context.Background(), env)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expectedResult, result, tc.check.Path)
+		assert.Equal(t, tc.expectedInventory, env.Inventory, tc.check.Path)
+	}
+}
+
+func TestConfigDeviceCheckFeedsInventory(t *testing.T) {
+	h := testHost()
+	h.isBlockDevice = func(fs.FileInfo) bool { return true }
+	// The sysfs fixture is good enough for /dev too, all we need is for
+	// the device to exist
+	h.sysBlock = "./testdata/write-cache/write-back-unknown"
+	h.devDir = "./testdata/write-cache/write-back-unknown"
+
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.Device = "/dev/sda"
+	env := &Env{machine: h}
+	result, err := NewConfigDeviceCheck(cfg).Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityOK, result.Severity)
+
+	// WriteCacheCheck without a Dev picks up the resolved device
+	result, err = WriteCacheCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityWarning, result.Severity)
+	assert.Contains(t, result.Message, "Disk sda ")
+}
+
+func TestIsBlockDevice(t *testing.T) {
+	for path, block := range map[string]bool{"/dev/null": false, "./config.go": false, "./testdata": false} {
+		info, err := os.Stat(path)
+		assert.Nil(t, err)
+		assert.Equal(t, block, isBlockDeviceMode(info), path)
+	}
+}
@@ -0,0 +1,257 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// A HardwareKind is the kind of device a HardwareReference refers to.
+type HardwareKind string
+
+const (
+	HardwareDisk      HardwareKind = "disk"
+	HardwareInterface HardwareKind = "interface"
+	HardwarePCI       HardwareKind = "pci"
+)
+
+// A HardwareReference is a device named in the install configuration.
+type HardwareReference struct {
+	// Path is the YAML path of the configuration field, e.g.
+	// "install.data_disk".
+	Path string
+	Kind HardwareKind
+	// Value is as it appears in the configuration, e.g. "/dev/sdb", an
+	// interface name or MAC address, or a PCI address.
+	Value string
+	// Required means the installation cannot succeed without the device.
+	Required bool
+}
+
+// A NIC is a network interface backed by a device.
+type NIC struct {
+	Name   string
+	HwAddr string
+	// SpeedMbps is the link speed, or 0 if the link is down or the
+	// driver doesn't say.
+	SpeedMbps int
+	// Duplex is "full" or "half", or empty if the link is down or the
+	// driver doesn't say.
+	Duplex string
+	// Master is the bond or bridge the interface is enslaved to, if any.
+	Master string
+	// Driver is the kernel driver bound to the interface's device.
+	Driver string
+}
+
+// ConfigHardwareCheck resolves every device referenced by the install
+// configuration against the inventory, collecting the host's disks,
+// interfaces and PCI devices if no earlier check has.  Each reference is
+// reported as found or not found, with a suggestion for likely typos,
+// grouped by configuration field so it's clear which key to fix.  A
+// missing required device is fatal, and a missing optional one is
+// warned about.
+type ConfigHardwareCheck struct {
+	References []HardwareReference
+}
+
+// NewConfigHardwareCheck returns a ConfigHardwareCheck for the devices in
+// the given install configuration.  The installation device, data disk
+// and management interfaces are required.  Disks to wipe and PCI devices
+// for passthrough aren't needed for the installation itself, so they're
+// optional.
+func NewConfigHardwareCheck(cfg *config.HarvesterConfig) ConfigHardwareCheck {
+	var refs []HardwareReference
+	if cfg.Install.Device != "" {
+		refs = append(refs, HardwareReference{"install.device", HardwareDisk, cfg.Install.Device, true})
+	}
+	if cfg.Install.DataDisk != "" {
+		refs = append(refs, HardwareReference{"install.data_disk", HardwareDisk, cfg.Install.DataDisk, true})
+	}
+	for _, iface := range cfg.Install.ManagementInterface.Interfaces {
+		value := iface.Name
+		if value == "" {
+			value = iface.HwAddr
+		}
+		refs = append(refs, HardwareReference{"install.management_interface.interfaces", HardwareInterface, value, true})
+	}
+	for _, disk := range cfg.Install.WipeDisksList {
+		refs = append(refs, HardwareReference{"install.wipe_disks_list", HardwareDisk, disk, false})
+	}
+	for _, dev := range cfg.Install.PCIPassthrough {
+		refs = append(refs, HardwareReference{"install.pci_passthrough", HardwarePCI, dev.Address, false})
+	}
+	return ConfigHardwareCheck{References: refs}
+}
+
+func (c ConfigHardwareCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ConfigHardware"
+	if len(c.References) == 0 {
+		return
+	}
+	if err = c.collect(env); err != nil {
+		return
+	}
+
+	var paths []string
+	entries := map[string][]string{}
+	for _, ref := range c.References {
+		found, suggestion, err := ref.resolve(env)
+		if err != nil {
+			return result, err
+		}
+		entry := fmt.Sprintf("%s found", ref.Value)
+		if !found {
+			entry = fmt.Sprintf("%s not found", ref.Value)
+			if suggestion != "" {
+				entry += fmt.Sprintf(" (did you mean %s?)", suggestion)
+			}
+			if ref.Required {
+				result.Severity = SeverityFatal
+			} else if result.Severity != SeverityFatal {
+				result.Severity = SeverityWarning
+			}
+		}
+		if _, ok := entries[ref.Path]; !ok {
+			paths = append(paths, ref.Path)
+		}
+		entries[ref.Path] = append(entries[ref.Path], entry)
+	}
+
+	var groups []string
+	for _, path := range paths {
+		groups = append(groups, fmt.Sprintf("%s: %s.", path, strings.Join(entries[path], ", ")))
+	}
+	result.Message = strings.Join(groups, " ")
+	return
+}
+
+// collect adds the host's devices of the kinds referenced to the
+// inventory, unless they're already there.
+func (c ConfigHardwareCheck) collect(env *Env) error {
+	inv := &env.Inventory
+	for _, ref := range c.References {
+		switch {
+		case ref.Kind == HardwareDisk && inv.BlockDevices == nil:
+			devs, err := env.listBlockDevices()
+			if err != nil {
+				return err
+			}
+			inv.BlockDevices = []string{}
+			for _, dev := range devs {
+				inv.BlockDevices = append(inv.BlockDevices, dev.Name)
+				inv.BlockDevices = append(inv.BlockDevices, dev.Partitions...)
+			}
+		case ref.Kind == HardwareInterface && inv.NICs == nil:
+			nics, err := env.listNICs()
+			if err != nil {
+				return err
+			}
+			inv.NICs = nics
+		case ref.Kind == HardwarePCI && inv.PCIDevices == nil:
+			devs, err := env.listPCIDevices()
+			if err != nil {
+				return err
+			}
+			inv.PCIDevices = inventoryPCIDevices(devs)
+		}
+	}
+	return nil
+}
+
+// resolve returns whether the referenced device is in the inventory, and
+// if not, what it might have been meant to be, if anything.
+func (r HardwareReference) resolve(env *Env) (bool, string, error) {
+	inv := env.Inventory
+	switch r.Kind {
+	case HardwareDisk:
+		name := strings.TrimPrefix(r.Value, "/dev/")
+		resolved, err := env.evalSymlinks(filepath.Join(env.host().devDir, name))
+		if err == nil {
+			name = filepath.Base(resolved)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return false, "", err
+		}
+		if slices.Contains(inv.BlockDevices, name) {
+			return true, "", nil
+		}
+		match := closestMatch(name, inv.BlockDevices)
+		if match != "" && strings.HasPrefix(r.Value, "/dev/") {
+			match = "/dev/" + match
+		}
+		return false, match, nil
+	case HardwareInterface:
+		var names, addrs []string
+		for _, nic := range inv.NICs {
+			if nic.Name == r.Value || strings.EqualFold(nic.HwAddr, r.Value) {
+				return true, "", nil
+			}
+			names = append(names, nic.Name)
+			addrs = append(addrs, nic.HwAddr)
+		}
+		if _, err := net.ParseMAC(r.Value); err == nil {
+			return false, closestMatch(r.Value, addrs), nil
+		}
+		return false, closestMatch(r.Value, names), nil
+	case HardwarePCI:
+		address := normalizePCIAddress(r.Value)
+		// Addresses all look alike, so only suggest ones on the same bus
+		bus := address[:strings.LastIndex(address, ":")+1]
+		var sameBus []string
+		for _, dev := range inv.PCIDevices {
+			if dev.Address == address {
+				return true, "", nil
+			}
+			if strings.HasPrefix(dev.Address, bus) {
+				sameBus = append(sameBus, dev.Address)
+			}
+		}
+		return false, closestMatch(address, sameBus), nil
+	}
+	return false, "", fmt.Errorf("unknown hardware kind %q", r.Kind)
+}
+
+// listNICs returns the network interfaces which are backed by a device,
+// i.e. not virtual ones such as bridges and bonds.
+func (e *Env) listNICs() ([]NIC, error) {
+	dir := filepath.Join(e.host().root, "sys/class/net")
+	entries, err := e.readDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []NIC{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	nics := []NIC{}
+	for _, entry := range entries {
+		if _, err := e.stat(filepath.Join(dir, entry.Name(), "device")); err != nil {
+			continue
+		}
+		nic := NIC{
+			Name:   entry.Name(),
+			HwAddr: e.readTrimmed(filepath.Join(dir, entry.Name(), "address")),
+		}
+		// speed is -1 when the link is down
+		if speed, err := strconv.Atoi(e.readTrimmed(filepath.Join(dir, entry.Name(), "speed"))); err == nil && speed > 0 {
+			nic.SpeedMbps = speed
+		}
+		if duplex := e.readTrimmed(filepath.Join(dir, entry.Name(), "duplex")); duplex == "full" || duplex == "half" {
+			nic.Duplex = duplex
+		}
+		if link, err := e.readlink(filepath.Join(dir, entry.Name(), "master")); err == nil {
+			nic.Master = filepath.Base(link)
+		}
+		if link, err := e.readlink(filepath.Join(dir, entry.Name(), "device/driver")); err == nil {
+			nic.Driver = filepath.Base(link)
+		}
+		nics = append(nics, nic)
+	}
+	return nics, nil
+}
@@ -0,0 +1,119 @@
+package preflight
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestConfigHardwareCheck(t *testing.T) {
+	h := testHost()
+	h.devDir = "./testdata/config-hardware/dev"
+
+	data, err := os.ReadFile("./testdata/config-hardware/config.yaml")
+	assert.Nil(t, err)
+	cfg, err := config.LoadHarvesterConfig(data)
+	assert.Nil(t, err)
+	check := NewConfigHardwareCheck(cfg)
+	assert.Equal(t, ConfigHardwareCheck{References: []HardwareReference{
+		{"install.device", HardwareDisk, "/dev/sdx", true},
+		{"install.data_disk", HardwareDisk, "/dev/nvme0n1", true},
+		{"install.management_interface.interfaces", HardwareInterface, "eno1", true},
+		{"install.management_interface.interfaces", HardwareInterface, "eno3", true},
+		{"install.management_interface.interfaces", HardwareInterface, "3c:ec:ef:12:34:57", true},
+		{"install.wipe_disks_list", HardwareDisk, "/dev/sdb", false},
+		{"install.wipe_disks_list", HardwareDisk, "/dev/sdc", false},
+		{"install.pci_passthrough", HardwarePCI, "3b:00.0", false},
+		{"install.pci_passthrough", HardwarePCI, "0000:af:00.1", false},
+	}}, check)
+
+	inventory := Inventory{
+		BlockDevices: []string{"sda", "sda1", "sdb", "nvme0n1"},
+		NICs: []NIC{
+			{Name: "eno1", HwAddr: "3c:ec:ef:12:34:56"},
+			{Name: "eno2", HwAddr: "3c:ec:ef:12:34:57"},
+		},
+		PCIDevices: []PCIDevice{{Address: "0000:00:1f.0"}, {Address: "0000:3b:00.0"}, {Address: "0000:af:00.0"}},
+	}
+
+	tests := []struct {
+		name     string
+		check    ConfigHardwareCheck
+		severity Severity
+		message  string
+	}{
+		{
+			name:     "mismatches",
+			check:    check,
+			severity: SeverityFatal,
+			message: "install.device: /dev/sdx not found (did you mean /dev/sda?). " +
+				"install.data_disk: /dev/nvme0n1 found. " +
+				"install.management_interface.interfaces: eno1 found, eno3 not found (did you mean eno1?), 3c:ec:ef:12:34:57 found. " +
+				"install.wipe_disks_list: /dev/sdb found, /dev/sdc not found (did you mean /dev/sda?). " +
+				"install.pci_passthrough: 3b:00.0 found, 0000:af:00.1 not found (did you mean 0000:af:00.0?).",
+		},
+		{
+			name: "optional missing",
+			check: ConfigHardwareCheck{References: []HardwareReference{
+				{"install.device", HardwareDisk, "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4", true},
+				{"install.pci_passthrough", HardwarePCI, "0000:d8:00.0", false},
+			}},
+			severity: SeverityWarning,
+			message: "install.device: /dev/disk/by-id/wwn-0x5000c500a1b2c3d4 found. " +
+				"install.pci_passthrough: 0000:d8:00.0 not found.",
+		},
+		{
+			name: "all found",
+			check: ConfigHardwareCheck{References: []HardwareReference{
+				{"install.device", HardwareDisk, "sdb", true},
+				{"install.management_interface.interfaces", HardwareInterface, "3C:EC:EF:12:34:56", true},
+			}},
+			message: "install.device: sdb found. install.management_interface.interfaces: 3C:EC:EF:12:34:56 found.",
+		},
+		{
+			name:  "nothing configured",
+			check: NewConfigHardwareCheck(config.NewHarvesterConfig()),
+		},
+	}
+
+	for _, test := range tests {
+		env := &Env{Inventory: inventory, machine: h}
+		result, err := test.check.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "ConfigHardware", Severity: test.severity, Message: test.message}, result, test.name)
+	}
+}
+
+func TestConfigHardwareCheckCollect(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/config-hardware/host"
+	h.sysBlock = h.root + "/sys/block"
+	h.sysBusPCIDevices = h.root + "/sys/bus/pci/devices"
+
+	check := ConfigHardwareCheck{References: []HardwareReference{
+		{"install.device", HardwareDisk, "sda1", true},
+		{"install.management_interface.interfaces", HardwareInterface, "eth1", true},
+		{"install.pci_passthrough", HardwarePCI, "3b:00.1", false},
+	}}
+	env := &Env{machine: h}
+	result, err := check.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{
+		Name:     "ConfigHardware",
+		Severity: SeverityWarning,
+		Message: "install.device: sda1 found. install.management_interface.interfaces: eth1 found. " +
+			"install.pci_passthrough: 3b:00.1 not found (did you mean 0000:3b:00.0?).",
+	}, result)
+	assert.Equal(t, Inventory{
+		BlockDevices: []string{"sda", "sda1"},
+		NICs: []NIC{
+			{Name: "eth0", HwAddr: "52:54:00:12:34:56", SpeedMbps: 10000, Duplex: "full"},
+			{Name: "eth1", HwAddr: "52:54:00:12:34:57", Master: "bond0"},
+		},
+		PCIDevices: []PCIDevice{{Address: "0000:3b:00.0"}},
+	}, env.Inventory)
+}
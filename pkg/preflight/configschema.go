@@ -0,0 +1,301 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/mapper/convert"
+	"gopkg.in/yaml.v3"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// ConfigSchemaCheck validates the install configuration document itself,
+// so that every problem with it is reported at once, with its location,
+// rather than one at a time as the installer gets to each section.  It
+// checks that each field has the right type, that enumerated fields have
+// one of their values, and that the fields each install mode needs are
+// present and consistent.  Unknown fields are warned about, with the
+// nearest known field, because they're usually typos.  Other problems are
+// fatal.  It should run before anything else looks at the configuration.
+type ConfigSchemaCheck struct {
+	// Data is the YAML document.
+	Data []byte
+}
+
+// A schemaProblem is something wrong with the configuration document.
+type schemaProblem struct {
+	severity Severity
+	path     string
+	line     int
+	message  string
+}
+
+func (p schemaProblem) String() string {
+	if p.line > 0 {
+		return fmt.Sprintf("%s (line %d): %s.", p.path, p.line, p.message)
+	}
+	return fmt.Sprintf("%s: %s.", p.path, p.message)
+}
+
+func (c ConfigSchemaCheck) Evaluate(_ context.Context, _ *Env) (result Result, err error) {
+	result.Name = "ConfigSchema"
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(c.Data, &doc); err != nil {
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("The configuration is not valid YAML: %v.", strings.TrimPrefix(err.Error(), "yaml: "))
+		return result, nil
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+
+	problems := walkSchema(root, reflect.TypeOf(config.HarvesterConfig{}), "", nil)
+	// The values can only be checked if the types were right enough for
+	// the document to be loaded
+	cfg, err := config.LoadHarvesterConfig(c.Data)
+	if err == nil {
+		problems = append(problems, validateConfigValues(cfg, root)...)
+	} else if len(problems) == 0 {
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("The configuration cannot be loaded: %v.", err)
+		return result, nil
+	}
+	if len(problems) == 0 {
+		result.Message = "The configuration is valid."
+		return
+	}
+
+	var messages []string
+	for _, p := range problems {
+		result.Severity = max(result.Severity, p.severity)
+		messages = append(messages, p.String())
+	}
+	result.Message = strings.Join(messages, " ")
+	return result, nil
+}
+
+// walkSchema checks that node is a valid t, adding any problems to
+// problems.
+func walkSchema(node *yaml.Node, t reflect.Type, path string, problems []schemaProblem) []schemaProblem {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return problems
+	}
+	typeError := func(expected string) []schemaProblem {
+		return append(problems, schemaProblem{SeverityFatal, path, node.Line, fmt.Sprintf("expected %s", expected)})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return typeError("a mapping")
+		}
+		fields := schemaFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPath := joinSchemaPath(path, key.Value)
+			field, ok := fields[key.Value]
+			if !ok {
+				message := "unknown field"
+				if match := closestMatch(key.Value, schemaFieldNames(t)); match != "" {
+					message += fmt.Sprintf(" (did you mean %s?)", match)
+				}
+				problems = append(problems, schemaProblem{SeverityWarning, fieldPath, key.Line, message})
+				continue
+			}
+			problems = walkSchema(value, field.Type, fieldPath, problems)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return typeError("a mapping")
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			problems = walkSchema(node.Content[i+1], t.Elem(), joinSchemaPath(path, node.Content[i].Value), problems)
+		}
+	case reflect.Slice:
+		if node.Kind == yaml.ScalarNode {
+			// A single value is taken as a list of one
+			return walkSchema(node, t.Elem(), path, problems)
+		}
+		if node.Kind != yaml.SequenceNode {
+			return typeError("a list")
+		}
+		for i, item := range node.Content {
+			problems = walkSchema(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			return typeError("a string")
+		}
+	case reflect.Bool:
+		if _, err := strconv.ParseBool(node.Value); node.Kind != yaml.ScalarNode || err != nil {
+			return typeError("true or false")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, err := strconv.ParseInt(node.Value, 0, t.Bits()); node.Kind != yaml.ScalarNode || err != nil {
+			return typeError("an integer")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, err := strconv.ParseUint(node.Value, 0, t.Bits()); node.Kind != yaml.ScalarNode || err != nil {
+			return typeError("a non-negative integer")
+		}
+	}
+	return problems
+}
+
+// schemaFields returns the fields of struct type t, keyed by every name
+// the configuration loader accepts for them: the JSON name, its
+// lowercase and snake_case forms, and their singulars.
+func schemaFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names := []string{name}
+		if strings.HasSuffix(name, "es") && len(name) > 2 {
+			names = append(names, name[:len(name)-2])
+		}
+		if strings.HasSuffix(name, "s") && len(name) > 1 {
+			names = append(names, name[:len(name)-1])
+		}
+		for _, n := range names {
+			fields[n] = field
+			fields[strings.ToLower(n)] = field
+			fields[convert.ToYAMLKey(n)] = field
+			fields[strings.ToLower(convert.ToYAMLKey(n))] = field
+		}
+	}
+	return fields
+}
+
+// schemaFieldNames returns the snake_case names of the fields of struct
+// type t, as the documentation gives them.
+func schemaFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, convert.ToYAMLKey(name))
+		}
+	}
+	return names
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// schemaLine returns the line of the node at the given path of snake_case
+// keys, matching keys the way the loader does.  If it isn't there, the
+// line of its nearest ancestor which is is returned, or 0 if there's none.
+func schemaLine(root *yaml.Node, path string) int {
+	node := root
+	line := 0
+	for _, key := range strings.Split(path, ".") {
+		if node.Kind != yaml.MappingNode {
+			return line
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if strings.ReplaceAll(strings.ToLower(node.Content[i].Value), "_", "") == strings.ReplaceAll(key, "_", "") {
+				line, next = node.Content[i].Line, node.Content[i+1]
+			}
+		}
+		if next == nil {
+			return line
+		}
+		node = next
+	}
+	return line
+}
+
+// validateConfigValues checks the values in the decoded configuration,
+// using root to find where they are.
+func validateConfigValues(cfg *config.HarvesterConfig, root *yaml.Node) []schemaProblem {
+	var problems []schemaProblem
+	problem := func(path, format string, args ...interface{}) {
+		problems = append(problems, schemaProblem{SeverityFatal, path, schemaLine(root, path), fmt.Sprintf(format, args...)})
+	}
+	oneOf := func(path, value string, values ...string) {
+		if value != "" && !slices.Contains(values, value) {
+			problem(path, "%q is not one of %s", value, strings.Join(values, ", "))
+		}
+	}
+
+	install := cfg.Install
+	mgmt := install.ManagementInterface
+	switch install.Mode {
+	case "":
+		problem("install.mode", "required")
+	case config.ModeCreate, config.ModeJoin, config.ModeInstall, config.ModeUpgrade:
+	default:
+		oneOf("install.mode", install.Mode, config.ModeCreate, config.ModeJoin, config.ModeInstall, config.ModeUpgrade)
+	}
+	oneOf("install.role", install.Role, config.RoleDefault, config.RoleWitness, config.RoleMgmt, config.RoleWorker)
+	oneOf("install.vip_mode", install.VipMode, config.NetworkMethodDHCP, config.NetworkMethodStatic, config.NetworkMethodNone)
+	oneOf("install.management_interface.method", mgmt.Method, config.NetworkMethodDHCP, config.NetworkMethodStatic, config.NetworkMethodNone)
+	oneOf("install.management_interface.bond_options.mode", mgmt.BondOptions["mode"], bondModes...)
+
+	if install.Mode == config.ModeCreate || install.Mode == config.ModeJoin {
+		if install.Mode == config.ModeJoin && cfg.ServerURL == "" {
+			problem("server_url", "required in join mode")
+		}
+		if install.Mode == config.ModeCreate && cfg.ServerURL != "" {
+			problem("server_url", "not allowed in create mode")
+		}
+		if cfg.Token == "" {
+			problem("token", "required in %s mode", install.Mode)
+		}
+		if len(mgmt.Interfaces) == 0 {
+			problem("install.management_interface.interfaces", "required in %s mode", install.Mode)
+		}
+		if len(cfg.SSHAuthorizedKeys) == 0 && cfg.Password == "" {
+			problem("os.password", "either this or os.ssh_authorized_keys is required, or nobody can log in")
+		}
+	}
+	if install.Mode == config.ModeCreate {
+		if install.Vip == "" {
+			problem("install.vip", "required in create mode")
+		}
+		if install.VipMode == config.NetworkMethodDHCP && install.VipHwAddr == "" {
+			problem("install.vip_hw_addr", "required when vip_mode is dhcp")
+		}
+	}
+	if mgmt.Method == config.NetworkMethodStatic {
+		for _, field := range []struct{ name, value string }{
+			{"ip", mgmt.IP}, {"subnet_mask", mgmt.SubnetMask}, {"gateway", mgmt.Gateway},
+		} {
+			if field.value == "" {
+				problem("install.management_interface."+field.name, "required when method is static")
+			}
+		}
+	}
+	if install.Automatic && install.Mode != config.ModeInstall && install.ISOURL == "" {
+		problem("install.iso_url", "required for automatic installation")
+	}
+	slices.SortStableFunc(problems, func(a, b schemaProblem) int {
+		return a.line - b.line
+	})
+	return problems
+}
@@ -0,0 +1,82 @@
+package preflight
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigSchemaCheck(t *testing.T) {
+	tests := []struct {
+		config   string
+		severity Severity
+		message  string
+	}{
+		{
+			config:  "valid",
+			message: "The configuration is valid.",
+		},
+		{
+			// Unknown fields and wrong types
+			config:   "types",
+			severity: SeverityFatal,
+			message: "os.passwrd (line 4): unknown field (did you mean password?). " +
+				"os.sysctls (line 7): expected a mapping. " +
+				"install.managment_interface (line 11): unknown field (did you mean management_interface?). " +
+				"install.automatic (line 15): expected true or false. " +
+				"install.mgmt (line 16): unknown field.",
+		},
+		{
+			// Missing and conflicting fields, and invalid values
+			config:   "create",
+			severity: SeverityFatal,
+			message: "token: required in create mode. " +
+				"server_url (line 1): not allowed in create mode. " +
+				"os.password (line 2): either this or os.ssh_authorized_keys is required, or nobody can log in. " +
+				"install.vip (line 4): required in create mode. " +
+				"install.vip_hw_addr (line 4): required when vip_mode is dhcp. " +
+				"install.role (line 6): \"manager\" is not one of default, witness, management, worker. " +
+				"install.management_interface.subnet_mask (line 8): required when method is static. " +
+				"install.management_interface.gateway (line 8): required when method is static. " +
+				"install.management_interface.bond_options.mode (line 14): \"lacp\" is not one of " +
+				"balance-rr, active-backup, balance-xor, broadcast, 802.3ad, balance-tlb, balance-alb.",
+		},
+		{
+			config:   "join",
+			severity: SeverityFatal,
+			message: "server_url: required in join mode. " +
+				"token: required in join mode. " +
+				"install.vip_mode (line 5): \"floating\" is not one of dhcp, static, none. " +
+				"install.management_interface.interfaces (line 6): required in join mode.",
+		},
+		{
+			config:   "modeless",
+			severity: SeverityFatal,
+			message: "install.mode (line 1): required. " +
+				"install.management_interface.method (line 4): \"manual\" is not one of dhcp, static, none.",
+		},
+		{
+			config:   "unknown",
+			severity: SeverityWarning,
+			message:  "install.data_disks (line 4): unknown field (did you mean data_disk?).",
+		},
+		{
+			config:   "invalid",
+			severity: SeverityFatal,
+			message:  "The configuration is not valid YAML: line 3: mapping values are not allowed in this context.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			data, err := os.ReadFile("./testdata/config-schema/" + tt.config + ".yaml")
+			assert.Nil(t, err)
+			result, err := ConfigSchemaCheck{Data: data}.Evaluate(context.Background(), &Env{})
+			assert.Nil(t, err)
+			assert.Equal(t, "ConfigSchema", result.Name)
+			assert.Equal(t, tt.severity, result.Severity)
+			assert.Equal(t, tt.message, result.Message)
+		})
+	}
+}
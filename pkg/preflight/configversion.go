@@ -0,0 +1,94 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// A SchemeVersion is a version of the install configuration's scheme,
+// which this installer understands.
+type SchemeVersion struct {
+	Version uint32
+	// Deprecated means configurations should be migrated to a newer
+	// version, because support for this one will be dropped.
+	Deprecated bool
+	// Changed lists the fields whose meaning is different in the newest
+	// version, so that it's clear what to look at when migrating.
+	Changed []string
+}
+
+// SupportedSchemeVersions are the configuration scheme versions this
+// installer understands, oldest first.
+var SupportedSchemeVersions = []SchemeVersion{
+	{Version: config.SchemeVersion},
+}
+
+// ConfigVersionCheck verifies that this installer understands the version
+// of the install configuration's scheme, because fields it doesn't know,
+// or whose meaning has changed, are otherwise silently misinterpreted
+// when configurations are reused across releases.  Versions newer than
+// the installer are fatal, and deprecated ones are warned about.  A
+// configuration which doesn't declare its version is taken to be the
+// oldest supported version, which is also warned about.
+type ConfigVersionCheck struct {
+	// Declared is the configuration's schemeVersion, or 0 if it has none.
+	Declared uint32
+	// Supported are the versions the installer understands, oldest
+	// first.
+	Supported []SchemeVersion
+}
+
+// NewConfigVersionCheck returns a ConfigVersionCheck for the given
+// install configuration.
+func NewConfigVersionCheck(cfg *config.HarvesterConfig) ConfigVersionCheck {
+	return ConfigVersionCheck{Declared: cfg.SchemeVersion, Supported: SupportedSchemeVersions}
+}
+
+func (c ConfigVersionCheck) Evaluate(_ context.Context, _ *Env) (result Result, err error) {
+	result.Name = "ConfigVersion"
+	if len(c.Supported) == 0 {
+		return
+	}
+	oldest, newest := c.Supported[0], c.Supported[len(c.Supported)-1]
+	supports := fmt.Sprintf("v%d", oldest.Version)
+	if newest.Version != oldest.Version {
+		supports += fmt.Sprintf("–v%d", newest.Version)
+	}
+
+	declared := c.Declared
+	message := fmt.Sprintf("The configuration declares v%d, and the installer supports %s.", declared, supports)
+	if declared == 0 {
+		declared = oldest.Version
+		result.Severity = SeverityWarning
+		message = fmt.Sprintf("The configuration does not declare its schemeVersion, so it is taken to be v%d, "+
+			"the oldest the installer supports (%s).", declared, supports)
+	}
+
+	var version *SchemeVersion
+	for i := range c.Supported {
+		if c.Supported[i].Version == declared {
+			version = &c.Supported[i]
+		}
+	}
+	switch {
+	case declared > newest.Version:
+		result.Severity = SeverityFatal
+		message = fmt.Sprintf("The configuration declares v%d, but the installer only supports %s. "+
+			"The configuration is for a newer installer, so use that, or rewrite it for v%d.", declared, supports, newest.Version)
+	case version == nil:
+		result.Severity = SeverityFatal
+		message = fmt.Sprintf("The configuration declares v%d, but the installer supports %s. Please rewrite it for v%d.",
+			declared, supports, newest.Version)
+	case version.Deprecated:
+		result.Severity = SeverityWarning
+		message += fmt.Sprintf(" v%d is deprecated, so please migrate the configuration to v%d.", declared, newest.Version)
+		if len(version.Changed) > 0 {
+			message += fmt.Sprintf(" These fields have changed meaning since v%d: %s.", declared, strings.Join(version.Changed, ", "))
+		}
+	}
+	result.Message = message
+	return
+}
@@ -0,0 +1,92 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewConfigVersionCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.SchemeVersion = 1
+	assert.Equal(t, ConfigVersionCheck{Declared: 1, Supported: SupportedSchemeVersions}, NewConfigVersionCheck(cfg))
+}
+
+func TestConfigVersionCheck(t *testing.T) {
+	supported := []SchemeVersion{
+		{Version: 2, Deprecated: true, Changed: []string{"install.vip_mode", "os.ntp_servers"}},
+		{Version: 3},
+		{Version: 4},
+	}
+
+	tests := []struct {
+		name      string
+		declared  uint32
+		supported []SchemeVersion
+		severity  Severity
+		message   string
+	}{
+		{
+			name:     "matching",
+			declared: 4,
+			message:  "The configuration declares v4, and the installer supports v2–v4.",
+		},
+		{
+			name:     "older",
+			declared: 3,
+			message:  "The configuration declares v3, and the installer supports v2–v4.",
+		},
+		{
+			name:     "newer",
+			declared: 5,
+			severity: SeverityFatal,
+			message: "The configuration declares v5, but the installer only supports v2–v4. " +
+				"The configuration is for a newer installer, so use that, or rewrite it for v4.",
+		},
+		{
+			name:     "too old",
+			declared: 1,
+			severity: SeverityFatal,
+			message:  "The configuration declares v1, but the installer supports v2–v4. Please rewrite it for v4.",
+		},
+		{
+			name:     "deprecated",
+			declared: 2,
+			severity: SeverityWarning,
+			message: "The configuration declares v2, and the installer supports v2–v4. " +
+				"v2 is deprecated, so please migrate the configuration to v4. " +
+				"These fields have changed meaning since v2: install.vip_mode, os.ntp_servers.",
+		},
+		{
+			name:     "unversioned",
+			severity: SeverityWarning,
+			message: "The configuration does not declare its schemeVersion, so it is taken to be v2, " +
+				"the oldest the installer supports (v2–v4). " +
+				"v2 is deprecated, so please migrate the configuration to v4. " +
+				"These fields have changed meaning since v2: install.vip_mode, os.ntp_servers.",
+		},
+		{
+			name:      "unversioned single version",
+			supported: []SchemeVersion{{Version: 1}},
+			severity:  SeverityWarning,
+			message: "The configuration does not declare its schemeVersion, so it is taken to be v1, " +
+				"the oldest the installer supports (v1).",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := ConfigVersionCheck{Declared: tt.declared, Supported: supported}
+			if tt.supported != nil {
+				check.Supported = tt.supported
+			}
+			result, err := check.Evaluate(context.Background(), &Env{})
+			assert.Nil(t, err)
+			assert.Equal(t, "ConfigVersion", result.Name)
+			assert.Equal(t, tt.severity, result.Severity)
+			assert.Equal(t, tt.message, result.Message)
+		})
+	}
+}
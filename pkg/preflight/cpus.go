@@ -0,0 +1,88 @@
+package preflight
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// schedAffinityCPUs returns the number of CPUs the installer may be
+// scheduled on, which is those online, unless it's been confined to
+// fewer.
+func schedAffinityCPUs() int {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		// The runtime asked the same question when it started
+		return runtime.NumCPU()
+	}
+	return set.Count()
+}
+
+// supportedArchs are the architectures SaftOS runs on.
+var supportedArchs = []string{"amd64", "arm64"}
+
+// presentCPUs returns the number of CPUs present, online or not, from the
+// kernel's list of them, e.g. "0-63".  On hosts with CPU hotplug, it can be
+// more than onlineCPUs.
+func presentCPUs(env *Env) (int, error) {
+	path := filepath.Join(env.host().root, "sys/devices/system/cpu/present")
+	data, err := env.readFile(path)
+	if err != nil {
+		return 0, err
+	}
+	cpus := map[int]bool{}
+	addCPUList(cpus, strings.TrimSpace(string(data)))
+	if len(cpus) == 0 {
+		return 0, parseErrorf("%s lists no CPUs: %q", path, strings.TrimSpace(string(data)))
+	}
+	return len(cpus), nil
+}
+
+// cpuinfoCPUs returns the number of CPUs listed in /proc/cpuinfo, for
+// when sysfs isn't mounted, as in a minimal initrd.  Each has a
+// "processor" line, on x86 and arm64 alike.
+func cpuinfoCPUs(env *Env) (int, error) {
+	data, err := env.readFile(env.host().procCPUInfo)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, _, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) == "processor" {
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, parseErrorf("%s lists no processors", env.host().procCPUInfo)
+	}
+	return count, nil
+}
+
+// crossCheckNproc logs whether nproc, which CPUs used to be counted with,
+// agrees with the count of those present.  It's only for debugging, since
+// forking is what counting them ourselves avoids, and minimal initrds
+// haven't got nproc, so its being missing is only worth a debug message.
+func crossCheckNproc(env *Env, present int) {
+	out, err := env.output("/usr/bin/nproc", "--all")
+	if errors.Is(err, ErrToolMissing) {
+		logrus.Debugf("Cannot cross-check the CPU count with nproc: %v", err)
+		return
+	} else if err != nil {
+		logrus.Warnf("Cannot cross-check the CPU count with nproc: %v", err)
+		return
+	}
+	nproc, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	switch {
+	case err != nil:
+		logrus.Warnf("Cannot cross-check the CPU count with nproc: %v", err)
+	case nproc != present:
+		logrus.Warnf("nproc --all counts %d CPUs, but %d are present", nproc, present)
+	default:
+		logrus.Debugf("nproc --all agrees that %d CPUs are present", present)
+	}
+}
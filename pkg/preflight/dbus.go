@@ -0,0 +1,150 @@
+package preflight
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemBusTimeout bounds the whole connection attempt, so that a wedged
+// dbus-daemon doesn't hang the run.
+const systemBusTimeout = 5 * time.Second
+
+// errNoSystemBus is returned by the system bus probe when the bus can't
+// be used.  Checks which need the bus (e.g. to query hostnamed or
+// timedated) should skip with this as the reason, rather than fail with
+// some misleading downstream error.
+var errNoSystemBus = errors.New("system bus unavailable")
+
+// skipNoSystemBus sets up result as skipped, if err is errNoSystemBus,
+// and returns nil in that case.  Otherwise, err is returned untouched.
+func skipNoSystemBus(result *Result, err error) error {
+	if errors.Is(err, errNoSystemBus) {
+		result.Severity = SeverityOK
+		result.Message = fmt.Sprintf("Skipped: %s.", errNoSystemBus)
+		return nil
+	}
+	return err
+}
+
+// helloSystemBus connects to the bus at path, authenticates, and says
+// Hello, which is as much as any client does before it can make calls.
+func helloSystemBus(ctx context.Context, path string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err = fmt.Fprintf(conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("authentication rejected: %s", strings.TrimSpace(line))
+	}
+
+	if _, err = conn.Write(append([]byte("BEGIN\r\n"), dbusHello()...)); err != nil {
+		return err
+	}
+	header := make([]byte, 16)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if header[1] != dbusMethodReturn {
+		return fmt.Errorf("unexpected reply type %d to Hello", header[1])
+	}
+	return nil
+}
+
+// D-Bus message types
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+)
+
+// dbusHello returns a little-endian org.freedesktop.DBus.Hello method
+// call, with serial 1 and no body.
+func dbusHello() []byte {
+	var fields []byte
+	field := func(code, sig byte, value string) {
+		// Header fields are structs, so 8-byte aligned, and the fixed
+		// part of the header before them is 16 bytes
+		for len(fields)%8 != 0 {
+			fields = append(fields, 0)
+		}
+		fields = append(fields, code, 1, sig, 0)
+		fields = binary.LittleEndian.AppendUint32(fields, uint32(len(value)))
+		fields = append(fields, value...)
+		fields = append(fields, 0)
+	}
+	field(1, 'o', "/org/freedesktop/DBus")
+	field(2, 's', "org.freedesktop.DBus")
+	field(3, 's', "Hello")
+	field(6, 's', "org.freedesktop.DBus")
+
+	msg := []byte{'l', dbusMethodCall, 0, 1}
+	msg = binary.LittleEndian.AppendUint32(msg, 0)
+	msg = binary.LittleEndian.AppendUint32(msg, 1)
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(len(fields)))
+	msg = append(msg, fields...)
+	for len(msg)%8 != 0 {
+		msg = append(msg, 0)
+	}
+	return msg
+}
+
+// systemBus returns nil if the system bus is usable, or errNoSystemBus
+// (wrapped with the reason) if not.  The bus is only probed once per
+// preflight run, and the outcome is recorded in the inventory.
+func (e *Env) systemBus(ctx context.Context) error {
+	if !e.systemBusProbed {
+		e.systemBusProbed = true
+		if _, err := e.stat(e.host().systemBusSocket); errors.Is(err, fs.ErrNotExist) {
+			e.systemBusErr = fmt.Errorf("%w: %s does not exist", errNoSystemBus, e.host().systemBusSocket)
+		} else {
+			ctx, cancel := context.WithTimeout(ctx, systemBusTimeout)
+			defer cancel()
+			if err := e.host().dialSystemBus(ctx, e.host().systemBusSocket); err != nil {
+				e.systemBusErr = fmt.Errorf("%w: %v", errNoSystemBus, err)
+			}
+		}
+		e.Inventory.SystemBus = e.systemBusErr == nil
+	}
+	return e.systemBusErr
+}
+
+// DBusCheck verifies that the D-Bus system bus is running and accepting
+// connections.  It only warns, because the installer itself can manage
+// without it; the checks which need the bus skip themselves instead.
+type DBusCheck struct{}
+
+func (c DBusCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "DBus"
+	if err := env.systemBus(ctx); err != nil {
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("D-Bus %s. Checks which need it will be skipped.", err)
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package preflight
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBus accepts one connection on a unix socket at path, and answers
+// the handshake dialSystemBus does
+func fakeBus(t *testing.T, path string) {
+	l, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		if nul, err := r.ReadByte(); err != nil || nul != 0 {
+			return
+		}
+		if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "AUTH EXTERNAL ") {
+			return
+		}
+		io.WriteString(conn, "OK 0123456789abcdef0123456789abcdef\r\n")
+		if line, err := r.ReadString('\n'); err != nil || line != "BEGIN\r\n" {
+			return
+		}
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil || header[0] != 'l' || header[1] != dbusMethodCall {
+			return
+		}
+		fields := make([]byte, (binary.LittleEndian.Uint32(header[12:])+7)&^7)
+		if _, err := io.ReadFull(r, fields); err != nil || !strings.Contains(string(fields), "Hello") {
+			return
+		}
+		reply := []byte{'l', dbusMethodReturn, 0, 1}
+		reply = append(reply, make([]byte, 12)...)
+		conn.Write(reply)
+	}()
+}
+
+func TestHelloSystemBus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bus")
+	fakeBus(t, path)
+	ctx, cancel := context.WithTimeout(context.Background(), systemBusTimeout)
+	defer cancel()
+	assert.Nil(t, helloSystemBus(ctx, path))
+}
+
+func TestDBusCheck(t *testing.T) {
+	h := testHost()
+
+	dir := t.TempDir()
+	present := filepath.Join(dir, "system_bus_socket")
+	assert.Nil(t, os.WriteFile(present, nil, 0600))
+
+	tests := []struct {
+		name     string
+		socket   string
+		dialErr  error
+		severity Severity
+		message  string
+	}{
+		{
+			name:     "socket missing",
+			socket:   filepath.Join(dir, "missing"),
+			severity: SeverityWarning,
+			message:  "D-Bus system bus unavailable: " + filepath.Join(dir, "missing") + " does not exist. Checks which need it will be skipped.",
+		},
+		{
+			name:     "connection refused",
+			socket:   present,
+			dialErr:  &net.OpError{Op: "dial", Net: "unix", Err: syscall.ECONNREFUSED},
+			severity: SeverityWarning,
+			message:  "D-Bus system bus unavailable: dial unix: connection refused. Checks which need it will be skipped.",
+		},
+		{
+			name:   "healthy",
+			socket: present,
+		},
+	}
+
+	for _, test := range tests {
+		h.systemBusSocket = test.socket
+		dials := 0
+		h.dialSystemBus = func(_ context.Context, path string) error {
+			dials++
+			assert.Equal(t, test.socket, path, test.name)
+			return test.dialErr
+		}
+		env := &Env{machine: h}
+		result, err := DBusCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "DBus", Severity: test.severity, Message: test.message}, result, test.name)
+		assert.Equal(t, test.severity == SeverityOK, env.Inventory.SystemBus, test.name)
+
+		// Dependent checks see the same outcome, without probing again
+		err = env.systemBus(context.Background())
+		assert.Equal(t, test.severity != SeverityOK, errors.Is(err, errNoSystemBus), test.name)
+		var skipped Result
+		assert.Nil(t, skipNoSystemBus(&skipped, err), test.name)
+		if err != nil {
+			assert.Equal(t, "Skipped: system bus unavailable.", skipped.Message, test.name)
+		}
+		assert.LessOrEqual(t, dials, 1, test.name)
+	}
+}
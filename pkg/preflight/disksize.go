@@ -0,0 +1,90 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// DiskSizeCheck verifies that the installation disk, as resolved by
+// ConfigDeviceCheck, and the data disk if there's a separate one, are big
+// enough for the node's role.  The installation disk needs more room
+// when it holds the data as well.  Disks which are too small are fatal,
+// as the installer would refuse them anyway.
+type DiskSizeCheck struct {
+	DataDisk string
+}
+
+// NewDiskSizeCheck returns a DiskSizeCheck for the disks in the given
+// install configuration.
+func NewDiskSizeCheck(cfg *config.HarvesterConfig) DiskSizeCheck {
+	return DiskSizeCheck{DataDisk: cfg.Install.DataDisk}
+}
+
+const gib = 1 << 30
+
+func (c DiskSizeCheck) readsFrom() []string {
+	return []string{"ConfigDevice"}
+}
+
+func (c DiskSizeCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "DiskSize"
+	if env.Inventory.InstallDevice == "" {
+		// ConfigDeviceCheck reports installation disks it can't resolve
+		return
+	}
+	devs, err := env.listBlockDevices()
+	if err != nil {
+		return
+	}
+	size := func(name string) (uint64, bool) {
+		for _, dev := range devs {
+			if dev.Name == name {
+				return dev.SizeBytes, true
+			}
+		}
+		return 0, false
+	}
+
+	dataDisk := ""
+	if c.DataDisk != "" {
+		resolved, err := env.evalSymlinks(filepath.Join(env.host().devDir, strings.TrimPrefix(c.DataDisk, "/dev/")))
+		if errors.Is(err, fs.ErrNotExist) {
+			// ConfigHardwareCheck reports missing disks
+			err = nil
+		} else if err != nil {
+			return result, err
+		} else if name := filepath.Base(resolved); name != env.Inventory.InstallDevice {
+			dataDisk = name
+		}
+	}
+
+	need := env.Options.thresholds(env.host().goarch)
+	var findings []string
+	check := func(description, dev string, minGiB uint64) {
+		bytes, ok := size(dev)
+		if !ok {
+			return
+		}
+		if bytes < minGiB*gib {
+			result.Severity = SeverityFatal
+			findings = append(findings, fmt.Sprintf("%s %s is %s, but SaftOS requires at least %dGiB for %s.",
+				description, dev, formatBytes(bytes), minGiB, env.Options.roleNode()))
+		} else {
+			findings = append(findings, fmt.Sprintf("%s %s is %s.", description, dev, formatBytes(bytes)))
+		}
+	}
+	if dataDisk == "" {
+		check("Installation disk", env.Inventory.InstallDevice, need.MinDiskGiB)
+	} else {
+		check("Installation disk", env.Inventory.InstallDevice, need.MinOSDiskGiB)
+		check("Data disk", dataDisk, need.MinDataDiskGiB)
+	}
+	result.Message = strings.Join(findings, " ")
+	return
+}
@@ -0,0 +1,95 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewDiskSizeCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.DataDisk = "/dev/sdb"
+	assert.Equal(t, DiskSizeCheck{DataDisk: "/dev/sdb"}, NewDiskSizeCheck(cfg))
+}
+
+func TestDiskSizeCheck(t *testing.T) {
+	h := testHost()
+	h.sysBlock = "./testdata/disk-size/sys/block"
+	h.devDir = "./testdata/disk-size/dev"
+
+	tests := []struct {
+		name     string
+		install  string
+		dataDisk string
+		role     Role
+		severity Severity
+		message  string
+	}{
+		{
+			name: "installation disk unknown",
+		},
+		{
+			name:    "single disk",
+			install: "sdc",
+			message: "Installation disk sdc is 500GiB.",
+		},
+		{
+			name:     "single disk too small",
+			install:  "sda",
+			severity: SeverityFatal,
+			message:  "Installation disk sda is 100GiB, but SaftOS requires at least 250GiB for a management node.",
+		},
+		{
+			name:    "single disk, witness",
+			install: "sda",
+			role:    RoleWitness,
+			message: "Installation disk sda is 100GiB.",
+		},
+		{
+			name:     "data disk is the installation disk",
+			install:  "sda",
+			dataDisk: "/dev/sda",
+			severity: SeverityFatal,
+			message:  "Installation disk sda is 100GiB, but SaftOS requires at least 250GiB for a management node.",
+		},
+		{
+			name:     "separate data disk",
+			install:  "sdc",
+			dataDisk: "/dev/disk/by-id/ata-DATA_DISK",
+			severity: SeverityFatal,
+			message:  "Installation disk sdc is 500GiB. Data disk sdb is 40GiB, but SaftOS requires at least 50GiB for a management node.",
+		},
+		{
+			name:     "separate data disk, witness",
+			install:  "sda",
+			dataDisk: "/dev/sdb",
+			role:     RoleWitness,
+			severity: SeverityFatal,
+			message:  "Installation disk sda is 100GiB. Data disk sdb is 40GiB, but SaftOS requires at least 50GiB for a witness node.",
+		},
+		{
+			name:     "separate data disk, small installation disk",
+			install:  "sda",
+			dataDisk: "/dev/sdc",
+			severity: SeverityFatal,
+			message:  "Installation disk sda is 100GiB, but SaftOS requires at least 180GiB for a management node. Data disk sdc is 500GiB.",
+		},
+		{
+			name:     "data disk missing",
+			install:  "sdc",
+			dataDisk: "/dev/sdz",
+			message:  "Installation disk sdc is 500GiB.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &Env{Options: Options{Role: tt.role}, Inventory: Inventory{InstallDevice: tt.install}, machine: h}
+			result, err := DiskSizeCheck{DataDisk: tt.dataDisk}.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "DiskSize", Severity: tt.severity, Message: tt.message}, result)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errNoSMBIOS is returned by the DMI collector on platforms which don't
+// have SMBIOS at all, such as many arm64 boards and some VMs.  Checks
+// which need DMI data should skip with this as the reason, rather than
+// fail, which the Runner does for them if they don't.
+var errNoSMBIOS error = &CheckError{Kind: ErrUnsupportedPlatform, Err: errors.New("SMBIOS not available on this platform")}
+
+// smbiosAvailable returns true if the firmware provides SMBIOS tables,
+// so there's some point in running dmidecode.
+func (e *Env) smbiosAvailable() bool {
+	_, err := os.Stat(e.host().sysFirmwareDMITables)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// skipNoSMBIOS sets up result as skipped, if err is errNoSMBIOS, and
+// returns nil in that case.  Otherwise, err is returned untouched.
+func skipNoSMBIOS(result *Result, err error) error {
+	if errors.Is(err, errNoSMBIOS) {
+		result.Severity = SeverityOK
+		result.Message = fmt.Sprintf("Skipped: %s.", errNoSMBIOS)
+		return nil
+	}
+	return err
+}
+
+// dmiProbes are the probes of a check which needs DMI data, so that
+// dmidecode runs in the inventory pass, unless there's nothing for it to
+// decode.
+func dmiProbes(env *Env) []toolCall {
+	if !env.smbiosAvailable() {
+		return nil
+	}
+	return []toolCall{{"/usr/sbin/dmidecode"}}
+}
+
+// dmi returns the DMI records of the given type, or errNoSMBIOS if there
+// aren't any DMI tables.  dmidecode's output is only decoded once per
+// preflight run, no matter how many checks need its data, and streamed
+// rather than taken from the tool cache if that kept only part of it.
+func (e *Env) dmi(typ int) ([]dmiRecord, error) {
+	if e.dmiRecords == nil && e.dmiErr == nil {
+		// As smbiosAvailable does, but recorded as evidence
+		if _, err := e.stat(e.host().sysFirmwareDMITables); errors.Is(err, fs.ErrNotExist) {
+			e.dmiErr = errNoSMBIOS
+			return nil, e.dmiErr
+		}
+		// Only the records are kept, not dmidecode's output
+		var p dmiParser
+		err := e.scanOutput(func(line string) bool {
+			p.feed(line)
+			return true
+		}, "/usr/sbin/dmidecode")
+		if errors.Is(err, errOutputTruncated) {
+			// The records before the cut are still worth having
+			logrus.Warnf("Some DMI records may be missing: %v", err)
+			err = nil
+		}
+		if err != nil {
+			e.dmiErr = fmt.Errorf("failed to run dmidecode: %w", err)
+		} else {
+			for _, anomaly := range p.Anomalies {
+				logrus.Warnf("Unexpected dmidecode output at %s", anomaly)
+			}
+			e.dmiRecords = p.Records
+			if e.dmiRecords == nil {
+				e.dmiRecords = []dmiRecord{}
+			}
+		}
+	}
+	if e.dmiErr != nil {
+		return nil, e.dmiErr
+	}
+	var records []dmiRecord
+	for _, record := range e.dmiRecords {
+		if record.Type == typ {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// dmidecodeOutput returns dmidecode's output, or errNoSMBIOS if there
+// aren't any DMI tables.  It's the same run as dmi decodes, so, as there,
+// if the tool cache kept only part of it, that part is returned.
+func (e *Env) dmidecodeOutput() ([]byte, error) {
+	if _, err := e.stat(e.host().sysFirmwareDMITables); errors.Is(err, fs.ErrNotExist) {
+		return nil, errNoSMBIOS
+	}
+	out, err := e.output("/usr/sbin/dmidecode")
+	if errors.Is(err, errOutputTruncated) {
+		logrus.Warnf("Some DMI records may be missing: %v", err)
+		err = nil
+	}
+	return out, err
+}
@@ -0,0 +1,81 @@
+package preflight
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvDMI(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+
+	runs := 0
+	var cmd *exec.Cmd
+	env := &Env{execCommand: func(_ string, _ ...string) *exec.Cmd {
+		runs++
+		cmd = fakeExecCommand("dmidecode-dell")
+		return cmd
+	}, machine: h}
+	bios, err := env.dmi(0)
+	assert.Nil(t, err)
+	assert.Len(t, bios, 1)
+	system, err := env.dmi(1)
+	assert.Nil(t, err)
+	assert.Len(t, system, 1)
+	memory, err := env.dmi(19)
+	assert.Nil(t, err)
+	assert.Empty(t, memory)
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, "LC_ALL=C", cmd.Env[len(cmd.Env)-1], "dmidecode must run in the C locale")
+
+	env = &Env{execCommand: fakeCommand("dmidecode-fail"), machine: h}
+	_, err = env.dmi(0)
+	assert.NotNil(t, err)
+}
+
+func TestEnvDMINoSMBIOS(t *testing.T) {
+	h := testHost()
+
+	runs := 0
+	h.sysFirmwareDMITables = "./testdata/dmi/none"
+	env := &Env{execCommand: func(_ string, _ ...string) *exec.Cmd {
+		runs++
+		return fakeExecCommand("dmidecode-dell")
+	}, machine: h}
+	_, err := env.dmi(0)
+	assert.ErrorIs(t, err, errNoSMBIOS)
+	_, err = env.dmi(1)
+	assert.ErrorIs(t, err, errNoSMBIOS)
+	assert.Equal(t, 0, runs)
+}
+
+func TestEnvDMITruncated(t *testing.T) {
+	h := testHost()
+	defer logrus.SetOutput(os.Stderr)
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+	var logged bytes.Buffer
+	logrus.SetOutput(&logged)
+
+	// The inventory pass kept only the output up to the second record
+	limit := strings.Index(execOutputs["dmidecode-dell"].output, "Handle 0x0100")
+	counter := &countingExecCommand{key: "dmidecode-dell"}
+	env := &Env{Options: Options{MaxToolOutput: int64(limit)}, execCommand: counter.command, machine: h}
+	_, err := env.output("/usr/sbin/dmidecode")
+	assert.ErrorIs(t, err, errOutputTruncated)
+
+	// So the records are streamed rather than decoded from what was kept
+	bios, err := env.dmi(0)
+	assert.Nil(t, err)
+	assert.Len(t, bios, 1)
+	system, err := env.dmi(1)
+	assert.Nil(t, err)
+	assert.Len(t, system, 1)
+	assert.NotContains(t, logged.String(), "Some DMI records may be missing")
+	assert.EqualValues(t, 2, counter.total.Load())
+}
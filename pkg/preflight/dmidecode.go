@@ -0,0 +1,207 @@
+package preflight
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// dmiSizeUnits are the units dmidecode gives sizes in, smallest first
+// (see http://git.savannah.nongnu.org/cgit/dmidecode.git/tree/dmidecode.c#n283)
+var dmiSizeUnits = []string{"bytes", "kB", "MB", "GB", "TB", "PB", "EB", "ZB"}
+
+// A dmiRecord is one structure from the output of dmidecode, e.g.
+//
+//	Handle 0x0000, DMI type 0, 26 bytes
+//	BIOS Information
+//		Vendor: Dell Inc.
+//		Version: 2.19.0
+//		Characteristics:
+//			PCI is supported
+//			UEFI is supported.
+//
+// has Handle "0x0000", Type 0, Title "BIOS Information", the Vendor and
+// Version in Fields, and the Characteristics in Lists.
+type dmiRecord struct {
+	Handle string
+	Type   int
+	Title  string
+	Fields map[string]string
+	Lists  map[string][]string
+}
+
+// A dmiAnomaly is a line of dmidecode output which the parser couldn't
+// make sense of.
+type dmiAnomaly struct {
+	Line   int
+	Text   string
+	Reason string
+}
+
+func (a dmiAnomaly) String() string {
+	return fmt.Sprintf("line %d, %s: %q", a.Line, a.Reason, a.Text)
+}
+
+// A dmiParser tokenises the output of dmidecode into records, a line at a
+// time.  The output is meant for people rather than programs, so the
+// parser is forgiving.  Fields are distinguished from list items and
+// wrapped values by indentation relative to the first field in each
+// record, counting tabs to the next multiple of eight, so it doesn't
+// matter how the output is indented overall, or whether it's been
+// through something which expanded the tabs.  Lines which can't be made
+// sense of are recorded in Anomalies, rather than dropped silently.
+type dmiParser struct {
+	Records   []dmiRecord
+	Anomalies []dmiAnomaly
+
+	line   int
+	record *dmiRecord
+	// skipping is set by a handle line which couldn't be parsed, until
+	// the end of its record
+	skipping    bool
+	fieldIndent int
+	listKey     string
+	// lastField is the field set by the previous line, which a further
+	// indented line without a colon continues
+	lastField string
+}
+
+// parseDMIDecode parses the output of dmidecode into records, and the
+// anomalies found on the way.
+func parseDMIDecode(out string) ([]dmiRecord, []dmiAnomaly) {
+	var p dmiParser
+	for _, line := range strings.Split(out, "\n") {
+		p.feed(line)
+	}
+	return p.Records, p.Anomalies
+}
+
+// feed parses the next line of output.
+func (p *dmiParser) feed(line string) {
+	p.line++
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "":
+		// Records are separated by blank lines
+		p.record, p.skipping = nil, false
+		return
+	case strings.HasPrefix(trimmed, "Handle "):
+		p.startRecord(line, trimmed)
+		return
+	case p.skipping || strings.HasPrefix(trimmed, "#"):
+		return
+	case p.record == nil:
+		// Before the first record is the preamble, e.g. "SMBIOS 3.3.0
+		// present.", but after it dmidecode only says something if the
+		// table is broken.
+		if len(p.Records) > 0 {
+			p.anomaly(line, "outside any record")
+		}
+		return
+	}
+
+	key, value, isField := strings.Cut(trimmed, ":")
+	if p.record.Title == "" && p.fieldIndent < 0 {
+		if !isField {
+			p.record.Title = trimmed
+			return
+		}
+		p.anomaly(line, "record has no title")
+	}
+
+	indent := indentation(line)
+	if p.fieldIndent < 0 {
+		p.fieldIndent = indent
+	}
+	switch {
+	case indent > p.fieldIndent && p.listKey != "":
+		p.record.Lists[p.listKey] = append(p.record.Lists[p.listKey], trimmed)
+	case indent > p.fieldIndent && !isField && p.lastField != "":
+		// A value which was wrapped onto the next line
+		p.record.Fields[p.lastField] += " " + trimmed
+	case !isField:
+		p.anomaly(line, "not a field")
+	case strings.TrimSpace(key) == "":
+		p.anomaly(line, "field has no name")
+	default:
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		p.listKey, p.lastField = "", ""
+		if value == "" {
+			p.listKey = key
+		} else {
+			p.record.Fields[key] = value
+			p.lastField = key
+		}
+	}
+}
+
+// startRecord parses a handle line, e.g. "Handle 0x0000, DMI type 0, 26
+// bytes", which starts a record.
+func (p *dmiParser) startRecord(line, trimmed string) {
+	p.record, p.skipping = nil, false
+	handle, info, _ := strings.Cut(strings.TrimPrefix(trimmed, "Handle "), ",")
+	handle = strings.TrimSpace(handle)
+	fields := strings.Fields(info)
+	typ := -1
+	if len(fields) >= 3 && fields[0] == "DMI" && fields[1] == "type" {
+		if n, err := strconv.Atoi(strings.TrimSuffix(fields[2], ",")); err == nil && n >= 0 && n <= 255 {
+			typ = n
+		}
+	}
+	if _, err := strconv.ParseUint(strings.TrimPrefix(handle, "0x"), 16, 16); err != nil || !strings.HasPrefix(handle, "0x") || typ < 0 {
+		p.anomaly(line, "malformed handle")
+		p.skipping = true
+		return
+	}
+	p.Records = append(p.Records, dmiRecord{
+		Handle: handle,
+		Type:   typ,
+		Fields: map[string]string{},
+		Lists:  map[string][]string{},
+	})
+	p.record = &p.Records[len(p.Records)-1]
+	p.fieldIndent = -1
+	p.listKey, p.lastField = "", ""
+}
+
+func (p *dmiParser) anomaly(line, reason string) {
+	p.Anomalies = append(p.Anomalies, dmiAnomaly{Line: p.line, Text: strings.TrimSpace(line), Reason: reason})
+}
+
+// indentation returns the column the text of line starts at, with tabs
+// to the next multiple of eight.
+func indentation(line string) (column int) {
+	for _, r := range line {
+		switch r {
+		case ' ':
+			column++
+		case '\t':
+			column += 8 - column%8
+		default:
+			return
+		}
+	}
+	return
+}
+
+// parseDMISize parses a size as dmidecode gives it, e.g. "2 GB", however
+// it's spaced, returning the amount and the unit, which is one of
+// dmiSizeUnits.
+func parseDMISize(value string) (uint64, string, error) {
+	compact := strings.Join(strings.Fields(value), "")
+	digits := strings.TrimPrefix(compact, "-")
+	i := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return 0, "", fmt.Errorf("%q is not a size", value)
+	}
+	n, err := parseCount(compact[:len(compact)-len(digits)+i])
+	if err != nil {
+		return 0, "", fmt.Errorf("%q %s", value, err)
+	}
+	unit := compact[i:]
+	if !slices.Contains(dmiSizeUnits, unit) {
+		return 0, "", fmt.Errorf("%q is not a size unit dmidecode uses", unit)
+	}
+	return n, unit, nil
+}
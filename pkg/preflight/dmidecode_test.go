@@ -0,0 +1,190 @@
+package preflight
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readDMIFixture(t *testing.T, name string) string {
+	data, err := os.ReadFile(filepath.Join("testdata/dmidecode", name))
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestParseDMIDecode(t *testing.T) {
+	records, anomalies := parseDMIDecode(execOutputs["dmidecode-dell"].output)
+	assert.Empty(t, anomalies)
+	assert.Equal(t, []dmiRecord{
+		{
+			Handle: "0x0000",
+			Type:   0,
+			Title:  "BIOS Information",
+			Fields: map[string]string{
+				"Vendor":        "Dell Inc.",
+				"Version":       "1.5.6",
+				"Release Date":  "06/14/2022",
+				"BIOS Revision": "1.5",
+			},
+			Lists: map[string][]string{
+				"Characteristics": {"PCI is supported", "UEFI is supported."},
+			},
+		},
+		{
+			Handle: "0x0100",
+			Type:   1,
+			Title:  "System Information",
+			Fields: map[string]string{
+				"Manufacturer":  "Dell Inc.",
+				"Product Name":  "PowerEdge R750",
+				"Serial Number": "ABC1234",
+				"UUID":          "4c4c4544-0042-4310-8033-b4c04f333233",
+			},
+			Lists: map[string][]string{},
+		},
+	}, records)
+
+	// Tabs count to the next multiple of eight, so these are all list
+	// items, however they're indented
+	records, anomalies = parseDMIDecode("Handle 0x0000, DMI type 0, 26 bytes\nBIOS Information\n" +
+		"  Characteristics:\n\tPCI is supported\n   \tUEFI is supported\n")
+	assert.Empty(t, anomalies)
+	assert.Equal(t, []string{"PCI is supported", "UEFI is supported"}, records[0].Lists["Characteristics"])
+}
+
+func TestParseDMIDecodeVendors(t *testing.T) {
+	tests := []struct {
+		fixture      string
+		types        map[int]int
+		manufacturer string
+		product      string
+		chassis      string
+		rangeSizes   []string
+	}{
+		{
+			fixture:      "dell-poweredge-r750.txt",
+			types:        map[int]int{0: 1, 1: 1, 3: 1, 4: 2, 16: 1, 19: 2, 38: 1, 127: 1},
+			manufacturer: "Dell Inc.",
+			product:      "PowerEdge R750",
+			chassis:      "Rack Mount Chassis",
+			rangeSizes:   []string{"2 GB", "254 GB"},
+		},
+		{
+			fixture:      "hpe-proliant-dl380-gen10.txt",
+			types:        map[int]int{0: 1, 1: 1, 3: 1, 4: 2, 16: 1, 19: 2, 39: 1, 199: 1, 127: 1},
+			manufacturer: "HPE",
+			product:      "ProLiant DL380 Gen10",
+			chassis:      "Rack Mount Chassis",
+			rangeSizes:   []string{"3 GB", "381 GB"},
+		},
+		{
+			// The tabs have been expanded, and a value wrapped
+			fixture:      "supermicro-x11dpi.txt",
+			types:        map[int]int{0: 1, 1: 1, 2: 1, 3: 1, 4: 2, 16: 1, 19: 1, 38: 1, 127: 1},
+			manufacturer: "Supermicro",
+			product:      "SYS-6029P-TRT",
+			chassis:      "Other",
+			rangeSizes:   []string{"128 GB"},
+		},
+		{
+			// A workstation, whose firmware describes its flash too
+			fixture:      "desktop-non-ecc.txt",
+			types:        map[int]int{0: 1, 1: 1, 3: 1, 4: 1, 16: 2, 19: 1, 127: 1},
+			manufacturer: "ASUS",
+			product:      "System Product Name",
+			chassis:      "Desktop",
+			rangeSizes:   []string{"64 GB"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			records, anomalies := parseDMIDecode(readDMIFixture(t, tt.fixture))
+			assert.Empty(t, anomalies)
+			types := map[int]int{}
+			var rangeSizes []string
+			for _, record := range records {
+				types[record.Type]++
+				switch record.Type {
+				case 0:
+					assert.Contains(t, record.Lists["Characteristics"], "UEFI is supported")
+				case 1:
+					assert.Equal(t, tt.manufacturer, record.Fields["Manufacturer"])
+					assert.Equal(t, tt.product, record.Fields["Product Name"])
+				case 3:
+					assert.Equal(t, tt.chassis, record.Fields["Type"])
+				case 4:
+					assert.Equal(t, "Populated, Enabled", record.Fields["Status"])
+				case 19:
+					rangeSizes = append(rangeSizes, record.Fields["Range Size"])
+				}
+			}
+			assert.Equal(t, tt.types, types)
+			assert.Equal(t, tt.rangeSizes, rangeSizes)
+		})
+	}
+
+	records, _ := parseDMIDecode(readDMIFixture(t, "hpe-proliant-dl380-gen10.txt"))
+	oem := records[len(records)-2]
+	assert.Equal(t, "OEM-specific Type", oem.Title)
+	assert.Len(t, oem.Lists["Header and Data"], 2)
+}
+
+func TestParseDMIDecodeCorrupted(t *testing.T) {
+	records, anomalies := parseDMIDecode(readDMIFixture(t, "corrupted.txt"))
+	assert.Equal(t, []dmiAnomaly{
+		{Line: 8, Text: "Version 1.0", Reason: "not a field"},
+		{Line: 11, Text: "Handle 0xZZZZ, DMI type nineteen, 31 bytes", Reason: "malformed handle"},
+		{Line: 16, Text: "Starting Address: 0x00000000000", Reason: "record has no title"},
+		{Line: 18, Text: ": 0x1000", Reason: "field has no name"},
+		{Line: 32, Text: "Invalid entry length (0). DMI table is broken! Stop.", Reason: "outside any record"},
+	}, anomalies)
+	assert.Equal(t, `line 8, not a field: "Version 1.0"`, anomalies[0].String())
+
+	// What could be made sense of is kept
+	var handles, rangeSizes []string
+	for _, record := range records {
+		handles = append(handles, record.Handle)
+		rangeSizes = append(rangeSizes, record.Fields["Range Size"])
+	}
+	assert.Equal(t, []string{"0x0000", "0x1300", "0x1301", "0x1302", "0x1303"}, handles)
+	assert.Equal(t, []string{"", "8GB", "lots", "4 GiB", "2048 MB"}, rangeSizes)
+	assert.Equal(t, "Acme", records[0].Fields["Vendor"])
+	assert.Equal(t, "01/01/2020", records[0].Fields["Release Date"])
+}
+
+func TestParseDMISize(t *testing.T) {
+	tests := []struct {
+		value  string
+		amount uint64
+		unit   string
+		err    string
+	}{
+		{value: "2 GB", amount: 2, unit: "GB"},
+		{value: "2048\tMB", amount: 2048, unit: "MB"},
+		{value: " 8GB ", amount: 8, unit: "GB"},
+		{value: "1 2 8 GB", amount: 128, unit: "GB"},
+		{value: "640 kB", amount: 640, unit: "kB"},
+		{value: "512 bytes", amount: 512, unit: "bytes"},
+		{value: "2 TB", amount: 2, unit: "TB"},
+		{value: "4 GiB", err: `"GiB" is not a size unit dmidecode uses`},
+		{value: "lots", err: `"lots" is not a size`},
+		{value: "", err: `"" is not a size`},
+		{value: "99999999999999999999 GB", err: `"99999999999999999999 GB" overflows`},
+		{value: "-2 GB", err: `"-2 GB" is negative`},
+		{value: "-GB", err: `"-GB" is not a size`},
+		{value: "２ GB", err: `"２ GB" is not a size`},
+	}
+	for _, tt := range tests {
+		amount, unit, err := parseDMISize(tt.value)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.value)
+			continue
+		}
+		assert.Nil(t, err, tt.value)
+		assert.Equal(t, tt.amount, amount, tt.value)
+		assert.Equal(t, tt.unit, unit, tt.value)
+	}
+}
@@ -0,0 +1,305 @@
+package preflight
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	// DefaultDNSProbeName is the name ConfiguredDNSCheck looks up, unless
+	// Options.DNSProbeName says otherwise.  It's one the installed system
+	// will need to resolve.
+	DefaultDNSProbeName = "docker.io"
+
+	dnsPort    = 53
+	dnsTimeout = 2 * time.Second
+)
+
+// DNS response codes, as in RFC 1035 section 4.1.1
+const (
+	dnsRCodeSuccess        = 0
+	dnsRCodeFormatError    = 1
+	dnsRCodeServerFailure  = 2
+	dnsRCodeNameError      = 3
+	dnsRCodeNotImplemented = 4
+	dnsRCodeRefused        = 5
+)
+
+var dnsRCodeNames = map[int]string{
+	dnsRCodeSuccess:        "NOERROR",
+	dnsRCodeFormatError:    "FORMERR",
+	dnsRCodeServerFailure:  "SERVFAIL",
+	dnsRCodeNameError:      "NXDOMAIN",
+	dnsRCodeNotImplemented: "NOTIMP",
+	dnsRCodeRefused:        "REFUSED",
+}
+
+// A dnsResponse summarizes the response to a DNS query.
+type dnsResponse struct {
+	RCode              int
+	RecursionAvailable bool
+	Answers            int
+	// TCP means the response was truncated over UDP, so it was
+	// repeated over TCP.
+	TCP     bool
+	Latency time.Duration
+}
+
+// A dnsClient queries a specific DNS server for the A records of a name.
+type dnsClient interface {
+	Query(ctx context.Context, server, name string) (dnsResponse, error)
+}
+
+// ConfiguredDNSCheck verifies that the DNS servers in the install
+// configuration answer queries, since a mistyped address otherwise only
+// shows up once the installed system can't pull images.  Each server is
+// queried directly, bypassing the live environment's resolver, for
+// Options.DNSProbeName.  Some servers not answering is warned about, and
+// none answering is fatal.  If the probe name is external, i.e. not in
+// the search domains, servers which refuse to recurse for it count as not
+// answering, because the installed system needs them to.  When the host
+// is air-gapped, servers on the internet aren't queried, and external
+// probe names aren't expected to resolve, so any response counts, except
+// SERVFAIL, which says the server couldn't answer at all.  Only the
+// servers which were queried are counted.
+type ConfiguredDNSCheck struct {
+	Servers []string
+	Client  dnsClient
+}
+
+// NewConfiguredDNSCheck returns a ConfiguredDNSCheck for the DNS servers
+// in the given install configuration.
+func NewConfiguredDNSCheck(cfg *config.HarvesterConfig) ConfiguredDNSCheck {
+	return ConfiguredDNSCheck{
+		Servers: cfg.OS.DNSNameservers,
+		Client:  udpDNSClient{Port: dnsPort, Timeout: dnsTimeout},
+	}
+}
+
+func (c ConfiguredDNSCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c ConfiguredDNSCheck) readsFrom() []string {
+	return []string{"ResolvConf"}
+}
+
+func (c ConfiguredDNSCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "ConfiguredDNS"
+	if len(c.Servers) == 0 {
+		result.Message = "Skipped: the install configuration does not set any DNS servers."
+		return
+	}
+	name := env.Options.DNSProbeName
+	if name == "" {
+		name = DefaultDNSProbeName
+	}
+	external := isExternalName(name, env.Inventory.SearchDomains)
+	unresolvable := external && env.Options.AirGapped
+	if unresolvable {
+		external = false
+		result.AirGapped = true
+	}
+
+	var findings, skipped []string
+	queried, answering := 0, 0
+	for _, server := range c.Servers {
+		if net.ParseIP(server) == nil {
+			findings = append(findings, fmt.Sprintf("%s: not an IP address.", server))
+			continue
+		}
+		if env.Options.AirGapped && onInternet(server) {
+			skipped = append(skipped, notContacted(server))
+			result.AirGapped = true
+			continue
+		}
+		queried++
+		resp, err := c.Client.Query(ctx, server, name)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		via := ""
+		if resp.TCP {
+			via = " over TCP"
+		}
+		latency := resp.Latency.Round(time.Millisecond)
+		rcode := dnsRCodeNames[resp.RCode]
+		if rcode == "" {
+			rcode = fmt.Sprintf("RCODE %d", resp.RCode)
+		}
+		switch {
+		case err != nil:
+			findings = append(findings, fmt.Sprintf("%s: %v.", server, err))
+		case external && (resp.RCode == dnsRCodeRefused || (!resp.RecursionAvailable && resp.Answers == 0)):
+			findings = append(findings, fmt.Sprintf("%s: responded%s in %s, but refuses to recurse, "+
+				"so it cannot resolve external names such as %s.", server, via, latency, name))
+		case resp.RCode == dnsRCodeSuccess || resp.RCode == dnsRCodeNameError || (unresolvable && resp.RCode != dnsRCodeServerFailure):
+			answering++
+			findings = append(findings, fmt.Sprintf("%s: answered %s%s in %s.", server, rcode, via, latency))
+		default:
+			findings = append(findings, fmt.Sprintf("%s: responded %s%s in %s.", server, rcode, via, latency))
+		}
+	}
+
+	if len(findings) == 0 {
+		result.Message = "Skipped: " + strings.Join(skipped, " ")
+		return
+	}
+	summary := fmt.Sprintf("%d of %d queried DNS servers answered a query for %s.", answering, queried, name)
+	if queried == 0 {
+		summary = "None of the configured DNS servers could be queried."
+	} else if unresolvable {
+		summary += fmt.Sprintf(" In %s, %s is not expected to resolve.", airGappedMode, name)
+	}
+	switch {
+	case answering == 0:
+		result.Severity = SeverityFatal
+		summary += " The installed system will not be able to resolve names."
+	case answering < len(findings):
+		// Some didn't answer, or aren't addresses at all
+		result.Severity = SeverityWarning
+	}
+	result.Message = summary + " " + strings.Join(append(findings, skipped...), " ")
+	return
+}
+
+// isExternalName returns whether name is outside the search domains, and
+// so would need a recursive server to resolve it.
+func isExternalName(name string, searchDomains []string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if !strings.Contains(name, ".") {
+		return false
+	}
+	for _, domain := range searchDomains {
+		domain = strings.TrimSuffix(domain, ".")
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return false
+		}
+	}
+	return true
+}
+
+// udpDNSClient queries DNS servers over UDP, repeating the query over TCP
+// if the response is truncated.  Each attempt has Timeout to complete.
+type udpDNSClient struct {
+	Port    int
+	Timeout time.Duration
+	// Device, if set, is the interface queries are sent from, regardless
+	// of the routing table.
+	Device string
+}
+
+func (c udpDNSClient) Query(ctx context.Context, server, name string) (resp dnsResponse, err error) {
+	query, err := newDNSQuery(name)
+	if err != nil {
+		return resp, err
+	}
+	addr := net.JoinHostPort(server, strconv.Itoa(c.Port))
+	start := time.Now()
+	reply, err := c.exchange(ctx, "udp", addr, query)
+	if err == nil && reply[2]&0x02 != 0 {
+		resp.TCP = true
+		reply, err = c.exchange(ctx, "tcp", addr, query)
+	}
+	resp.Latency = time.Since(start)
+	if err != nil {
+		return resp, err
+	}
+	resp.RCode = int(reply[3] & 0x0f)
+	resp.RecursionAvailable = reply[3]&0x80 != 0
+	resp.Answers = int(binary.BigEndian.Uint16(reply[6:]))
+	return resp, nil
+}
+
+// exchange sends query to addr, returning the response's message, or at
+// least its header.
+func (c udpDNSClient) exchange(ctx context.Context, network, addr string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	reply := make([]byte, 65535)
+	n, err := c.roundTrip(ctx, network, addr, query, reply)
+	transport := strings.ToUpper(network)
+	switch {
+	case isTimeout(err) || errors.Is(err, context.DeadlineExceeded):
+		return nil, fmt.Errorf("no response within %s over %s", c.Timeout, transport)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return nil, fmt.Errorf("nothing is listening on port %d over %s", c.Port, transport)
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return nil, fmt.Errorf("closed the %s connection without responding", transport)
+	case err != nil:
+		return nil, fmt.Errorf("no response over %s: %w", transport, err)
+	case n < 12 || reply[0] != query[0] || reply[1] != query[1]:
+		return nil, fmt.Errorf("sent an invalid response over %s", transport)
+	}
+	return reply[:n], nil
+}
+
+// roundTrip sends query to addr and reads the response into reply,
+// returning its length.
+func (c udpDNSClient) roundTrip(ctx context.Context, network, addr string, query, reply []byte) (n int, err error) {
+	dialer := net.Dialer{Control: bindToDevice(c.Device)}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if network == "tcp" {
+		// Messages are prefixed with their length over TCP
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err = conn.Write(append(framed, query...)); err != nil {
+			return 0, err
+		}
+		if _, err = io.ReadFull(conn, reply[:2]); err != nil {
+			return 0, err
+		}
+		return io.ReadFull(conn, reply[:binary.BigEndian.Uint16(reply)])
+	}
+
+	if _, err = conn.Write(query); err != nil {
+		return 0, err
+	}
+	for {
+		// Anything that isn't a response to this query is ignored
+		if n, err = conn.Read(reply); err != nil {
+			return 0, err
+		}
+		if n >= 12 && reply[0] == query[0] && reply[1] == query[1] && reply[2]&0x80 != 0 {
+			return n, nil
+		}
+	}
+}
+
+// newDNSQuery returns a recursive query for the A records of name, with a
+// random ID.
+func newDNSQuery(name string) ([]byte, error) {
+	query := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(query, uint16(rand.Intn(1<<16)))
+	// Recursion desired
+	query[2] = 0x01
+	// One question
+	binary.BigEndian.PutUint16(query[4:], 1)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("%q is not a valid DNS name", name)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	// The root label, then type A and class IN
+	query = append(query, 0, 0, 1, 0, 1)
+	return query, nil
+}
@@ -0,0 +1,306 @@
+package preflight
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// dnsBehaviour is how a fake DNS server responds
+type dnsBehaviour int
+
+const (
+	dnsHealthy dnsBehaviour = iota
+	dnsSilent
+	dnsRefusing
+	dnsNonRecursive
+	// dnsTruncating truncates responses over UDP, and answers over TCP
+	dnsTruncating
+)
+
+// fakeDNSReply returns the response to query a fake DNS server behaving
+// as told sends over the given network.
+func fakeDNSReply(query []byte, behaviour dnsBehaviour, network string) []byte {
+	reply := append([]byte(nil), query...)
+	// A response, with recursion desired and available
+	reply[2] = 0x81
+	reply[3] = 0x80
+	switch {
+	case behaviour == dnsRefusing:
+		reply[3] |= dnsRCodeRefused
+	case behaviour == dnsNonRecursive:
+		reply[3] = 0
+	case behaviour == dnsTruncating && network == "udp":
+		reply[2] |= 0x02
+	default:
+		binary.BigEndian.PutUint16(reply[6:], 1)
+		reply = append(reply, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1)
+	}
+	return reply
+}
+
+// newFakeDNSServer starts a DNS server on ip and port, over both UDP and
+// TCP, which behaves as told.
+func newFakeDNSServer(t *testing.T, ip string, port int, behaviour dnsBehaviour) {
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if behaviour != dnsSilent {
+				conn.WriteTo(fakeDNSReply(buf[:n], behaviour, "udp"), addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 512)
+				if _, err := io.ReadFull(c, buf[:2]); err != nil {
+					return
+				}
+				n := binary.BigEndian.Uint16(buf)
+				if _, err := io.ReadFull(c, buf[:n]); err != nil || behaviour == dnsSilent {
+					return
+				}
+				reply := fakeDNSReply(buf[:n], behaviour, "tcp")
+				c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+			}()
+		}
+	}()
+}
+
+// freeDNSPort returns a port which is free over both UDP and TCP on the
+// loopback addresses
+func freeDNSPort(t *testing.T) int {
+	for i := 0; i < 10; i++ {
+		port := freeUDPPort(t)
+		if listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+			listener.Close()
+			return port
+		}
+	}
+	t.Fatal("no free port")
+	return 0
+}
+
+func TestUDPDNSClient(t *testing.T) {
+	port := freeDNSPort(t)
+	newFakeDNSServer(t, "127.0.0.1", port, dnsHealthy)
+	newFakeDNSServer(t, "127.0.0.2", port, dnsSilent)
+	newFakeDNSServer(t, "127.0.0.3", port, dnsRefusing)
+	newFakeDNSServer(t, "127.0.0.4", port, dnsNonRecursive)
+	newFakeDNSServer(t, "127.0.0.5", port, dnsTruncating)
+	client := udpDNSClient{Port: port, Timeout: 200 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		server   string
+		response dnsResponse
+		err      string
+	}{
+		{
+			name:     "healthy",
+			server:   "127.0.0.1",
+			response: dnsResponse{RecursionAvailable: true, Answers: 1},
+		},
+		{
+			name:   "silent",
+			server: "127.0.0.2",
+			err:    "no response within 200ms over UDP",
+		},
+		{
+			name:     "refusing",
+			server:   "127.0.0.3",
+			response: dnsResponse{RCode: dnsRCodeRefused, RecursionAvailable: true},
+		},
+		{
+			name:     "non-recursive",
+			server:   "127.0.0.4",
+			response: dnsResponse{},
+		},
+		{
+			name:     "truncating",
+			server:   "127.0.0.5",
+			response: dnsResponse{RecursionAvailable: true, Answers: 1, TCP: true},
+		},
+		{
+			name:   "nothing listening",
+			server: "127.0.0.6",
+			err:    "nothing is listening on port " + strconv.Itoa(port) + " over UDP",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := client.Query(context.Background(), tt.server, "docker.io")
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Less(t, response.Latency, client.Timeout)
+			response.Latency = 0
+			assert.Equal(t, tt.response, response)
+		})
+	}
+}
+
+func TestNewDNSQuery(t *testing.T) {
+	query, err := newDNSQuery("docker.io.")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 0, 0, 1, 0, 0, 0, 0, 0, 0}, query[2:12])
+	assert.Equal(t, "\x06docker\x02io\x00\x00\x01\x00\x01", string(query[12:]))
+
+	_, err = newDNSQuery("docker..io")
+	assert.EqualError(t, err, `"docker..io" is not a valid DNS name`)
+}
+
+// fakeDNSClient returns canned responses for each server
+type fakeDNSClient map[string]fakeDNSAnswer
+
+type fakeDNSAnswer struct {
+	response dnsResponse
+	err      error
+}
+
+func (c fakeDNSClient) Query(_ context.Context, server, _ string) (dnsResponse, error) {
+	answer := c[server]
+	return answer.response, answer.err
+}
+
+func TestNewConfiguredDNSCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.OS.DNSNameservers = []string{"10.0.0.2", "10.0.0.3"}
+	check := NewConfiguredDNSCheck(cfg)
+	assert.Equal(t, cfg.OS.DNSNameservers, check.Servers)
+	assert.Equal(t, udpDNSClient{Port: dnsPort, Timeout: dnsTimeout}, check.Client)
+}
+
+func TestConfiguredDNSCheck(t *testing.T) {
+	client := fakeDNSClient{
+		"10.0.0.2": {response: dnsResponse{RecursionAvailable: true, Answers: 1, Latency: 12 * time.Millisecond}},
+		"10.0.0.3": {response: dnsResponse{RecursionAvailable: true, Answers: 1, TCP: true, Latency: 31 * time.Millisecond}},
+		"10.0.0.4": {err: errors.New("no response within 2s over UDP")},
+		"10.0.0.5": {response: dnsResponse{RCode: dnsRCodeRefused, RecursionAvailable: true, Latency: 4 * time.Millisecond}},
+		"10.0.0.6": {response: dnsResponse{Latency: 5 * time.Millisecond}},
+		"10.0.0.7": {response: dnsResponse{RCode: dnsRCodeServerFailure, RecursionAvailable: true, Latency: 40 * time.Millisecond}},
+		"10.0.0.8": {response: dnsResponse{RCode: dnsRCodeNameError, RecursionAvailable: true, Latency: 9 * time.Millisecond}},
+	}
+
+	tests := []struct {
+		name      string
+		servers   []string
+		probeName string
+		airGapped bool
+		severity  Severity
+		message   string
+	}{
+		{
+			name:    "none",
+			message: "Skipped: the install configuration does not set any DNS servers.",
+		},
+		{
+			name:    "all answering",
+			servers: []string{"10.0.0.2", "10.0.0.3"},
+			message: "2 of 2 queried DNS servers answered a query for docker.io. " +
+				"10.0.0.2: answered NOERROR in 12ms. " +
+				"10.0.0.3: answered NOERROR over TCP in 31ms.",
+		},
+		{
+			name:     "some dead",
+			servers:  []string{"10.0.0.2", "10.0.0.4", "10.0.0.7", "10.0.0.300"},
+			severity: SeverityWarning,
+			message: "1 of 3 queried DNS servers answered a query for docker.io. " +
+				"10.0.0.2: answered NOERROR in 12ms. " +
+				"10.0.0.4: no response within 2s over UDP. " +
+				"10.0.0.7: responded SERVFAIL in 40ms. " +
+				"10.0.0.300: not an IP address.",
+		},
+		{
+			name:     "all dead or refusing",
+			servers:  []string{"10.0.0.4", "10.0.0.5", "10.0.0.6"},
+			severity: SeverityFatal,
+			message: "0 of 3 queried DNS servers answered a query for docker.io. " +
+				"The installed system will not be able to resolve names. " +
+				"10.0.0.4: no response within 2s over UDP. " +
+				"10.0.0.5: responded in 4ms, but refuses to recurse, so it cannot resolve external names such as docker.io. " +
+				"10.0.0.6: responded in 5ms, but refuses to recurse, so it cannot resolve external names such as docker.io.",
+		},
+		{
+			name:      "non-recursive with internal name",
+			servers:   []string{"10.0.0.6", "10.0.0.8"},
+			probeName: "registry.example.com",
+			message: "2 of 2 queried DNS servers answered a query for registry.example.com. " +
+				"10.0.0.6: answered NOERROR in 5ms. " +
+				"10.0.0.8: answered NXDOMAIN in 9ms.",
+		},
+		{
+			// Air-gapped servers aren't expected to resolve external
+			// names, but SERVFAIL still isn't an answer
+			name:      "air-gapped",
+			servers:   []string{"10.0.0.5", "10.0.0.7"},
+			airGapped: true,
+			severity:  SeverityWarning,
+			message: "1 of 2 queried DNS servers answered a query for docker.io. " +
+				"In air-gapped mode, docker.io is not expected to resolve. " +
+				"10.0.0.5: answered REFUSED in 4ms. " +
+				"10.0.0.7: responded SERVFAIL in 40ms.",
+		},
+		{
+			name:     "not addresses",
+			servers:  []string{"dns.example.com"},
+			severity: SeverityFatal,
+			message: "None of the configured DNS servers could be queried. " +
+				"The installed system will not be able to resolve names. " +
+				"dns.example.com: not an IP address.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := ConfiguredDNSCheck{Servers: tt.servers, Client: client}
+			env := &Env{Options: Options{DNSProbeName: tt.probeName, AirGapped: tt.airGapped}}
+			env.Inventory.SearchDomains = []string{"example.com"}
+			result, err := check.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, "ConfiguredDNS", result.Name)
+			assert.Equal(t, tt.severity, result.Severity)
+			assert.Equal(t, tt.message, result.Message)
+		})
+	}
+}
+
+func TestIsExternalName(t *testing.T) {
+	search := []string{"example.com."}
+	assert.True(t, isExternalName("docker.io", search))
+	assert.False(t, isExternalName("registry.example.com.", search))
+	assert.False(t, isExternalName("example.com", search))
+	assert.False(t, isExternalName("registry", search))
+}
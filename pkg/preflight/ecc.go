@@ -0,0 +1,117 @@
+package preflight
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ECCMemoryCheck warns if the RAM isn't ECC, which SaftOS requires for
+// production use, since without it a flipped bit silently corrupts VM
+// disks and cluster state.  The firmware says how each physical memory
+// array corrects errors in its DMI type 16 record.  Where it doesn't say,
+// or dmidecode isn't installed, that's logged and the check passes, as
+// test installs mustn't be stopped by the firmware's silence.
+type ECCMemoryCheck struct{}
+
+// eccErrorCorrection says which of dmidecode's error correction types
+// are ECC.  Parity only detects errors.  The rest, "Other" and "Unknown",
+// say nothing.
+var eccErrorCorrection = map[string]bool{
+	"Single-bit ECC": true,
+	"Multi-bit ECC":  true,
+	"CRC":            true,
+	"None":           false,
+	"Parity":         false,
+}
+
+// A memoryArray is a physical memory array, as described by its DMI type
+// 16 record, e.g.
+//
+//	Handle 0x1000, DMI type 16, 23 bytes
+//	Physical Memory Array
+//		Location: System Board Or Motherboard
+//		Use: System Memory
+//		Error Correction Type: Multi-bit ECC
+//		Maximum Capacity: 12 TB
+//		Error Information Handle: Not Provided
+//		Number Of Devices: 32
+type memoryArray struct {
+	Handle string
+	// Use is what the array is for, e.g. "System Memory" or "Flash
+	// Memory".
+	Use string
+	// ErrorCorrection is how it corrects errors, e.g. "Multi-bit ECC", or
+	// empty if the firmware doesn't say.
+	ErrorCorrection string
+}
+
+// memoryArrays returns the physical memory arrays among records.
+func memoryArrays(records []dmiRecord) []memoryArray {
+	var arrays []memoryArray
+	for _, record := range records {
+		if record.Type == 16 {
+			arrays = append(arrays, memoryArray{
+				Handle:          record.Handle,
+				Use:             record.Fields["Use"],
+				ErrorCorrection: record.Fields["Error Correction Type"],
+			})
+		}
+	}
+	return arrays
+}
+
+func (c ECCMemoryCheck) probes(env *Env) []toolCall {
+	return dmiProbes(env)
+}
+
+func (c ECCMemoryCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ECCMemory"
+	records, err := env.dmi(16)
+	if errors.Is(err, ErrToolMissing) {
+		logrus.Infof("Unable to determine whether the RAM is ECC: %v", err)
+		result.Message = "Unable to determine whether the RAM is ECC, because dmidecode is not installed."
+		return result, nil
+	}
+	if err = skipNoSMBIOS(&result, err); err != nil || result.Message != "" {
+		return
+	}
+
+	var ecc, nonECC, unknown []string
+	for _, array := range memoryArrays(records) {
+		if array.Use != "System Memory" {
+			continue
+		}
+		correction := cmp.Or(array.ErrorCorrection, "not reported")
+		isECC, known := eccErrorCorrection[correction]
+		switch {
+		case !known && !slices.Contains(unknown, correction):
+			unknown = append(unknown, correction)
+		case known && !isECC && !slices.Contains(nonECC, correction):
+			nonECC = append(nonECC, correction)
+		case isECC && !slices.Contains(ecc, correction):
+			ecc = append(ecc, correction)
+		}
+	}
+	switch {
+	case len(nonECC) > 0:
+		result.Severity = SeverityWarning
+		result.Message = "Non-ECC RAM detected (error correction: " + strings.Join(nonECC, ", ") +
+			"). SaftOS requires ECC memory for production use."
+		result.Facts = map[string]any{"errorCorrection": strings.Join(nonECC, ", ")}
+	case len(unknown) > 0 || len(ecc) == 0:
+		// Passing is all that can be done
+		if len(unknown) == 0 {
+			unknown = []string{"no memory arrays"}
+		}
+		logrus.Infof("The firmware does not say whether the RAM is ECC (%s)", strings.Join(unknown, ", "))
+		result.Message = "Unable to determine whether the RAM is ECC, because the firmware does not say."
+	default:
+		result.Facts = map[string]any{"errorCorrection": strings.Join(ecc, ", ")}
+	}
+	return
+}
@@ -0,0 +1,110 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryArrays(t *testing.T) {
+	records, _ := parseDMIDecode(readDMIFixture(t, "dell-poweredge-r750.txt"))
+	assert.Equal(t, []memoryArray{{Handle: "0x1000", Use: "System Memory", ErrorCorrection: "Multi-bit ECC"}}, memoryArrays(records))
+	records, _ = parseDMIDecode(readDMIFixture(t, "desktop-non-ecc.txt"))
+	assert.Equal(t, []memoryArray{
+		{Handle: "0x000F", Use: "System Memory", ErrorCorrection: "None"},
+		{Handle: "0x0010", Use: "Flash Memory", ErrorCorrection: "Unknown"},
+	}, memoryArrays(records))
+}
+
+func TestECCMemoryCheck(t *testing.T) {
+	defer logrus.SetOutput(os.Stderr)
+	tests := []struct {
+		fixture string
+		dmi     string
+		result  Result
+		logged  string
+	}{
+		{
+			fixture: "dell-poweredge-r750.txt",
+			result:  Result{Name: "ECCMemory", Facts: map[string]any{"errorCorrection": "Multi-bit ECC"}},
+		},
+		{
+			// The tabs have been expanded
+			fixture: "supermicro-x11dpi.txt",
+			result:  Result{Name: "ECCMemory", Facts: map[string]any{"errorCorrection": "Single-bit ECC"}},
+		},
+		{
+			// Whatever the flash memory has doesn't matter
+			fixture: "desktop-non-ecc.txt",
+			result: Result{
+				Name:     "ECCMemory",
+				Severity: SeverityWarning,
+				Message:  "Non-ECC RAM detected (error correction: None). SaftOS requires ECC memory for production use.",
+				Facts:    map[string]any{"errorCorrection": "None"},
+			},
+		},
+		{
+			dmi: "Handle 0x0008, DMI type 16, 23 bytes\nPhysical Memory Array\n\tUse: System Memory\n\tError Correction Type: Parity\n" +
+				"\nHandle 0x0009, DMI type 16, 23 bytes\nPhysical Memory Array\n\tUse: System Memory\n\tError Correction Type: Multi-bit ECC\n",
+			result: Result{
+				Name:     "ECCMemory",
+				Severity: SeverityWarning,
+				Message:  "Non-ECC RAM detected (error correction: Parity). SaftOS requires ECC memory for production use.",
+				Facts:    map[string]any{"errorCorrection": "Parity"},
+			},
+		},
+		{
+			dmi:    "Handle 0x1000, DMI type 16, 23 bytes\nPhysical Memory Array\n\tUse: System Memory\n\tError Correction Type: Unknown\n",
+			result: Result{Name: "ECCMemory", Message: "Unable to determine whether the RAM is ECC, because the firmware does not say."},
+			logged: "The firmware does not say whether the RAM is ECC (Unknown)",
+		},
+		{
+			dmi:    "Handle 0x1000, DMI type 16, 23 bytes\nPhysical Memory Array\n\tUse: System Memory\n",
+			result: Result{Name: "ECCMemory", Message: "Unable to determine whether the RAM is ECC, because the firmware does not say."},
+			logged: "The firmware does not say whether the RAM is ECC (not reported)",
+		},
+		{
+			// Older VMs don't describe their memory at all
+			dmi:    "Handle 0x0100, DMI type 1, 27 bytes\nSystem Information\n\tManufacturer: QEMU\n",
+			result: Result{Name: "ECCMemory", Message: "Unable to determine whether the RAM is ECC, because the firmware does not say."},
+			logged: "The firmware does not say whether the RAM is ECC (no memory arrays)",
+		},
+	}
+	for _, test := range tests {
+		var logged bytes.Buffer
+		logrus.SetOutput(&logged)
+		out := test.dmi
+		if test.fixture != "" {
+			out = readDMIFixture(t, test.fixture)
+		}
+		records, _ := parseDMIDecode(out)
+		result, err := ECCMemoryCheck{}.Evaluate(context.Background(), &Env{dmiRecords: records})
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, test.result, result, test.fixture)
+		if test.logged != "" {
+			assert.Contains(t, logged.String(), test.logged)
+		}
+	}
+}
+
+func TestECCMemoryCheckNoDmidecode(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+
+	env := &Env{execCommand: func(string, ...string) *exec.Cmd { return exec.Command("/nonexistent/dmidecode") }, machine: h}
+	result, err := ECCMemoryCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "ECCMemory", Message: "Unable to determine whether the RAM is ECC, because dmidecode is not installed."}, result)
+
+	// Nor is it any use without SMBIOS
+	h.sysFirmwareDMITables = "./testdata/nonexistent/DMI"
+	result, err = ECCMemoryCheck{}.Evaluate(context.Background(), &Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityOK, result.Severity)
+	assert.Equal(t, "Skipped: SMBIOS not available on this platform.", result.Message)
+}
@@ -0,0 +1,64 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// lowEntropyBits is the entropy pool level below which old kernels are
+// liable to block
+const lowEntropyBits = 256
+
+// getrandomNonBlocking is the kernel version from which getrandom() and
+// /dev/random never block once the CRNG is initialised
+var getrandomNonBlocking = kernelVersion{Major: 5, Minor: 6}
+
+// EntropyCheck warns if the kernel is old enough that random number
+// generation can block, and the entropy pool is low with no hardware RNG
+// to top it up.  That shows up as certificate and token generation
+// during bootstrap hanging for minutes, typically on VMs.  On newer
+// kernels, it just records the hardware RNG in the inventory.
+type EntropyCheck struct{}
+
+func (c EntropyCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Entropy"
+
+	rng := env.readTrimmed(filepath.Join(env.host().root, "sys/devices/virtual/misc/hw_random/rng_current"))
+	if rng == "none" {
+		rng = ""
+	} else if rng == "" {
+		if _, err := env.stat(filepath.Join(env.host().devDir, "hwrng")); err == nil {
+			rng = "unknown"
+		}
+	}
+	env.Inventory.HWRNG = rng
+
+	release, _, err := env.host().unameRelease()
+	if err != nil {
+		return
+	}
+	version, err := parseKernelVersion(release)
+	if err != nil {
+		return
+	}
+	if !version.less(getrandomNonBlocking) {
+		return
+	}
+
+	avail, err := strconv.Atoi(env.readTrimmed(filepath.Join(env.host().root, "proc/sys/kernel/random/entropy_avail")))
+	if err != nil {
+		return result, fmt.Errorf("unable to read available entropy: %w", err)
+	}
+	switch {
+	case rng != "":
+		result.Message = fmt.Sprintf("Hardware RNG %s is available to feed the entropy pool.", rng)
+	case avail < lowEntropyBits:
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("Only %d bits of entropy are available, and kernel %s can block waiting for more, "+
+			"which may stall certificate generation for minutes. "+
+			"Please add a hardware RNG (e.g. virtio-rng for VMs), or run an entropy daemon such as haveged.", avail, release)
+	}
+	return
+}
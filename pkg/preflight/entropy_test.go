@@ -0,0 +1,59 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntropyCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture string
+		release string
+		rng     string
+		result  Result
+	}{
+		{
+			fixture: "old-kernel-low-entropy",
+			release: "4.12.14-122.37-default",
+			result: Result{
+				Name:     "Entropy",
+				Severity: SeverityWarning,
+				Message: "Only 23 bits of entropy are available, and kernel 4.12.14-122.37-default can block waiting for more, " +
+					"which may stall certificate generation for minutes. " +
+					"Please add a hardware RNG (e.g. virtio-rng for VMs), or run an entropy daemon such as haveged.",
+			},
+		},
+		{
+			fixture: "old-kernel-with-virtio-rng",
+			release: "4.12.14-122.37-default",
+			rng:     "virtio_rng.0",
+			result:  Result{Name: "Entropy", Message: "Hardware RNG virtio_rng.0 is available to feed the entropy pool."},
+		},
+		{
+			fixture: "new-kernel",
+			release: "5.14.21-150500.55.39-default",
+			rng:     "tpm-rng-0",
+			result:  Result{Name: "Entropy"},
+		},
+		{
+			fixture: "old-kernel-low-entropy",
+			release: "6.4.0-150600.23.25-default",
+			result:  Result{Name: "Entropy"},
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/entropy/" + test.fixture
+		h.devDir = "./testdata/entropy/" + test.fixture + "/dev"
+		h.unameRelease = func() (string, string, error) { return test.release, "x86_64", nil }
+		env := &Env{machine: h}
+		result, err := EntropyCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, test.result, result, test.fixture)
+		assert.Equal(t, test.rng, env.Inventory.HWRNG, test.fixture)
+	}
+}
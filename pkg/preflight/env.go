@@ -0,0 +1,129 @@
+package preflight
+
+import "context"
+
+// Env holds the state shared by the checks in a single preflight run.
+// Checks which learn something about the host that other checks need
+// record it in the Inventory.
+type Env struct {
+	Options   Options
+	Inventory Inventory
+
+	// Output of dmidecode, collected by the first check that needs it
+	dmiRecords []dmiRecord
+	dmiErr     error
+
+	// Outcome of probing the D-Bus system bus, by the first check that
+	// needs it
+	systemBusProbed bool
+	systemBusErr    error
+
+	// The machine the checks look at, or the live one if nil
+	machine *host
+
+	// The context of the check being evaluated, which the external tools
+	// it runs are killed by, and its file reads give up with, when done
+	ctx context.Context
+	// Makes the commands which run external tools
+	execCommand execFunc
+	// Outputs of the external tools run so far
+	tools *toolCache
+
+	// What the check being evaluated has consumed, if evidence is being
+	// captured
+	evidence *evidenceLog
+}
+
+// Inventory describes what's been learned about the host so far.
+type Inventory struct {
+	// InstallDevice is the kernel name (e.g. "sda") of the installation
+	// target, once it's been resolved from the install configuration.
+	InstallDevice string
+	// BootMode is how the live environment was booted.
+	BootMode BootMode
+	// TPM is the host's TPM, or nil if it doesn't have one.
+	TPM *TPM
+	// Firmware is the platform firmware, if DMI data is available.
+	Firmware *Firmware
+	// BMC is the host's BMC, or nil if it doesn't have one.
+	BMC *BMC
+	// Watchdogs are the host's watchdog devices.
+	Watchdogs []Watchdog
+	// PowerSupplies are the host's power supply slots.
+	PowerSupplies []PowerSupply
+	// HottestSensor is the temperature sensor with the highest reading,
+	// or nil if there are no sensors.
+	HottestSensor *TempSensor
+	// GPUs are the host's display and 3D controllers.
+	GPUs []GPU
+	// CgroupMode is how the cgroup hierarchy is set up.
+	CgroupMode CgroupMode
+	// HWRNG is the hardware RNG in use, e.g. "virtio_rng.0", or empty if
+	// there isn't one.
+	HWRNG string
+	// Cmdline is the kernel command line.
+	Cmdline string
+	// IsolatedCPUs is the number of CPUs isolated from the scheduler by
+	// isolcpus or nohz_full.
+	IsolatedCPUs int
+	// SystemBus is whether the D-Bus system bus is available.
+	SystemBus bool
+	// LockdownMode is the kernel lockdown mode, e.g. "integrity", or
+	// empty if the kernel doesn't support lockdown.
+	LockdownMode string
+	// Clocksource is the kernel's current clocksource, e.g. "tsc".
+	Clocksource string
+	// ChassisType is the SMBIOS chassis type, e.g. "Rack Mount Chassis",
+	// if known.
+	ChassisType string
+	// Nameservers are the upstream DNS servers the resolver uses, behind
+	// any local stub.
+	Nameservers []string
+	// SearchDomains are the resolver's search domains.
+	SearchDomains []string
+	// MachineID is the contents of /etc/machine-id, if it's valid.
+	MachineID string
+	// LogicalCPUs is the number of logical CPUs, including isolated ones.
+	LogicalCPUs int
+	// Sockets is the number of populated CPU sockets.
+	Sockets int
+	// PhysicalNICs is the number of network interfaces backed by a
+	// device.
+	PhysicalNICs int
+	// Disks is the number of disks.
+	Disks int
+	// CrashKernelBytes is the amount of memory reserved for the crash
+	// kernel.
+	CrashKernelBytes uint64
+	// BlockDevices are the kernel names of the host's disks and their
+	// partitions.
+	BlockDevices []string
+	// NICs are the host's physical network interfaces.
+	NICs []NIC
+	// PCIDevices are the host's PCI devices.
+	PCIDevices []PCIDevice
+	// DHCPLeases are the IPv4 leases the live environment got, which show
+	// the segments with a DHCP server.
+	DHCPLeases []DHCPLease
+	// MemoryBytes is the amount of RAM usable by workloads, i.e. excluding
+	// the crash kernel reservation.
+	MemoryBytes uint64
+}
+
+// context returns the context of the check being evaluated, or the
+// background context if the Env isn't a Runner's.
+func (e *Env) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// targets returns devs plus the installation device from the inventory,
+// if it's known and not already included.
+func (e *Env) targets(devs []string) []string {
+	if e.Inventory.InstallDevice != "" && !containsDev(devs, e.Inventory.InstallDevice) {
+		return append(devs[:len(devs):len(devs)], e.Inventory.InstallDevice)
+	}
+	return devs
+}
@@ -0,0 +1,153 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The kinds of error a check can fail with.  The errors checks return
+// wrap one of them where the cause is known, so that callers can tell
+// them apart with errors.Is rather than by matching messages.
+var (
+	// ErrToolMissing means an external tool the check runs isn't
+	// installed.
+	ErrToolMissing = errors.New("tool missing")
+	// ErrParseFailure means what a tool printed, or a file held, couldn't
+	// be made sense of.
+	ErrParseFailure = errors.New("parse failure")
+	// ErrPermission means the check wasn't allowed to read or do
+	// something, usually because the installer isn't running as root.
+	// It's fs.ErrPermission, so the errors of files which can't be read
+	// are already of this kind.
+	ErrPermission = fs.ErrPermission
+	// ErrUnsupportedPlatform means the host doesn't have something the
+	// check relies on, such as SMBIOS, so the check doesn't apply to it.
+	// The Runner reports such checks as skipped, rather than failed.
+	ErrUnsupportedPlatform = errors.New("unsupported platform")
+	// ErrTimeout means the check took longer than Options.CheckTimeout,
+	// so the Runner gave up on it.  It's context.DeadlineExceeded, so the
+	// errors of tools and requests which ran out of time are of this kind.
+	ErrTimeout = context.DeadlineExceeded
+)
+
+// An ErrorKind names the kind of error a check failed with in a Result.
+type ErrorKind string
+
+const (
+	ErrorKindToolMissing         ErrorKind = "tool-missing"
+	ErrorKindParseFailure        ErrorKind = "parse-failure"
+	ErrorKindPermission          ErrorKind = "permission"
+	ErrorKindUnsupportedPlatform ErrorKind = "unsupported-platform"
+	ErrorKindTimeout             ErrorKind = "timeout"
+)
+
+// errorKinds are the ErrorKinds of the kinds of error, in the order an
+// error is classified in, should it somehow wrap more than one.
+var errorKinds = []struct {
+	err  error
+	kind ErrorKind
+}{
+	{ErrUnsupportedPlatform, ErrorKindUnsupportedPlatform},
+	{ErrToolMissing, ErrorKindToolMissing},
+	{ErrPermission, ErrorKindPermission},
+	{ErrParseFailure, ErrorKindParseFailure},
+	{ErrTimeout, ErrorKindTimeout},
+}
+
+// A CheckError is an error from a check, of the given Kind, which is one
+// of the kinds of error above, or nil if it's not known, with what the
+// check was doing when it failed as Op, e.g. the tool it was running.  If
+// Op is empty, Err says it all.
+type CheckError struct {
+	Kind error
+	Op   string
+	Err  error
+}
+
+func (e *CheckError) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *CheckError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// toolError returns the error of running the external tool name, so
+// that it says which tool couldn't be run, and is ErrToolMissing if it
+// isn't installed.  If the tool ran, but exited unsuccessfully, the error
+// is returned untouched.  A nil error stays nil.
+func toolError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var kind error
+	switch {
+	case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist):
+		kind = ErrToolMissing
+	case errors.Is(err, fs.ErrPermission):
+		kind = ErrPermission
+	default:
+		// If it ran and failed, what its exit status means is for the
+		// check to make sense of
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return err
+		}
+		kind = errorKindOf(err)
+	}
+	return &CheckError{Kind: kind, Op: filepath.Base(name), Err: err}
+}
+
+// parseErrorf is like fmt.Errorf, except that the error is
+// ErrParseFailure.
+func parseErrorf(format string, a ...any) error {
+	return &CheckError{Kind: ErrParseFailure, Err: fmt.Errorf(format, a...)}
+}
+
+// errorKindOf returns the kind of error err is, or nil if it's not known.
+// Errors which don't wrap one of the kinds are classified by their cause,
+// so that those of checks which return what the standard library gave
+// them are classified too.
+func errorKindOf(err error) error {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.err
+		}
+	}
+	var numErr *strconv.NumError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var yamlErr *yaml.TypeError
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return ErrToolMissing
+	case errors.As(err, &numErr), errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.As(err, &yamlErr):
+		return ErrParseFailure
+	}
+	return nil
+}
+
+// classifyError returns the ErrorKind of err, or "" if it's not known.
+func classifyError(err error) ErrorKind {
+	kind := errorKindOf(err)
+	for _, k := range errorKinds {
+		if kind == k.err {
+			return k.kind
+		}
+	}
+	return ""
+}
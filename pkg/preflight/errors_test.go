@@ -0,0 +1,176 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckError(t *testing.T) {
+	cause := errors.New("no such thing")
+	err := error(&CheckError{Kind: ErrToolMissing, Op: "dmidecode", Err: cause})
+	assert.EqualError(t, err, "dmidecode: no such thing")
+	assert.ErrorIs(t, err, ErrToolMissing)
+	assert.ErrorIs(t, err, cause)
+	assert.NotErrorIs(t, err, ErrParseFailure)
+
+	var checkErr *CheckError
+	assert.True(t, errors.As(fmt.Errorf("failed: %w", err), &checkErr))
+	assert.Equal(t, "dmidecode", checkErr.Op)
+
+	err = &CheckError{Err: cause}
+	assert.EqualError(t, err, "no such thing")
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, ErrorKind(""), classifyError(err))
+}
+
+func TestToolError(t *testing.T) {
+	assert.Nil(t, toolError("/usr/bin/true", nil))
+
+	err := toolError("/nonexistent/dmidecode", exec.Command("/nonexistent/dmidecode").Run())
+	assert.ErrorIs(t, err, ErrToolMissing)
+	assert.EqualError(t, err, "dmidecode: fork/exec /nonexistent/dmidecode: no such file or directory")
+
+	err = toolError("nonexistent-tool", exec.Command("nonexistent-tool").Run())
+	assert.ErrorIs(t, err, ErrToolMissing)
+
+	// Even root can't run a file which isn't executable
+	err = toolError("present", exec.Command("./testdata/cpus/4/sys/devices/system/cpu/present").Run())
+	assert.ErrorIs(t, err, ErrPermission)
+	assert.Equal(t, ErrorKindPermission, classifyError(err))
+
+	// The check makes sense of the exit status
+	exitErr := fakeExecCommand("metal").Run()
+	assert.Equal(t, exitErr, toolError("/usr/bin/systemd-detect-virt", exitErr))
+}
+
+func TestClassifyError(t *testing.T) {
+	_, numErr := strconv.Atoi("lots")
+	syntaxErr := json.Unmarshal([]byte("{"), &struct{}{})
+	tests := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{errors.New("oops"), ""},
+		{&fs.PathError{Op: "open", Path: "/dev/kvm", Err: syscall.ENOENT}, ""},
+		{&fs.PathError{Op: "open", Path: "/proc/meminfo", Err: syscall.EACCES}, ErrorKindPermission},
+		{fmt.Errorf("reading: %w", &fs.PathError{Op: "open", Path: "/x", Err: syscall.EPERM}), ErrorKindPermission},
+		{fmt.Errorf("unable to parse: %w", numErr), ErrorKindParseFailure},
+		{fmt.Errorf("invalid setting: %w", syntaxErr), ErrorKindParseFailure},
+		{parseErrorf("unable to parse %q", "x"), ErrorKindParseFailure},
+		{fmt.Errorf("failed to run dmidecode: %w", &CheckError{Kind: ErrToolMissing, Err: errors.New("x")}), ErrorKindToolMissing},
+		{&exec.Error{Name: "nproc", Err: exec.ErrNotFound}, ErrorKindToolMissing},
+		{errNoSMBIOS, ErrorKindUnsupportedPlatform},
+		{errICMPNotPermitted, ErrorKindPermission},
+		{fmt.Errorf("querying the NTP servers: %w", context.DeadlineExceeded), ErrorKindTimeout},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.kind, classifyError(tt.err), tt.err.Error())
+	}
+}
+
+// Each way the original checks can fail is of a kind callers can tell
+// apart.
+func TestCheckErrorKinds(t *testing.T) {
+	h := testHost()
+	h.onlineCPUs = func() int { return 16 }
+	notExecutable := "./testdata/cpus/4/sys/devices/system/cpu/present"
+
+	t.Run("CPU", func(t *testing.T) {
+		h.root = "./testdata/cpus/garbage"
+		_, err := presentCPUs(&Env{machine: h})
+		assert.ErrorIs(t, err, ErrParseFailure)
+		assert.EqualError(t, err, `testdata/cpus/garbage/sys/devices/system/cpu/present lists no CPUs: "none"`)
+
+		h.root = "./testdata/nonexistent"
+		_, err = presentCPUs(&Env{machine: h})
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+
+		h.procCPUInfo = "./testdata/cpus/garbage/cpuinfo"
+		_, err = cpuinfoCPUs(&Env{machine: h})
+		assert.ErrorIs(t, err, ErrParseFailure)
+		assert.EqualError(t, err, "./testdata/cpus/garbage/cpuinfo lists no processors")
+
+		// Either way, the CPUs online are counted instead
+		result, err := CPUCheck{}.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err)
+		assert.Equal(t, SeverityOK, result.Severity)
+	})
+
+	t.Run("Memory", func(t *testing.T) {
+		h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+		env := &Env{execCommand: func(string, ...string) *exec.Cmd { return exec.Command("/nonexistent/dmidecode") }, machine: h}
+		_, err := env.dmi(19)
+		assert.ErrorIs(t, err, ErrToolMissing)
+		assert.EqualError(t, err, "failed to run dmidecode: dmidecode: fork/exec /nonexistent/dmidecode: no such file or directory")
+
+		h.sysFirmwareDMITables = "./testdata/nonexistent"
+		_, err = (&Env{machine: h}).dmi(19)
+		assert.ErrorIs(t, err, ErrUnsupportedPlatform)
+
+		// Without dmidecode, /proc/meminfo is the fallback
+		_, err = MemoryCheck{MemInfoPath: "./testdata/nonexistent"}.Evaluate(context.Background(), &Env{machine: h})
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		assert.Equal(t, ErrorKind(""), classifyError(err))
+
+		_, err = MemoryCheck{MemInfoPath: notExecutable}.Evaluate(context.Background(), &Env{machine: h})
+		assert.ErrorIs(t, err, ErrParseFailure)
+		assert.EqualError(t, err, "unable to extract MemTotal from "+notExecutable)
+	})
+
+	t.Run("Virt", func(t *testing.T) {
+		check := VirtCheck{ExecCommand: func(string, ...string) *exec.Cmd { return exec.Command("/nonexistent/systemd-detect-virt") }}
+		_, err := check.Run()
+		assert.ErrorIs(t, err, ErrToolMissing)
+
+		check.ExecCommand = func(string, ...string) *exec.Cmd { return exec.Command(notExecutable) }
+		_, err = check.Run()
+		assert.ErrorIs(t, err, ErrPermission)
+		assert.EqualError(t, err, "systemd-detect-virt: fork/exec "+notExecutable+": permission denied")
+	})
+
+	t.Run("KVMHost", func(t *testing.T) {
+		// A path through a file can't be stat'd
+		_, err := KVMHostCheck{DevicePath: filepath.Join(notExecutable, "kvm")}.Run()
+		assert.ErrorIs(t, err, syscall.ENOTDIR)
+		assert.Equal(t, ErrorKind(""), classifyError(err))
+
+		if os.Geteuid() == 0 {
+			t.Skip("root can stat anything")
+		}
+		dir := t.TempDir()
+		assert.Nil(t, os.Chmod(dir, 0))
+		defer func() { _ = os.Chmod(dir, 0o700) }()
+		_, err = KVMHostCheck{DevicePath: filepath.Join(dir, "kvm")}.Run()
+		assert.ErrorIs(t, err, ErrPermission)
+	})
+
+	t.Run("NetworkSpeed", func(t *testing.T) {
+		h.root = t.TempDir()
+		writeFile(t, h.root, "sys/class/net/eth0/speed", "-100\n")
+		_, err := NetworkSpeedCheck{"eth0"}.Evaluate(context.Background(), &Env{machine: h})
+		assert.ErrorIs(t, err, ErrParseFailure)
+		assert.EqualError(t, err, "unable to determine NIC speed from "+h.root+"/sys/class/net/eth0/speed: line 1, the speed is negative: \"-100\"")
+
+		// Not knowing the speed isn't an error
+		h.root = "./testdata/network-speed/unknown"
+		_, err = NetworkSpeedCheck{"eth0"}.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err)
+
+		_, err = NetworkSpeedCheck{"eth1"}.Evaluate(context.Background(), &Env{machine: h})
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+
+		_, err = NetworkSpeedCheck{"../../kernel"}.Evaluate(context.Background(), &Env{machine: h})
+		assert.ErrorIs(t, err, errInvalidInterfaceName)
+	})
+}
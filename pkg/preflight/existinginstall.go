@@ -0,0 +1,113 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// An lsblkDevice is a block device as lsblk -J describes it, with the
+// partitions and devices on it, e.g. LVs on a PV, as its Children.
+type lsblkDevice struct {
+	Name string `json:"name"`
+	// Path is the device's node, e.g. /dev/sda1 or /dev/mapper/vg0-root
+	Path       string        `json:"path"`
+	FSType     string        `json:"fstype"`
+	Label      string        `json:"label"`
+	PartLabel  string        `json:"partlabel"`
+	MountPoint string        `json:"mountpoint"`
+	Children   []lsblkDevice `json:"children"`
+}
+
+// ExistingInstallCheck guards against wiping a disk which is still in
+// use, as users have wiped nodes still serving workloads by picking the
+// wrong one.  It lists what's on the installation disk Dev, or the one
+// from the inventory if Dev is empty: the partitions of a SaftOS
+// installation, LVM physical volumes, mdraid members, and mounted
+// filesystems, all of which say something lives there, so the user can
+// decide whether it can go.  A mounted filesystem is fatal, as the
+// installer would fail to wipe it anyway, and the rest are warnings, or
+// only information if destructive operations have been allowed.  An empty
+// disk passes silently.  PreviousInstallCheck goes on to look inside a
+// previous SaftOS installation.
+type ExistingInstallCheck struct {
+	Dev string
+}
+
+func (c ExistingInstallCheck) readsFrom() []string {
+	return []string{"ConfigDevice"}
+}
+
+func (c ExistingInstallCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ExistingInstall"
+	dev := strings.TrimPrefix(c.Dev, "/dev/")
+	if dev == "" {
+		dev = env.Inventory.InstallDevice
+	}
+	if dev == "" {
+		// ConfigDeviceCheck reports installation disks it can't resolve
+		return
+	}
+	if _, err = env.blockDevPath(dev, ""); err != nil {
+		return
+	}
+	out, err := env.output("/usr/bin/lsblk", "-J", "-o", "NAME,PATH,FSTYPE,LABEL,PARTLABEL,MOUNTPOINT", "/dev/"+dev)
+	if err != nil {
+		return
+	}
+	var lsblk struct {
+		BlockDevices []lsblkDevice `json:"blockdevices"`
+	}
+	if err = json.Unmarshal(out, &lsblk); err != nil {
+		err = parseErrorf("unable to parse what lsblk says is on %s: %v", dev, err)
+		return
+	}
+
+	var found, mounted []string
+	var walk func(devs []lsblkDevice)
+	walk = func(devs []lsblkDevice) {
+		for _, d := range devs {
+			name := d.Path
+			switch {
+			case strings.HasPrefix(d.Label, "COS_") || slices.Contains(cosLabels, d.Label):
+				found = append(found, fmt.Sprintf("%s on %s", d.Label, name))
+			case d.FSType == "LVM2_member":
+				found = append(found, "an LVM physical volume on "+name)
+			case d.FSType == "linux_raid_member":
+				found = append(found, "an mdraid member on "+name)
+			}
+			switch {
+			case d.MountPoint == "[SWAP]":
+				found = append(found, "an active swap area on "+name)
+				mounted = append(mounted, name)
+			case d.MountPoint != "":
+				fs := strings.TrimSpace(d.FSType + " filesystem")
+				found = append(found, fmt.Sprintf("a mounted %s on %s (at %s)", fs, name, d.MountPoint))
+				mounted = append(mounted, name)
+			}
+			walk(d.Children)
+		}
+	}
+	walk(lsblk.BlockDevices)
+	if len(found) == 0 {
+		return
+	}
+
+	result.Facts = map[string]any{"found": found}
+	result.Message = fmt.Sprintf("Found %s.", joinWithAnd(found))
+	switch {
+	case len(mounted) > 0:
+		result.Severity = SeverityFatal
+		result.Message += fmt.Sprintf(" %s is in use, so the installer cannot wipe it. "+
+			"Please make sure nothing on it is still needed, and unmount %s, or choose another disk.", "/dev/"+dev, joinWithAnd(mounted))
+	case env.Options.DestructiveAllowed:
+		result.Severity = SeverityInfo
+		result.Message += fmt.Sprintf(" %s will be wiped.", "/dev/"+dev)
+	default:
+		result.Severity = SeverityWarning
+		result.Message += fmt.Sprintf(" Installing will wipe %s, so please make sure nothing on it is still needed.", "/dev/"+dev)
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExistingInstallCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		lsblk       string
+		dev         string
+		destructive bool
+		severity    Severity
+		message     string
+	}{
+		{
+			name:  "clean",
+			lsblk: "lsblk-clean",
+			dev:   "sdb",
+		},
+		{
+			name:     "previously installed",
+			lsblk:    "lsblk-installed",
+			dev:      "sda",
+			severity: SeverityWarning,
+			message: "Found COS_GRUB on /dev/sda1, COS_OEM on /dev/sda2, COS_STATE on /dev/sda3, COS_RECOVERY on /dev/sda4, " +
+				"COS_PERSISTENT on /dev/sda5 and HARV_LH_DEFAULT on /dev/sda6. " +
+				"Installing will wipe /dev/sda, so please make sure nothing on it is still needed.",
+		},
+		{
+			name:        "previously installed, wiping allowed",
+			lsblk:       "lsblk-installed",
+			dev:         "/dev/sda",
+			destructive: true,
+			severity:    SeverityInfo,
+			message: "Found COS_GRUB on /dev/sda1, COS_OEM on /dev/sda2, COS_STATE on /dev/sda3, COS_RECOVERY on /dev/sda4, " +
+				"COS_PERSISTENT on /dev/sda5 and HARV_LH_DEFAULT on /dev/sda6. /dev/sda will be wiped.",
+		},
+		{
+			// Wiping it isn't an option, however it's allowed
+			name:        "in use",
+			lsblk:       "lsblk-in-use",
+			dev:         "sda",
+			destructive: true,
+			severity:    SeverityFatal,
+			message: "Found a mounted vfat filesystem on /dev/sda1 (at /boot/efi), an active swap area on /dev/sda2, " +
+				"an LVM physical volume on /dev/sda3, a mounted xfs filesystem on /dev/mapper/vg0-root (at /) and an mdraid member on /dev/sda4. " +
+				"/dev/sda is in use, so the installer cannot wipe it. Please make sure nothing on it is still needed, " +
+				"and unmount /dev/sda1, /dev/sda2 and /dev/mapper/vg0-root, or choose another disk.",
+		},
+		{
+			// The LVs on a disk which is a PV are in /dev/mapper too
+			name:     "whole disk PV",
+			lsblk:    "lsblk-lvm-disk",
+			dev:      "sdb",
+			severity: SeverityFatal,
+			message: "Found an LVM physical volume on /dev/sdb and a mounted ext4 filesystem on /dev/mapper/vg1-data (at /srv). " +
+				"/dev/sdb is in use, so the installer cannot wipe it. Please make sure nothing on it is still needed, " +
+				"and unmount /dev/mapper/vg1-data, or choose another disk.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []string
+			env := &Env{Options: Options{DestructiveAllowed: tt.destructive}, execCommand: func(name string, args ...string) *exec.Cmd {
+				ran = append(ran, strings.Join(append([]string{name}, args...), " "))
+				return fakeExecCommand(tt.lsblk)
+			}}
+			result, err := ExistingInstallCheck{Dev: tt.dev}.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, "ExistingInstall", result.Name)
+			assert.Equal(t, tt.severity, result.Severity)
+			assert.Equal(t, tt.message, result.Message)
+			assert.Equal(t, []string{"/usr/bin/lsblk -J -o NAME,PATH,FSTYPE,LABEL,PARTLABEL,MOUNTPOINT /dev/" + strings.TrimPrefix(tt.dev, "/dev/")}, ran)
+		})
+	}
+}
+
+func TestExistingInstallCheckDevice(t *testing.T) {
+	// The installation device from the inventory, if none is given
+	env := &Env{Inventory: Inventory{InstallDevice: "sda"}, execCommand: fakeCommand("lsblk-in-use")}
+	result, err := ExistingInstallCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityFatal, result.Severity)
+
+	// Or none, if ConfigDeviceCheck couldn't resolve it
+	result, err = ExistingInstallCheck{}.Evaluate(context.Background(), &Env{})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "ExistingInstall"}, result)
+
+	_, err = ExistingInstallCheck{Dev: "../sda"}.Evaluate(context.Background(), &Env{})
+	assert.ErrorIs(t, err, errInvalidBlockDeviceName)
+
+	_, err = ExistingInstallCheck{Dev: "sda"}.Evaluate(context.Background(), &Env{execCommand: fakeCommand("lsblk-garbage")})
+	assert.ErrorIs(t, err, ErrParseFailure)
+
+	_, err = ExistingInstallCheck{Dev: "sda"}.Evaluate(context.Background(), &Env{execCommand: func(string, ...string) *exec.Cmd {
+		return exec.Command("/nonexistent/lsblk")
+	}})
+	assert.ErrorIs(t, err, ErrToolMissing)
+}
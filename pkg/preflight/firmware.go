@@ -0,0 +1,406 @@
+package preflight
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rules/firmware.yaml
+var defaultFirmwareMinimums []byte
+
+// defaultFirmwareOverridePath is where sites can put the firmware
+// minimums they know of
+const defaultFirmwareOverridePath = "/etc/saftos/preflight/firmware.yaml"
+
+// BootMode is the firmware interface the live environment was booted
+// through, which the installed system will also use.
+type BootMode string
+
+const (
+	BootModeUnknown BootMode = ""
+	BootModeUEFI    BootMode = "uefi"
+	BootModeLegacy  BootMode = "legacy"
+)
+
+// BootModeCheck determines whether the live environment was booted via
+// UEFI or legacy BIOS, and records it in the inventory.  The installer
+// partitions the target to match, so a machine booted in legacy mode
+// will end up with a legacy install, which is only supported for testing,
+// and otherwise only fails once the bootloader is installed.  That's
+// worth a warning, since it's much easier to fix now than later,
+// particularly if the firmware supports UEFI (and the legacy boot is
+// down to CSM being enabled, or the wrong boot menu entry being picked).
+type BootModeCheck struct{}
+
+func (c BootModeCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "BootMode"
+
+	_, err = env.stat(env.host().sysFirmwareEFI)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+		env.Inventory.BootMode = BootModeLegacy
+		result.Severity = SeverityWarning
+		result.Message = "Booted in legacy BIOS mode, which SaftOS only supports for testing."
+		if firmwareSupportsUEFI(env) {
+			result.Message += " This machine's firmware supports UEFI, but the system will be installed for legacy boot. " +
+				"Please consider disabling CSM or legacy boot in the firmware settings, and booting the installer via UEFI."
+		} else {
+			result.Message += " Please boot the installer via UEFI for production use."
+		}
+		return
+	} else if err != nil {
+		return
+	}
+
+	env.Inventory.BootMode = BootModeUEFI
+	result.Message = "Booted in UEFI mode."
+	// fw_platform_size only exists on x86, where 32-bit UEFI firmware
+	// can boot a 64-bit kernel
+	if out, err := env.readFile(filepath.Join(env.host().sysFirmwareEFI, "fw_platform_size")); err == nil {
+		if bits, err := strconv.Atoi(strings.TrimSpace(string(out))); err == nil {
+			result.Message = fmt.Sprintf("Booted in UEFI mode (%d-bit firmware).", bits)
+		}
+	}
+	return
+}
+
+// firmwareSupportsUEFI uses the BIOS characteristics reported by dmidecode
+// to guess whether the firmware can boot via UEFI.  If dmidecode fails, or
+// doesn't give a clear answer, we assume not.
+func firmwareSupportsUEFI(env *Env) bool {
+	records, err := env.dmi(0)
+	if err != nil {
+		return false
+	}
+	for _, record := range records {
+		for _, characteristic := range record.Lists["Characteristics"] {
+			if characteristic == "UEFI is supported." {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// efiGlobalVariable is the vendor GUID of the standard UEFI variables
+const efiGlobalVariable = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+// readEFIVar returns the data of the given global EFI variable, without
+// the attributes efivarfs prefixes it with.
+func readEFIVar(env *Env, name string) ([]byte, error) {
+	data, err := env.readFile(filepath.Join(env.host().sysFirmwareEFI, "efivars", name+"-"+efiGlobalVariable))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("EFI variable %s is truncated", name)
+	}
+	return data[4:], nil
+}
+
+// SecureBootPolicy says what Secure Boot state the site requires.
+type SecureBootPolicy string
+
+const (
+	// SecureBootAny means any state is acceptable
+	SecureBootAny SecureBootPolicy = ""
+	// SecureBootRequired means Secure Boot must be enabled
+	SecureBootRequired SecureBootPolicy = "required"
+	// SecureBootMustBeOff means Secure Boot must be disabled
+	SecureBootMustBeOff SecureBootPolicy = "must-be-off"
+)
+
+// ParseSecureBootPolicy validates a policy given by the user.
+func ParseSecureBootPolicy(s string) (SecureBootPolicy, error) {
+	switch policy := SecureBootPolicy(s); policy {
+	case SecureBootAny, SecureBootRequired, SecureBootMustBeOff:
+		return policy, nil
+	}
+	return SecureBootAny, fmt.Errorf("unknown Secure Boot policy %q, expected %q or %q", s, SecureBootRequired, SecureBootMustBeOff)
+}
+
+// SecureBootCheck reports whether Secure Boot is enabled, and compares
+// that with the policy in the Options, if any.  Without a policy, the
+// result is purely informational, unless Secure Boot is enabled but the
+// kernel modules SaftOS needs aren't signed, so they won't load.
+type SecureBootCheck struct{}
+
+func (c SecureBootCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "SecureBoot"
+	policy := env.Options.SecureBootPolicy
+
+	if _, err = env.stat(env.host().sysFirmwareEFI); errors.Is(err, fs.ErrNotExist) {
+		err = nil
+		result.Message = "Secure Boot is not available, because the system was not booted via UEFI."
+		if policy == SecureBootRequired {
+			result.Severity = SeverityFatal
+			result.Message += " Secure Boot is required, please boot the installer via UEFI with Secure Boot enabled."
+		}
+		return
+	} else if err != nil {
+		return
+	}
+
+	// The variables can be missing if efivarfs isn't mounted, and some
+	// firmware returns garbage for them (or the kernel refuses to read
+	// them), so anything other than a clean read means "don't know".
+	secureBoot, sbErr := readEFIVar(env, "SecureBoot")
+	setupMode, smErr := readEFIVar(env, "SetupMode")
+	switch {
+	case sbErr != nil || len(secureBoot) < 1:
+		result.Message = "Unable to determine whether Secure Boot is enabled, because the SecureBoot EFI variable could not be read."
+		if policy != SecureBootAny {
+			result.Severity = SeverityWarning
+			result.Message += " Please verify the Secure Boot setting in the firmware manually."
+		}
+	case secureBoot[0] == 1:
+		result.Message = "Secure Boot is enabled."
+		if policy == SecureBootMustBeOff {
+			result.Severity = SeverityFatal
+			result.Message += " Secure Boot must be disabled on this site, please disable it in the firmware settings."
+		} else if unsigned := unsignedModules(env); len(unsigned) > 0 {
+			result.Severity = SeverityWarning
+			result.Message += fmt.Sprintf(" These kernel modules SaftOS needs are not signed, so they cannot be loaded while it is: %s. "+
+				"Please disable Secure Boot, or boot a kernel whose modules are signed.", strings.Join(unsigned, ", "))
+		}
+	case smErr == nil && len(setupMode) > 0 && setupMode[0] == 1:
+		result.Message = "Secure Boot is disabled, because the firmware is in setup mode (no platform key is enrolled)."
+		if policy == SecureBootRequired {
+			result.Severity = SeverityWarning
+			result.Message += " Secure Boot is required, please enroll the platform keys and enable Secure Boot before putting this host into production."
+		}
+	default:
+		result.Message = "Secure Boot is disabled."
+		if policy == SecureBootRequired {
+			result.Severity = SeverityFatal
+			result.Message += " Secure Boot is required, please enable it in the firmware settings."
+		}
+	}
+	return
+}
+
+// unsignedModules returns those of defaultKernelModules which the CPU
+// needs, which aren't built in, and which modinfo says have no signer.
+// Those modinfo can't find are ModuleSetCheck's business, as are any
+// errors finding which are built in.
+func unsignedModules(env *Env) []string {
+	release, _, err := env.host().unameRelease()
+	if err != nil {
+		return nil
+	}
+	builtin, err := readBuiltinModules(env, filepath.Join(env.host().root, "lib/modules", release, "modules.builtin"))
+	if err != nil {
+		return nil
+	}
+	var unsigned []string
+	for _, module := range cpuModules(env, defaultKernelModules) {
+		name := normalizeModuleName(module.Name)
+		if builtin[name] {
+			continue
+		}
+		out, err := env.output("/usr/sbin/modinfo", "-F", "signer", name)
+		if err == nil && strings.TrimSpace(string(out)) == "" {
+			unsigned = append(unsigned, name)
+		}
+	}
+	return unsigned
+}
+
+// Firmware describes the platform firmware, as reported by DMI.
+type Firmware struct {
+	Vendor             string
+	Version            string
+	ReleaseDate        string
+	SystemManufacturer string
+	SystemProductName  string
+}
+
+// A FirmwareMinimum is the minimum firmware version we recommend for
+// systems whose manufacturer and product name match the given patterns
+// (in path.Match syntax, where empty matches anything).
+type FirmwareMinimum struct {
+	Manufacturer string `yaml:"manufacturer"`
+	Product      string `yaml:"product"`
+	MinVersion   string `yaml:"minVersion"`
+	// Reason briefly explains what goes wrong with older versions.
+	Reason string `yaml:"reason"`
+}
+
+// DefaultFirmwareMinimums returns the known firmware problems, which we
+// recommend updating past, from the override file, if there is one,
+// otherwise the ones shipped in rules/firmware.yaml.  None are shipped,
+// as each needs a vendor advisory to back it, so sites add the ones they
+// know of.
+func DefaultFirmwareMinimums() ([]FirmwareMinimum, error) {
+	return loadFirmwareMinimums(defaultFirmwareOverridePath)
+}
+
+// loadFirmwareMinimums is DefaultFirmwareMinimums, with the override file
+// at path.
+func loadFirmwareMinimums(path string) ([]FirmwareMinimum, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		data = defaultFirmwareMinimums
+	} else if err != nil {
+		return nil, err
+	}
+	var minimums []FirmwareMinimum
+	if err := yaml.Unmarshal(data, &minimums); err != nil {
+		return nil, fmt.Errorf("unable to parse firmware minimums: %w", err)
+	}
+	return minimums, nil
+}
+
+// FirmwareVersionCheck records the firmware vendor, version and release
+// date in the inventory, and warns if the firmware is older than the
+// minimum recommended for the platform.  Platforms without an entry in
+// Minimums (DefaultFirmwareMinimums if nil) always pass.
+type FirmwareVersionCheck struct {
+	Minimums []FirmwareMinimum
+}
+
+func (c FirmwareVersionCheck) probes(env *Env) []toolCall {
+	return dmiProbes(env)
+}
+
+func (c FirmwareVersionCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "FirmwareVersion"
+
+	bios, err := env.dmi(0)
+	if err != nil {
+		err = skipNoSMBIOS(&result, err)
+		return
+	}
+	system, err := env.dmi(1)
+	if err != nil {
+		return
+	}
+	fw := &Firmware{}
+	if len(bios) > 0 {
+		fw.Vendor = bios[0].Fields["Vendor"]
+		fw.Version = bios[0].Fields["Version"]
+		fw.ReleaseDate = bios[0].Fields["Release Date"]
+	}
+	if len(system) > 0 {
+		fw.SystemManufacturer = system[0].Fields["Manufacturer"]
+		fw.SystemProductName = system[0].Fields["Product Name"]
+	}
+	env.Inventory.Firmware = fw
+
+	minimums := c.Minimums
+	if minimums == nil {
+		if minimums, err = loadFirmwareMinimums(env.host().firmwareOverridePath); err != nil {
+			return
+		}
+	}
+	for _, minimum := range minimums {
+		if !globMatch(minimum.Manufacturer, fw.SystemManufacturer) || !globMatch(minimum.Product, fw.SystemProductName) {
+			continue
+		}
+		if cmp, ok := compareFirmwareVersions(fw.Version, minimum.MinVersion); ok && cmp < 0 {
+			result.Severity = SeverityWarning
+			result.Message = fmt.Sprintf("Firmware version %s on %s %s is older than the recommended minimum %s (%s). Please update the firmware.",
+				fw.Version, fw.SystemManufacturer, fw.SystemProductName, minimum.MinVersion, minimum.Reason)
+			return
+		}
+	}
+	return
+}
+
+// globMatch is path.Match, except that an empty pattern matches anything
+// and a malformed one matches nothing.
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, s)
+	return matched
+}
+
+// compareFirmwareVersions compares two firmware version strings, returning
+// -1, 0 or 1 as a is older than, the same as, or newer than b.  Vendors
+// format versions differently (e.g. "1.5.6", "U32 v2.80", "3.4a" or
+// "IVE170K-2.30"), so both are split into runs of digits and letters,
+// ignoring any punctuation, and compared piece by piece, numerically
+// where both are numbers.  If the letters differ (e.g. "U32 v2.80" and
+// "A43 v1.20" from different firmware families), the versions aren't
+// comparable and ok is false.  Missing numbers count as zero, so "1.0"
+// is the same as "1.0.0".
+func compareFirmwareVersions(a, b string) (cmp int, ok bool) {
+	aParts := splitFirmwareVersion(a)
+	bParts := splitFirmwareVersion(b)
+	if len(aParts) == 0 || len(bParts) == 0 {
+		return 0, false
+	}
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.ParseUint(aParts[i], 10, 64)
+		bNum, bErr := strconv.ParseUint(bParts[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1, true
+				}
+				return 1, true
+			}
+		case aErr != nil && bErr != nil:
+			if !strings.EqualFold(aParts[i], bParts[i]) {
+				// Trailing letters are revisions, e.g. "3.4a" < "3.4b"
+				if i == len(aParts)-1 && i == len(bParts)-1 {
+					return strings.Compare(strings.ToLower(aParts[i]), strings.ToLower(bParts[i])), true
+				}
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+	}
+	// Whichever has more pieces is newer, e.g. "3.4a" > "3.4", unless
+	// they're all zeros
+	longer, cmp := aParts, 1
+	if len(bParts) > len(aParts) {
+		longer, cmp = bParts, -1
+	}
+	for _, part := range longer[min(len(aParts), len(bParts)):] {
+		if n, err := strconv.ParseUint(part, 10, 64); err != nil || n != 0 {
+			return cmp, true
+		}
+	}
+	return 0, true
+}
+
+func splitFirmwareVersion(version string) (parts []string) {
+	var current []rune
+	var currentIsDigit bool
+	for _, r := range version {
+		isDigit := unicode.IsDigit(r)
+		if !isDigit && !unicode.IsLetter(r) {
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+				current = nil
+			}
+			continue
+		}
+		if len(current) > 0 && isDigit != currentIsDigit {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		current = append(current, r)
+		currentIsDigit = isDigit
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+	return
+}
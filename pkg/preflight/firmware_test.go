@@ -0,0 +1,339 @@
+package preflight
+
+import (
+	"context"
+	"io/fs"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBootModeCheck(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+
+	const legacy = "Booted in legacy BIOS mode, which SaftOS only supports for testing."
+	tests := []struct {
+		fixture   string
+		dmidecode string
+		mode      BootMode
+		result    Result
+	}{
+		{
+			fixture:   "efi-present-64",
+			dmidecode: "dmidecode-uefi",
+			mode:      BootModeUEFI,
+			result:    Result{Name: "BootMode", Severity: SeverityOK, Message: "Booted in UEFI mode (64-bit firmware)."},
+		},
+		{
+			fixture:   "efi-present-32",
+			dmidecode: "dmidecode-uefi",
+			mode:      BootModeUEFI,
+			result:    Result{Name: "BootMode", Severity: SeverityOK, Message: "Booted in UEFI mode (32-bit firmware)."},
+		},
+		{
+			fixture:   "absent",
+			dmidecode: "dmidecode-bios",
+			mode:      BootModeLegacy,
+			result:    Result{Name: "BootMode", Severity: SeverityWarning, Message: legacy + " Please boot the installer via UEFI for production use."},
+		},
+		{
+			fixture:   "absent",
+			dmidecode: "dmidecode-fail",
+			mode:      BootModeLegacy,
+			result:    Result{Name: "BootMode", Severity: SeverityWarning, Message: legacy + " Please boot the installer via UEFI for production use."},
+		},
+		{
+			fixture:   "absent",
+			dmidecode: "dmidecode-uefi",
+			mode:      BootModeLegacy,
+			result: Result{
+				Name:     "BootMode",
+				Severity: SeverityWarning,
+				Message: legacy + " This machine's firmware supports UEFI, but the system will be installed for legacy boot. " +
+					"Please consider disabling CSM or legacy boot in the firmware settings, and booting the installer via UEFI.",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		h.sysFirmwareEFI = "./testdata/boot-mode/" + test.fixture + "/sys/firmware/efi"
+		env := &Env{execCommand: fakeCommand(test.dmidecode), machine: h}
+		result, err := BootModeCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err)
+		assert.Equal(t, test.result, result, test.fixture+" "+test.dmidecode)
+		assert.Equal(t, test.mode, env.Inventory.BootMode)
+	}
+}
+
+// Secure Boot stops the kernel loading modules which aren't signed, so
+// those SaftOS needs have to be.
+func TestSecureBootCheckUnsignedModules(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/boot-mode/efi-present-64"
+	h.sysFirmwareEFI = h.root + "/sys/firmware/efi"
+	h.unameRelease = func() (string, string, error) { return "6.4.0", "x86_64", nil }
+
+	var probed []string
+	env := &Env{machine: h, execCommand: func(name string, args ...string) *exec.Cmd {
+		module := args[len(args)-1]
+		probed = append(probed, module)
+		switch module {
+		case "tun", "vhost_net":
+			return fakeExecCommand("modinfo-unsigned")
+		case "iscsi_tcp":
+			// modinfo can't find it
+			return fakeExecCommand("modprobe-fail")
+		}
+		return fakeExecCommand("modinfo-signed")
+	}}
+	result, err := SecureBootCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{
+		Name:     "SecureBoot",
+		Severity: SeverityWarning,
+		Message: "Secure Boot is enabled. These kernel modules SaftOS needs are not signed, so they cannot be loaded while it is: tun, vhost_net. " +
+			"Please disable Secure Boot, or boot a kernel whose modules are signed.",
+	}, result)
+	// Those built in needn't be signed
+	assert.NotContains(t, probed, "overlay")
+	assert.NotContains(t, probed, "bridge")
+	assert.Contains(t, probed, "vxlan")
+}
+
+func TestReadEFIVar(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareEFI = "./testdata/boot-mode/efi-present-64/sys/firmware/efi"
+	data, err := readEFIVar(&Env{machine: h}, "SecureBoot")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, data)
+
+	_, err = readEFIVar(&Env{machine: h}, "PK")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestSecureBootCheck(t *testing.T) {
+	// The kernel's modules are all signed, or built in
+	h := testHost()
+	h.root = "./testdata/boot-mode/efi-present-64"
+	h.unameRelease = func() (string, string, error) { return "6.4.0", "x86_64", nil }
+	modinfo := fakeCommand("modinfo-signed")
+
+	// The states are given as the SecureBoot and SetupMode values, with
+	// nil meaning the variable can't be read
+	states := map[string]struct {
+		efi        bool
+		secureBoot []byte
+		setupMode  []byte
+	}{
+		"enabled":    {true, []byte{1}, []byte{0}},
+		"disabled":   {true, []byte{0}, []byte{0}},
+		"setup-mode": {true, []byte{0}, []byte{1}},
+		"not-uefi":   {false, nil, nil},
+		"unreadable": {true, nil, nil},
+	}
+
+	tests := []struct {
+		state    string
+		policy   SecureBootPolicy
+		severity Severity
+		message  string
+	}{
+		{"enabled", SecureBootAny, SeverityOK, "Secure Boot is enabled."},
+		{"enabled", SecureBootRequired, SeverityOK, "Secure Boot is enabled."},
+		{"enabled", SecureBootMustBeOff, SeverityFatal,
+			"Secure Boot is enabled. Secure Boot must be disabled on this site, please disable it in the firmware settings."},
+		{"disabled", SecureBootAny, SeverityOK, "Secure Boot is disabled."},
+		{"disabled", SecureBootRequired, SeverityFatal,
+			"Secure Boot is disabled. Secure Boot is required, please enable it in the firmware settings."},
+		{"disabled", SecureBootMustBeOff, SeverityOK, "Secure Boot is disabled."},
+		{"setup-mode", SecureBootAny, SeverityOK,
+			"Secure Boot is disabled, because the firmware is in setup mode (no platform key is enrolled)."},
+		{"setup-mode", SecureBootRequired, SeverityWarning,
+			"Secure Boot is disabled, because the firmware is in setup mode (no platform key is enrolled). " +
+				"Secure Boot is required, please enroll the platform keys and enable Secure Boot before putting this host into production."},
+		{"not-uefi", SecureBootAny, SeverityOK, "Secure Boot is not available, because the system was not booted via UEFI."},
+		{"not-uefi", SecureBootRequired, SeverityFatal,
+			"Secure Boot is not available, because the system was not booted via UEFI. " +
+				"Secure Boot is required, please boot the installer via UEFI with Secure Boot enabled."},
+		{"not-uefi", SecureBootMustBeOff, SeverityOK, "Secure Boot is not available, because the system was not booted via UEFI."},
+		{"unreadable", SecureBootAny, SeverityOK,
+			"Unable to determine whether Secure Boot is enabled, because the SecureBoot EFI variable could not be read."},
+		{"unreadable", SecureBootRequired, SeverityWarning,
+			"Unable to determine whether Secure Boot is enabled, because the SecureBoot EFI variable could not be read. " +
+				"Please verify the Secure Boot setting in the firmware manually."},
+	}
+
+	for _, test := range tests {
+		state := states[test.state]
+		h.sysFirmwareEFI = "./testdata/boot-mode/absent/sys/firmware/efi"
+		if state.efi {
+			// Each variable is preceded by its attributes
+			h.sysFirmwareEFI = t.TempDir()
+			writeFile(t, h.sysFirmwareEFI, "efivars/.keep", "")
+			for name, data := range map[string][]byte{"SecureBoot": state.secureBoot, "SetupMode": state.setupMode} {
+				if data != nil {
+					writeFile(t, h.sysFirmwareEFI, "efivars/"+name+"-"+efiGlobalVariable, "\x06\x00\x00\x00"+string(data))
+				}
+			}
+		}
+		env := &Env{Options: Options{SecureBootPolicy: test.policy}, execCommand: modinfo, machine: h}
+		result, err := SecureBootCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err)
+		assert.Equal(t, Result{Name: "SecureBoot", Severity: test.severity, Message: test.message}, result,
+			test.state+" "+string(test.policy))
+	}
+}
+
+func TestParseSecureBootPolicy(t *testing.T) {
+	policy, err := ParseSecureBootPolicy("required")
+	assert.Nil(t, err)
+	assert.Equal(t, SecureBootRequired, policy)
+
+	policy, err = ParseSecureBootPolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, SecureBootAny, policy)
+
+	_, err = ParseSecureBootPolicy("on")
+	assert.NotNil(t, err)
+}
+
+func TestCompareFirmwareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+		ok   bool
+	}{
+		// Dell, Supermicro-style dotted versions
+		{"1.5.6", "1.5.6", 0, true},
+		{"1.5.6", "1.10.2", -1, true},
+		{"2.19.0", "2.9.1", 1, true},
+		{"3.4", "3.4a", -1, true},
+		{"3.4b", "3.4a", 1, true},
+		{"1.0", "1.0.0", 0, true},
+		{"1.0.0", "1", 0, true},
+		{"1.0", "1.0.1", -1, true},
+		{"2.1.0a", "2.1", 1, true},
+		// HPE firmware families
+		{"U32 v2.80", "U32 v2.72", 1, true},
+		{"U32 v2.60", "U32 v2.72", -1, true},
+		{"A43 v1.20", "U32 v2.72", 0, false},
+		// Lenovo build IDs
+		{"IVE170K-2.30", "IVE164K-2.10", 1, true},
+		{"IVE164K-2.10", "IVE164K-2.10", 0, true},
+		{"IVE164K-2.10", "TEE170H-3.10", 0, false},
+		// Nothing to compare
+		{"", "1.0", 0, false},
+	}
+
+	for _, test := range tests {
+		cmp, ok := compareFirmwareVersions(test.a, test.b)
+		assert.Equal(t, test.ok, ok, test.a+" vs "+test.b)
+		if ok {
+			assert.Equal(t, test.cmp, cmp, test.a+" vs "+test.b)
+		}
+	}
+}
+
+func TestFirmwareVersionCheck(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+	h.firmwareOverridePath = "./testdata/firmware/override.yaml"
+
+	expectedFirmware := &Firmware{
+		Vendor:             "Dell Inc.",
+		Version:            "1.5.6",
+		ReleaseDate:        "06/14/2022",
+		SystemManufacturer: "Dell Inc.",
+		SystemProductName:  "PowerEdge R750",
+	}
+
+	tests := []struct {
+		name     string
+		minimums []FirmwareMinimum
+		result   Result
+	}{
+		{
+			name:     "unknown platform",
+			minimums: []FirmwareMinimum{},
+			result:   Result{Name: "FirmwareVersion"},
+		},
+		{
+			name: "known problem",
+			result: Result{
+				Name:     "FirmwareVersion",
+				Severity: SeverityWarning,
+				Message: "Firmware version 1.5.6 on Dell Inc. PowerEdge R750 is older than the recommended minimum 1.6.5 " +
+					"(x2APIC interrupt remapping can fail, losing interrupts under load). Please update the firmware.",
+			},
+		},
+		{
+			name: "new enough",
+			minimums: []FirmwareMinimum{
+				{Manufacturer: "Dell Inc.", Product: "PowerEdge R7?0", MinVersion: "1.4.0", Reason: "broken x2APIC"},
+			},
+			result: Result{Name: "FirmwareVersion"},
+		},
+		{
+			name: "other platform",
+			minimums: []FirmwareMinimum{
+				{Manufacturer: "HPE", Product: "ProLiant DL380 Gen10", MinVersion: "U30 v2.80", Reason: "IOMMU errata"},
+			},
+			result: Result{Name: "FirmwareVersion"},
+		},
+		{
+			name: "too old",
+			minimums: []FirmwareMinimum{
+				{Manufacturer: "HPE", Product: "ProLiant DL380 Gen10", MinVersion: "U30 v2.80", Reason: "IOMMU errata"},
+				{Product: "PowerEdge R7?0", MinVersion: "1.10.2", Reason: "broken x2APIC"},
+			},
+			result: Result{
+				Name:     "FirmwareVersion",
+				Severity: SeverityWarning,
+				Message:  "Firmware version 1.5.6 on Dell Inc. PowerEdge R750 is older than the recommended minimum 1.10.2 (broken x2APIC). Please update the firmware.",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		env := &Env{execCommand: fakeCommand("dmidecode-dell"), machine: h}
+		result, err := FirmwareVersionCheck{Minimums: test.minimums}.Evaluate(context.Background(), env)
+		assert.Nil(t, err)
+		assert.Equal(t, test.result, result, test.name)
+		assert.Equal(t, expectedFirmware, env.Inventory.Firmware, test.name)
+	}
+}
+
+// None are shipped, so only the ones a site adds are checked.
+func TestLoadFirmwareMinimums(t *testing.T) {
+	minimums, err := loadFirmwareMinimums("./testdata/firmware/missing.yaml")
+	assert.Nil(t, err)
+	assert.Empty(t, minimums)
+
+	minimums, err = loadFirmwareMinimums("./testdata/firmware/override.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, FirmwareMinimum{Manufacturer: "HPE", Product: "ProLiant DL380 Gen10", MinVersion: "U30 v2.80",
+		Reason: "IOMMU errata can corrupt DMA from devices passed through to VMs"}, minimums[1])
+
+	_, err = loadFirmwareMinimums("./testdata/firmware/malformed.yaml")
+	assert.ErrorContains(t, err, "unable to parse firmware minimums")
+
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+	h.firmwareOverridePath = "./testdata/firmware/missing.yaml"
+	env := &Env{execCommand: fakeCommand("dmidecode-dell"), machine: h}
+	result, err := FirmwareVersionCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "FirmwareVersion"}, result)
+}
+
+func TestFirmwareVersionCheckNoSMBIOS(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/none"
+	env := &Env{machine: h}
+	result, err := FirmwareVersionCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "FirmwareVersion", Message: "Skipped: SMBIOS not available on this platform."}, result)
+	assert.Nil(t, env.Inventory.Firmware)
+}
@@ -0,0 +1,241 @@
+package preflight
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// A captured machine is a directory mirroring the paths the checks read
+// on a real one, e.g. proc/meminfo, sys/devices/system/cpu/present and
+// sys/class/net/eth0/speed, with what the tools the checks run printed
+// under dumps, named by dumpName, e.g. dumps/dmidecode.txt.  A tool's
+// exit status, if it wasn't zero, goes alongside its dump, e.g. in
+// dumps/dmidecode.exit.  A tool without a dump isn't installed.  The
+// running kernel is given by proc/sys/kernel/osrelease and arch, and the
+// CPUs online by sys/devices/system/cpu/online, since the checks ask the
+// kernel for those rather than reading them.
+//
+// The profiles under testdata/machines were captured from machines QA
+// looked at; more can be added there by copying the files the checks
+// read, or by unpacking a support bundle, which is laid out the same way.
+
+// dumpCommand returns a command which prints the dump at path, and exits
+// with the status beside it, or one which can't be run if there's no
+// dump.
+func dumpCommand(path string) *exec.Cmd {
+	if _, err := os.Stat(path); err != nil {
+		return exec.Command(path)
+	}
+	return fakeExecCommand("dump:" + path)
+}
+
+// testHost returns a copy of the live host, for a test to point at a
+// machine of its own.
+func testHost() *host {
+	h := liveHost
+	return &h
+}
+
+// loadFixture returns a host with every path the checks read in the
+// captured machine in dir, and what runs the tools they run against its
+// dumps.  The checks which are told where to look, such as MemoryCheck,
+// are pointed there by fixtureChecks.
+func loadFixture(dir string) (*host, execFunc) {
+	h := testHost()
+	for _, path := range []*string{
+		&h.etcAdjtime, &h.systemBusSocket,
+		&h.sysBusPCIDevices, &h.sysKernelIOMMUGroups, &h.sysBusPCIDrivers, &h.sysBusPlatformDevices,
+		&h.procCmdline, &h.procCPUInfo, &h.procTTYDriverSerial, &h.sysClassTTY, &h.devDir, &h.sysBlock,
+		&h.sysClassThermal, &h.sysClassHwmon, &h.sysModuleKVMIntelNested, &h.sysModuleKVMAMDNested, &h.devTPMRM, &h.devTPM, &h.sysClassTPM, &h.sysClassWatchdog,
+		&h.sysFirmwareDMITables, &h.sysFirmwareEFI, &h.sysFirmwareACPITables, &h.maximaOverridePath, &h.hclOverridePath, &h.firmwareOverridePath,
+	} {
+		*path = filepath.Join(dir, *path)
+	}
+	h.root = dir
+	// The captured devices are files
+	h.isBlockDevice = func(info fs.FileInfo) bool { return info.Mode().IsRegular() }
+	h.onlineCPUs = func() int {
+		cpus := map[int]bool{}
+		addCPUList(cpus, (&Env{}).readTrimmed(filepath.Join(dir, "sys/devices/system/cpu/online")))
+		return len(cpus)
+	}
+	h.unameRelease = func() (string, string, error) {
+		release, err := os.ReadFile(filepath.Join(dir, "proc/sys/kernel/osrelease"))
+		if err != nil {
+			return "", "", err
+		}
+		machine, err := os.ReadFile(filepath.Join(dir, "proc/sys/kernel/arch"))
+		return strings.TrimSpace(string(release)), strings.TrimSpace(string(machine)), err
+	}
+	return h, func(name string, args ...string) *exec.Cmd {
+		return dumpCommand(filepath.Join(dir, "dumps", dumpName(name, args...)))
+	}
+}
+
+// fixtureChecks are the checks which are run against the captured machine
+// on h, in dir: those of the host's hardware and kernel, which only read
+// its state, with NetworkSpeedCheck for each of its NICs.
+func fixtureChecks(t *testing.T, h *host, dir string) []ResultCheck {
+	checks := []ResultCheck{
+		CmdlineCheck{},
+		CPUCheck{},
+		MemoryCheck{MemInfoPath: filepath.Join(dir, defaultProcMemInfo)},
+		BootModeCheck{},
+		ChassisCheck{},
+		KernelVersionCheck{},
+	}
+	nics, err := (&Env{machine: h}).listNICs()
+	assert.Nil(t, err)
+	for _, nic := range nics {
+		checks = append(checks, NetworkSpeedCheck{nic.Name})
+	}
+	return checks
+}
+
+// RunAgainstFixture runs the checks against the captured machine in dir,
+// with the default Options, and returns their results.
+func RunAgainstFixture(t *testing.T, dir string) []Result {
+	t.Helper()
+	h, command := loadFixture(dir)
+	runner := Runner{Checks: fixtureChecks(t, h, dir), ExecCommand: command, machine: h}
+	return runner.Run(context.Background()).Results
+}
+
+// verdict returns the worst severity of results, and the names of the
+// checks which weren't OK, or failed to run.
+func verdict(results []Result) (worst Severity, flagged []string) {
+	for _, result := range results {
+		worst = max(worst, result.Severity)
+		if result.Severity != SeverityOK || result.Error != "" {
+			flagged = append(flagged, result.Name)
+		}
+	}
+	return
+}
+
+func TestDumpName(t *testing.T) {
+	assert.Equal(t, "dmidecode.txt", dumpName("/usr/sbin/dmidecode"))
+	assert.Equal(t, "dmidecode-t-19.txt", dumpName("/usr/sbin/dmidecode", "-t", "19"))
+	assert.Equal(t, "systemctl-version.txt", dumpName("/usr/bin/systemctl", "--version"))
+}
+
+func TestRunAgainstFixture(t *testing.T) {
+	tests := []struct {
+		machine string
+		worst   Severity
+		flagged []string
+		errors  map[string]ErrorKind
+	}{
+		{
+			// Too small even for testing, and its virtio NIC doesn't
+			// have a speed
+			machine: "small-vm",
+			worst:   SeverityFatal,
+			flagged: []string{"CPU", "Memory", "BootMode", "NetworkSpeed"},
+		},
+		{
+			machine: "server-1g-nic",
+			worst:   SeverityWarning,
+			flagged: []string{"NetworkSpeed"},
+		},
+		{
+			machine: "prod-healthy",
+			worst:   SeverityOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.machine, func(t *testing.T) {
+			results := RunAgainstFixture(t, filepath.Join("testdata/machines", tt.machine))
+			worst, flagged := verdict(results)
+			assert.Equal(t, tt.worst, worst)
+			assert.Equal(t, tt.flagged, flagged)
+			errors := map[string]ErrorKind{}
+			for _, result := range results {
+				if result.Error != "" {
+					errors[result.Name] = result.ErrorKind
+				}
+			}
+			if tt.errors == nil {
+				tt.errors = map[string]ErrorKind{}
+			}
+			assert.Equal(t, tt.errors, errors)
+		})
+	}
+}
+
+// The checks share nothing mutable, so that runs can overlap, e.g. in a
+// long-lived process which re-runs them.  Run with -race to prove it.
+func TestRunConcurrently(t *testing.T) {
+	dir := "testdata/machines/prod-healthy"
+	h, command := loadFixture(dir)
+	checks := ConfigChecks(config.NewHarvesterConfig())
+	for i, check := range checks {
+		if _, ok := check.(MemoryCheck); ok {
+			checks[i] = MemoryCheck{MemInfoPath: filepath.Join(dir, defaultProcMemInfo)}
+		}
+	}
+	checks = append(checks, fixtureChecks(t, h, dir)...)
+	opts := Options{AirGapped: true, CaptureEvidence: true}
+
+	serial := Runner{Checks: checks, Options: opts, ExecCommand: command, machine: h}
+	expected := serial.Run(context.Background())
+
+	const runs = 8
+	reports := make([]Report, runs)
+	var wg sync.WaitGroup
+	for i := range reports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runner := Runner{Checks: checks, Options: opts, ExecCommand: command, machine: h}
+			reports[i] = runner.Run(context.Background())
+		}()
+	}
+	wg.Wait()
+	for _, report := range reports {
+		assert.Equal(t, len(expected.Results), len(report.Results))
+		for i, result := range report.Results {
+			assert.Equal(t, expected.Results[i].Name, result.Name)
+			assert.Equal(t, expected.Results[i].Severity, result.Severity, result.Name)
+			assert.Equal(t, expected.Results[i].Message, result.Message, result.Name)
+			assert.Equal(t, expected.Results[i].Error, result.Error, result.Name)
+		}
+	}
+}
+
+// Replaying a machine reads nothing from the host running the tests.
+func TestLoadFixture(t *testing.T) {
+	h, command := loadFixture("testdata/machines/server-1g-nic")
+	assert.Equal(t, "testdata/machines/server-1g-nic/sys/block", h.sysBlock)
+	assert.Equal(t, 16, h.onlineCPUs())
+	cpus, err := cpuinfoCPUs(&Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, 16, cpus)
+	release, machine, err := h.unameRelease()
+	assert.Nil(t, err)
+	assert.Equal(t, [2]string{"5.14.21-150500.55.39-default", "x86_64"}, [2]string{release, machine})
+
+	out, err := command.cmd("/usr/sbin/dmidecode").Output()
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "Product Name: SYS-6029P-TRT")
+
+	// Tools without a dump weren't installed
+	err = toolError("nproc", command.cmd("/usr/bin/nproc", "--all").Run())
+	assert.ErrorIs(t, err, ErrToolMissing)
+
+	// The live host is left as it was
+	assert.Equal(t, "/sys/block", liveHost.sysBlock)
+	assert.Equal(t, "/proc/cpuinfo", liveHost.procCPUInfo)
+	assert.Equal(t, "/", liveHost.root)
+}
@@ -0,0 +1,124 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pciVendorNames are the GPU vendors we know by name
+var pciVendorNames = map[string]string{
+	"10de": "NVIDIA",
+	"1002": "AMD",
+	"8086": "Intel",
+}
+
+// bmcGraphicsVendors make the simple VGA controllers built into BMCs,
+// which aren't interesting as GPUs
+var bmcGraphicsVendors = []string{"1a03", "102b"}
+
+// nvidiaVGPUDevices are the NVIDIA boards which support vGPU, by PCI
+// device ID.
+var nvidiaVGPUDevices = map[string]string{
+	"13bd": "Tesla M10",
+	"1b38": "Tesla P40",
+	"1db4": "Tesla V100 PCIe",
+	"1eb8": "Tesla T4",
+	"20b5": "A100 80GB PCIe",
+	"20b7": "A30",
+	"20f1": "A100 PCIe",
+	"2230": "RTX A6000",
+	"2235": "A40",
+	"2236": "A10",
+	"25b6": "A16",
+	"26b1": "RTX 6000 Ada",
+	"26b5": "L40",
+	"26b9": "L40S",
+	"27b8": "L4",
+}
+
+// A GPU is a display or 3D controller.
+type GPU struct {
+	Address string
+	Vendor  string
+	Device  string
+	Driver  string
+	// VGPU is the board name, if it's a vGPU-capable NVIDIA board
+	VGPU string
+}
+
+// GPUCheck lists the GPUs and the drivers they're bound to, and advises
+// on what's needed to pass them through to VMs.  It doesn't change any
+// driver bindings.
+type GPUCheck struct{}
+
+func (c GPUCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "GPU"
+
+	devs, err := env.listPCIDevices()
+	if err != nil {
+		return
+	}
+	if env.Inventory.PCIDevices == nil {
+		env.Inventory.PCIDevices = inventoryPCIDevices(devs)
+	}
+	var descs []string
+	var hostBound bool
+	for _, dev := range devs {
+		if dev.baseClass() != 0x03 || slices.Contains(bmcGraphicsVendors, dev.Vendor) {
+			continue
+		}
+		gpu := GPU{
+			Address: dev.Address,
+			Vendor:  dev.Vendor,
+			Device:  dev.Device,
+			Driver:  dev.Driver,
+		}
+		if dev.Vendor == "10de" {
+			gpu.VGPU = nvidiaVGPUDevices[dev.Device]
+		}
+		env.Inventory.GPUs = append(env.Inventory.GPUs, gpu)
+
+		desc := fmt.Sprintf("%s %s:%s", dev.Address, dev.Vendor, dev.Device)
+		if name, ok := pciVendorNames[dev.Vendor]; ok {
+			desc = fmt.Sprintf("%s %s", dev.Address, name)
+			if gpu.VGPU != "" {
+				desc += " " + gpu.VGPU
+			} else {
+				desc += fmt.Sprintf(" %s:%s", dev.Vendor, dev.Device)
+			}
+		}
+		if gpu.VGPU != "" {
+			desc += " (vGPU capable)"
+		}
+		switch dev.Driver {
+		case "":
+			desc += ", no driver"
+		case "vfio-pci":
+			desc += ", bound to vfio-pci"
+		default:
+			desc += ", bound to " + dev.Driver
+			hostBound = true
+		}
+		descs = append(descs, desc)
+	}
+	if len(descs) == 0 {
+		return
+	}
+
+	result.Severity = SeverityInfo
+	msgs := []string{fmt.Sprintf("GPUs: %s.", strings.Join(descs, "; "))}
+	if hostBound {
+		msgs = append(msgs, "GPUs bound to host drivers will need to be bound to vfio-pci before they can be passed through to VMs.")
+	}
+	enabled, err := env.iommuEnabled()
+	if err != nil {
+		return
+	}
+	if !enabled {
+		msgs = append(msgs, "The IOMMU is not enabled, so GPUs can't be passed through to VMs. Please enable VT-d or AMD-Vi in the firmware settings.")
+	}
+	result.Message = strings.Join(msgs, " ")
+	return
+}
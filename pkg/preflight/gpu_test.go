@@ -0,0 +1,54 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGPUCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture string
+		gpus    []GPU
+		result  Result
+	}{
+		{
+			fixture: "nouveau",
+			gpus: []GPU{
+				{Address: "0000:01:00.0", Vendor: "10de", Device: "1eb8", Driver: "nouveau", VGPU: "Tesla T4"},
+			},
+			result: Result{
+				Name:     "GPU",
+				Severity: SeverityInfo,
+				Message: "GPUs: 0000:01:00.0 NVIDIA Tesla T4 (vGPU capable), bound to nouveau. " +
+					"GPUs bound to host drivers will need to be bound to vfio-pci before they can be passed through to VMs. " +
+					"The IOMMU is not enabled, so GPUs can't be passed through to VMs. Please enable VT-d or AMD-Vi in the firmware settings.",
+			},
+		},
+		{
+			fixture: "vfio",
+			gpus: []GPU{
+				{Address: "0000:41:00.0", Vendor: "10de", Device: "2236", Driver: "vfio-pci", VGPU: "A10"},
+				{Address: "0000:42:00.0", Vendor: "1002", Device: "744c"},
+			},
+			result: Result{
+				Name:     "GPU",
+				Severity: SeverityInfo,
+				Message:  "GPUs: 0000:41:00.0 NVIDIA A10 (vGPU capable), bound to vfio-pci; 0000:42:00.0 AMD 1002:744c, no driver.",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		h.sysBusPCIDevices = "./testdata/gpu/" + test.fixture + "/sys/bus/pci/devices"
+		h.sysKernelIOMMUGroups = "./testdata/gpu/" + test.fixture + "/sys/kernel/iommu_groups"
+		env := &Env{machine: h}
+		result, err := GPUCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, test.result, result, test.fixture)
+		assert.Equal(t, test.gpus, env.Inventory.GPUs, test.fixture)
+	}
+}
@@ -0,0 +1,352 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+//go:embed rules/hcl.json
+var defaultHCL []byte
+
+// defaultHCLOverridePath is where a newer hardware compatibility list can
+// be put than the one shipped with the installer.
+const defaultHCLOverridePath = "/etc/saftos/preflight/hcl.json"
+
+// HCLVersion is the version of the hardware compatibility list's format.
+const HCLVersion = 1
+
+// The kinds of component an HCLEntry can be.
+const (
+	// HCLKindPCI is a PCI storage or network controller, by its
+	// vendor:device ID, e.g. "8086:1572".
+	HCLKindPCI = "pci"
+	// HCLKindPlatform is a system, by its SMBIOS product name.
+	HCLKindPlatform = "platform"
+	// HCLKindDriver is a NIC's kernel driver, e.g. "i40e".
+	HCLKindDriver = "driver"
+)
+
+// The statuses an HCLEntry can have.
+const (
+	HCLStatusValidated   = "validated"
+	HCLStatusKnownIssues = "known-issues"
+)
+
+// An HCL is a hardware compatibility list: the components SaftOS has been
+// validated with, or is known to have problems with.
+type HCL struct {
+	Version int        `json:"version"`
+	Entries []HCLEntry `json:"entries"`
+}
+
+// An HCLEntry is a component on the hardware compatibility list.
+type HCLEntry struct {
+	// Kind is HCLKindPCI, HCLKindPlatform or HCLKindDriver.
+	Kind string `json:"kind"`
+	// ID identifies the component, as it's detected: the PCI IDs in
+	// lower case hex, the product name or the driver name.
+	ID string `json:"id"`
+	// Name is what the component is called, if its ID doesn't say.
+	Name string `json:"name,omitempty"`
+	// Status is HCLStatusValidated or HCLStatusKnownIssues.
+	Status string `json:"status"`
+	// Link is where the known issues are described, which entries with
+	// known issues must have.
+	Link string `json:"link,omitempty"`
+}
+
+// pciIDPattern matches the IDs of HCLKindPCI entries.
+var pciIDPattern = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{4}$`)
+
+// DefaultHCL returns the hardware compatibility list from the override
+// file, if there is one, otherwise the one shipped in rules/hcl.json.
+// The override replaces the shipped list, rather than adding to it, as
+// it's meant to be a newer edition of it.
+func DefaultHCL() (*HCL, error) {
+	return loadHCL(defaultHCLOverridePath)
+}
+
+// loadHCL is DefaultHCL, with the override file at path.
+func loadHCL(path string) (*HCL, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		path, data = "rules/hcl.json", defaultHCL
+	} else if err != nil {
+		return nil, err
+	}
+	hcl, err := ParseHCL(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the hardware compatibility list %s: %w", path, err)
+	}
+	return hcl, nil
+}
+
+// ParseHCL parses a hardware compatibility list, in JSON.  Malformed
+// entries are rejected with a ParseError saying which line they start
+// on, rather than skipped, so that a list which doesn't say what it
+// means isn't half used.
+func ParseHCL(data []byte) (*HCL, error) {
+	hcl := &HCL{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := expectDelim(decoder, data, '{'); err != nil {
+		return nil, err
+	}
+	seen := map[[2]string]int{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, hclSyntaxError(data, decoder, err)
+		}
+		switch token {
+		case "version":
+			if err := decoder.Decode(&hcl.Version); err != nil {
+				return nil, hclSyntaxError(data, decoder, err)
+			}
+		case "entries":
+			if err := expectDelim(decoder, data, '['); err != nil {
+				return nil, err
+			}
+			for decoder.More() {
+				line := lineAt(data, decoder.InputOffset())
+				var entry HCLEntry
+				if err := decoder.Decode(&entry); err != nil {
+					return nil, hclSyntaxError(data, decoder, err)
+				}
+				if reason := entry.validate(); reason != "" {
+					return nil, hclEntryError(data, line, reason)
+				}
+				key := [2]string{entry.Kind, entry.ID}
+				if first, ok := seen[key]; ok {
+					return nil, hclEntryError(data, line, fmt.Sprintf("duplicate of the entry on line %d", first))
+				}
+				seen[key] = line
+				hcl.Entries = append(hcl.Entries, entry)
+			}
+			if err := expectDelim(decoder, data, ']'); err != nil {
+				return nil, err
+			}
+		default:
+			line := lineAt(data, decoder.InputOffset())
+			return nil, hclEntryError(data, line, fmt.Sprintf("unknown field %v", token))
+		}
+	}
+	if err := expectDelim(decoder, data, '}'); err != nil {
+		return nil, err
+	}
+	if hcl.Version != HCLVersion {
+		return nil, fmt.Errorf("unsupported hardware compatibility list version %d, expected %d", hcl.Version, HCLVersion)
+	}
+	return hcl, nil
+}
+
+// validate returns what's wrong with the entry, if anything.
+func (e HCLEntry) validate() string {
+	switch {
+	case e.Kind != HCLKindPCI && e.Kind != HCLKindPlatform && e.Kind != HCLKindDriver:
+		return fmt.Sprintf("unknown kind %q", e.Kind)
+	case e.ID == "":
+		return "no id"
+	case e.Kind == HCLKindPCI && !pciIDPattern.MatchString(e.ID):
+		return fmt.Sprintf("PCI id %q isn't vendor:device in lower case hex", e.ID)
+	case e.Status != HCLStatusValidated && e.Status != HCLStatusKnownIssues:
+		return fmt.Sprintf("unknown status %q", e.Status)
+	case e.Status == HCLStatusKnownIssues && e.Link == "":
+		return "known issues without a link to them"
+	}
+	return ""
+}
+
+// expectDelim reads the next token, which must be delim.
+func expectDelim(decoder *json.Decoder, data []byte, delim json.Delim) error {
+	line := lineAt(data, decoder.InputOffset())
+	token, err := decoder.Token()
+	if err != nil {
+		return hclSyntaxError(data, decoder, err)
+	}
+	if token != delim {
+		return hclEntryError(data, line, fmt.Sprintf("expected %v", delim))
+	}
+	return nil
+}
+
+// hclSyntaxError returns err, which the decoder returned, as a
+// ParseError on the line it was at.
+func hclSyntaxError(data []byte, decoder *json.Decoder, err error) error {
+	offset := decoder.InputOffset()
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		offset = int64(len(data))
+	}
+	return hclEntryError(data, lineAt(data, offset), err.Error())
+}
+
+// hclEntryError returns a ParseError for the given line of data.
+func hclEntryError(data []byte, line int, reason string) error {
+	lines := strings.Split(string(data), "\n")
+	text := ""
+	if line <= len(lines) {
+		text = strings.TrimSpace(lines[line-1])
+	}
+	return &ParseError{Where: fmt.Sprintf("line %d", line), Text: text, Reason: reason}
+}
+
+// lineAt returns the line of the next token at or after offset, in data.
+func lineAt(data []byte, offset int64) int {
+	offset = min(offset, int64(len(data)))
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// lookup returns the entry for the given component, if it's on the list.
+func (h *HCL) lookup(kind, id string) (HCLEntry, bool) {
+	for _, entry := range h.Entries {
+		if entry.Kind == kind && entry.ID == id {
+			return entry, true
+		}
+	}
+	return HCLEntry{}, false
+}
+
+// HCLCheck looks up the host's storage and network controllers, platform
+// and NIC drivers on the hardware compatibility list, and says which of
+// them have been validated, have known issues, or aren't on it.  It's
+// purely advisory, as hardware which hasn't been validated usually works
+// all the same, so it never fails.
+type HCLCheck struct {
+	// List overrides DefaultHCL, if set.
+	List *HCL
+}
+
+// hclComponent is a component of the host to look up.
+type hclComponent struct {
+	Kind string
+	ID   string
+}
+
+func (c hclComponent) String() string {
+	switch c.Kind {
+	case HCLKindPCI:
+		return "PCI device " + c.ID
+	case HCLKindPlatform:
+		return fmt.Sprintf("platform %q", c.ID)
+	}
+	return "driver " + c.ID
+}
+
+func (c HCLCheck) readsFrom() []string {
+	return []string{"FirmwareVersion"}
+}
+
+func (c HCLCheck) probes(env *Env) []toolCall {
+	return dmiProbes(env)
+}
+
+func (c HCLCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "HCL"
+	hcl := c.List
+	if hcl == nil {
+		if hcl, err = loadHCL(env.host().hclOverridePath); err != nil {
+			return
+		}
+	}
+	components, err := hclComponents(env)
+	if err != nil {
+		return
+	}
+
+	var msgs []string
+	validated := 0
+	for _, component := range components {
+		entry, ok := hcl.lookup(component.Kind, component.ID)
+		name := component.String()
+		if ok && entry.Name != "" {
+			name += " (" + entry.Name + ")"
+		}
+		switch {
+		case !ok:
+			msgs = append(msgs, fmt.Sprintf("%s: not on the compatibility list.", name))
+		case entry.Status == HCLStatusKnownIssues:
+			msgs = append(msgs, fmt.Sprintf("%s: known issues (%s).", name, entry.Link))
+		default:
+			validated++
+			msgs = append(msgs, fmt.Sprintf("%s: validated.", name))
+		}
+	}
+	msgs = append(msgs, fmt.Sprintf("%d of %d components validated.", validated, len(components)))
+	if validated < len(components) {
+		result.Severity = SeverityInfo
+	}
+	result.Message = strings.Join(msgs, " ")
+	result.Facts = map[string]any{"components": len(components), "validated": validated}
+	return
+}
+
+// hclComponents returns the components of the host which the hardware
+// compatibility list covers: its storage and network controllers, its
+// platform, if it has SMBIOS, and the drivers of its NICs.
+func hclComponents(env *Env) ([]hclComponent, error) {
+	var components []hclComponent
+	devs, err := env.listPCIDevices()
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range devs {
+		// Mass storage and network controllers
+		if class := dev.baseClass(); class != 0x01 && class != 0x02 {
+			continue
+		}
+		component := hclComponent{Kind: HCLKindPCI, ID: strings.ToLower(dev.Vendor + ":" + dev.Device)}
+		if !slices.Contains(components, component) {
+			components = append(components, component)
+		}
+	}
+
+	product := ""
+	if fw := env.Inventory.Firmware; fw != nil {
+		product = fw.SystemProductName
+	} else {
+		system, err := env.dmi(1)
+		if err != nil && !errors.Is(err, errNoSMBIOS) {
+			return nil, err
+		}
+		if len(system) > 0 {
+			product = system[0].Fields["Product Name"]
+		}
+	}
+	if product = strings.TrimSpace(product); product != "" {
+		components = append(components, hclComponent{Kind: HCLKindPlatform, ID: product})
+	}
+
+	nics := env.Inventory.NICs
+	if nics == nil {
+		if nics, err = env.listNICs(); err != nil {
+			return nil, err
+		}
+	}
+	for _, nic := range nics {
+		component := hclComponent{Kind: HCLKindDriver, ID: nic.Driver}
+		if nic.Driver != "" && !slices.Contains(components, component) {
+			components = append(components, component)
+		}
+	}
+	return components, nil
+}
@@ -0,0 +1,155 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadHCL(t *testing.T) {
+	hcl, err := loadHCL("./testdata/hcl/missing.json")
+	assert.Nil(t, err)
+	assert.Equal(t, HCLVersion, hcl.Version)
+	assert.Contains(t, hcl.Entries, HCLEntry{Kind: HCLKindDriver, ID: "i40e", Status: HCLStatusValidated})
+
+	// The override replaces the shipped list
+	hcl, err = loadHCL("./testdata/hcl/override.json")
+	assert.Nil(t, err)
+	assert.Len(t, hcl.Entries, 3)
+	_, ok := hcl.lookup(HCLKindDriver, "i40e")
+	assert.False(t, ok)
+
+	_, err = loadHCL("./testdata/hcl/malformed.json")
+	assert.EqualError(t, err, "unable to load the hardware compatibility list ./testdata/hcl/malformed.json: "+
+		`line 5, duplicate of the entry on line 4: "{\"kind\": \"pci\", \"id\": \"8086:1572\", \"status\": \"validated\"}"`)
+	assert.True(t, errors.Is(err, ErrParseFailure))
+}
+
+func TestParseHCL(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{
+			name: "valid",
+			data: `{"version": 1, "entries": [{"kind": "platform", "id": "PowerEdge R650", "status": "validated"}]}`,
+		},
+		{
+			name: "unknown kind",
+			data: "{\n\"version\": 1,\n\"entries\": [\n{\"kind\": \"gpu\", \"id\": \"10de:2236\", \"status\": \"validated\"}\n]}",
+			err:  `line 4, unknown kind "gpu": "{\"kind\": \"gpu\", \"id\": \"10de:2236\", \"status\": \"validated\"}"`,
+		},
+		{
+			name: "upper case PCI ID",
+			data: "{\"version\": 1, \"entries\": [\n{\"kind\": \"pci\", \"id\": \"15B3:1017\", \"status\": \"validated\"}]}",
+			err:  `line 2, PCI id "15B3:1017" isn't vendor:device in lower case hex: "{\"kind\": \"pci\", \"id\": \"15B3:1017\", \"status\": \"validated\"}]}"`,
+		},
+		{
+			name: "known issues without a link",
+			data: "{\"version\": 1, \"entries\": [\n{\"kind\": \"driver\", \"id\": \"r8169\", \"status\": \"known-issues\"}]}",
+			err:  `line 2, known issues without a link to them: "{\"kind\": \"driver\", \"id\": \"r8169\", \"status\": \"known-issues\"}]}"`,
+		},
+		{
+			name: "unknown status",
+			data: `{"version": 1, "entries": [{"kind": "driver", "id": "r8169", "status": "fine"}]}`,
+			err:  `line 1, unknown status "fine": "{\"version\": 1, \"entries\": [{\"kind\": \"driver\", \"id\": \"r8169\", \"status\": \"fine\"}]}"`,
+		},
+		{
+			name: "unknown field",
+			data: "{\"version\": 1, \"entries\": [\n{\"kind\": \"driver\", \"driver\": \"r8169\", \"status\": \"validated\"}]}",
+			err:  `line 2, json: unknown field "driver": "{\"kind\": \"driver\", \"driver\": \"r8169\", \"status\": \"validated\"}]}"`,
+		},
+		{
+			name: "truncated",
+			data: "{\"version\": 1, \"entries\": [\n{\"kind\": \"driver\",",
+			err:  `line 2, unexpected EOF: "{\"kind\": \"driver\","`,
+		},
+		{
+			name: "unsupported version",
+			data: `{"version": 2, "entries": []}`,
+			err:  "unsupported hardware compatibility list version 2, expected 1",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := ParseHCL([]byte(test.data))
+		if test.err == "" {
+			assert.Nil(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestHCLCheck(t *testing.T) {
+	h := testHost()
+	h.sysBusPCIDevices = "./testdata/hcl/host/sys/bus/pci/devices"
+	h.sysFirmwareDMITables = "./testdata/hcl/missing"
+
+	nics := []NIC{{Name: "eth0", Driver: "i40e"}, {Name: "eth1", Driver: "i40e"}, {Name: "eth2", Driver: "r8169"}}
+	tests := []struct {
+		name     string
+		override string
+		firmware *Firmware
+		result   Result
+	}{
+		{
+			name:     "embedded list",
+			override: "missing.json",
+			result: Result{
+				Name:     "HCL",
+				Severity: SeverityInfo,
+				Message: "PCI device 8086:1572 (Intel Ethernet Controller X710 for 10GbE SFP+): validated. " +
+					"PCI device 1000:0097 (Broadcom / LSI SAS3008 HBA): validated. " +
+					"PCI device 144d:a80a: not on the compatibility list. " +
+					"driver i40e: validated. " +
+					"driver r8169: not on the compatibility list. " +
+					"3 of 5 components validated.",
+				Facts: map[string]any{"components": 5, "validated": 3},
+			},
+		},
+		{
+			name:     "override",
+			override: "override.json",
+			firmware: &Firmware{SystemProductName: "PowerEdge R650"},
+			result: Result{
+				Name:     "HCL",
+				Severity: SeverityInfo,
+				Message: "PCI device 8086:1572 (Intel Ethernet Controller X710 for 10GbE SFP+): known issues (https://example.com/hcl/x710). " +
+					"PCI device 1000:0097: not on the compatibility list. " +
+					"PCI device 144d:a80a (Samsung PM9A3): validated. " +
+					`platform "PowerEdge R650": validated. ` +
+					"driver i40e: not on the compatibility list. " +
+					"driver r8169: not on the compatibility list. " +
+					"2 of 6 components validated.",
+				Facts: map[string]any{"components": 6, "validated": 2},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		h.hclOverridePath = "./testdata/hcl/" + test.override
+		env := &Env{Inventory: Inventory{NICs: nics, Firmware: test.firmware}, machine: h}
+		result, err := HCLCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.result, result, test.name)
+	}
+
+	// Everything's validated
+	list := &HCL{Version: HCLVersion, Entries: []HCLEntry{
+		{Kind: HCLKindPCI, ID: "8086:1572", Status: HCLStatusValidated},
+		{Kind: HCLKindPCI, ID: "1000:0097", Status: HCLStatusValidated},
+		{Kind: HCLKindPCI, ID: "144d:a80a", Status: HCLStatusValidated},
+	}}
+	result, err := HCLCheck{List: list}.Evaluate(context.Background(), &Env{Inventory: Inventory{NICs: []NIC{}}, machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{
+		Name: "HCL",
+		Message: "PCI device 8086:1572: validated. PCI device 1000:0097: validated. PCI device 144d:a80a: validated. " +
+			"3 of 3 components validated.",
+		Facts: map[string]any{"components": 3, "validated": 3},
+	}, result)
+}
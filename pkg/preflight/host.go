@@ -0,0 +1,154 @@
+package preflight
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"os"
+	"runtime"
+	"time"
+)
+
+// A host is the machine the checks look at: where its files are, and how
+// its kernel and clock are asked what they say.  liveHost is the one the
+// installer is running on.  A host isn't changed once it's in use, so
+// that runs can overlap; a run against another machine, such as a test's
+// fake one, is given a host of its own through the Env.
+type host struct {
+	// root is what the paths the checks build themselves, e.g. of a
+	// NIC's sysfs directory, are under.
+	root string
+
+	sysBlock string
+	devDir   string
+	// procCPUInfo is where the CPU flags are read from.
+	procCPUInfo string
+	procCmdline string
+	// procSwaps lists the active swap areas.
+	procSwaps           string
+	procTTYDriverSerial string
+	sysClassTTY         string
+	etcAdjtime          string
+	systemBusSocket     string
+
+	sysBusPCIDevices      string
+	sysBusPCIDrivers      string
+	sysBusPlatformDevices string
+	sysKernelIOMMUGroups  string
+	sysFirmwareDMITables  string
+	sysFirmwareEFI        string
+	// sysFirmwareACPITables is where the firmware's ACPI tables are,
+	// among them those describing the IOMMU.
+	sysFirmwareACPITables string
+	sysClassThermal       string
+	sysClassHwmon         string
+	sysClassWatchdog      string
+	// The TPM's devices, the resource manager first, as that's what's
+	// used when there is one, and where its sysfs directory is
+	devTPMRM    string
+	devTPM      string
+	sysClassTPM string
+	// The KVM modules' nested parameters, which say whether a host lets
+	// its VMs run VMs of their own
+	sysModuleKVMIntelNested string
+	sysModuleKVMAMDNested   string
+
+	// maximaOverridePath is where sites can put their own tested maxima.
+	maximaOverridePath string
+	// hclOverridePath is where a newer hardware compatibility list can
+	// be put than the one shipped with the installer.
+	hclOverridePath string
+	// firmwareOverridePath is where sites can put firmware minimums.
+	firmwareOverridePath string
+
+	// goarch is the host's architecture, as GOARCH names it, which is
+	// the installer's own.
+	goarch string
+	// now and sleep are the host's clock.  sleep waits for d, or until
+	// ctx is done, in which case it returns its error.
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+	// onlineCPUs returns the number of CPUs the installer may be
+	// scheduled on.
+	onlineCPUs func() int
+	// unameRelease returns the running kernel's release (e.g.
+	// "5.14.21-150500.55.39-default") and machine (e.g. "x86_64").
+	unameRelease func() (release, machine string, err error)
+	// hostname returns the live environment's hostname.
+	hostname func() (string, error)
+	// dialSystemBus connects to the D-Bus system bus at path, and says
+	// Hello.
+	dialSystemBus func(ctx context.Context, path string) error
+	// isBlockDevice returns whether info is that of a block device.
+	isBlockDevice func(info fs.FileInfo) bool
+	// statFS returns the bytes available to unprivileged users on the
+	// filesystem path is on.
+	statFS func(path string) (uint64, error)
+	// interfaceAddrs returns the addresses of the host's interfaces, by
+	// interface name.
+	interfaceAddrs func() (map[string][]*net.IPNet, error)
+}
+
+// liveHost is the machine the installer is running on.
+var liveHost = host{
+	root:                    "/",
+	sysBlock:                "/sys/block",
+	devDir:                  "/dev",
+	procCPUInfo:             "/proc/cpuinfo",
+	procCmdline:             "/proc/cmdline",
+	procSwaps:               "/proc/swaps",
+	procTTYDriverSerial:     "/proc/tty/driver/serial",
+	sysClassTTY:             "/sys/class/tty",
+	etcAdjtime:              "/etc/adjtime",
+	systemBusSocket:         "/run/dbus/system_bus_socket",
+	sysBusPCIDevices:        "/sys/bus/pci/devices",
+	sysBusPCIDrivers:        "/sys/bus/pci/drivers",
+	sysBusPlatformDevices:   "/sys/bus/platform/devices",
+	sysKernelIOMMUGroups:    "/sys/kernel/iommu_groups",
+	sysFirmwareDMITables:    "/sys/firmware/dmi/tables/DMI",
+	sysFirmwareEFI:          "/sys/firmware/efi",
+	sysFirmwareACPITables:   "/sys/firmware/acpi/tables",
+	sysClassThermal:         "/sys/class/thermal",
+	sysClassHwmon:           "/sys/class/hwmon",
+	sysClassWatchdog:        "/sys/class/watchdog",
+	devTPMRM:                "/dev/tpmrm0",
+	devTPM:                  "/dev/tpm0",
+	sysClassTPM:             "/sys/class/tpm",
+	sysModuleKVMIntelNested: "/sys/module/kvm_intel/parameters/nested",
+	sysModuleKVMAMDNested:   "/sys/module/kvm_amd/parameters/nested",
+	maximaOverridePath:      defaultMaximaOverridePath,
+	hclOverridePath:         defaultHCLOverridePath,
+	firmwareOverridePath:    defaultFirmwareOverridePath,
+	goarch:                  runtime.GOARCH,
+	now:                     time.Now,
+	sleep:                   sleepContext,
+	onlineCPUs:              schedAffinityCPUs,
+	unameRelease:            uname,
+	hostname:                os.Hostname,
+	dialSystemBus:           helloSystemBus,
+	isBlockDevice:           isBlockDeviceMode,
+	statFS:                  statfsAvailable,
+	interfaceAddrs:          netInterfaceAddrs,
+}
+
+// host returns the machine the checks look at, which is the live one
+// unless the Env was given another.
+func (e *Env) host() *host {
+	if e.machine == nil {
+		return &liveHost
+	}
+	return e.machine
+}
+
+// sleepContext waits for d, or until ctx is done, in which case it
+// returns its error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,123 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// hostnameLookupTimeout is how long the hostname may take to resolve.
+const hostnameLookupTimeout = 3 * time.Second
+
+// HostnameCheck verifies that the hostname can be a Kubernetes node name,
+// i.e. an RFC 1123 label, as otherwise the install breaks at the
+// Kubernetes layer, long after anyone can tell why.  It's the hostname in
+// the install configuration which is checked, if it sets one, or else
+// the live environment's.  An invalid hostname is fatal.  The hostname
+// is then resolved, and its not resolving, or only resolving to loopback
+// addresses, as a broken /etc/hosts makes it, is warned about: other
+// nodes can't reach this one by name, though single-node installs work.
+type HostnameCheck struct {
+	// Hostname is the configured hostname, or empty for the live
+	// environment's.
+	Hostname string
+	Resolver hostResolver
+	Timeout  time.Duration
+}
+
+// NewHostnameCheck returns a HostnameCheck for the hostname in the given
+// install configuration.
+func NewHostnameCheck(cfg *config.HarvesterConfig) HostnameCheck {
+	return HostnameCheck{Hostname: cfg.OS.Hostname, Resolver: net.DefaultResolver, Timeout: hostnameLookupTimeout}
+}
+
+func (c HostnameCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c HostnameCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "Hostname"
+	hostname := c.Hostname
+	if hostname == "" {
+		if hostname, err = env.host().hostname(); err != nil {
+			return
+		}
+	}
+	if violation := hostnameViolation(hostname); violation != "" {
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("The hostname %q is not valid: %s. Kubernetes requires it to be an RFC 1123 label, "+
+			"of at most 63 lowercase letters, digits and hyphens, starting and ending with a letter or digit.", hostname, violation)
+		return
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	addrs, lookupErr := c.Resolver.LookupHost(lookupCtx, hostname)
+	cancel()
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	if lookupErr != nil {
+		var dnsErr *net.DNSError
+		if errors.As(lookupErr, &dnsErr) {
+			lookupErr = errors.New(dnsErr.Err)
+		}
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("The hostname %s cannot be resolved: %v. Other nodes will not be able to reach this one by name, "+
+			"though a single-node install works without it.", hostname, lookupErr)
+		return
+	}
+	loopback := true
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip == nil || !ip.IsLoopback() {
+			loopback = false
+		}
+	}
+	if loopback {
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("The hostname %s only resolves to loopback addresses (%s), which usually means /etc/hosts is broken. "+
+			"Other nodes will not be able to reach this one by name, though a single-node install works without it.",
+			hostname, strings.Join(addrs, ", "))
+		result.Remediation = &Remediation{Hint: "Map the hostname to the node's own address in /etc/hosts, or in DNS."}
+		return
+	}
+	result.Message = fmt.Sprintf("The hostname %s resolves to %s.", hostname, strings.Join(addrs, ", "))
+	return
+}
+
+// hostnameViolation says how hostname breaks the rules for RFC 1123
+// labels, which Kubernetes node names must follow, or returns "" if it
+// doesn't.  localhost is ruled out too, as every node would have it.
+func hostnameViolation(hostname string) string {
+	switch {
+	case hostname == "":
+		return "it is empty"
+	case strings.EqualFold(hostname, "localhost") || strings.HasPrefix(strings.ToLower(hostname), "localhost."):
+		return "localhost is the name of every host"
+	case len(hostname) > 63:
+		return fmt.Sprintf("it is %d characters long", len(hostname))
+	}
+	for _, r := range hostname {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return "it contains uppercase letters"
+		case r == '_':
+			return "it contains underscores"
+		case r == '.':
+			return "it contains dots, but must be a single label rather than a domain name"
+		case !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-'):
+			return fmt.Sprintf("it contains %q", r)
+		}
+	}
+	switch {
+	case hostname[0] == '-':
+		return "it starts with a hyphen"
+	case hostname[len(hostname)-1] == '-':
+		return "it ends with a hyphen"
+	}
+	return ""
+}
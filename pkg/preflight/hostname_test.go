@@ -0,0 +1,89 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostnameViolation(t *testing.T) {
+	tests := []struct {
+		hostname  string
+		violation string
+	}{
+		{"node1", ""},
+		{"harvester-01", ""},
+		{"0node", ""},
+		{strings.Repeat("a", 63), ""},
+		{strings.Repeat("a", 64), "it is 64 characters long"},
+		{"", "it is empty"},
+		{"localhost", "localhost is the name of every host"},
+		{"LocalHost.localdomain", "localhost is the name of every host"},
+		{"Node1", "it contains uppercase letters"},
+		{"node_1", "it contains underscores"},
+		{"node1.example.com", "it contains dots, but must be a single label rather than a domain name"},
+		{"node 1", `it contains ' '`},
+		{"nöde", `it contains 'ö'`},
+		{"-node", "it starts with a hyphen"},
+		{"node-", "it ends with a hyphen"},
+		{"-", "it starts with a hyphen"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.violation, hostnameViolation(test.hostname), test.hostname)
+	}
+}
+
+func TestHostnameCheck(t *testing.T) {
+	h := testHost()
+	h.hostname = func() (string, error) { return "live-node", nil }
+	resolver := fakeResolver{
+		"node1":     {"10.0.0.11", "fe80::1"},
+		"live-node": {"10.0.0.12"},
+		"broken":    {"127.0.1.1", "::1"},
+	}
+
+	tests := []struct {
+		hostname string
+		result   Result
+	}{
+		{
+			hostname: "node1",
+			result:   Result{Name: "Hostname", Message: "The hostname node1 resolves to 10.0.0.11, fe80::1."},
+		},
+		{
+			result: Result{Name: "Hostname", Message: "The hostname live-node resolves to 10.0.0.12."},
+		},
+		{
+			hostname: "Node_1",
+			result: Result{Name: "Hostname", Severity: SeverityFatal,
+				Message: `The hostname "Node_1" is not valid: it contains uppercase letters. Kubernetes requires it to be an RFC 1123 label, ` +
+					"of at most 63 lowercase letters, digits and hyphens, starting and ending with a letter or digit."},
+		},
+		{
+			hostname: "unknown",
+			result: Result{Name: "Hostname", Severity: SeverityWarning,
+				Message: "The hostname unknown cannot be resolved: no such host. Other nodes will not be able to reach this one by name, " +
+					"though a single-node install works without it."},
+		},
+		{
+			hostname: "broken",
+			result: Result{Name: "Hostname", Severity: SeverityWarning,
+				Message: "The hostname broken only resolves to loopback addresses (127.0.1.1, ::1), which usually means /etc/hosts is broken. " +
+					"Other nodes will not be able to reach this one by name, though a single-node install works without it.",
+				Remediation: &Remediation{Hint: "Map the hostname to the node's own address in /etc/hosts, or in DNS."}},
+		},
+	}
+	for _, test := range tests {
+		check := HostnameCheck{Hostname: test.hostname, Resolver: resolver, Timeout: hostnameLookupTimeout}
+		result, err := check.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.hostname)
+		assert.Equal(t, test.result, result, test.hostname)
+	}
+
+	h.hostname = func() (string, error) { return "", errors.New("no hostname") }
+	_, err := HostnameCheck{Resolver: resolver}.Evaluate(context.Background(), &Env{machine: h})
+	assert.EqualError(t, err, "no hostname")
+}
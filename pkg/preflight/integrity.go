@@ -0,0 +1,271 @@
+package preflight
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// ReportSchemaVersion is the version of the persisted report's schema.
+// It goes up when a change would make reports read differently, e.g. by
+// VerifyReport.
+const ReportSchemaVersion = 1
+
+// hashPrefix says which hash Integrity.Hash is.
+const hashPrefix = "sha256:"
+
+// ErrReportModified is returned by VerifyReport if the report doesn't
+// match its hash or signature.
+var ErrReportModified = errors.New("the report has been modified since it was written")
+
+// Integrity is how a persisted report can be shown not to have been
+// edited since it was written.  Hash is that of the report's canonical
+// form, without its Integrity, so it only shows that the report is
+// intact if the file is trusted.  Signature, if the report was signed,
+// is the Ed25519 signature of the canonical form by the key whose public
+// half is PublicKey, which shows it wherever the key is trusted.  Those
+// are base64, and the hash hex.
+type Integrity struct {
+	Hash      string `json:"hash"`
+	Signature string `json:"signature,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// seal returns the report with its SchemaVersion and Integrity, signed
+// with key if it isn't nil.
+func (r Report) seal(key ed25519.PrivateKey) (Report, error) {
+	r.SchemaVersion = ReportSchemaVersion
+	r.Integrity = nil
+	data, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	payload, err := canonicalPayload(data)
+	if err != nil {
+		return r, err
+	}
+	sum := sha256.Sum256(payload)
+	r.Integrity = &Integrity{Hash: hashPrefix + hex.EncodeToString(sum[:])}
+	if key != nil {
+		r.Integrity.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+		r.Integrity.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	}
+	return r, nil
+}
+
+// VerifyReport reads the report persisted at path, and checks that it
+// matches its hash, and, if publicKey isn't nil, that it was signed by
+// its private half.  It returns the report if so, and otherwise an error,
+// which is ErrReportModified if it doesn't match.
+func VerifyReport(path string, publicKey ed25519.PublicKey) (Report, error) {
+	var report Report
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("%s isn't a preflight report: %w", path, err)
+	}
+	switch {
+	case report.SchemaVersion == 0:
+		return report, fmt.Errorf("%s has no schema version, so it was written before reports were hashed", path)
+	case report.SchemaVersion > ReportSchemaVersion:
+		return report, fmt.Errorf("%s has schema version %d, but only versions up to %d are understood",
+			path, report.SchemaVersion, ReportSchemaVersion)
+	case report.Integrity == nil:
+		return report, fmt.Errorf("%s has no integrity hash", path)
+	}
+
+	payload, err := canonicalPayload(data)
+	if err != nil {
+		return report, err
+	}
+	sum := sha256.Sum256(payload)
+	if report.Integrity.Hash != hashPrefix+hex.EncodeToString(sum[:]) {
+		return report, fmt.Errorf("%s doesn't match its hash: %w", path, ErrReportModified)
+	}
+	if publicKey == nil {
+		return report, nil
+	}
+	if report.Integrity.Signature == "" {
+		return report, fmt.Errorf("%s isn't signed", path)
+	}
+	signature, err := base64.StdEncoding.DecodeString(report.Integrity.Signature)
+	if err != nil || !ed25519.Verify(publicKey, payload, signature) {
+		return report, fmt.Errorf("%s wasn't signed with the key given: %w", path, ErrReportModified)
+	}
+	return report, nil
+}
+
+// ParseSigningKey returns the Ed25519 private key encoded as the base64
+// of its seed, or of the whole key.
+func ParseSigningKey(s string) (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid report signing key: %w", err)
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		private := ed25519.PrivateKey(key)
+		// The public half is only taken on trust if it matches
+		if !bytes.Equal(ed25519.NewKeyFromSeed(private.Seed()), private) {
+			return nil, errors.New("invalid report signing key: its public half doesn't match")
+		}
+		return private, nil
+	}
+	return nil, fmt.Errorf("invalid report signing key: %d bytes, expected %d or %d", len(key), ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// ParsePublicKey returns the Ed25519 public key encoded as base64, as
+// Integrity.PublicKey is.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: %d bytes, expected %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+var (
+	sessionKeyOnce sync.Once
+	sessionKey     ed25519.PrivateKey
+	sessionKeyErr  error
+)
+
+// ReportSigningKey returns the key reports are signed with according to
+// cfg: the one it holds, or the live session's, which is generated the
+// first time it's needed, in which case generated is set, so that its
+// public half can be shown.  It returns nil unless signing is enabled.
+func ReportSigningKey(cfg *config.HarvesterConfig) (key ed25519.PrivateKey, generated bool, err error) {
+	signing := cfg.Install.ReportSigning
+	switch {
+	case signing.Key != "":
+		key, err = ParseSigningKey(signing.Key)
+		return key, false, err
+	case !signing.Enabled:
+		return nil, false, nil
+	}
+	sessionKeyOnce.Do(func() {
+		_, sessionKey, sessionKeyErr = ed25519.GenerateKey(rand.Reader)
+		generated = true
+	})
+	return sessionKey, generated, sessionKeyErr
+}
+
+// canonicalPayload returns the canonical form of the report encoded as
+// JSON in data, which is what's hashed and signed: without its
+// integrity, and encoded by canonicalJSON.  It's taken from the JSON,
+// rather than a Report, so that a report written by another version,
+// with fields this one doesn't know, still verifies.
+func canonicalPayload(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var payload map[string]any
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	delete(payload, "integrity")
+	var out bytes.Buffer
+	if err := canonicalJSON(&out, payload); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// integerPattern matches the numbers canonicalJSON keeps as they are.
+var integerPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+
+// canonicalJSON writes v, as decoded from JSON with UseNumber, to out in
+// a form which doesn't depend on how encoding/json happens to encode
+// things: with no whitespace, the keys of objects sorted bytewise, only
+// the characters which must be escaped in strings escaped, in lower case
+// hex, integers as they are and other numbers in exponent form, with the
+// fewest digits which read back as the same float64.
+func canonicalJSON(out *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		out.WriteString("null")
+	case bool:
+		out.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		if integerPattern.MatchString(string(v)) {
+			out.WriteString(string(v))
+			break
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return err
+		}
+		out.WriteString(strconv.FormatFloat(f, 'e', -1, 64))
+	case string:
+		canonicalString(out, v)
+	case []any:
+		out.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := canonicalJSON(out, item); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		out.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			canonicalString(out, key)
+			out.WriteByte(':')
+			if err := canonicalJSON(out, v[key]); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected %T in a report", v)
+	}
+	return nil
+}
+
+// canonicalString writes s to out as a JSON string, as canonicalJSON
+// does.  Any invalid UTF-8 was replaced when the JSON was decoded.
+func canonicalString(out *bytes.Buffer, s string) {
+	out.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r < 0x20:
+			fmt.Fprintf(out, `\u%04x`, r)
+		default:
+			out.WriteRune(r)
+		}
+	}
+	out.WriteByte('"')
+}
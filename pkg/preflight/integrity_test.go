@@ -0,0 +1,278 @@
+package preflight
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// testSigningSeed is the seed of the key reports are signed with in tests.
+var testSigningSeed = bytes.Repeat([]byte{7}, ed25519.SeedSize)
+
+func integrityReport() Report {
+	return Report{
+		Mode:      RunModeInstall,
+		Timestamp: testRunTime,
+		Results: []Result{
+			{Name: "CPU", Facts: map[string]any{"onlineCPUs": 16}, Duration: 1234 * time.Microsecond},
+			{Name: "NetworkSpeed", Severity: SeverityWarning, Message: "Link speed of eth0 is 2.5Gbps <slow> & \"odd\".",
+				Facts: map[string]any{"speedGbps": 2.5}},
+			{Name: "Join", Severity: SeverityFatal, Message: "The cluster cannot be reached.", Overridden: true},
+		},
+	}
+}
+
+// edit rewrites the report at path with edit applied to its text.
+func edit(t *testing.T, path string, edit func(string) string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(path, []byte(edit(string(data))), 0600))
+}
+
+func TestReportSignVerify(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(testSigningSeed)
+	public := key.Public().(ed25519.PublicKey)
+	path := filepath.Join(t.TempDir(), "report.json")
+	report := integrityReport()
+	assert.Nil(t, report.WriteSignedFile(path, key))
+
+	verified, err := VerifyReport(path, public)
+	assert.Nil(t, err)
+	assert.Equal(t, ReportSchemaVersion, verified.SchemaVersion)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(public), verified.Integrity.PublicKey)
+	assert.True(t, strings.HasPrefix(verified.Integrity.Hash, "sha256:"))
+	assert.Equal(t, report.Results[2], verified.Results[2])
+
+	// Signing is deterministic, and doesn't modify the report
+	again := filepath.Join(t.TempDir(), "again.json")
+	assert.Nil(t, report.WriteSignedFile(again, key))
+	first, _ := os.ReadFile(path)
+	second, _ := os.ReadFile(again)
+	assert.Equal(t, string(first), string(second))
+	assert.Nil(t, report.Integrity)
+	assert.Zero(t, report.SchemaVersion)
+
+	// How the JSON is laid out doesn't matter, only what it says
+	var compact bytes.Buffer
+	assert.Nil(t, json.Compact(&compact, first))
+	assert.Nil(t, os.WriteFile(path, compact.Bytes(), 0600))
+	_, err = VerifyReport(path, public)
+	assert.Nil(t, err)
+
+	// Nor does the order of the fields
+	var fields map[string]json.RawMessage
+	assert.Nil(t, json.Unmarshal(first, &fields))
+	var reordered bytes.Buffer
+	reordered.WriteString("{")
+	i := 0
+	for _, name := range []string{"results", "integrity", "timestamp", "schemaVersion", "mode", "airGapped", "production", "destructiveAllowed"} {
+		if raw, ok := fields[name]; ok {
+			if i > 0 {
+				reordered.WriteString(",")
+			}
+			i++
+			reordered.WriteString(`"` + name + `":`)
+			reordered.Write(raw)
+		}
+	}
+	reordered.WriteString("}")
+	assert.Equal(t, len(fields), i)
+	assert.Nil(t, os.WriteFile(path, reordered.Bytes(), 0600))
+	_, err = VerifyReport(path, public)
+	assert.Nil(t, err)
+
+	// It's only verified with the key it was signed with
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{8}, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+	_, err = VerifyReport(path, other)
+	assert.ErrorIs(t, err, ErrReportModified)
+	assert.ErrorContains(t, err, "wasn't signed with the key given")
+}
+
+func TestReportTampered(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(testSigningSeed)
+	public := key.Public().(ed25519.PublicKey)
+	tests := []struct {
+		description string
+		edit        func(string) string
+	}{
+		{"failure overridden", func(s string) string {
+			return strings.Replace(s, `"severity": "fail"`, `"severity": "pass"`, 1)
+		}},
+		{"message reworded", func(s string) string {
+			return strings.Replace(s, "cannot be reached", "can be reached", 1)
+		}},
+		{"number changed", func(s string) string {
+			return strings.Replace(s, `"speedGbps": 2.5`, `"speedGbps": 25`, 1)
+		}},
+		{"field added", func(s string) string {
+			return strings.Replace(s, `"mode": "install"`, `"mode": "install", "reviewed": true`, 1)
+		}},
+		{"result removed", func(s string) string {
+			start := strings.Index(s, `{
+      "name": "Join"`)
+			end := strings.LastIndex(s, "]")
+			return strings.TrimRight(s[:start], ", \n") + "\n  " + s[end:]
+		}},
+		{"signature replaced", func(s string) string {
+			other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{8}, ed25519.SeedSize))
+			signature := base64.StdEncoding.EncodeToString(ed25519.Sign(other, []byte("anything")))
+			start := strings.Index(s, `"signature": "`) + len(`"signature": "`)
+			end := start + strings.Index(s[start:], `"`)
+			return s[:start] + signature + s[end:]
+		}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "report.json")
+		assert.Nil(t, integrityReport().WriteSignedFile(path, key))
+		before, _ := os.ReadFile(path)
+		edit(t, path, tt.edit)
+		after, _ := os.ReadFile(path)
+		assert.NotEqual(t, string(before), string(after), tt.description)
+
+		_, err := VerifyReport(path, public)
+		assert.ErrorIs(t, err, ErrReportModified, tt.description)
+	}
+
+	// The hash is checked even without a key, though it could be
+	// recomputed by whoever edited the report
+	path := filepath.Join(t.TempDir(), "report.json")
+	assert.Nil(t, integrityReport().WriteSignedFile(path, key))
+	edit(t, path, tests[0].edit)
+	_, err := VerifyReport(path, nil)
+	assert.ErrorIs(t, err, ErrReportModified)
+	assert.ErrorContains(t, err, "doesn't match its hash")
+}
+
+func TestReportUnsigned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	assert.Nil(t, integrityReport().WriteFile(path))
+
+	// Without a key, it's hashed but not signed
+	report, err := VerifyReport(path, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, ReportSchemaVersion, report.SchemaVersion)
+	assert.Len(t, report.Integrity.Hash, len("sha256:")+64)
+	assert.Empty(t, report.Integrity.Signature)
+	assert.Empty(t, report.Integrity.PublicKey)
+
+	public := ed25519.NewKeyFromSeed(testSigningSeed).Public().(ed25519.PublicKey)
+	_, err = VerifyReport(path, public)
+	assert.EqualError(t, err, path+" isn't signed")
+
+	edit(t, path, func(s string) string { return strings.Replace(s, "Join", "Joint", 1) })
+	_, err = VerifyReport(path, nil)
+	assert.ErrorIs(t, err, ErrReportModified)
+}
+
+func TestVerifyReportInvalid(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, []byte(data), 0600))
+		return path
+	}
+
+	_, err := VerifyReport(filepath.Join(dir, "missing.json"), nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	path := write("garbage.json", "not json")
+	_, err = VerifyReport(path, nil)
+	assert.ErrorContains(t, err, path+" isn't a preflight report")
+	path = write("old.json", `{"mode": "install", "results": []}`)
+	_, err = VerifyReport(path, nil)
+	assert.EqualError(t, err, path+" has no schema version, so it was written before reports were hashed")
+	path = write("new.json", `{"schemaVersion": 2, "integrity": {"hash": "sha256:00"}}`)
+	_, err = VerifyReport(path, nil)
+	assert.EqualError(t, err, path+" has schema version 2, but only versions up to 1 are understood")
+	path = write("nohash.json", `{"schemaVersion": 1}`)
+	_, err = VerifyReport(path, nil)
+	assert.EqualError(t, err, path+" has no integrity hash")
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{`{"b": 1, "a": [true, false, null], "A": {}}`, `{"A":{},"a":[true,false,null],"b":1}`},
+		{`[0, -7, 12345678901234567890, 2.5, 1e3, 1E3, 1000.0, 0.000001, -1.5e-7]`,
+			`[0,-7,12345678901234567890,2.5e+00,1e+03,1e+03,1e+03,1e-06,-1.5e-07]`},
+		{`"<a & b> \"q\" \\ é   \n \t \u0001 \/"`, "\"<a & b> \\\"q\\\" \\\\ é   \\u000a \\u0009 \\u0001 /\""},
+		{`{"é": 1, "z": 2, "Z": 3}`, `{"Z":3,"z":2,"é":1}`},
+	}
+	for _, tt := range tests {
+		decoder := json.NewDecoder(strings.NewReader(tt.in))
+		decoder.UseNumber()
+		var v any
+		assert.Nil(t, decoder.Decode(&v))
+		var out bytes.Buffer
+		assert.Nil(t, canonicalJSON(&out, v))
+		assert.Equal(t, tt.out, out.String(), tt.in)
+	}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(testSigningSeed)
+	parsed, err := ParseSigningKey(base64.StdEncoding.EncodeToString(testSigningSeed))
+	assert.Nil(t, err)
+	assert.Equal(t, key, parsed)
+	parsed, err = ParseSigningKey(base64.StdEncoding.EncodeToString(key))
+	assert.Nil(t, err)
+	assert.Equal(t, key, parsed)
+
+	mismatched := append(bytes.Clone(key[:ed25519.SeedSize]), make([]byte, ed25519.PublicKeySize)...)
+	_, err = ParseSigningKey(base64.StdEncoding.EncodeToString(mismatched))
+	assert.EqualError(t, err, "invalid report signing key: its public half doesn't match")
+	_, err = ParseSigningKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.EqualError(t, err, "invalid report signing key: 5 bytes, expected 32 or 64")
+	_, err = ParseSigningKey("not base64!")
+	assert.ErrorContains(t, err, "invalid report signing key")
+
+	public, err := ParsePublicKey(base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	assert.Nil(t, err)
+	assert.Equal(t, key.Public(), public)
+	_, err = ParsePublicKey(base64.StdEncoding.EncodeToString(testSigningSeed[:8]))
+	assert.EqualError(t, err, "invalid public key: 8 bytes, expected 32")
+}
+
+func TestReportSigningKey(t *testing.T) {
+	defer func() { sessionKeyOnce, sessionKey, sessionKeyErr = sync.Once{}, nil, nil }()
+
+	// Reports aren't signed unless that's asked for
+	cfg := config.NewHarvesterConfig()
+	key, generated, err := ReportSigningKey(cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+	assert.False(t, generated)
+
+	// The configured key is used, if there is one
+	cfg.Install.ReportSigning.Key = base64.StdEncoding.EncodeToString(testSigningSeed)
+	key, generated, err = ReportSigningKey(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, ed25519.NewKeyFromSeed(testSigningSeed), key)
+	assert.False(t, generated)
+	cfg.Install.ReportSigning.Key = "bogus"
+	_, _, err = ReportSigningKey(cfg)
+	assert.ErrorContains(t, err, "invalid report signing key")
+
+	// Otherwise one is generated for the session, once
+	cfg.Install.ReportSigning = config.ReportSigning{Enabled: true}
+	key, generated, err = ReportSigningKey(cfg)
+	assert.Nil(t, err)
+	assert.Len(t, key, ed25519.PrivateKeySize)
+	assert.True(t, generated)
+	again, generated, err := ReportSigningKey(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, key, again)
+	assert.False(t, generated)
+}
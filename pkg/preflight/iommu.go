@@ -0,0 +1,96 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// iommuACPITables are the ACPI tables describing each kind of IOMMU, with
+// the kernel parameter which enables it, if it isn't enabled by default.
+var iommuACPITables = []struct {
+	table, name, param string
+}{
+	{"DMAR", "VT-d", "intel_iommu=on"},
+	{"IVRS", "AMD-Vi", "amd_iommu=on"},
+	{"IORT", "SMMU", ""},
+}
+
+// iommuOffParams are the kernel parameters which disable the IOMMU.
+var iommuOffParams = []string{"intel_iommu=off", "amd_iommu=off", "iommu=off"}
+
+// IOMMUCheck warns if the IOMMU isn't active, as then no PCI device can
+// be passed through to VMs, which users otherwise only find out when
+// enabling passthrough after installing.  The kernel only sets up IOMMU
+// groups if it's active.  If there are none, the kernel command line and
+// the firmware's ACPI tables say why: the IOMMU is disabled on the
+// command line, or the firmware describes one which the command line
+// doesn't enable, or the firmware doesn't describe one at all, because
+// the platform hasn't one or it's disabled in the firmware settings.
+// Passthrough is optional, so none of these are fatal.
+type IOMMUCheck struct{}
+
+func (c IOMMUCheck) readsFrom() []string {
+	return []string{"Cmdline"}
+}
+
+func (c IOMMUCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "IOMMU"
+	entries, err := env.readDir(env.host().sysKernelIOMMUGroups)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	groups := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			groups++
+		}
+	}
+	if groups > 0 {
+		result.Message = fmt.Sprintf("The IOMMU is enabled, with %d IOMMU groups.", groups)
+		result.Facts = map[string]any{"groups": groups}
+		return result, nil
+	}
+
+	cmdline := env.Inventory.Cmdline
+	if cmdline == "" {
+		var out []byte
+		if out, err = env.readFile(env.host().procCmdline); err != nil {
+			return
+		}
+		cmdline = string(out)
+	}
+	params := strings.Fields(cmdline)
+
+	result.Severity = SeverityWarning
+	for _, off := range iommuOffParams {
+		if slices.Contains(params, off) {
+			result.Message = fmt.Sprintf("The IOMMU is disabled on the kernel command line (%s), so PCI passthrough will not work.", off)
+			result.Remediation = &Remediation{Hint: "Remove " + off + " from the kernel command line."}
+			return result, nil
+		}
+	}
+	for _, iommu := range iommuACPITables {
+		if _, statErr := env.stat(filepath.Join(env.host().sysFirmwareACPITables, iommu.table)); statErr != nil {
+			continue
+		}
+		result.Facts = map[string]any{"iommu": iommu.name}
+		if iommu.param == "" || slices.Contains(params, iommu.param) {
+			break
+		}
+		result.Message = fmt.Sprintf("IOMMU hardware (%s) is present but not enabled on the kernel command line, so PCI passthrough will not work.", iommu.name)
+		result.Remediation = &Remediation{Hint: "Add " + iommu.param + " to the kernel command line."}
+		return result, nil
+	}
+	if result.Facts != nil {
+		result.Message = fmt.Sprintf("IOMMU hardware (%s) is present, but the kernel has not enabled it, so PCI passthrough will not work.", result.Facts["iommu"])
+		return result, nil
+	}
+	result.Message = "No IOMMU support detected, so PCI passthrough will not work. Please enable VT-d or AMD-Vi in the firmware settings, if the platform has it."
+	result.Remediation = &Remediation{Hint: "Enable VT-d or AMD-Vi (it may be called IOMMU or Directed I/O) in the firmware setup."}
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package preflight
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIOMMUCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture string
+		result  Result
+	}{
+		{
+			fixture: "enabled",
+			result:  Result{Message: "The IOMMU is enabled, with 3 IOMMU groups.", Facts: map[string]any{"groups": 3}},
+		},
+		{
+			// The kernel was told to enable it, but found none
+			fixture: "disabled-in-firmware",
+			result: Result{
+				Severity:    SeverityWarning,
+				Message:     "No IOMMU support detected, so PCI passthrough will not work. Please enable VT-d or AMD-Vi in the firmware settings, if the platform has it.",
+				Remediation: &Remediation{Hint: "Enable VT-d or AMD-Vi (it may be called IOMMU or Directed I/O) in the firmware setup."},
+			},
+		},
+		{
+			fixture: "disabled-on-cmdline",
+			result: Result{
+				Severity:    SeverityWarning,
+				Message:     "IOMMU hardware (VT-d) is present but not enabled on the kernel command line, so PCI passthrough will not work.",
+				Facts:       map[string]any{"iommu": "VT-d"},
+				Remediation: &Remediation{Hint: "Add intel_iommu=on to the kernel command line."},
+			},
+		},
+		{
+			fixture: "off-on-cmdline",
+			result: Result{
+				Severity:    SeverityWarning,
+				Message:     "The IOMMU is disabled on the kernel command line (amd_iommu=off), so PCI passthrough will not work.",
+				Remediation: &Remediation{Hint: "Remove amd_iommu=off from the kernel command line."},
+			},
+		},
+	}
+	for _, test := range tests {
+		dir := filepath.Join("testdata/iommu", test.fixture)
+		h.sysKernelIOMMUGroups = filepath.Join(dir, "sys/kernel/iommu_groups")
+		h.procCmdline = filepath.Join(dir, "proc/cmdline")
+		h.sysFirmwareACPITables = filepath.Join(dir, "sys/firmware/acpi/tables")
+		result, err := IOMMUCheck{}.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.fixture)
+		test.result.Name = "IOMMU"
+		assert.Equal(t, test.result, result, test.fixture)
+	}
+
+	// What CmdlineCheck read is used, if it ran first
+	env := &Env{machine: h}
+	env.Inventory.Cmdline = "BOOT_IMAGE=/boot/vmlinuz iommu=off"
+	result, err := IOMMUCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, "The IOMMU is disabled on the kernel command line (iommu=off), so PCI passthrough will not work.", result.Message)
+}
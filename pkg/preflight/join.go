@@ -0,0 +1,258 @@
+package preflight
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const joinTimeout = 10 * time.Second
+
+var (
+	// Tokens are passwords, so they may contain letters, digits and the
+	// OWASP special password characters (as the console allows)
+	joinTokenPattern = regexp.MustCompile("^[a-zA-Z0-9 !\"#$%&'()*+,-./:;<=>?@^_`{|}~[\\]\\\\]+$")
+	// Secure tokens pin the cluster CA, e.g. K10<sha256 of the CA>::node:secret
+	secureJoinTokenPattern = regexp.MustCompile("^K10[0-9a-f]{64}::.+$")
+)
+
+// JoinCheck verifies, when joining an existing cluster, that the server
+// URL in the install configuration is reachable and is actually a
+// cluster's join endpoint, and that the token is well formed.  Otherwise
+// mistakes only show up as rancherd retrying forever after first boot.
+// The server's certificate is verified against the system CAs plus the
+// additional CA from the install configuration, and the configured proxy
+// is used.  Without an additional CA, a self-signed certificate is only
+// warned about, because that's how most clusters are set up.  The token
+// is only checked locally, and never appears in messages.
+type JoinCheck struct {
+	Mode      string
+	ServerURL string
+	Token     string
+	// CACerts are PEM encoded certificates to trust in addition to the
+	// system CAs.
+	CACerts string
+	Proxy   httpproxy.Config
+}
+
+// NewJoinCheck returns a JoinCheck for the given install configuration.
+func NewJoinCheck(cfg *config.HarvesterConfig) JoinCheck {
+	return JoinCheck{
+		Mode:      cfg.Install.Mode,
+		ServerURL: cfg.ServerURL,
+		Token:     cfg.Token,
+		CACerts:   cfg.SystemSettings["additional-ca"],
+		Proxy:     proxyFromConfig(cfg),
+	}
+}
+
+// proxyFromConfig returns the proxy settings the installed system will
+// use.  Proxy settings in the OS environment of the configuration take
+// precedence over the installer's own environment.
+func proxyFromConfig(cfg *config.HarvesterConfig) httpproxy.Config {
+	proxy := *httpproxy.FromEnvironment()
+	for name, value := range cfg.OS.Environment {
+		switch strings.ToUpper(name) {
+		case "HTTP_PROXY":
+			proxy.HTTPProxy = value
+		case "HTTPS_PROXY":
+			proxy.HTTPSProxy = value
+		case "NO_PROXY":
+			proxy.NoProxy = value
+		}
+	}
+	return proxy
+}
+
+func (c JoinCheck) Evaluate(ctx context.Context, _ *Env) (result Result, err error) {
+	result.Name = "Join"
+	if c.Mode != config.ModeJoin {
+		return
+	}
+
+	var problems []string
+	problem := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	defer func() {
+		if len(problems) > 0 {
+			result.Message = strings.Join(problems, " ")
+		}
+	}()
+
+	if reason := joinTokenProblem(c.Token); reason != "" {
+		problem(SeverityFatal, "The token is not valid: %s.", reason)
+	}
+
+	server, reason := parseServerURL(c.ServerURL)
+	if reason != "" {
+		problem(SeverityFatal, "The server URL is not valid: %s.", reason)
+		return
+	}
+
+	roots, ok := rootCAs(c.CACerts)
+	if !ok {
+		problem(SeverityFatal, "The additional CA does not contain any PEM encoded certificates.")
+	}
+	proxyFunc := c.Proxy.ProxyFunc()
+	proxy, err := proxyFunc(server)
+	if err != nil {
+		problem(SeverityFatal, "The proxy setting is not valid: %v.", redactedError(err))
+		return result, nil
+	}
+
+	host := server.Hostname()
+	if proxy == nil && net.ParseIP(host) == nil {
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			problem(SeverityFatal, "Cannot resolve %s: %v.", host, redactedError(err))
+			return result, nil
+		}
+	}
+
+	ping := server.JoinPath("ping")
+	resp, err := c.get(ctx, ping, &tls.Config{RootCAs: roots}, proxyFunc)
+	if certErr := certificateError(err); certErr != nil {
+		if c.CACerts != "" {
+			problem(SeverityFatal, "The TLS certificate of %s is not trusted: %v.", server.Host, certErr)
+		} else {
+			problem(SeverityWarning, "The TLS certificate of %s cannot be verified: %v. "+
+				"Set additional-ca in the system settings to verify it.", server.Host, certErr)
+		}
+		// Carry on, to see whether it's a join endpoint at all
+		resp, err = c.get(ctx, ping, &tls.Config{InsecureSkipVerify: true}, proxyFunc)
+	}
+	if err != nil {
+		problem(SeverityFatal, "Cannot connect to %s: %v.", server.Host, redactedError(err))
+		return result, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	resp.Body.Close()
+	if err != nil {
+		problem(SeverityFatal, "Cannot connect to %s: %v.", server.Host, redactedError(err))
+		return result, nil
+	}
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "pong" {
+		problem(SeverityFatal, "%s does not look like a SaftOS join endpoint: GET /ping returned %s.", server.Host, resp.Status)
+		return result, nil
+	}
+
+	if len(problems) == 0 {
+		result.Message = fmt.Sprintf("%s is reachable and responds as a SaftOS join endpoint.", server.Host)
+	}
+	return result, nil
+}
+
+// get fetches u with the given TLS and proxy settings.
+func (c JoinCheck) get(ctx context.Context, u *url.URL, tlsConfig *tls.Config, proxy func(*url.URL) (*url.URL, error)) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.client(tlsConfig, proxy).Do(req)
+}
+
+// client returns an HTTP client for the join server with the given TLS
+// and proxy settings.
+func (c JoinCheck) client(tlsConfig *tls.Config, proxy func(*url.URL) (*url.URL, error)) *http.Client {
+	return &http.Client{
+		Timeout: joinTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return proxy(req.URL)
+			},
+		},
+		// A join endpoint answers directly
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// parseServerURL parses a server URL the way the console accepts them,
+// i.e. https is assumed if there's no scheme, and 443 if there's no port.
+// Any credentials in the URL are dropped.  If the URL is invalid, the
+// reason is returned instead.
+func parseServerURL(serverURL string) (*url.URL, string) {
+	serverURL = strings.TrimSpace(serverURL)
+	if serverURL == "" {
+		return nil, "it is empty"
+	}
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "https://" + serverURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, "it is not a URL"
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Sprintf("the scheme is %s, but it must be https", u.Scheme)
+	}
+	if strings.Trim(u.Path, "/") != "" {
+		return nil, fmt.Sprintf("it has a path, %s, but it must not", u.Path)
+	}
+	u.User = nil
+	u.Path = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "443")
+	}
+	return u, ""
+}
+
+// joinTokenProblem returns why token isn't a valid join token, or "" if
+// it is.  The reason never includes the token itself.
+func joinTokenProblem(token string) string {
+	switch {
+	case token == "":
+		return "it is empty"
+	case !joinTokenPattern.MatchString(token):
+		return "it must consist of letters, digits and OWASP special password characters"
+	case strings.HasPrefix(token, "K10") && strings.Contains(token, "::") && !secureJoinTokenPattern.MatchString(token):
+		return "it looks like a secure token, but secure tokens are K10, then the 64 digit CA hash, then ::, then the credentials"
+	}
+	return ""
+}
+
+// certificateError returns the certificate verification error behind err,
+// or nil if it isn't one.
+func certificateError(err error) error {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return unknownAuthority
+	case errors.As(err, &invalid):
+		return invalid
+	case errors.As(err, &hostname):
+		return hostname
+	}
+	return nil
+}
+
+// redactedError returns the innermost cause of a URL error, so that the
+// URL, and any credentials in it, aren't repeated in the message.
+func redactedError(err error) error {
+	var urlErr *url.Error
+	for errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// KdumpCheck reports whether memory is reserved for a crash kernel, which
+// kdump needs in order to capture crash dumps.  The crashkernel= kernel
+// parameter requests the reservation, and /sys/kernel/kexec_crash_size
+// says how much the kernel actually got, which is recorded in the
+// inventory so that MemoryCheck doesn't count it.  Support wants crash
+// dumps from production hosts, so having no reservation is warned about
+// in production mode.  Kernels without kexec support are skipped.
+type KdumpCheck struct{}
+
+func (c KdumpCheck) readsFrom() []string {
+	return []string{"Cmdline"}
+}
+
+func (c KdumpCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Kdump"
+	out, err := env.readFile(filepath.Join(env.host().root, "sys/kernel/kexec_crash_size"))
+	if errors.Is(err, fs.ErrNotExist) {
+		result.Message = "Skipped: the kernel does not support kexec."
+		return result, nil
+	} else if err != nil {
+		return
+	}
+	reserved, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return
+	}
+	env.Inventory.CrashKernelBytes = reserved
+
+	cmdline := env.Inventory.Cmdline
+	if cmdline == "" {
+		out, err = env.readFile(env.host().procCmdline)
+		if err != nil {
+			return
+		}
+		cmdline = string(out)
+	}
+	var param string
+	for _, p := range strings.Fields(cmdline) {
+		if strings.HasPrefix(p, "crashkernel=") {
+			param = p
+		}
+	}
+
+	switch {
+	case reserved > 0:
+		result.Severity = SeverityInfo
+		result.Message = fmt.Sprintf("Kdump can capture crash dumps: %s is reserved for the crash kernel.", formatBytes(reserved))
+		if param != "" {
+			result.Message = fmt.Sprintf("Kdump can capture crash dumps: %s is reserved for the crash kernel by %s.", formatBytes(reserved), param)
+		}
+	case param != "":
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("The kernel command line has %s, but no memory could be reserved for the crash kernel, so kdump cannot capture crash dumps.", param)
+	default:
+		result.Severity = SeverityInfo
+		if env.Options.Production {
+			result.Severity = SeverityWarning
+		}
+		result.Message = "No memory is reserved for the crash kernel, so kdump cannot capture crash dumps. " +
+			"Add crashkernel= to the kernel command line to enable it."
+	}
+	return
+}
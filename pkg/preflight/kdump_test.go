@@ -0,0 +1,58 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKdumpCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture    string
+		production bool
+		severity   Severity
+		message    string
+		reserved   uint64
+	}{
+		{
+			fixture:  "reserved",
+			severity: SeverityInfo,
+			message:  "Kdump can capture crash dumps: 512MiB is reserved for the crash kernel by crashkernel=512M.",
+			reserved: 512 << 20,
+		},
+		{
+			fixture:  "not-reserved",
+			severity: SeverityInfo,
+			message:  "No memory is reserved for the crash kernel, so kdump cannot capture crash dumps. Add crashkernel= to the kernel command line to enable it.",
+		},
+		{
+			fixture:    "not-reserved",
+			production: true,
+			severity:   SeverityWarning,
+			message:    "No memory is reserved for the crash kernel, so kdump cannot capture crash dumps. Add crashkernel= to the kernel command line to enable it.",
+		},
+		{
+			fixture:  "failed",
+			severity: SeverityWarning,
+			message:  "The kernel command line has crashkernel=64G, but no memory could be reserved for the crash kernel, so kdump cannot capture crash dumps.",
+		},
+		{
+			fixture:    "no-kexec",
+			production: true,
+			message:    "Skipped: the kernel does not support kexec.",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/kdump/" + test.fixture
+		h.procCmdline = h.root + "/proc/cmdline"
+		env := &Env{Options: Options{Production: test.production}, machine: h}
+		result, err := KdumpCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "Kdump", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Equal(t, test.reserved, env.Inventory.CrashKernelBytes, test.fixture)
+	}
+}
@@ -0,0 +1,116 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// uname returns the running kernel's release (e.g.
+// "5.14.21-150500.55.39-default") and machine (e.g. "x86_64").
+func uname() (release, machine string, err error) {
+	var uts unix.Utsname
+	if err = unix.Uname(&uts); err != nil {
+		return
+	}
+	return unix.ByteSliceToString(uts.Release[:]), unix.ByteSliceToString(uts.Machine[:]), nil
+}
+
+// A KernelMinimum gives the kernel versions we support, as "major.minor".
+type KernelMinimum struct {
+	// Minimum is the oldest supported version.
+	Minimum string
+	// WarnBelow is the version below which support will be dropped
+	// soon.  Versions from Minimum up to WarnBelow get a warning.  It
+	// may be empty.
+	WarnBelow string
+}
+
+// DefaultKernelMinimum is the KernelMinimum used when KernelVersionCheck
+// doesn't have one.
+var DefaultKernelMinimum = KernelMinimum{Minimum: "5.14"}
+
+// KernelVersionCheck makes sure the live environment's kernel isn't too
+// old, which can happen with downstream respins of the installer image.
+type KernelVersionCheck struct {
+	KernelMinimum
+	// PerArch overrides KernelMinimum for specific architectures, keyed
+	// by uname machine, e.g. "aarch64".
+	PerArch map[string]KernelMinimum
+}
+
+func (c KernelVersionCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "KernelVersion"
+
+	release, machine, err := env.host().unameRelease()
+	if err != nil {
+		return
+	}
+	minimum := c.KernelMinimum
+	if perArch, ok := c.PerArch[machine]; ok {
+		minimum = perArch
+	}
+	if minimum.Minimum == "" {
+		minimum = DefaultKernelMinimum
+	}
+
+	version, err := parseKernelVersion(release)
+	if err != nil {
+		return
+	}
+	required, err := parseKernelVersion(minimum.Minimum)
+	if err != nil {
+		return
+	}
+	if version.less(required) {
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("Kernel %s is older than the minimum supported version %s. Please use an installer image with a newer kernel.",
+			release, minimum.Minimum)
+		return
+	}
+	if minimum.WarnBelow != "" {
+		var warnBelow kernelVersion
+		if warnBelow, err = parseKernelVersion(minimum.WarnBelow); err != nil {
+			return
+		}
+		if version.less(warnBelow) {
+			result.Severity = SeverityWarning
+			result.Message = fmt.Sprintf("Kernel %s is older than %s, and support for it will be dropped soon.", release, minimum.WarnBelow)
+		}
+	}
+	return
+}
+
+type kernelVersion struct {
+	Major, Minor, Patch int
+}
+
+// less compares major and minor versions only, because that's all the
+// minimums are given in.
+func (v kernelVersion) less(other kernelVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+var kernelVersionRegexp = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseKernelVersion gets the version numbers from a kernel release,
+// ignoring any vendor suffix or rc tag, e.g. 5.14.21 from
+// "5.14.21-150500.55.39-default" or 6.10.0 from "6.10.0-rc3".
+func parseKernelVersion(release string) (v kernelVersion, err error) {
+	match := kernelVersionRegexp.FindStringSubmatch(release)
+	if match == nil {
+		return v, parseErrorf("unable to parse kernel version %q", release)
+	}
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		v.Patch, _ = strconv.Atoi(match[3])
+	}
+	return v, nil
+}
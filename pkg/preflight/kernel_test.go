@@ -0,0 +1,68 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKernelVersion(t *testing.T) {
+	tests := map[string]kernelVersion{
+		"6.8.12":                       {6, 8, 12},
+		"5.14":                         {5, 14, 0},
+		"5.14.21-150500.55.39-default": {5, 14, 21},
+		"6.4.0-150600.23.25-default":   {6, 4, 0},
+		"6.1.0-18-amd64":               {6, 1, 0},
+		"6.10.0-rc3":                   {6, 10, 0},
+		"6.11-rc1+":                    {6, 11, 0},
+	}
+	for release, expected := range tests {
+		version, err := parseKernelVersion(release)
+		assert.Nil(t, err, release)
+		assert.Equal(t, expected, version, release)
+	}
+
+	for _, release := range []string{"", "linux", "6"} {
+		_, err := parseKernelVersion(release)
+		assert.NotNil(t, err, release)
+	}
+}
+
+func TestKernelVersionCheck(t *testing.T) {
+	h := testHost()
+
+	check := KernelVersionCheck{
+		KernelMinimum: KernelMinimum{Minimum: "5.14", WarnBelow: "6.4"},
+		PerArch: map[string]KernelMinimum{
+			"aarch64": {Minimum: "6.4"},
+		},
+	}
+	tests := []struct {
+		release  string
+		machine  string
+		check    KernelVersionCheck
+		severity Severity
+		message  string
+	}{
+		{"6.4.0-150600.23.25-default", "x86_64", check, SeverityOK, ""},
+		{"6.10.0-rc3", "x86_64", check, SeverityOK, ""},
+		{"5.14.21-150500.55.39-default", "x86_64", check, SeverityWarning,
+			"Kernel 5.14.21-150500.55.39-default is older than 6.4, and support for it will be dropped soon."},
+		{"5.3.18-150300.59.87-default", "x86_64", check, SeverityFatal,
+			"Kernel 5.3.18-150300.59.87-default is older than the minimum supported version 5.14. Please use an installer image with a newer kernel."},
+		{"5.14.21-150500.55.39-default", "aarch64", check, SeverityFatal,
+			"Kernel 5.14.21-150500.55.39-default is older than the minimum supported version 6.4. Please use an installer image with a newer kernel."},
+		{"5.14.21-150500.55.39-default", "x86_64", KernelVersionCheck{}, SeverityOK, ""},
+		{"4.12.14-122.37-default", "x86_64", KernelVersionCheck{}, SeverityFatal,
+			"Kernel 4.12.14-122.37-default is older than the minimum supported version 5.14. Please use an installer image with a newer kernel."},
+	}
+
+	for _, test := range tests {
+		h.unameRelease = func() (string, string, error) { return test.release, test.machine, nil }
+		result, err := test.check.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.release)
+		assert.Equal(t, Result{Name: "KernelVersion", Severity: test.severity, Message: test.message}, result,
+			test.release+" "+test.machine)
+	}
+}
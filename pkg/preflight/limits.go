@@ -0,0 +1,94 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A LimitFloor is the minimum recommended value of a sysctl (named as for
+// sysctl(8), e.g. "fs.file-max") or systemd manager setting (e.g.
+// "DefaultLimitNOFILE").
+type LimitFloor struct {
+	Name  string
+	Floor uint64
+}
+
+// DefaultLimitFloors are the limits a virtualization node needs, given
+// the number of VMs, volumes and watches it has to deal with.
+var DefaultLimitFloors = []LimitFloor{
+	{"fs.file-max", 1048576},
+	{"fs.nr_open", 1048576},
+	{"fs.inotify.max_user_watches", 524288},
+	{"fs.inotify.max_user_instances", 8192},
+	{"DefaultLimitNOFILE", 524288},
+}
+
+// LimitsCheck warns about file descriptor and inotify limits which are
+// too low, because they lead to sporadic "too many open files" errors
+// long after installation.  Limits which can't be read are ignored.
+type LimitsCheck struct {
+	// Floors overrides DefaultLimitFloors, if set.
+	Floors []LimitFloor
+}
+
+// probes are the systemd manager settings among the floors; sysctls are
+// read from /proc/sys.
+func (c LimitsCheck) probes(env *Env) []toolCall {
+	floors := c.Floors
+	if floors == nil {
+		floors = DefaultLimitFloors
+	}
+	var probes []toolCall
+	for _, floor := range floors {
+		if !strings.Contains(floor.Name, ".") {
+			probes = append(probes, toolCall{"/usr/bin/systemctl", "show", "--property", floor.Name})
+		}
+	}
+	return probes
+}
+
+func (c LimitsCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Limits"
+	floors := c.Floors
+	if floors == nil {
+		floors = DefaultLimitFloors
+	}
+
+	var low []string
+	for _, floor := range floors {
+		value, ok := readLimit(env, floor.Name)
+		if ok && value < floor.Floor {
+			low = append(low, fmt.Sprintf("%s is %d (recommended at least %d)", floor.Name, value, floor.Floor))
+		}
+	}
+	if len(low) > 0 {
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("Some limits are below the recommended values: %s. "+
+			"These can cause \"too many open files\" errors under load.", strings.Join(low, ", "))
+	}
+	return
+}
+
+// readLimit reads a sysctl from /proc/sys, or if the name doesn't have a
+// dot, a systemd manager setting.
+func readLimit(env *Env, name string) (uint64, bool) {
+	var value string
+	if strings.Contains(name, ".") {
+		value = env.readTrimmed(filepath.Join(env.host().root, "proc/sys", strings.ReplaceAll(name, ".", "/")))
+	} else {
+		out, err := env.output("/usr/bin/systemctl", "show", "--property", name)
+		if err != nil {
+			return 0, false
+		}
+		_, value, _ = strings.Cut(strings.TrimSpace(string(out)), "=")
+	}
+	if value == "infinity" {
+		return math.MaxUint64, true
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,68 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		name      string
+		fixture   string
+		systemctl string
+		floors    []LimitFloor
+		result    Result
+	}{
+		{
+			name:      "compliant",
+			fixture:   "compliant",
+			systemctl: "systemctl-nofile-high",
+			result:    Result{Name: "Limits"},
+		},
+		{
+			name:      "compliant infinity",
+			fixture:   "compliant",
+			systemctl: "systemctl-nofile-inf",
+			result:    Result{Name: "Limits"},
+		},
+		{
+			name:      "low",
+			fixture:   "low",
+			systemctl: "systemctl-nofile-low",
+			result: Result{
+				Name:     "Limits",
+				Severity: SeverityWarning,
+				Message: "Some limits are below the recommended values: " +
+					"fs.file-max is 809194 (recommended at least 1048576), " +
+					"fs.inotify.max_user_watches is 8192 (recommended at least 524288), " +
+					"fs.inotify.max_user_instances is 128 (recommended at least 8192), " +
+					"DefaultLimitNOFILE is 4096 (recommended at least 524288). " +
+					"These can cause \"too many open files\" errors under load.",
+			},
+		},
+		{
+			name:      "low without systemd",
+			fixture:   "low",
+			systemctl: "systemctl-fail",
+			floors:    []LimitFloor{{"fs.inotify.max_user_instances", 128}, {"fs.nr_open", 2097152}, {"DefaultLimitNOFILE", 1024}},
+			result: Result{
+				Name:     "Limits",
+				Severity: SeverityWarning,
+				Message: "Some limits are below the recommended values: fs.nr_open is 1048576 (recommended at least 2097152). " +
+					"These can cause \"too many open files\" errors under load.",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/limits/" + test.fixture
+		env := &Env{execCommand: fakeCommand(test.systemctl), machine: h}
+		result, err := LimitsCheck{Floors: test.floors}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.result, result, test.name)
+	}
+}
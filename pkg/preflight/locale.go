@@ -0,0 +1,199 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// LocaleCheck validates the timezone, locale and keymap in the install
+// configuration, because a typo in any of them only shows up as a
+// systemd-firstboot failure late in the first boot.  They're checked
+// against what's available in the live environment, which runs the same
+// OS image as the target.  Invalid values are fatal, with a suggestion
+// if there's something close.  If nothing's configured, the check passes
+// silently.
+type LocaleCheck struct {
+	Timezone string
+	Locale   string
+	Keymap   string
+}
+
+// NewLocaleCheck returns a LocaleCheck for the settings in the given
+// install configuration.
+func NewLocaleCheck(cfg *config.HarvesterConfig) LocaleCheck {
+	return LocaleCheck{Timezone: cfg.OS.Timezone, Locale: cfg.OS.Locale, Keymap: cfg.OS.Keymap}
+}
+
+func (c LocaleCheck) probes(env *Env) []toolCall {
+	if c.Locale == "" {
+		return nil
+	}
+	return []toolCall{{"/usr/bin/locale", "-a"}}
+}
+
+func (c LocaleCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Locale"
+
+	var msgs []string
+	if c.Timezone != "" {
+		var zones []string
+		if zones, err = listTimezones(env); err != nil {
+			return
+		}
+		msgs = append(msgs, validateSetting("Timezone", c.Timezone, zones, c.Timezone)...)
+	}
+	if c.Locale != "" {
+		var locales []string
+		if locales, err = listLocales(env); err != nil {
+			return
+		}
+		msgs = append(msgs, validateSetting("Locale", c.Locale, locales, normalizeLocale(c.Locale))...)
+	}
+	if c.Keymap != "" {
+		var keymaps []string
+		if keymaps, err = listKeymaps(env); err != nil {
+			return
+		}
+		msgs = append(msgs, validateSetting("Keymap", c.Keymap, keymaps, c.Keymap)...)
+	}
+	if len(msgs) > 0 {
+		result.Severity = SeverityFatal
+		result.Message = strings.Join(msgs, " ")
+	}
+	return
+}
+
+// validateSetting returns a message if key (the normalized form of value)
+// isn't one of the available values, suggesting the closest one.
+func validateSetting(what, value string, available []string, key string) []string {
+	// With nothing to compare against, there's no telling
+	if len(available) == 0 || slices.Contains(available, key) {
+		return nil
+	}
+	msg := fmt.Sprintf("%s %s is not available.", what, value)
+	if match := closestMatch(key, available); match != "" {
+		msg += fmt.Sprintf(" Did you mean %s?", match)
+	}
+	return []string{msg}
+}
+
+// listTimezones returns the names of the zones in the zoneinfo database,
+// e.g. "Europe/Berlin".
+func listTimezones(env *Env) ([]string, error) {
+	dir := filepath.Join(env.host().root, "usr/share/zoneinfo")
+	var zones []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+		if d.IsDir() {
+			// The posix and right trees duplicate everything
+			if name == "posix" || name == "right" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Zone names are capitalized, unlike the data files in there
+		if name[0] >= 'A' && name[0] <= 'Z' && !strings.Contains(name, ".") {
+			zones = append(zones, name)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return zones, err
+}
+
+// listLocales returns the locales from locale -a, normalized.
+func listLocales(env *Env) ([]string, error) {
+	out, err := env.output("/usr/bin/locale", "-a")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list locales: %w", err)
+	}
+	var locales []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			locales = append(locales, normalizeLocale(line))
+		}
+	}
+	return locales, nil
+}
+
+// normalizeLocale normalizes the codeset of a locale name the way glibc
+// does, e.g. "en_US.UTF-8" to "en_US.utf8".
+func normalizeLocale(locale string) string {
+	name, codeset, ok := strings.Cut(locale, ".")
+	if !ok {
+		return locale
+	}
+	codeset, modifier, _ := strings.Cut(codeset, "@")
+	codeset = strings.ToLower(strings.ReplaceAll(codeset, "-", ""))
+	if modifier != "" {
+		codeset += "@" + modifier
+	}
+	return name + "." + codeset
+}
+
+// listKeymaps returns the names of the console keymaps, e.g. "de-latin1".
+func listKeymaps(env *Env) ([]string, error) {
+	var keymaps []string
+	err := filepath.WalkDir(filepath.Join(env.host().root, "usr/share/kbd/keymaps"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		for _, ext := range []string{".map.gz", ".map"} {
+			if !d.IsDir() && strings.HasSuffix(name, ext) {
+				keymaps = append(keymaps, strings.TrimSuffix(name, ext))
+				break
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return keymaps, err
+}
+
+// closestMatch returns the candidate with the smallest edit distance from
+// value, or "" if none of them is close enough to be a likely typo.
+func closestMatch(value string, candidates []string) string {
+	best, bestDistance := "", len(value)/3+1
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(value), strings.ToLower(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,76 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestLocaleCheck(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/locale/host"
+
+	tests := []struct {
+		name     string
+		os       config.OS
+		severity Severity
+		message  string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "valid",
+			os:   config.OS{Timezone: "Europe/Berlin", Locale: "en_US.UTF-8", Keymap: "de-latin1"},
+		},
+		{
+			name: "utc",
+			os:   config.OS{Timezone: "UTC", Locale: "C.utf8", Keymap: "us"},
+		},
+		{
+			name:     "typos",
+			os:       config.OS{Timezone: "Europe/Berln", Locale: "en_UK.UTF-8", Keymap: "de-latin"},
+			severity: SeverityFatal,
+			message: "Timezone Europe/Berln is not available. Did you mean Europe/Berlin? " +
+				"Locale en_UK.UTF-8 is not available. Did you mean en_US.utf8? " +
+				"Keymap de-latin is not available. Did you mean de-latin1?",
+		},
+		{
+			name:     "nonsense",
+			os:       config.OS{Timezone: "Mars/Olympus_Mons"},
+			severity: SeverityFatal,
+			message:  "Timezone Mars/Olympus_Mons is not available.",
+		},
+		{
+			name:     "posix tree",
+			os:       config.OS{Timezone: "posix/Europe/Berlin"},
+			severity: SeverityFatal,
+			message:  "Timezone posix/Europe/Berlin is not available. Did you mean Europe/Berlin?",
+		},
+	}
+
+	for _, test := range tests {
+		command := func(_ string, _ ...string) *exec.Cmd {
+			if test.os.Locale == "" {
+				t.Errorf("%s: unexpected command", test.name)
+			}
+			return fakeExecCommand("locale-a")
+		}
+		cfg := config.NewHarvesterConfig()
+		cfg.OS = test.os
+		result, err := NewLocaleCheck(cfg).Evaluate(context.Background(), &Env{execCommand: command, machine: h})
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "Locale", Severity: test.severity, Message: test.message}, result, test.name)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("Europe/Berlin", "Europe/Berlin"))
+	assert.Equal(t, 1, editDistance("Europe/Berln", "Europe/Berlin"))
+	assert.Equal(t, 2, editDistance("de-latin", "de-latin10"))
+	assert.Equal(t, 3, editDistance("", "abc"))
+}
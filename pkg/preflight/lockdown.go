@@ -0,0 +1,49 @@
+package preflight
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+)
+
+// readLockdownMode returns the selected kernel lockdown mode, which is
+// the one in brackets in /sys/kernel/security/lockdown, e.g. "integrity"
+// from "none [integrity] confidentiality".  It returns "" if the kernel
+// doesn't support lockdown.
+func readLockdownMode(env *Env) string {
+	for _, mode := range strings.Fields(env.readTrimmed(filepath.Join(env.host().root, "sys/kernel/security/lockdown"))) {
+		if strings.HasPrefix(mode, "[") && strings.HasSuffix(mode, "]") {
+			return strings.Trim(mode, "[]")
+		}
+	}
+	return ""
+}
+
+// lockdownActive returns whether kernel lockdown is in effect.
+func lockdownActive(env *Env) bool {
+	mode := readLockdownMode(env)
+	return mode != "" && mode != "none"
+}
+
+// LockdownCheck reports the kernel lockdown mode, which is recorded in the
+// inventory.  Integrity mode (which Secure Boot usually triggers) is
+// fine, but confidentiality mode also blocks things SaftOS uses, so it's
+// warned about.  Kernels without lockdown support pass silently.
+type LockdownCheck struct{}
+
+func (c LockdownCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Lockdown"
+	mode := readLockdownMode(env)
+	env.Inventory.LockdownMode = mode
+	switch mode {
+	case "integrity":
+		result.Severity = SeverityInfo
+		result.Message = "Kernel lockdown is in integrity mode, so unsigned kernel modules and kexec images cannot be loaded."
+	case "confidentiality":
+		result.Severity = SeverityWarning
+		result.Message = "Kernel lockdown is in confidentiality mode, which limits SaftOS features: " +
+			"BPF-based network observability cannot read kernel memory, hardware monitoring via MSRs is unavailable, " +
+			"and kexec-based recovery is restricted to signed images. Boot with lockdown=integrity unless this is required."
+	}
+	return
+}
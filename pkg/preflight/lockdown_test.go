@@ -0,0 +1,45 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockdownCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture  string
+		mode     string
+		severity Severity
+		message  string
+	}{
+		{fixture: "unsupported"},
+		{fixture: "none", mode: "none"},
+		{
+			fixture:  "integrity",
+			mode:     "integrity",
+			severity: SeverityInfo,
+			message:  "Kernel lockdown is in integrity mode, so unsigned kernel modules and kexec images cannot be loaded.",
+		},
+		{
+			fixture:  "confidentiality",
+			mode:     "confidentiality",
+			severity: SeverityWarning,
+			message: "Kernel lockdown is in confidentiality mode, which limits SaftOS features: " +
+				"BPF-based network observability cannot read kernel memory, hardware monitoring via MSRs is unavailable, " +
+				"and kexec-based recovery is restricted to signed images. Boot with lockdown=integrity unless this is required.",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/lockdown/" + test.fixture
+		env := &Env{machine: h}
+		result, err := LockdownCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "Lockdown", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Equal(t, test.mode, env.Inventory.LockdownMode, test.fixture)
+	}
+}
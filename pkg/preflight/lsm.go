@@ -0,0 +1,98 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// LSMPolicy says what SELinux mode the site requires.
+type LSMPolicy string
+
+const (
+	// LSMNoPreference means any mode is acceptable
+	LSMNoPreference LSMPolicy = ""
+	// LSMRequireEnforcing means SELinux must be enforcing
+	LSMRequireEnforcing LSMPolicy = "require-enforcing"
+	// LSMRequirePermissiveOrOff means SELinux must not be enforcing
+	LSMRequirePermissiveOrOff LSMPolicy = "require-permissive-or-off"
+)
+
+// ParseLSMPolicy validates a policy given by the user.
+func ParseLSMPolicy(s string) (LSMPolicy, error) {
+	switch policy := LSMPolicy(s); policy {
+	case LSMNoPreference, LSMRequireEnforcing, LSMRequirePermissiveOrOff:
+		return policy, nil
+	}
+	return LSMNoPreference, fmt.Errorf("unknown LSM policy %q, expected %q or %q", s, LSMRequireEnforcing, LSMRequirePermissiveOrOff)
+}
+
+// LSMCheck reports the active Linux Security Modules, the SELinux mode
+// and whether AppArmor is enabled, and compares the SELinux mode with the
+// policy in the Options, if any.  Without a policy, the result is purely
+// informational.
+type LSMCheck struct{}
+
+func (c LSMCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "LSM"
+
+	// securityfs may not be mounted, in which case we have to go by what
+	// the individual LSMs expose
+	var lsms []string
+	out, err := env.readFile(filepath.Join(env.host().root, "sys/kernel/security/lsm"))
+	if err == nil {
+		lsms = strings.Split(strings.TrimSpace(string(out)), ",")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	err = nil
+
+	selinux := selinuxMode(env)
+	apparmor := env.readTrimmed(filepath.Join(env.host().root, "sys/module/apparmor/parameters/enabled")) == "Y"
+
+	var msgs []string
+	if len(lsms) > 0 {
+		msgs = append(msgs, fmt.Sprintf("Active LSMs: %s.", strings.Join(lsms, ", ")))
+	}
+	appArmorState := "disabled"
+	if apparmor {
+		appArmorState = "enabled"
+	}
+	msgs = append(msgs, fmt.Sprintf("SELinux is %s, AppArmor is %s.", selinux, appArmorState))
+
+	switch env.Options.LSMPolicy {
+	case LSMRequireEnforcing:
+		if selinux != "enforcing" {
+			result.Severity = SeverityWarning
+			msgs = append(msgs, "This site requires SELinux to be enforcing.")
+		}
+	case LSMRequirePermissiveOrOff:
+		if selinux == "enforcing" {
+			result.Severity = SeverityFatal
+			msgs = append(msgs, "This site requires SELinux to be permissive or disabled, please boot with enforcing=0 or selinux=0.")
+		}
+	}
+	result.Message = strings.Join(msgs, " ")
+	return
+}
+
+// selinuxMode returns "enforcing", "permissive" or "disabled".  selinuxfs
+// is normally mounted whenever SELinux is enabled, but if it isn't, we
+// try getenforce.
+func selinuxMode(env *Env) string {
+	enforce, err := env.readFile(filepath.Join(env.host().root, "sys/fs/selinux/enforce"))
+	if err == nil {
+		if strings.TrimSpace(string(enforce)) == "1" {
+			return "enforcing"
+		}
+		return "permissive"
+	}
+	out, err := env.output("/usr/sbin/getenforce")
+	if err != nil {
+		return "disabled"
+	}
+	return strings.ToLower(strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,66 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLSMCheck(t *testing.T) {
+	h := testHost()
+
+	const (
+		enforcing      = "Active LSMs: lockdown, capability, yama, selinux, bpf. SELinux is enforcing, AppArmor is disabled."
+		permissive     = "Active LSMs: lockdown, capability, yama, selinux, bpf. SELinux is permissive, AppArmor is disabled."
+		disabled       = "Active LSMs: lockdown, capability, yama, bpf. SELinux is disabled, AppArmor is disabled."
+		apparmor       = "Active LSMs: lockdown, capability, yama, apparmor, bpf. SELinux is disabled, AppArmor is enabled."
+		notEnforcing   = " This site requires SELinux to be enforcing."
+		mustNotEnforce = " This site requires SELinux to be permissive or disabled, please boot with enforcing=0 or selinux=0."
+	)
+
+	tests := []struct {
+		fixture    string
+		getenforce string
+		policy     LSMPolicy
+		severity   Severity
+		message    string
+	}{
+		{"enforcing", "", LSMNoPreference, SeverityOK, enforcing},
+		{"enforcing", "", LSMRequireEnforcing, SeverityOK, enforcing},
+		{"enforcing", "", LSMRequirePermissiveOrOff, SeverityFatal, enforcing + mustNotEnforce},
+		{"permissive", "", LSMNoPreference, SeverityOK, permissive},
+		{"permissive", "", LSMRequireEnforcing, SeverityWarning, permissive + notEnforcing},
+		{"permissive", "", LSMRequirePermissiveOrOff, SeverityOK, permissive},
+		{"disabled", "", LSMNoPreference, SeverityOK, disabled},
+		{"disabled", "", LSMRequireEnforcing, SeverityWarning, disabled + notEnforcing},
+		{"disabled", "", LSMRequirePermissiveOrOff, SeverityOK, disabled},
+		{"apparmor", "", LSMNoPreference, SeverityOK, apparmor},
+		{"apparmor", "", LSMRequireEnforcing, SeverityWarning, apparmor + notEnforcing},
+		{"apparmor", "", LSMRequirePermissiveOrOff, SeverityOK, apparmor},
+		{"no-securityfs", "getenforce-enforcing", LSMRequirePermissiveOrOff, SeverityFatal,
+			"SELinux is enforcing, AppArmor is disabled." + mustNotEnforce},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/lsm/" + test.fixture
+		getenforce := test.getenforce
+		if getenforce == "" {
+			getenforce = "getenforce-missing"
+		}
+		env := &Env{Options: Options{LSMPolicy: test.policy}, execCommand: fakeCommand(getenforce), machine: h}
+		result, err := LSMCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "LSM", Severity: test.severity, Message: test.message}, result,
+			test.fixture+" "+string(test.policy))
+	}
+}
+
+func TestParseLSMPolicy(t *testing.T) {
+	policy, err := ParseLSMPolicy("require-enforcing")
+	assert.Nil(t, err)
+	assert.Equal(t, LSMRequireEnforcing, policy)
+
+	_, err = ParseLSMPolicy("enforcing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,110 @@
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// machineIDUninitialized is what images built for systemd-firstboot have
+// in /etc/machine-id, until the first boot generates a real one
+const machineIDUninitialized = "uninitialized"
+
+// validMachineID returns true if id is 32 lowercase hex digits, and not
+// all zeros (which some image builders use as a placeholder).
+func validMachineID(id string) bool {
+	if len(id) != 32 || id == strings.Repeat("0", 32) {
+		return false
+	}
+	return strings.Trim(id, "0123456789abcdef") == ""
+}
+
+// readFleetInventory reads a fleet inventory file, which has a machine ID
+// per line, optionally followed by the host it belongs to.  Blank lines
+// and lines starting with # are ignored.  It returns the hosts by ID.
+func readFleetInventory(env *Env, path string) (map[string]string, error) {
+	data, err := env.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fleet := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, host, _ := strings.Cut(line, " ")
+		fleet[id] = strings.TrimSpace(host)
+	}
+	return fleet, scanner.Err()
+}
+
+// MachineIDCheck verifies that /etc/machine-id is well-formed, and
+// consistent with D-Bus's copy in /var/lib/dbus/machine-id.  Images
+// cloned with a baked-in machine ID give duplicate identifiers which
+// confuse journald aggregation and licensing tools, so if the options
+// list known clone sources (KnownMachineIDs, or a FleetInventory file),
+// a match is warned about.  A blank machine ID is expected in the live
+// environment, as it's only generated on first boot.  The machine ID is
+// recorded in the inventory.
+type MachineIDCheck struct{}
+
+func (c MachineIDCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "MachineID"
+	etcPath := filepath.Join(env.host().root, "etc/machine-id")
+	id := env.readTrimmed(etcPath)
+	switch {
+	case id == "" || id == machineIDUninitialized:
+		result.Message = "The machine ID is blank, and will be generated on first boot."
+		return
+	case !validMachineID(id):
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("The machine ID %q in /etc/machine-id is not valid. "+
+			"Please empty the file, so that a new one is generated on first boot.", id)
+		return
+	}
+	env.Inventory.MachineID = id
+	result.Message = fmt.Sprintf("The machine ID is %s.", id)
+
+	// /var/lib/dbus/machine-id is usually a symlink to /etc/machine-id,
+	// which is consistent by definition, and which we can't follow
+	// relative to the host's root anyway
+	dbusPath := filepath.Join(env.host().root, "var/lib/dbus/machine-id")
+	if info, err := env.lstat(dbusPath); err == nil && info.Mode()&fs.ModeSymlink == 0 {
+		if dbusID := env.readTrimmed(dbusPath); dbusID != "" && dbusID != id {
+			result.Severity = SeverityWarning
+			result.Message += fmt.Sprintf(" It doesn't match the D-Bus machine ID %s in /var/lib/dbus/machine-id. "+
+				"Please replace that file with a symlink to /etc/machine-id.", dbusID)
+		}
+	}
+
+	known := map[string]string{}
+	for _, knownID := range env.Options.KnownMachineIDs {
+		known[knownID] = ""
+	}
+	if env.Options.FleetInventory != "" {
+		fleet, err := readFleetInventory(env, env.Options.FleetInventory)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return result, fmt.Errorf("unable to read fleet inventory: %w", err)
+		}
+		for fleetID, host := range fleet {
+			known[fleetID] = host
+		}
+	}
+	if host, ok := known[id]; ok {
+		result.Severity = SeverityWarning
+		source := "a known clone source"
+		if host != "" {
+			source = host
+		}
+		result.Message += fmt.Sprintf(" This is the same as %s, so the image was probably cloned without resetting it. "+
+			"Please empty /etc/machine-id, so that a new one is generated on first boot.", source)
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMachineIDCheck(t *testing.T) {
+	h := testHost()
+
+	const (
+		id       = "4c2f8a9e1b7d4e0f9a3c6b5d8e7f1a2b"
+		valid    = "The machine ID is " + id + "."
+		blank    = "The machine ID is blank, and will be generated on first boot."
+		resetIt  = "Please empty /etc/machine-id, so that a new one is generated on first boot."
+		mismatch = " It doesn't match the D-Bus machine ID 0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a in /var/lib/dbus/machine-id. " +
+			"Please replace that file with a symlink to /etc/machine-id."
+	)
+
+	tests := []struct {
+		fixture   string
+		options   Options
+		severity  Severity
+		message   string
+		machineID string
+	}{
+		{fixture: "blank", message: blank},
+		{fixture: "uninitialized", message: blank},
+		{fixture: "valid", message: valid, machineID: id},
+		{
+			fixture:   "valid",
+			options:   Options{FleetInventory: "./testdata/machine-id/missing.txt"},
+			message:   valid,
+			machineID: id,
+		},
+		{
+			fixture:   "mismatched",
+			severity:  SeverityWarning,
+			message:   valid + mismatch,
+			machineID: id,
+		},
+		{
+			fixture:   "valid",
+			options:   Options{KnownMachineIDs: []string{id}},
+			severity:  SeverityWarning,
+			message:   valid + " This is the same as a known clone source, so the image was probably cloned without resetting it. " + resetIt,
+			machineID: id,
+		},
+		{
+			fixture:   "valid",
+			options:   Options{FleetInventory: "./testdata/machine-id/fleet.txt"},
+			severity:  SeverityWarning,
+			message:   valid + " This is the same as node2, so the image was probably cloned without resetting it. " + resetIt,
+			machineID: id,
+		},
+		{
+			fixture:  "zeros",
+			severity: SeverityWarning,
+			message: "The machine ID \"00000000000000000000000000000000\" in /etc/machine-id is not valid. " +
+				"Please empty the file, so that a new one is generated on first boot.",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/machine-id/" + test.fixture
+		env := &Env{Options: test.options, machine: h}
+		result, err := MachineIDCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "MachineID", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Equal(t, test.machineID, env.Inventory.MachineID, test.fixture)
+	}
+}
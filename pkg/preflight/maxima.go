@@ -0,0 +1,160 @@
+package preflight
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rules/maxima.yaml
+var defaultMaxima []byte
+
+// defaultMaximaOverridePath is where sites can put their own tested maxima
+const defaultMaximaOverridePath = "/etc/saftos/preflight/maxima.yaml"
+
+// A Maximum is the largest number of some resource SaftOS has been tested
+// with on a single node.
+type Maximum struct {
+	// Resource is "logical CPUs", "sockets", "physical NICs" or "disks".
+	Resource string `yaml:"resource"`
+	Max      int    `yaml:"max"`
+}
+
+// DefaultMaxima returns the tested maxima from the override file, if there
+// is one, otherwise the ones shipped in rules/maxima.yaml.
+func DefaultMaxima() ([]Maximum, error) {
+	return loadMaxima(defaultMaximaOverridePath)
+}
+
+// loadMaxima is DefaultMaxima, with the override file at path.
+func loadMaxima(path string) ([]Maximum, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		data = defaultMaxima
+	} else if err != nil {
+		return nil, err
+	}
+	var maxima []Maximum
+	if err := yaml.Unmarshal(data, &maxima); err != nil {
+		return nil, fmt.Errorf("unable to parse tested maxima: %w", err)
+	}
+	return maxima, nil
+}
+
+// MaximaCheck compares the size of the host against the tested maxima,
+// because very large hosts may hit untested code paths.  It's purely
+// advisory, so it never fails.  Counts which aren't in the inventory
+// already are collected, and recorded there.
+type MaximaCheck struct {
+	// Maxima overrides DefaultMaxima, if set.
+	Maxima []Maximum
+}
+
+func (c MaximaCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Maxima"
+	maxima := c.Maxima
+	if maxima == nil {
+		if maxima, err = loadMaxima(env.host().maximaOverridePath); err != nil {
+			return
+		}
+	}
+
+	inv := &env.Inventory
+	if inv.LogicalCPUs == 0 || inv.Sockets == 0 {
+		cpus, sockets := countCPUs(env)
+		if inv.LogicalCPUs == 0 {
+			inv.LogicalCPUs = cpus
+		}
+		if inv.Sockets == 0 {
+			inv.Sockets = sockets
+		}
+		if inv.Sockets == 0 {
+			inv.Sockets = countSocketsDMI(env)
+		}
+	}
+	if inv.PhysicalNICs == 0 {
+		inv.PhysicalNICs = countPhysicalNICs(env)
+	}
+	if inv.Disks == 0 {
+		if devs, err := env.listBlockDevices(); err == nil {
+			for _, dev := range devs {
+				if dev.isDisk() {
+					inv.Disks++
+				}
+			}
+		}
+	}
+	counts := map[string]int{
+		"logical CPUs":  inv.LogicalCPUs,
+		"sockets":       inv.Sockets,
+		"physical NICs": inv.PhysicalNICs,
+		"disks":         inv.Disks,
+	}
+
+	var msgs []string
+	for _, maximum := range maxima {
+		if count := counts[maximum.Resource]; count > maximum.Max {
+			msgs = append(msgs, fmt.Sprintf("%d %s detected; SaftOS is validated up to %d.", count, maximum.Resource, maximum.Max))
+		}
+	}
+	if len(msgs) > 0 {
+		result.Severity = SeverityInfo
+		result.Message = strings.Join(msgs, " ")
+	}
+	return
+}
+
+// countCPUs returns the number of logical CPUs and sockets in
+// /proc/cpuinfo.  Some architectures don't report a physical id, in
+// which case the number of sockets is 0.
+func countCPUs(env *Env) (cpus, sockets int) {
+	out, err := env.readFile(filepath.Join(env.host().root, "proc/cpuinfo"))
+	if err != nil {
+		return
+	}
+	ids := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "processor":
+			cpus++
+		case "physical id":
+			ids[strings.TrimSpace(value)] = true
+		}
+	}
+	sockets = len(ids)
+	return
+}
+
+// countSocketsDMI returns the number of populated processor sockets
+// according to DMI, or 1 if DMI isn't available.
+func countSocketsDMI(env *Env) int {
+	records, err := env.dmi(4)
+	if err != nil {
+		return 1
+	}
+	sockets := 0
+	for _, record := range records {
+		if strings.HasPrefix(record.Fields["Status"], "Populated") {
+			sockets++
+		}
+	}
+	return max(sockets, 1)
+}
+
+// countPhysicalNICs returns the number of network interfaces backed by a
+// device, which leaves out bridges, bonds, VLANs and the like.
+func countPhysicalNICs(env *Env) int {
+	nics, _ := env.listNICs()
+	return len(nics)
+}
@@ -0,0 +1,80 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMaxima(t *testing.T) {
+	maxima, err := loadMaxima("./testdata/maxima/missing.yaml")
+	assert.Nil(t, err)
+	assert.Contains(t, maxima, Maximum{Resource: "logical CPUs", Max: 256})
+
+	maxima, err = loadMaxima("./testdata/maxima/override.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, []Maximum{{"logical CPUs", 1024}, {"sockets", 1}}, maxima)
+}
+
+func TestMaximaCheck(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+	h.sysBlock = "./testdata/maxima/host/sys/block"
+	h.maximaOverridePath = "./testdata/maxima/missing.yaml"
+
+	tests := []struct {
+		name      string
+		fixture   string
+		maxima    []Maximum
+		inventory Inventory
+		severity  Severity
+		message   string
+		counts    [4]int
+	}{
+		{
+			name:      "within limits",
+			inventory: Inventory{LogicalCPUs: 128, Sockets: 2, PhysicalNICs: 4, Disks: 12},
+			counts:    [4]int{128, 2, 4, 12},
+		},
+		{
+			name:      "beyond limits",
+			inventory: Inventory{LogicalCPUs: 448, Sockets: 8, PhysicalNICs: 4, Disks: 40},
+			severity:  SeverityInfo,
+			message: "448 logical CPUs detected; SaftOS is validated up to 256. " +
+				"8 sockets detected; SaftOS is validated up to 4. " +
+				"40 disks detected; SaftOS is validated up to 32.",
+			counts: [4]int{448, 8, 4, 40},
+		},
+		{
+			name:     "collected",
+			maxima:   []Maximum{{"logical CPUs", 2}, {"sockets", 1}, {"physical NICs", 1}, {"disks", 2}},
+			severity: SeverityInfo,
+			message: "4 logical CPUs detected; SaftOS is validated up to 2. " +
+				"2 sockets detected; SaftOS is validated up to 1. " +
+				"2 physical NICs detected; SaftOS is validated up to 1.",
+			counts: [4]int{4, 2, 2, 2},
+		},
+		{
+			name:     "sockets from DMI",
+			fixture:  "arm64",
+			maxima:   []Maximum{{"sockets", 1}},
+			severity: SeverityInfo,
+			message:  "2 sockets detected; SaftOS is validated up to 1.",
+			counts:   [4]int{2, 2, 0, 2},
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/maxima/host"
+		if test.fixture != "" {
+			h.root = "./testdata/maxima/" + test.fixture
+		}
+		env := &Env{Inventory: test.inventory, execCommand: fakeCommand("dmidecode-two-sockets"), machine: h}
+		result, err := MaximaCheck{Maxima: test.maxima}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "Maxima", Severity: test.severity, Message: test.message}, result, test.name)
+		inv := env.Inventory
+		assert.Equal(t, test.counts, [4]int{inv.LogicalCPUs, inv.Sockets, inv.PhysicalNICs, inv.Disks}, test.name)
+	}
+}
@@ -0,0 +1,126 @@
+package preflight
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A MessageTemplate is one of the messages the hardware requirement
+// checks give, as the format it's rendered from.  Support scripts and
+// runbooks quote these, so their wording is pinned by the golden files
+// under testdata/golden, and only changes when those are regenerated.
+// A template may be a fragment which is rendered into another, e.g. the
+// CPUs counted.
+type MessageTemplate struct {
+	// Check is the name of the check's Result, e.g. "CPU".
+	Check string `json:"check"`
+	// Condition is what the message is given for, e.g. "below-test".
+	Condition string `json:"condition"`
+	// Severity is that of the Result the message is given with.
+	Severity Severity `json:"severity"`
+	// Error means the template is of the error the check fails with,
+	// rather than of its message.
+	Error bool `json:"error,omitempty"`
+	// Format is the fmt format the message is rendered from.
+	Format string `json:"format"`
+}
+
+func (m MessageTemplate) render(a ...any) string {
+	return fmt.Sprintf(m.Format, a...)
+}
+
+var (
+	cpuCoresMessage = MessageTemplate{Check: "CPU", Condition: "cores",
+		Format: "%d CPU cores"}
+	cpuCoresIsolatedMessage = MessageTemplate{Check: "CPU", Condition: "cores-isolated",
+		Format: "%d usable CPU cores (%d more are isolated)"}
+	cpuBelowTestMessage = MessageTemplate{Check: "CPU", Condition: "below-test", Severity: SeverityFatal,
+		Format: "Only %s detected. SaftOS requires at least %d cores for testing and %d for production use of %s."}
+	cpuBelowProdMessage = MessageTemplate{Check: "CPU", Condition: "below-prod", Severity: SeverityWarning,
+		Format: "%s detected. SaftOS requires at least %d cores for production use of %s."}
+	cpuUnsupportedArchMessage = MessageTemplate{Check: "CPU", Condition: "unsupported-arch", Severity: SeverityFatal,
+		Format: "SaftOS does not support the %s architecture. It runs on %s only."}
+
+	memoryBelowTestMessage = MessageTemplate{Check: "Memory", Condition: "below-test", Severity: SeverityFatal,
+		Format: "Only %s RAM detected. SaftOS requires at least %dGiB for testing and %dGiB for production use of %s."}
+	memoryBelowProdMessage = MessageTemplate{Check: "Memory", Condition: "below-prod", Severity: SeverityWarning,
+		Format: "%s RAM detected. SaftOS requires at least %dGiB for production use of %s."}
+	memoryCrashKernelMessage = MessageTemplate{Check: "Memory", Condition: "crash-kernel", Severity: SeverityWarning,
+		Format: " A further %s is reserved for crash dumps."}
+	memoryNoMemTotalError = MessageTemplate{Check: "Memory", Condition: "no-memtotal", Error: true,
+		Format: "unable to extract MemTotal from %s"}
+	memoryMalformedMemTotalError = MessageTemplate{Check: "Memory", Condition: "malformed-memtotal", Error: true,
+		Format: "unable to extract MemTotal from %s: %w"}
+
+	virtVirtualizedMessage = MessageTemplate{Check: "Virt", Condition: "virtualized", Severity: SeverityWarning,
+		Format: "System is virtualized (%s) which is not supported in production. Nested virtualization is available, so it will do for testing."}
+	virtNoNestedMessage = MessageTemplate{Check: "Virt", Condition: "no-nested", Severity: SeverityWarning,
+		Format: "System is virtualized (%s) without nested virtualization, so virtual machines on SaftOS will be emulated, and unusably slow. " +
+			"Please enable nested virtualization on the hypervisor."}
+	virtNoNestedProductionMessage = MessageTemplate{Check: "Virt", Condition: "no-nested-production", Severity: SeverityFatal,
+		Format: "System is virtualized (%s) without nested virtualization, so virtual machines on SaftOS would be emulated, which is unusable in production. " +
+			"Please enable nested virtualization on the hypervisor."}
+
+	kvmHostNoKVMMessage = MessageTemplate{Check: "KVMHost", Condition: "no-kvm", Severity: SeverityWarning,
+		Format: "SaftOS requires hardware-assisted virtualization, but /dev/kvm does not exist."}
+
+	networkSpeedBelowTestMessage = MessageTemplate{Check: "NetworkSpeed", Condition: "below-test", Severity: SeverityFatal,
+		Format: "Link speed of %s is only %dMpbs. SaftOS requires at least %dGbps for testing and %dGbps for production use of %s."}
+	networkSpeedBelowProdMessage = MessageTemplate{Check: "NetworkSpeed", Condition: "below-prod", Severity: SeverityWarning,
+		Format: "Link speed of %s is %gGbps. SaftOS requires at least %dGbps for production use of %s."}
+	networkSpeedNoLinkMessage = MessageTemplate{Check: "NetworkSpeed", Condition: "no-link", Severity: SeverityWarning,
+		Format: "NIC %s has no link, cannot verify speed (SaftOS requires at least %dGbps for production use of %s)."}
+	networkSpeedUnknownMessage = MessageTemplate{Check: "NetworkSpeed", Condition: "unknown-speed", Severity: SeverityWarning,
+		Format: "NIC %s reports its speed as unknown, as virtio NICs do, cannot verify speed (SaftOS requires at least %dGbps for production use of %s)."}
+	networkSpeedWirelessMessage = MessageTemplate{Check: "NetworkSpeed", Condition: "wireless", Severity: SeverityFatal,
+		Format: "NIC %s is wireless, which is unsupported. SaftOS requires a wired NIC of at least %dGbps for production use of %s."}
+	networkSpeedMalformedError = MessageTemplate{Check: "NetworkSpeed", Condition: "malformed-speed", Error: true,
+		Format: "unable to determine NIC speed from %s: %w"}
+	networkSpeedInvalidNameError = MessageTemplate{Check: "NetworkSpeed", Condition: "invalid-name", Error: true,
+		Format: "%w %q: %s"}
+	networkSpeedNoLinksError = MessageTemplate{Check: "NetworkSpeed", Condition: "no-links", Error: true,
+		Format: "unable to determine the link speed of %s, as it has no %s"}
+	networkSpeedNestedError = MessageTemplate{Check: "NetworkSpeed", Condition: "nested-too-deep", Error: true,
+		Format: "unable to determine the link speed of %s, as it's stacked more than %d interfaces deep"}
+
+	diskRotationalMessage = MessageTemplate{Check: "Disk", Condition: "rotational", Severity: SeverityWarning,
+		Format: "Disk %s is rotational. SSD or NVMe storage is recommended, as etcd and VM disks need fast random I/O."}
+	diskMalformedError = MessageTemplate{Check: "Disk", Condition: "malformed", Error: true,
+		Format: "unable to parse %s: the value %w"}
+)
+
+// messages is the catalog, in the order the checks run in.
+var messages = []MessageTemplate{
+	cpuCoresMessage,
+	cpuCoresIsolatedMessage,
+	cpuBelowTestMessage,
+	cpuBelowProdMessage,
+	cpuUnsupportedArchMessage,
+	memoryBelowTestMessage,
+	memoryBelowProdMessage,
+	memoryCrashKernelMessage,
+	memoryNoMemTotalError,
+	memoryMalformedMemTotalError,
+	virtVirtualizedMessage,
+	virtNoNestedMessage,
+	virtNoNestedProductionMessage,
+	kvmHostNoKVMMessage,
+	networkSpeedBelowTestMessage,
+	networkSpeedBelowProdMessage,
+	networkSpeedNoLinkMessage,
+	networkSpeedUnknownMessage,
+	networkSpeedWirelessMessage,
+	networkSpeedMalformedError,
+	networkSpeedInvalidNameError,
+	networkSpeedNoLinksError,
+	networkSpeedNestedError,
+	diskRotationalMessage,
+	diskMalformedError,
+}
+
+// Messages returns the templates of every message the hardware
+// requirement checks can give, and every error they can fail with which
+// isn't the system's own, so that their documentation can be generated.
+func Messages() []MessageTemplate {
+	return slices.Clone(messages)
+}
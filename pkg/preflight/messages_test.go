@@ -0,0 +1,255 @@
+package preflight
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The messages the hardware requirement checks give are pinned by the
+// golden files in testdata/golden, one per check.  When a message is
+// meant to change, regenerate them with
+//
+//	go test ./pkg/preflight -run TestMessagesGolden -update
+//
+// and review the diff as you would that of the message itself.
+var update = flag.Bool("update", false, "regenerate the golden files in testdata/golden")
+
+// A messageCase renders a check's message, or error, in one condition
+// from fixed inputs.  Its name is the condition, followed by those of any
+// fragments of the message, separated by slashes.
+type messageCase struct {
+	check   string
+	name    string
+	variant string
+	run     func(t *testing.T, h *host) (Result, error)
+}
+
+// goldenNICs are the device names NetworkSpeedCheck's messages are
+// rendered with, since they're quoted in them.
+var goldenNICs = []string{"eth0", "ens1f0", "enp94s0f1", "bond0.100"}
+
+// goldenDisks are the device names DiskCheck's messages are rendered
+// with, for the same reason.
+var goldenDisks = []string{"sda", "nvme0n1", "vda"}
+
+// writeFile writes data to the file at path under dir, creating the
+// directories it's in.
+func writeFile(t *testing.T, dir, path, data string) {
+	t.Helper()
+	path = filepath.Join(dir, path)
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0o644))
+}
+
+func messageCases() []messageCase {
+	cpu := func(present string, online, isolated int) func(t *testing.T, h *host) (Result, error) {
+		return func(t *testing.T, h *host) (Result, error) {
+			writeFile(t, h.root, "sys/devices/system/cpu/present", present+"\n")
+			h.onlineCPUs = func() int { return online }
+			return CPUCheck{}.Evaluate(context.Background(), &Env{Inventory: Inventory{IsolatedCPUs: isolated}, machine: h})
+		}
+	}
+	cpuArch := func(arch string) func(t *testing.T, h *host) (Result, error) {
+		return func(t *testing.T, h *host) (Result, error) {
+			h.goarch = arch
+			return CPUCheck{}.Evaluate(context.Background(), &Env{machine: h})
+		}
+	}
+	memory := func(memTotal string, crashKernelBytes uint64) func(t *testing.T, h *host) (Result, error) {
+		return func(t *testing.T, h *host) (Result, error) {
+			meminfo := ""
+			if memTotal != "" {
+				meminfo = "MemTotal:       " + memTotal + "\n"
+			}
+			writeFile(t, h.root, "proc/meminfo", meminfo+"MemFree:         1048576 kB\n")
+			check := MemoryCheck{MemInfoPath: filepath.Join(h.root, "proc/meminfo")}
+			env := &Env{Inventory: Inventory{CrashKernelBytes: crashKernelBytes}, execCommand: fakeCommand("dmidecode-fail"), machine: h}
+			return check.Evaluate(context.Background(), env)
+		}
+	}
+	virt := func(key, devKvm string, production bool) func(t *testing.T, h *host) (Result, error) {
+		return func(_ *testing.T, h *host) (Result, error) {
+			env := &Env{Options: Options{Production: production}, machine: h}
+			return VirtCheck{ExecCommand: fakeCommand(key), DevicePath: devKvm}.Evaluate(context.Background(), env)
+		}
+	}
+	kvmHost := func(path string) func(t *testing.T, h *host) (Result, error) {
+		return func(_ *testing.T, h *host) (Result, error) {
+			return KVMHostCheck{DevicePath: path}.Evaluate(context.Background(), &Env{machine: h})
+		}
+	}
+	networkSpeed := func(dev, speed string) func(t *testing.T, h *host) (Result, error) {
+		return func(t *testing.T, h *host) (Result, error) {
+			if validateInterfaceName(dev) == nil {
+				writeFile(t, h.root, filepath.Join("sys/class/net", dev, "speed"), speed+"\n")
+			}
+			return NetworkSpeedCheck{dev}.Evaluate(context.Background(), &Env{machine: h})
+		}
+	}
+	// nicState lays out the sysfs directory of the interface dev with its
+	// speed, and the file given, which says what state it's in.
+	nicState := func(dev, file, data, speed string) func(t *testing.T, h *host) (Result, error) {
+		return func(t *testing.T, h *host) (Result, error) {
+			dir := filepath.Join(h.root, "sys/class/net", dev)
+			writeFile(t, dir, "speed", speed+"\n")
+			writeFile(t, dir, file, data)
+			return NetworkSpeedCheck{dev}.Evaluate(context.Background(), &Env{machine: h})
+		}
+	}
+	// stacked lays out the sysfs directory of the interface dev, stacked
+	// on others, with the files given, and empty directories for those
+	// ending in a slash.
+	stacked := func(dev string, files ...string) func(t *testing.T, h *host) (Result, error) {
+		return func(t *testing.T, h *host) (Result, error) {
+			for _, file := range files {
+				path := filepath.Join(h.root, "sys/class/net", dev, file)
+				if strings.HasSuffix(file, "/") {
+					assert.Nil(t, os.MkdirAll(path, 0o755))
+				} else {
+					writeFile(t, filepath.Dir(path), filepath.Base(path), "\n")
+				}
+			}
+			return NetworkSpeedCheck{dev}.Evaluate(context.Background(), &Env{machine: h})
+		}
+	}
+	disk := func(dev, size, rotational string) func(t *testing.T, h *host) (Result, error) {
+		return func(t *testing.T, h *host) (Result, error) {
+			if _, err := (&Env{machine: h}).blockDevPath(dev, ""); err == nil {
+				writeFile(t, h.sysBlock, filepath.Join(dev, "size"), size+"\n")
+				writeFile(t, h.sysBlock, filepath.Join(dev, "queue/hw_sector_size"), "512\n")
+				writeFile(t, h.sysBlock, filepath.Join(dev, "queue/rotational"), rotational+"\n")
+			}
+			return DiskCheck{dev}.Evaluate(context.Background(), &Env{machine: h})
+		}
+	}
+
+	cases := []messageCase{
+		{"CPU", "pass", "", cpu("0-15", 16, 0)},
+		{"CPU", "below-test/cores", "", cpu("0-3", 4, 0)},
+		{"CPU", "below-prod/cores", "", cpu("0-7", 8, 0)},
+		{"CPU", "below-prod/cores-isolated", "", cpu("0-11", 12, 2)},
+		{"CPU", "unsupported-arch", "riscv64", cpuArch("riscv64")},
+		{"Memory", "pass", "", memory("65758888 kB", 0)},
+		{"Memory", "below-test", "", memory("458112 kB", 0)},
+		{"Memory", "below-prod", "", memory("32856640 kB", 0)},
+		{"Memory", "below-prod/crash-kernel", "", memory("32856640 kB", 512<<20)},
+		{"Memory", "no-memtotal", "", memory("", 0)},
+		{"Memory", "malformed-memtotal", "", memory("-32856640 kB", 0)},
+		{"Memory", "malformed-memtotal", "", memory("32 GB", 0)},
+		{"Virt", "pass", "", virt("metal", "./testdata/dev-kvm", false)},
+		{"Virt", "virtualized", "", virt("kvm", "./testdata/dev-kvm", false)},
+		{"Virt", "no-nested", "", virt("kvm", "./testdata/nonexistent", false)},
+		{"Virt", "no-nested-production", "", virt("kvm", "./testdata/nonexistent", true)},
+		{"KVMHost", "pass", "", kvmHost("./testdata/dev-kvm")},
+		{"KVMHost", "no-kvm", "", kvmHost("./testdata/dev-kvm-does-not-exist")},
+	}
+	for _, dev := range goldenNICs {
+		cases = append(cases,
+			messageCase{"NetworkSpeed", "pass", dev, networkSpeed(dev, "25000")},
+			messageCase{"NetworkSpeed", "below-test", dev, networkSpeed(dev, "100")},
+			messageCase{"NetworkSpeed", "below-prod", dev, networkSpeed(dev, "2500")},
+			messageCase{"NetworkSpeed", "unknown-speed", dev, networkSpeed(dev, "-1")},
+			messageCase{"NetworkSpeed", "malformed-speed", dev, networkSpeed(dev, "-100")},
+		)
+	}
+	for _, dev := range []string{"", "..", "../../kernel", "eth0:1", strings.Repeat("e", 16)} {
+		cases = append(cases, messageCase{"NetworkSpeed", "invalid-name", fmt.Sprintf("%q", dev), networkSpeed(dev, "")})
+	}
+	cases = append(cases,
+		messageCase{"NetworkSpeed", "no-link", "eno1", nicState("eno1", "operstate", "down", "-1")},
+		messageCase{"NetworkSpeed", "wireless", "wlp2s0", nicState("wlp2s0", "wireless/.keep", "", "866")},
+		messageCase{"NetworkSpeed", "no-links", "bond1", stacked("bond1", "bonding/slaves", "bonding/mode")},
+		messageCase{"NetworkSpeed", "no-links", "br1", stacked("br1", "bridge/", "brif/")},
+		messageCase{"NetworkSpeed", "nested-too-deep", "veth9", stacked("veth9", "lower_veth9")},
+	)
+	for _, dev := range goldenDisks {
+		cases = append(cases,
+			messageCase{"Disk", "pass", dev, disk(dev, "1953525168", "0")},
+			messageCase{"Disk", "rotational", dev, disk(dev, "3907029168", "1")},
+			messageCase{"Disk", "malformed", dev, disk(dev, "-1", "0")},
+		)
+	}
+	for _, dev := range []string{"..", "../../proc", "sda\x00"} {
+		cases = append(cases, messageCase{"Disk", "invalid-name", fmt.Sprintf("%q", dev), disk(dev, "", "")})
+	}
+	return cases
+}
+
+// renderMessage renders the outcome of a case as a line of its golden
+// file, with the scratch directory it ran in taken out of paths.
+func renderMessage(c messageCase, result Result, err error, root string) string {
+	name := c.name
+	if c.variant != "" {
+		name += " " + c.variant
+	}
+	var line string
+	switch {
+	case err != nil && classifyError(err) == "":
+		line = fmt.Sprintf("%s: error: %s", name, err)
+	case err != nil:
+		line = fmt.Sprintf("%s: error (%s): %s", name, classifyError(err), err)
+	case result.Message == "":
+		line = fmt.Sprintf("%s: %s", name, result.Severity)
+	default:
+		line = fmt.Sprintf("%s: %s: %s", name, result.Severity, result.Message)
+	}
+	return strings.ReplaceAll(line, root, "")
+}
+
+func TestMessagesGolden(t *testing.T) {
+	rendered := map[string][]string{}
+	var checks []string
+	for _, c := range messageCases() {
+		h := testHost()
+		h.root = t.TempDir()
+		h.sysBlock = filepath.Join(h.root, "sys/block")
+		result, err := c.run(t, h)
+		if _, ok := rendered[c.check]; !ok {
+			checks = append(checks, c.check)
+		}
+		rendered[c.check] = append(rendered[c.check], renderMessage(c, result, err, h.root))
+	}
+
+	for _, check := range checks {
+		golden := filepath.Join("testdata/golden", check+".golden")
+		got := strings.Join(rendered[check], "\n") + "\n"
+		if *update {
+			assert.Nil(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+			assert.Nil(t, os.WriteFile(golden, []byte(got), 0o644))
+			continue
+		}
+		expected, err := os.ReadFile(golden)
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), got, "%s differs; if that's intended, rerun with -update", golden)
+	}
+}
+
+// Every template in the catalog is rendered by some case, so none of them
+// can change without a golden file changing.
+func TestMessages(t *testing.T) {
+	covered := map[[2]string]bool{}
+	for _, c := range messageCases() {
+		for _, condition := range strings.Split(c.name, "/") {
+			covered[[2]string{c.check, condition}] = true
+		}
+	}
+	seen := map[[2]string]bool{}
+	for _, m := range Messages() {
+		key := [2]string{m.Check, m.Condition}
+		assert.False(t, seen[key], "%v is in the catalog twice", key)
+		seen[key] = true
+		assert.True(t, covered[key], "%v has no golden case", key)
+		assert.NotEmpty(t, m.Format)
+	}
+
+	// The catalog can't be changed through what's returned
+	Messages()[0].Format = "changed"
+	assert.Equal(t, "%d CPU cores", Messages()[0].Format)
+}
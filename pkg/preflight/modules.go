@@ -0,0 +1,201 @@
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// A KernelModule is a module SaftOS needs, either built in, loaded, or
+// loadable.
+type KernelModule struct {
+	Name string
+	// Required modules are fatal if missing, others are just recommended.
+	Required bool
+	// Purpose explains what the module is needed for.
+	Purpose string
+}
+
+// defaultKernelModules are the modules the SaftOS stack relies on.
+var defaultKernelModules = []KernelModule{
+	{"kvm", true, "running VMs"},
+	{"kvm_intel", true, "running VMs on Intel CPUs"},
+	{"kvm_amd", true, "running VMs on AMD CPUs"},
+	{"overlay", true, "container image layers"},
+	{"br_netfilter", true, "filtering of bridged traffic"},
+	{"bridge", true, "VM networks"},
+	{"tun", true, "VM network interfaces"},
+	{"vhost_net", true, "accelerated VM networking"},
+	{"vxlan", true, "the cluster overlay network"},
+	{"nf_conntrack", true, "service load balancing"},
+	{"iscsi_tcp", true, "attaching volumes"},
+	{"dm_snapshot", true, "volume snapshots"},
+	{"dm_thin_pool", true, "thin-provisioned volumes"},
+	{"8021q", false, "VLAN networks"},
+	{"bonding", false, "NIC bonding"},
+	{"nbd", false, "attaching VM images for inspection"},
+	{"dm_crypt", false, "encrypted volumes"},
+	{"vfio_pci", false, "PCI passthrough"},
+}
+
+// cpuKernelModules are the modules which are only needed if the CPU has
+// the given flag, by name.
+var cpuKernelModules = map[string]string{"kvm_intel": "vmx", "kvm_amd": "svm"}
+
+// DefaultKernelModules returns the modules the SaftOS stack relies on, so
+// that the installer can make sure of them without repeating the list.
+func DefaultKernelModules() []KernelModule {
+	return slices.Clone(defaultKernelModules)
+}
+
+// ModuleSetCheck verifies that all the kernel modules SaftOS needs are
+// available, because a custom kernel missing one of them fails at some
+// random point later on.  Modules are available if they're built in
+// (according to modules.builtin), already loaded, or modprobe says it
+// could load them.  Those which are only loadable are listed too, though
+// that's fine, as the kernel loads them when they're needed, and loading
+// them is the remediation.  Those which are missing can only be had from
+// another kernel, or package, which the hint says.  Modules for another
+// CPU vendor's virtualization extensions, e.g. kvm_amd on Intel CPUs,
+// aren't looked for.
+type ModuleSetCheck struct {
+	// Modules overrides DefaultKernelModules, if set.
+	Modules []KernelModule
+}
+
+func (c ModuleSetCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ModuleSet"
+	modules := c.Modules
+	if modules == nil {
+		modules = defaultKernelModules
+	}
+
+	release, _, err := env.host().unameRelease()
+	if err != nil {
+		return
+	}
+	present, err := readBuiltinModules(env, filepath.Join(env.host().root, "lib/modules", release, "modules.builtin"))
+	if err != nil {
+		return
+	}
+	if err = readLoadedModules(env, filepath.Join(env.host().root, "proc/modules"), present); err != nil {
+		return
+	}
+
+	var missingRequired, missingRecommended, loadable []string
+	var actions []RemediationAction
+	for _, module := range cpuModules(env, modules) {
+		name := normalizeModuleName(module.Name)
+		if present[name] {
+			continue
+		}
+		if moduleLoadable(env, name) {
+			loadable = append(loadable, module.Name)
+			actions = append(actions, RemediationAction{Kind: RemediationModuleLoad, Target: name})
+			continue
+		}
+		desc := fmt.Sprintf("%s (%s)", module.Name, module.Purpose)
+		if module.Required {
+			missingRequired = append(missingRequired, desc)
+		} else {
+			missingRecommended = append(missingRecommended, desc)
+		}
+	}
+
+	var msgs []string
+	if len(missingRequired) > 0 {
+		result.Severity = SeverityFatal
+		msgs = append(msgs, fmt.Sprintf("Required kernel modules are missing: %s.", strings.Join(missingRequired, ", ")))
+	}
+	if len(missingRecommended) > 0 {
+		if result.Severity < SeverityWarning {
+			result.Severity = SeverityWarning
+		}
+		msgs = append(msgs, fmt.Sprintf("Recommended kernel modules are missing: %s.", strings.Join(missingRecommended, ", ")))
+	}
+	if len(loadable) > 0 {
+		msgs = append(msgs, fmt.Sprintf("Kernel modules which are loadable, but not loaded yet: %s.", strings.Join(loadable, ", ")))
+	}
+	result.Message = strings.Join(msgs, " ")
+	var hints []string
+	if len(missingRequired)+len(missingRecommended) > 0 {
+		hints = append(hints, "Boot a kernel which provides the missing modules, or if they're packaged separately, install them.")
+	}
+	if len(actions) > 0 {
+		hints = append(hints, fmt.Sprintf("Load those which are loadable now with: %s.", commands(actions)))
+	}
+	if len(hints) > 0 {
+		result.Remediation = &Remediation{Hint: strings.Join(hints, " "), Actions: actions}
+	}
+	return
+}
+
+// cpuModules returns those of modules which the CPU needs, leaving out
+// those in cpuKernelModules whose flag it hasn't got.  If the CPU's flags
+// can't be read, none of those are needed.
+func cpuModules(env *Env, modules []KernelModule) []KernelModule {
+	if !slices.ContainsFunc(modules, func(module KernelModule) bool {
+		return cpuKernelModules[normalizeModuleName(module.Name)] != ""
+	}) {
+		return modules
+	}
+	flags, _ := readCPUFlags(env, env.host().procCPUInfo)
+	return slices.DeleteFunc(slices.Clone(modules), func(module KernelModule) bool {
+		flag := cpuKernelModules[normalizeModuleName(module.Name)]
+		return flag != "" && !slices.Contains(flags, flag)
+	})
+}
+
+// normalizeModuleName returns the name the kernel uses for a module, which
+// has underscores where the file name may have dashes.
+func normalizeModuleName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// readBuiltinModules returns the modules listed in modules.builtin, which
+// has a path such as "kernel/drivers/net/tun.ko" per line.  A missing file
+// means no modules are built in.
+func readBuiltinModules(env *Env, path string) (map[string]bool, error) {
+	modules := map[string]bool{}
+	data, err := env.readFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return modules, nil
+	} else if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name := strings.TrimSuffix(filepath.Base(strings.TrimSpace(scanner.Text())), ".ko")
+		if name != "" {
+			modules[normalizeModuleName(name)] = true
+		}
+	}
+	return modules, scanner.Err()
+}
+
+// readLoadedModules adds the modules listed in /proc/modules to modules.
+func readLoadedModules(env *Env, path string, modules map[string]bool) error {
+	data, err := env.readFile(path)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			modules[fields[0]] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// moduleLoadable returns true if modprobe says it could load the module.
+// modprobe is only run with --dry-run, so as not to change anything.
+func moduleLoadable(env *Env, name string) bool {
+	return env.succeeds("/usr/sbin/modprobe", "--dry-run", name)
+}
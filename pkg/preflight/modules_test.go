@@ -0,0 +1,142 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleSetCheck(t *testing.T) {
+	h := testHost()
+	h.unameRelease = func() (string, string, error) { return "6.4.0-test", "x86_64", nil }
+	command := func(_ string, args ...string) *exec.Cmd {
+		switch args[len(args)-1] {
+		case "vxlan", "nbd":
+			return fakeExecCommand("modprobe-ok")
+		}
+		return fakeExecCommand("modprobe-fail")
+	}
+
+	modules := []KernelModule{
+		{"overlay", true, "container image layers"},
+		{"dm-snapshot", true, "volume snapshots"},
+		{"tun", true, "VM network interfaces"},
+		{"vxlan", true, "the cluster overlay network"},
+		{"iscsi_tcp", true, "attaching volumes"},
+		{"8021q", false, "VLAN networks"},
+		{"nbd", false, "attaching VM images for inspection"},
+		{"dm_crypt", false, "encrypted volumes"},
+	}
+
+	tests := []struct {
+		fixture     string
+		modules     []KernelModule
+		severity    Severity
+		message     string
+		remediation *Remediation
+	}{
+		{
+			fixture:  "host",
+			modules:  modules,
+			severity: SeverityFatal,
+			message: "Required kernel modules are missing: iscsi_tcp (attaching volumes). " +
+				"Recommended kernel modules are missing: dm_crypt (encrypted volumes). " +
+				"Kernel modules which are loadable, but not loaded yet: vxlan, nbd.",
+			remediation: &Remediation{
+				Hint: "Boot a kernel which provides the missing modules, or if they're packaged separately, install them. " +
+					"Load those which are loadable now with: /usr/sbin/modprobe vxlan; /usr/sbin/modprobe nbd.",
+				Actions: []RemediationAction{
+					{Kind: RemediationModuleLoad, Target: "vxlan"},
+					{Kind: RemediationModuleLoad, Target: "nbd"},
+				},
+			},
+		},
+		{
+			fixture:  "host",
+			modules:  modules[5:],
+			severity: SeverityWarning,
+			message: "Recommended kernel modules are missing: dm_crypt (encrypted volumes). " +
+				"Kernel modules which are loadable, but not loaded yet: nbd.",
+			remediation: &Remediation{
+				Hint: "Boot a kernel which provides the missing modules, or if they're packaged separately, install them. " +
+					"Load those which are loadable now with: /usr/sbin/modprobe nbd.",
+				Actions: []RemediationAction{{Kind: RemediationModuleLoad, Target: "nbd"}},
+			},
+		},
+		{
+			// Being loadable is fine
+			fixture: "host",
+			modules: modules[:4],
+			message: "Kernel modules which are loadable, but not loaded yet: vxlan.",
+			remediation: &Remediation{
+				Hint:    "Load those which are loadable now with: /usr/sbin/modprobe vxlan.",
+				Actions: []RemediationAction{{Kind: RemediationModuleLoad, Target: "vxlan"}},
+			},
+		},
+		{
+			// Nothing which could be loaded is missing
+			fixture:  "host",
+			modules:  []KernelModule{modules[4]},
+			severity: SeverityFatal,
+			message:  "Required kernel modules are missing: iscsi_tcp (attaching volumes).",
+			remediation: &Remediation{
+				Hint: "Boot a kernel which provides the missing modules, or if they're packaged separately, install them.",
+			},
+		},
+		{
+			fixture:  "no-builtin",
+			modules:  modules[:4],
+			severity: SeverityFatal,
+			message: "Required kernel modules are missing: overlay (container image layers), dm-snapshot (volume snapshots). " +
+				"Kernel modules which are loadable, but not loaded yet: vxlan.",
+			remediation: &Remediation{
+				Hint: "Boot a kernel which provides the missing modules, or if they're packaged separately, install them. " +
+					"Load those which are loadable now with: /usr/sbin/modprobe vxlan.",
+				Actions: []RemediationAction{{Kind: RemediationModuleLoad, Target: "vxlan"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/modules/" + test.fixture
+		result, err := ModuleSetCheck{Modules: test.modules}.Evaluate(context.Background(), &Env{execCommand: command, machine: h})
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "ModuleSet", Severity: test.severity, Message: test.message, Remediation: test.remediation},
+			result, test.fixture)
+	}
+}
+
+// Only the KVM module for the CPU's virtualization extensions is needed.
+func TestModuleSetCheckCPUModules(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/modules/host"
+	h.unameRelease = func() (string, string, error) { return "6.4.0-test", "x86_64", nil }
+	modules := []KernelModule{
+		{"kvm_intel", true, "running VMs on Intel CPUs"},
+		{"kvm_amd", true, "running VMs on AMD CPUs"},
+	}
+	for fixture, message := range map[string]string{
+		"intel": "Required kernel modules are missing: kvm_intel (running VMs on Intel CPUs).",
+		"amd":   "Required kernel modules are missing: kvm_amd (running VMs on AMD CPUs).",
+		// Neither, without virtualization extensions
+		"vm": "",
+	} {
+		h.procCPUInfo = "./testdata/cpu-virt/" + fixture + "/cpuinfo"
+		result, err := ModuleSetCheck{Modules: modules}.Evaluate(context.Background(), &Env{execCommand: fakeCommand("modprobe-fail"), machine: h})
+		assert.Nil(t, err, fixture)
+		assert.Equal(t, message, result.Message, fixture)
+	}
+}
+
+func TestDefaultKernelModules(t *testing.T) {
+	modules := DefaultKernelModules()
+	for _, name := range []string{"kvm", "kvm_intel", "kvm_amd", "vhost_net", "overlay", "br_netfilter", "iscsi_tcp", "nbd"} {
+		assert.True(t, slices.ContainsFunc(modules, func(module KernelModule) bool { return module.Name == name }), name)
+	}
+	// The caller can't change the defaults
+	modules[0].Name = "changed"
+	assert.Equal(t, "kvm", DefaultKernelModules()[0].Name)
+}
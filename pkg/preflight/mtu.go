@@ -0,0 +1,98 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MTUCheck verifies that the interfaces Devs have the same MTU, as nodes
+// whose MTUs differ have their VXLAN overlay traffic silently drop large
+// packets.  If ExpectedMTU is set, each interface must have it, or else
+// they must all have the same one, whatever it is.  An interface whose MTU
+// can't be read, e.g. because it doesn't exist, is reported along with
+// the rest, rather than failing the check.
+type MTUCheck struct {
+	Devs        []string
+	ExpectedMTU int
+}
+
+func (c MTUCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c MTUCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "MTU"
+	if len(c.Devs) == 0 {
+		return
+	}
+
+	mtus := map[string]int{}
+	var listed, problems []string
+	for _, dev := range c.Devs {
+		mtu, err := netDevMTU(env, dev)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			problems = append(problems, fmt.Sprintf("Interface %s does not exist.", dev))
+			continue
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("Unable to read the MTU of %s: %v.", dev, err))
+			continue
+		}
+		mtus[dev] = mtu
+		if c.ExpectedMTU == 0 || mtu != c.ExpectedMTU {
+			listed = append(listed, fmt.Sprintf("%s (MTU %d)", dev, mtu))
+		}
+	}
+
+	distinct := map[int]bool{}
+	for _, mtu := range mtus {
+		distinct[mtu] = true
+	}
+	mismatched := false
+	switch {
+	case c.ExpectedMTU > 0 && len(listed) > 0:
+		mismatched = true
+		offenders := "Interfaces " + strings.Join(listed, ", ") + " do"
+		if len(listed) == 1 {
+			offenders = "Interface " + listed[0] + " does"
+		}
+		problems = slices.Insert(problems, 0, fmt.Sprintf("%s not have the expected MTU of %d.", offenders, c.ExpectedMTU))
+	case c.ExpectedMTU == 0 && len(distinct) > 1:
+		mismatched = true
+		problems = slices.Insert(problems, 0, fmt.Sprintf("Interfaces have differing MTUs: %s.", strings.Join(listed, ", ")))
+	}
+	if len(mtus) > 0 {
+		result.Facts = map[string]any{"mtus": mtus}
+	}
+	if len(problems) > 0 {
+		result.Severity = SeverityWarning
+		result.Message = strings.Join(problems, " ")
+		if mismatched {
+			result.Message += " Mismatched MTUs make the overlay network silently drop large packets, " +
+				"so please fix the switch or network configuration before installing."
+		}
+	}
+	return
+}
+
+// netDevMTU reads the MTU of the interface dev.
+func netDevMTU(env *Env, dev string) (int, error) {
+	path, err := env.netDevPath(dev, "mtu")
+	if err != nil {
+		return 0, err
+	}
+	out, err := env.readFile(path)
+	if err != nil {
+		return 0, err
+	}
+	mtu, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || mtu <= 0 {
+		return 0, &ParseError{Where: "line 1", Text: strings.TrimSpace(string(out)), Reason: "the MTU is not a positive number"}
+	}
+	return mtu, nil
+}
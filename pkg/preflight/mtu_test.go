@@ -0,0 +1,83 @@
+package preflight
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMTUCheck(t *testing.T) {
+	h := testHost()
+	h.root = t.TempDir()
+	net := filepath.Join(h.root, "sys/class/net")
+	for dev, mtu := range map[string]string{"eth0": "1500", "eth1": "1500", "eth2": "9000", "eth3": "9000", "eth4": "1450", "eth5": "garbage"} {
+		writeFile(t, net, dev+"/mtu", mtu+"\n")
+	}
+	const mismatched = " Mismatched MTUs make the overlay network silently drop large packets, " +
+		"so please fix the switch or network configuration before installing."
+
+	tests := []struct {
+		name   string
+		check  MTUCheck
+		result Result
+	}{
+		{
+			name:   "standard",
+			check:  MTUCheck{Devs: []string{"eth0", "eth1"}},
+			result: Result{Facts: map[string]any{"mtus": map[string]int{"eth0": 1500, "eth1": 1500}}},
+		},
+		{
+			name:   "jumbo expected",
+			check:  MTUCheck{Devs: []string{"eth2", "eth3"}, ExpectedMTU: 9000},
+			result: Result{Facts: map[string]any{"mtus": map[string]int{"eth2": 9000, "eth3": 9000}}},
+		},
+		{
+			name:  "differing",
+			check: MTUCheck{Devs: []string{"eth0", "eth2", "eth4"}},
+			result: Result{
+				Severity: SeverityWarning,
+				Message:  "Interfaces have differing MTUs: eth0 (MTU 1500), eth2 (MTU 9000), eth4 (MTU 1450)." + mismatched,
+				Facts:    map[string]any{"mtus": map[string]int{"eth0": 1500, "eth2": 9000, "eth4": 1450}},
+			},
+		},
+		{
+			name:  "not as expected",
+			check: MTUCheck{Devs: []string{"eth0", "eth2", "eth4"}, ExpectedMTU: 9000},
+			result: Result{
+				Severity: SeverityWarning,
+				Message:  "Interfaces eth0 (MTU 1500), eth4 (MTU 1450) do not have the expected MTU of 9000." + mismatched,
+				Facts:    map[string]any{"mtus": map[string]int{"eth0": 1500, "eth2": 9000, "eth4": 1450}},
+			},
+		},
+		{
+			// The rest are still checked
+			name:  "missing",
+			check: MTUCheck{Devs: []string{"eth0", "eth9", "eth4", "eth5"}, ExpectedMTU: 1450},
+			result: Result{
+				Severity: SeverityWarning,
+				Message: "Interface eth0 (MTU 1500) does not have the expected MTU of 1450. Interface eth9 does not exist. " +
+					`Unable to read the MTU of eth5: line 1, the MTU is not a positive number: "garbage".` + mismatched,
+				Facts: map[string]any{"mtus": map[string]int{"eth0": 1500, "eth4": 1450}},
+			},
+		},
+		{
+			name:  "only missing",
+			check: MTUCheck{Devs: []string{"eth9", "../eth0"}},
+			result: Result{
+				Severity: SeverityWarning,
+				Message:  `Interface eth9 does not exist. Unable to read the MTU of ../eth0: invalid interface name "../eth0": it contains a slash, colon, NUL or white space.`,
+			},
+		},
+		{
+			name: "none",
+		},
+	}
+	for _, test := range tests {
+		result, err := test.check.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.name)
+		test.result.Name = "MTU"
+		assert.Equal(t, test.result, result, test.name)
+	}
+}
@@ -0,0 +1,283 @@
+package preflight
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	defaultMTU = 1500
+	// icmpOverhead is the size of the IPv4 and ICMP headers, which ping's
+	// size doesn't include
+	icmpOverhead = 28
+	// minPingSize is the size of the probe which tells whether a target
+	// answers ICMP echo requests at all
+	minPingSize = 64
+	// maxMTUSizes bounds the sizes tried for each target, which is enough
+	// to find the path MTU to the byte for jumbo frames
+	maxMTUSizes = 16
+	// pingAttempts is how many times a size which gets no reply is tried,
+	// so that a lost packet isn't mistaken for one too big
+	pingAttempts = 2
+	pingTimeout  = time.Second
+)
+
+// A pingOutcome is what became of an ICMP echo request.
+type pingOutcome int
+
+const (
+	pingReply pingOutcome = iota
+	pingNoReply
+	// pingTooBig means the request was bigger than the MTU of the
+	// interface it would have been sent on, so it wasn't sent.
+	pingTooBig
+)
+
+var errICMPNotPermitted error = &CheckError{Kind: ErrPermission, Err: errors.New("sending ICMP echo requests is not permitted")}
+
+// A pinger sends ICMP echo requests which mustn't be fragmented.  Size is
+// that of the whole IP packet.  It returns errICMPNotPermitted if the
+// requests can't be sent at all.
+type pinger interface {
+	Ping(ctx context.Context, target net.IP, size int) (pingOutcome, error)
+}
+
+// MTUPathCheck verifies that packets as big as the MTU the install
+// configuration sets for the management network pass all the way to the
+// gateway and, when joining a cluster, to the join server.  A switch on
+// the way with a smaller MTU otherwise only shows up as connections which
+// hang once they carry large packets.  ICMP echo requests which mustn't
+// be fragmented are sent at bracketing sizes, searching for the largest
+// that gets a reply, with a bounded number of probes per target.  A path
+// MTU below the configured one is warned about.  Targets which don't
+// answer even small requests aren't measured, and if ICMP isn't
+// permitted, the check is skipped.  The live environment's interfaces
+// may have a smaller MTU than the configured one, in which case only
+// that much of the path can be measured.  The default MTU isn't checked.
+type MTUPathCheck struct {
+	MTU     int
+	Gateway string
+	// ServerURL is that of the join server, if joining a cluster.
+	ServerURL string
+	Pinger    pinger
+	Resolver  hostResolver
+}
+
+// NewMTUPathCheck returns an MTUPathCheck for the management network in
+// the given install configuration.
+func NewMTUPathCheck(cfg *config.HarvesterConfig) MTUPathCheck {
+	check := MTUPathCheck{
+		MTU:      cfg.ManagementInterface.MTU,
+		Pinger:   commandPinger{Timeout: pingTimeout},
+		Resolver: net.DefaultResolver,
+	}
+	if cfg.ManagementInterface.Method == config.NetworkMethodStatic {
+		check.Gateway = cfg.ManagementInterface.Gateway
+	}
+	if cfg.Install.Mode == config.ModeJoin {
+		check.ServerURL = cfg.ServerURL
+	}
+	return check
+}
+
+func (c MTUPathCheck) Modes() []RunMode {
+	return anyMode
+}
+
+// An mtuTarget is a host the path MTU is measured to.
+type mtuTarget struct {
+	name string
+	ip   net.IP
+}
+
+func (c MTUPathCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "MTUPath"
+	if c.MTU == 0 || c.MTU == defaultMTU {
+		result.Message = "Skipped: the management network uses the default MTU."
+		return
+	}
+
+	var targets []mtuTarget
+	gateway := net.ParseIP(c.Gateway).To4()
+	if gateway == nil {
+		// Without a static gateway, that of the live environment's
+		// default route is most likely the management network's
+		gateway = defaultGateway(env)
+	}
+	if gateway != nil {
+		targets = append(targets, mtuTarget{"Gateway " + gateway.String(), gateway})
+	}
+	var skipped []string
+	if u, problem := parseServerURL(c.ServerURL); c.ServerURL != "" && problem == "" {
+		host := u.Hostname()
+		switch ip := net.ParseIP(host).To4(); {
+		case env.Options.AirGapped && onInternet(host):
+			skipped = append(skipped, notContacted("Join server "+host))
+			result.AirGapped = true
+		case ip != nil:
+			targets = append(targets, mtuTarget{"Join server " + host, ip})
+		default:
+			lookupCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+			addrs, _ := c.Resolver.LookupHost(lookupCtx, host)
+			cancel()
+			for _, addr := range addrs {
+				if ip = net.ParseIP(addr).To4(); ip != nil {
+					break
+				}
+			}
+			if ip == nil {
+				skipped = append(skipped, fmt.Sprintf("Join server %s: cannot resolve, so the path MTU to it was not measured.", host))
+			} else {
+				targets = append(targets, mtuTarget{fmt.Sprintf("Join server %s (%s)", host, ip), ip})
+			}
+		}
+	}
+	if len(targets) == 0 {
+		result.Message = "Skipped: there is no gateway or join server to measure the path MTU to."
+		if len(skipped) > 0 {
+			result.Message = "Skipped: " + strings.Join(skipped, " ")
+		}
+		return
+	}
+
+	var findings []string
+	for _, target := range targets {
+		finding, severity, err := c.measure(ctx, target)
+		if errors.Is(err, errICMPNotPermitted) {
+			result.Severity = SeverityOK
+			result.Message = fmt.Sprintf("Skipped: %v, so the path MTU cannot be measured.", err)
+			return result, nil
+		} else if err != nil {
+			return result, err
+		}
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, finding)
+	}
+	result.Message = strings.Join(append(findings, skipped...), " ")
+	return
+}
+
+// measure searches for the largest packet which passes to target, up to
+// the configured MTU, describing how it compares.
+func (c MTUPathCheck) measure(ctx context.Context, target mtuTarget) (string, Severity, error) {
+	sizes := 0
+	tooBig := false
+	passes := func(size int) (bool, error) {
+		sizes++
+		for attempt := 0; attempt < pingAttempts; attempt++ {
+			outcome, err := c.Pinger.Ping(ctx, target.ip, size)
+			if err != nil {
+				return false, err
+			}
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			switch outcome {
+			case pingReply:
+				return true, nil
+			case pingTooBig:
+				tooBig = true
+				return false, nil
+			}
+		}
+		return false, nil
+	}
+
+	if ok, err := passes(minPingSize); err != nil {
+		return "", SeverityOK, err
+	} else if !ok {
+		return fmt.Sprintf("%s: does not answer ICMP echo requests, so the path MTU to it was not measured.", target.name), SeverityOK, nil
+	}
+	if ok, err := passes(c.MTU); err != nil {
+		return "", SeverityOK, err
+	} else if ok {
+		return fmt.Sprintf("%s: packets of %d bytes pass, as the configured MTU needs.", target.name, c.MTU), SeverityOK, nil
+	}
+
+	// The largest passing size is in [passing, failing)
+	passing, failing := minPingSize, c.MTU
+	for failing-passing > 1 && sizes < maxMTUSizes {
+		size := passing + (failing-passing)/2
+		ok, err := passes(size)
+		if err != nil {
+			return "", SeverityOK, err
+		}
+		if ok {
+			passing = size
+		} else {
+			failing = size
+		}
+	}
+	largest := fmt.Sprintf("%d bytes", passing)
+	if failing-passing > 1 {
+		largest = "at least " + largest
+	}
+	if tooBig {
+		return fmt.Sprintf("%s: the largest packet that passes is %s, because the live environment's interface has a smaller MTU "+
+			"than the configured %d, so the rest of the path was not measured.", target.name, largest, c.MTU), SeverityInfo, nil
+	}
+	return fmt.Sprintf("%s: the largest packet that passes is %s, but the configured MTU is %d, "+
+		"so larger packets will be dropped on the way.", target.name, largest, c.MTU), SeverityWarning, nil
+}
+
+// defaultGateway returns the gateway of the host's IPv4 default route, if
+// it has one.
+func defaultGateway(env *Env) net.IP {
+	data, err := env.readFile(filepath.Join(env.host().root, "proc/net/route"))
+	if err != nil {
+		return nil
+	}
+	// After a header line, each line is the interface, then the
+	// destination and the gateway as little-endian hex, and so on
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[2])
+		if err != nil || len(gateway) != 4 || binary.LittleEndian.Uint32(gateway) == 0 {
+			continue
+		}
+		return net.IPv4(gateway[3], gateway[2], gateway[1], gateway[0]).To4()
+	}
+	return nil
+}
+
+// commandPinger pings with ping(8), waiting Timeout for each reply.
+type commandPinger struct {
+	Timeout time.Duration
+	// Exec makes the ping commands, or is exec.Command if nil.
+	Exec execFunc
+}
+
+func (p commandPinger) Ping(_ context.Context, target net.IP, size int) (pingOutcome, error) {
+	out, err := p.Exec.cmd("/usr/bin/ping", "-n", "-c", "1", "-W", strconv.Itoa(int(p.Timeout.Seconds())),
+		"-M", "do", "-s", strconv.Itoa(size-icmpOverhead), target.String()).CombinedOutput()
+	text := strings.ToLower(string(out))
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return pingReply, nil
+	case strings.Contains(text, "operation not permitted") || strings.Contains(text, "permission denied"):
+		return pingNoReply, errICMPNotPermitted
+	case strings.Contains(text, "message too long"):
+		return pingTooBig, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return pingNoReply, nil
+	case exitErr == nil:
+		// It didn't run at all
+		return pingNoReply, toolError("/usr/bin/ping", err)
+	}
+	return pingNoReply, fmt.Errorf("ping %s: %s", target, strings.TrimSpace(string(out)))
+}
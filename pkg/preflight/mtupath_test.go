@@ -0,0 +1,220 @@
+package preflight
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// fakePinger passes packets up to the path MTU of each target, and can't
+// send any bigger than localMTU, if that's set.  The first request of
+// each size in lost gets no reply.
+type fakePinger struct {
+	pathMTU  map[string]int
+	localMTU int
+	denied   bool
+	lost     map[int]bool
+	sent     []int
+}
+
+func (p *fakePinger) Ping(_ context.Context, target net.IP, size int) (pingOutcome, error) {
+	p.sent = append(p.sent, size)
+	switch mtu, ok := p.pathMTU[target.String()]; {
+	case p.denied:
+		return pingNoReply, errICMPNotPermitted
+	case p.localMTU > 0 && size > p.localMTU:
+		return pingTooBig, nil
+	case p.lost[size]:
+		p.lost[size] = false
+		return pingNoReply, nil
+	case ok && size <= mtu:
+		return pingReply, nil
+	}
+	return pingNoReply, nil
+}
+
+func TestNewMTUPathCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.Mode = config.ModeJoin
+	cfg.ServerURL = "https://10.0.5.5"
+	cfg.ManagementInterface = config.Network{Method: config.NetworkMethodStatic, Gateway: "192.168.1.1", MTU: 9000}
+	check := NewMTUPathCheck(cfg)
+	assert.Equal(t, 9000, check.MTU)
+	assert.Equal(t, "192.168.1.1", check.Gateway)
+	assert.Equal(t, "https://10.0.5.5", check.ServerURL)
+
+	cfg.Install.Mode = config.ModeCreate
+	cfg.ManagementInterface.Method = config.NetworkMethodDHCP
+	check = NewMTUPathCheck(cfg)
+	assert.Empty(t, check.Gateway)
+	assert.Empty(t, check.ServerURL)
+}
+
+func TestMTUPathCheck(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/mtu-path"
+	resolver := fakeResolver{"rancher.example.com": {"2001:db8::5", "10.0.5.5"}}
+
+	tests := []struct {
+		name      string
+		mtu       int
+		gateway   string
+		serverURL string
+		pinger    *fakePinger
+		options   Options
+		severity  Severity
+		message   string
+		sent      []int
+	}{
+		{
+			name:    "default MTU",
+			mtu:     1500,
+			gateway: "192.168.1.1",
+			pinger:  &fakePinger{},
+			message: "Skipped: the management network uses the default MTU.",
+		},
+		{
+			name:    "jumbo frames pass",
+			mtu:     9000,
+			gateway: "192.168.1.1",
+			pinger:  &fakePinger{pathMTU: map[string]int{"192.168.1.1": 9000}},
+			message: "Gateway 192.168.1.1: packets of 9000 bytes pass, as the configured MTU needs.",
+			sent:    []int{64, 9000},
+		},
+		{
+			name:    "lost request",
+			mtu:     9000,
+			gateway: "192.168.1.1",
+			pinger:  &fakePinger{pathMTU: map[string]int{"192.168.1.1": 9000}, lost: map[int]bool{9000: true}},
+			message: "Gateway 192.168.1.1: packets of 9000 bytes pass, as the configured MTU needs.",
+			sent:    []int{64, 9000, 9000},
+		},
+		{
+			name:     "switch only passes 1500",
+			mtu:      9000,
+			gateway:  "192.168.1.1",
+			pinger:   &fakePinger{pathMTU: map[string]int{"192.168.1.1": 1500}},
+			severity: SeverityWarning,
+			message: "Gateway 192.168.1.1: the largest packet that passes is 1500 bytes, but the configured MTU is 9000, " +
+				"so larger packets will be dropped on the way.",
+		},
+		{
+			name:      "gateway passes, join server doesn't",
+			mtu:       9000,
+			gateway:   "192.168.1.1",
+			serverURL: "https://rancher.example.com",
+			pinger:    &fakePinger{pathMTU: map[string]int{"192.168.1.1": 9000, "10.0.5.5": 8000}},
+			severity:  SeverityWarning,
+			message: "Gateway 192.168.1.1: packets of 9000 bytes pass, as the configured MTU needs. " +
+				"Join server rancher.example.com (10.0.5.5): the largest packet that passes is 8000 bytes, but the configured MTU is 9000, " +
+				"so larger packets will be dropped on the way.",
+		},
+		{
+			name:     "live environment's MTU is smaller",
+			mtu:      9000,
+			gateway:  "192.168.1.1",
+			pinger:   &fakePinger{pathMTU: map[string]int{"192.168.1.1": 9000}, localMTU: 1500},
+			severity: SeverityInfo,
+			message: "Gateway 192.168.1.1: the largest packet that passes is 1500 bytes, " +
+				"because the live environment's interface has a smaller MTU than the configured 9000, so the rest of the path was not measured.",
+		},
+		{
+			name:      "no ICMP echo replies",
+			mtu:       9000,
+			gateway:   "192.168.1.1",
+			serverURL: "10.0.5.5:6443",
+			pinger:    &fakePinger{pathMTU: map[string]int{"10.0.5.5": 9000}},
+			message: "Gateway 192.168.1.1: does not answer ICMP echo requests, so the path MTU to it was not measured. " +
+				"Join server 10.0.5.5: packets of 9000 bytes pass, as the configured MTU needs.",
+		},
+		{
+			name:    "ICMP not permitted",
+			mtu:     9000,
+			gateway: "192.168.1.1",
+			pinger:  &fakePinger{denied: true},
+			message: "Skipped: sending ICMP echo requests is not permitted, so the path MTU cannot be measured.",
+			sent:    []int{64},
+		},
+		{
+			name:    "gateway of the default route",
+			mtu:     9000,
+			pinger:  &fakePinger{pathMTU: map[string]int{"192.168.1.1": 9000}},
+			message: "Gateway 192.168.1.1: packets of 9000 bytes pass, as the configured MTU needs.",
+			sent:    []int{64, 9000},
+		},
+		{
+			name:      "air-gapped",
+			mtu:       9000,
+			gateway:   "192.168.1.1",
+			serverURL: "https://saftos.rancher.com",
+			pinger:    &fakePinger{pathMTU: map[string]int{"192.168.1.1": 9000}},
+			options:   Options{AirGapped: true},
+			message: "Gateway 192.168.1.1: packets of 9000 bytes pass, as the configured MTU needs. " +
+				"Join server saftos.rancher.com is on the internet, so it was not contacted in air-gapped mode.",
+			sent: []int{64, 9000},
+		},
+		{
+			name:      "join server unresolvable",
+			mtu:       9000,
+			gateway:   "192.168.1.1",
+			serverURL: "https://unknown.example.com",
+			pinger:    &fakePinger{pathMTU: map[string]int{"192.168.1.1": 9000}},
+			message: "Gateway 192.168.1.1: packets of 9000 bytes pass, as the configured MTU needs. " +
+				"Join server unknown.example.com: cannot resolve, so the path MTU to it was not measured.",
+			sent: []int{64, 9000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := MTUPathCheck{MTU: tt.mtu, Gateway: tt.gateway, ServerURL: tt.serverURL, Pinger: tt.pinger, Resolver: resolver}
+			result, err := check.Evaluate(context.Background(), &Env{Options: tt.options, machine: h})
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "MTUPath", Severity: tt.severity, Message: tt.message, AirGapped: tt.options.AirGapped}, result)
+			if tt.sent != nil {
+				assert.Equal(t, tt.sent, tt.pinger.sent)
+			}
+			targets := 1
+			if tt.serverURL != "" {
+				targets = 2
+			}
+			assert.LessOrEqual(t, len(tt.pinger.sent), targets*maxMTUSizes*pingAttempts, "the probes must be bounded")
+		})
+	}
+
+	h.root = "./testdata/nonexistent"
+	result, err := MTUPathCheck{MTU: 9000, Pinger: &fakePinger{}}.Evaluate(context.Background(), &Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "MTUPath", Message: "Skipped: there is no gateway or join server to measure the path MTU to."}, result)
+}
+
+func TestCommandPinger(t *testing.T) {
+	gateway := net.IPv4(192, 168, 1, 1)
+	for key, expected := range map[string]struct {
+		outcome pingOutcome
+		err     string
+	}{
+		"ping-reply":         {outcome: pingReply},
+		"ping-frag-needed":   {outcome: pingNoReply},
+		"ping-too-long":      {outcome: pingTooBig},
+		"ping-not-permitted": {outcome: pingNoReply, err: errICMPNotPermitted.Error()},
+		"ping-unreachable":   {outcome: pingNoReply, err: "ping 192.168.1.1: ping: connect: Network is unreachable"},
+	} {
+		outcome, err := commandPinger{Timeout: pingTimeout, Exec: fakeCommand(key)}.Ping(context.Background(), gateway, 9000)
+		assert.Equal(t, expected.outcome, outcome, key)
+		if expected.err == "" {
+			assert.Nil(t, err, key)
+		} else {
+			assert.EqualError(t, err, expected.err, key)
+		}
+	}
+
+	missing := func(string, ...string) *exec.Cmd { return exec.Command("/nonexistent/ping") }
+	_, err := commandPinger{Timeout: pingTimeout, Exec: missing}.Ping(context.Background(), gateway, 9000)
+	assert.ErrorIs(t, err, ErrToolMissing)
+}
@@ -0,0 +1,212 @@
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// errInvalidInterfaceName is returned, wrapped, for an interface name the
+// kernel wouldn't allow, which can only have come from a mistyped or
+// malicious configuration.
+var errInvalidInterfaceName = errors.New("invalid interface name")
+
+// validateInterfaceName returns an error wrapping errInvalidInterfaceName
+// unless name is one the kernel would give an interface, which is the
+// same test as its dev_valid_name(): it has to fit in IFNAMSIZ with its
+// terminating NUL, can't be "." or "..", and can't contain a slash, colon
+// or white space.
+func validateInterfaceName(name string) error {
+	reason := ""
+	switch {
+	case name == "":
+		reason = "it is empty"
+	case len(name) >= unix.IFNAMSIZ:
+		reason = fmt.Sprintf("it is longer than %d bytes", unix.IFNAMSIZ-1)
+	case name == "." || name == "..":
+		reason = "it is a directory"
+	case strings.ContainsAny(name, "/:\x00\t\n\v\f\r "):
+		reason = "it contains a slash, colon, NUL or white space"
+	default:
+		return nil
+	}
+	return fmt.Errorf(networkSpeedInvalidNameError.Format, errInvalidInterfaceName, name, reason)
+}
+
+// netDevPath returns the path of file in the sysfs directory of the
+// network interface dev, e.g. /sys/class/net/eth0/speed.  Checks which
+// look at a particular interface build their paths with it, since the
+// name may come from the install configuration, so it's validated, and
+// the path is made sure to be under /sys/class/net.
+func (e *Env) netDevPath(dev, file string) (string, error) {
+	if err := validateInterfaceName(dev); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(e.host().root, "sys/class/net")
+	path := filepath.Join(dir, dev, file)
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w %q: %s is not under %s", errInvalidInterfaceName, dev, path, dir)
+	}
+	return path, nil
+}
+
+// maxNestedNetDevs is how deeply interfaces are followed down to those
+// they're stacked on, which is the kernel's own limit, MAX_NEST_DEV.
+const maxNestedNetDevs = 8
+
+// linkNetDevs returns the interfaces whose link speeds make up dev's.
+// Bonds, bridges and VLANs don't have a meaningful speed of their own, so
+// they're followed down to the NICs they're stacked on: the active slave
+// of an active-backup bond, or else all its slaves, the ports of a bridge
+// and the parent of a VLAN.  Anything else is its own link.
+func linkNetDevs(env *Env, dev string) ([]string, error) {
+	return linkNetDevsNested(env, dev, 0)
+}
+
+func linkNetDevsNested(env *Env, dev string, depth int) ([]string, error) {
+	if depth > maxNestedNetDevs {
+		return nil, parseErrorf(networkSpeedNestedError.Format, dev, maxNestedNetDevs)
+	}
+	lower, err := lowerNetDevs(env, dev)
+	if err != nil || len(lower) == 0 {
+		return []string{dev}, err
+	}
+	var links []string
+	for _, l := range lower {
+		devs, err := linkNetDevsNested(env, l, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devs {
+			if !slices.Contains(links, d) {
+				links = append(links, d)
+			}
+		}
+	}
+	return links, nil
+}
+
+// lowerNetDevs returns the interfaces dev is directly stacked on, if it's
+// a bond, bridge or VLAN, as linkNetDevs follows them.
+func lowerNetDevs(env *Env, dev string) ([]string, error) {
+	dir, err := env.netDevPath(dev, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := env.stat(filepath.Join(dir, "bonding/slaves")); err == nil {
+		return bondSlaves(env, dev, dir)
+	}
+	if _, err := env.stat(filepath.Join(dir, "bridge")); err == nil {
+		ports, err := readDirNames(env, filepath.Join(dir, "brif"))
+		if err != nil {
+			return nil, err
+		}
+		if len(ports) == 0 {
+			return nil, parseErrorf(networkSpeedNoLinksError.Format, dev, "ports")
+		}
+		return ports, nil
+	}
+	// A VLAN, or a macvlan and the like, links to its parent
+	names, err := readDirNames(env, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var lower []string
+	for _, name := range names {
+		if parent, ok := strings.CutPrefix(name, "lower_"); ok {
+			lower = append(lower, parent)
+		}
+	}
+	return lower, nil
+}
+
+// bondSlaves returns the slaves of the bond dev, whose sysfs directory is
+// dir, which are those whose speed is the bond's: only the active slave,
+// in active-backup mode, as that's the only one carrying traffic, and
+// otherwise all of them.  Those aren't summed, even though LACP and the
+// balancing modes spread traffic over them, so that a degraded bond can't
+// pass for a healthy one.
+func bondSlaves(env *Env, dev, dir string) ([]string, error) {
+	out, err := env.readFile(filepath.Join(dir, "bonding/slaves"))
+	if err != nil {
+		return nil, err
+	}
+	slaves := strings.Fields(string(out))
+	if len(slaves) == 0 {
+		return nil, parseErrorf(networkSpeedNoLinksError.Format, dev, "slaves")
+	}
+	// The mode is the name and number, e.g. "active-backup 1"
+	mode, err := env.readFile(filepath.Join(dir, "bonding/mode"))
+	if err != nil {
+		return nil, err
+	}
+	if fields := strings.Fields(string(mode)); len(fields) == 0 || fields[0] != "active-backup" {
+		return slaves, nil
+	}
+	// There's no active slave while none has a link, in which case any
+	// might become active
+	active, err := env.readFile(filepath.Join(dir, "bonding/active_slave"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if active := strings.TrimSpace(string(active)); active != "" {
+		return []string{active}, nil
+	}
+	return slaves, nil
+}
+
+// readDirNames returns the names of the entries in the directory at path.
+func readDirNames(env *Env, path string) ([]string, error) {
+	entries, err := env.readDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// netDevState returns the message NetworkSpeedCheck gives instead of the
+// speed of the interface dev, if it hasn't one worth reading: if it's
+// wireless, or has no link.  The kernel only knows the speed of a NIC
+// with a carrier, and reading the carrier of one which is
+// administratively down fails with EINVAL.  An interface without an
+// operstate or carrier, as in older captures, is assumed to be up.
+func netDevState(env *Env, dev string) (*MessageTemplate, error) {
+	dir, err := env.netDevPath(dev, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := env.stat(filepath.Join(dir, "wireless")); err == nil {
+		return &networkSpeedWirelessMessage, nil
+	}
+	operstate, err := env.readFile(filepath.Join(dir, "operstate"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	switch strings.TrimSpace(string(operstate)) {
+	case "down", "lowerlayerdown", "notpresent":
+		return &networkSpeedNoLinkMessage, nil
+	}
+	carrier, err := env.readFile(filepath.Join(dir, "carrier"))
+	switch {
+	case errors.Is(err, unix.EINVAL):
+		return &networkSpeedNoLinkMessage, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case strings.TrimSpace(string(carrier)) == "0":
+		return &networkSpeedNoLinkMessage, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,74 @@
+package preflight
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateInterfaceName(t *testing.T) {
+	for _, name := range []string{"eth0", "enp0s31f6", "br-mgmt", "mgmt-bo.100", "..eth0", strings.Repeat("e", 15)} {
+		assert.Nil(t, validateInterfaceName(name), name)
+	}
+
+	for name, expected := range map[string]string{
+		"":                      `invalid interface name "": it is empty`,
+		strings.Repeat("e", 16): `invalid interface name "eeeeeeeeeeeeeeee": it is longer than 15 bytes`,
+		".":                     `invalid interface name ".": it is a directory`,
+		"..":                    `invalid interface name "..": it is a directory`,
+		"../../kernel":          `invalid interface name "../../kernel": it contains a slash, colon, NUL or white space`,
+		"eth0:1":                `invalid interface name "eth0:1": it contains a slash, colon, NUL or white space`,
+		"eth 0":                 `invalid interface name "eth 0": it contains a slash, colon, NUL or white space`,
+		"eth0\x00":              `invalid interface name "eth0\x00": it contains a slash, colon, NUL or white space`,
+	} {
+		err := validateInterfaceName(name)
+		assert.ErrorIs(t, err, errInvalidInterfaceName, name)
+		assert.EqualError(t, err, expected, name)
+	}
+}
+
+func TestNetDevPath(t *testing.T) {
+	env := &Env{}
+
+	path, err := env.netDevPath("eth0", "speed")
+	assert.Nil(t, err)
+	assert.Equal(t, "/sys/class/net/eth0/speed", path)
+
+	path, err = env.netDevPath("..eth0", "speed")
+	assert.Nil(t, err)
+	assert.Equal(t, "/sys/class/net/..eth0/speed", path)
+
+	for _, dev := range []string{"", "..", "../../kernel", "/etc/passwd", strings.Repeat("e", 64)} {
+		_, err := env.netDevPath(dev, "speed")
+		assert.ErrorIs(t, err, errInvalidInterfaceName, dev)
+	}
+
+	// Even a valid name can't be combined with a file outside its directory
+	_, err = env.netDevPath("eth0", "../../../../etc/passwd")
+	assert.ErrorIs(t, err, errInvalidInterfaceName)
+}
+
+// Stacked interfaces whose links can't be found are parse failures, like
+// a malformed speed.
+func TestLinkNetDevsErrors(t *testing.T) {
+	h := testHost()
+	h.root = t.TempDir()
+	net := filepath.Join(h.root, "sys/class/net")
+	writeFile(t, net, "bond1/bonding/slaves", "\n")
+	assert.Nil(t, os.MkdirAll(filepath.Join(net, "br1/bridge"), 0o755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(net, "br1/brif"), 0o755))
+	writeFile(t, net, "veth9/lower_veth9", "")
+
+	for dev, expected := range map[string]string{
+		"bond1": "unable to determine the link speed of bond1, as it has no slaves",
+		"br1":   "unable to determine the link speed of br1, as it has no ports",
+		"veth9": "unable to determine the link speed of veth9, as it's stacked more than 8 interfaces deep",
+	} {
+		_, err := linkNetDevs(&Env{machine: h}, dev)
+		assert.ErrorIs(t, err, ErrParseFailure, dev)
+		assert.EqualError(t, err, expected, dev)
+	}
+}
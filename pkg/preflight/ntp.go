@@ -0,0 +1,236 @@
+package preflight
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	ntpPort    = 123
+	ntpTimeout = 3 * time.Second
+	// ntpEpochOffset is the number of seconds between the NTP epoch,
+	// 1900, and the Unix epoch
+	ntpEpochOffset = 2208988800
+)
+
+// A hostResolver looks up the addresses of host names, like
+// net.Resolver.
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// ConfiguredNTPCheck verifies that the NTP servers in the install
+// configuration work, since they're what the installed system will keep
+// using, long after anyone is watching.  Each server name is resolved with
+// the live environment's resolver, and each of its addresses is probed
+// with SNTP until one answers.  The clock offset each server reports is
+// given.  Fewer than two servers answering is warned about, because then
+// there's nothing to cross-check a bad server against, and none answering
+// is fatal.  Configurations without NTP servers, as is usual when
+// air-gapped, are skipped.  When the host is air-gapped, servers on the
+// internet aren't probed, but those on site still are.
+type ConfiguredNTPCheck struct {
+	Servers  []string
+	Resolver hostResolver
+	// Port is the NTP port, which is only changed by tests.
+	Port    int
+	Timeout time.Duration
+}
+
+// NewConfiguredNTPCheck returns a ConfiguredNTPCheck for the NTP servers
+// in the given install configuration.
+func NewConfiguredNTPCheck(cfg *config.HarvesterConfig) ConfiguredNTPCheck {
+	return ConfiguredNTPCheck{
+		Servers:  cfg.OS.NTPServers,
+		Resolver: net.DefaultResolver,
+		Port:     ntpPort,
+		Timeout:  ntpTimeout,
+	}
+}
+
+func (c ConfiguredNTPCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c ConfiguredNTPCheck) readsFrom() []string {
+	return []string{"ResolvConf"}
+}
+
+func (c ConfiguredNTPCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "ConfiguredNTP"
+	if len(c.Servers) == 0 {
+		result.Message = "Skipped: the install configuration does not set any NTP servers."
+		if env.Options.AirGapped {
+			result.Message = "Skipped: the install configuration does not set any NTP servers, as expected when air-gapped."
+			result.AirGapped = true
+		}
+		return
+	}
+
+	var findings, skipped []string
+	responding := 0
+	for _, server := range c.Servers {
+		if env.Options.AirGapped && onInternet(server) {
+			skipped = append(skipped, notContacted(server))
+			result.AirGapped = true
+			continue
+		}
+		finding, ok := c.probe(ctx, env, server)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if ok {
+			responding++
+		}
+		findings = append(findings, finding)
+	}
+	if len(findings) == 0 {
+		result.Message = "Skipped: " + strings.Join(skipped, " ")
+		return
+	}
+
+	resolver := "the system resolver"
+	if len(env.Inventory.Nameservers) > 0 {
+		resolver = strings.Join(env.Inventory.Nameservers, ", ")
+	}
+	summary := fmt.Sprintf("%d of %d queried NTP servers responded (names resolved via %s).",
+		responding, len(findings), resolver)
+	switch {
+	case responding == 0:
+		result.Severity = SeverityFatal
+		summary += " The installed system will not be able to keep its clock in sync."
+		if env.Options.AirGapped {
+			summary += " The host is air-gapped, so the NTP servers must be on site."
+			result.AirGapped = true
+		}
+	case responding < 2:
+		result.Severity = SeverityWarning
+		summary += " At least two are needed to detect a server with the wrong time."
+	}
+	result.Message = summary + " " + strings.Join(append(findings, skipped...), " ")
+	return
+}
+
+// probe resolves server and queries its addresses until one responds,
+// describing the outcome.  ok is true if the server responded.
+func (c ConfiguredNTPCheck) probe(ctx context.Context, env *Env, server string) (finding string, ok bool) {
+	addr, offset, err := queryNTPServer(ctx, c.Resolver, server, c.Port, c.Timeout, env.host().now)
+	if err != nil {
+		return fmt.Sprintf("%s: %v.", server, err), false
+	}
+	return fmt.Sprintf("%s (%s): reachable, offset %s.", server, addr, formatOffset(offset)), true
+}
+
+// queryNTPServer resolves server and queries its addresses with SNTP
+// until one responds, returning which did, and the offset it gave.
+func queryNTPServer(ctx context.Context, resolver hostResolver, server string, port int, timeout time.Duration, now func() time.Time) (addr string, offset time.Duration, err error) {
+	var addrs []string
+	if net.ParseIP(server) != nil {
+		addrs = []string{server}
+	} else {
+		lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+		addrs, err = resolver.LookupHost(lookupCtx, server)
+		cancel()
+		if err != nil {
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) {
+				err = errors.New(dnsErr.Err)
+			}
+			return "", 0, fmt.Errorf("cannot resolve: %w", err)
+		}
+	}
+
+	var failures []string
+	for _, addr := range addrs {
+		offset, err := sntpQuery(ctx, net.JoinHostPort(addr, strconv.Itoa(port)), timeout, now)
+		if err == nil {
+			return addr, offset, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s %v", addr, err))
+	}
+	return "", 0, fmt.Errorf("unreachable (%s)", strings.Join(failures, "; "))
+}
+
+// formatOffset formats a clock offset with its sign, to the millisecond.
+func formatOffset(offset time.Duration) string {
+	s := offset.Round(time.Millisecond).String()
+	if offset >= 0 {
+		s = "+" + s
+	}
+	return s
+}
+
+// sntpQuery queries the NTP server at addr as an SNTP (RFC 4330) client,
+// returning how far the local clock is behind the server's.
+func sntpQuery(ctx context.Context, addr string, timeout time.Duration, now func() time.Time) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	request := make([]byte, 48)
+	// Leap indicator 0, version 4, mode 3 (client)
+	request[0] = 0<<6 | 4<<3 | 3
+	sent := now()
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(sent))
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	response := make([]byte, 48)
+	for {
+		n, err := conn.Read(response)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, net.ErrClosed) || isTimeout(err) {
+			return 0, fmt.Errorf("no response within %s", timeout)
+		} else if err != nil {
+			return 0, err
+		}
+		received := now()
+		// Anything that isn't the server's reply to this request is
+		// ignored, as RFC 4330 requires
+		if n < 48 || response[0]&0x7 != 4 || binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]) {
+			continue
+		}
+		if stratum := response[1]; stratum == 0 {
+			return 0, fmt.Errorf("refused the request (kiss code %q)", strings.TrimRight(string(response[12:16]), "\x00"))
+		} else if stratum > 15 {
+			return 0, errors.New("is not synchronized")
+		}
+		serverReceived := fromNTPTime(binary.BigEndian.Uint64(response[32:]))
+		serverSent := fromNTPTime(binary.BigEndian.Uint64(response[40:]))
+		return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// toNTPTime converts t to an NTP timestamp: seconds since 1900 in the
+// upper 32 bits, and the fraction of a second in the lower.
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(secs, nanos)
+}
@@ -0,0 +1,186 @@
+package preflight
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// sntpBehaviour is how a fake SNTP server responds
+type sntpBehaviour int
+
+const (
+	sntpOK sntpBehaviour = iota
+	sntpSilent
+	sntpKissOfDeath
+	sntpUnsynchronized
+)
+
+// newFakeSNTPServer starts an SNTP server on ip and port which behaves as
+// told, and whose clock reads clock.  It's stopped, and has stopped
+// answering, by the time the test's cleanups have run.
+func newFakeSNTPServer(t *testing.T, ip string, port int, behaviour sntpBehaviour, clock time.Time) {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 || behaviour == sntpSilent {
+				continue
+			}
+			response := make([]byte, 48)
+			// Version 4, mode 4 (server)
+			response[0] = 4<<3 | 4
+			switch behaviour {
+			case sntpKissOfDeath:
+				copy(response[12:], "RATE")
+			case sntpUnsynchronized:
+				response[1] = 16
+			default:
+				response[1] = 2
+			}
+			copy(response[24:32], buf[40:48])
+			serverTime := toNTPTime(clock)
+			binary.BigEndian.PutUint64(response[32:], serverTime)
+			binary.BigEndian.PutUint64(response[40:], serverTime)
+			conn.WriteTo(response, addr)
+		}
+	}()
+}
+
+// fakeResolver resolves names from a map
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// freeUDPPort returns a UDP port which is free on the loopback addresses
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestNewConfiguredNTPCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.OS.NTPServers = []string{"0.suse.pool.ntp.org", "1.suse.pool.ntp.org"}
+	check := NewConfiguredNTPCheck(cfg)
+	assert.Equal(t, cfg.OS.NTPServers, check.Servers)
+	assert.Equal(t, net.DefaultResolver, check.Resolver)
+	assert.Equal(t, ntpPort, check.Port)
+	assert.Equal(t, ntpTimeout, check.Timeout)
+}
+
+func TestConfiguredNTPCheck(t *testing.T) {
+	h := testHost()
+	fixed := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	h.now = func() time.Time { return fixed }
+
+	port := freeUDPPort(t)
+	newFakeSNTPServer(t, "127.0.0.1", port, sntpOK, fixed.Add(2*time.Second))
+	newFakeSNTPServer(t, "127.0.0.2", port, sntpOK, fixed.Add(-150*time.Millisecond))
+	newFakeSNTPServer(t, "127.0.0.3", port, sntpSilent, fixed)
+	newFakeSNTPServer(t, "127.0.0.4", port, sntpKissOfDeath, fixed)
+	newFakeSNTPServer(t, "127.0.0.5", port, sntpUnsynchronized, fixed)
+	resolver := fakeResolver{
+		"ntp1.example.com":   {"127.0.0.1"},
+		"ntp2.example.com":   {"127.0.0.2"},
+		"silent.example.com": {"127.0.0.3"},
+		// The first address of a pool not responding doesn't matter
+		"pool.example.com": {"127.0.0.3", "127.0.0.2"},
+		"kod.example.com":  {"127.0.0.4"},
+	}
+
+	tests := []struct {
+		name      string
+		servers   []string
+		airGapped bool
+		severity  Severity
+		message   string
+	}{
+		{
+			name:    "none",
+			message: "Skipped: the install configuration does not set any NTP servers.",
+		},
+		{
+			name:      "none air-gapped",
+			airGapped: true,
+			message:   "Skipped: the install configuration does not set any NTP servers, as expected when air-gapped.",
+		},
+		{
+			name:    "all responding",
+			servers: []string{"ntp1.example.com", "ntp2.example.com", "pool.example.com"},
+			message: "3 of 3 queried NTP servers responded (names resolved via 10.0.0.2). " +
+				"ntp1.example.com (127.0.0.1): reachable, offset +2s. " +
+				"ntp2.example.com (127.0.0.2): reachable, offset -150ms. " +
+				"pool.example.com (127.0.0.2): reachable, offset -150ms.",
+		},
+		{
+			name:     "one responding",
+			servers:  []string{"ntp1.example.com", "silent.example.com", "typo.example.com", "127.0.0.5"},
+			severity: SeverityWarning,
+			message: "1 of 4 queried NTP servers responded (names resolved via 10.0.0.2). " +
+				"At least two are needed to detect a server with the wrong time. " +
+				"ntp1.example.com (127.0.0.1): reachable, offset +2s. " +
+				"silent.example.com: unreachable (127.0.0.3 no response within 200ms). " +
+				"typo.example.com: cannot resolve: no such host. " +
+				"127.0.0.5: unreachable (127.0.0.5 is not synchronized).",
+		},
+		{
+			name:      "none responding",
+			servers:   []string{"kod.example.com", "silent.example.com"},
+			airGapped: true,
+			severity:  SeverityFatal,
+			message: "0 of 2 queried NTP servers responded (names resolved via 10.0.0.2). " +
+				"The installed system will not be able to keep its clock in sync. " +
+				"The host is air-gapped, so the NTP servers must be on site. " +
+				"kod.example.com: unreachable (127.0.0.4 refused the request (kiss code \"RATE\")). " +
+				"silent.example.com: unreachable (127.0.0.3 no response within 200ms).",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := ConfiguredNTPCheck{Servers: tt.servers, Resolver: resolver, Port: port, Timeout: 200 * time.Millisecond}
+			env := &Env{Options: Options{AirGapped: tt.airGapped}, machine: h}
+			env.Inventory.Nameservers = []string{"10.0.0.2"}
+			result, err := check.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, "ConfiguredNTP", result.Name)
+			assert.Equal(t, tt.severity, result.Severity)
+			assert.Equal(t, tt.message, result.Message)
+		})
+	}
+}
+
+func TestNTPTime(t *testing.T) {
+	ts := time.Date(2026, 3, 14, 15, 9, 26, 535897932, time.UTC)
+	assert.WithinDuration(t, ts, fromNTPTime(toNTPTime(ts)), time.Nanosecond)
+	// The NTP epoch is 1900
+	assert.Equal(t, uint64(0), toNTPTime(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
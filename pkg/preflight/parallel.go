@@ -0,0 +1,152 @@
+package preflight
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// An inventoryReader is a check which reads what other checks record in
+// the Inventory, named by their Results, e.g. "Cmdline".  When checks
+// are run in parallel, it waits for those of them which come before it.
+type inventoryReader interface {
+	readsFrom() []string
+}
+
+// RunParallel is like Run, except that up to concurrency checks are
+// evaluated at once, which shortens the run on slow hosts, where most of
+// it is spent waiting for external tools.  The Results are still in the
+// order of the checks, and logged in it, as each is known.
+//
+// Each check is evaluated with an Env of its own, which shares the tool
+// cache, and starts with what the checks which had finished recorded in
+// the Inventory.  What it records is merged back when it finishes.  A
+// check which reads what others record waits for those before it to
+// finish, so it sees the same Inventory as it would in Run.
+// BenchmarkChecks load the host, and would measure each other, so they
+// run one at a time after the rest.
+func (r *Runner) RunParallel(ctx context.Context, concurrency int) Report {
+	if concurrency <= 1 {
+		return r.Run(ctx)
+	}
+	checks := r.selected()
+	env := &Env{Options: r.Options, execCommand: r.ExecCommand, machine: r.machine}
+	env.gather(ctx, checks)
+	report := r.newReport(len(checks), env.host().now())
+	report.Results = report.Results[:len(checks)]
+
+	// mu guards env, and what's reported
+	var mu sync.Mutex
+	finished := make([]chan struct{}, len(checks))
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+	logged := 0
+	finish := func(i int, result Result, cancelled bool) {
+		report.Results[i] = result
+		report.Cancelled = report.Cancelled || cancelled
+		close(finished[i])
+		for logged < len(checks) && isClosed(finished[logged]) {
+			logResult(report.Results[logged])
+			logged++
+		}
+	}
+
+	var wg sync.WaitGroup
+	queue := make(chan int)
+	for w := 0; w < min(concurrency, len(checks)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				for _, j := range dependencies(checks, i) {
+					<-finished[j]
+				}
+				mu.Lock()
+				fork := env.fork()
+				mu.Unlock()
+				before := fork.Inventory
+				result, cancelled := r.evaluate(ctx, checks[i], fork, nil)
+				mu.Lock()
+				env.merge(fork, before)
+				finish(i, result, cancelled)
+				mu.Unlock()
+			}
+		}()
+	}
+	for i, check := range checks {
+		if !isBenchmark(check) {
+			queue <- i
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	budget := newTimeBudget(r.Options.benchmarkBudget(), checks, env.host().now)
+	for i, check := range checks {
+		if isBenchmark(check) {
+			result, cancelled := r.evaluate(ctx, check, env, budget)
+			finish(i, result, cancelled)
+		}
+	}
+	report.Inventory = &env.Inventory
+	return report
+}
+
+// dependencies returns the indices of the checks before the i'th which it
+// reads what they record in the Inventory of.  BenchmarkChecks don't
+// count, as they run after the rest.
+func dependencies(checks []ResultCheck, i int) []int {
+	reader, ok := checks[i].(inventoryReader)
+	if !ok {
+		return nil
+	}
+	var deps []int
+	for _, name := range reader.readsFrom() {
+		for j, check := range checks[:i] {
+			if resultName(check) == name && !isBenchmark(check) {
+				deps = append(deps, j)
+			}
+		}
+	}
+	return deps
+}
+
+// isClosed returns whether ch is closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// fork returns an Env for evaluating a check alongside others, which
+// shares e's tool cache, and starts with what e has learned so far.
+func (e *Env) fork() *Env {
+	fork := *e
+	fork.tools = e.cache()
+	fork.ctx = nil
+	fork.evidence = nil
+	return &fork
+}
+
+// merge records in e what was learned in fork, since its Inventory was
+// before: the fields of the Inventory which changed, and the outcomes of
+// what's only done once per run, unless e already has them.
+func (e *Env) merge(fork *Env, before Inventory) {
+	inventory := reflect.ValueOf(&e.Inventory).Elem()
+	was, is := reflect.ValueOf(before), reflect.ValueOf(fork.Inventory)
+	for i := 0; i < inventory.NumField(); i++ {
+		if !reflect.DeepEqual(was.Field(i).Interface(), is.Field(i).Interface()) {
+			inventory.Field(i).Set(is.Field(i))
+		}
+	}
+	if e.dmiRecords == nil && e.dmiErr == nil {
+		e.dmiRecords, e.dmiErr = fork.dmiRecords, fork.dmiErr
+	}
+	if !e.systemBusProbed {
+		e.systemBusProbed, e.systemBusErr = fork.systemBusProbed, fork.systemBusErr
+	}
+}
@@ -0,0 +1,171 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// rendezvousCheck waits for all of the rendezvousChecks sharing arrived
+// to start, or gives up after a second, as it would if they ran one at a
+// time.
+type rendezvousCheck struct {
+	name    string
+	arrived *atomic.Int32
+	of      int32
+}
+
+func (c rendezvousCheck) Evaluate(_ context.Context, _ *Env) (Result, error) {
+	c.arrived.Add(1)
+	for deadline := time.Now().Add(time.Second); c.arrived.Load() < c.of; {
+		if time.Now().After(deadline) {
+			return Result{Name: c.name, Severity: SeverityFatal, Message: "Alone."}, nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return Result{Name: c.name, Message: "Together."}, nil
+}
+
+// writerCheck records the host's name in the inventory after a while,
+// and readerCheck reads it.
+type writerCheck struct{}
+
+func (c writerCheck) Evaluate(_ context.Context, env *Env) (Result, error) {
+	time.Sleep(50 * time.Millisecond)
+	env.Inventory.MachineID = "f00dfeed"
+	return Result{Name: "Writer"}, nil
+}
+
+type readerCheck struct{}
+
+func (c readerCheck) readsFrom() []string {
+	return []string{"writer"}
+}
+
+func (c readerCheck) Evaluate(_ context.Context, env *Env) (Result, error) {
+	return Result{Name: "Reader", Message: "Read " + env.Inventory.MachineID + "."}, nil
+}
+
+type panickingCheck struct{}
+
+func (c panickingCheck) Evaluate(context.Context, *Env) (Result, error) {
+	var nics []NIC
+	return Result{Name: nics[1].Name}, nil
+}
+
+func TestRunParallel(t *testing.T) {
+	var arrived atomic.Int32
+	runner := Runner{Checks: []ResultCheck{
+		rendezvousCheck{"First", &arrived, 3},
+		writerCheck{},
+		rendezvousCheck{"Second", &arrived, 3},
+		readerCheck{},
+		panickingCheck{},
+		rendezvousCheck{"Third", &arrived, 3},
+		fakeCheck{result: Result{Name: "Last", Severity: SeverityWarning, Message: "meh"}},
+	}}
+	report := runner.RunParallel(context.Background(), 4)
+	assert.False(t, report.Cancelled)
+	var results []string
+	for _, result := range report.Results {
+		results = append(results, fmt.Sprintf("%s %s %s%s", result.Name, result.Severity, result.Message, result.Error))
+	}
+	assert.Equal(t, []string{
+		// The checks ran at the same time, but are reported in order
+		"First pass Together.",
+		"Writer pass ",
+		"Second pass Together.",
+		// The reader waited for what the writer records
+		"Reader pass Read f00dfeed.",
+		// A check which panics only fails itself
+		"panicking pass check panicked: runtime error: index out of range [1] with length 0",
+		"Third pass Together.",
+		"Last warn meh",
+	}, results)
+	assert.Equal(t, "f00dfeed", report.Inventory.MachineID)
+
+	// One at a time is how Run runs them
+	arrived.Store(0)
+	report = runner.RunParallel(context.Background(), 1)
+	assert.Equal(t, "Alone.", report.Results[0].Message)
+	assert.Equal(t, "Read f00dfeed.", report.Results[3].Message)
+	assert.Equal(t, "check panicked: runtime error: index out of range [1] with length 0", report.Results[4].Error)
+
+	// A cancelled run runs nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report = runner.RunParallel(ctx, 4)
+	assert.True(t, report.Cancelled)
+	assert.Len(t, report.Results, 7)
+	for _, result := range report.Results {
+		assert.Equal(t, "Skipped: run cancelled.", result.Message, result.Name)
+	}
+}
+
+// slowCheck takes delay longer to evaluate than the check it wraps, which
+// it's reported as.
+type slowCheck struct {
+	ResultCheck
+	delay time.Duration
+}
+
+func (c slowCheck) Unwrap() ResultCheck {
+	return c.ResultCheck
+}
+
+func (c slowCheck) Evaluate(ctx context.Context, env *Env) (Result, error) {
+	time.Sleep(c.delay)
+	return c.ResultCheck.Evaluate(ctx, env)
+}
+
+// Running the install configuration's checks in parallel reports what
+// running them in order does, in particular for the storage checks, which
+// look at the installation device ConfigDeviceCheck records.
+func TestRunParallelConfigChecks(t *testing.T) {
+	h, command := loadFixture("testdata/previous-install/harvester")
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.Device = "/dev/sda"
+	checks := ConfigChecks(cfg)
+	for i, check := range checks {
+		// Slow enough that anything which doesn't wait for it misses it
+		if _, ok := check.(ConfigDeviceCheck); ok {
+			checks[i] = slowCheck{check, 50 * time.Millisecond}
+		}
+	}
+	runner := Runner{Checks: checks, Options: Options{AirGapped: true}, ExecCommand: command, machine: h}
+	expected := runner.Run(context.Background())
+	assert.Equal(t, "sda", expected.Inventory.InstallDevice)
+
+	for i := 0; i < 3; i++ {
+		report := runner.RunParallel(context.Background(), 8)
+		for i := range report.Results {
+			expected.Results[i].Duration, report.Results[i].Duration = 0, 0
+		}
+		expected.Timestamp = report.Timestamp
+		assert.Equal(t, expected, report)
+	}
+}
+
+// BenchmarkRunParallel compares running checks whose tools are slow one
+// at a time with running them in parallel.
+func BenchmarkRunParallel(b *testing.B) {
+	var checks []ResultCheck
+	for i := 0; i < 8; i++ {
+		tool := fmt.Sprintf("/usr/bin/tool%d", i)
+		checks = append(checks, probingCheck{tool, func(env *Env) { _, _ = env.output(tool) }})
+	}
+	runner := Runner{Checks: checks, ExecCommand: fakeCommand("slow")}
+	for _, concurrency := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runner.RunParallel(context.Background(), concurrency)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The parsers here read what the kernel and firmware say about the host's
+// memory and network, which usually, but not always, look the way they're
+// documented to.  They're fuzzed (see parse_test.go), so whatever they're
+// given, they don't panic, and anything they can't make sense of is
+// rejected with a ParseError saying which line it was, rather than read
+// as something it isn't.
+
+// A ParseError is a line of input which couldn't be made sense of.  It's
+// ErrParseFailure.
+type ParseError struct {
+	// Where says where the line is, e.g. "line 3", or which record it's
+	// in.
+	Where string
+	// Text is the offending line, without its line ending.
+	Text string
+	// Reason says what's wrong with it.
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s, %s: %q", e.Where, e.Reason, e.Text)
+}
+
+func (e *ParseError) Unwrap() error {
+	return ErrParseFailure
+}
+
+// errNoMemTotal is returned by ParseProcMeminfoKiB if there's no MemTotal
+// line at all, so there's no line to blame.
+var errNoMemTotal = parseErrorf("no MemTotal line")
+
+// errNoMemoryRanges is returned by ParseDmidecodeMemoryKiB if there are
+// no Memory Array Mapped Address records at all.
+var errNoMemoryRanges = parseErrorf("no Memory Array Mapped Address records")
+
+// errLinkSpeedUnknown is returned by ParseLinkSpeedMbps for the kernel's
+// SPEED_UNKNOWN, which the drivers of virtual NICs, and those of physical
+// ones without a link, report.
+var errLinkSpeedUnknown = &ParseError{Where: "line 1", Text: "-1", Reason: "the speed is unknown"}
+
+// ParseProcMeminfoKiB returns MemTotal from r, which is in the format of
+// /proc/meminfo, e.g. "MemTotal:       32856640 kB".  Only the first
+// MemTotal line counts.  It must be in kB, and the amount must be more
+// than nothing, and few enough bytes to count in a uint.
+func ParseProcMeminfoKiB(r io.Reader) (kib uint, err error) {
+	found := false
+	lineNo := 0
+	if _, scanErr := scanLines(r, func(line string) bool {
+		lineNo++
+		key, value, _ := strings.Cut(line, ":")
+		if strings.TrimSpace(key) != "MemTotal" {
+			return true
+		}
+		found = true
+		kib, err = parseMemTotal(value)
+		if err != nil {
+			err = &ParseError{Where: fmt.Sprintf("line %d", lineNo), Text: line, Reason: err.Error()}
+		}
+		return false
+	}); scanErr != nil {
+		return 0, scanErr
+	}
+	if !found {
+		return 0, errNoMemTotal
+	}
+	if err != nil {
+		return 0, err
+	}
+	return kib, nil
+}
+
+// parseMemTotal parses the value of a MemTotal line, e.g. "  1024 kB".
+func parseMemTotal(value string) (uint, error) {
+	fields := strings.Fields(value)
+	switch {
+	case len(fields) != 2:
+		return 0, errors.New("MemTotal is not an amount in kB")
+	case fields[1] != "kB":
+		return 0, fmt.Errorf("MemTotal is in %q, not kB", fields[1])
+	}
+	kib, err := parseCount(fields[0])
+	if err != nil {
+		return 0, fmt.Errorf("MemTotal %s", err)
+	}
+	if kib == 0 {
+		return 0, errors.New("MemTotal is zero")
+	}
+	if kib > math.MaxUint>>10 {
+		return 0, errors.New("MemTotal overflows")
+	}
+	return uint(kib), nil
+}
+
+// parseCount parses a decimal count, saying whether one which isn't is
+// negative, too big, or not a number at all.
+func parseCount(s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	switch {
+	case err == nil:
+		return n, nil
+	case strings.HasPrefix(s, "-") && strings.Trim(s[1:], "0123456789") == "" && len(s) > 1:
+		return 0, errors.New("is negative")
+	case errors.Is(err, strconv.ErrRange):
+		return 0, errors.New("overflows")
+	}
+	return 0, errors.New("is not a number")
+}
+
+// dmiSizeShifts are how far a size in each of dmiSizeUnits is shifted to
+// give KiB, or right shifted, for bytes.
+var dmiSizeShifts = map[string]int{"bytes": -10, "kB": 0, "MB": 10, "GB": 20}
+
+// ParseDmidecodeMemoryKiB returns the RAM installed, in KiB, according
+// to the output of dmidecode, i.e. the total Range Size of its Memory
+// Array Mapped Address (DMI type 19) records.  Records it can't count,
+// whether because their Range Size isn't a size, is in a unit dmidecode
+// doesn't use, or would overflow the total, are left out of it, and
+// returned as ParseErrors, joined, rather than silently counted as
+// nothing.  The total is still returned with them, as it's what the
+// other records say.  If there are no records at all, the error is
+// errNoMemoryRanges.
+func ParseDmidecodeMemoryKiB(output []byte) (uint, error) {
+	records, _ := parseDMIDecode(string(output))
+	var ranges []dmiRecord
+	for _, record := range records {
+		if record.Type == 19 {
+			ranges = append(ranges, record)
+		}
+	}
+	if len(ranges) == 0 {
+		return 0, errNoMemoryRanges
+	}
+	kib, ignored := memoryRangesKiB(ranges)
+	var errs []error
+	for _, e := range ignored {
+		errs = append(errs, e)
+	}
+	return kib, errors.Join(errs...)
+}
+
+// memoryRangesKiB adds up the Range Size of each of the Memory Array
+// Mapped Address records.  Records it can't count are left out, and
+// returned as errors.
+func memoryRangesKiB(records []dmiRecord) (kib uint, ignored []*ParseError) {
+	for _, record := range records {
+		value := record.Fields["Range Size"]
+		ignore := func(reason string) {
+			ignored = append(ignored, &ParseError{
+				Where:  "Memory Array Mapped Address " + record.Handle,
+				Text:   "Range Size: " + value,
+				Reason: reason,
+			})
+		}
+		size, unit, err := parseDMISize(value)
+		if err != nil {
+			ignore(err.Error())
+			continue
+		}
+		shift, ok := dmiSizeShifts[unit]
+		if !ok {
+			// If we've somehow got a Memory Array Mapped Address with
+			// one of the enormous units, let's just pretend we've got
+			// a terabyte of RAM and be done with it ;-)
+			logrus.Infof("Found Memory Array Mapped Address with Range Size %d %s, assuming 1 TiB RAM for preflight check", size, unit)
+			return 1 << 30, ignored
+		}
+		if size > math.MaxUint {
+			ignore("the size overflows")
+			continue
+		}
+		rangeSize := uint(size)
+		if shift < 0 {
+			rangeSize >>= -shift
+		} else if rangeSize > math.MaxUint>>shift {
+			ignore("the size overflows")
+			continue
+		} else {
+			rangeSize <<= shift
+		}
+		if kib+rangeSize < kib {
+			ignore("the total overflows")
+			continue
+		}
+		kib += rangeSize
+	}
+	return kib, ignored
+}
+
+// ParseLinkSpeedMbps returns the link speed in a network interface's
+// speed file in sysfs, e.g. "1000\n".  The kernel reports it as an int,
+// so a speed which isn't a positive one is rejected, with
+// errLinkSpeedUnknown for its SPEED_UNKNOWN.
+func ParseLinkSpeedMbps(data string) (int, error) {
+	line, rest, _ := strings.Cut(strings.TrimSpace(data), "\n")
+	if rest != "" {
+		next, _, _ := strings.Cut(rest, "\n")
+		return 0, &ParseError{Where: "line 2", Text: next, Reason: "the speed is more than one line"}
+	}
+	line = strings.TrimSpace(line)
+	if line == "-1" {
+		return 0, errLinkSpeedUnknown
+	}
+	speed, err := parseCount(line)
+	switch {
+	case err != nil:
+		return 0, &ParseError{Where: "line 1", Text: line, Reason: "the speed " + err.Error()}
+	case speed == 0:
+		return 0, &ParseError{Where: "line 1", Text: line, Reason: "the speed is zero"}
+	case speed > math.MaxInt32:
+		return 0, &ParseError{Where: "line 1", Text: line, Reason: "the speed overflows"}
+	}
+	return int(speed), nil
+}
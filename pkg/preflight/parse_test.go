@@ -0,0 +1,243 @@
+package preflight
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcMeminfoKiB(t *testing.T) {
+	for path, expected := range map[string]uint{
+		"testdata/meminfo-512MiB": 458112,
+		"testdata/meminfo-32GiB":  32856640,
+		"testdata/meminfo-64GiB":  65758888,
+	} {
+		kib, err := ParseProcMeminfoKiB(strings.NewReader(readFile(t, path)))
+		assert.Nil(t, err, path)
+		assert.Equal(t, expected, kib, path)
+	}
+
+	kib, err := ParseProcMeminfoKiB(strings.NewReader("MemFree: 1 kB\nMemTotal:1024 kB\r\nMemTotal: 2048 kB\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1024), kib)
+
+	_, err = ParseProcMeminfoKiB(strings.NewReader("MemFree: 1024 kB\n"))
+	assert.Equal(t, errNoMemTotal, err)
+	assert.ErrorIs(t, err, ErrParseFailure)
+
+	for meminfo, expected := range map[string]string{
+		"MemFree: 1 kB\nMemTotal: -1024 kB\n":            `line 2, MemTotal is negative: "MemTotal: -1024 kB"`,
+		"MemTotal: 18446744073709551616 kB\n":            `line 1, MemTotal overflows: "MemTotal: 18446744073709551616 kB"`,
+		"MemTotal: 18014398509481984 kB\n":               `line 1, MemTotal overflows: "MemTotal: 18014398509481984 kB"`,
+		"MemTotal: 0 kB\n":                               `line 1, MemTotal is zero: "MemTotal: 0 kB"`,
+		"MemTotal: 32 GB\n":                              `line 1, MemTotal is in "GB", not kB: "MemTotal: 32 GB"`,
+		"MemTotal: 1024\n":                               `line 1, MemTotal is not an amount in kB: "MemTotal: 1024"`,
+		"MemTotal:\n":                                    `line 1, MemTotal is not an amount in kB: "MemTotal:"`,
+		"MemTotal: lots kB\n":                            `line 1, MemTotal is not a number: "MemTotal: lots kB"`,
+		"MemTotal: 0x400 kB\n":                           `line 1, MemTotal is not a number: "MemTotal: 0x400 kB"`,
+		"MemTotal: 1024 kB\x00\n":                        `line 1, MemTotal is in "kB\x00", not kB: "MemTotal: 1024 kB\x00"`,
+		"MemTotal: " + strings.Repeat("9", 1<<17) + "\n": `line 1, MemTotal is not an amount in kB: "MemTotal: ` + strings.Repeat("9", maxToolLine-10) + ` [output truncated]"`,
+	} {
+		_, err := ParseProcMeminfoKiB(strings.NewReader(meminfo))
+		var parseErr *ParseError
+		assert.True(t, errors.As(err, &parseErr), meminfo)
+		assert.ErrorIs(t, err, ErrParseFailure, meminfo)
+		assert.EqualError(t, err, expected)
+	}
+}
+
+// ignoredRanges returns what's wrong with each of the ranges
+// ParseDmidecodeMemoryKiB couldn't count.
+func ignoredRanges(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	assert.True(t, ok, err)
+	var reasons []string
+	for _, e := range joined.Unwrap() {
+		var parseErr *ParseError
+		assert.True(t, errors.As(e, &parseErr), e)
+		assert.ErrorIs(t, e, ErrParseFailure)
+		reasons = append(reasons, e.Error())
+	}
+	return reasons
+}
+
+func TestParseDmidecodeMemoryKiB(t *testing.T) {
+	kib, err := ParseDmidecodeMemoryKiB([]byte(readDMIFixture(t, "dell-poweredge-r750.txt")))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(256<<20), kib)
+
+	kib, err = ParseDmidecodeMemoryKiB([]byte(readDMIFixture(t, "corrupted.txt")))
+	assert.Equal(t, uint(10<<20), kib)
+	assert.Equal(t, []string{
+		`Memory Array Mapped Address 0x1301, "lots" is not a size: "Range Size: lots"`,
+		`Memory Array Mapped Address 0x1302, "GiB" is not a size unit dmidecode uses: "Range Size: 4 GiB"`,
+	}, ignoredRanges(t, err))
+
+	// Nothing to go on
+	for _, out := range []string{"", readDMIFixture(t, "corrupted.txt")[:200], "# dmidecode 3.5\n# No SMBIOS nor DMI entry point found, sorry.\n"} {
+		kib, err = ParseDmidecodeMemoryKiB([]byte(out))
+		assert.Zero(t, kib)
+		assert.Equal(t, errNoMemoryRanges, err, out)
+		assert.ErrorIs(t, err, ErrParseFailure)
+	}
+
+	ranges := func(sizes ...string) []byte {
+		var out strings.Builder
+		for i, size := range sizes {
+			out.WriteString("Handle 0x130" + string(rune('0'+i)) + ", DMI type 19, 31 bytes\n" +
+				"Memory Array Mapped Address\n" +
+				"\tStarting Address: 0x0000000100000000k\n" +
+				"\tRange Size: " + size + "\n\n")
+		}
+		return []byte(out.String())
+	}
+	tests := []struct {
+		sizes   []string
+		kib     uint
+		ignored []string
+	}{
+		{[]string{"2 GB", "510 GB"}, 512 << 20, nil},
+		{[]string{"2048 bytes", "640 kB", "1 MB"}, 2 + 640 + 1024, nil},
+		{[]string{"16 GB", "16 GB", "16 GB", "16 GB"}, 64 << 20, nil},
+		{[]string{"2 GB", "3 TB"}, 1 << 30, nil},
+		{[]string{"1 EB"}, 1 << 30, nil},
+		{[]string{"4 GiB", "8 GB"}, 8 << 20, []string{`Memory Array Mapped Address 0x1300, "GiB" is not a size unit dmidecode uses: "Range Size: 4 GiB"`}},
+		{[]string{"4 QB"}, 0, []string{`Memory Array Mapped Address 0x1300, "QB" is not a size unit dmidecode uses: "Range Size: 4 QB"`}},
+		{[]string{"-2 GB", "4 GB"}, 4 << 20, []string{`Memory Array Mapped Address 0x1300, "-2 GB" is negative: "Range Size: -2 GB"`}},
+		{[]string{"17592186044416 GB"}, 0, []string{`Memory Array Mapped Address 0x1300, the size overflows: "Range Size: 17592186044416 GB"`}},
+		{[]string{"17592186044415 GB", "1 GB"}, math.MaxUint >> 20 << 20, []string{`Memory Array Mapped Address 0x1301, the total overflows: "Range Size: 1 GB"`}},
+		{[]string{"18446744073709551616 bytes"}, 0, []string{`Memory Array Mapped Address 0x1300, "18446744073709551616 bytes" overflows: "Range Size: 18446744073709551616 bytes"`}},
+		{[]string{""}, 0, []string{`Memory Array Mapped Address 0x1300, "" is not a size: "Range Size: "`}},
+	}
+	for _, tt := range tests {
+		kib, err := ParseDmidecodeMemoryKiB(ranges(tt.sizes...))
+		assert.Equal(t, tt.kib, kib, tt.sizes)
+		assert.Equal(t, tt.ignored, ignoredRanges(t, err), tt.sizes)
+	}
+}
+
+func TestParseLinkSpeedMbps(t *testing.T) {
+	for data, expected := range map[string]int{
+		"1000\n":     1000,
+		" 25000 \n":  25000,
+		"2500":       2500,
+		"2147483647": math.MaxInt32,
+	} {
+		speed, err := ParseLinkSpeedMbps(data)
+		assert.Nil(t, err, data)
+		assert.Equal(t, expected, speed, data)
+	}
+
+	_, err := ParseLinkSpeedMbps("-1\n")
+	assert.Equal(t, errLinkSpeedUnknown, err)
+
+	for data, expected := range map[string]string{
+		"-100\n":               `line 1, the speed is negative: "-100"`,
+		"0\n":                  `line 1, the speed is zero: "0"`,
+		"2147483648\n":         `line 1, the speed overflows: "2147483648"`,
+		"4294967295\n":         `line 1, the speed overflows: "4294967295"`,
+		"1e3\n":                `line 1, the speed is not a number: "1e3"`,
+		"\n":                   `line 1, the speed is not a number: ""`,
+		"1000\n1000\n":         `line 2, the speed is more than one line: "1000"`,
+		"99999999999999999999": `line 1, the speed overflows: "99999999999999999999"`,
+	} {
+		_, err := ParseLinkSpeedMbps(data)
+		assert.ErrorIs(t, err, ErrParseFailure, data)
+		assert.EqualError(t, err, expected, data)
+	}
+}
+
+// readFile returns the contents of the file at path.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+// The parsers are fuzzed from what real machines have said, and the
+// oddities known about.  Inputs the fuzzer has found problems with are in
+// testdata/fuzz, so they're tried by every run of the tests.
+
+func FuzzParseProcMeminfoKiB(f *testing.F) {
+	for _, path := range []string{"testdata/meminfo-512MiB", "testdata/meminfo-32GiB", "testdata/meminfo-64GiB"} {
+		data, err := os.ReadFile(path)
+		assert.Nil(f, err)
+		f.Add(string(data))
+	}
+	// Without MemTotal at all
+	f.Add("MemFree:         1048576 kB\nMemAvailable:    2097152 kB\n")
+	f.Add("MemTotal: -1 kB\n")
+	f.Add("MemTotal:\t18014398509481983 kB\n")
+	f.Fuzz(func(t *testing.T, meminfo string) {
+		kib, err := ParseProcMeminfoKiB(strings.NewReader(meminfo))
+		if err != nil {
+			assert.ErrorIs(t, err, ErrParseFailure)
+			assert.Zero(t, kib)
+			return
+		}
+		assert.NotZero(t, kib)
+		assert.LessOrEqual(t, kib, uint(math.MaxUint>>10))
+	})
+}
+
+func FuzzParseDmidecodeMemoryKiB(f *testing.F) {
+	fixtures, err := filepath.Glob("testdata/dmidecode/*.txt")
+	assert.Nil(f, err)
+	for _, path := range fixtures {
+		data, err := os.ReadFile(path)
+		assert.Nil(f, err)
+		f.Add(string(data))
+	}
+	for _, key := range []string{"dmidecode-8GiB", "dmidecode-32GiB", "dmidecode-64GiB"} {
+		f.Add(execOutputs[key].output)
+	}
+	// Addresses with dmidecode's "k" suffix, and units nobody has
+	f.Add("Handle 0x0025, DMI type 19, 31 bytes\nMemory Array Mapped Address\n\tStarting Address: 0x0000000100000000k\n\tEnding Address: 0x000000807FFFFFFFk\n\tRange Size: 510 GB\n")
+	f.Add("Handle 0x0025, DMI type 19, 31 bytes\nMemory Array Mapped Address\n\tRange Size: 2 ZB\n")
+	f.Add("Handle 0x0025, DMI type 19, 31 bytes\nMemory Array Mapped Address\n\tRange Size: -2 GB\n")
+	f.Fuzz(func(t *testing.T, out string) {
+		_, err := ParseDmidecodeMemoryKiB([]byte(out))
+		records, _ := parseDMIDecode(out)
+		ranges := 0
+		for _, record := range records {
+			if record.Type == 19 {
+				ranges++
+			}
+		}
+		if ranges == 0 {
+			assert.Equal(t, errNoMemoryRanges, err)
+			return
+		}
+		ignored := ignoredRanges(t, err)
+		assert.LessOrEqual(t, len(ignored), ranges)
+		for _, reason := range ignored {
+			assert.Contains(t, reason, `: "Range Size: `)
+		}
+	})
+}
+
+func FuzzParseLinkSpeedMbps(f *testing.F) {
+	for _, speed := range []string{"100\n", "1000\n", "2500\n", "10000\n", "25000\n", "-1\n", "0\n", "4294967295\n", "fast\n"} {
+		f.Add(speed)
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		speed, err := ParseLinkSpeedMbps(data)
+		if err != nil {
+			assert.ErrorIs(t, err, ErrParseFailure)
+			assert.Zero(t, speed)
+			return
+		}
+		assert.Positive(t, speed)
+		assert.LessOrEqual(t, speed, math.MaxInt32)
+	})
+}
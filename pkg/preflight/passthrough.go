@@ -0,0 +1,151 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// PassthroughReadinessCheck verifies that the PCI devices the install
+// configuration says will be passed through to VMs actually can be.
+// Each device must exist and be in an IOMMU group, and every other
+// endpoint in the group has to be passed through along with it, so
+// groups shared with e.g. the USB controller are a problem.  vfio-pci
+// must also be available.  If no devices are configured, there's nothing
+// to check.
+type PassthroughReadinessCheck struct {
+	Devices []config.PCIDevice
+}
+
+// NewPassthroughReadinessCheck returns a PassthroughReadinessCheck for the
+// passthrough devices in the given install configuration.
+func NewPassthroughReadinessCheck(cfg *config.HarvesterConfig) PassthroughReadinessCheck {
+	return PassthroughReadinessCheck{Devices: cfg.Install.PCIPassthrough}
+}
+
+func (c PassthroughReadinessCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "PassthroughReadiness"
+	if len(c.Devices) == 0 {
+		return
+	}
+
+	var addresses []string
+	for _, dev := range c.Devices {
+		addresses = append(addresses, normalizePCIAddress(dev.Address))
+	}
+
+	var problems []string
+	for _, address := range addresses {
+		dev, err := env.readPCIDevice(address)
+		if errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, fmt.Sprintf("%s does not exist.", address))
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		if dev.IOMMUGroup == "" {
+			problems = append(problems, fmt.Sprintf("%s is not in an IOMMU group, because the IOMMU is not enabled. "+
+				"Please enable VT-d or AMD-Vi in the firmware settings.", address))
+			continue
+		}
+		others, err := iommuGroupCompanions(env, dev, addresses)
+		if err != nil {
+			return result, err
+		}
+		if len(others) > 0 {
+			problems = append(problems, fmt.Sprintf("%s is in IOMMU group %s with %s, which would have to be passed through as well.",
+				address, dev.IOMMUGroup, strings.Join(others, ", ")))
+		}
+	}
+
+	loadable, err := vfioPCILoadable(env)
+	if err != nil {
+		return
+	}
+	if !loadable {
+		problems = append(problems, "The vfio-pci module is not available.")
+	}
+
+	if len(problems) > 0 {
+		result.Severity = SeverityFatal
+		result.Message = "PCI passthrough will not work as configured. " + strings.Join(problems, " ")
+		return
+	}
+	result.Message = fmt.Sprintf("PCI devices %s are ready for passthrough.", strings.Join(addresses, ", "))
+	return
+}
+
+// normalizePCIAddress adds the domain to an address if it's missing,
+// e.g. "01:00.0" becomes "0000:01:00.0".
+func normalizePCIAddress(address string) string {
+	address = strings.ToLower(address)
+	if strings.Count(address, ":") == 1 {
+		return "0000:" + address
+	}
+	return address
+}
+
+// iommuGroupCompanions returns the other endpoints in dev's IOMMU group
+// which aren't among the given addresses, with their classes.  Bridges
+// don't count, because vfio doesn't need them.
+func iommuGroupCompanions(env *Env, dev pciDevice, addresses []string) ([]string, error) {
+	entries, err := env.readDir(filepath.Join(env.host().sysKernelIOMMUGroups, dev.IOMMUGroup, "devices"))
+	if err != nil {
+		return nil, err
+	}
+	var others []string
+	for _, entry := range entries {
+		if entry.Name() == dev.Address || slices.Contains(addresses, entry.Name()) {
+			continue
+		}
+		other, err := env.readPCIDevice(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if other.baseClass() == 0x06 {
+			continue
+		}
+		others = append(others, fmt.Sprintf("%s (%s)", other.Address, pciClassName(other.Class)))
+	}
+	return others, nil
+}
+
+// pciClassNames describe the PCI classes most likely to turn up in an
+// IOMMU group, by base class and subclass
+var pciClassNames = map[uint32]string{
+	0x0100: "SCSI controller",
+	0x0101: "IDE controller",
+	0x0104: "RAID controller",
+	0x0106: "SATA controller",
+	0x0108: "NVMe controller",
+	0x0200: "Ethernet controller",
+	0x0300: "VGA controller",
+	0x0302: "3D controller",
+	0x0403: "audio device",
+	0x0c03: "USB controller",
+	0x0c05: "SMBus controller",
+}
+
+func pciClassName(class uint32) string {
+	if name, ok := pciClassNames[class>>8]; ok {
+		return name
+	}
+	return fmt.Sprintf("class %06x", class)
+}
+
+// vfioPCILoadable returns true if vfio-pci is loaded, or modprobe says it
+// could be.
+func vfioPCILoadable(env *Env) (bool, error) {
+	if _, err := env.stat(filepath.Join(env.host().sysBusPCIDrivers, "vfio-pci")); err == nil {
+		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return moduleLoadable(env, "vfio-pci"), nil
+}
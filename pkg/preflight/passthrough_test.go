@@ -0,0 +1,99 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestPassthroughReadinessCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		name     string
+		fixture  string
+		devices  []config.PCIDevice
+		modprobe string
+		result   Result
+	}{
+		{
+			name:    "nothing configured",
+			fixture: "clean",
+			result:  Result{Name: "PassthroughReadiness"},
+		},
+		{
+			name:    "clean group",
+			fixture: "clean",
+			devices: []config.PCIDevice{{Address: "41:00.0"}, {Address: "0000:41:00.1"}},
+			result: Result{
+				Name:    "PassthroughReadiness",
+				Message: "PCI devices 0000:41:00.0, 0000:41:00.1 are ready for passthrough.",
+			},
+		},
+		{
+			name:    "missing sibling function",
+			fixture: "clean",
+			devices: []config.PCIDevice{{Address: "0000:41:00.0"}},
+			result: Result{
+				Name:     "PassthroughReadiness",
+				Severity: SeverityFatal,
+				Message: "PCI passthrough will not work as configured. " +
+					"0000:41:00.0 is in IOMMU group 30 with 0000:41:00.1 (audio device), which would have to be passed through as well.",
+			},
+		},
+		{
+			name:     "dirty group",
+			fixture:  "dirty",
+			devices:  []config.PCIDevice{{Address: "0000:05:00.0"}, {Address: "0000:06:00.0"}, {Address: "0000:07:00.0"}},
+			modprobe: "modprobe-ok",
+			result: Result{
+				Name:     "PassthroughReadiness",
+				Severity: SeverityFatal,
+				Message: "PCI passthrough will not work as configured. " +
+					"0000:05:00.0 is in IOMMU group 14 with 0000:00:14.0 (USB controller), which would have to be passed through as well. " +
+					"0000:07:00.0 does not exist.",
+			},
+		},
+		{
+			name:     "no vfio-pci",
+			fixture:  "dirty",
+			devices:  []config.PCIDevice{{Address: "0000:06:00.0"}},
+			modprobe: "modprobe-fail",
+			result: Result{
+				Name:     "PassthroughReadiness",
+				Severity: SeverityFatal,
+				Message:  "PCI passthrough will not work as configured. The vfio-pci module is not available.",
+			},
+		},
+		{
+			name:    "no iommu",
+			fixture: "../gpu/nouveau",
+			devices: []config.PCIDevice{{Address: "0000:01:00.0"}},
+			result: Result{
+				Name:     "PassthroughReadiness",
+				Severity: SeverityFatal,
+				Message: "PCI passthrough will not work as configured. " +
+					"0000:01:00.0 is not in an IOMMU group, because the IOMMU is not enabled. Please enable VT-d or AMD-Vi in the firmware settings. " +
+					"The vfio-pci module is not available.",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		dir := "./testdata/passthrough/" + test.fixture + "/sys"
+		h.sysBusPCIDevices = dir + "/bus/pci/devices"
+		h.sysBusPCIDrivers = dir + "/bus/pci/drivers"
+		h.sysKernelIOMMUGroups = dir + "/kernel/iommu_groups"
+		modprobe := test.modprobe
+		if modprobe == "" {
+			modprobe = "modprobe-fail"
+		}
+		env := &Env{execCommand: fakeCommand(modprobe), machine: h}
+		result, err := PassthroughReadinessCheck{Devices: test.devices}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.result, result, test.name)
+	}
+}
@@ -0,0 +1,115 @@
+package preflight
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// PassthroughConfigCheck verifies that the PCI devices the install
+// configuration says will be passed through to VMs are the ones on this
+// host.  Configurations prepared centrally are often copied from a
+// different generation of chassis, where the same card sits at another
+// address.  An address which doesn't exist is fatal.  If the configuration
+// gives the vendor and device IDs it expects, a device with different IDs
+// is warned about.  Either way, the addresses of devices with the expected
+// IDs are suggested.  The host's devices are collected into the inventory
+// if no earlier check has.
+type PassthroughConfigCheck struct {
+	Devices []config.PCIDevice
+}
+
+// NewPassthroughConfigCheck returns a PassthroughConfigCheck for the
+// passthrough devices in the given install configuration.
+func NewPassthroughConfigCheck(cfg *config.HarvesterConfig) PassthroughConfigCheck {
+	return PassthroughConfigCheck{Devices: cfg.Install.PCIPassthrough}
+}
+
+func (c PassthroughConfigCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "PassthroughConfig"
+	if len(c.Devices) == 0 {
+		return
+	}
+	if env.Inventory.PCIDevices == nil {
+		devs, err := env.listPCIDevices()
+		if err != nil {
+			return result, err
+		}
+		env.Inventory.PCIDevices = inventoryPCIDevices(devs)
+	}
+	if len(env.Inventory.PCIDevices) == 0 {
+		result.Message = "Skipped: no PCI devices were found."
+		return
+	}
+
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+	defer func() { result.Message = strings.Join(findings, " ") }()
+
+	for i, want := range c.Devices {
+		path := fmt.Sprintf("install.pci_passthrough[%d]", i)
+		address := normalizePCIAddress(want.Address)
+		vendor, device := normalizePCIID(want.VendorID), normalizePCIID(want.DeviceID)
+		expected := pciID(vendor, device)
+
+		var matches []string
+		if expected != "" {
+			for _, dev := range env.Inventory.PCIDevices {
+				if dev.Address != address && pciIDMatches(dev, vendor, device) {
+					matches = append(matches, dev.Address)
+				}
+			}
+		}
+		elsewhere := ""
+		if len(matches) > 0 {
+			elsewhere = fmt.Sprintf(" %s %s found at %s.", pluralize(len(matches), "device", "devices"),
+				expected, strings.Join(matches, ", "))
+		}
+
+		index := slices.IndexFunc(env.Inventory.PCIDevices, func(dev PCIDevice) bool { return dev.Address == address })
+		switch {
+		case index < 0 && expected != "":
+			finding(SeverityFatal, "%s: %s (expected %s) does not exist on this host.%s", path, address, expected, elsewhere)
+		case index < 0:
+			finding(SeverityFatal, "%s: %s does not exist on this host.", path, address)
+		case expected == "":
+			actual := env.Inventory.PCIDevices[index]
+			finding(SeverityOK, "%s: %s is %s.", path, address, pciID(actual.Vendor, actual.Device))
+		case !pciIDMatches(env.Inventory.PCIDevices[index], vendor, device):
+			actual := env.Inventory.PCIDevices[index]
+			finding(SeverityWarning, "%s: %s is %s, not the expected %s.%s",
+				path, address, pciID(actual.Vendor, actual.Device), expected, elsewhere)
+		default:
+			finding(SeverityOK, "%s: %s is %s as expected.", path, address, expected)
+		}
+	}
+	return
+}
+
+// normalizePCIID returns a vendor or device ID as sysfs has it, e.g.
+// "0x10DE" becomes "10de".
+func normalizePCIID(id string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(id)), "0x")
+}
+
+// pciID formats a vendor and device ID pair the way lspci does, with
+// "*" for a part that isn't known, or returns "" if neither is.
+func pciID(vendor, device string) string {
+	if vendor == "" && device == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s", cmp.Or(vendor, "*"), cmp.Or(device, "*"))
+}
+
+// pciIDMatches returns true if dev has the given IDs, either of which
+// matches any device if it's empty.
+func pciIDMatches(dev PCIDevice, vendor, device string) bool {
+	return (vendor == "" || dev.Vendor == vendor) && (device == "" || dev.Device == device)
+}
@@ -0,0 +1,98 @@
+package preflight
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestPassthroughConfigCheck(t *testing.T) {
+	data, err := os.ReadFile("./testdata/passthrough-config/config.yaml")
+	assert.Nil(t, err)
+	cfg, err := config.LoadHarvesterConfig(data)
+	assert.Nil(t, err)
+	check := NewPassthroughConfigCheck(cfg)
+	assert.Equal(t, PassthroughConfigCheck{Devices: []config.PCIDevice{
+		{Address: "0000:3b:00.0", VendorID: "10de", DeviceID: "1eb8"},
+		{Address: "5e:00.0", VendorID: "0x10DE", DeviceID: "0x2236"},
+		{Address: "0000:d8:00.0", VendorID: "10de", DeviceID: "1eb8"},
+		{Address: "0000:00:1f.6"},
+	}}, check)
+
+	inventory := []PCIDevice{
+		{Address: "0000:00:1f.6", Vendor: "8086", Device: "15b9"},
+		{Address: "0000:3b:00.0", Vendor: "10de", Device: "1eb8"},
+		{Address: "0000:5e:00.0", Vendor: "8086", Device: "1572"},
+		{Address: "0000:86:00.0", Vendor: "10de", Device: "2236"},
+		{Address: "0000:af:00.0", Vendor: "10de", Device: "1eb8"},
+	}
+
+	tests := []struct {
+		name      string
+		devices   []config.PCIDevice
+		inventory []PCIDevice
+		severity  Severity
+		message   string
+	}{
+		{
+			name:      "exact, moved and absent",
+			devices:   check.Devices,
+			inventory: inventory,
+			severity:  SeverityFatal,
+			message: "install.pci_passthrough[0]: 0000:3b:00.0 is 10de:1eb8 as expected. " +
+				"install.pci_passthrough[1]: 0000:5e:00.0 is 8086:1572, not the expected 10de:2236. 1 device 10de:2236 found at 0000:86:00.0. " +
+				"install.pci_passthrough[2]: 0000:d8:00.0 (expected 10de:1eb8) does not exist on this host. " +
+				"2 devices 10de:1eb8 found at 0000:3b:00.0, 0000:af:00.0. " +
+				"install.pci_passthrough[3]: 0000:00:1f.6 is 8086:15b9.",
+		},
+		{
+			name:      "exact",
+			devices:   []config.PCIDevice{{Address: "3b:00.0", VendorID: "10de", DeviceID: "1eb8"}},
+			inventory: inventory,
+			message:   "install.pci_passthrough[0]: 0000:3b:00.0 is 10de:1eb8 as expected.",
+		},
+		{
+			name:      "moved",
+			devices:   []config.PCIDevice{{Address: "0000:5e:00.0", VendorID: "10de"}},
+			inventory: inventory,
+			severity:  SeverityWarning,
+			message: "install.pci_passthrough[0]: 0000:5e:00.0 is 8086:1572, not the expected 10de:*. " +
+				"3 devices 10de:* found at 0000:3b:00.0, 0000:86:00.0, 0000:af:00.0.",
+		},
+		{
+			name:      "absent without IDs",
+			devices:   []config.PCIDevice{{Address: "0000:d8:00.0"}},
+			inventory: inventory,
+			severity:  SeverityFatal,
+			message:   "install.pci_passthrough[0]: 0000:d8:00.0 does not exist on this host.",
+		},
+		{
+			name:      "absent without a match",
+			devices:   []config.PCIDevice{{Address: "0000:d8:00.0", VendorID: "1002", DeviceID: "740f"}},
+			inventory: inventory,
+			severity:  SeverityFatal,
+			message:   "install.pci_passthrough[0]: 0000:d8:00.0 (expected 1002:740f) does not exist on this host.",
+		},
+		{
+			name:      "no PCI devices",
+			devices:   check.Devices,
+			inventory: []PCIDevice{},
+			message:   "Skipped: no PCI devices were found.",
+		},
+		{
+			name:      "nothing configured",
+			inventory: inventory,
+		},
+	}
+
+	for _, test := range tests {
+		env := &Env{Inventory: Inventory{PCIDevices: test.inventory}}
+		result, err := PassthroughConfigCheck{Devices: test.devices}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "PassthroughConfig", Severity: test.severity, Message: test.message}, result, test.name)
+	}
+}
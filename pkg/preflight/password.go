@@ -0,0 +1,142 @@
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+//go:embed rules/common-passwords.txt
+var commonPasswordList []byte
+
+const (
+	minPasswordLength = 8
+	// minPasswordEntropy is the fewest bits of entropy, as estimated by
+	// passwordEntropy, a password may have.  It's roughly that of ten
+	// random lower case letters and digits.
+	minPasswordEntropy = 50
+)
+
+// hashedPasswordPattern matches passwords in crypt(3) format, e.g.
+// $6$salt$hash
+var hashedPasswordPattern = regexp.MustCompile(`^\$[0-9a-z]+\$[^$]*\$`)
+
+// PasswordCheck verifies that the node password in the install
+// configuration isn't trivially guessable, since configurations often
+// ship with "password" or the example from the documentation.  The
+// password must be long enough, varied enough, and not one of the most
+// common passwords (see rules/common-passwords.txt).  A weak password is
+// warned about, or is fatal for production hosts when
+// Options.WeakPasswordFatal is set.  The password never appears in
+// messages, not even partly, so that it can't leak into logs or reports.
+// Passwords which are already hashed can't be checked, so they're
+// skipped.
+type PasswordCheck struct {
+	Password string
+}
+
+// NewPasswordCheck returns a PasswordCheck for the node password in the
+// given install configuration.
+func NewPasswordCheck(cfg *config.HarvesterConfig) PasswordCheck {
+	return PasswordCheck{Password: cfg.OS.Password}
+}
+
+func (c PasswordCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Password"
+	switch {
+	case c.Password == "":
+		result.Message = "Skipped: the install configuration does not set a password."
+		return
+	case hashedPasswordPattern.MatchString(c.Password):
+		result.Message = "Skipped: the password is already hashed, so its strength cannot be checked."
+		return
+	}
+
+	var weaknesses []string
+	if len([]rune(c.Password)) < minPasswordLength {
+		weaknesses = append(weaknesses, fmt.Sprintf("it is shorter than %d characters", minPasswordLength))
+	}
+	if isCommonPassword(c.Password) {
+		weaknesses = append(weaknesses, "it is one of the most common passwords, or an example from the documentation")
+	} else if passwordEntropy(c.Password) < minPasswordEntropy {
+		weaknesses = append(weaknesses, "it is too predictable; use a longer password, or a wider range of characters")
+	}
+	if len(weaknesses) == 0 {
+		result.Message = "The password is not a common one, and is long and varied enough."
+		return
+	}
+	result.Severity = SeverityWarning
+	if env.Options.Production && env.Options.WeakPasswordFatal {
+		result.Severity = SeverityFatal
+	}
+	result.Message = "The password is weak: " + strings.Join(weaknesses, ", and ") + ". Please choose another."
+	return
+}
+
+// isCommonPassword returns whether password is in the list of common
+// passwords, ignoring case and any digits and punctuation at the end.
+func isCommonPassword(password string) bool {
+	password = strings.ToLower(password)
+	stem := strings.TrimRightFunc(password, func(r rune) bool { return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) })
+	scanner := bufio.NewScanner(bytes.NewReader(commonPasswordList))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if password == line || stem == line {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordEntropy estimates the entropy of password in bits, as if each
+// character were picked at random from the classes of characters it
+// uses.  Repeated characters only count for half, so that "aaaaaaaaaaaa"
+// doesn't pass for strong.
+func passwordEntropy(password string) float64 {
+	var lower, upper, digit, other bool
+	seen := map[rune]bool{}
+	length := 0.0
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+		if seen[r] {
+			length += 0.5
+		} else {
+			length++
+		}
+		seen[r] = true
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {other, 33}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return length * math.Log2(float64(pool))
+}
@@ -0,0 +1,118 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewPasswordCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.OS.Password = "rancher"
+	assert.Equal(t, PasswordCheck{Password: "rancher"}, NewPasswordCheck(cfg))
+}
+
+func TestPasswordCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		production bool
+		fatal      bool
+		severity   Severity
+		message    string
+	}{
+		{
+			name:    "none",
+			message: "Skipped: the install configuration does not set a password.",
+		},
+		{
+			name:     "hashed",
+			password: "$6$4wQ2bDm5pVyI1zYB$Qq7rD1pLhM6N0xOc3Y8i4Rj0hR2f5u9ZtKcG7WbLs3aE1nJvXyT2k8dPqFgHmU6oIeA9zC0rSxN5lVwB4tD1y/",
+			message:  "Skipped: the password is already hashed, so its strength cannot be checked.",
+		},
+		{
+			name:     "common",
+			password: "Password123!",
+			severity: SeverityWarning,
+			message:  "The password is weak: it is one of the most common passwords, or an example from the documentation. Please choose another.",
+		},
+		{
+			name:     "documentation example",
+			password: "rancher",
+			severity: SeverityWarning,
+			message: "The password is weak: it is shorter than 8 characters, " +
+				"and it is one of the most common passwords, or an example from the documentation. Please choose another.",
+		},
+		{
+			name:     "short",
+			password: "x7#Kq",
+			severity: SeverityWarning,
+			message: "The password is weak: it is shorter than 8 characters, " +
+				"and it is too predictable; use a longer password, or a wider range of characters. Please choose another.",
+		},
+		{
+			name:     "repetitive",
+			password: "aaaaaaaaaaaaaaaa",
+			severity: SeverityWarning,
+			message:  "The password is weak: it is too predictable; use a longer password, or a wider range of characters. Please choose another.",
+		},
+		{
+			name:       "weak in production",
+			password:   "p@ssword",
+			production: true,
+			severity:   SeverityWarning,
+			message:    "The password is weak: it is one of the most common passwords, or an example from the documentation. Please choose another.",
+		},
+		{
+			name:       "weak in production, made fatal",
+			password:   "p@ssword",
+			production: true,
+			fatal:      true,
+			severity:   SeverityFatal,
+			message:    "The password is weak: it is one of the most common passwords, or an example from the documentation. Please choose another.",
+		},
+		{
+			name:     "strong",
+			password: "Vellum-Otter-91-Quince",
+			message:  "The password is not a common one, and is long and varied enough.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &Env{Options: Options{Production: tt.production, WeakPasswordFatal: tt.fatal}}
+			result, err := PasswordCheck{Password: tt.password}.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "Password", Severity: tt.severity, Message: tt.message}, result)
+		})
+	}
+}
+
+func TestPasswordCheckDoesNotLeak(t *testing.T) {
+	for _, password := range []string{"rancher", "Letmein2024!", "x7#Kq", "Vellum-Otter-91-Quince"} {
+		runner := Runner{Checks: []ResultCheck{PasswordCheck{Password: password}}}
+		report := runner.Run(context.Background())
+
+		var text strings.Builder
+		assert.Nil(t, report.WriteText(&text))
+		data, err := json.Marshal(report)
+		assert.Nil(t, err)
+		path := filepath.Join(t.TempDir(), "preflight.json")
+		assert.Nil(t, report.WriteFile(path))
+		persisted, err := os.ReadFile(path)
+		assert.Nil(t, err)
+
+		// Nor any recognisable part of it
+		stem := strings.TrimRight(password, "0123456789!")
+		for _, output := range []string{text.String(), string(data), string(persisted)} {
+			assert.NotContains(t, output, password)
+			assert.NotContains(t, strings.ToLower(output), strings.ToLower(stem))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package preflight
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A PCIDevice is a PCI device in the inventory.  IDs are as sysfs has
+// them, without the 0x prefix, e.g. Vendor "10de".
+type PCIDevice struct {
+	Address string
+	Vendor  string
+	Device  string
+}
+
+// pciDevice is what we know about a PCI device from sysfs.  IDs are as
+// sysfs has them, without the 0x prefix, e.g. Vendor "10de".
+type pciDevice struct {
+	Address string
+	Class   uint32
+	Vendor  string
+	Device  string
+	// Driver is the name of the bound driver, if any
+	Driver string
+	// IOMMUGroup is empty if the IOMMU is disabled
+	IOMMUGroup string
+}
+
+// baseClass returns the PCI base class, e.g. 0x03 for display controllers.
+func (d pciDevice) baseClass() uint32 {
+	return d.Class >> 16
+}
+
+func (e *Env) readPCIDevice(address string) (pciDevice, error) {
+	dir := filepath.Join(e.host().sysBusPCIDevices, address)
+	dev := pciDevice{Address: address}
+	class, err := e.readFile(filepath.Join(dir, "class"))
+	if err != nil {
+		return dev, err
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(class)), "0x"), 16, 32)
+	if err != nil {
+		return dev, err
+	}
+	dev.Class = uint32(value)
+	dev.Vendor = strings.TrimPrefix(e.readTrimmed(filepath.Join(dir, "vendor")), "0x")
+	dev.Device = strings.TrimPrefix(e.readTrimmed(filepath.Join(dir, "device")), "0x")
+	if link, err := e.readlink(filepath.Join(dir, "driver")); err == nil {
+		dev.Driver = filepath.Base(link)
+	}
+	if link, err := e.readlink(filepath.Join(dir, "iommu_group")); err == nil {
+		dev.IOMMUGroup = filepath.Base(link)
+	}
+	return dev, nil
+}
+
+func (e *Env) listPCIDevices() ([]pciDevice, error) {
+	entries, err := e.readDir(e.host().sysBusPCIDevices)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var devs []pciDevice
+	for _, entry := range entries {
+		dev, err := e.readPCIDevice(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// inventoryPCIDevices returns devs as they're recorded in the inventory.
+func inventoryPCIDevices(devs []pciDevice) []PCIDevice {
+	inv := []PCIDevice{}
+	for _, dev := range devs {
+		inv = append(inv, PCIDevice{Address: dev.Address, Vendor: dev.Vendor, Device: dev.Device})
+	}
+	return inv
+}
+
+// iommuEnabled returns true if the kernel has set up IOMMU groups, which
+// it only does if the IOMMU is present and enabled.
+func (e *Env) iommuEnabled() (bool, error) {
+	entries, err := e.readDir(e.host().sysKernelIOMMUGroups)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,158 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A PowerSupply is a supply slot, as reported by the BMC or DMI.
+type PowerSupply struct {
+	Name    string
+	Present bool
+	// Fault describes the problem the supply reports, if any, e.g.
+	// "AC lost".
+	Fault string
+}
+
+// psuFaults are the problems a power supply can report, as they appear
+// in the ipmitool event text or the DMI status
+var psuFaults = []string{
+	"Failure detected",
+	"Predictive failure",
+	"AC lost",
+	"input lost",
+	"out-of-range",
+	"Config Error",
+	"Critical",
+}
+
+// PSURedundancyCheck looks at the power supplies via IPMI, falling back
+// to DMI if the BMC doesn't have any power supply sensors.  It warns if
+// a supply reports a fault, or in production mode if there's only one
+// supply.  It's skipped if BMCCheck didn't find an IPMI interface.
+type PSURedundancyCheck struct{}
+
+func (c PSURedundancyCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c PSURedundancyCheck) readsFrom() []string {
+	return []string{"BMC"}
+}
+
+func (c PSURedundancyCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "PSURedundancy"
+
+	if env.Inventory.BMC == nil || env.Inventory.BMC.Interface != "ipmi" {
+		result.Message = "Skipped, because there is no IPMI interface to query the power supplies through."
+		return
+	}
+	out, err := env.output("/usr/bin/ipmitool", "sdr", "type", "Power Supply")
+	if err != nil {
+		err = nil
+		result.Message = "Skipped, because the power supply sensors could not be queried via IPMI."
+		return
+	}
+	supplies := parseIPMIPowerSupplies(string(out))
+	if len(supplies) == 0 {
+		var records []dmiRecord
+		if records, err = env.dmi(39); err != nil && !errors.Is(err, errNoSMBIOS) {
+			return
+		}
+		err = nil
+		supplies = parseDMIPowerSupplies(records)
+	}
+	env.Inventory.PowerSupplies = supplies
+
+	var present int
+	var faults []string
+	for _, psu := range supplies {
+		if !psu.Present {
+			continue
+		}
+		present++
+		if psu.Fault != "" {
+			faults = append(faults, fmt.Sprintf("%s (%s)", psu.Name, psu.Fault))
+		}
+	}
+
+	switch {
+	case len(faults) > 0:
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("Power supplies reporting problems: %s. Please check the power cabling and the supplies.",
+			strings.Join(faults, ", "))
+	case present == 0:
+		result.Message = "No power supply sensors were found, so power supply redundancy could not be checked."
+	case present == 1:
+		result.Message = "Only one power supply is present."
+		if env.Options.Production {
+			result.Severity = SeverityWarning
+			result.Message += " Redundant power supplies are recommended for production use."
+		}
+	default:
+		result.Message = fmt.Sprintf("%d power supplies present.", present)
+	}
+	return
+}
+
+// parseIPMIPowerSupplies parses the output of `ipmitool sdr type "Power
+// Supply"`, which looks something like this:
+//
+//	PS1 Status       | 62h | ok  | 10.1 | Presence detected
+//	PS2 Status       | 63h | ok  | 10.2 | Presence detected, Power Supply AC lost
+//	PS Redundancy    | 77h | ok  |  7.1 | Fully Redundant
+//
+// Sensor names vary by vendor ("PS1 Status", "PSU2", "Power Supply 1"),
+// so supplies are identified by the presence events rather than the
+// names.  Redundancy sensors and the like are ignored, because what they
+// say is implied by the state of the supplies.
+func parseIPMIPowerSupplies(out string) (supplies []PowerSupply) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 5 {
+			continue
+		}
+		name := strings.TrimSpace(fields[0])
+		event := strings.TrimSpace(fields[4])
+		switch {
+		case strings.Contains(event, "Presence detected"):
+			supplies = append(supplies, PowerSupply{Name: name, Present: true, Fault: psuFault(event)})
+		case strings.Contains(strings.ToLower(name), "redundan") || strings.Contains(event, "Redundan"):
+			continue
+		case strings.TrimSpace(fields[2]) == "ns", event == "No Reading", event == "":
+			// An empty slot, or a sensor for one that's never been
+			// populated
+			supplies = append(supplies, PowerSupply{Name: name})
+		}
+	}
+	return
+}
+
+// parseDMIPowerSupplies gets the power supplies from DMI type 39
+// records, whose Status is e.g. "Present, OK" or "Not Present".
+func parseDMIPowerSupplies(records []dmiRecord) (supplies []PowerSupply) {
+	for i, record := range records {
+		name := record.Fields["Location"]
+		if name == "" {
+			name = fmt.Sprintf("PSU%d", i+1)
+		}
+		status := record.Fields["Status"]
+		supplies = append(supplies, PowerSupply{
+			Name:    name,
+			Present: strings.HasPrefix(status, "Present"),
+			Fault:   psuFault(status),
+		})
+	}
+	return
+}
+
+func psuFault(status string) string {
+	for _, fault := range psuFaults {
+		if strings.Contains(status, fault) {
+			return fault
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPSURedundancyCheck(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+
+	ipmi := &BMC{Interface: "ipmi"}
+	tests := []struct {
+		name       string
+		bmc        *BMC
+		sdr        string
+		dmidecode  string
+		production bool
+		supplies   []PowerSupply
+		severity   Severity
+		message    string
+	}{
+		{
+			name: "dell ac lost",
+			bmc:  ipmi,
+			sdr:  "ipmitool-sdr-dell",
+			supplies: []PowerSupply{
+				{Name: "PS1 Status", Present: true},
+				{Name: "PS2 Status", Present: true, Fault: "AC lost"},
+			},
+			severity: SeverityWarning,
+			message:  "Power supplies reporting problems: PS2 Status (AC lost). Please check the power cabling and the supplies.",
+		},
+		{
+			name:       "hpe redundant",
+			bmc:        ipmi,
+			sdr:        "ipmitool-sdr-hpe",
+			production: true,
+			supplies: []PowerSupply{
+				{Name: "Power Supply 1", Present: true},
+				{Name: "Power Supply 2", Present: true},
+			},
+			message: "2 power supplies present.",
+		},
+		{
+			name:       "lenovo empty slot",
+			bmc:        ipmi,
+			sdr:        "ipmitool-sdr-lenovo",
+			production: true,
+			supplies: []PowerSupply{
+				{Name: "PSU1 Status", Present: true},
+				{Name: "PSU2 Status"},
+			},
+			severity: SeverityWarning,
+			message:  "Only one power supply is present. Redundant power supplies are recommended for production use.",
+		},
+		{
+			name:      "desktop",
+			bmc:       ipmi,
+			sdr:       "ipmitool-sdr-empty",
+			dmidecode: "dmidecode-psu-desktop",
+			supplies: []PowerSupply{
+				{Name: "To Be Filled By O.E.M.", Present: true},
+			},
+			message: "Only one power supply is present.",
+		},
+		{
+			name:      "no sensors",
+			bmc:       ipmi,
+			sdr:       "ipmitool-sdr-empty",
+			dmidecode: "dmidecode-bios",
+			message:   "No power supply sensors were found, so power supply redundancy could not be checked.",
+		},
+		{
+			name:    "ipmitool fails",
+			bmc:     ipmi,
+			sdr:     "ipmitool-fail",
+			message: "Skipped, because the power supply sensors could not be queried via IPMI.",
+		},
+		{
+			name:    "redfish",
+			bmc:     &BMC{Interface: "redfish"},
+			message: "Skipped, because there is no IPMI interface to query the power supplies through.",
+		},
+		{
+			name:    "no bmc",
+			message: "Skipped, because there is no IPMI interface to query the power supplies through.",
+		},
+	}
+
+	for _, test := range tests {
+		command := func(name string, _ ...string) *exec.Cmd {
+			if strings.HasSuffix(name, "dmidecode") {
+				return fakeExecCommand(test.dmidecode)
+			}
+			return fakeExecCommand(test.sdr)
+		}
+		env := &Env{Options: Options{Production: test.production}, Inventory: Inventory{BMC: test.bmc}, execCommand: command, machine: h}
+		result, err := PSURedundancyCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "PSURedundancy", Severity: test.severity, Message: test.message}, result, test.name)
+		assert.Equal(t, test.supplies, env.Inventory.PowerSupplies, test.name)
+	}
+}
+
+func TestPSURedundancyCheckNoSMBIOS(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/none"
+	env := &Env{Inventory: Inventory{BMC: &BMC{Interface: "ipmi"}}, execCommand: fakeCommand("ipmitool-sdr-empty"), machine: h}
+	result, err := PSURedundancyCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{
+		Name:    "PSURedundancy",
+		Message: "No power supply sensors were found, so power supply redundancy could not be checked.",
+	}, result)
+}
@@ -0,0 +1,103 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxUpgradeLoadPerCPU is the highest five minute load average, per
+	// online CPU, at which an upgrade should be started
+	maxUpgradeLoadPerCPU = 0.8
+	// minUpgradeAvailableMemory is the smallest fraction of memory which
+	// should be available when an upgrade is started
+	minUpgradeAvailableMemory = 0.15
+)
+
+// PressureCheck verifies that an installed node isn't too busy to be
+// upgraded.  Upgrading drains the node, so its workloads must fit on the
+// others, and the upgrade itself needs CPU and memory to pull and unpack
+// the new release; on a node that's already struggling, VM migrations
+// time out and the upgrade stalls.  Both the five minute load average,
+// relative to the number of online CPUs, and the fraction of memory the
+// kernel considers available are warned about above their thresholds.
+type PressureCheck struct{}
+
+func (c PressureCheck) Modes() []RunMode {
+	return []RunMode{RunModeUpgrade}
+}
+
+func (c PressureCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Pressure"
+
+	out, err := env.readFile(filepath.Join(env.host().root, "proc/loadavg"))
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		return result, parseErrorf("unable to parse /proc/loadavg: %q", out)
+	}
+	load, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return result, parseErrorf("unable to parse /proc/loadavg: %w", err)
+	}
+	cpus := map[int]bool{}
+	addCPUList(cpus, env.readTrimmed(filepath.Join(env.host().root, "sys/devices/system/cpu/online")))
+	if len(cpus) == 0 {
+		return result, parseErrorf("unable to determine the online CPUs")
+	}
+
+	memTotal, memAvailable, err := readMemAvailable(env, filepath.Join(env.host().root, "proc/meminfo"))
+	if err != nil {
+		return
+	}
+	available := float64(memAvailable) / float64(memTotal)
+
+	var findings []string
+	if perCPU := load / float64(len(cpus)); perCPU > maxUpgradeLoadPerCPU {
+		result.Severity = SeverityWarning
+		findings = append(findings, fmt.Sprintf("The five minute load average is %.1f, or %.2f per CPU, above the %.1f an upgrade should be started at.",
+			load, perCPU, maxUpgradeLoadPerCPU))
+	} else {
+		findings = append(findings, fmt.Sprintf("The five minute load average is %.1f, or %.2f per CPU.", load, perCPU))
+	}
+	if available < minUpgradeAvailableMemory {
+		result.Severity = SeverityWarning
+		findings = append(findings, fmt.Sprintf("Only %.0f%% of memory (%s) is available, below the %.0f%% an upgrade should be started with.",
+			available*100, formatBytes(memAvailable), minUpgradeAvailableMemory*100))
+	} else {
+		findings = append(findings, fmt.Sprintf("%.0f%% of memory (%s) is available.", available*100, formatBytes(memAvailable)))
+	}
+	if result.Severity > SeverityOK {
+		findings = append(findings, "Please move workloads off the node, or wait for a quieter time, before upgrading.")
+	}
+	result.Message = strings.Join(findings, " ")
+	return
+}
+
+// readMemAvailable returns MemTotal and MemAvailable from the meminfo
+// file at path, in bytes.
+func readMemAvailable(env *Env, path string) (total, available uint64, err error) {
+	out, err := env.readFile(path)
+	if err != nil {
+		return
+	}
+
+	var haveAvailable bool
+	for _, line := range strings.Split(string(out), "\n") {
+		var kib uint64
+		if n, _ := fmt.Sscanf(line, "MemTotal: %d kB", &kib); n == 1 {
+			total = kib << 10
+		} else if n, _ := fmt.Sscanf(line, "MemAvailable: %d kB", &kib); n == 1 {
+			available, haveAvailable = kib<<10, true
+		}
+	}
+	if total == 0 || !haveAvailable {
+		err = parseErrorf("unable to extract MemTotal and MemAvailable from %s", path)
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPressureCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture  string
+		severity Severity
+		message  string
+		err      string
+	}{
+		{
+			fixture: "idle",
+			message: "The five minute load average is 2.4, or 0.30 per CPU. 50% of memory (32GiB) is available.",
+		},
+		{
+			fixture:  "busy",
+			severity: SeverityWarning,
+			message: "The five minute load average is 8.0, or 1.00 per CPU, above the 0.8 an upgrade should be started at. " +
+				"50% of memory (32GiB) is available. " +
+				"Please move workloads off the node, or wait for a quieter time, before upgrading.",
+		},
+		{
+			fixture:  "low-memory",
+			severity: SeverityWarning,
+			message: "The five minute load average is 2.4, or 0.30 per CPU. " +
+				"Only 6% of memory (4GiB) is available, below the 15% an upgrade should be started with. " +
+				"Please move workloads off the node, or wait for a quieter time, before upgrading.",
+		},
+		{
+			fixture: "no-meminfo-available",
+			err:     "unable to extract MemTotal and MemAvailable from testdata/pressure/no-meminfo-available/proc/meminfo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			h.root = "./testdata/pressure/" + tt.fixture
+			result, err := PressureCheck{}.Evaluate(context.Background(), &Env{machine: h})
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "Pressure", Severity: tt.severity, Message: tt.message}, result)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cosLabels are the filesystem labels of the partitions of a SaftOS (or
+// Harvester) installation, in partition order
+var cosLabels = []string{"COS_OEM", "COS_STATE", "COS_RECOVERY", "COS_PERSISTENT", "HARV_LH_DEFAULT"}
+
+const (
+	// previousConfigPath is where the installer saves the install
+	// configuration, in the COS_OEM partition
+	previousConfigPath = "/harvester.config"
+	// previousRancherState is where /var/lib/rancher is persisted, in
+	// the COS_PERSISTENT partition
+	previousRancherState = "/.state/var-lib-rancher.bind"
+)
+
+// PreviousInstallCheck looks for a previous SaftOS or Harvester
+// installation on the installation device from the inventory, or any of
+// the other Targets.  Re-installing over one (often a half-finished
+// earlier attempt) inherits stale rancherd state and certificates, and
+// the cluster never converges, so this is fatal unless destructive
+// operations have been allowed.  The partitions are found by their
+// filesystem labels, and only ever read: the previously installed version
+// and cluster state are read with debugfs, which opens them read-only.
+type PreviousInstallCheck struct {
+	Targets []string
+}
+
+func (c PreviousInstallCheck) readsFrom() []string {
+	return []string{"ConfigDevice"}
+}
+
+func (c PreviousInstallCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "PreviousInstall"
+
+	var found []string
+	var oem, persistent string
+	for _, target := range env.targets(c.Targets) {
+		target = strings.TrimPrefix(target, "/dev/")
+		var parts []string
+		if parts, err = env.partitions(target); err != nil {
+			return
+		}
+		for _, dev := range parts {
+			var sig signature
+			if sig, err = probeSignature(env, dev); err != nil {
+				return
+			}
+			if !strings.HasPrefix(sig.Type, "ext") || !slices.Contains(cosLabels, sig.Label) {
+				continue
+			}
+			found = append(found, fmt.Sprintf("%s on %s", sig.Label, dev))
+			switch sig.Label {
+			case "COS_OEM":
+				oem = dev
+			case "COS_PERSISTENT":
+				persistent = dev
+			}
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	desc := "a previous SaftOS installation"
+	if oem != "" {
+		if version := previousInstallVersion(env, oem); version != "" {
+			desc += fmt.Sprintf(" (version %s)", version)
+		}
+	}
+	result.Message = fmt.Sprintf("Found %s: %s.", desc, strings.Join(found, ", "))
+	if persistent != "" && previousRancherStatePresent(env, persistent) {
+		result.Message += fmt.Sprintf(" %s has cluster state in /var/lib/rancher.", persistent)
+	}
+
+	if env.Options.DestructiveAllowed {
+		result.Severity = SeverityInfo
+		result.Message += " It will be wiped."
+		return
+	}
+	result.Severity = SeverityFatal
+	result.Message += " Installing over it would leave stale cluster state and certificates behind. " +
+		"Please choose upgrade mode to upgrade it, or confirm that the disk should be wiped."
+	return
+}
+
+// debugfs runs a debugfs(8) request against a partition.  debugfs opens
+// the filesystem read-only, unless asked not to.
+func debugfs(env *Env, dev, request string) ([]byte, error) {
+	return env.output("/usr/sbin/debugfs", "-R", request, filepath.Join(env.host().devDir, dev))
+}
+
+// previousInstallVersion returns the version from the install
+// configuration saved in a COS_OEM partition, or "" if it can't be read.
+func previousInstallVersion(env *Env, dev string) string {
+	out, err := debugfs(env, dev, "cat "+previousConfigPath)
+	if err != nil {
+		return ""
+	}
+	var cfg struct {
+		Version string `yaml:"harvesterChartVersion"`
+	}
+	if err := yaml.Unmarshal(out, &cfg); err != nil {
+		return ""
+	}
+	return cfg.Version
+}
+
+// previousRancherStatePresent returns true if a COS_PERSISTENT partition
+// has anything in /var/lib/rancher.
+func previousRancherStatePresent(env *Env, dev string) bool {
+	return debugfsDirPopulated(env, dev, previousRancherState)
+}
+
+// debugfsDirPopulated returns true if the directory dir on the ext2/3/4
+// filesystem on a partition has anything in it.
+func debugfsDirPopulated(env *Env, dev, dir string) bool {
+	// ls -p prints entries as /inode/mode/uid/gid/name/size/
+	out, err := debugfs(env, dev, "ls -p "+dir)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "/")
+		if len(fields) > 5 && fields[5] != "." && fields[5] != ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviousInstallCheck(t *testing.T) {
+	h := testHost()
+
+	const (
+		harvester = "Found a previous SaftOS installation (version v1.3.1): " +
+			"COS_OEM on sda2, COS_STATE on sda3, COS_RECOVERY on sda4, COS_PERSISTENT on sda5."
+		rancherState = " sda5 has cluster state in /var/lib/rancher."
+		guidance     = " Installing over it would leave stale cluster state and certificates behind. " +
+			"Please choose upgrade mode to upgrade it, or confirm that the disk should be wiped."
+	)
+
+	tests := []struct {
+		fixture     string
+		config      string
+		state       string
+		destructive bool
+		severity    Severity
+		message     string
+	}{
+		{
+			fixture:  "harvester",
+			config:   "debugfs-harvester-config",
+			state:    "debugfs-rancher-state",
+			severity: SeverityFatal,
+			message:  harvester + rancherState + guidance,
+		},
+		{
+			fixture:     "harvester",
+			config:      "debugfs-harvester-config",
+			state:       "debugfs-rancher-state",
+			destructive: true,
+			severity:    SeverityInfo,
+			message:     harvester + rancherState + " It will be wiped.",
+		},
+		{
+			fixture:  "harvester",
+			config:   "debugfs-not-found",
+			state:    "debugfs-empty-dir",
+			severity: SeverityFatal,
+			message:  "Found a previous SaftOS installation: COS_OEM on sda2, COS_STATE on sda3, COS_RECOVERY on sda4, COS_PERSISTENT on sda5." + guidance,
+		},
+		{
+			fixture:  "partial",
+			severity: SeverityFatal,
+			message:  "Found a previous SaftOS installation: COS_STATE on sda2." + guidance,
+		},
+		{
+			fixture: "clean",
+		},
+	}
+
+	for _, test := range tests {
+		dir := "./testdata/previous-install/" + test.fixture
+		h.sysBlock = dir + "/sys/block"
+		h.devDir = dir + "/dev"
+		command := func(_ string, args ...string) *exec.Cmd {
+			switch {
+			case strings.HasPrefix(args[1], "cat ") && strings.HasSuffix(args[2], "/sda2"):
+				return fakeExecCommand(test.config)
+			case strings.HasPrefix(args[1], "ls ") && strings.HasSuffix(args[2], "/sda5"):
+				return fakeExecCommand(test.state)
+			}
+			return fakeExecCommand("debugfs-not-found")
+		}
+		env := &Env{
+			Options:     Options{DestructiveAllowed: test.destructive},
+			Inventory:   Inventory{InstallDevice: "sda"},
+			execCommand: command,
+			machine:     h,
+		}
+		result, err := PreviousInstallCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "PreviousInstall", Severity: test.severity, Message: test.message}, result, test.fixture)
+	}
+}
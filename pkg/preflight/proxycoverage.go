@@ -0,0 +1,238 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	// The cluster networks used unless the install configuration sets
+	// others, as the console's cluster network panel says
+	defaultClusterPodCIDR     = "10.52.0.0/16"
+	defaultClusterServiceCIDR = "10.53.0.0/16"
+)
+
+// clusterDomains are the suffixes of the names of services inside the
+// cluster, along with an example name under each.
+var clusterDomains = []struct{ suffix, example string }{
+	{".svc", "rancher.cattle-system.svc"},
+	{".cluster.local", "kubernetes.default.svc.cluster.local"},
+}
+
+// ProxyCoverageCheck verifies that, when the installed system is to use a
+// proxy, no_proxy exempts the cluster's internal traffic: the node, pod
+// and service networks, the VIP, and names of services in the cluster.
+// Otherwise that traffic hairpins through the proxy, which at best is
+// slow and at worst breaks the cluster, even though the proxy itself
+// works.  Each destination is matched as Go's httpproxy package matches
+// it, which is how the cluster's components will; a network only counts
+// as exempt if a single no_proxy entry covers all of it.  Proxied
+// destinations are warned about, along with the no_proxy entries that
+// would exempt them.  Invalid proxy URLs are fatal.
+type ProxyCoverageCheck struct {
+	Proxy httpproxy.Config
+	// NodeCIDR is the management network, if it's statically
+	// configured.
+	NodeCIDR    string
+	PodCIDR     string
+	ServiceCIDR string
+	VIP         string
+}
+
+// NewProxyCoverageCheck returns a ProxyCoverageCheck for the given install
+// configuration.
+func NewProxyCoverageCheck(cfg *config.HarvesterConfig) ProxyCoverageCheck {
+	check := ProxyCoverageCheck{
+		Proxy:       proxyFromConfig(cfg),
+		PodCIDR:     cfg.ClusterPodCIDR,
+		ServiceCIDR: cfg.ClusterServiceCIDR,
+		VIP:         cfg.Vip,
+	}
+	if check.PodCIDR == "" {
+		check.PodCIDR = defaultClusterPodCIDR
+	}
+	if check.ServiceCIDR == "" {
+		check.ServiceCIDR = defaultClusterServiceCIDR
+	}
+	mgmt := cfg.ManagementInterface
+	if mgmt.Method == config.NetworkMethodStatic {
+		ip, mask := net.ParseIP(mgmt.IP).To4(), net.ParseIP(mgmt.SubnetMask).To4()
+		if ip != nil && mask != nil {
+			check.NodeCIDR = (&net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}).String()
+		}
+	}
+	return check
+}
+
+func (c ProxyCoverageCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c ProxyCoverageCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ProxyCoverage"
+	var invalid []string
+	for _, proxy := range []struct{ name, value string }{
+		{"http_proxy", c.Proxy.HTTPProxy},
+		{"https_proxy", c.Proxy.HTTPSProxy},
+	} {
+		if err := validateProxyURL(proxy.value); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s is not a valid proxy URL: %v.", proxy.name, err))
+		}
+	}
+	if len(invalid) > 0 {
+		result.Severity = SeverityFatal
+		result.Message = strings.Join(invalid, " ")
+		return
+	}
+	if c.Proxy.HTTPProxy == "" && c.Proxy.HTTPSProxy == "" {
+		result.Message = "Skipped: no proxy is configured."
+		if env.Options.AirGapped {
+			result.Message = "Skipped: no proxy is configured, as expected when air-gapped."
+			result.AirGapped = true
+		}
+		return
+	}
+
+	type destination struct {
+		description, entry string
+		proxied            bool
+	}
+	var destinations []destination
+	proxyFunc := c.Proxy.ProxyFunc()
+	for _, network := range []struct{ description, cidr string }{
+		{"the node network", c.NodeCIDR},
+		{"the pod network", c.PodCIDR},
+		{"the service network", c.ServiceCIDR},
+	} {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			continue
+		}
+		destinations = append(destinations, destination{
+			description: fmt.Sprintf("%s (%s)", network.description, ipNet),
+			entry:       ipNet.String(),
+			proxied:     !networkBypassesProxy(c.Proxy.NoProxy, ipNet),
+		})
+	}
+	if vip := net.ParseIP(c.VIP); vip != nil {
+		destinations = append(destinations, destination{
+			description: fmt.Sprintf("the VIP (%s)", vip),
+			entry:       vip.String(),
+			proxied:     hostProxied(proxyFunc, vip.String()),
+		})
+	}
+	for _, domain := range clusterDomains {
+		destinations = append(destinations, destination{
+			description: fmt.Sprintf("%s names", domain.suffix),
+			entry:       domain.suffix,
+			proxied:     hostProxied(proxyFunc, domain.example),
+		})
+	}
+
+	var proxied, entries, covered []string
+	for _, d := range destinations {
+		if d.proxied {
+			proxied = append(proxied, d.description)
+			entries = append(entries, d.entry)
+		} else {
+			covered = append(covered, d.description)
+		}
+	}
+	if len(proxied) == 0 {
+		result.Message = fmt.Sprintf("no_proxy exempts %s from the proxy.", joinWithAnd(covered))
+		return
+	}
+	result.Severity = SeverityWarning
+	result.Message = fmt.Sprintf("Traffic to %s would go through the proxy. Add %q to no_proxy.",
+		joinWithAnd(proxied), strings.Join(entries, ","))
+	return
+}
+
+// hostProxied returns whether requests to host, over either HTTP or
+// HTTPS, would go through the proxy.
+func hostProxied(proxyFunc func(*url.URL) (*url.URL, error), host string) bool {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	for _, scheme := range []string{"http", "https"} {
+		if proxy, err := proxyFunc(&url.URL{Scheme: scheme, Host: host}); err == nil && proxy != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// networkBypassesProxy returns whether a single entry of noProxy covers
+// all of network.  httpproxy only matches addresses against CIDR entries
+// and the "*" wildcard, so those are all that can.
+func networkBypassesProxy(noProxy string, network *net.IPNet) bool {
+	ones, _ := network.Mask.Size()
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "*" {
+			return true
+		}
+		if _, entryNet, err := net.ParseCIDR(entry); err == nil {
+			entryOnes, _ := entryNet.Mask.Size()
+			if entryOnes <= ones && entryNet.Contains(network.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateProxyURL checks a proxy URL the way httpproxy interprets it,
+// where a URL without a scheme is taken to be an HTTP proxy.  httpproxy
+// also retries URLs which have a scheme but don't parse with http://
+// prepended, which "works" by making the scheme the host, so that isn't
+// done here.  The URL itself is left out of errors, since it may contain
+// a password.
+func validateProxyURL(proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if !strings.Contains(proxy, "://") && (err != nil || u.Scheme == "" || u.Host == "") {
+		if withScheme, err2 := url.Parse("http://" + proxy); err2 == nil {
+			u, err = withScheme, nil
+		}
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	} else if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("it has no host")
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+	}
+	return nil
+}
+
+// joinWithAnd joins items as a list in prose, e.g. "a, b and c".
+func joinWithAnd(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
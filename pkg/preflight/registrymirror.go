@@ -0,0 +1,391 @@
+package preflight
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rancher/wharfie/pkg/registries"
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const registryTimeout = 10 * time.Second
+
+// manifestMediaTypes are the manifest formats a mirror is asked for, as
+// containerd does
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// errCredentialsRejected is returned when a registry, or its token
+// service, refuses the configured credentials
+var errCredentialsRejected = errors.New("rejected the configured credentials")
+
+// RegistryMirrorCheck verifies the private registry mirrors in the
+// containerd-registry system setting, so that wrong credentials or an
+// untrusted certificate are caught now, rather than when images fail to
+// pull during bootstrap.  Each mirror endpoint is probed at /v2/, trusting
+// the system CAs plus the additional CA, and through the configured
+// proxy.  If it asks for credentials, the basic or token authentication
+// handshake is completed with those configured for its host.  If
+// Options.RegistryProbeManifest is set, that manifest is fetched (HEAD
+// only) as well, to prove pull access.  Credentials and tokens never
+// appear in messages.  Mirrors which are plain HTTP, or whose
+// certificates aren't verified, are warned about for production hosts.
+// When the host is air-gapped, mirrors on the internet aren't probed.
+type RegistryMirrorCheck struct {
+	// Registries is the containerd-registry system setting, as JSON.
+	Registries string
+	// CACerts are PEM encoded certificates to trust in addition to the
+	// system CAs.
+	CACerts string
+	Proxy   httpproxy.Config
+}
+
+// NewRegistryMirrorCheck returns a RegistryMirrorCheck for the given
+// install configuration.
+func NewRegistryMirrorCheck(cfg *config.HarvesterConfig) RegistryMirrorCheck {
+	return RegistryMirrorCheck{
+		Registries: cfg.SystemSettings["containerd-registry"],
+		CACerts:    cfg.SystemSettings["additional-ca"],
+		Proxy:      proxyFromConfig(cfg),
+	}
+}
+
+func (c RegistryMirrorCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c RegistryMirrorCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "RegistryMirror"
+
+	var registry registries.Registry
+	if strings.TrimSpace(c.Registries) != "" {
+		if err := json.Unmarshal([]byte(c.Registries), &registry); err != nil {
+			// Not the JSON error, which may quote the credentials
+			result.Severity = SeverityFatal
+			result.Message = "The containerd-registry system setting is not valid JSON."
+			return result, nil
+		}
+	}
+	var names []string
+	for name, mirror := range registry.Mirrors {
+		if len(mirror.Endpoints) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		result.Message = "Skipped: the install configuration does not set any registry mirrors."
+		return
+	}
+	slices.Sort(names)
+
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+	roots, _ := rootCAs(c.CACerts)
+	probed := 0
+	for _, name := range names {
+		for i, endpoint := range registry.Mirrors[name].Endpoints {
+			u, err := url.Parse(strings.TrimSpace(endpoint))
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				// Nor the endpoint, which may have credentials in it
+				finding(SeverityFatal, "Mirror %d of %s is not an http or https URL.", i+1, name)
+				continue
+			}
+			u.User = nil
+			if env.Options.AirGapped && onInternet(u.Hostname()) {
+				findings = append(findings, notContacted(fmt.Sprintf("%s mirror %s", name, u.Host)))
+				result.AirGapped = true
+				continue
+			}
+			probed++
+			hostConfig := registry.Configs[u.Host]
+			insecure := u.Scheme == "http" || (hostConfig.TLS != nil && hostConfig.TLS.InsecureSkipVerify)
+
+			probe := registryProbe{
+				endpoint: u,
+				auth:     hostConfig.Auth,
+				client:   c.client(roots, insecure),
+			}
+			severity, outcome := probe.run(ctx, env.Options.RegistryProbeManifest)
+			finding(severity, "%s mirror %s %s.", name, u.Host, outcome)
+			if insecure && env.Options.Production {
+				finding(SeverityWarning, "%s mirror %s is used without verifying its identity, so images could be tampered with; "+
+					"production hosts should use https with a trusted certificate.", name, u.Host)
+			}
+		}
+	}
+	result.Message = strings.Join(findings, " ")
+	if probed == 0 && result.Severity == SeverityOK {
+		result.Message = "Skipped: " + result.Message
+	}
+	return
+}
+
+// client returns the HTTP client used to probe a mirror.
+func (c RegistryMirrorCheck) client(roots *x509.CertPool, insecure bool) *http.Client {
+	proxy := c.Proxy.ProxyFunc()
+	return &http.Client{
+		Timeout: registryTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, InsecureSkipVerify: insecure},
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return proxy(req.URL)
+			},
+		},
+	}
+}
+
+// registryProbe performs the requests of the distribution API a client
+// pulling from a registry would.
+type registryProbe struct {
+	endpoint *url.URL
+	auth     *registries.AuthConfig
+	client   *http.Client
+}
+
+// run probes the registry, and if manifest is set, whether it can be
+// pulled.  The outcome completes a sentence starting with the mirror.
+func (p registryProbe) run(ctx context.Context, manifest string) (Severity, string) {
+	resp, err := p.do(ctx, http.MethodGet, "/v2/", "")
+	if err != nil {
+		return SeverityFatal, p.describeError(err)
+	}
+
+	var outcome, challenge, authorization string
+	switch resp.StatusCode {
+	case http.StatusOK:
+		outcome = "is reachable, and allows anonymous access"
+	case http.StatusUnauthorized:
+		challenge = resp.Header.Get("WWW-Authenticate")
+		if authorization, err = p.authorize(ctx, challenge, ""); err != nil {
+			return SeverityFatal, p.describeError(err)
+		}
+		if resp, err = p.do(ctx, http.MethodGet, "/v2/", authorization); err != nil {
+			return SeverityFatal, p.describeError(err)
+		}
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return SeverityFatal, errCredentialsRejected.Error()
+		case resp.StatusCode != http.StatusOK:
+			return SeverityFatal, fmt.Sprintf("answered GET /v2/ with %s, so it is not a registry", resp.Status)
+		case p.credentialed():
+			outcome = "accepted the configured credentials"
+		default:
+			outcome = "is reachable, and allows anonymous access"
+		}
+	default:
+		return SeverityFatal, fmt.Sprintf("answered GET /v2/ with %s, so it is not a registry", resp.Status)
+	}
+	if manifest == "" {
+		return SeverityOK, outcome
+	}
+
+	repository, reference, ok := parseManifestReference(manifest)
+	if !ok {
+		return SeverityFatal, outcome + fmt.Sprintf(", but %s is not a repository:tag or repository@digest", manifest)
+	}
+	if scheme, _ := parseChallenge(challenge); scheme == "bearer" {
+		// Tokens are scoped, so pulling needs another
+		if authorization, err = p.authorize(ctx, challenge, "repository:"+repository+":pull"); err != nil {
+			return SeverityFatal, outcome + ", but " + p.describeError(err)
+		}
+	}
+	resp, err = p.do(ctx, http.MethodHead, "/v2/"+repository+"/manifests/"+reference, authorization)
+	if err != nil {
+		return SeverityFatal, outcome + ", but " + p.describeError(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return SeverityOK, outcome + ", and can pull " + manifest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return SeverityFatal, outcome + ", but does not allow pulling " + manifest
+	case http.StatusNotFound:
+		return SeverityWarning, outcome + ", but does not have " + manifest
+	}
+	return SeverityWarning, outcome + fmt.Sprintf(", but answered HEAD for %s with %s", manifest, resp.Status)
+}
+
+// do makes a request of the registry, discarding the body.
+func (p registryProbe) do(ctx context.Context, method, path, authorization string) (*http.Response, error) {
+	u := *p.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	return resp, nil
+}
+
+// credentials returns the configured username and password, from either
+// the separate fields or the combined auth field.
+func (p registryProbe) credentials() (username, password string, ok bool) {
+	if p.auth == nil {
+		return "", "", false
+	}
+	if p.auth.Username != "" || p.auth.Password != "" {
+		return p.auth.Username, p.auth.Password, true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(p.auth.Auth)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+func (p registryProbe) credentialed() bool {
+	_, _, ok := p.credentials()
+	return ok
+}
+
+// authorize answers an authentication challenge, returning the value of
+// the Authorization header to send.  Token challenges are answered by
+// fetching a token with the given scope from the challenge's realm.
+func (p registryProbe) authorize(ctx context.Context, challenge, scope string) (string, error) {
+	username, password, ok := p.credentials()
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if !ok {
+			return "", errors.New("requires credentials, but none are configured for it in containerd-registry")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || realm.Host == "" {
+			return "", errors.New("asked for a token, but did not say where to get one")
+		}
+		query := realm.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		if scope != "" {
+			query.Set("scope", scope)
+		}
+		realm.RawQuery = query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			if !ok {
+				return "", errors.New("requires credentials, but none are configured for it in containerd-registry")
+			}
+			return "", errCredentialsRejected
+		case resp.StatusCode != http.StatusOK:
+			return "", fmt.Errorf("token service %s answered with %s", realm.Host, resp.Status)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+			return "", fmt.Errorf("token service %s did not return a token", realm.Host)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		if token.Token == "" {
+			return "", fmt.Errorf("token service %s did not return a token", realm.Host)
+		}
+		return "Bearer " + token.Token, nil
+	}
+	return "", fmt.Errorf("asked for %q authentication, which is not supported", scheme)
+}
+
+// describeError turns an error probing the registry into the rest of a
+// sentence.
+func (p registryProbe) describeError(err error) string {
+	if certErr := certificateError(err); certErr != nil {
+		return fmt.Sprintf("has a TLS certificate which is not trusted: %v. Add the CA which issued it to additional-ca in the system settings", certErr)
+	}
+	if errors.Is(err, errCredentialsRejected) {
+		return err.Error()
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Sprintf("cannot be reached: %v", redactedError(err))
+	}
+	return err.Error()
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g. `Bearer
+// realm="https://auth.example.com/token",service="registry"`, returning
+// the scheme in lower case and its parameters.
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(params[key])
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return strings.ToLower(scheme), params
+}
+
+// parseManifestReference splits a manifest reference, e.g.
+// library/busybox:1.36 or library/busybox@sha256:..., into the
+// repository and the tag or digest.
+func parseManifestReference(manifest string) (repository, reference string, ok bool) {
+	if repository, digest, found := strings.Cut(manifest, "@"); found {
+		return repository, digest, repository != "" && digest != ""
+	}
+	i := strings.LastIndex(manifest, ":")
+	if i < 0 || strings.Contains(manifest[i:], "/") {
+		return "", "", false
+	}
+	return manifest[:i], manifest[i+1:], i > 0 && i < len(manifest)-1
+}
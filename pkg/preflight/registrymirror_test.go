@@ -0,0 +1,263 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	registryUser     = "mirror-bot"
+	registryPassword = "s3cret-Passw0rd"
+	registryManifest = "library/busybox:1.36"
+)
+
+// newBasicAuthRegistry returns a registry which requires basic
+// authentication, and has registryManifest.
+func newBasicAuthRegistry(tlsServer bool) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != registryUser || password != registryPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/", "/v2/library/busybox/manifests/1.36":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	if tlsServer {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+// newTokenAuthRegistry returns a registry which requires tokens from its
+// own token service, which only gives them for basic authentication.
+// Tokens are for the scope they were asked for.
+func newTokenAuthRegistry() *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, password, ok := r.BasicAuth(); !ok || user != registryUser || password != registryPassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "token-for-" + r.URL.Query().Get("scope")})
+			return
+		}
+		challenge := fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL)
+		switch auth := r.Header.Get("Authorization"); {
+		case r.URL.Path == "/v2/" && strings.HasPrefix(auth, "Bearer token-for-"):
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/library/busybox/manifests/1.36" && auth == "Bearer token-for-repository:library/busybox:pull":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	return server
+}
+
+// mirrorSetting returns a containerd-registry setting with the server as
+// the docker.io mirror, with the given credentials.
+func mirrorSetting(server *httptest.Server, user, password string, insecure bool) string {
+	u, _ := url.Parse(server.URL)
+	registry := map[string]interface{}{
+		"mirrors": map[string]interface{}{
+			"docker.io": map[string]interface{}{"endpoint": []string{server.URL}},
+		},
+	}
+	hostConfig := map[string]interface{}{}
+	if user != "" {
+		hostConfig["auth"] = map[string]string{"username": user, "password": password}
+	}
+	if insecure {
+		hostConfig["tls"] = map[string]bool{"insecure_skip_verify": true}
+	}
+	registry["configs"] = map[string]interface{}{u.Host: hostConfig}
+	data, _ := json.Marshal(registry)
+	return string(data)
+}
+
+func TestNewRegistryMirrorCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.SystemSettings = map[string]string{
+		"containerd-registry": `{"mirrors":{}}`,
+		"additional-ca":       "-----BEGIN CERTIFICATE-----",
+	}
+	check := NewRegistryMirrorCheck(cfg)
+	assert.Equal(t, `{"mirrors":{}}`, check.Registries)
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----", check.CACerts)
+}
+
+func TestRegistryMirrorCheck(t *testing.T) {
+	basic := newBasicAuthRegistry(false)
+	defer basic.Close()
+	token := newTokenAuthRegistry()
+	defer token.Close()
+	secure := newBasicAuthRegistry(true)
+	defer secure.Close()
+	host := func(server *httptest.Server) string {
+		return strings.TrimPrefix(strings.TrimPrefix(server.URL, "http://"), "https://")
+	}
+	secureCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: secure.Certificate().Raw}))
+
+	tests := []struct {
+		name       string
+		registries string
+		caCerts    string
+		manifest   string
+		production bool
+		severity   Severity
+		message    string
+	}{
+		{
+			name:    "none",
+			message: "Skipped: the install configuration does not set any registry mirrors.",
+		},
+		{
+			name:       "not JSON",
+			registries: `{"mirrors": {"docker.io": {"endpoint": ["` + registryPassword,
+			severity:   SeverityFatal,
+			message:    "The containerd-registry system setting is not valid JSON.",
+		},
+		{
+			name:       "not a URL",
+			registries: `{"mirrors": {"docker.io": {"endpoint": ["ftp://` + registryUser + ":" + registryPassword + `@mirror"]}}}`,
+			severity:   SeverityFatal,
+			message:    "Mirror 1 of docker.io is not an http or https URL.",
+		},
+		{
+			name:       "basic authentication",
+			registries: mirrorSetting(basic, registryUser, registryPassword, false),
+			manifest:   registryManifest,
+			message:    "docker.io mirror " + host(basic) + " accepted the configured credentials, and can pull library/busybox:1.36.",
+		},
+		{
+			name:       "basic authentication, bad credentials",
+			registries: mirrorSetting(basic, registryUser, "hunter2", false),
+			severity:   SeverityFatal,
+			message:    "docker.io mirror " + host(basic) + " rejected the configured credentials.",
+		},
+		{
+			name:       "basic authentication, no credentials",
+			registries: mirrorSetting(basic, "", "", false),
+			severity:   SeverityFatal,
+			message:    "docker.io mirror " + host(basic) + " requires credentials, but none are configured for it in containerd-registry.",
+		},
+		{
+			name:       "manifest missing",
+			registries: mirrorSetting(basic, registryUser, registryPassword, false),
+			manifest:   "library/alpine:3.20",
+			severity:   SeverityWarning,
+			message:    "docker.io mirror " + host(basic) + " accepted the configured credentials, but does not have library/alpine:3.20.",
+		},
+		{
+			name:       "plain HTTP in production",
+			registries: mirrorSetting(basic, registryUser, registryPassword, false),
+			production: true,
+			severity:   SeverityWarning,
+			message: "docker.io mirror " + host(basic) + " accepted the configured credentials. " +
+				"docker.io mirror " + host(basic) + " is used without verifying its identity, so images could be tampered with; " +
+				"production hosts should use https with a trusted certificate.",
+		},
+		{
+			name:       "token authentication",
+			registries: mirrorSetting(token, registryUser, registryPassword, false),
+			manifest:   registryManifest,
+			message:    "docker.io mirror " + host(token) + " accepted the configured credentials, and can pull library/busybox:1.36.",
+		},
+		{
+			name:       "token authentication, bad credentials",
+			registries: mirrorSetting(token, "someone", registryPassword, false),
+			severity:   SeverityFatal,
+			message:    "docker.io mirror " + host(token) + " rejected the configured credentials.",
+		},
+		{
+			name:       "trusted by the additional CA",
+			registries: mirrorSetting(secure, registryUser, registryPassword, false),
+			caCerts:    secureCA,
+			production: true,
+			message:    "docker.io mirror " + host(secure) + " accepted the configured credentials.",
+		},
+		{
+			name:       "certificate not verified",
+			registries: mirrorSetting(secure, registryUser, registryPassword, true),
+			message:    "docker.io mirror " + host(secure) + " accepted the configured credentials.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := RegistryMirrorCheck{Registries: tt.registries, CACerts: tt.caCerts}
+			env := &Env{Options: Options{Production: tt.production, RegistryProbeManifest: tt.manifest}}
+			result, err := check.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "RegistryMirror", Severity: tt.severity, Message: tt.message}, result)
+			assert.NotContains(t, result.Message, registryPassword)
+			assert.NotContains(t, result.Message, registryUser)
+		})
+	}
+}
+
+func TestRegistryMirrorCheckUntrusted(t *testing.T) {
+	secure := newBasicAuthRegistry(true)
+	defer secure.Close()
+
+	check := RegistryMirrorCheck{Registries: mirrorSetting(secure, registryUser, registryPassword, false)}
+	result, err := check.Evaluate(context.Background(), &Env{})
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityFatal, result.Severity)
+	assert.Contains(t, result.Message, "has a TLS certificate which is not trusted: ")
+	assert.Contains(t, result.Message, "Add the CA which issued it to additional-ca in the system settings.")
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull,push"`)
+	assert.Equal(t, "bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:a/b:pull,push",
+	}, params)
+
+	scheme, params = parseChallenge(`Basic realm=registry`)
+	assert.Equal(t, "basic", scheme)
+	assert.Equal(t, map[string]string{"realm": "registry"}, params)
+}
+
+func TestParseManifestReference(t *testing.T) {
+	tests := []struct {
+		manifest   string
+		repository string
+		reference  string
+		ok         bool
+	}{
+		{"library/busybox:1.36", "library/busybox", "1.36", true},
+		{"library/busybox@sha256:abc", "library/busybox", "sha256:abc", true},
+		{"registry:5000/busybox", "", "", false},
+		{"busybox", "", "", false},
+		{"busybox:", "", "", false},
+	}
+	for _, tt := range tests {
+		repository, reference, ok := parseManifestReference(tt.manifest)
+		assert.Equal(t, tt.ok, ok, tt.manifest)
+		if tt.ok {
+			assert.Equal(t, tt.repository, repository)
+			assert.Equal(t, tt.reference, reference)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package preflight
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rancher/wharfie/pkg/registries"
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// DefaultRegistryEndpoints are the registries images are pulled from
+// during bootstrap, unless the host is air-gapped.
+var DefaultRegistryEndpoints = []string{"https://index.docker.io"}
+
+// RegistryReachabilityCheck verifies that the registries images are
+// pulled from during bootstrap can be reached, because otherwise the
+// install hangs for ages before failing.  Each endpoint's /v2/ is
+// fetched, through the configured proxy, within Timeout, and any which
+// don't answer as a registry does, with or without asking for
+// credentials, are fatal, with the reason: the name not resolving, the
+// connection being refused or timing out, the proxy wanting credentials,
+// or a certificate which isn't trusted, which is a private registry's
+// needing its CA added rather than its being unreachable.  Whether the
+// credentials work is RegistryMirrorCheck's business.  With no endpoints,
+// as when fully air-gapped, there's nothing to check, and when the host
+// is air-gapped, endpoints on the internet aren't probed.
+type RegistryReachabilityCheck struct {
+	// Endpoints are the registries' URLs, e.g. "https://index.docker.io",
+	// or https is assumed if there's no scheme.
+	Endpoints []string
+	// CACerts are PEM encoded certificates to trust in addition to the
+	// system CAs.
+	CACerts string
+	// InsecureHosts are the hosts whose certificates aren't verified, as
+	// the containerd-registry system setting may say of mirrors.
+	InsecureHosts []string
+	Proxy         httpproxy.Config
+	// Timeout is how long each endpoint may take to answer, or
+	// registryTimeout if zero.
+	Timeout time.Duration
+	// Client makes the requests, if set, rather than one which trusts
+	// CACerts and uses Proxy, so tests can use their own.
+	Client *http.Client
+}
+
+// NewRegistryReachabilityCheck returns a RegistryReachabilityCheck for the
+// given install configuration, which checks DefaultRegistryEndpoints
+// unless it's air-gapped.  A registry which the containerd-registry system
+// setting mirrors is pulled from through its mirrors, which are checked
+// instead, as sites with mirrors often firewall the registries themselves.
+func NewRegistryReachabilityCheck(cfg *config.HarvesterConfig) RegistryReachabilityCheck {
+	c := RegistryReachabilityCheck{
+		CACerts: cfg.SystemSettings["additional-ca"],
+		Proxy:   proxyFromConfig(cfg),
+		Timeout: registryTimeout,
+	}
+	if cfg.Install.AirGapped {
+		return c
+	}
+	var registry registries.Registry
+	// RegistryMirrorCheck reports a setting which isn't valid JSON
+	_ = json.Unmarshal([]byte(cfg.SystemSettings["containerd-registry"]), &registry)
+	for _, endpoint := range DefaultRegistryEndpoints {
+		mirror, ok := registry.Mirrors[registryName(endpoint)]
+		if !ok {
+			mirror = registry.Mirrors["*"]
+		}
+		if len(mirror.Endpoints) == 0 {
+			c.Endpoints = append(c.Endpoints, endpoint)
+			continue
+		}
+		for _, m := range mirror.Endpoints {
+			c.Endpoints = append(c.Endpoints, m)
+			if u, err := url.Parse(strings.TrimSpace(m)); err == nil {
+				if hostTLS := registry.Configs[u.Host].TLS; hostTLS != nil && hostTLS.InsecureSkipVerify {
+					c.InsecureHosts = append(c.InsecureHosts, u.Host)
+				}
+			}
+		}
+	}
+	return c
+}
+
+// registryName returns the name containerd knows the registry at endpoint
+// by, e.g. docker.io for https://index.docker.io.
+func registryName(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	switch u.Host {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return u.Host
+}
+
+func (c RegistryReachabilityCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c RegistryReachabilityCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "RegistryReachability"
+	if len(c.Endpoints) == 0 {
+		return
+	}
+
+	roots, _ := rootCAs(c.CACerts)
+	proxy := c.Proxy.ProxyFunc()
+	client := func(insecure bool) *http.Client {
+		if c.Client != nil {
+			return c.Client
+		}
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, InsecureSkipVerify: insecure},
+				Proxy: func(req *http.Request) (*url.URL, error) {
+					return proxy(req.URL)
+				},
+			},
+		}
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = registryTimeout
+	}
+
+	var reachable, unreachable, skipped []string
+	for i, endpoint := range c.Endpoints {
+		endpoint = strings.TrimSpace(endpoint)
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			// Not the endpoint, which may have credentials in it
+			unreachable = append(unreachable, fmt.Sprintf("Registry endpoint %d is not an http or https URL.", i+1))
+			continue
+		}
+		u.User = nil
+		if env.Options.AirGapped && onInternet(u.Hostname()) {
+			skipped = append(skipped, notContacted(u.Host))
+			result.AirGapped = true
+			continue
+		}
+		if problem := probeRegistry(ctx, client(slices.Contains(c.InsecureHosts, u.Host)), u, timeout); problem != "" {
+			unreachable = append(unreachable, fmt.Sprintf("%s %s.", u.Host, problem))
+		} else {
+			reachable = append(reachable, u.Host)
+		}
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+	}
+
+	var msgs []string
+	if len(unreachable) > 0 {
+		result.Severity = SeverityFatal
+		msgs = append(msgs, "Images cannot be pulled from every registry, so the install would hang during bootstrap.")
+		msgs = append(msgs, unreachable...)
+	}
+	if len(reachable) > 0 {
+		msgs = append(msgs, fmt.Sprintf("Reachable registries: %s.", strings.Join(reachable, ", ")))
+	}
+	result.Message = strings.Join(append(msgs, skipped...), " ")
+	if len(msgs) == 0 {
+		result.Message = "Skipped: " + result.Message
+	}
+	return
+}
+
+// probeRegistry fetches the registry's /v2/ within timeout, returning
+// what's wrong, completing a sentence starting with its host, or "" if it
+// answered as a registry.
+func probeRegistry(ctx context.Context, client *http.Client, u *url.URL, timeout time.Duration) string {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	probe := *u
+	probe.Path = strings.TrimSuffix(probe.Path, "/") + "/v2/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe.String(), nil)
+	if err != nil {
+		return fmt.Sprintf("cannot be reached: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return describeReachabilityError(err, timeout)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusUnauthorized:
+		return ""
+	case http.StatusProxyAuthRequired:
+		return "cannot be reached, because the proxy requires credentials (407 Proxy Authentication Required)"
+	}
+	return fmt.Sprintf("answered GET /v2/ with %s, so it is not a registry", resp.Status)
+}
+
+// describeReachabilityError says why a registry couldn't be reached,
+// completing a sentence starting with its host.
+func describeReachabilityError(err error, timeout time.Duration) string {
+	var dnsErr *net.DNSError
+	switch {
+	case certificateError(err) != nil:
+		return fmt.Sprintf("has a TLS certificate which is not trusted: %v. "+
+			"If it's a private registry, add the CA which issued it to additional-ca in the system settings", certificateError(err))
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("cannot be resolved: %s", dnsErr.Err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused the connection"
+	case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+		return fmt.Sprintf("did not answer within %s", timeout)
+	case strings.Contains(err.Error(), http.StatusText(http.StatusProxyAuthRequired)):
+		// Which is how a proxy refusing to CONNECT is reported
+		return "cannot be reached, because the proxy requires credentials (407 Proxy Authentication Required)"
+	}
+	return fmt.Sprintf("cannot be reached: %v", redactedError(err))
+}
@@ -0,0 +1,141 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A RemediationKind is a kind of fix a RemediationAction makes.
+type RemediationKind string
+
+const (
+	// RemediationModuleLoad loads the kernel module Target.
+	RemediationModuleLoad RemediationKind = "module-load"
+	// RemediationSysctlSet sets the sysctl Target to Value.
+	RemediationSysctlSet RemediationKind = "sysctl-set"
+	// RemediationServiceEnable enables and starts the systemd unit Target.
+	RemediationServiceEnable RemediationKind = "service-enable"
+)
+
+// autoRemediable are the kinds of RemediationAction the Runner may take
+// by itself, when Options.AutoRemediate is set.  They only change the
+// live environment, and are easily undone.  Nothing which could destroy
+// data may ever be added.
+var autoRemediable = map[RemediationKind]bool{
+	RemediationModuleLoad:    true,
+	RemediationSysctlSet:     true,
+	RemediationServiceEnable: true,
+}
+
+// A Remediation says how to fix what a check found: Hint for people, and
+// the Actions which would do it, if it can be done by a command.
+type Remediation struct {
+	Hint    string              `json:"hint"`
+	Actions []RemediationAction `json:"actions,omitempty"`
+}
+
+// A RemediationAction is a fix which can be made by a command.
+type RemediationAction struct {
+	Kind   RemediationKind `json:"kind"`
+	Target string          `json:"target"`
+	Value  string          `json:"value,omitempty"`
+}
+
+// command returns the command line which makes the fix, or nil if the
+// action's kind isn't known.
+func (a RemediationAction) command() []string {
+	switch a.Kind {
+	case RemediationModuleLoad:
+		return []string{"/usr/sbin/modprobe", a.Target}
+	case RemediationSysctlSet:
+		return []string{"/usr/sbin/sysctl", "-w", a.Target + "=" + a.Value}
+	case RemediationServiceEnable:
+		return []string{"/usr/bin/systemctl", "enable", "--now", a.Target}
+	}
+	return nil
+}
+
+// String returns the command line which makes the fix, for people.
+func (a RemediationAction) String() string {
+	if command := a.command(); command != nil {
+		return strings.Join(command, " ")
+	}
+	return fmt.Sprintf("%s %s", a.Kind, a.Target)
+}
+
+// commands returns the command lines which make actions, for hints.
+func commands(actions []RemediationAction) string {
+	lines := make([]string, len(actions))
+	for i, action := range actions {
+		lines[i] = action.String()
+	}
+	return strings.Join(lines, "; ")
+}
+
+// Remediated records what the Runner did to fix what a check found, when
+// Options.AutoRemediate is set: the Actions it took, or refused to, and
+// the Severity and Message of the check before, since the Result is then
+// that of running it again.
+type Remediated struct {
+	Actions  []RemediationTaken `json:"actions"`
+	Severity Severity           `json:"severity"`
+	Message  string             `json:"message,omitempty"`
+}
+
+// A RemediationTaken is a RemediationAction the Runner took, or refused
+// to, in which case Error says why, as it does if the action failed.
+type RemediationTaken struct {
+	RemediationAction
+	Error string `json:"error,omitempty"`
+}
+
+// remediate takes the actions in result.Remediation, and evaluates check
+// again, returning its new Result, with what was done in Remediated.
+// Actions of kinds which aren't autoRemediable are refused.  If none
+// were taken, result is returned as it was, apart from recording that.
+func remediate(ctx context.Context, check ResultCheck, env *Env, result Result) (Result, error) {
+	remediated := &Remediated{Severity: result.Severity, Message: result.Message}
+	taken := 0
+	for _, action := range result.Remediation.Actions {
+		outcome := RemediationTaken{RemediationAction: action}
+		if !autoRemediable[action.Kind] {
+			outcome.Error = fmt.Sprintf("%s actions are not taken automatically", action.Kind)
+			remediated.Actions = append(remediated.Actions, outcome)
+			continue
+		}
+		command := action.command()
+		if out, err := env.command(command[0], command[1:]...).CombinedOutput(); err != nil {
+			outcome.Error = err.Error()
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				outcome.Error += ": " + msg
+			}
+		} else {
+			taken++
+		}
+		remediated.Actions = append(remediated.Actions, outcome)
+	}
+	if taken == 0 {
+		result.Remediated = remediated
+		return result, nil
+	}
+
+	// The host has changed, so what the tools said about it is stale
+	env.tools = nil
+	recheck, err := check.Evaluate(ctx, env)
+	recheck.Remediated = remediated
+	return recheck, err
+}
+
+// fixed returns whether any of the actions in r were taken successfully.
+func (r *Remediated) fixed() bool {
+	if r == nil {
+		return false
+	}
+	for _, action := range r.Actions {
+		if action.Error == "" {
+			return true
+		}
+	}
+	return false
+}
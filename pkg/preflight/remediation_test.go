@@ -0,0 +1,187 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixableCheck warns until the sysctl it recommends has been set, which
+// is noticed by faking the commands.
+type fixableCheck struct {
+	actions []RemediationAction
+	fixed   *bool
+	runs    *int
+}
+
+func (c fixableCheck) Evaluate(_ context.Context, _ *Env) (Result, error) {
+	*c.runs++
+	if *c.fixed {
+		return Result{Name: "Fixable"}, nil
+	}
+	return Result{
+		Name:        "Fixable",
+		Severity:    SeverityWarning,
+		Message:     "vm.swappiness is 100.",
+		Remediation: &Remediation{Hint: "Set it with: " + commands(c.actions) + ".", Actions: c.actions},
+	}, nil
+}
+
+// fakeRemediationCommands returns a fake for the Runner's ExecCommand,
+// which records the command lines run, which succeed if ok says so.
+func fakeRemediationCommands(ok func(command string) bool) (func(string, ...string) *exec.Cmd, *[]string) {
+	ran := &[]string{}
+	return func(name string, args ...string) *exec.Cmd {
+		command := strings.Join(append([]string{name}, args...), " ")
+		*ran = append(*ran, command)
+		if ok(command) {
+			return fakeExecCommand("modprobe-ok")
+		}
+		return fakeExecCommand("modprobe-fail")
+	}, ran
+}
+
+func TestRemediationHints(t *testing.T) {
+	actions := []RemediationAction{
+		{Kind: RemediationModuleLoad, Target: "dm_crypt"},
+		{Kind: RemediationSysctlSet, Target: "vm.swappiness", Value: "60"},
+		{Kind: RemediationServiceEnable, Target: "systemd-timesyncd.service"},
+		{Kind: "disk-wipe", Target: "/dev/sda"},
+	}
+	assert.Equal(t, "/usr/sbin/modprobe dm_crypt; /usr/sbin/sysctl -w vm.swappiness=60; "+
+		"/usr/bin/systemctl enable --now systemd-timesyncd.service; disk-wipe /dev/sda", commands(actions))
+}
+
+func TestAutoRemediate(t *testing.T) {
+	fixed, runs := false, 0
+	command, ran := fakeRemediationCommands(func(command string) bool {
+		fixed = command == "/usr/sbin/sysctl -w vm.swappiness=60"
+		return true
+	})
+	action := RemediationAction{Kind: RemediationSysctlSet, Target: "vm.swappiness", Value: "60"}
+	check := fixableCheck{actions: []RemediationAction{action}, fixed: &fixed, runs: &runs}
+
+	// Nothing is done unless it's asked for
+	runner := Runner{Checks: []ResultCheck{check}, ExecCommand: command}
+	report := runner.Run(context.Background())
+	assert.Equal(t, SeverityWarning, report.Results[0].Severity)
+	assert.Nil(t, report.Results[0].Remediated)
+	assert.Empty(t, *ran)
+	assert.Equal(t, 1, runs)
+
+	// The fix is made, and the check run again
+	runner.Options.AutoRemediate = true
+	report = runner.Run(context.Background())
+	result := report.Results[0]
+	assert.Equal(t, []string{"/usr/sbin/sysctl -w vm.swappiness=60"}, *ran)
+	assert.Equal(t, 3, runs)
+	assert.Equal(t, SeverityOK, result.Severity)
+	assert.Empty(t, result.Message)
+	assert.Equal(t, &Remediated{
+		Actions:  []RemediationTaken{{RemediationAction: action}},
+		Severity: SeverityWarning,
+		Message:  "vm.swappiness is 100.",
+	}, result.Remediated)
+	assert.Empty(t, report.Fatal())
+
+	// A check which passes isn't touched
+	*ran = nil
+	report = runner.Run(context.Background())
+	assert.Nil(t, report.Results[0].Remediated)
+	assert.Empty(t, *ran)
+}
+
+// blockingFixableCheck is a fixableCheck which, once fixed, blocks until
+// it's cancelled.
+type blockingFixableCheck struct {
+	fixableCheck
+}
+
+func (c blockingFixableCheck) Evaluate(ctx context.Context, env *Env) (Result, error) {
+	result, err := c.fixableCheck.Evaluate(ctx, env)
+	if *c.fixed {
+		<-ctx.Done()
+		return result, ctx.Err()
+	}
+	return result, err
+}
+
+// Remediating a check, and running it again, is within its time.
+func TestAutoRemediateTimeout(t *testing.T) {
+	fixed, runs := false, 0
+	// The fix itself is quick, rather than a run of the test binary, which
+	// under the race detector can take the check's whole time to start
+	command := func(string, ...string) *exec.Cmd {
+		fixed = true
+		return exec.Command("/bin/sh", "-c", "exit 0")
+	}
+	action := RemediationAction{Kind: RemediationSysctlSet, Target: "vm.swappiness", Value: "60"}
+	check := blockingFixableCheck{fixableCheck{actions: []RemediationAction{action}, fixed: &fixed, runs: &runs}}
+	runner := Runner{
+		Checks:      []ResultCheck{check, fakeCheck{result: Result{Name: "Last"}}},
+		Options:     Options{AutoRemediate: true, CheckTimeout: 200 * time.Millisecond},
+		ExecCommand: command,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	started := time.Now()
+	report := runner.Run(ctx)
+	assert.Less(t, time.Since(started), 5*time.Second)
+	assert.False(t, report.Cancelled)
+	assert.Equal(t, 2, runs)
+	assert.Equal(t, Result{Name: "Fixable", Error: "timed out after 200ms", ErrorKind: ErrorKindTimeout,
+		Duration: report.Results[0].Duration}, report.Results[0])
+	assert.Equal(t, Result{Name: "Last", Duration: report.Results[1].Duration}, report.Results[1])
+}
+
+func TestAutoRemediateFailure(t *testing.T) {
+	fixed, runs := false, 0
+	command, ran := fakeRemediationCommands(func(command string) bool { return strings.Contains(command, "modprobe") })
+	check := fixableCheck{actions: []RemediationAction{
+		{Kind: RemediationSysctlSet, Target: "vm.swappiness", Value: "60"},
+		{Kind: RemediationModuleLoad, Target: "dm_crypt"},
+	}, fixed: &fixed, runs: &runs}
+
+	// What failed is recorded, and the check is still run again, since
+	// something else was done
+	runner := Runner{Checks: []ResultCheck{check}, ExecCommand: command}
+	runner.Options.AutoRemediate = true
+	result := runner.Run(context.Background()).Results[0]
+	assert.Equal(t, []string{"/usr/sbin/sysctl -w vm.swappiness=60", "/usr/sbin/modprobe dm_crypt"}, *ran)
+	assert.Equal(t, 2, runs)
+	assert.Equal(t, SeverityWarning, result.Severity)
+	assert.Len(t, result.Remediated.Actions, 2)
+	assert.Equal(t, "exit status 1", result.Remediated.Actions[0].Error)
+	assert.Empty(t, result.Remediated.Actions[1].Error)
+	assert.True(t, result.Remediated.fixed())
+}
+
+func TestAutoRemediateRefused(t *testing.T) {
+	fixed, runs := false, 0
+	command, ran := fakeRemediationCommands(func(string) bool {
+		fixed = true
+		return true
+	})
+	wipe := RemediationAction{Kind: "disk-wipe", Target: "/dev/sda"}
+	check := fixableCheck{actions: []RemediationAction{wipe}, fixed: &fixed, runs: &runs}
+
+	// Nothing which isn't whitelisted is run, and as nothing was done,
+	// the check isn't run again
+	runner := Runner{Checks: []ResultCheck{check}, ExecCommand: command}
+	runner.Options.AutoRemediate = true
+	result := runner.Run(context.Background()).Results[0]
+	assert.Empty(t, *ran)
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, SeverityWarning, result.Severity)
+	assert.Equal(t, &Remediated{
+		Actions:  []RemediationTaken{{RemediationAction: wipe, Error: "disk-wipe actions are not taken automatically"}},
+		Severity: SeverityWarning,
+		Message:  "vm.swappiness is 100.",
+	}, result.Remediated)
+	assert.False(t, result.Remediated.fixed())
+	assert.False(t, autoRemediable["disk-wipe"])
+}
@@ -0,0 +1,258 @@
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// A RenderMode is how much of a report a Renderer shows.
+type RenderMode int
+
+const (
+	// RenderDefault shows a line for each check, and the summary.
+	RenderDefault RenderMode = iota
+	// RenderQuiet only shows the checks which didn't pass, and the
+	// summary.
+	RenderQuiet
+	// RenderVerbose shows what each check measured, the thresholds it
+	// applied, how to fix what it found and what was fixed, how long it
+	// took, and why it was skipped or couldn't run, under its line.
+	RenderVerbose
+)
+
+// ParseRenderMode returns the RenderMode asked for by the --quiet and
+// --verbose flags, which can't both be given.
+func ParseRenderMode(quiet, verbose bool) (RenderMode, error) {
+	switch {
+	case quiet && verbose:
+		return RenderDefault, errors.New("--quiet and --verbose can't be used together")
+	case quiet:
+		return RenderQuiet, nil
+	case verbose:
+		return RenderVerbose, nil
+	}
+	return RenderDefault, nil
+}
+
+// skippedPrefix starts the message of a result which was skipped, e.g.
+// because the check doesn't apply on this platform, or the run was
+// cancelled.
+const skippedPrefix = "Skipped: "
+
+// ANSI SGR sequences for the labels, which are only written when a
+// Renderer has Color set.
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+	colorDim    = "\x1b[2m"
+)
+
+// A Renderer writes a report for people to read on a terminal, with a
+// line for each check, aligned, and a summary line.  The labels are
+// colored if Color is set, which NewRenderer only does if the output is
+// a terminal and NO_COLOR isn't set.
+type Renderer struct {
+	Mode  RenderMode
+	Color bool
+}
+
+// isTerminal returns whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// NewRenderer returns a Renderer for writing to w in mode, in color if w
+// is a terminal, unless NO_COLOR is set (https://no-color.org).
+func NewRenderer(w io.Writer, mode RenderMode) Renderer {
+	return newRenderer(w, mode, isTerminal)
+}
+
+// newRenderer is NewRenderer, with terminal saying whether a file is a
+// terminal.
+func newRenderer(w io.Writer, mode RenderMode, terminal func(*os.File) bool) Renderer {
+	f, ok := w.(*os.File)
+	color := ok && terminal(f) && os.Getenv("NO_COLOR") == ""
+	return Renderer{Mode: mode, Color: color}
+}
+
+// label returns the label of result, and its color.
+func (r Renderer) label(result Result) (string, string) {
+	switch {
+	case result.Error != "":
+		return "ERR", colorRed
+	case strings.HasPrefix(result.Message, skippedPrefix):
+		return "SKIP", colorDim
+	}
+	switch result.Severity {
+	case SeverityOK:
+		return "PASS", colorGreen
+	case SeverityInfo:
+		return "INFO", colorCyan
+	case SeverityWarning:
+		return "WARN", colorYellow
+	case SeverityFatal:
+		return "FAIL", colorRed
+	}
+	return strings.ToUpper(result.Severity.String()), ""
+}
+
+// passed returns whether result is one quiet mode leaves out.
+func passed(result Result) bool {
+	return result.Error == "" && result.Severity <= SeverityInfo
+}
+
+// Render writes report to w.
+func (r Renderer) Render(w io.Writer, report Report) error {
+	width := 0
+	for _, result := range report.Results {
+		width = max(width, len(result.Name))
+	}
+	for _, result := range report.Results {
+		if r.Mode == RenderQuiet && passed(result) {
+			continue
+		}
+		if _, err := io.WriteString(w, r.line(result, width)); err != nil {
+			return err
+		}
+		if r.Mode == RenderVerbose {
+			for _, detail := range details(result) {
+				if _, err := fmt.Fprintf(w, "%*s  %s\n", 4+2+width, "", detail); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, err := fmt.Fprintln(w, summary(report))
+	return err
+}
+
+// line returns the line for result, with its name padded to width.
+func (r Renderer) line(result Result, width int) string {
+	label, color := r.label(result)
+	label = fmt.Sprintf("%-4s", label)
+	if r.Color && color != "" {
+		label = color + label + colorReset
+	}
+	msg := result.Message
+	switch {
+	case result.Error != "":
+		msg = "The check could not be run."
+	case strings.HasPrefix(msg, skippedPrefix):
+		msg = "Skipped."
+	}
+	if result.Overridden {
+		msg += " (overridden)"
+	}
+	if result.AirGapped {
+		msg += " (" + airGappedMode + ")"
+	}
+	if result.Remediated.fixed() {
+		msg += " (remediated)"
+	}
+	msg = strings.TrimSpace(msg)
+	return strings.TrimRight(fmt.Sprintf("%s  %-*s  %s", label, width, result.Name, msg), " ") + "\n"
+}
+
+// details returns what verbose mode adds under the line for result.
+func details(result Result) []string {
+	var lines []string
+	if result.Error != "" {
+		line := "error: " + result.Error
+		if result.ErrorKind != "" {
+			line += " (" + string(result.ErrorKind) + ")"
+		}
+		lines = append(lines, line)
+	}
+	if reason, ok := strings.CutPrefix(result.Message, skippedPrefix); ok {
+		lines = append(lines, "skipped: "+strings.TrimSuffix(reason, "."))
+	}
+	if len(result.Facts) > 0 {
+		lines = append(lines, "measured: "+formatValues(result.Facts))
+	}
+	if len(result.Thresholds) > 0 {
+		lines = append(lines, "thresholds: "+formatValues(result.Thresholds))
+	}
+	if result.Remediation != nil && result.Remediation.Hint != "" {
+		lines = append(lines, "fix: "+result.Remediation.Hint)
+	}
+	if result.Remediated != nil {
+		for _, action := range result.Remediated.Actions {
+			if action.Error == "" {
+				lines = append(lines, "remediated: "+action.String())
+			} else {
+				lines = append(lines, "not remediated: "+action.String()+": "+action.Error)
+			}
+		}
+		if result.Remediated.fixed() {
+			before := strings.ToUpper(result.Remediated.Severity.String())
+			if result.Remediated.Message != "" {
+				before += " " + result.Remediated.Message
+			}
+			lines = append(lines, "before remediation: "+before)
+		}
+	}
+	if result.Duration > 0 {
+		lines = append(lines, "took: "+result.Duration.Round(time.Millisecond/10).String())
+	}
+	return lines
+}
+
+// formatValues returns values as "name=value" pairs, in order of name.
+func formatValues(values map[string]any) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", name, values[name]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// summary returns the line counting the outcomes of report's checks.
+func summary(report Report) string {
+	var passed, warned, failed, errored, skipped int
+	for _, result := range report.Results {
+		switch {
+		case result.Error != "":
+			errored++
+		case strings.HasPrefix(result.Message, skippedPrefix):
+			skipped++
+		case result.Severity == SeverityFatal:
+			failed++
+		case result.Severity == SeverityWarning:
+			warned++
+		default:
+			passed++
+		}
+	}
+	counts := []string{fmt.Sprintf("%d passed", passed), fmt.Sprintf("%d warned", warned), fmt.Sprintf("%d failed", failed)}
+	if errored > 0 {
+		counts = append(counts, fmt.Sprintf("%d could not run", errored))
+	}
+	if skipped > 0 {
+		counts = append(counts, fmt.Sprintf("%d skipped", skipped))
+	}
+	checks := "checks"
+	if len(report.Results) == 1 {
+		checks = "check"
+	}
+	line := fmt.Sprintf("%d %s: %s.", len(report.Results), checks, strings.Join(counts, ", "))
+	if report.Cancelled {
+		line += " The run was cancelled, so this is only part of the report."
+	}
+	return line
+}
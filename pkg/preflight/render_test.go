@@ -0,0 +1,179 @@
+package preflight
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ansiSequence matches the escape sequences the renderer colors with.
+var ansiSequence = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func renderReport() Report {
+	return Report{Results: []Result{
+		{Name: "CPU", Facts: map[string]any{"onlineCPUs": 16, "presentCPUs": 16},
+			Thresholds: map[string]any{"minCPUTest": 8, "minCPUProd": 16}, Duration: 1234 * time.Microsecond},
+		{Name: "NetworkSpeed", Severity: SeverityWarning, Message: "Link speed of eth0 is 1Gbps.",
+			Facts: map[string]any{"speedMbps": 1000}},
+		{Name: "Join", Severity: SeverityFatal, Message: "The cluster cannot be reached.", Overridden: true},
+		{Name: "TPM", Error: "unable to run tpm2_getcap: not found", ErrorKind: ErrorKindToolMissing},
+		{Name: "FirmwareVersion", Message: "Skipped: SMBIOS not available on this platform."},
+		{Name: "Chassis", Severity: SeverityInfo, Message: "Blade.", AirGapped: true},
+	}}
+}
+
+func render(t *testing.T, renderer Renderer, report Report) string {
+	t.Helper()
+	var out bytes.Buffer
+	assert.Nil(t, renderer.Render(&out, report))
+	return out.String()
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		mode RenderMode
+		out  string
+	}{
+		{RenderDefault, "" +
+			"PASS  CPU\n" +
+			"WARN  NetworkSpeed     Link speed of eth0 is 1Gbps.\n" +
+			"FAIL  Join             The cluster cannot be reached. (overridden)\n" +
+			"ERR   TPM              The check could not be run.\n" +
+			"SKIP  FirmwareVersion  Skipped.\n" +
+			"INFO  Chassis          Blade. (air-gapped mode)\n" +
+			"6 checks: 2 passed, 1 warned, 1 failed, 1 could not run, 1 skipped.\n"},
+		{RenderQuiet, "" +
+			"WARN  NetworkSpeed     Link speed of eth0 is 1Gbps.\n" +
+			"FAIL  Join             The cluster cannot be reached. (overridden)\n" +
+			"ERR   TPM              The check could not be run.\n" +
+			"6 checks: 2 passed, 1 warned, 1 failed, 1 could not run, 1 skipped.\n"},
+		{RenderVerbose, "" +
+			"PASS  CPU\n" +
+			"                       measured: onlineCPUs=16 presentCPUs=16\n" +
+			"                       thresholds: minCPUProd=16 minCPUTest=8\n" +
+			"                       took: 1.2ms\n" +
+			"WARN  NetworkSpeed     Link speed of eth0 is 1Gbps.\n" +
+			"                       measured: speedMbps=1000\n" +
+			"FAIL  Join             The cluster cannot be reached. (overridden)\n" +
+			"ERR   TPM              The check could not be run.\n" +
+			"                       error: unable to run tpm2_getcap: not found (tool-missing)\n" +
+			"SKIP  FirmwareVersion  Skipped.\n" +
+			"                       skipped: SMBIOS not available on this platform\n" +
+			"INFO  Chassis          Blade. (air-gapped mode)\n" +
+			"6 checks: 2 passed, 1 warned, 1 failed, 1 could not run, 1 skipped.\n"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, render(t, Renderer{Mode: tt.mode}, renderReport()), "mode %d", tt.mode)
+
+		// Color doesn't change anything else, alignment included
+		colored := render(t, Renderer{Mode: tt.mode, Color: true}, renderReport())
+		assert.NotEqual(t, tt.out, colored)
+		assert.Equal(t, tt.out, ansiSequence.ReplaceAllString(colored, ""), "mode %d", tt.mode)
+	}
+}
+
+func TestRenderRemediation(t *testing.T) {
+	report := Report{Results: []Result{
+		{Name: "MemorySysctl", Remediated: &Remediated{
+			Actions: []RemediationTaken{
+				{RemediationAction: RemediationAction{Kind: RemediationSysctlSet, Target: "vm.swappiness", Value: "60"}},
+				{RemediationAction: RemediationAction{Kind: "disk-wipe", Target: "/dev/sda"}, Error: "disk-wipe actions are not taken automatically"},
+			},
+			Severity: SeverityWarning,
+			Message:  "vm.swappiness is 100.",
+		}},
+		{Name: "ModuleSet", Severity: SeverityFatal, Message: "Required kernel modules are missing: iscsi_tcp.",
+			Remediation: &Remediation{Hint: "Load them with: /usr/sbin/modprobe iscsi_tcp.",
+				Actions: []RemediationAction{{Kind: RemediationModuleLoad, Target: "iscsi_tcp"}}},
+			Remediated: &Remediated{
+				Actions:  []RemediationTaken{{RemediationAction: RemediationAction{Kind: RemediationModuleLoad, Target: "iscsi_tcp"}, Error: "exit status 1"}},
+				Severity: SeverityFatal,
+				Message:  "Required kernel modules are missing: iscsi_tcp.",
+			}},
+	}}
+	assert.Equal(t, ""+
+		"PASS  MemorySysctl  (remediated)\n"+
+		"FAIL  ModuleSet     Required kernel modules are missing: iscsi_tcp.\n"+
+		"2 checks: 1 passed, 0 warned, 1 failed.\n",
+		render(t, Renderer{}, report))
+	assert.Equal(t, ""+
+		"PASS  MemorySysctl  (remediated)\n"+
+		"                    remediated: /usr/sbin/sysctl -w vm.swappiness=60\n"+
+		"                    not remediated: disk-wipe /dev/sda: disk-wipe actions are not taken automatically\n"+
+		"                    before remediation: WARN vm.swappiness is 100.\n"+
+		"FAIL  ModuleSet     Required kernel modules are missing: iscsi_tcp.\n"+
+		"                    fix: Load them with: /usr/sbin/modprobe iscsi_tcp.\n"+
+		"                    not remediated: /usr/sbin/modprobe iscsi_tcp: exit status 1\n"+
+		"2 checks: 1 passed, 0 warned, 1 failed.\n",
+		render(t, Renderer{Mode: RenderVerbose}, report))
+}
+
+func TestRenderAlignment(t *testing.T) {
+	report := Report{Results: []Result{
+		{Name: "A", Message: "a"},
+		{Name: "ConfigHardware", Severity: SeverityWarning, Message: "b"},
+		{Name: "AVeryLongCheckNameIndeed", Severity: SeverityFatal, Message: "c"},
+	}}
+	out := render(t, Renderer{Color: true}, report)
+	lines := strings.Split(strings.TrimSuffix(ansiSequence.ReplaceAllString(out, ""), "\n"), "\n")
+	assert.Len(t, lines, 4)
+	column := strings.LastIndex(lines[2], "c")
+	for i, msg := range []string{"a", "b", "c"} {
+		assert.Equal(t, column, strings.LastIndex(lines[i], msg), lines[i])
+	}
+	assert.Equal(t, "\x1b[32mPASS\x1b[0m  A                         a\n", strings.SplitAfter(out, "\n")[0])
+	assert.Equal(t, "3 checks: 1 passed, 1 warned, 1 failed.", lines[3])
+
+	assert.Equal(t, "0 checks: 0 passed, 0 warned, 0 failed.\n", render(t, Renderer{}, Report{}))
+	assert.Equal(t, "PASS  A  a\n1 check: 1 passed, 0 warned, 0 failed.\n", render(t, Renderer{}, Report{Results: report.Results[:1]}))
+}
+
+func TestNewRenderer(t *testing.T) {
+	terminal := false
+	isTerminal := func(*os.File) bool { return terminal }
+
+	// Only terminals get color
+	t.Setenv("NO_COLOR", "")
+	assert.Equal(t, Renderer{Mode: RenderVerbose}, newRenderer(os.Stdout, RenderVerbose, isTerminal))
+	assert.Equal(t, Renderer{}, newRenderer(&bytes.Buffer{}, RenderDefault, isTerminal))
+	terminal = true
+	assert.Equal(t, Renderer{Mode: RenderQuiet, Color: true}, newRenderer(os.Stdout, RenderQuiet, isTerminal))
+	assert.Equal(t, Renderer{}, newRenderer(&bytes.Buffer{}, RenderDefault, isTerminal))
+
+	// Unless NO_COLOR says otherwise
+	t.Setenv("NO_COLOR", "1")
+	assert.Equal(t, Renderer{Mode: RenderQuiet}, newRenderer(os.Stdout, RenderQuiet, isTerminal))
+
+	// A file isn't a terminal
+	f, err := os.Create(t.TempDir() + "/out")
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.False(t, NewRenderer(f, RenderDefault).Color)
+}
+
+func TestParseRenderMode(t *testing.T) {
+	tests := []struct {
+		quiet, verbose bool
+		mode           RenderMode
+		err            string
+	}{
+		{false, false, RenderDefault, ""},
+		{true, false, RenderQuiet, ""},
+		{false, true, RenderVerbose, ""},
+		{true, true, RenderDefault, "--quiet and --verbose can't be used together"},
+	}
+	for _, tt := range tests {
+		mode, err := ParseRenderMode(tt.quiet, tt.verbose)
+		assert.Equal(t, tt.mode, mode)
+		if tt.err == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
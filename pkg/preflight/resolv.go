@@ -0,0 +1,106 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	resolvConf = "etc/resolv.conf"
+	// resolvedUpstreamConf is where systemd-resolved lists the upstream
+	// servers its stub at 127.0.0.53 forwards to
+	resolvedUpstreamConf = "run/systemd/resolve/resolv.conf"
+)
+
+// readResolvConf returns the nameservers and search domains in a
+// resolv.conf(5) file.  A missing file has neither.
+func readResolvConf(env *Env, path string) (nameservers, search []string, err error) {
+	out, err := env.readFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	} else if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			nameservers = append(nameservers, fields[1])
+		case "search", "domain":
+			// The last of these wins
+			search = fields[1:]
+		}
+	}
+	return
+}
+
+func isLoopback(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ResolvConfCheck reports the nameservers and search domains the live
+// environment's resolver uses.  If /etc/resolv.conf only points at a
+// local stub (as with systemd-resolved), the upstream servers behind it
+// are reported instead.  No nameservers, or a stub with no upstreams, is
+// warned about, because the network checks which need DNS will all fail
+// in confusing ways.  The effective nameservers are recorded in the
+// inventory.
+type ResolvConfCheck struct{}
+
+func (c ResolvConfCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c ResolvConfCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ResolvConf"
+	nameservers, search, err := readResolvConf(env, filepath.Join(env.host().root, resolvConf))
+	if err != nil {
+		return
+	}
+	if len(nameservers) == 0 {
+		result.Severity = SeverityWarning
+		result.Message = "No nameservers are configured in /etc/resolv.conf, so DNS lookups will fail."
+		return
+	}
+
+	var stubs, upstreams []string
+	for _, nameserver := range nameservers {
+		if isLoopback(nameserver) {
+			stubs = append(stubs, nameserver)
+		} else {
+			upstreams = append(upstreams, nameserver)
+		}
+	}
+	if len(upstreams) == 0 {
+		var resolvedSearch []string
+		if upstreams, resolvedSearch, err = readResolvConf(env, filepath.Join(env.host().root, resolvedUpstreamConf)); err != nil {
+			return
+		}
+		if len(search) == 0 {
+			search = resolvedSearch
+		}
+		if len(upstreams) == 0 {
+			result.Severity = SeverityWarning
+			result.Message = fmt.Sprintf("Only the local stub resolver %s is configured, and it has no upstream nameservers, "+
+				"so DNS lookups will fail.", strings.Join(stubs, ", "))
+			return
+		}
+	}
+	env.Inventory.Nameservers = upstreams
+	env.Inventory.SearchDomains = search
+
+	result.Message = fmt.Sprintf("Nameservers: %s.", strings.Join(upstreams, ", "))
+	if len(search) > 0 {
+		result.Message += fmt.Sprintf(" Search domains: %s.", strings.Join(search, ", "))
+	}
+	return
+}
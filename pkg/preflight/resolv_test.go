@@ -0,0 +1,58 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvConfCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture     string
+		severity    Severity
+		message     string
+		nameservers []string
+		search      []string
+	}{
+		{
+			fixture:     "plain",
+			message:     "Nameservers: 10.20.30.2, 10.20.30.3. Search domains: lab.example.com, example.com.",
+			nameservers: []string{"10.20.30.2", "10.20.30.3"},
+			search:      []string{"lab.example.com", "example.com"},
+		},
+		{
+			fixture:     "resolved-stub",
+			message:     "Nameservers: 192.168.1.1, 2001:db8::53. Search domains: example.com.",
+			nameservers: []string{"192.168.1.1", "2001:db8::53"},
+			search:      []string{"example.com"},
+		},
+		{
+			fixture:  "resolved-no-upstreams",
+			severity: SeverityWarning,
+			message:  "Only the local stub resolver 127.0.0.53 is configured, and it has no upstream nameservers, so DNS lookups will fail.",
+		},
+		{
+			fixture:  "empty",
+			severity: SeverityWarning,
+			message:  "No nameservers are configured in /etc/resolv.conf, so DNS lookups will fail.",
+		},
+		{
+			fixture:  "missing",
+			severity: SeverityWarning,
+			message:  "No nameservers are configured in /etc/resolv.conf, so DNS lookups will fail.",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/resolv-conf/" + test.fixture
+		env := &Env{machine: h}
+		result, err := ResolvConfCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "ResolvConf", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Equal(t, test.nameservers, env.Inventory.Nameservers, test.fixture)
+		assert.Equal(t, test.search, env.Inventory.SearchDomains, test.fixture)
+	}
+}
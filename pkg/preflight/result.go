@@ -0,0 +1,110 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Severity classifies the outcome of a check, so that callers can tell
+// the difference between something that should block installation and
+// something that just deserves a mention.
+type Severity int
+
+const (
+	// SeverityOK means the check passed.
+	SeverityOK Severity = iota
+	// SeverityInfo means the check passed, but found something the
+	// user may want to know about.
+	SeverityInfo
+	// SeverityWarning means the system will work, but not as well as
+	// it should, or not in a way that's supported for production use.
+	SeverityWarning
+	// SeverityFatal means installation should not proceed.
+	SeverityFatal
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityOK:
+		return "pass"
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warn"
+	case SeverityFatal:
+		return "fail"
+	}
+	return "unknown"
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	for candidate := SeverityOK; candidate <= SeverityFatal; candidate++ {
+		if candidate.String() == string(text) {
+			*s = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity %q", text)
+}
+
+// A Result is the outcome of a ResultCheck.  Message may be empty when
+// Severity is SeverityOK.  Error is only set by the Runner, when the
+// check failed to run, with ErrorKind saying why, if it's known.
+// Overridden is set on a fatal Result when the user has chosen to
+// proceed regardless.  AirGapped is set when the check behaved
+// differently because the host is air-gapped, e.g. it didn't probe
+// endpoints on the internet.  Device is the device the check is
+// of, if it's of one, e.g. the NIC.  Facts are what the check measured,
+// by name, for programs reading the report rather than people, and
+// Thresholds what it compared them against.  Measurement is the one
+// figure the hardware requirement checks compared against their
+// minima.  Duration is how long the check took, as measured by the
+// Runner.  Remediation says how to fix what the check found, if it
+// knows, and Remediated what the Runner did about it, if it was asked
+// to.  Evidence is only recorded if the Runner was asked to capture it,
+// and is left out of the text and compact forms of the report.
+type Result struct {
+	Name        string         `json:"name"`
+	Severity    Severity       `json:"severity"`
+	Message     string         `json:"message,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	ErrorKind   ErrorKind      `json:"errorKind,omitempty"`
+	Overridden  bool           `json:"overridden,omitempty"`
+	AirGapped   bool           `json:"airGapped,omitempty"`
+	Device      string         `json:"device,omitempty"`
+	Facts       map[string]any `json:"facts,omitempty"`
+	Thresholds  map[string]any `json:"thresholds,omitempty"`
+	Measurement *Measurement   `json:"measurement,omitempty"`
+	Duration    time.Duration  `json:"duration,omitempty"`
+	Remediation *Remediation   `json:"remediation,omitempty"`
+	Remediated  *Remediated    `json:"remediated,omitempty"`
+	Evidence    []Evidence     `json:"evidence,omitempty"`
+}
+
+// A Measurement is what a hardware requirement check measured, and the
+// minima for testing and production it was compared against, so that
+// automation needn't parse the message.  Quantity is what was measured,
+// e.g. "Memory", and Value is in Unit, e.g. "GiB".  Tolerance, if set, is
+// the fraction of the minima which is enough, for measurements known to
+// read a bit low.
+type Measurement struct {
+	Quantity  string  `json:"quantity"`
+	Value     float64 `json:"value"`
+	Unit      string  `json:"unit"`
+	MinTest   int     `json:"minTest"`
+	MinProd   int     `json:"minProd"`
+	Tolerance float64 `json:"tolerance,omitempty"`
+}
+
+// A ResultCheck is like a Check, except that its outcome is classified
+// by Severity rather than just being a message, and it has access to the
+// Env shared by all checks in the run.  As with Check, the error value
+// will only be set if the check itself failed to run.
+type ResultCheck interface {
+	Evaluate(ctx context.Context, env *Env) (Result, error)
+}
@@ -0,0 +1,117 @@
+package preflight
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultRetryAttempts and DefaultRetryDelay are how often, and after
+	// how long at first, the checks DefaultChecks retries are evaluated,
+	// which gives a NIC which has just come up a few seconds to negotiate
+	// its link.
+	DefaultRetryAttempts = 3
+	DefaultRetryDelay    = time.Second
+	// defaultRetryBackoff is what WithRetry multiplies the delay by
+	// after each retry.
+	defaultRetryBackoff = 2
+)
+
+// A transientChecker is a check some of whose Results may only say how
+// the host was for a moment, e.g. a NIC's speed while it's negotiating
+// its link, so that it's worth evaluating again.
+type transientChecker interface {
+	transient(result Result) bool
+}
+
+// A RetryCheck evaluates Check again, up to Attempts times in all, when
+// it fails to run, or its Result is one it says may be transient, so
+// that a host which has only just booted isn't failed for what sorts
+// itself out by the time the user reruns preflight.  A Result which
+// legitimately fails is final, as are errors which another attempt won't
+// change: a tool which isn't installed, a permission which isn't
+// granted, or a platform which isn't supported.  The wait before the
+// first retry is Delay, and each after that Backoff times the last.
+// Whatever the last attempt found is returned, under Check's own name.
+// BenchmarkChecks aren't retried, as they'd overrun their allotment.
+type RetryCheck struct {
+	Check    ResultCheck
+	Attempts int
+	Delay    time.Duration
+	// Backoff is 1, i.e. the delay doesn't grow, if zero.
+	Backoff float64
+}
+
+// WithRetry returns a RetryCheck which evaluates check up to attempts
+// times, waiting delay before the first retry, and twice as long before
+// each after that.
+func WithRetry(check ResultCheck, attempts int, delay time.Duration) RetryCheck {
+	return RetryCheck{Check: check, Attempts: attempts, Delay: delay, Backoff: defaultRetryBackoff}
+}
+
+// Unwrap returns the check which is retried, which is what the RetryCheck
+// is reported as.
+func (c RetryCheck) Unwrap() ResultCheck {
+	return c.Check
+}
+
+func (c RetryCheck) Modes() []RunMode {
+	if modal, ok := c.Check.(ModalCheck); ok {
+		return modal.Modes()
+	}
+	return []RunMode{RunModeInstall}
+}
+
+func (c RetryCheck) readsFrom() []string {
+	if reader, ok := c.Check.(inventoryReader); ok {
+		return reader.readsFrom()
+	}
+	return nil
+}
+
+func (c RetryCheck) probes(env *Env) []toolCall {
+	if prober, ok := c.Check.(inventoryProber); ok {
+		return prober.probes(env)
+	}
+	return nil
+}
+
+func (c RetryCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	// What the tools said may be what's being retried, so retries run
+	// them again, without disturbing what the other checks see
+	tools := env.tools
+	delay := c.Delay
+	for attempt := 1; ; attempt++ {
+		result, err = c.Check.Evaluate(ctx, env)
+		if attempt >= c.Attempts || ctx.Err() != nil || !c.retryable(result, err) {
+			break
+		}
+		why := result.Message
+		if err != nil {
+			why = err.Error()
+		}
+		logrus.Debugf("preflight: %s: attempt %d of %d: %s; retrying in %s", resultName(c), attempt, c.Attempts, why, delay)
+		if env.host().sleep(ctx, delay) != nil {
+			break
+		}
+		delay = time.Duration(float64(delay) * max(c.Backoff, 1))
+		env.tools = nil
+	}
+	if env.tools != tools && tools != nil {
+		env.tools = tools
+	}
+	return
+}
+
+// retryable returns whether evaluating the check again might find
+// otherwise than result and err.
+func (c RetryCheck) retryable(result Result, err error) bool {
+	if err != nil {
+		return !slices.Contains([]error{ErrToolMissing, ErrPermission, ErrUnsupportedPlatform}, errorKindOf(err))
+	}
+	checker, ok := c.Check.(transientChecker)
+	return ok && checker.transient(result)
+}
@@ -0,0 +1,218 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyCheck fails to run, or gives a transient result, until it's been
+// evaluated enough times, and then gives result
+type flakyCheck struct {
+	failures int
+	err      error
+	// flickers is whether its failures are transient results
+	flickers  bool
+	result    Result
+	evaluated *int
+}
+
+func (c flakyCheck) Evaluate(_ context.Context, _ *Env) (Result, error) {
+	*c.evaluated++
+	if *c.evaluated > c.failures {
+		return c.result, nil
+	}
+	if c.err != nil {
+		return Result{Name: "Flaky"}, c.err
+	}
+	return Result{Name: "Flaky", Severity: SeverityWarning, Message: "Not yet."}, nil
+}
+
+func (c flakyCheck) transient(result Result) bool {
+	return c.flickers && result.Message == "Not yet."
+}
+
+func TestRetryCheck(t *testing.T) {
+	h := testHost()
+	defer func() {
+		logrus.SetOutput(os.Stderr)
+		logrus.SetLevel(logrus.InfoLevel)
+	}()
+	var slept []time.Duration
+	h.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	var logs bytes.Buffer
+	logrus.SetOutput(&logs)
+	logrus.SetLevel(logrus.DebugLevel)
+
+	flaky := errors.New("systemd-detect-virt: exit status 1")
+	passed := Result{Name: "Flaky", Message: "Done."}
+	failed := Result{Name: "Flaky", Severity: SeverityFatal, Message: "Not good enough."}
+	tests := []struct {
+		name      string
+		check     flakyCheck
+		result    Result
+		err       error
+		evaluated int
+		slept     []time.Duration
+	}{
+		{
+			name:      "succeeds after errors",
+			check:     flakyCheck{failures: 2, err: flaky, result: passed},
+			result:    passed,
+			evaluated: 3,
+			slept:     []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "errors every time",
+			check:     flakyCheck{failures: 5, err: flaky, result: passed},
+			result:    Result{Name: "Flaky"},
+			err:       flaky,
+			evaluated: 3,
+			slept:     []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "transient result",
+			check:     flakyCheck{failures: 1, flickers: true, result: passed},
+			result:    passed,
+			evaluated: 2,
+			slept:     []time.Duration{time.Second},
+		},
+		{
+			// Results which aren't said to be transient are final
+			name:      "warning",
+			check:     flakyCheck{failures: 1, result: passed},
+			result:    Result{Name: "Flaky", Severity: SeverityWarning, Message: "Not yet."},
+			evaluated: 1,
+		},
+		{
+			name:      "legitimate failure",
+			check:     flakyCheck{result: failed},
+			result:    failed,
+			evaluated: 1,
+		},
+		{
+			name:      "tool missing",
+			check:     flakyCheck{failures: 1, err: toolError("/usr/bin/systemd-detect-virt", exec.ErrNotFound), result: passed},
+			result:    Result{Name: "Flaky"},
+			err:       ErrToolMissing,
+			evaluated: 1,
+		},
+		{
+			name:      "permission denied",
+			check:     flakyCheck{failures: 1, err: os.ErrPermission, result: passed},
+			result:    Result{Name: "Flaky"},
+			err:       ErrPermission,
+			evaluated: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slept = nil
+			evaluated := 0
+			tt.check.evaluated = &evaluated
+			result, err := WithRetry(tt.check, 3, time.Second).Evaluate(context.Background(), &Env{machine: h})
+			assert.Equal(t, tt.result, result)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.evaluated, evaluated)
+			assert.Equal(t, tt.slept, slept)
+		})
+	}
+	assert.Contains(t, logs.String(), "preflight: flaky: attempt 1 of 3: systemd-detect-virt: exit status 1; retrying in 1s")
+	assert.Contains(t, logs.String(), "preflight: flaky: attempt 2 of 3: systemd-detect-virt: exit status 1; retrying in 2s")
+
+	// Without backoff, the delay stays the same
+	slept = nil
+	evaluated := 0
+	check := RetryCheck{Check: flakyCheck{failures: 2, err: flaky, evaluated: &evaluated}, Attempts: 3, Delay: time.Second}
+	_, err := check.Evaluate(context.Background(), &Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{time.Second, time.Second}, slept)
+
+	// Nor is there any waiting once the context is done
+	h.sleep = sleepContext
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	evaluated = 0
+	_, err = WithRetry(flakyCheck{failures: 2, err: flaky, evaluated: &evaluated}, 3, time.Hour).Evaluate(ctx, &Env{machine: h})
+	assert.Equal(t, flaky, err)
+	assert.Equal(t, 1, evaluated)
+}
+
+// A NIC which doesn't know its speed yet may just be negotiating its link.
+func TestRetryCheckNetworkSpeed(t *testing.T) {
+	h := testHost()
+	h.root = t.TempDir()
+	speed := filepath.Join(h.root, "sys/class/net/eth0/speed")
+	writeFile(t, h.root, "sys/class/net/eth0/speed", "-1\n")
+	h.sleep = func(context.Context, time.Duration) error {
+		return os.WriteFile(speed, []byte("25000\n"), 0o644)
+	}
+
+	result, err := WithRetry(NetworkSpeedCheck{"eth0"}, 3, time.Second).Evaluate(context.Background(), &Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityOK, result.Severity)
+	assert.Equal(t, map[string]any{"speedMbps": 25000}, result.Facts)
+}
+
+// The tools are run again on each retry, as what they said may be what
+// failed, but the other checks still see what they said the first time.
+func TestRetryCheckTools(t *testing.T) {
+	h := testHost()
+	h.sleep = func(context.Context, time.Duration) error { return nil }
+
+	runs := 0
+	env := &Env{execCommand: func(string, ...string) *exec.Cmd {
+		runs++
+		if runs == 1 {
+			return fakeExecCommand("dmidecode-fail")
+		}
+		return fakeExecCommand("nproc 16")
+	}, machine: h}
+	// As the inventory pass leaves it
+	env.cache()
+	result, err := WithRetry(fakeToolCheck{}, 3, time.Second).Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, "16", result.Message)
+	assert.Equal(t, 2, runs)
+
+	_, err = env.output("/usr/bin/nproc", "--all")
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, runs)
+}
+
+// fakeToolCheck reports what nproc says
+type fakeToolCheck struct{}
+
+func (c fakeToolCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	out, err := env.output("/usr/bin/nproc", "--all")
+	result.Message = string(bytes.TrimSpace(out))
+	return
+}
+
+// A RetryCheck is reported as the check it retries, and runs when, and
+// after what, that would.
+func TestRetryCheckIdentity(t *testing.T) {
+	assert.Equal(t, "CPU", resultName(WithRetry(CPUCheck{}, 3, time.Second)))
+	assert.Equal(t, "NetworkSpeed", resultName(WithRetry(&NetworkSpeedCheck{"eth0"}, 3, time.Second)))
+
+	assert.True(t, runsIn(WithRetry(SwapCheck{}, 3, time.Second), RunModeUpgrade))
+	assert.False(t, runsIn(WithRetry(CPUCheck{}, 3, time.Second), RunModeUpgrade))
+	assert.Equal(t, []string{"Cmdline"}, WithRetry(CPUCheck{}, 3, time.Second).readsFrom())
+	assert.Equal(t, MemoryCheck{}.probes(&Env{}), WithRetry(MemoryCheck{}, 3, time.Second).probes(&Env{}))
+	assert.Nil(t, WithRetry(KVMHostCheck{}, 3, time.Second).probes(&Env{}))
+
+	report := NewRunner(WithRetry(fakeCheck{err: errors.New("broken")}, 1, 0)).Run(context.Background())
+	assert.Equal(t, "fake", report.Results[0].Name)
+	assert.Equal(t, "broken", report.Results[0].Error)
+}
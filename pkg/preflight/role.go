@@ -0,0 +1,209 @@
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// A Role is the part a node plays in the cluster, which determines how
+// much hardware it needs.
+type Role string
+
+const (
+	// RoleManagement nodes run the control plane, as well as workloads.
+	// Nodes with the default role may be promoted to management, so they
+	// need the same.
+	RoleManagement Role = "management"
+	// RoleWorker nodes only run workloads.
+	RoleWorker Role = "worker"
+	// RoleWitness nodes only run etcd, to keep quorum, so they can be
+	// much smaller.
+	RoleWitness Role = "witness"
+)
+
+// ParseRole validates a role given by the user.  An empty role, or the
+// install configuration's "default" role, is RoleManagement.
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case "", config.RoleDefault:
+		return RoleManagement, nil
+	case RoleManagement, RoleWorker, RoleWitness:
+		return role, nil
+	}
+	return RoleManagement, fmt.Errorf("unknown role %q, expected %q, %q or %q", s, RoleManagement, RoleWorker, RoleWitness)
+}
+
+// Thresholds are the minimum hardware for a node.  The Test minima are
+// for testing, and the Prod ones for production use.
+type Thresholds struct {
+	MinCPUTest         int `yaml:"minCPUTest,omitempty"`
+	MinCPUProd         int `yaml:"minCPUProd,omitempty"`
+	MinMemoryGiBTest   int `yaml:"minMemoryGiBTest,omitempty"`
+	MinMemoryGiBProd   int `yaml:"minMemoryGiBProd,omitempty"`
+	MinNetworkGbpsTest int `yaml:"minNetworkGbpsTest,omitempty"`
+	MinNetworkGbpsProd int `yaml:"minNetworkGbpsProd,omitempty"`
+	// MinDiskGiB is the minimum size of the installation disk when it
+	// holds the data as well, and MinOSDiskGiB when there's a separate
+	// data disk, which must be at least MinDataDiskGiB.
+	MinDiskGiB     uint64 `yaml:"minDiskGiB,omitempty"`
+	MinOSDiskGiB   uint64 `yaml:"minOSDiskGiB,omitempty"`
+	MinDataDiskGiB uint64 `yaml:"minDataDiskGiB,omitempty"`
+}
+
+// DefaultThresholds returns the Thresholds of management and worker
+// nodes, which are the Min constants.
+func DefaultThresholds() Thresholds {
+	return defaultThresholds
+}
+
+// Validate returns an error if any of the minima is negative, or any for
+// production is below that for testing.
+func (t Thresholds) Validate() error {
+	for _, minima := range []struct {
+		name       string
+		test, prod int
+	}{
+		{"CPU cores", t.MinCPUTest, t.MinCPUProd},
+		{"memory", t.MinMemoryGiBTest, t.MinMemoryGiBProd},
+		{"network speed", t.MinNetworkGbpsTest, t.MinNetworkGbpsProd},
+	} {
+		switch {
+		case minima.test < 0 || minima.prod < 0:
+			return fmt.Errorf("the minimum %s is negative", minima.name)
+		case minima.prod < minima.test:
+			return fmt.Errorf("the minimum %s for production (%d) is below that for testing (%d)", minima.name, minima.prod, minima.test)
+		}
+	}
+	return nil
+}
+
+// DefaultThresholdsPath is where an installer image can ship Thresholds
+// which override those of the roles, where they're set, e.g. for a
+// variant of SaftOS which supports smaller hardware, without the
+// installer being rebuilt.
+const DefaultThresholdsPath = "/etc/saftos/preflight-thresholds.yaml"
+
+// ThresholdsFromFile reads Thresholds from the YAML file at path, with
+// the fields named as in a Result's Thresholds, e.g. "minCPUTest".  Only
+// those set override the role's, and the minima they make for every role
+// have to be valid.
+func ThresholdsFromFile(path string) (Thresholds, error) {
+	var t Thresholds
+	f, err := os.Open(path)
+	if err != nil {
+		return t, err
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&t); err != nil && !errors.Is(err, io.EOF) {
+		return Thresholds{}, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, role := range []Role{RoleManagement, RoleWorker, RoleWitness} {
+		if err := (Options{Role: role, Thresholds: t}).thresholds(liveHost.goarch).Validate(); err != nil {
+			return Thresholds{}, fmt.Errorf("%s: for %s nodes, %w", path, role, err)
+		}
+	}
+	return t, nil
+}
+
+// imageThresholds returns the Thresholds shipped in the installer image at
+// path, if there are any.  Invalid ones are ignored, as if there weren't any,
+// rather than stopping the checks from running.
+func imageThresholds(path string) Thresholds {
+	t, err := ThresholdsFromFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logrus.Errorf("Ignoring the preflight thresholds in the installer image: %v", err)
+	}
+	return t
+}
+
+// RoleThresholds are the default Thresholds for each role.  Those for
+// management and worker nodes are from Hardware Requirements in the
+// documentation, and witness nodes need far less.
+var RoleThresholds = map[Role]Thresholds{
+	RoleManagement: defaultThresholds,
+	RoleWorker:     defaultThresholds,
+	RoleWitness: {
+		MinCPUTest:         2,
+		MinCPUProd:         4,
+		MinMemoryGiBTest:   8,
+		MinMemoryGiBProd:   16,
+		MinNetworkGbpsTest: 1,
+		MinNetworkGbpsProd: 1,
+		MinDiskGiB:         config.HardMinDataDiskSizeGiB,
+		MinOSDiskGiB:       config.HardMinDataDiskSizeGiB,
+		MinDataDiskGiB:     config.HardMinDataDiskSizeGiB,
+	},
+}
+
+var defaultThresholds = Thresholds{
+	MinCPUTest:         MinCPUTest,
+	MinCPUProd:         MinCPUProd,
+	MinMemoryGiBTest:   MinMemoryTest,
+	MinMemoryGiBProd:   MinMemoryProd,
+	MinNetworkGbpsTest: MinNetworkGbpsTest,
+	MinNetworkGbpsProd: MinNetworkGbpsProd,
+	MinDiskGiB:         config.SingleDiskMinSizeGiB,
+	MinOSDiskGiB:       config.MultipleDiskMinSizeGiB,
+	MinDataDiskGiB:     config.HardMinDataDiskSizeGiB,
+}
+
+// ArchThresholds override the RoleThresholds, where they're set, on
+// hosts of the given architecture, as named by GOARCH.  arm64 servers
+// have many more cores than x86 ones, each doing less, so more of them
+// are needed.
+var ArchThresholds = map[string]map[Role]Thresholds{
+	"arm64": {
+		RoleManagement: {MinCPUTest: 16, MinCPUProd: 32},
+		RoleWorker:     {MinCPUTest: 16, MinCPUProd: 32},
+	},
+}
+
+// thresholds returns the Thresholds for the role in the Options, and the
+// host architecture arch, with the explicitly set ones in
+// Options.Thresholds taking precedence.
+func (o Options) thresholds(arch string) Thresholds {
+	role, _ := ParseRole(string(o.Role))
+	return RoleThresholds[role].overriddenBy(ArchThresholds[arch][role]).overriddenBy(o.Thresholds)
+}
+
+// overriddenBy returns t with the minima set in explicit in their place.
+func (t Thresholds) overriddenBy(explicit Thresholds) Thresholds {
+	override := func(value *int, explicit int) {
+		if explicit != 0 {
+			*value = explicit
+		}
+	}
+	override(&t.MinCPUTest, explicit.MinCPUTest)
+	override(&t.MinCPUProd, explicit.MinCPUProd)
+	override(&t.MinMemoryGiBTest, explicit.MinMemoryGiBTest)
+	override(&t.MinMemoryGiBProd, explicit.MinMemoryGiBProd)
+	override(&t.MinNetworkGbpsTest, explicit.MinNetworkGbpsTest)
+	override(&t.MinNetworkGbpsProd, explicit.MinNetworkGbpsProd)
+	for _, size := range []struct{ value, explicit *uint64 }{
+		{&t.MinDiskGiB, &explicit.MinDiskGiB},
+		{&t.MinOSDiskGiB, &explicit.MinOSDiskGiB},
+		{&t.MinDataDiskGiB, &explicit.MinDataDiskGiB},
+	} {
+		if *size.explicit != 0 {
+			*size.value = *size.explicit
+		}
+	}
+	return t
+}
+
+// roleNode describes the node the thresholds were applied for, e.g. "a
+// witness node", for messages.
+func (o Options) roleNode() string {
+	role, _ := ParseRole(string(o.Role))
+	return fmt.Sprintf("a %s node", role)
+}
@@ -0,0 +1,186 @@
+package preflight
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestParseRole(t *testing.T) {
+	for s, expected := range map[string]Role{
+		"":           RoleManagement,
+		"default":    RoleManagement,
+		"management": RoleManagement,
+		"worker":     RoleWorker,
+		"witness":    RoleWitness,
+	} {
+		role, err := ParseRole(s)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, role)
+	}
+	_, err := ParseRole("arbiter")
+	assert.EqualError(t, err, `unknown role "arbiter", expected "management", "worker" or "witness"`)
+}
+
+func TestOptionsThresholds(t *testing.T) {
+	assert.Equal(t, defaultThresholds, Options{}.thresholds("amd64"))
+	assert.Equal(t, RoleThresholds[RoleWitness], Options{Role: RoleWitness}.thresholds("amd64"))
+
+	expected := RoleThresholds[RoleWitness]
+	expected.MinCPUProd = 6
+	expected.MinDataDiskGiB = 100
+	options := Options{Role: RoleWitness, Thresholds: Thresholds{MinCPUProd: 6, MinDataDiskGiB: 100}}
+	assert.Equal(t, expected, options.thresholds("amd64"))
+
+	// On arm64, more cores are needed, unless explicitly set
+	expected = defaultThresholds
+	expected.MinCPUTest, expected.MinCPUProd = 16, 32
+	assert.Equal(t, expected, Options{}.thresholds("arm64"))
+	assert.Equal(t, RoleThresholds[RoleWitness], Options{Role: RoleWitness}.thresholds("arm64"))
+	expected.MinCPUTest = 12
+	assert.Equal(t, expected, Options{Thresholds: Thresholds{MinCPUTest: 12}}.thresholds("arm64"))
+}
+
+func TestThresholdsFromFile(t *testing.T) {
+	thresholds, err := ThresholdsFromFile("./testdata/thresholds/edge.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, Thresholds{MinCPUTest: 4, MinCPUProd: 4, MinMemoryGiBTest: 16, MinMemoryGiBProd: 16}, thresholds)
+
+	thresholds, err = ThresholdsFromFile("./testdata/thresholds/empty.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, Thresholds{}, thresholds)
+
+	for fixture, expected := range map[string]string{
+		"prod-below-test":         "for management nodes, the minimum memory for production (16) is below that for testing (32)",
+		"witness-prod-below-test": "for witness nodes, the minimum CPU cores for production (4) is below that for testing (8)",
+		"negative":                "for management nodes, the minimum network speed is negative",
+		"unknown-field":           "yaml: unmarshal errors:\n  line 1: field minCPUs not found in type preflight.Thresholds",
+	} {
+		path := "./testdata/thresholds/" + fixture + ".yaml"
+		thresholds, err := ThresholdsFromFile(path)
+		assert.EqualError(t, err, path+": "+expected, fixture)
+		assert.Equal(t, Thresholds{}, thresholds, fixture)
+	}
+
+	_, err = ThresholdsFromFile("./testdata/thresholds/nonexistent.yaml")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// Thresholds shipped in the installer image apply to every run, unless
+// they're invalid.
+func TestImageThresholds(t *testing.T) {
+	edge := Thresholds{MinCPUTest: 4, MinCPUProd: 4, MinMemoryGiBTest: 16, MinMemoryGiBProd: 16}
+	assert.Equal(t, edge, imageThresholds("./testdata/thresholds/edge.yaml"))
+	expected := DefaultThresholds()
+	expected.MinCPUTest, expected.MinCPUProd = 4, 4
+	expected.MinMemoryGiBTest, expected.MinMemoryGiBProd = 16, 16
+	assert.Equal(t, expected, Options{Thresholds: edge}.thresholds("amd64"))
+
+	for _, fixture := range []string{"prod-below-test", "nonexistent"} {
+		assert.Equal(t, Thresholds{}, imageThresholds("./testdata/thresholds/"+fixture+".yaml"), fixture)
+	}
+
+	// Those at DefaultThresholdsPath are what runs start with
+	image := imageThresholds(DefaultThresholdsPath)
+	assert.Equal(t, image, NewRunner().Options.Thresholds)
+	assert.Equal(t, image, OptionsFromConfig(config.NewHarvesterConfig()).Thresholds)
+}
+
+// The same small machine isn't fit to be a management node, but is fine
+// as a witness.
+func TestRoleThresholds(t *testing.T) {
+	h := testHost()
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+	h.root = "./testdata/role"
+	h.onlineCPUs = func() int { return 4 }
+
+	tests := []struct {
+		name     string
+		check    ResultCheck
+		command  string
+		severity Severity
+		message  string
+		facts    map[string]any
+		device   string
+		// thresholds applied for a management node, then a witness
+		thresholds, witness map[string]any
+		// what's measured, with the management node's thresholds
+		measurement   Measurement
+		witnessMinima [2]int
+	}{
+		{
+			name:          "CPU",
+			check:         CPUCheck{},
+			severity:      SeverityFatal,
+			message:       "Only 4 CPU cores detected. SaftOS requires at least 8 cores for testing and 16 for production use of a management node.",
+			facts:         map[string]any{"arch": liveHost.goarch, "onlineCPUs": 4, "presentCPUs": 4},
+			thresholds:    map[string]any{"minCPUTest": MinCPUTest, "minCPUProd": MinCPUProd},
+			witness:       map[string]any{"minCPUTest": 2, "minCPUProd": 4},
+			measurement:   Measurement{Quantity: "CPU", Value: 4, Unit: "cores", MinTest: MinCPUTest, MinProd: MinCPUProd},
+			witnessMinima: [2]int{2, 4},
+		},
+		{
+			name:          "Memory",
+			check:         MemoryCheck{},
+			command:       "dmidecode-32GiB",
+			severity:      SeverityWarning,
+			message:       "32GiB RAM detected. SaftOS requires at least 64GiB for production use of a management node.",
+			facts:         map[string]any{"memoryGiB": uint64(32)},
+			thresholds:    map[string]any{"minMemoryGiBTest": MinMemoryTest, "minMemoryGiBProd": MinMemoryProd},
+			witness:       map[string]any{"minMemoryGiBTest": 8, "minMemoryGiBProd": 16},
+			measurement:   Measurement{Quantity: "Memory", Value: 32, Unit: "GiB", MinTest: MinMemoryTest, MinProd: MinMemoryProd},
+			witnessMinima: [2]int{8, 16},
+		},
+		{
+			name:          "NetworkSpeed",
+			check:         NetworkSpeedCheck{"eth0"},
+			severity:      SeverityWarning,
+			message:       "Link speed of eth0 is 1Gbps. SaftOS requires at least 10Gbps for production use of a management node.",
+			facts:         map[string]any{"speedMbps": 1000},
+			device:        "eth0",
+			thresholds:    map[string]any{"minNetworkGbpsTest": MinNetworkGbpsTest, "minNetworkGbpsProd": MinNetworkGbpsProd},
+			witness:       map[string]any{"minNetworkGbpsTest": 1, "minNetworkGbpsProd": 1},
+			measurement:   Measurement{Quantity: "Network", Value: 1, Unit: "Gbps", MinTest: MinNetworkGbpsTest, MinProd: MinNetworkGbpsProd},
+			witnessMinima: [2]int{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command := fakeCommand(tt.command)
+			result, err := tt.check.Evaluate(context.Background(), &Env{execCommand: command, machine: h})
+			assert.Nil(t, err)
+			measurement := tt.measurement
+			assert.Equal(t, Result{Name: tt.name, Severity: tt.severity, Message: tt.message, Device: tt.device,
+				Facts: tt.facts, Thresholds: tt.thresholds, Measurement: &measurement}, result)
+
+			result, err = tt.check.Evaluate(context.Background(), &Env{Options: Options{Role: RoleWitness}, execCommand: command, machine: h})
+			assert.Nil(t, err)
+			witness := tt.measurement
+			witness.MinTest, witness.MinProd = tt.witnessMinima[0], tt.witnessMinima[1]
+			assert.Equal(t, Result{Name: tt.name, Device: tt.device, Facts: tt.facts, Thresholds: tt.witness, Measurement: &witness}, result)
+		})
+	}
+}
+
+// Thresholds set explicitly take precedence over those for the role.
+func TestRoleThresholdsOverridden(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/cpus/4"
+	h.onlineCPUs = func() int { return 4 }
+
+	env := &Env{Options: Options{Role: RoleWitness, Thresholds: Thresholds{MinCPUProd: 8}}, machine: h}
+	result, err := CPUCheck{}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{
+		Name:        "CPU",
+		Severity:    SeverityWarning,
+		Message:     "4 CPU cores detected. SaftOS requires at least 8 cores for production use of a witness node.",
+		Facts:       map[string]any{"arch": liveHost.goarch, "onlineCPUs": 4, "presentCPUs": 4},
+		Thresholds:  map[string]any{"minCPUTest": 2, "minCPUProd": 8},
+		Measurement: &Measurement{Quantity: "CPU", Value: 4, Unit: "cores", MinTest: 2, MinProd: 8},
+	}, result)
+}
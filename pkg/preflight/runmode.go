@@ -0,0 +1,56 @@
+package preflight
+
+import (
+	"fmt"
+	"slices"
+)
+
+// InstalledConfigPath is where an installed node keeps its install
+// configuration, which the checks are run against before an upgrade.
+const InstalledConfigPath = "/oem/harvester.config"
+
+// A RunMode is what the checks are being run ahead of.
+type RunMode string
+
+const (
+	// RunModeInstall checks a host the installer has been booted on.
+	RunModeInstall RunMode = "install"
+	// RunModeUpgrade checks an installed node before it's upgraded.
+	RunModeUpgrade RunMode = "upgrade"
+)
+
+// ParseRunMode validates a run mode given by the user.  An empty mode is
+// RunModeInstall.
+func ParseRunMode(s string) (RunMode, error) {
+	switch mode := RunMode(s); mode {
+	case "":
+		return RunModeInstall, nil
+	case RunModeInstall, RunModeUpgrade:
+		return mode, nil
+	}
+	return RunModeInstall, fmt.Errorf("unknown mode %q, expected %q or %q", s, RunModeInstall, RunModeUpgrade)
+}
+
+// A ModalCheck is a ResultCheck which declares the modes it makes sense
+// in.  Checks which aren't ModalChecks only run in RunModeInstall, since
+// most of them are about the hardware or the target disks, which an
+// installed node has already been found fit for.
+type ModalCheck interface {
+	ResultCheck
+	Modes() []RunMode
+}
+
+// anyMode is the Modes of checks which are as relevant to an installed
+// node as to a new one.
+var anyMode = []RunMode{RunModeInstall, RunModeUpgrade}
+
+// runsIn returns whether check should run in mode.
+func runsIn(check ResultCheck, mode RunMode) bool {
+	if mode == "" {
+		mode = RunModeInstall
+	}
+	if modal, ok := check.(ModalCheck); ok {
+		return slices.Contains(modal.Modes(), mode)
+	}
+	return mode == RunModeInstall
+}
@@ -0,0 +1,126 @@
+package preflight
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// modalFakeCheck is a fakeCheck which declares the modes it runs in
+type modalFakeCheck struct {
+	fakeCheck
+	modes []RunMode
+}
+
+func (c modalFakeCheck) Modes() []RunMode {
+	return c.modes
+}
+
+func TestParseRunMode(t *testing.T) {
+	for s, expected := range map[string]RunMode{
+		"":        RunModeInstall,
+		"install": RunModeInstall,
+		"upgrade": RunModeUpgrade,
+	} {
+		mode, err := ParseRunMode(s)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, mode)
+	}
+	_, err := ParseRunMode("reinstall")
+	assert.EqualError(t, err, `unknown mode "reinstall", expected "install" or "upgrade"`)
+}
+
+func TestRunsIn(t *testing.T) {
+	undeclared := fakeCheck{}
+	installOnly := modalFakeCheck{modes: []RunMode{RunModeInstall}}
+	upgradeOnly := modalFakeCheck{modes: []RunMode{RunModeUpgrade}}
+	both := modalFakeCheck{modes: anyMode}
+
+	tests := []struct {
+		check    ResultCheck
+		mode     RunMode
+		expected bool
+	}{
+		{undeclared, "", true},
+		{undeclared, RunModeInstall, true},
+		{undeclared, RunModeUpgrade, false},
+		{installOnly, RunModeInstall, true},
+		{installOnly, RunModeUpgrade, false},
+		{upgradeOnly, "", false},
+		{upgradeOnly, RunModeInstall, false},
+		{upgradeOnly, RunModeUpgrade, true},
+		{both, RunModeInstall, true},
+		{both, RunModeUpgrade, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, runsIn(tt.check, tt.mode), "%#v in %q", tt.check, tt.mode)
+	}
+}
+
+func TestRunnerMode(t *testing.T) {
+	h := testHost()
+	h.now = func() time.Time { return testRunTime }
+	var installSaw, upgradeSaw *Env
+	checks := []ResultCheck{
+		fakeCheck{result: Result{Name: "Undeclared"}},
+		modalFakeCheck{fakeCheck{result: Result{Name: "InstallOnly"}, env: &installSaw}, []RunMode{RunModeInstall}},
+		modalFakeCheck{fakeCheck{result: Result{Name: "UpgradeOnly"}, env: &upgradeSaw}, []RunMode{RunModeUpgrade}},
+		modalFakeCheck{fakeCheck{result: Result{Name: "Both"}}, anyMode},
+	}
+
+	runner := Runner{Checks: checks, machine: h}
+	report := runner.Run(context.Background())
+	assert.Equal(t, Report{
+		Mode:      RunModeInstall,
+		Timestamp: testRunTime,
+		Results:   []Result{{Name: "Undeclared"}, {Name: "InstallOnly"}, {Name: "Both"}},
+		Inventory: &Inventory{},
+	}, report)
+	assert.NotNil(t, installSaw)
+	assert.Nil(t, upgradeSaw)
+
+	installSaw = nil
+	runner = Runner{Checks: checks, Mode: RunModeUpgrade, machine: h}
+	report = runner.Run(context.Background())
+	assert.Equal(t, Report{
+		Mode:      RunModeUpgrade,
+		Timestamp: testRunTime,
+		Results:   []Result{{Name: "UpgradeOnly"}, {Name: "Both"}},
+		Inventory: &Inventory{},
+	}, report)
+	assert.Nil(t, installSaw, "install-only checks must not run in upgrade mode")
+	assert.NotNil(t, upgradeSaw)
+}
+
+// Checks of the target disks' existing data are irrelevant before an
+// upgrade, and those of the upgrade irrelevant before an install.
+func TestConfigChecksModes(t *testing.T) {
+	var install, upgrade []string
+	for _, check := range ConfigChecks(config.NewHarvesterConfig()) {
+		if runsIn(check, RunModeInstall) {
+			install = append(install, checkName(check))
+		}
+		if runsIn(check, RunModeUpgrade) {
+			upgrade = append(upgrade, checkName(check))
+		}
+	}
+	for _, name := range []string{"PreviousInstallCheck", "ResidueCheck", "PoolMembershipCheck", "ConfigDeviceCheck", "DiskSizeCheck", "DiskCheck"} {
+		assert.Contains(t, install, name)
+		assert.NotContains(t, upgrade, name)
+	}
+	for _, name := range []string{"UpgradeSpaceCheck", "PressureCheck"} {
+		assert.NotContains(t, install, name)
+		assert.Contains(t, upgrade, name)
+	}
+	assert.Contains(t, install, "ClockSanityCheck")
+	assert.Contains(t, upgrade, "ClockSanityCheck")
+}
+
+func checkName(check ResultCheck) string {
+	return reflect.TypeOf(check).Name()
+}
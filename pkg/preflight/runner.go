@@ -0,0 +1,703 @@
+package preflight
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	// DefaultReportPath is where the results of a run are persisted
+	DefaultReportPath = "/var/log/saftos-preflight.json"
+	// DefaultCheckTimeout is how long a check may take, unless
+	// Options.CheckTimeout says otherwise.  Checks take seconds on a
+	// healthy host, but a tool can hang on broken firmware, as dmidecode
+	// does on some BMCs, and mustn't hold up the install forever.
+	DefaultCheckTimeout = 2 * time.Minute
+)
+
+// Options are the caller-supplied settings for a preflight run, which
+// checks consult via the Env.
+type Options struct {
+	// DestructiveAllowed means the user has confirmed that existing data
+	// on the target disks may be destroyed.  When false, checks must be
+	// strictly read-only and treat any data they find on the target disks
+	// as fatal.  When true, such findings are merely informational, and
+	// benchmarks may write to the disks.
+	DestructiveAllowed bool
+	// SecureBootPolicy is the Secure Boot state the site requires, if any.
+	SecureBootPolicy SecureBootPolicy
+	// TPMRequired means a missing TPM 2.0 is fatal, as it is for
+	// encrypted installs, rather than a warning.
+	TPMRequired bool
+	// Production means the host is intended for production use, so
+	// checks for things which only matter there should warn rather
+	// than just inform.
+	Production bool
+	// LSMPolicy is the SELinux mode the site requires, if any.
+	LSMPolicy LSMPolicy
+	// KnownMachineIDs are machine IDs of images known to have been
+	// cloned, which this host's machine ID shouldn't match.
+	KnownMachineIDs []string
+	// FleetInventory is the path of a file listing the machine IDs of
+	// existing hosts, one per line, optionally followed by the host name.
+	FleetInventory string
+	// ClusterVersion is the version of the cluster being joined, if known.
+	// Otherwise it's fetched from the join server.
+	ClusterVersion string
+	// ClusterNodes are the members of the cluster being joined, if known.
+	// Otherwise they're listed by the join server, given ClusterAPIToken.
+	ClusterNodes []ClusterNode
+	// ClusterAPIToken is an API token for the cluster being joined, which
+	// lets checks query it.
+	ClusterAPIToken string
+	// Secrets are credentials from the install configuration, which are
+	// masked wherever they turn up in Evidence, even if they don't look
+	// like credentials.
+	Secrets []string
+	// MaxVersionSkew is how many minor versions this installer may be
+	// from the cluster being joined.
+	MaxVersionSkew int
+	// AirGapped means the host has no access to the internet, so nothing
+	// can be fetched from outside the site during or after installation.
+	// Checks don't probe endpoints on the internet, but still probe those
+	// configured on site.
+	AirGapped bool
+	// DNSProbeName is the name the configured DNS servers are asked to
+	// resolve, if not DefaultDNSProbeName.
+	DNSProbeName string
+	// WeakPasswordFatal means a weak node password should fail
+	// production hosts, rather than just be warned about.
+	WeakPasswordFatal bool
+	// MinTokenEntropy is the fewest bits of entropy the cluster token may
+	// have without being warned about, if not DefaultMinTokenEntropy.
+	MinTokenEntropy int
+	// Role is the node's role, which selects the hardware Thresholds.
+	Role Role
+	// Thresholds override those of the role, where they're set.
+	Thresholds Thresholds
+	// CAExpiryWindow is how soon before they expire additional CA
+	// certificates are warned about, if not DefaultCAExpiryWindow.
+	CAExpiryWindow time.Duration
+	// RegistryProbeManifest is a manifest, e.g. "library/busybox:1.36",
+	// which registry mirrors are asked for to prove pull access.  If
+	// empty, they're only asked for their API root.
+	RegistryProbeManifest string
+	// MaxToolOutput is the most bytes of output kept from each external
+	// tool the checks run, if not DefaultMaxToolOutput.
+	MaxToolOutput int64
+	// Debug means checks cross-check what they find with the tools they
+	// used to rely on, logging any disagreement.
+	Debug bool
+	// CaptureEvidence means each Result records the raw outputs its check
+	// consumed as Evidence, for support to see what the machine reported.
+	CaptureEvidence bool
+	// MaxEvidence is the most bytes kept of each piece of Evidence, if
+	// not DefaultMaxEvidence.
+	MaxEvidence int
+	// AutoRemediate means that when a check which didn't pass says how
+	// to fix what it found, the Runner makes the fix, if it's of a kind
+	// which is safe to make automatically, and runs the check again.
+	AutoRemediate bool
+	// Benchmarks means BenchmarkChecks are run, as they aren't by
+	// default, within BenchmarkBudget altogether, if not
+	// DefaultBenchmarkBudget.
+	Benchmarks      bool
+	BenchmarkBudget time.Duration
+	// CheckTimeout is how long each check other than the BenchmarkChecks
+	// may take, if not DefaultCheckTimeout.  The external tools it runs
+	// are killed when it's up, and the check reported as timed out.
+	CheckTimeout time.Duration
+}
+
+// checkTimeout returns the Options' CheckTimeout, or the default.
+func (o Options) checkTimeout() time.Duration {
+	if o.CheckTimeout > 0 {
+		return o.CheckTimeout
+	}
+	return DefaultCheckTimeout
+}
+
+// OptionsFromConfig returns the Options implied by the install
+// configuration, with any Thresholds shipped in the installer image.
+func OptionsFromConfig(cfg *config.HarvesterConfig) Options {
+	// An invalid role is ConfigSchemaCheck's business
+	role, _ := ParseRole(cfg.Install.Role)
+	return Options{
+		DestructiveAllowed: cfg.Install.WipeAllDisks,
+		AirGapped:          cfg.Install.AirGapped,
+		TPMRequired:        cfg.Install.RequireTPM,
+		MaxVersionSkew:     DefaultMaxVersionSkew,
+		Role:               role,
+		Thresholds:         imageThresholds(DefaultThresholdsPath),
+		CAExpiryWindow:     DefaultCAExpiryWindow,
+		MinTokenEntropy:    DefaultMinTokenEntropy,
+		MaxToolOutput:      DefaultMaxToolOutput,
+		Secrets:            configSecrets(cfg),
+	}
+}
+
+// configSecrets returns the credentials in the install configuration.
+func configSecrets(cfg *config.HarvesterConfig) []string {
+	secrets := []string{cfg.Token, cfg.OS.Password, cfg.ReportSigning.Key}
+	for _, wifi := range cfg.OS.Wifi {
+		secrets = append(secrets, wifi.Passphrase)
+	}
+	return secrets
+}
+
+// ConfigChecks returns the checks which apply to the given install
+// configuration, in the order they need to run (the device checks rely
+// on ConfigDeviceCheck having populated the inventory).  They're for
+// every RunMode; the Runner picks out those for its own.  They include
+// the hardware requirement checks DefaultChecks returns, for the
+// management interfaces named in the configuration.
+func ConfigChecks(cfg *config.HarvesterConfig) []ResultCheck {
+	var dataDisks []string
+	if cfg.Install.DataDisk != "" {
+		dataDisks = append(dataDisks, cfg.Install.DataDisk)
+	}
+	// Interfaces given only by MAC address are ConfigHardwareCheck's
+	// business
+	var nics []string
+	for _, iface := range cfg.Install.ManagementInterface.Interfaces {
+		if iface.Name != "" {
+			nics = append(nics, iface.Name)
+		}
+	}
+	checks := []ResultCheck{
+		NewConfigVersionCheck(cfg),
+		BootModeCheck{},
+		SecureBootCheck{},
+		TPMCheck{},
+		FirmwareVersionCheck{},
+		BMCCheck{},
+		ChassisCheck{},
+		PSURedundancyCheck{},
+		WatchdogCheck{},
+		ThermalCheck{},
+		GPUCheck{},
+		IOMMUCheck{},
+		NewPassthroughReadinessCheck(cfg),
+		NewPassthroughConfigCheck(cfg),
+		NewSerialConsoleCheck(cfg),
+		ResolvConfCheck{},
+		NewCACertCheck(cfg),
+		NewJoinCheck(cfg),
+		NewVersionSkewCheck(cfg),
+		NewHostnameCheck(cfg),
+		NewClusterHostnameCheck(cfg),
+		NewSSHKeyCheck(cfg),
+		NewPasswordCheck(cfg),
+		NewTokenCheck(cfg),
+		MachineIDCheck{},
+		NewClockSanityCheck(),
+		NewTimeSyncServiceCheck(cfg),
+		NewTimeSyncCheck(cfg),
+		NewConfiguredNTPCheck(cfg),
+		NewConfiguredDNSCheck(cfg),
+		NewProxyCoverageCheck(cfg),
+		NewRegistryReachabilityCheck(cfg),
+		NewRegistryMirrorCheck(cfg),
+		NewLocaleCheck(cfg),
+		SystemdCheck{},
+		DBusCheck{},
+		ConflictingServicesCheck{},
+		CmdlineCheck{},
+		CPUCheck{},
+		KdumpCheck{},
+		NewMemoryCheck(),
+		ECCMemoryCheck{},
+		WithRetry(NewVirtCheck(), DefaultRetryAttempts, DefaultRetryDelay),
+		NewKVMHostCheck(),
+		NewCPUVirtExtCheck(),
+		SwapCheck{},
+		ClocksourceCheck{},
+		KernelVersionCheck{},
+		LockdownCheck{},
+		TaintCheck{},
+		ModuleSetCheck{},
+		EntropyCheck{},
+		LimitsCheck{},
+		MemorySysctlCheck{},
+		CgroupCheck{},
+		LSMCheck{},
+		NewArtifactChecksumCheck(cfg),
+		NewConfigHardwareCheck(cfg),
+	}
+	checks = append(checks, nicChecks(nics)...)
+	return append(checks,
+		NewNetworkTopologyCheck(cfg),
+		NewBondModeCheck(cfg),
+		NewVIPModeCheck(cfg),
+		NewVLANServiceCheck(cfg),
+		NewMTUPathCheck(cfg),
+		NewStaticRouteCheck(cfg),
+		NewCloudInitNetworkCheck(cfg),
+		NewConfigDeviceCheck(cfg),
+		NewDiskSizeCheck(cfg),
+		DiskCheck{},
+		WriteCacheCheck{},
+		AlignmentCheck{Devs: dataDisks, Wiped: cfg.Install.WipeDisksList},
+		PreviousInstallCheck{Targets: dataDisks},
+		ExistingInstallCheck{},
+		MaximaCheck{},
+		HCLCheck{},
+		PoolMembershipCheck{Targets: dataDisks},
+		ResidueCheck{Targets: dataDisks},
+		UpgradeSpaceCheck{},
+		PressureCheck{},
+	)
+}
+
+// DefaultChecks returns the hardware requirement checks, with a
+// NetworkSpeedCheck for each of the NICs named, and an MTUCheck that
+// they agree, for callers which don't have an install configuration yet.
+// They run early in boot, so the VirtCheck and NetworkSpeedChecks, which
+// can fail then for a moment, are retried.
+func DefaultChecks(nics []string) []ResultCheck {
+	checks := []ResultCheck{
+		CPUCheck{},
+		NewMemoryCheck(),
+		ECCMemoryCheck{},
+		WithRetry(NewVirtCheck(), DefaultRetryAttempts, DefaultRetryDelay),
+		NewKVMHostCheck(),
+		NewCPUVirtExtCheck(),
+	}
+	return append(checks, nicChecks(nics)...)
+}
+
+// nicChecks returns a NetworkSpeedCheck for each of the NICs named,
+// retried as their links may still be coming up, and an MTUCheck that
+// they agree.
+func nicChecks(nics []string) []ResultCheck {
+	var checks []ResultCheck
+	for _, nic := range nics {
+		checks = append(checks, WithRetry(NetworkSpeedCheck{Dev: nic}, DefaultRetryAttempts, DefaultRetryDelay))
+	}
+	if len(nics) > 1 {
+		checks = append(checks, MTUCheck{Devs: nics})
+	}
+	return checks
+}
+
+// A Runner runs a set of checks with the same Options, sharing a single
+// Env between them.  Only the checks which apply in Mode are run, so the
+// same checks can be given for installs and upgrades.
+type Runner struct {
+	Checks  []ResultCheck
+	Options Options
+	// Mode is RunModeInstall if empty.
+	Mode RunMode
+	// ExecCommand makes the commands which run the external tools the
+	// checks need, or is exec.Command if nil.
+	ExecCommand func(name string, args ...string) *exec.Cmd
+
+	// machine is what the checks look at, or the live host if nil.
+	machine *host
+}
+
+// NewRunner returns a Runner for the given checks, with the default
+// Options, but for any Thresholds shipped in the installer image.
+func NewRunner(checks ...ResultCheck) *Runner {
+	return &Runner{Checks: checks, Options: Options{Thresholds: imageThresholds(DefaultThresholdsPath)}}
+}
+
+// A Report is the outcome of a Runner's run.  The Options the run used
+// are recorded, because they affect how findings are classified.
+type Report struct {
+	DestructiveAllowed bool    `json:"destructiveAllowed"`
+	Production         bool    `json:"production"`
+	AirGapped          bool    `json:"airGapped"`
+	Mode               RunMode `json:"mode"`
+	Role               Role    `json:"role,omitempty"`
+	// Timestamp is when the run started.
+	Timestamp time.Time `json:"timestamp"`
+	Results   []Result  `json:"results"`
+	// Inventory is what the checks learned about the host.
+	Inventory *Inventory `json:"inventory,omitempty"`
+	// Cancelled is set if the run was cancelled before every check had
+	// run, so that the report is partial.
+	Cancelled bool `json:"cancelled,omitempty"`
+	// SchemaVersion and Integrity are only set in persisted reports.
+	SchemaVersion int        `json:"schemaVersion,omitempty"`
+	Integrity     *Integrity `json:"integrity,omitempty"`
+}
+
+// Run runs the checks for the Runner's Mode in order.  A check which
+// fails to run, or panics, doesn't stop the others; its error is
+// recorded in its Result instead, classified by its kind.  A check
+// which fails because the platform doesn't support it is skipped,
+// rather than failed.  The external tools the checks probe the host
+// with are run first, in a single inventory pass, and each only once.
+// If Options.CaptureEvidence is set, what each check consumed of them,
+// and of the files it read, is recorded in its Result.  If
+// Options.AutoRemediate is set, fixes are made as described there.
+// BenchmarkChecks only run if Options.Benchmarks is set, and those which
+// don't fit in what's left of the time budget are skipped rather than
+// started.  Other checks which take longer than Options.CheckTimeout
+// fail with an error of kind ErrTimeout, once the tools they're running
+// have been killed, and whatever they found is discarded.
+//
+// If ctx is cancelled, e.g. because the operator interrupted the
+// installer, the check in flight is left to give up, and it and the
+// checks which hadn't run yet are skipped, while the results of those
+// which had run are kept.  The report says it was cancelled.
+//
+// Each Result is logged as it comes in, by logResult.
+func (r *Runner) Run(ctx context.Context) Report {
+	checks := r.selected()
+	env := &Env{Options: r.Options, execCommand: r.ExecCommand, machine: r.machine}
+	env.gather(ctx, checks)
+	report := r.newReport(len(checks), env.host().now())
+	budget := newTimeBudget(r.Options.benchmarkBudget(), checks, env.host().now)
+	for _, check := range checks {
+		result, cancelled := r.evaluate(ctx, check, env, budget)
+		report.Cancelled = report.Cancelled || cancelled
+		logResult(result)
+		report.Results = append(report.Results, result)
+	}
+	report.Inventory = &env.Inventory
+	return report
+}
+
+// mode returns the Runner's Mode, or RunModeInstall if it's empty.
+func (r *Runner) mode() RunMode {
+	if r.Mode == "" {
+		return RunModeInstall
+	}
+	return r.Mode
+}
+
+// selected returns the checks which apply in the Runner's mode, without
+// the BenchmarkChecks, unless Options.Benchmarks is set.
+func (r *Runner) selected() []ResultCheck {
+	var checks []ResultCheck
+	for _, check := range r.Checks {
+		if runsIn(check, r.mode()) && (r.Options.Benchmarks || !isBenchmark(check)) {
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+// newReport returns the Report of a run starting at started, with room
+// for the Results of n checks.
+func (r *Runner) newReport(n int, started time.Time) Report {
+	return Report{
+		DestructiveAllowed: r.Options.DestructiveAllowed,
+		Production:         r.Options.Production,
+		AirGapped:          r.Options.AirGapped,
+		Mode:               r.mode(),
+		Role:               r.Options.Role,
+		Timestamp:          started.UTC(),
+		Results:            make([]Result, 0, n),
+	}
+}
+
+// evaluate evaluates check with env, within its time limit, or its
+// allotment of budget if it's a BenchmarkCheck, remediating what it finds
+// if Options.AutoRemediate is set, and returns its Result as it's
+// reported.  It returns whether the run was cancelled while it ran, or
+// before.
+func (r *Runner) evaluate(ctx context.Context, check ResultCheck, env *Env, budget *timeBudget) (Result, bool) {
+	if ctx.Err() != nil {
+		return skippedResult(Result{Name: resultName(check)}, runCancelled), true
+	}
+	timeout := r.Options.checkTimeout()
+	if isBenchmark(check) {
+		allotted, ok := budget.allot()
+		if !ok {
+			return skippedResult(Result{Name: resultName(check)}, budgetExhausted), false
+		}
+		timeout = allotted
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	env.ctx = checkCtx
+	if r.Options.CaptureEvidence {
+		env.evidence = newEvidenceLog(r.Options)
+	}
+	started := env.host().now()
+	result, err := evaluateCheck(checkCtx, check, env)
+	if err == nil && r.Options.AutoRemediate && result.Severity >= SeverityWarning && result.Remediation != nil &&
+		len(result.Remediation.Actions) > 0 {
+		result, err = remediate(checkCtx, check, env, result)
+	}
+	result.Duration = env.host().now().Sub(started)
+	if env.evidence != nil {
+		result.Evidence = env.evidence.evidence()
+	}
+	if err != nil && result.Name == "" {
+		result.Name = resultName(check)
+	}
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// It was interrupted, rather than failing
+		return skippedResult(result, runCancelled), true
+	case err != nil && checkCtx.Err() != nil && isBenchmark(check):
+		// It overran what it was allotted of the time budget
+		result = skippedResult(result, budgetExhausted)
+	case ctx.Err() == nil && checkCtx.Err() != nil && !isBenchmark(check):
+		// Even if it didn't fail, the tools it ran were killed
+		result = timedOutResult(result, timeout)
+	case errors.Is(err, ErrUnsupportedPlatform):
+		result.Severity = SeverityOK
+		result.Message = fmt.Sprintf("Skipped: %s.", err)
+	case err != nil:
+		result.Error = err.Error()
+		result.ErrorKind = classifyError(err)
+	}
+	return result, false
+}
+
+// evaluateCheck evaluates check, recovering from any panic, which it
+// returns as the check's error, so that a bug in one check doesn't stop
+// the others.  A check which hasn't returned within toolWaitDelay of ctx
+// being done, because it's stuck somewhere ctx doesn't reach, such as a
+// read from a hung device, is abandoned with ctx's error.  It evaluates
+// a fork of env, so that once abandoned, it can't change what the checks
+// after it see.
+func evaluateCheck(ctx context.Context, check ResultCheck, env *Env) (Result, error) {
+	type outcome struct {
+		result Result
+		err    error
+	}
+	fork := env.fork()
+	fork.ctx, fork.evidence = env.ctx, env.evidence
+	before := fork.Inventory
+	done := make(chan outcome, 1)
+	go func() {
+		var o outcome
+		defer func() {
+			if p := recover(); p != nil {
+				o = outcome{err: fmt.Errorf("check panicked: %v", p)}
+			}
+			done <- o
+		}()
+		o.result, o.err = check.Evaluate(ctx, fork)
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-ctx.Done():
+		select {
+		case o = <-done:
+		case <-time.After(toolWaitDelay):
+			return Result{}, ctx.Err()
+		}
+	}
+	env.merge(fork, before)
+	return o.result, o.err
+}
+
+// logResult logs result at the level its severity deserves: checks which
+// failed to run, or failed, as errors, warnings as warnings, and the rest
+// as information.
+func logResult(result Result) {
+	msg := result.Message
+	switch {
+	case result.Error != "":
+		msg = "error: " + result.Error
+	case msg == "":
+		msg = result.Severity.String()
+	}
+	switch {
+	case result.Error != "" || result.Severity == SeverityFatal:
+		logrus.Errorf("preflight: %s: %s", result.Name, msg)
+	case result.Severity == SeverityWarning:
+		logrus.Warnf("preflight: %s: %s", result.Name, msg)
+	default:
+		logrus.Infof("preflight: %s: %s", result.Name, msg)
+	}
+}
+
+// runCancelled is why checks are skipped when the run is cancelled.
+const runCancelled = "run cancelled"
+
+// skippedResult returns result as skipped for reason, keeping only its
+// name and how long it ran for.
+func skippedResult(result Result, reason string) Result {
+	return Result{Name: result.Name, Message: skippedPrefix + reason + ".", Duration: result.Duration}
+}
+
+// timedOutResult returns result as having failed because its check took
+// longer than timeout, keeping only its name and how long it ran for.
+func timedOutResult(result Result, timeout time.Duration) Result {
+	return Result{
+		Name:      result.Name,
+		Error:     fmt.Sprintf("timed out after %s", timeout),
+		ErrorKind: ErrorKindTimeout,
+		Duration:  result.Duration,
+	}
+}
+
+// resultName returns the name of the Result of check, for when it has to
+// be reported without being evaluated.  By convention, that's the name of
+// its type, without the Check suffix, or that of the check it wraps, such
+// as a RetryCheck's.
+func resultName(check ResultCheck) string {
+	if wrapper, ok := check.(interface{ Unwrap() ResultCheck }); ok {
+		return resultName(wrapper.Unwrap())
+	}
+	typ := reflect.TypeOf(check)
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return strings.TrimSuffix(typ.Name(), "Check")
+}
+
+// Override marks the fatal results the user has chosen to proceed
+// despite: all of them, or those of the named checks.
+func (r *Report) Override(all bool, names []string) {
+	for i := range r.Results {
+		result := &r.Results[i]
+		if result.Severity != SeverityFatal {
+			continue
+		}
+		if all || slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, result.Name) }) {
+			result.Overridden = true
+		}
+	}
+}
+
+// Fatal returns the fatal results which haven't been overridden.
+func (r Report) Fatal() []Result {
+	var fatal []Result
+	for _, result := range r.Results {
+		if result.Severity == SeverityFatal && !result.Overridden {
+			fatal = append(fatal, result)
+		}
+	}
+	return fatal
+}
+
+// Passed returns true if every check ran, and none found anything worth
+// a warning, so that the installer can go ahead without asking.  Fatal
+// results which have been overridden still count against it.
+func (r Report) Passed() bool {
+	if r.Cancelled {
+		return false
+	}
+	for _, result := range r.Results {
+		if result.Error != "" || result.Severity >= SeverityWarning {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText writes the report for people to read, one result per line.
+func (r Report) WriteText(w io.Writer) error {
+	for _, result := range r.Results {
+		msg := result.Message
+		if result.Error != "" {
+			msg = "error: " + result.Error
+		}
+		if result.Overridden {
+			msg += " (overridden)"
+		}
+		if result.AirGapped {
+			msg += " (" + airGappedMode + ")"
+		}
+		if _, err := fmt.Fprintf(w, "%-4s  %-16s  %s\n", result.Severity, result.Name, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The formats WriteFormat can write a report in.
+const (
+	ReportFormatText = "text"
+	ReportFormatJSON = "json"
+	ReportFormatYAML = "yaml"
+)
+
+// WriteFormat writes the report in the given format: ReportFormatText,
+// as WriteText does, or ReportFormatJSON or ReportFormatYAML, for
+// automation to read rather than scraping the messages.  The YAML has
+// the same keys as the JSON, so that the two can be read alike.
+func (r Report) WriteFormat(w io.Writer, format string) error {
+	var out []byte
+	var err error
+	switch format {
+	case ReportFormatText:
+		return r.WriteText(w)
+	case ReportFormatJSON:
+		if out, err = json.MarshalIndent(r, "", "  "); err != nil {
+			return err
+		}
+		out = append(out, '\n')
+	case ReportFormatYAML:
+		if out, err = r.yaml(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown report format %q, expected %s, %s or %s",
+			format, ReportFormatText, ReportFormatJSON, ReportFormatYAML)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// yaml returns the report as YAML.  It's converted from the JSON, rather
+// than marshalled directly, so that it's keyed by the JSON tags and the
+// types' MarshalJSON and MarshalText are used, without every field also
+// needing a yaml tag.
+func (r Report) yaml() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	// JSON is YAML in flow style, which is written back as it was read
+	// unless the styles are cleared.
+	var plain func(*yaml.Node)
+	plain = func(n *yaml.Node) {
+		n.Style = 0
+		for _, child := range n.Content {
+			plain(child)
+		}
+	}
+	plain(&node)
+	return yaml.Marshal(&node)
+}
+
+// WriteFile persists the report as JSON, with its schema version and
+// hash, but unsigned.
+func (r Report) WriteFile(path string) error {
+	return r.WriteSignedFile(path, nil)
+}
+
+// WriteSignedFile persists the report like WriteFile, signed with key,
+// unless it's nil.
+func (r Report) WriteSignedFile(path string, key ed25519.PrivateKey) error {
+	sealed, err := r.seal(key)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0600)
+}
@@ -0,0 +1,510 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// fakeCheck returns whatever it's told to, and records the Env it saw
+type fakeCheck struct {
+	result Result
+	err    error
+	env    **Env
+}
+
+func (c fakeCheck) Evaluate(_ context.Context, env *Env) (Result, error) {
+	if c.env != nil {
+		*c.env = env
+	}
+	return c.result, c.err
+}
+
+// testRunTime is when runs in tests start
+var testRunTime = time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+
+func TestRunner(t *testing.T) {
+	h := testHost()
+	defer logrus.SetOutput(os.Stderr)
+	h.now = func() time.Time { return testRunTime }
+	var logs bytes.Buffer
+	logrus.SetOutput(&logs)
+	var seen *Env
+	runner := Runner{
+		Checks: []ResultCheck{
+			fakeCheck{result: Result{Name: "First", Severity: SeverityWarning, Message: "meh"}},
+			fakeCheck{result: Result{Name: "Broken"}, err: errors.New("oops")},
+			fakeCheck{result: Result{Name: "Missing"}, err: toolError("/usr/bin/missing", &os.PathError{Op: "fork/exec", Path: "/usr/bin/missing", Err: os.ErrNotExist})},
+			fakeCheck{result: Result{Name: "Unsupported"}, err: errNoSMBIOS},
+			fakeCheck{result: Result{Name: "Last"}, env: &seen},
+		},
+		Options: Options{DestructiveAllowed: true},
+		machine: h,
+	}
+
+	report := runner.Run(context.Background())
+	assert.Equal(t, Report{
+		DestructiveAllowed: true,
+		Mode:               RunModeInstall,
+		Timestamp:          testRunTime,
+		Results: []Result{
+			{Name: "First", Severity: SeverityWarning, Message: "meh"},
+			{Name: "Broken", Error: "oops"},
+			{Name: "Missing", Error: "missing: fork/exec /usr/bin/missing: file does not exist", ErrorKind: ErrorKindToolMissing},
+			{Name: "Unsupported", Message: "Skipped: SMBIOS not available on this platform."},
+			{Name: "Last"},
+		},
+		Inventory: &Inventory{},
+	}, report)
+	assert.True(t, seen.Options.DestructiveAllowed)
+
+	// Each result is logged at the level its severity deserves
+	for _, line := range []string{
+		`level=warning msg="preflight: First: meh"`,
+		`level=error msg="preflight: Broken: error: oops"`,
+		`level=error msg="preflight: Missing: error: missing: fork/exec /usr/bin/missing: file does not exist"`,
+		`level=info msg="preflight: Unsupported: Skipped: SMBIOS not available on this platform."`,
+		`level=info msg="preflight: Last: pass"`,
+	} {
+		assert.Contains(t, logs.String(), line)
+	}
+}
+
+func TestNewRunner(t *testing.T) {
+	h := testHost()
+	defer logrus.SetOutput(os.Stderr)
+	h.now = func() time.Time { return testRunTime }
+	var logs bytes.Buffer
+	logrus.SetOutput(&logs)
+
+	runner := NewRunner(
+		fakeCheck{result: Result{Name: "CPU", Severity: SeverityFatal, Message: "Only 4 CPU cores detected."}},
+		fakeCheck{result: Result{Name: "Memory"}},
+	)
+	runner.machine = h
+	report := runner.Run(context.Background())
+	assert.Equal(t, []Result{
+		{Name: "CPU", Severity: SeverityFatal, Message: "Only 4 CPU cores detected."},
+		{Name: "Memory"},
+	}, report.Results)
+	assert.False(t, report.Passed())
+	assert.Contains(t, logs.String(), `level=error msg="preflight: CPU: Only 4 CPU cores detected."`)
+}
+
+func TestDefaultChecks(t *testing.T) {
+	assert.Equal(t, []ResultCheck{
+		CPUCheck{},
+		NewMemoryCheck(),
+		ECCMemoryCheck{},
+		NewKVMHostCheck(),
+		NewCPUVirtExtCheck(),
+		WithRetry(NetworkSpeedCheck{"eth0"}, DefaultRetryAttempts, DefaultRetryDelay),
+		WithRetry(NetworkSpeedCheck{"eth1"}, DefaultRetryAttempts, DefaultRetryDelay),
+		MTUCheck{Devs: []string{"eth0", "eth1"}},
+	}, slices.DeleteFunc(DefaultChecks([]string{"eth0", "eth1"}), func(check ResultCheck) bool {
+		// Its ExecCommand is a func, which can't be compared
+		retry, ok := check.(RetryCheck)
+		_, virt := retry.Check.(VirtCheck)
+		return ok && virt
+	}))
+	assert.Len(t, DefaultChecks(nil), 6)
+}
+
+// The hardware requirement checks are run for an install configuration
+// too, on the management interfaces it names.
+func TestConfigChecksHardware(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.ManagementInterface.Interfaces = []config.NetworkInterface{{Name: "eth0"}, {HwAddr: "52:54:00:12:34:56"}, {Name: "eth1"}}
+	checks := ConfigChecks(cfg)
+	for _, check := range DefaultChecks([]string{"eth0", "eth1"}) {
+		if retry, ok := check.(RetryCheck); ok {
+			if _, virt := retry.Check.(VirtCheck); virt {
+				// Its ExecCommand is a func, which can't be compared
+				assert.True(t, slices.ContainsFunc(checks, func(c ResultCheck) bool {
+					retry, ok := c.(RetryCheck)
+					_, virt := retry.Check.(VirtCheck)
+					return ok && virt && retry.Attempts == DefaultRetryAttempts
+				}))
+				continue
+			}
+		}
+		assert.Contains(t, checks, check)
+	}
+}
+
+// The partitions on the installation and data disks which will be kept
+// are checked for alignment.
+func TestConfigChecksAlignment(t *testing.T) {
+	h, command := loadFixture("testdata/previous-install/harvester")
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.Device = "/dev/sda"
+	runner := Runner{Checks: ConfigChecks(cfg), Options: Options{AirGapped: true}, ExecCommand: command, machine: h}
+	report := runner.Run(context.Background())
+	i := slices.IndexFunc(report.Results, func(r Result) bool { return r.Name == "Alignment" })
+	assert.NotEqual(t, -1, i)
+	assert.Equal(t, SeverityOK, report.Results[i].Severity)
+	assert.Empty(t, report.Results[i].Error)
+
+	h.sysBlock = "./testdata/alignment/legacy"
+	cfg.Install.DataDisk = "/dev/sdb"
+	runner.Checks = ConfigChecks(cfg)
+	report = runner.Run(context.Background())
+	i = slices.IndexFunc(report.Results, func(r Result) bool { return r.Name == "Alignment" })
+	assert.NotEqual(t, -1, i)
+	assert.Equal(t, SeverityWarning, report.Results[i].Severity)
+	assert.Contains(t, report.Results[i].Message, "sdb1 (start sector 63), sda1 (start sector 63), sda2 (start sector 1028160)")
+}
+
+// blockingCheck blocks until it's cancelled, saying when it's started.
+type blockingCheck struct {
+	started chan<- struct{}
+}
+
+func (c blockingCheck) Evaluate(ctx context.Context, _ *Env) (Result, error) {
+	close(c.started)
+	<-ctx.Done()
+	return Result{Name: "Blocking", Message: "Half done."}, ctx.Err()
+}
+
+func TestRunnerCancelled(t *testing.T) {
+	h := testHost()
+	h.now = func() time.Time { return testRunTime }
+	started := make(chan struct{})
+	runner := Runner{
+		Checks: []ResultCheck{
+			fakeCheck{result: Result{Name: "First", Severity: SeverityWarning, Message: "meh"}},
+			blockingCheck{started},
+			fakeCheck{result: Result{Name: "Last"}},
+			CPUCheck{},
+		},
+		machine: h,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	report := runner.Run(ctx)
+	assert.True(t, report.Cancelled)
+	assert.Equal(t, []Result{
+		{Name: "First", Severity: SeverityWarning, Message: "meh"},
+		// What the check in flight found is dropped, as it's incomplete
+		{Name: "Blocking", Message: "Skipped: run cancelled."},
+		// Those which hadn't run are named after their types
+		{Name: "fake", Message: "Skipped: run cancelled."},
+		{Name: "CPU", Message: "Skipped: run cancelled."},
+	}, report.Results)
+
+	// The partial report is still rendered, and persisted
+	var text strings.Builder
+	assert.Nil(t, Renderer{}.Render(&text, report))
+	assert.Contains(t, text.String(), "SKIP  CPU       Skipped.\n")
+	assert.Contains(t, text.String(), "4 checks: 0 passed, 1 warned, 0 failed, 3 skipped. The run was cancelled")
+	path := filepath.Join(t.TempDir(), "report.json")
+	assert.Nil(t, report.WriteFile(path))
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var persisted Report
+	assert.Nil(t, json.Unmarshal(data, &persisted))
+	assert.True(t, persisted.Cancelled)
+	assert.Equal(t, report.Results, persisted.Results)
+
+	// A run cancelled before it started runs nothing
+	report = runner.Run(ctx)
+	assert.True(t, report.Cancelled)
+	assert.Len(t, report.Results, 4)
+	for _, result := range report.Results {
+		assert.Equal(t, "Skipped: run cancelled.", result.Message, result.Name)
+	}
+}
+
+// A check which takes too long fails with a timeout, once the tool it's
+// stuck on has been killed, and the run carries on.
+func TestRunnerCheckTimeout(t *testing.T) {
+	var out []byte
+	var err error
+	runner := Runner{
+		Checks: []ResultCheck{
+			probingCheck{"Hanging", func(env *Env) {
+				out, err = env.output("/usr/sbin/dmidecode", "-t", "17")
+			}},
+			probingCheck{"Reading", func(env *Env) {
+				// It's the same tool, which isn't run again
+				_, _ = env.output("/usr/sbin/dmidecode", "-t", "17")
+				_, _ = env.readFile("/proc/meminfo")
+			}},
+			fakeCheck{result: Result{Name: "Last"}},
+		},
+		Options:     Options{CheckTimeout: 200 * time.Millisecond},
+		ExecCommand: fakeCommand("hang"),
+	}
+	started := time.Now()
+	report := runner.Run(context.Background())
+	assert.Less(t, time.Since(started), 10*time.Second)
+	assert.False(t, report.Cancelled)
+	assert.Empty(t, out)
+	assert.NotNil(t, err)
+
+	hanging := report.Results[0]
+	assert.Equal(t, "Hanging", hanging.Name)
+	assert.Equal(t, "timed out after 200ms", hanging.Error)
+	assert.Equal(t, ErrorKindTimeout, hanging.ErrorKind)
+	assert.GreaterOrEqual(t, hanging.Duration, 200*time.Millisecond)
+	assert.Equal(t, Result{Name: "Reading", Duration: report.Results[1].Duration}, report.Results[1])
+	assert.Equal(t, Result{Name: "Last", Duration: report.Results[2].Duration}, report.Results[2])
+}
+
+// A check which is stuck somewhere killing its tools doesn't reach is
+// given up on soon after its time is up, and what it records once it
+// comes unstuck is ignored.
+func TestRunnerCheckTimeoutStuck(t *testing.T) {
+	unstuck := make(chan struct{})
+	recorded := make(chan struct{})
+	runner := Runner{
+		Checks: []ResultCheck{
+			probingCheck{"Stuck", func(env *Env) {
+				<-unstuck
+				env.Inventory.MachineID = "f00dfeed"
+				close(recorded)
+			}},
+			probingCheck{"Last", func(env *Env) {}},
+		},
+		Options: Options{CheckTimeout: 200 * time.Millisecond},
+	}
+	started := time.Now()
+	report := runner.Run(context.Background())
+	assert.Less(t, time.Since(started), 5*time.Second)
+	assert.Equal(t, Result{Name: "probing", Error: "timed out after 200ms", ErrorKind: ErrorKindTimeout,
+		Duration: report.Results[0].Duration}, report.Results[0])
+	assert.Equal(t, Result{Name: "Last", Duration: report.Results[1].Duration}, report.Results[1])
+
+	close(unstuck)
+	<-recorded
+	assert.Empty(t, report.Inventory.MachineID)
+}
+
+// Checks which set up something temporary tear it down when they're
+// cancelled, so the run can be interrupted safely.
+func TestRunnerCancelledCleanup(t *testing.T) {
+	h := testHost()
+	h.interfaceAddrs = func() (map[string][]*net.IPNet, error) { return nil, nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	linker := &fakeVLANLinker{}
+	runner := Runner{Checks: []ResultCheck{
+		probingCheck{"Inventory", func(env *Env) { env.Inventory.NICs = []NIC{{Name: "eno1"}} }},
+		VLANServiceCheck{
+			Members: []string{"eno1"},
+			Network: config.Network{Method: config.NetworkMethodStatic, IP: "192.168.100.20", SubnetMask: "255.255.255.0",
+				Gateway: "192.168.100.1", VlanID: 100},
+			DNSServers: []string{"192.168.100.2"},
+			Linker:     linker,
+			// The operator interrupts the run while the gateway is probed
+			Prober: fakeVLANProber{cancel: cancel},
+		},
+		probingCheck{"Never", func(*Env) { t.Error("a check ran after the run was cancelled") }},
+	}, machine: h}
+	report := runner.Run(ctx)
+	assert.True(t, report.Cancelled)
+	assert.Equal(t, "Inventory", report.Results[0].Name)
+	assert.Equal(t, "Skipped: run cancelled.", report.Results[1].Message)
+	assert.Equal(t, "VLANService", report.Results[1].Name)
+	assert.Equal(t, []string{
+		"add preflight100 on eno1 as VLAN 100",
+		"assign 192.168.100.20/24 to preflight100",
+		"delete preflight100",
+	}, linker.calls)
+}
+
+func TestReportWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	report := Report{
+		DestructiveAllowed: true,
+		Results:            []Result{{Name: "Residue", Severity: SeverityFatal, Message: "nope"}},
+	}
+	assert.Nil(t, report.WriteFile(path))
+
+	out, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var raw map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out, &raw))
+	assert.Equal(t, true, raw["destructiveAllowed"])
+	assert.Equal(t, "fail", raw["results"].([]interface{})[0].(map[string]interface{})["severity"])
+
+	assert.Equal(t, float64(ReportSchemaVersion), raw["schemaVersion"])
+
+	var decoded Report
+	assert.Nil(t, json.Unmarshal(out, &decoded))
+	assert.NotNil(t, decoded.Integrity)
+	report.SchemaVersion, report.Integrity = ReportSchemaVersion, decoded.Integrity
+	assert.Equal(t, report, decoded)
+}
+
+func TestReportOverride(t *testing.T) {
+	newReport := func() Report {
+		return Report{Results: []Result{
+			{Name: "Residue", Severity: SeverityFatal, Message: "nope"},
+			{Name: "Memory", Severity: SeverityWarning, Message: "meh"},
+			{Name: "BootMode", Severity: SeverityFatal, Message: "no"},
+		}}
+	}
+
+	report := newReport()
+	assert.Equal(t, []Result{report.Results[0], report.Results[2]}, report.Fatal())
+
+	report.Override(false, []string{"residue", "Memory"})
+	assert.True(t, report.Results[0].Overridden)
+	assert.False(t, report.Results[1].Overridden, "only fatal results are overridden")
+	assert.Equal(t, []Result{report.Results[2]}, report.Fatal())
+
+	report = newReport()
+	report.Override(true, nil)
+	assert.Empty(t, report.Fatal())
+	assert.True(t, report.Results[2].Overridden)
+}
+
+func TestReportPassed(t *testing.T) {
+	tests := []struct {
+		name   string
+		report Report
+		passed bool
+	}{
+		{"empty", Report{}, true},
+		{"information", Report{Results: []Result{
+			{Name: "CPU"},
+			{Name: "HCL", Severity: SeverityInfo, Message: "1 of 2 components validated."},
+			{Name: "BMC", Message: "Skipped: nothing to do."},
+		}}, true},
+		{"warning", Report{Results: []Result{{Name: "Memory", Severity: SeverityWarning, Message: "meh"}}}, false},
+		{"fatal", Report{Results: []Result{{Name: "CPU", Severity: SeverityFatal, Message: "nope"}}}, false},
+		{"overridden", Report{Results: []Result{{Name: "CPU", Severity: SeverityFatal, Message: "nope", Overridden: true}}}, false},
+		{"error", Report{Results: []Result{{Name: "Broken", Error: "oops"}}}, false},
+		{"cancelled", Report{Results: []Result{{Name: "CPU"}}, Cancelled: true}, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.passed, tt.report.Passed(), tt.name)
+	}
+}
+
+func TestReportWriteText(t *testing.T) {
+	report := Report{Results: []Result{
+		{Name: "Residue", Severity: SeverityFatal, Message: "nope", Overridden: true},
+		{Name: "Broken", Error: "oops"},
+		{Name: "ConfiguredNTP", Message: "Skipped: nothing to do.", AirGapped: true},
+	}}
+	var out strings.Builder
+	assert.Nil(t, report.WriteText(&out))
+	assert.Equal(t, "fail  Residue           nope (overridden)\n"+
+		"pass  Broken            error: oops\n"+
+		"pass  ConfiguredNTP     Skipped: nothing to do. (air-gapped mode)\n", out.String())
+}
+
+func TestReportWriteFormat(t *testing.T) {
+	report := Report{
+		Timestamp: testRunTime,
+		Results: []Result{
+			{
+				Name:        "NetworkSpeed",
+				Severity:    SeverityWarning,
+				Message:     "Link speed of eth0 is 1Gbps.",
+				Device:      "eth0",
+				Facts:       map[string]any{"speedMbps": 1000},
+				Measurement: &Measurement{Quantity: "Network", Value: 1, Unit: "Gbps", MinTest: 1, MinProd: 10},
+			},
+			{
+				Name:        "Memory",
+				Severity:    SeverityFatal,
+				Message:     "7.5GiB RAM detected.",
+				Measurement: &Measurement{Quantity: "Memory", Value: 7.5, Unit: "GiB", MinTest: 32, MinProd: 64, Tolerance: 0.9},
+			},
+			{Name: "Broken", Error: "oops"},
+		},
+	}
+
+	decoders := map[string]func([]byte, any) error{
+		ReportFormatJSON: json.Unmarshal,
+		ReportFormatYAML: yaml.Unmarshal,
+	}
+	for format, unmarshal := range decoders {
+		var out bytes.Buffer
+		assert.Nil(t, report.WriteFormat(&out, format), format)
+		var raw map[string]any
+		assert.Nil(t, unmarshal(out.Bytes(), &raw), format)
+
+		results := raw["results"].([]any)
+		assert.Len(t, results, 3, format)
+		network := results[0].(map[string]any)
+		assert.Equal(t, "NetworkSpeed", network["name"], format)
+		assert.Equal(t, "eth0", network["device"], format)
+		assert.Equal(t, "warn", network["severity"], format)
+		measurement := network["measurement"].(map[string]any)
+		assert.Equal(t, "Gbps", measurement["unit"], format)
+		assert.EqualValues(t, 1, measurement["value"], format)
+		assert.EqualValues(t, 1, measurement["minTest"], format)
+		assert.EqualValues(t, 10, measurement["minProd"], format)
+		assert.NotContains(t, measurement, "tolerance", format)
+
+		memory := results[1].(map[string]any)
+		assert.Equal(t, "fail", memory["severity"], format)
+		assert.NotContains(t, memory, "device", format)
+		measurement = memory["measurement"].(map[string]any)
+		assert.EqualValues(t, 7.5, measurement["value"], format)
+		assert.EqualValues(t, 32, measurement["minTest"], format)
+		assert.EqualValues(t, 0.9, measurement["tolerance"], format)
+
+		broken := results[2].(map[string]any)
+		assert.Equal(t, "oops", broken["error"], format)
+		assert.NotContains(t, broken, "measurement", format)
+	}
+
+	// The JSON reads back as the report it was written from
+	var out bytes.Buffer
+	assert.Nil(t, report.WriteFormat(&out, ReportFormatJSON))
+	var decoded Report
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, report.Results[1], decoded.Results[1])
+	assert.Equal(t, *report.Results[0].Measurement, *decoded.Results[0].Measurement)
+
+	out.Reset()
+	assert.Nil(t, report.WriteFormat(&out, ReportFormatText))
+	assert.Contains(t, out.String(), "warn  NetworkSpeed      Link speed of eth0 is 1Gbps.\n")
+
+	assert.EqualError(t, report.WriteFormat(&out, "xml"), `unknown report format "xml", expected text, json or yaml`)
+}
+
+func TestOptionsFromConfig(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	assert.False(t, OptionsFromConfig(cfg).DestructiveAllowed)
+	assert.Equal(t, DefaultMaxVersionSkew, OptionsFromConfig(cfg).MaxVersionSkew)
+	assert.Equal(t, DefaultCAExpiryWindow, OptionsFromConfig(cfg).CAExpiryWindow)
+	assert.Equal(t, DefaultMinTokenEntropy, OptionsFromConfig(cfg).MinTokenEntropy)
+	assert.EqualValues(t, DefaultMaxToolOutput, OptionsFromConfig(cfg).MaxToolOutput)
+	cfg.Install.WipeAllDisks = true
+	assert.True(t, OptionsFromConfig(cfg).DestructiveAllowed)
+	assert.Equal(t, RoleManagement, OptionsFromConfig(cfg).Role)
+	cfg.Install.Role = config.RoleWitness
+	assert.Equal(t, RoleWitness, OptionsFromConfig(cfg).Role)
+	assert.False(t, OptionsFromConfig(cfg).AirGapped)
+	cfg.Install.AirGapped = true
+	assert.True(t, OptionsFromConfig(cfg).AirGapped)
+	assert.False(t, OptionsFromConfig(cfg).TPMRequired)
+	cfg.Install.RequireTPM = true
+	assert.True(t, OptionsFromConfig(cfg).TPMRequired)
+}
@@ -0,0 +1,143 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// A consoleArg is a console= kernel parameter, e.g. "ttyS0,115200n8" has
+// Name "ttyS0" and Options "115200n8".
+type consoleArg struct {
+	Name    string
+	Options string
+}
+
+func parseConsoleArg(arg string) consoleArg {
+	name, options, _ := strings.Cut(arg, ",")
+	return consoleArg{Name: strings.TrimPrefix(name, "/dev/"), Options: options}
+}
+
+// speed returns the baud rate from the options, e.g. "115200" from
+// "115200n8", or "" if there isn't one.
+func (c consoleArg) speed() string {
+	end := strings.IndexFunc(c.Options, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		return c.Options
+	}
+	return c.Options[:end]
+}
+
+func (c consoleArg) serial() bool {
+	return strings.HasPrefix(c.Name, "ttyS") || strings.HasPrefix(c.Name, "ttyAMA")
+}
+
+// SerialConsoleCheck verifies that the serial consoles given on the
+// kernel command line, and the console the install configuration will
+// persist (TTY), refer to serial ports which actually exist.  Systems
+// with no serial console configured pass silently.
+type SerialConsoleCheck struct {
+	TTY string
+}
+
+// NewSerialConsoleCheck returns a SerialConsoleCheck for the console in
+// the given install configuration.
+func NewSerialConsoleCheck(cfg *config.HarvesterConfig) SerialConsoleCheck {
+	return SerialConsoleCheck{TTY: cfg.Install.TTY}
+}
+
+func (c SerialConsoleCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "SerialConsole"
+
+	cmdline, err := env.readFile(env.host().procCmdline)
+	if err != nil {
+		return
+	}
+	var consoles []consoleArg
+	for _, param := range strings.Fields(string(cmdline)) {
+		if arg, ok := strings.CutPrefix(param, "console="); ok {
+			if console := parseConsoleArg(arg); console.serial() {
+				consoles = append(consoles, console)
+			}
+		}
+	}
+	persisted := parseConsoleArg(c.TTY)
+	if len(consoles) == 0 && !persisted.serial() {
+		return
+	}
+
+	detected, err := detectedSerialPorts(env)
+	if err != nil {
+		return
+	}
+
+	var problems, names []string
+	for _, console := range consoles {
+		names = append(names, console.Name)
+		if !slices.Contains(detected, console.Name) {
+			problems = append(problems, fmt.Sprintf("The kernel command line has console=%s, but no UART was detected for %s.",
+				console.Name, console.Name))
+		}
+		if persisted.Name == console.Name && persisted.speed() != "" && console.speed() != "" && persisted.speed() != console.speed() {
+			problems = append(problems, fmt.Sprintf("The install configuration sets the console speed of %s to %s, but the kernel command line uses %s.",
+				console.Name, persisted.speed(), console.speed()))
+		}
+	}
+	if persisted.serial() && !slices.Contains(detected, persisted.Name) && !slices.Contains(names, persisted.Name) {
+		problems = append(problems, fmt.Sprintf("The install configuration persists the console on %s, but no UART was detected for %s.",
+			persisted.Name, persisted.Name))
+	}
+
+	if len(problems) == 0 {
+		if persisted.serial() && !slices.Contains(names, persisted.Name) {
+			names = append(names, persisted.Name)
+		}
+		result.Message = fmt.Sprintf("Serial console on %s.", strings.Join(names, ", "))
+		return
+	}
+	if len(detected) == 0 {
+		detected = []string{"none"}
+	}
+	result.Severity = SeverityWarning
+	result.Message = fmt.Sprintf("%s Serial ports detected: %s. Please check the console settings, and the serial port configuration in the firmware.",
+		strings.Join(problems, " "), strings.Join(detected, ", "))
+	return
+}
+
+// detectedSerialPorts returns the serial ports which have a UART behind
+// them.  The 8250 driver registers ttyS0-3 (or more) whether or not there
+// are any UARTs, so for those we have to look at the driver's idea of the
+// UART type.  Other drivers (e.g. PL011, for ttyAMA) only register ports
+// which exist, so they can be found in sysfs.
+func detectedSerialPorts(env *Env) ([]string, error) {
+	var detected []string
+
+	out, err := env.readFile(env.host().procTTYDriverSerial)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		var port int
+		var uart string
+		if n, _ := fmt.Sscanf(line, "%d: uart:%s", &port, &uart); n == 2 && uart != "unknown" {
+			detected = append(detected, fmt.Sprintf("ttyS%d", port))
+		}
+	}
+
+	matches, err := env.glob(filepath.Join(env.host().sysClassTTY, "ttyAMA*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, match := range matches {
+		detected = append(detected, filepath.Base(match))
+	}
+	sort.Strings(detected)
+	return detected, nil
+}
@@ -0,0 +1,77 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerialConsoleCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture  string
+		tty      string
+		severity Severity
+		message  string
+	}{
+		{
+			fixture: "match",
+			message: "Serial console on ttyS0.",
+		},
+		{
+			fixture: "match",
+			tty:     "ttyS0",
+			message: "Serial console on ttyS0.",
+		},
+		{
+			fixture: "match",
+			tty:     "ttyAMA0",
+			message: "Serial console on ttyS0, ttyAMA0.",
+		},
+		{
+			fixture:  "match",
+			tty:      "ttyS0,9600",
+			severity: SeverityWarning,
+			message: "The install configuration sets the console speed of ttyS0 to 9600, but the kernel command line uses 115200. " +
+				"Serial ports detected: ttyAMA0, ttyS0. Please check the console settings, and the serial port configuration in the firmware.",
+		},
+		{
+			fixture:  "mismatch",
+			severity: SeverityWarning,
+			message: "The kernel command line has console=ttyS1, but no UART was detected for ttyS1. " +
+				"Serial ports detected: ttyS0. Please check the console settings, and the serial port configuration in the firmware.",
+		},
+		{
+			fixture:  "absent-uart",
+			severity: SeverityWarning,
+			message: "The kernel command line has console=ttyS0, but no UART was detected for ttyS0. " +
+				"Serial ports detected: none. Please check the console settings, and the serial port configuration in the firmware.",
+		},
+		{
+			fixture: "graphical",
+		},
+		{
+			fixture: "graphical",
+			tty:     "tty1",
+		},
+		{
+			fixture:  "graphical",
+			tty:      "ttyS1",
+			severity: SeverityWarning,
+			message: "The install configuration persists the console on ttyS1, but no UART was detected for ttyS1. " +
+				"Serial ports detected: none. Please check the console settings, and the serial port configuration in the firmware.",
+		},
+	}
+
+	for _, test := range tests {
+		dir := "./testdata/serial-console/" + test.fixture
+		h.procCmdline = dir + "/proc/cmdline"
+		h.procTTYDriverSerial = dir + "/proc/tty/driver/serial"
+		h.sysClassTTY = dir + "/sys/class/tty"
+		result, err := SerialConsoleCheck{TTY: test.tty}.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.fixture+" "+test.tty)
+		assert.Equal(t, Result{Name: "SerialConsole", Severity: test.severity, Message: test.message}, result, test.fixture+" "+test.tty)
+	}
+}
@@ -0,0 +1,134 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// A ConflictingService is a service which fights with what the installer
+// sets up, if it's running.
+type ConflictingService struct {
+	// Unit is the systemd unit, e.g. "docker.service".
+	Unit string
+	// Process is the process name (as in /proc/<pid>/comm), to catch the
+	// service when it wasn't started by systemd.
+	Process string
+	// Hard conflicts are fatal, others are warned about.
+	Hard bool
+	// WithContainers means the service only conflicts if it has
+	// containers.
+	WithContainers bool
+	// Reason explains the conflict.
+	Reason string
+}
+
+// DefaultConflictingServices are the services known to conflict with SaftOS.
+var DefaultConflictingServices = []ConflictingService{
+	{Unit: "libvirtd.service", Process: "libvirtd", Hard: true,
+		Reason: "it manages KVM guests and networks outside of SaftOS"},
+	{Unit: "docker.service", Process: "dockerd", Hard: true,
+		Reason: "its bridge and iptables rules conflict with the cluster network"},
+	{Unit: "containerd.service", Process: "containerd", Hard: true, WithContainers: true,
+		Reason: "its containers would be left running alongside the cluster's"},
+	{Unit: "k3s.service", Process: "k3s-server", Hard: true,
+		Reason: "it's another Kubernetes distribution"},
+	{Unit: "k3s-agent.service", Process: "k3s-agent", Hard: true,
+		Reason: "it's another Kubernetes distribution"},
+	{Unit: "rke2-server.service", Process: "rke2", Hard: true,
+		Reason: "a previous RKE2 installation would compete with the one SaftOS sets up"},
+	{Unit: "rke2-agent.service", Hard: true,
+		Reason: "a previous RKE2 installation would compete with the one SaftOS sets up"},
+	{Unit: "firewalld.service", Process: "firewalld",
+		Reason: "it rewrites iptables rules which the cluster network relies on"},
+}
+
+// ConflictingServicesCheck looks for services already running on the host
+// which conflict with SaftOS, e.g. in a live environment customised by
+// the user.  Services are found by asking systemd, and by scanning /proc
+// for ones which weren't started by systemd.
+type ConflictingServicesCheck struct {
+	// Services overrides DefaultConflictingServices, if set.
+	Services []ConflictingService
+}
+
+func (c ConflictingServicesCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "ConflictingServices"
+	services := c.Services
+	if services == nil {
+		services = DefaultConflictingServices
+	}
+
+	processes, err := runningProcesses(env)
+	if err != nil {
+		return
+	}
+
+	var hard, soft []string
+	for _, service := range services {
+		running := service.Process != "" && processes[service.Process]
+		if !running && service.Unit != "" {
+			running = env.succeeds("/usr/bin/systemctl", "is-active", "--quiet", service.Unit)
+		}
+		if !running || (service.WithContainers && !containerdHasContainers(env)) {
+			continue
+		}
+		name := service.Unit
+		if name == "" {
+			name = service.Process
+		}
+		desc := fmt.Sprintf("%s (%s)", name, service.Reason)
+		if service.Hard {
+			hard = append(hard, desc)
+		} else {
+			soft = append(soft, desc)
+		}
+	}
+
+	var msgs []string
+	if len(hard) > 0 {
+		result.Severity = SeverityFatal
+		msgs = append(msgs, fmt.Sprintf("Conflicting services are running, and must be stopped and disabled: %s.",
+			strings.Join(hard, ", ")))
+	}
+	if len(soft) > 0 {
+		if result.Severity < SeverityWarning {
+			result.Severity = SeverityWarning
+		}
+		msgs = append(msgs, fmt.Sprintf("Services which may interfere with SaftOS are running: %s.", strings.Join(soft, ", ")))
+	}
+	result.Message = strings.Join(msgs, " ")
+	return
+}
+
+// runningProcesses returns the names of the processes in /proc.
+func runningProcesses(env *Env) (map[string]bool, error) {
+	dir := filepath.Join(env.host().root, "proc")
+	entries, err := env.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	processes := map[string]bool{}
+	for _, entry := range entries {
+		if strings.Trim(entry.Name(), "0123456789") != "" {
+			continue
+		}
+		if comm := env.readTrimmed(filepath.Join(dir, entry.Name(), "comm")); comm != "" {
+			processes[comm] = true
+		}
+	}
+	return processes, nil
+}
+
+// containerdHasContainers returns true if containerd has any running
+// tasks, in any namespace.
+func containerdHasContainers(env *Env) bool {
+	tasks, _ := env.glob(filepath.Join(env.host().root, "run/containerd/io.containerd.runtime.v2.task/*/*"))
+	for _, task := range tasks {
+		if info, err := env.stat(task); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
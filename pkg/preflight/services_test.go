@@ -0,0 +1,73 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictingServicesCheck(t *testing.T) {
+	h := testHost()
+
+	const (
+		libvirtd   = "libvirtd.service (it manages KVM guests and networks outside of SaftOS)"
+		docker     = "docker.service (its bridge and iptables rules conflict with the cluster network)"
+		containerd = "containerd.service (its containers would be left running alongside the cluster's)"
+		rke2       = "rke2-agent.service (a previous RKE2 installation would compete with the one SaftOS sets up)"
+		firewalld  = "firewalld.service (it rewrites iptables rules which the cluster network relies on)"
+	)
+
+	tests := []struct {
+		fixture  string
+		units    []string
+		severity Severity
+		message  string
+	}{
+		{fixture: "clean"},
+		{
+			fixture:  "clean",
+			units:    []string{"libvirtd.service", "rke2-agent.service"},
+			severity: SeverityFatal,
+			message:  "Conflicting services are running, and must be stopped and disabled: " + libvirtd + ", " + rke2 + ".",
+		},
+		{
+			fixture:  "dockerd",
+			severity: SeverityFatal,
+			message:  "Conflicting services are running, and must be stopped and disabled: " + docker + ".",
+		},
+		{
+			fixture: "containerd-idle",
+			units:   []string{"containerd.service"},
+		},
+		{
+			fixture:  "containerd-busy",
+			units:    []string{"firewalld.service"},
+			severity: SeverityFatal,
+			message: "Conflicting services are running, and must be stopped and disabled: " + containerd + ". " +
+				"Services which may interfere with SaftOS are running: " + firewalld + ".",
+		},
+		{
+			fixture:  "clean",
+			units:    []string{"firewalld.service"},
+			severity: SeverityWarning,
+			message:  "Services which may interfere with SaftOS are running: " + firewalld + ".",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/conflicting-services/" + test.fixture
+		command := func(_ string, args ...string) *exec.Cmd {
+			for _, unit := range test.units {
+				if args[len(args)-1] == unit {
+					return fakeExecCommand("systemctl-active")
+				}
+			}
+			return fakeExecCommand("systemctl-fail")
+		}
+		result, err := ConflictingServicesCheck{}.Evaluate(context.Background(), &Env{execCommand: command, machine: h})
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "ConflictingServices", Severity: test.severity, Message: test.message}, result, test.fixture)
+	}
+}
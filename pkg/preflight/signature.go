@@ -0,0 +1,228 @@
+package preflight
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	sigZFS       = "zfs_member"
+	sigBtrfs     = "btrfs"
+	sigBluestore = "ceph_bluestore"
+	sigExt2      = "ext2"
+	sigExt3      = "ext3"
+	sigExt4      = "ext4"
+
+	// Ceph BlueStore OSDs (as created by ceph-volume or Rook) start with
+	// this, followed by the OSD UUID
+	bluestoreMagic = "bluestore block device\n"
+
+	// The Btrfs superblock lives at 64KiB, with the magic at 0x40 in it
+	btrfsSuperblockOffset = 0x10000
+	btrfsMagic            = "_BHRfS_M"
+
+	// The ext2/3/4 superblock lives at 1KiB, with the magic at 0x38 in it
+	extSuperblockOffset  = 0x400
+	extMagic             = 0xef53
+	extCompatHasJournal  = 0x4
+	extIncompatExtents   = 0x40
+	extIncompatFlexGroup = 0x200
+
+	// The first ZFS vdev label is at the start of the device, with the
+	// XDR encoded config nvlist at 16KiB and the uberblock ring at 128KiB.
+	// The ring's slots are the larger of 1KiB and the pool's sector size,
+	// and any of them may be the one in use.
+	zfsNVListOffset      = 0x4000
+	zfsNVListSize        = 0x1c000
+	zfsUberblockOffset   = 0x20000
+	zfsUberblockRingSize = 0x20000
+	zfsUberblockSlotSize = 0x400
+	zfsUberblockMagic    = 0x00bab10c
+	zfsDataTypeUint64    = 8
+	zfsDataTypeString    = 9
+	zfsXDREncoding       = 1
+	zfsMaxNVListEntries  = 1024
+)
+
+// A signature describes a filesystem or volume manager that was found on
+// a block device.  UUID is the filesystem UUID (Btrfs) or pool GUID (ZFS),
+// Label is the filesystem label or pool name.
+type signature struct {
+	Type       string
+	UUID       string
+	Label      string
+	NumDevices uint64
+}
+
+// probeSignature looks for known on-disk signatures on the named device
+// (e.g. "sda1").  The device is only ever opened read-only.  If nothing
+// recognisable is found, an empty signature is returned.
+func probeSignature(env *Env, dev string) (sig signature, err error) {
+	f, err := os.Open(filepath.Join(env.host().devDir, dev))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if sig, err = probeBluestore(f); err != nil || sig.Type != "" {
+		return
+	}
+	if sig, err = probeBtrfs(f); err != nil || sig.Type != "" {
+		return
+	}
+	if sig, err = probeExt(f); err != nil || sig.Type != "" {
+		return
+	}
+	return probeZFS(f)
+}
+
+// readAtMost is io.ReadAt, except that hitting EOF isn't an error, because
+// a device too short to contain a signature just doesn't have one.
+func readAtMost(r io.ReaderAt, buf []byte, off int64) (int, error) {
+	n, err := r.ReadAt(buf, off)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+func probeBluestore(r io.ReaderAt) (sig signature, err error) {
+	label := make([]byte, len(bluestoreMagic)+36)
+	n, err := readAtMost(r, label, 0)
+	if err != nil || n < len(label) || string(label[:len(bluestoreMagic)]) != bluestoreMagic {
+		return
+	}
+	sig.Type = sigBluestore
+	sig.UUID = string(label[len(bluestoreMagic):])
+	return
+}
+
+func probeBtrfs(r io.ReaderAt) (sig signature, err error) {
+	sb := make([]byte, 0x100)
+	n, err := readAtMost(r, sb, btrfsSuperblockOffset)
+	if err != nil || n < len(sb) || string(sb[0x40:0x48]) != btrfsMagic {
+		return
+	}
+	fsid := sb[0x20:0x30]
+	sig.Type = sigBtrfs
+	sig.UUID = fmt.Sprintf("%x-%x-%x-%x-%x", fsid[0:4], fsid[4:6], fsid[6:8], fsid[8:10], fsid[10:16])
+	sig.NumDevices = binary.LittleEndian.Uint64(sb[0x88:0x90])
+	return
+}
+
+func probeExt(r io.ReaderAt) (sig signature, err error) {
+	sb := make([]byte, 0x100)
+	n, err := readAtMost(r, sb, extSuperblockOffset)
+	if err != nil || n < len(sb) || binary.LittleEndian.Uint16(sb[0x38:]) != extMagic {
+		return
+	}
+	compat := binary.LittleEndian.Uint32(sb[0x5c:])
+	incompat := binary.LittleEndian.Uint32(sb[0x60:])
+	switch {
+	case incompat&(extIncompatExtents|extIncompatFlexGroup) != 0:
+		sig.Type = sigExt4
+	case compat&extCompatHasJournal != 0:
+		sig.Type = sigExt3
+	default:
+		sig.Type = sigExt2
+	}
+	uuid := sb[0x68:0x78]
+	sig.UUID = fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+	sig.Label = string(bytes.TrimRight(sb[0x78:0x88], "\x00"))
+	return
+}
+
+func probeZFS(r io.ReaderAt) (sig signature, err error) {
+	ring := make([]byte, zfsUberblockRingSize)
+	n, err := readAtMost(r, ring, zfsUberblockOffset)
+	if err != nil || !hasZFSUberblock(ring[:n]) {
+		return
+	}
+	nvlist := make([]byte, zfsNVListSize)
+	if n, err = readAtMost(r, nvlist, zfsNVListOffset); err != nil {
+		return
+	}
+	pairs, err := parseXDRNVList(nvlist[:n])
+	if err != nil {
+		return
+	}
+	sig.Type = sigZFS
+	if name, ok := pairs["name"].(string); ok {
+		sig.Label = name
+	}
+	if guid, ok := pairs["pool_guid"].(uint64); ok {
+		sig.UUID = fmt.Sprintf("%d", guid)
+	}
+	return
+}
+
+// hasZFSUberblock returns whether any slot of the uberblock ring holds an
+// uberblock.
+func hasZFSUberblock(ring []byte) bool {
+	for off := 0; off+8 <= len(ring); off += zfsUberblockSlotSize {
+		// The uberblock is written in the host's byte order
+		if magic := ring[off : off+8]; binary.LittleEndian.Uint64(magic) == zfsUberblockMagic || binary.BigEndian.Uint64(magic) == zfsUberblockMagic {
+			return true
+		}
+	}
+	return false
+}
+
+// parseXDRNVList decodes the top level string and uint64 values of an XDR
+// encoded nvlist, as found in ZFS vdev labels.  Everything else (notably
+// the nested vdev_tree) is skipped.
+func parseXDRNVList(buf []byte) (map[string]interface{}, error) {
+	errMalformed := errors.New("malformed ZFS label nvlist")
+	// 4 byte header (encoding, endianness, 2 reserved) then version and flags
+	if len(buf) < 12 || buf[0] != zfsXDREncoding {
+		return nil, errMalformed
+	}
+	pairs := map[string]interface{}{}
+	off := 12
+	for i := 0; i < zfsMaxNVListEntries; i++ {
+		if off+8 > len(buf) {
+			return nil, errMalformed
+		}
+		encodedSize := int(binary.BigEndian.Uint32(buf[off:]))
+		decodedSize := binary.BigEndian.Uint32(buf[off+4:])
+		if encodedSize == 0 && decodedSize == 0 {
+			return pairs, nil
+		}
+		if encodedSize < 20 || off+encodedSize > len(buf) {
+			return nil, errMalformed
+		}
+		pair := buf[off+8 : off+encodedSize]
+		nameLen := int(binary.BigEndian.Uint32(pair))
+		if 4+nameLen > len(pair) {
+			return nil, errMalformed
+		}
+		name := string(bytes.TrimRight(pair[4:4+nameLen], "\x00"))
+		// Strings are padded to a multiple of 4 bytes
+		p := 4 + (nameLen+3)&^3
+		if p+8 > len(pair) {
+			return nil, errMalformed
+		}
+		dataType := binary.BigEndian.Uint32(pair[p:])
+		data := pair[p+8:]
+		switch dataType {
+		case zfsDataTypeUint64:
+			if len(data) >= 8 {
+				pairs[name] = binary.BigEndian.Uint64(data)
+			}
+		case zfsDataTypeString:
+			if len(data) >= 4 {
+				strLen := int(binary.BigEndian.Uint32(data))
+				if 4+strLen <= len(data) {
+					pairs[name] = string(data[4 : 4+strLen])
+				}
+			}
+		}
+		off += encodedSize
+	}
+	return nil, errMalformed
+}
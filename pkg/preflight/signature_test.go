@@ -0,0 +1,46 @@
+package preflight
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseXDRNVListMalformed(t *testing.T) {
+	malformed := [][]byte{
+		nil,
+		// native rather than XDR encoding
+		{0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+		// pair claiming to be longer than the buffer
+		{1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 1, 0, 0, 0, 1, 0},
+		// no terminating pair
+		{1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+	}
+	for _, buf := range malformed {
+		_, err := parseXDRNVList(buf)
+		assert.NotNil(t, err)
+	}
+}
+
+// The uberblock in use can be in any slot of the ring, not just the first.
+func TestProbeZFSUberblockRing(t *testing.T) {
+	fixture, err := os.ReadFile("./testdata/pool-membership/zfs/dev/sdb1")
+	assert.Nil(t, err)
+	label := make([]byte, zfsUberblockOffset+zfsUberblockRingSize)
+	copy(label, fixture)
+	clear(label[zfsUberblockOffset:])
+	sig, err := probeZFS(bytes.NewReader(label))
+	assert.Nil(t, err)
+	assert.Equal(t, signature{}, sig)
+
+	for _, slot := range []int{5, 31, 127} {
+		clear(label[zfsUberblockOffset:])
+		binary.BigEndian.PutUint64(label[zfsUberblockOffset+slot*zfsUberblockSlotSize:], zfsUberblockMagic)
+		sig, err = probeZFS(bytes.NewReader(label))
+		assert.Nil(t, err)
+		assert.Equal(t, signature{Type: sigZFS, Label: "tank", UUID: "1234567890123456789"}, sig, slot)
+	}
+}
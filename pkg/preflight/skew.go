@@ -0,0 +1,199 @@
+package preflight
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/version"
+)
+
+const (
+	// DefaultMaxVersionSkew is how many minor versions a joining node may
+	// be behind or ahead of the cluster.
+	DefaultMaxVersionSkew = 1
+
+	// clusterVersionPath is where the join server publishes the
+	// cluster's version, as a setting resource
+	clusterVersionPath = "v1/harvester/settings/server-version"
+)
+
+// VersionSkewCheck verifies, when joining an existing cluster, that this
+// installer's version is compatible with the cluster's.  The cluster's
+// version is Options.ClusterVersion if set, or else it's fetched from the
+// join server.  Versions further apart than Options.MaxVersionSkew minor
+// versions, or with different major versions, are fatal.  Joining an
+// older node to a newer cluster works, but is unusual, so it's warned
+// about.  If the cluster's version can't be fetched, that's only warned
+// about, because JoinCheck reports why the server is unreachable, and so
+// is a cluster version which isn't a release.  Development builds of the
+// installer aren't checked.
+type VersionSkewCheck struct {
+	Join             JoinCheck
+	InstallerVersion string
+}
+
+// NewVersionSkewCheck returns a VersionSkewCheck for this installer and
+// the given install configuration.
+func NewVersionSkewCheck(cfg *config.HarvesterConfig) VersionSkewCheck {
+	return VersionSkewCheck{Join: NewJoinCheck(cfg), InstallerVersion: version.HarvesterVersion}
+}
+
+func (c VersionSkewCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "VersionSkew"
+	if c.Join.Mode != config.ModeJoin {
+		return
+	}
+	installer, err := parseSemver(c.InstallerVersion)
+	if err != nil {
+		result.Message = fmt.Sprintf("Skipped: installer version %s is not a release.", c.InstallerVersion)
+		return result, nil
+	}
+
+	clusterVersion := env.Options.ClusterVersion
+	if clusterVersion == "" {
+		if clusterVersion, err = c.clusterVersion(ctx); err != nil {
+			result.Severity = SeverityWarning
+			result.Message = fmt.Sprintf("Unable to get the cluster's version from the join server: %v. "+
+				"Version compatibility was not checked.", redactedError(err))
+			return result, nil
+		}
+	}
+	cluster, err := parseSemver(clusterVersion)
+	if err != nil {
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("The cluster's version %q is not a release version. "+
+			"Version compatibility was not checked.", clusterVersion)
+		return result, nil
+	}
+
+	skew := installer.Minor - cluster.Minor
+	switch {
+	case installer.Major != cluster.Major || skew > env.Options.MaxVersionSkew || -skew > env.Options.MaxVersionSkew:
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("Installer version %s cannot join a cluster running %s. "+
+			"The installer and the cluster may differ by at most %d in their minor version. Please use the installer for %s.",
+			c.InstallerVersion, clusterVersion, env.Options.MaxVersionSkew, clusterVersion)
+	case installer.compare(cluster) < 0:
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("Installer version %s is older than the cluster, which is running %s. "+
+			"Joining an older node is supported, but it is unusual. Consider using the installer for %s.",
+			c.InstallerVersion, clusterVersion, clusterVersion)
+	case installer.compare(cluster) > 0:
+		result.Severity = SeverityInfo
+		result.Message = fmt.Sprintf("Installer version %s is newer than the cluster, which is running %s.",
+			c.InstallerVersion, clusterVersion)
+	default:
+		result.Message = fmt.Sprintf("Installer version %s matches the cluster.", c.InstallerVersion)
+	}
+	return
+}
+
+// clusterVersion fetches the cluster's version from the join server.
+// Whether the server's certificate is trusted is JoinCheck's concern, so
+// an untrusted one doesn't stop this.
+func (c VersionSkewCheck) clusterVersion(ctx context.Context) (string, error) {
+	server, reason := parseServerURL(c.Join.ServerURL)
+	if reason != "" {
+		return "", fmt.Errorf("the server URL is not valid: %s", reason)
+	}
+	u := server.JoinPath(clusterVersionPath)
+	proxy := c.Join.Proxy.ProxyFunc()
+	roots, _ := rootCAs(c.Join.CACerts)
+	resp, err := c.Join.get(ctx, u, &tls.Config{RootCAs: roots}, proxy)
+	if certificateError(err) != nil {
+		resp, err = c.Join.get(ctx, u, &tls.Config{InsecureSkipVerify: true}, proxy)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET /%s returned %s", clusterVersionPath, resp.Status)
+	}
+
+	var setting struct {
+		Value   string `json:"value"`
+		Default string `json:"default"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&setting); err != nil {
+		return "", fmt.Errorf("GET /%s returned an invalid setting: %w", clusterVersionPath, err)
+	}
+	if setting.Value != "" {
+		return setting.Value, nil
+	}
+	if setting.Default != "" {
+		return setting.Default, nil
+	}
+	return "", errors.New("the cluster did not report its version")
+}
+
+// A semver is a semantic version.  Build metadata is ignored.
+type semver struct {
+	Major, Minor, Patch int
+	Prerelease          string
+}
+
+var semverRegexp = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+func parseSemver(s string) (v semver, err error) {
+	match := semverRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return v, parseErrorf("unable to parse version %q", s)
+	}
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	v.Patch, _ = strconv.Atoi(match[3])
+	v.Prerelease = match[4]
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 as v has lower, equal or higher precedence
+// than other, following the semantic versioning rules.
+func (v semver) compare(other semver) int {
+	if c := cmp.Compare(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(other.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		m, errM := strconv.Atoi(a[i])
+		n, errN := strconv.Atoi(b[i])
+		switch {
+		case errM == nil && errN == nil:
+			return cmp.Compare(m, n)
+		case errM == nil:
+			// Numeric identifiers have lower precedence
+			return -1
+		case errN == nil:
+			return 1
+		}
+		return strings.Compare(a[i], b[i])
+	}
+	return cmp.Compare(len(a), len(b))
+}
@@ -0,0 +1,146 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestVersionSkewCheck(t *testing.T) {
+	cluster := newTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/harvester/settings/server-version" {
+			fmt.Fprint(w, `{"id":"server-version","type":"harvesterhci.io.setting","default":"","value":"v1.4.1"}`)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer cluster.Close()
+	notCluster := newTLSServer(http.NotFoundHandler())
+	defer notCluster.Close()
+	closed := newTLSServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name      string
+		installer string
+		server    string
+		expected  string
+		severity  Severity
+		message   string
+	}{
+		{
+			name:      "equal",
+			installer: "v1.4.1",
+			server:    cluster.URL,
+			message:   "Installer version v1.4.1 matches the cluster.",
+		},
+		{
+			name:      "older within skew",
+			installer: "v1.3.2",
+			server:    cluster.URL,
+			severity:  SeverityWarning,
+			message: "Installer version v1.3.2 is older than the cluster, which is running v1.4.1. " +
+				"Joining an older node is supported, but it is unusual. Consider using the installer for v1.4.1.",
+		},
+		{
+			name:      "older patch",
+			installer: "v1.4.1-rc2",
+			server:    cluster.URL,
+			severity:  SeverityWarning,
+			message: "Installer version v1.4.1-rc2 is older than the cluster, which is running v1.4.1. " +
+				"Joining an older node is supported, but it is unusual. Consider using the installer for v1.4.1.",
+		},
+		{
+			name:      "newer within skew",
+			installer: "v1.5.0",
+			server:    cluster.URL,
+			severity:  SeverityInfo,
+			message:   "Installer version v1.5.0 is newer than the cluster, which is running v1.4.1.",
+		},
+		{
+			name:      "beyond skew",
+			installer: "v1.2.3",
+			server:    cluster.URL,
+			severity:  SeverityFatal,
+			message: "Installer version v1.2.3 cannot join a cluster running v1.4.1. " +
+				"The installer and the cluster may differ by at most 1 in their minor version. Please use the installer for v1.4.1.",
+		},
+		{
+			name:      "different major",
+			installer: "v2.4.1",
+			expected:  "v1.4.1",
+			severity:  SeverityFatal,
+			message: "Installer version v2.4.1 cannot join a cluster running v1.4.1. " +
+				"The installer and the cluster may differ by at most 1 in their minor version. Please use the installer for v1.4.1.",
+		},
+		{
+			name:      "expected version",
+			installer: "v1.4.0",
+			server:    closed.URL,
+			expected:  "v1.4.0",
+			message:   "Installer version v1.4.0 matches the cluster.",
+		},
+		{
+			name:      "unreachable",
+			installer: "v1.4.1",
+			server:    closed.URL,
+			severity:  SeverityWarning,
+			message: fmt.Sprintf("Unable to get the cluster's version from the join server: dial tcp %s: connect: connection refused. "+
+				"Version compatibility was not checked.", closed.Listener.Addr()),
+		},
+		{
+			name:      "not a cluster",
+			installer: "v1.4.1",
+			server:    notCluster.URL,
+			severity:  SeverityWarning,
+			message: "Unable to get the cluster's version from the join server: GET /v1/harvester/settings/server-version returned 404 Not Found. " +
+				"Version compatibility was not checked.",
+		},
+		{
+			name:      "unparseable cluster version",
+			installer: "v1.4.1",
+			expected:  "master-head",
+			severity:  SeverityWarning,
+			message:   `The cluster's version "master-head" is not a release version. Version compatibility was not checked.`,
+		},
+		{
+			name:      "development build",
+			installer: "dev",
+			server:    cluster.URL,
+			message:   "Skipped: installer version dev is not a release.",
+		},
+	}
+
+	for _, test := range tests {
+		check := VersionSkewCheck{
+			Join:             JoinCheck{Mode: config.ModeJoin, ServerURL: test.server, Token: "token"},
+			InstallerVersion: test.installer,
+		}
+		env := &Env{Options: Options{ClusterVersion: test.expected, MaxVersionSkew: DefaultMaxVersionSkew}}
+		result, err := check.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "VersionSkew", Severity: test.severity, Message: test.message}, result, test.name)
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"v1.0.0-alpha", "v1.0.0-alpha.1", "v1.0.0-alpha.beta", "v1.0.0-beta", "v1.0.0-beta.2",
+		"v1.0.0-beta.11", "v1.0.0-rc.1", "v1.0.0", "1.0.1+build.5", "v1.2.0", "v2.0.0"}
+	for i := range ordered {
+		a, err := parseSemver(ordered[i])
+		assert.Nil(t, err, ordered[i])
+		assert.Equal(t, 0, a.compare(a), ordered[i])
+		if i > 0 {
+			b, _ := parseSemver(ordered[i-1])
+			assert.Equal(t, 1, a.compare(b), ordered[i])
+			assert.Equal(t, -1, b.compare(a), ordered[i])
+		}
+	}
+	_, err := parseSemver("v1.4")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,282 @@
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const sshKeyTimeout = 10 * time.Second
+
+// sshKeyProviders are the shorthands for fetching a user's keys from a
+// code hosting site, e.g. github:alice, which the installed system
+// understands.
+var sshKeyProviders = map[string]string{
+	"github": "https://github.com/%s.keys",
+	"gitlab": "https://gitlab.com/%s.keys",
+}
+
+// knownSSHKeyTypes are the key types which can be parsed, so a key of
+// one of them which can't be is malformed rather than unsupported.
+var knownSSHKeyTypes = []string{
+	ssh.KeyAlgoRSA, ssh.KeyAlgoDSA, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoED25519, ssh.KeyAlgoSKED25519,
+}
+
+// SSHKeyCheck verifies that the SSH authorized keys in the install
+// configuration are usable, since otherwise the administrator is locked
+// out of the node after installation.  Keys are given inline, or as URLs
+// or github:user style shorthands for keys to fetch.  Each URL is fetched
+// with the configured proxy, and must yield at least one valid key, or
+// it's fatal, as is an invalid inline key.  Keys of types which sshd no
+// longer accepts are warned about.  When the host is air-gapped, the
+// shorthands and URLs on the internet are fatal, because they can't be
+// fetched, but other URLs are still tried in case they're on site.
+type SSHKeyCheck struct {
+	// Keys are the entries of os.ssh_authorized_keys.
+	Keys    []string
+	Proxy   httpproxy.Config
+	Timeout time.Duration
+}
+
+// NewSSHKeyCheck returns an SSHKeyCheck for the given install
+// configuration.
+func NewSSHKeyCheck(cfg *config.HarvesterConfig) SSHKeyCheck {
+	return SSHKeyCheck{Keys: cfg.SSHAuthorizedKeys, Proxy: proxyFromConfig(cfg), Timeout: sshKeyTimeout}
+}
+
+// sshKeyCount tallies the lines of an authorized_keys source.
+type sshKeyCount struct {
+	valid            int
+	unsupported      int
+	unsupportedTypes []string
+	invalid          int
+}
+
+func (c *sshKeyCount) add(other sshKeyCount) {
+	c.valid += other.valid
+	c.unsupported += other.unsupported
+	for _, keyType := range other.unsupportedTypes {
+		if !slices.Contains(c.unsupportedTypes, keyType) {
+			c.unsupportedTypes = append(c.unsupportedTypes, keyType)
+		}
+	}
+	c.invalid += other.invalid
+}
+
+func (c sshKeyCount) String() string {
+	s := "no valid keys"
+	if c.valid > 0 {
+		s = pluralize(c.valid, "valid key", "valid keys")
+	}
+	if c.unsupported > 0 {
+		s += fmt.Sprintf(", %s of unsupported type %s",
+			pluralize(c.unsupported, "key", "keys"), strings.Join(c.unsupportedTypes, ", "))
+	}
+	if c.invalid > 0 {
+		s += fmt.Sprintf(", %s", pluralize(c.invalid, "invalid line", "invalid lines"))
+	}
+	return s
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+func (c SSHKeyCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "SSHKey"
+	if len(c.Keys) == 0 {
+		result.Message = "Skipped: no SSH keys are configured."
+		return
+	}
+
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+	var inline sshKeyCount
+	inlineKeys := 0
+	for i, key := range c.Keys {
+		key = strings.TrimSpace(key)
+		source, shorthand := sshKeySource(key)
+		if source == "" {
+			count := countAuthorizedKeys([]byte(key))
+			if count.invalid > 0 {
+				finding(SeverityFatal, "os.ssh_authorized_keys[%d] is not a valid authorized key.", i)
+			}
+			inline.add(count)
+			inlineKeys++
+			continue
+		}
+		if env.Options.AirGapped && (shorthand || sourceOnInternet(source)) {
+			finding(SeverityFatal, "%s: no usable keys, because %s cannot be fetched when the host is air-gapped.", key, source)
+			result.AirGapped = true
+			continue
+		}
+
+		count, reason, err := c.fetch(ctx, source)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		switch {
+		case err != nil && env.Options.AirGapped:
+			finding(SeverityFatal, "%s: no usable keys: %v. The host is air-gapped, so it must be on site.", key, redactedError(err))
+			result.AirGapped = true
+		case err != nil:
+			finding(SeverityFatal, "%s: no usable keys: %v.", key, redactedError(err))
+		case reason != "":
+			finding(SeverityFatal, "%s: no usable keys: %s.", key, reason)
+		default:
+			finding(sshKeySeverity(count), "%s: %s.", key, count)
+		}
+	}
+	if inlineKeys > 0 {
+		// Invalid inline keys have been reported individually
+		inline.invalid = 0
+		result.Severity = max(result.Severity, sshKeySeverity(inline))
+		findings = append([]string{fmt.Sprintf("Inline: %s.", inline)}, findings...)
+	}
+	result.Message = strings.Join(findings, " ")
+	return
+}
+
+// sshKeySeverity returns how severe the problems with a source are.
+func sshKeySeverity(count sshKeyCount) Severity {
+	switch {
+	case count.valid == 0:
+		return SeverityFatal
+	case count.unsupported > 0 || count.invalid > 0:
+		return SeverityWarning
+	}
+	return SeverityOK
+}
+
+// sourceOnInternet returns whether the URL source is on the internet.
+func sourceOnInternet(source string) bool {
+	u, err := url.Parse(source)
+	return err == nil && onInternet(u.Hostname())
+}
+
+// sshKeySource returns the URL to fetch keys from, if key refers to keys
+// elsewhere rather than being one, and whether it was a shorthand.
+func sshKeySource(key string) (source string, shorthand bool) {
+	if strings.HasPrefix(key, "http://") || strings.HasPrefix(key, "https://") {
+		return key, false
+	}
+	provider, user, ok := strings.Cut(key, ":")
+	if format, known := sshKeyProviders[provider]; ok && known && user != "" && !strings.ContainsAny(user, " /") {
+		return fmt.Sprintf(format, url.PathEscape(user)), true
+	}
+	return "", false
+}
+
+// fetch downloads and counts the keys at source.  If the response is
+// clearly not an authorized_keys file, the reason is returned instead.
+func (c SSHKeyCheck) fetch(ctx context.Context, source string) (count sshKeyCount, reason string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return count, "", err
+	}
+	proxy := c.Proxy.ProxyFunc()
+	client := http.Client{Transport: &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		},
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return count, "", fmt.Errorf("timed out after %s", c.Timeout)
+		}
+		return count, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return count, fmt.Sprintf("the server returned %s", resp.Status), nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return count, "", fmt.Errorf("timed out after %s", c.Timeout)
+	} else if err != nil {
+		return count, "", err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return count, "the server returned an HTML page, not authorized keys", nil
+	}
+	return countAuthorizedKeys(data), "", nil
+}
+
+// countAuthorizedKeys counts the keys in an authorized_keys file.  DSA
+// keys are counted as unsupported, because sshd no longer accepts them.
+func countAuthorizedKeys(data []byte) (count sshKeyCount) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		var keyType string
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		switch {
+		case err == nil && key.Type() != ssh.KeyAlgoDSA:
+			count.valid++
+			continue
+		case err == nil:
+			keyType = key.Type()
+		default:
+			keyType = unknownKeyType(line)
+		}
+		if keyType == "" {
+			count.invalid++
+			continue
+		}
+		count.unsupported++
+		if !slices.Contains(count.unsupportedTypes, keyType) {
+			count.unsupportedTypes = append(count.unsupportedTypes, keyType)
+		}
+	}
+	return
+}
+
+// unknownKeyType returns the type of a well-formed authorized_keys line
+// whose key type isn't known, or "" if it's not well-formed or the type
+// is known.  The key itself begins with its type, so that can be checked
+// without knowing how to parse the key.
+func unknownKeyType(line []byte) string {
+	fields := strings.Fields(string(line))
+	for i := 0; i+1 < len(fields); i++ {
+		blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil || len(blob) < 4 {
+			continue
+		}
+		n := binary.BigEndian.Uint32(blob)
+		if uint64(n) <= uint64(len(blob)-4) && string(blob[4:4+n]) == fields[i] &&
+			!slices.Contains(knownSSHKeyTypes, strings.TrimSuffix(fields[i], "-cert-v01@openssh.com")) {
+			return fields[i]
+		}
+	}
+	return ""
+}
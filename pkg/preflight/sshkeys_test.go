@@ -0,0 +1,173 @@
+package preflight
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewSSHKeyCheck(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "")
+	cfg := config.NewHarvesterConfig()
+	cfg.SSHAuthorizedKeys = []string{"github:alice"}
+	cfg.OS.Environment = map[string]string{"http_proxy": "http://proxy.example.com:3128"}
+	check := NewSSHKeyCheck(cfg)
+	assert.Equal(t, []string{"github:alice"}, check.Keys)
+	assert.Equal(t, "http://proxy.example.com:3128", check.Proxy.HTTPProxy)
+	assert.Equal(t, sshKeyTimeout, check.Timeout)
+}
+
+func TestSSHKeyCheck(t *testing.T) {
+	readKeys := func(name string) string {
+		data, err := os.ReadFile("./testdata/ssh-keys/" + name)
+		assert.Nil(t, err)
+		return string(data)
+	}
+	aliceKeys := readKeys("alice.keys")
+	aliceKey := strings.SplitN(aliceKeys, "\n", 2)[0]
+
+	mux := http.NewServeMux()
+	for _, name := range []string{"alice.keys", "mixed.keys", "legacy.keys"} {
+		keys := readKeys(name)
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(keys))
+		})
+	}
+	errorPage := readKeys("error.html")
+	mux.HandleFunc("/portal.keys", func(w http.ResponseWriter, _ *http.Request) {
+		// A captive portal, which answers everything with a page
+		w.Write([]byte(errorPage))
+	})
+	mux.HandleFunc("/missing.keys", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(errorPage))
+	})
+	mux.HandleFunc("/slow.keys", func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		keys      []string
+		proxy     string
+		airGapped bool
+		severity  Severity
+		message   string
+	}{
+		{
+			name:    "none",
+			message: "Skipped: no SSH keys are configured.",
+		},
+		{
+			name:    "url",
+			keys:    []string{server.URL + "/alice.keys"},
+			message: server.URL + "/alice.keys: 2 valid keys.",
+		},
+		{
+			name:    "proxy",
+			keys:    []string{"http://keys.example.com/alice.keys"},
+			proxy:   server.URL,
+			message: "http://keys.example.com/alice.keys: 2 valid keys.",
+		},
+		{
+			name:     "unsupported types",
+			keys:     []string{server.URL + "/mixed.keys"},
+			severity: SeverityWarning,
+			message: server.URL + "/mixed.keys: 1 valid key, 2 keys of unsupported type ssh-dss, ssh-xmss@openssh.com, " +
+				"1 invalid line.",
+		},
+		{
+			name:     "only unsupported",
+			keys:     []string{server.URL + "/legacy.keys"},
+			severity: SeverityFatal,
+			message:  server.URL + "/legacy.keys: no valid keys, 1 key of unsupported type ssh-dss.",
+		},
+		{
+			name:     "html page",
+			keys:     []string{server.URL + "/portal.keys"},
+			severity: SeverityFatal,
+			message:  server.URL + "/portal.keys: no usable keys: the server returned an HTML page, not authorized keys.",
+		},
+		{
+			name:     "not found",
+			keys:     []string{server.URL + "/missing.keys"},
+			severity: SeverityFatal,
+			message:  server.URL + "/missing.keys: no usable keys: the server returned 404 Not Found.",
+		},
+		{
+			name:     "timeout",
+			keys:     []string{server.URL + "/slow.keys"},
+			severity: SeverityFatal,
+			message:  server.URL + "/slow.keys: no usable keys: timed out after 100ms.",
+		},
+		{
+			name:      "air-gapped",
+			keys:      []string{"github:alice", server.URL + "/alice.keys"},
+			airGapped: true,
+			severity:  SeverityFatal,
+			message: "github:alice: no usable keys, because https://github.com/alice.keys cannot be fetched when the host is air-gapped. " +
+				server.URL + "/alice.keys: 2 valid keys.",
+		},
+		{
+			name:    "inline",
+			keys:    []string{aliceKeys, strings.SplitN(readKeys("mixed.keys"), "\n", 2)[0]},
+			message: "Inline: 3 valid keys.",
+		},
+		{
+			name:     "inline invalid",
+			keys:     []string{aliceKey, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5", readKeys("legacy.keys")},
+			severity: SeverityFatal,
+			message: "Inline: 1 valid key, 1 key of unsupported type ssh-dss. " +
+				"os.ssh_authorized_keys[1] is not a valid authorized key.",
+		},
+		{
+			name:     "inline unsupported",
+			keys:     []string{readKeys("legacy.keys")},
+			severity: SeverityFatal,
+			message:  "Inline: no valid keys, 1 key of unsupported type ssh-dss.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := SSHKeyCheck{Keys: tt.keys, Proxy: httpproxy.Config{HTTPProxy: tt.proxy}, Timeout: 100 * time.Millisecond}
+			env := &Env{Options: Options{AirGapped: tt.airGapped}}
+			result, err := check.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			assert.Equal(t, "SSHKey", result.Name)
+			assert.Equal(t, tt.severity, result.Severity)
+			assert.Equal(t, tt.message, result.Message)
+		})
+	}
+}
+
+func TestSSHKeySource(t *testing.T) {
+	tests := []struct {
+		key       string
+		source    string
+		shorthand bool
+	}{
+		{"https://github.com/alice.keys", "https://github.com/alice.keys", false},
+		{"github:alice", "https://github.com/alice.keys", true},
+		{"gitlab:bob", "https://gitlab.com/bob.keys", true},
+		{"bitbucket:carol", "", false},
+		{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHl/Q1ldVRKZ3go5U2IDhqbhwX4H1B6ytt7ohM52kPAj", "", false},
+	}
+	for _, tt := range tests {
+		source, shorthand := sshKeySource(tt.key)
+		assert.Equal(t, tt.source, source, tt.key)
+		assert.Equal(t, tt.shorthand, shorthand, tt.key)
+	}
+}
@@ -0,0 +1,351 @@
+package preflight
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	// wickedRoutesFile holds routes for any interface, and the
+	// ifroute-<interface> files those for one interface
+	wickedRoutesFile    = "/etc/sysconfig/network/routes"
+	wickedIfroutePrefix = "/etc/sysconfig/network/ifroute-"
+	wickedIfcfgPrefix   = "/etc/sysconfig/network/ifcfg-"
+)
+
+// StaticRouteCheck verifies the static routes the install configuration
+// adds, by writing wicked route files with os.write_files (see routes(5)).
+// Wicked silently ignores routes it can't parse, and routes via gateways
+// which aren't on any of the node's subnets, so mistakes otherwise only
+// show up as unreachable networks after installation.  Each route must
+// parse, its gateway must be on the subnet of its interface (or of any
+// interface, if it doesn't name one), and routes without a gateway must
+// name an interface the node will have.  Routes to the same destination
+// as another, or as a subnet the node is on, are warned about.  The
+// interfaces are the management interface and those configured by
+// ifcfg files, also in os.write_files; those using DHCP have no known
+// subnet, so gateways on them can't be verified.  Each finding names the
+// route by its place in the configuration.
+type StaticRouteCheck struct {
+	Files   []config.File
+	Network config.Network
+}
+
+// NewStaticRouteCheck returns a StaticRouteCheck for the given install
+// configuration.
+func NewStaticRouteCheck(cfg *config.HarvesterConfig) StaticRouteCheck {
+	return StaticRouteCheck{
+		Files:   cfg.OS.WriteFiles,
+		Network: cfg.ManagementInterface,
+	}
+}
+
+// A staticRoute is a line of a wicked route file.
+type staticRoute struct {
+	// source is where the route is configured, for messages
+	source      string
+	destination *net.IPNet
+	gateway     net.IP
+	iface       string
+}
+
+// A nodeInterface is an interface the installed node will configure.  Its
+// subnet is nil if it uses DHCP.
+type nodeInterface struct {
+	name   string
+	subnet *net.IPNet
+}
+
+func (c StaticRouteCheck) Evaluate(_ context.Context, _ *Env) (result Result, err error) {
+	result.Name = "StaticRoute"
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+	defer func() { result.Message = strings.Join(findings, " ") }()
+
+	ifaces := c.interfaces()
+	var routes []staticRoute
+	mgmt := ifaces[0]
+	if mgmt.subnet != nil {
+		if gateway := net.ParseIP(c.Network.Gateway); gateway != nil {
+			_, anywhere, _ := net.ParseCIDR("0.0.0.0/0")
+			routes = append(routes, staticRoute{
+				source:      "the default route (install.management_interface.gateway)",
+				destination: anywhere,
+				gateway:     gateway,
+				iface:       mgmt.name,
+			})
+		}
+	}
+	configured := len(routes)
+
+	for i, file := range c.Files {
+		var defaultIface string
+		switch {
+		case file.Path == wickedRoutesFile:
+		case strings.HasPrefix(file.Path, wickedIfroutePrefix):
+			defaultIface = strings.TrimPrefix(file.Path, wickedIfroutePrefix)
+			if defaultIface == mgmt.name {
+				finding(SeverityWarning, "os.write_files[%d] (%s) is overwritten or removed by the installer on every boot, along with its routes; "+
+					"add them to %s instead.", i, file.Path, wickedRoutesFile)
+				continue
+			}
+		default:
+			continue
+		}
+		content, err := writeFileContent(file)
+		if err != nil {
+			finding(SeverityWarning, "os.write_files[%d] (%s) cannot be decoded: %v.", i, file.Path, err)
+			continue
+		}
+		for n, line := range strings.Split(content, "\n") {
+			if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			source := fmt.Sprintf("os.write_files[%d] (%s) line %d", i, file.Path, n+1)
+			route, problem := parseStaticRoute(line, defaultIface)
+			if problem != "" {
+				finding(SeverityWarning, "%s: %s.", source, problem)
+				continue
+			}
+			route.source = source
+			if problem := route.validate(ifaces); problem != "" {
+				finding(SeverityWarning, "%s: %s.", source, problem)
+				continue
+			}
+			if problem := route.conflicts(routes, ifaces); problem != "" {
+				finding(SeverityWarning, "%s: %s.", source, problem)
+			}
+			routes = append(routes, route)
+		}
+	}
+
+	switch added := len(routes) - configured; {
+	case added == 0 && len(findings) == 0:
+		findings = append(findings, "Skipped: the install configuration does not add any static routes.")
+	case len(findings) == 0 && added == 1:
+		finding(SeverityOK, "The static route is valid.")
+	case len(findings) == 0:
+		finding(SeverityOK, "All %d static routes are valid.", added)
+	}
+	return
+}
+
+// interfaces returns the interfaces the node will configure, starting
+// with the management interface.
+func (c StaticRouteCheck) interfaces() []nodeInterface {
+	mgmt := nodeInterface{name: config.MgmtInterfaceName}
+	if c.Network.VlanID >= 2 && c.Network.VlanID <= 4094 {
+		mgmt.name = fmt.Sprintf("%s.%d", mgmt.name, c.Network.VlanID)
+	}
+	if c.Network.Method == config.NetworkMethodStatic {
+		ip, mask := net.ParseIP(c.Network.IP), net.ParseIP(c.Network.SubnetMask).To4()
+		if ip != nil && mask != nil {
+			mgmt.subnet = &net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+		}
+	}
+	ifaces := []nodeInterface{mgmt}
+	for _, file := range c.Files {
+		name, ok := strings.CutPrefix(file.Path, wickedIfcfgPrefix)
+		if !ok || name == mgmt.name {
+			continue
+		}
+		content, err := writeFileContent(file)
+		if err != nil {
+			continue
+		}
+		ifaces = append(ifaces, nodeInterface{name: name, subnet: ifcfgSubnet(content)})
+	}
+	return ifaces
+}
+
+// ifcfgSubnet returns the subnet of the static address in ifcfg(5)
+// content, or nil if there isn't one.
+func ifcfgSubnet(content string) *net.IPNet {
+	vars := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok {
+			vars[name] = strings.Trim(value, `'"`)
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(vars["BOOTPROTO"]), "dhcp") {
+		return nil
+	}
+	address := vars["IPADDR"]
+	if !strings.Contains(address, "/") {
+		switch {
+		case vars["PREFIXLEN"] != "":
+			address += "/" + vars["PREFIXLEN"]
+		case vars["NETMASK"] != "":
+			mask := net.ParseIP(vars["NETMASK"]).To4()
+			if mask == nil {
+				return nil
+			}
+			ones, _ := net.IPMask(mask).Size()
+			address += "/" + strconv.Itoa(ones)
+		}
+	}
+	ip, subnet, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil
+	}
+	subnet.IP = ip.Mask(subnet.Mask)
+	return subnet
+}
+
+// parseStaticRoute parses a line of a wicked route file, the columns of
+// which are the destination, gateway, netmask and interface, the latter
+// three being "-" when not given.  defaultIface is the interface of an
+// ifroute file.  If the line can't be parsed, the reason is returned
+// instead.
+func parseStaticRoute(line, defaultIface string) (route staticRoute, problem string) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return route, fmt.Sprintf("%q is not a route; expected DESTINATION GATEWAY [NETMASK [INTERFACE]]", line)
+	}
+	column := func(i int) string {
+		if i < len(fields) && fields[i] != "-" {
+			return fields[i]
+		}
+		return ""
+	}
+
+	destination, netmask := fields[0], column(2)
+	switch {
+	case destination == "default":
+		destination = "0.0.0.0/0"
+	case !strings.Contains(destination, "/") && netmask != "":
+		if ones, err := strconv.Atoi(netmask); err == nil {
+			destination += "/" + strconv.Itoa(ones)
+		} else if mask := net.ParseIP(netmask).To4(); mask != nil {
+			ones, bits := net.IPMask(mask).Size()
+			if bits == 0 {
+				return route, fmt.Sprintf("netmask %s is not valid", netmask)
+			}
+			destination += "/" + strconv.Itoa(ones)
+		} else {
+			return route, fmt.Sprintf("netmask %s is not valid", netmask)
+		}
+	case !strings.Contains(destination, "/"):
+		// A host route
+		if ip := net.ParseIP(destination); ip != nil && ip.To4() == nil {
+			destination += "/128"
+		} else {
+			destination += "/32"
+		}
+	}
+	ip, subnet, err := net.ParseCIDR(destination)
+	if err != nil {
+		return route, fmt.Sprintf("destination %s is not a CIDR, an address with a netmask, or default", fields[0])
+	}
+	if !ip.Equal(subnet.IP) {
+		return route, fmt.Sprintf("destination %s has host bits set; it should be %s", fields[0], subnet)
+	}
+	route.destination = subnet
+
+	if gateway := column(1); gateway != "" {
+		if route.gateway = net.ParseIP(gateway); route.gateway == nil {
+			return route, fmt.Sprintf("gateway %s is not an IP address", gateway)
+		}
+		if route.gateway.IsUnspecified() {
+			route.gateway = nil
+		}
+	}
+	route.iface = column(3)
+	if route.iface == "" {
+		route.iface = defaultIface
+	}
+	return route, ""
+}
+
+// validate returns why the route won't work on a node with ifaces, or ""
+// if it will.
+func (r staticRoute) validate(ifaces []nodeInterface) string {
+	var names []string
+	var iface *nodeInterface
+	for i := range ifaces {
+		names = append(names, ifaces[i].name)
+		if ifaces[i].name == r.iface {
+			iface = &ifaces[i]
+		}
+	}
+	if r.iface != "" && iface == nil {
+		return fmt.Sprintf("interface %s is not one of the node's configured interfaces (%s)", r.iface, strings.Join(names, ", "))
+	}
+	if r.gateway == nil {
+		if r.iface == "" {
+			return fmt.Sprintf("the route to %s has neither a gateway nor an interface", r.destination)
+		}
+		// Link scoped, so the interface is all it needs
+		return ""
+	}
+
+	candidates := ifaces
+	if iface != nil {
+		candidates = []nodeInterface{*iface}
+	}
+	var subnets []string
+	for _, candidate := range candidates {
+		if candidate.subnet == nil {
+			// DHCP, so it may well be on the subnet
+			return ""
+		}
+		if candidate.subnet.Contains(r.gateway) {
+			return ""
+		}
+		subnets = append(subnets, fmt.Sprintf("%s on %s", candidate.subnet, candidate.name))
+	}
+	return fmt.Sprintf("gateway %s is not on the node's subnets (%s), so the route to %s will not work",
+		r.gateway, strings.Join(subnets, ", "), r.destination)
+}
+
+// conflicts returns how the route clashes with the routes before it, or
+// with the subnets of ifaces, or "" if it doesn't.
+func (r staticRoute) conflicts(routes []staticRoute, ifaces []nodeInterface) string {
+	for _, other := range routes {
+		if other.destination.String() != r.destination.String() {
+			continue
+		}
+		if other.gateway.Equal(r.gateway) && other.iface == r.iface {
+			return fmt.Sprintf("the route to %s duplicates %s", r.destination, other.source)
+		}
+		return fmt.Sprintf("the route to %s conflicts with %s, which is %s", r.destination, other.source, other.via())
+	}
+	for _, iface := range ifaces {
+		if iface.subnet != nil && iface.subnet.String() == r.destination.String() {
+			return fmt.Sprintf("the route to %s conflicts with the subnet of %s", r.destination, iface.name)
+		}
+	}
+	return ""
+}
+
+// via describes where the route sends traffic.
+func (r staticRoute) via() string {
+	switch {
+	case r.gateway != nil && r.iface != "":
+		return fmt.Sprintf("via %s on %s", r.gateway, r.iface)
+	case r.gateway != nil:
+		return fmt.Sprintf("via %s", r.gateway)
+	}
+	return "directly on " + r.iface
+}
+
+// writeFileContent returns the decoded content of a file written by the
+// install configuration.
+func writeFileContent(file config.File) (string, error) {
+	if slices.Contains([]string{"base64", "b64"}, file.Encoding) {
+		decoded, err := base64.StdEncoding.DecodeString(file.Content)
+		return string(decoded), err
+	}
+	return file.Content, nil
+}
@@ -0,0 +1,143 @@
+package preflight
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewStaticRouteCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.OS.WriteFiles = []config.File{{Path: wickedRoutesFile, Content: "default 10.0.0.1 - -\n"}}
+	cfg.ManagementInterface.Method = config.NetworkMethodDHCP
+	assert.Equal(t, StaticRouteCheck{Files: cfg.OS.WriteFiles, Network: cfg.ManagementInterface}, NewStaticRouteCheck(cfg))
+}
+
+func TestStaticRouteCheck(t *testing.T) {
+	static := config.Network{
+		Method:     config.NetworkMethodStatic,
+		IP:         "192.168.10.20",
+		SubnetMask: "255.255.255.0",
+		Gateway:    "192.168.10.1",
+	}
+	dhcp := config.Network{Method: config.NetworkMethodDHCP}
+	storage := config.File{
+		Path:    "/etc/sysconfig/network/ifcfg-eth2",
+		Content: "STARTMODE='auto'\nBOOTPROTO='static'\nIPADDR='172.16.0.20/24'\n",
+	}
+	routes := func(content string) config.File {
+		return config.File{Path: wickedRoutesFile, Content: content}
+	}
+
+	tests := []struct {
+		name     string
+		network  config.Network
+		files    []config.File
+		severity Severity
+		message  string
+	}{
+		{
+			name:    "none",
+			network: static,
+			files:   []config.File{{Path: "/etc/motd", Content: "hello"}},
+			message: "Skipped: the install configuration does not add any static routes.",
+		},
+		{
+			name:    "valid",
+			network: static,
+			files: []config.File{
+				storage,
+				routes("# Backups\n10.20.0.0/16 192.168.10.254 - -\n10.30.0.0 192.168.10.254 255.255.0.0 mgmt-br\n"),
+				{Path: "/etc/sysconfig/network/ifroute-eth2", Content: "10.40.0.0/16 172.16.0.1\n"},
+			},
+			message: "All 3 static routes are valid.",
+		},
+		{
+			name:    "valid, base64",
+			network: static,
+			files: []config.File{{
+				Path:     wickedRoutesFile,
+				Encoding: "b64",
+				Content:  base64.StdEncoding.EncodeToString([]byte("10.20.0.0/16 192.168.10.254\n")),
+			}},
+			message: "The static route is valid.",
+		},
+		{
+			name:     "off-subnet gateway",
+			network:  static,
+			files:    []config.File{storage, routes("10.20.0.0/16 192.168.11.254\n10.30.0.0/16 172.16.0.1 - mgmt-br\n")},
+			severity: SeverityWarning,
+			message: "os.write_files[1] (/etc/sysconfig/network/routes) line 1: gateway 192.168.11.254 is not on the node's subnets " +
+				"(192.168.10.0/24 on mgmt-br, 172.16.0.0/24 on eth2), so the route to 10.20.0.0/16 will not work. " +
+				"os.write_files[1] (/etc/sysconfig/network/routes) line 2: gateway 172.16.0.1 is not on the node's subnets " +
+				"(192.168.10.0/24 on mgmt-br), so the route to 10.30.0.0/16 will not work.",
+		},
+		{
+			name:    "gateway on a DHCP network",
+			network: dhcp,
+			files:   []config.File{routes("10.20.0.0/16 192.168.11.254\n")},
+			message: "The static route is valid.",
+		},
+		{
+			name:     "invalid",
+			network:  static,
+			files:    []config.File{routes("10.20.0.0/33 192.168.10.254\n10.20.0.0/16 gw\n10.20.0.1/16 192.168.10.254\nnonsense\n")},
+			severity: SeverityWarning,
+			message: "os.write_files[0] (/etc/sysconfig/network/routes) line 1: destination 10.20.0.0/33 is not a CIDR, an address with a netmask, or default. " +
+				"os.write_files[0] (/etc/sysconfig/network/routes) line 2: gateway gw is not an IP address. " +
+				"os.write_files[0] (/etc/sysconfig/network/routes) line 3: destination 10.20.0.1/16 has host bits set; it should be 10.20.0.0/16. " +
+				`os.write_files[0] (/etc/sysconfig/network/routes) line 4: "nonsense" is not a route; expected DESTINATION GATEWAY [NETMASK [INTERFACE]].`,
+		},
+		{
+			name:     "overlapping destinations",
+			network:  static,
+			files:    []config.File{routes("10.20.0.0/16 192.168.10.254\n10.20.0.0 192.168.10.253 16\n10.20.0.0/16 192.168.10.254\ndefault 192.168.10.2\n192.168.10.0/24 192.168.10.3\n")},
+			severity: SeverityWarning,
+			message: "os.write_files[0] (/etc/sysconfig/network/routes) line 2: the route to 10.20.0.0/16 conflicts with " +
+				"os.write_files[0] (/etc/sysconfig/network/routes) line 1, which is via 192.168.10.254. " +
+				"os.write_files[0] (/etc/sysconfig/network/routes) line 3: the route to 10.20.0.0/16 duplicates " +
+				"os.write_files[0] (/etc/sysconfig/network/routes) line 1. " +
+				"os.write_files[0] (/etc/sysconfig/network/routes) line 4: the route to 0.0.0.0/0 conflicts with " +
+				"the default route (install.management_interface.gateway), which is via 192.168.10.1 on mgmt-br. " +
+				"os.write_files[0] (/etc/sysconfig/network/routes) line 5: the route to 192.168.10.0/24 conflicts with the subnet of mgmt-br.",
+		},
+		{
+			name:    "link-scoped",
+			network: static,
+			files: []config.File{
+				storage,
+				routes("10.50.0.0/16 - - eth2\n"),
+				{Path: "/etc/sysconfig/network/ifroute-eth2", Content: "10.60.0.0/16 -\n"},
+			},
+			message: "All 2 static routes are valid.",
+		},
+		{
+			name:     "link-scoped, unknown interface",
+			network:  static,
+			files:    []config.File{routes("10.50.0.0/16 - - eth3\n10.60.0.0/16 0.0.0.0\n")},
+			severity: SeverityWarning,
+			message: "os.write_files[0] (/etc/sysconfig/network/routes) line 1: interface eth3 is not one of the node's configured interfaces (mgmt-br). " +
+				"os.write_files[0] (/etc/sysconfig/network/routes) line 2: the route to 10.60.0.0/16 has neither a gateway nor an interface.",
+		},
+		{
+			name:     "management interface routes",
+			network:  config.Network{Method: config.NetworkMethodDHCP, VlanID: 100},
+			files:    []config.File{{Path: "/etc/sysconfig/network/ifroute-mgmt-br.100", Content: "10.20.0.0/16 192.168.10.254\n"}},
+			severity: SeverityWarning,
+			message: "os.write_files[0] (/etc/sysconfig/network/ifroute-mgmt-br.100) is overwritten or removed by the installer on every boot, " +
+				"along with its routes; add them to /etc/sysconfig/network/routes instead.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := StaticRouteCheck{Files: tt.files, Network: tt.network}
+			result, err := check.Evaluate(context.Background(), &Env{})
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "StaticRoute", Severity: tt.severity, Message: tt.message}, result)
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultPLPModels returns model prefixes of drives which are known to
+// have power-loss protection, i.e. whose volatile write cache will be
+// flushed to stable media if power is lost.  This is by no means an
+// exhaustive list, it's just the enterprise drives we see most often.
+func DefaultPLPModels() []string {
+	return []string{
+		"INTEL SSDSC2KB", // Intel D3-S4510/S4610
+		"INTEL SSDSC2KG",
+		"INTEL SSDPE2KX", // Intel P4510
+		"INTEL SSDPF2KX", // Intel P5510
+		"SAMSUNG MZ7LH",  // Samsung PM883
+		"SAMSUNG MZQL2",  // Samsung PM9A3
+		"SAMSUNG MZ7KH",  // Samsung SM883
+		"MICRON_5300",
+		"MICRON_5400",
+		"MICRON_7300",
+		"MICRON_7450",
+		"KINGSTON SEDC", // Kingston DC500/DC600
+		"KCD6XL",        // Kioxia CD6
+		"KCM6XR",        // Kioxia CM6
+	}
+}
+
+// WriteCacheCheck looks at whether the write cache on the system disk
+// is volatile.  Consumer SSDs typically have a volatile write cache and
+// no power-loss protection (PLP), which risks corrupting etcd if power
+// is lost.  This check only ever reads the cache setting, it never
+// changes it.  If Dev is empty, the installation device from the
+// inventory is checked.  If it's a partition, the disk it's on is.
+type WriteCacheCheck struct {
+	Dev string
+	// PLPModels overrides DefaultPLPModels() if set
+	PLPModels []string
+}
+
+// nvmeIDCtrl holds the subset of `nvme id-ctrl -o json` that we care about
+type nvmeIDCtrl struct {
+	ModelNumber string `json:"mn"`
+	// Bit 0 of VWC indicates the presence of a volatile write cache
+	VWC *int `json:"vwc"`
+}
+
+func (c WriteCacheCheck) readsFrom() []string {
+	return []string{"ConfigDevice"}
+}
+
+func (c WriteCacheCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "WriteCache"
+	dev := strings.TrimPrefix(c.Dev, "/dev/")
+	if dev == "" {
+		dev = env.Inventory.InstallDevice
+	}
+	if dev == "" {
+		// ConfigDeviceCheck reports installation disks it can't resolve
+		return
+	}
+	if dev, err = env.resolveDisk(dev); err != nil {
+		return
+	}
+
+	out, err := env.readFile(filepath.Join(env.host().sysBlock, dev, "queue", "write_cache"))
+	if err != nil {
+		return
+	}
+	// This is either "write back" or "write through"
+	volatile := strings.TrimSpace(string(out)) == "write back"
+
+	// Model is optional; we just won't be able to match it against the
+	// PLP list if we can't read it.
+	model := ""
+	if out, err := env.readFile(filepath.Join(env.host().sysBlock, dev, "device", "model")); err == nil {
+		model = strings.TrimSpace(string(out))
+	}
+
+	if strings.HasPrefix(dev, "nvme") {
+		// For NVMe devices, nvme-cli can tell us definitively whether
+		// the controller has a volatile write cache.  If nvme-cli isn't
+		// available, we just go with what sysfs told us.
+		if out, err := env.output("/usr/sbin/nvme", "id-ctrl", "/dev/"+dev, "-o", "json"); err == nil {
+			var ctrl nvmeIDCtrl
+			if json.Unmarshal(out, &ctrl) == nil {
+				if ctrl.VWC != nil {
+					volatile = volatile && *ctrl.VWC&1 == 1
+				}
+				if model == "" {
+					model = strings.TrimSpace(ctrl.ModelNumber)
+				}
+			}
+		}
+	}
+
+	if !volatile {
+		return
+	}
+
+	plpModels := c.PLPModels
+	if plpModels == nil {
+		plpModels = DefaultPLPModels()
+	}
+	for _, prefix := range plpModels {
+		if model != "" && strings.HasPrefix(strings.ToUpper(model), strings.ToUpper(prefix)) {
+			result.Severity = SeverityInfo
+			result.Message = fmt.Sprintf("Disk %s (%s) has a volatile write cache, but is known to have power-loss protection.", dev, model)
+			return
+		}
+	}
+
+	if model == "" {
+		model = "unknown model"
+	}
+	result.Severity = SeverityWarning
+	result.Message = fmt.Sprintf("Disk %s (%s) has a volatile write cache and no known power-loss protection, which risks data corruption on power loss. "+
+		"Please use PLP-capable media for production, or disable the write cache.", dev, model)
+	return
+}
+
+// AlignmentCheck looks for existing partitions which aren't aligned to
+// 1MiB boundaries, or to the device's physical block size or optimal I/O
+// size where those are larger, on devices which will be reused by the
+// installer (e.g. when an existing ESP or data partition is kept).  Partitions created
+// by ancient tooling often start at sector 63, which hurts performance
+// on SSDs and disks with 4KiB physical sectors.  The devices are Devs and
+// the installation device from the inventory.  Those listed in Wiped are
+// skipped, because the installer will create new, correctly aligned
+// partitions on them.
+type AlignmentCheck struct {
+	Devs  []string
+	Wiped []string
+}
+
+func (c AlignmentCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Alignment"
+
+	// The misaligned partitions, grouped by the alignment they needed
+	var alignments []uint64
+	misaligned := map[uint64][]string{}
+	for _, dev := range env.targets(c.Devs) {
+		dev = strings.TrimPrefix(dev, "/dev/")
+		if containsDev(c.Wiped, dev) {
+			continue
+		}
+		var parts []string
+		if parts, err = env.partitions(dev); err != nil {
+			return
+		}
+		var physicalBlockSize, optimalIOSize uint64
+		if physicalBlockSize, err = env.readSysfsUint(filepath.Join(env.host().sysBlock, dev, "queue", "physical_block_size")); err != nil {
+			return
+		}
+		// optimal_io_size is 0 if the device doesn't report one, which is
+		// fine, and a read failure is treated the same way.
+		optimalIOSize, _ = env.readSysfsUint(filepath.Join(env.host().sysBlock, dev, "queue", "optimal_io_size"))
+		alignment := lcm(lcm(1<<20, physicalBlockSize), optimalIOSize)
+
+		for _, part := range parts {
+			var start uint64
+			if start, err = env.readSysfsUint(filepath.Join(env.host().sysBlock, dev, part, "start")); err != nil {
+				return
+			}
+			// sysfs always reports the start in 512 byte sectors,
+			// regardless of the device's logical block size.
+			if (start*512)%alignment != 0 {
+				if _, ok := misaligned[alignment]; !ok {
+					alignments = append(alignments, alignment)
+				}
+				misaligned[alignment] = append(misaligned[alignment], fmt.Sprintf("%s (start sector %d)", part, start))
+			}
+		}
+	}
+
+	if len(alignments) > 0 {
+		var msgs []string
+		for _, alignment := range alignments {
+			msgs = append(msgs, fmt.Sprintf("Partitions %s are not aligned to %s boundaries, which will reduce disk performance.",
+				strings.Join(misaligned[alignment], ", "), formatBytes(alignment)))
+		}
+		result.Severity = SeverityWarning
+		result.Message = strings.Join(append(msgs, "Please consider recreating them, or wiping the disk."), " ")
+	}
+	return
+}
+
+// lcm returns the least common multiple of a and b, or a if b is 0, as
+// for a device which doesn't report an optimal I/O size.
+func lcm(a, b uint64) uint64 {
+	if b == 0 {
+		return a
+	}
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return a / x * b
+}
+
+// PoolMembershipCheck looks for ZFS pool members and multi-device Btrfs
+// filesystems on the candidate disks (and their partitions).  Such a disk
+// may look empty from the partition table, but wiping it will quietly
+// degrade or destroy someone's array, the rest of which may well be
+// elsewhere.  If the installation device from the inventory or any of
+// the other Targets (e.g. data disks) are pool members, this is fatal
+// unless destructive operations have been allowed.  If Devs is empty, all
+// disks are probed.
+type PoolMembershipCheck struct {
+	Devs    []string
+	Targets []string
+}
+
+func (c PoolMembershipCheck) readsFrom() []string {
+	return []string{"ConfigDevice"}
+}
+
+func (c PoolMembershipCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "PoolMembership"
+
+	type pool struct {
+		sig     signature
+		members []string
+	}
+	var pools []*pool
+	var targetMembers []string
+	targets := env.targets(c.Targets)
+	candidates := c.Devs
+	if len(candidates) == 0 {
+		var devs []blockDevice
+		if devs, err = env.listBlockDevices(); err != nil {
+			return
+		}
+		for _, dev := range devs {
+			if dev.isDisk() {
+				candidates = append(candidates, dev.Name)
+			}
+		}
+	}
+	for _, disk := range candidates {
+		disk = strings.TrimPrefix(disk, "/dev/")
+		var parts []string
+		if parts, err = env.partitions(disk); err != nil {
+			return
+		}
+		for _, dev := range append([]string{disk}, parts...) {
+			var sig signature
+			if sig, err = probeSignature(env, dev); err != nil {
+				return
+			}
+			if sig.Type == "" || (sig.Type == sigBtrfs && sig.NumDevices < 2) {
+				// Single device Btrfs is just a filesystem, not a pool
+				continue
+			}
+			var p *pool
+			for _, existing := range pools {
+				if existing.sig.Type == sig.Type && existing.sig.UUID == sig.UUID {
+					p = existing
+				}
+			}
+			if p == nil {
+				p = &pool{sig: sig}
+				pools = append(pools, p)
+			}
+			p.members = append(p.members, dev)
+			if containsDev(targets, disk) {
+				targetMembers = append(targetMembers, dev)
+			}
+		}
+	}
+
+	if len(pools) == 0 {
+		return
+	}
+
+	var msgs []string
+	for _, p := range pools {
+		switch p.sig.Type {
+		case sigZFS:
+			msgs = append(msgs, fmt.Sprintf("ZFS pool %s (guid %s) has %d member device(s) on this host: %s.",
+				p.sig.Label, p.sig.UUID, len(p.members), strings.Join(p.members, ", ")))
+		case sigBtrfs:
+			msgs = append(msgs, fmt.Sprintf("Btrfs filesystem %s has %d of %d member devices on this host: %s.",
+				p.sig.UUID, len(p.members), p.sig.NumDevices, strings.Join(p.members, ", ")))
+		}
+	}
+
+	result.Severity = SeverityInfo
+	if len(targetMembers) > 0 {
+		if env.Options.DestructiveAllowed {
+			msgs = append(msgs, fmt.Sprintf("%s will be wiped, which will degrade or destroy these pools.",
+				strings.Join(targetMembers, ", ")))
+		} else {
+			result.Severity = SeverityFatal
+			msgs = append(msgs, fmt.Sprintf("Installing to %s would degrade or destroy these pools. "+
+				"Please remove the devices from their pools, or confirm that they should be wiped.",
+				strings.Join(targetMembers, ", ")))
+		}
+	}
+	result.Message = strings.Join(msgs, " ")
+	return
+}
+
+// residuePaths are directories which, if there's anything in them on one
+// of the host's filesystems, indicate that other storage or cluster
+// software has been installed on it at some point.
+var residuePaths = []struct {
+	path string
+	what string
+}{
+	{"/var/lib/ceph", "Ceph data"},
+	{"/var/lib/rook", "Rook data"},
+	{"/etc/rancher", "k3s/RKE2 configuration"},
+	{"/var/lib/rancher", "k3s/RKE2 state"},
+	{"/var/lib/docker/containers", "Docker containers"},
+	{"/var/lib/containerd/io.containerd.metadata.v1.bolt", "containerd containers"},
+}
+
+// ResidueCheck looks for remnants of other storage or cluster software
+// (Ceph, Rook, k3s/RKE2, Docker, containerd) on hosts which have been
+// recycled from some other use.  Their udev rules, device mapper devices
+// and services tend to fight with a fresh installation, so they're worth
+// a warning.  The installer runs from its own live image, so it's the
+// host's disks which are examined, not the running system: ceph-volume
+// LVs and BlueStore OSDs are recognised by name and signature, and the
+// directories in residuePaths are looked for on ext2/3/4 filesystems with
+// debugfs, which opens them read-only.  If the remnants occupy the
+// installation device from the inventory or any of the other Targets
+// (e.g. data disks), this is fatal unless destructive operations have
+// been allowed.
+type ResidueCheck struct {
+	Targets []string
+}
+
+func (c ResidueCheck) readsFrom() []string {
+	return []string{"ConfigDevice"}
+}
+
+func (c ResidueCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Residue"
+
+	devs, err := env.listBlockDevices()
+	if err != nil {
+		return
+	}
+	targets := env.targets(c.Targets)
+	// onTarget returns true if the device (or, for a device mapper
+	// device, any it's on) is one of the targets or a partition of one.
+	onTarget := func(dev blockDevice, name string) bool {
+		if containsDev(targets, diskOf(devs, name)) {
+			return true
+		}
+		for _, slave := range dev.Slaves {
+			if containsDev(targets, diskOf(devs, slave)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var found, onTargets []string
+	add := func(remnant string, target bool) {
+		found = append(found, remnant)
+		if target {
+			onTargets = append(onTargets, remnant)
+		}
+	}
+	for _, dev := range devs {
+		// ceph-volume creates LVs named ceph--<vg uuid>-osd--block--<uuid>
+		if !strings.HasPrefix(dev.DMName, "ceph--") {
+			continue
+		}
+		for _, slave := range dev.Slaves {
+			add(fmt.Sprintf("ceph-volume LV %s on %s", dev.DMName, slave), containsDev(targets, diskOf(devs, slave)))
+		}
+	}
+	for _, dev := range devs {
+		if strings.HasPrefix(dev.DMName, "ceph--") {
+			continue
+		}
+		for _, name := range append([]string{dev.Name}, dev.Partitions...) {
+			target := onTarget(dev, name)
+			sig, err := probeSignature(env, name)
+			if err != nil {
+				if target {
+					return result, err
+				}
+				// Devices without media and the like can't have
+				// anything on them
+				continue
+			}
+			switch {
+			case sig.Type == sigBluestore:
+				add("Ceph BlueStore OSD on "+name, target)
+			case strings.HasPrefix(sig.Type, "ext"):
+				for _, p := range residuePaths {
+					if debugfsDirPopulated(env, name, p.path) {
+						add(fmt.Sprintf("%s in %s on %s", p.what, p.path, name), target)
+					}
+				}
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		return
+	}
+	result.Severity = SeverityWarning
+	result.Message = fmt.Sprintf("Found remnants of other storage or cluster software: %s. "+
+		"These may conflict with SaftOS.", strings.Join(found, ", "))
+	if len(onTargets) > 0 {
+		if env.Options.DestructiveAllowed {
+			// The remnants on the target disks will be wiped, so they're
+			// only worth a mention, but anything elsewhere still matters.
+			if len(onTargets) == len(found) {
+				result.Severity = SeverityInfo
+			}
+			result.Message += fmt.Sprintf(" The installation target will be wiped, removing %s.", strings.Join(onTargets, ", "))
+		} else {
+			result.Severity = SeverityFatal
+			result.Message += fmt.Sprintf(" The installation target is still in use by %s, please clean it up before installing.",
+				strings.Join(onTargets, ", "))
+		}
+	}
+	return
+}
@@ -0,0 +1,324 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteCacheCheck(t *testing.T) {
+	h := testHost()
+
+	expectedResults := map[string]Result{
+		"./testdata/write-cache/write-through": {
+			Name:     "WriteCache",
+			Severity: SeverityOK,
+		},
+		"./testdata/write-cache/write-back-plp": {
+			Name:     "WriteCache",
+			Severity: SeverityInfo,
+			Message:  "Disk sda (INTEL SSDSC2KB960G8) has a volatile write cache, but is known to have power-loss protection.",
+		},
+		"./testdata/write-cache/write-back-unknown": {
+			Name:     "WriteCache",
+			Severity: SeverityWarning,
+			Message: "Disk sda (Samsung SSD 870 EVO 1TB) has a volatile write cache and no known power-loss protection, which risks data corruption on power loss. " +
+				"Please use PLP-capable media for production, or disable the write cache.",
+		},
+	}
+
+	check := WriteCacheCheck{Dev: "/dev/sda"}
+	for dir, expectedResult := range expectedResults {
+		h.sysBlock = dir
+		result, err := check.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err)
+		assert.Equal(t, expectedResult, result)
+	}
+}
+
+func TestWriteCacheCheckPLPOverride(t *testing.T) {
+	h := testHost()
+	h.sysBlock = "./testdata/write-cache/write-back-unknown"
+	check := WriteCacheCheck{Dev: "sda", PLPModels: []string{"Samsung SSD 870"}}
+	result, err := check.Evaluate(context.Background(), &Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityInfo, result.Severity)
+}
+
+func TestWriteCacheCheckNVMe(t *testing.T) {
+	h := testHost()
+	h.sysBlock = "./testdata/write-cache/write-back-unknown"
+	expectedSeverities := map[string]Severity{
+		"nvme-vwc-0":     SeverityOK,
+		"nvme-vwc-7":     SeverityWarning,
+		"dmidecode-fail": SeverityWarning, // i.e. nvme-cli failed, fall back to sysfs
+	}
+
+	check := WriteCacheCheck{Dev: "nvme0n1"}
+	for key, expectedSeverity := range expectedSeverities {
+		result, err := check.Evaluate(context.Background(), &Env{execCommand: fakeCommand(key), machine: h})
+		assert.Nil(t, err)
+		assert.Equal(t, expectedSeverity, result.Severity, key)
+	}
+}
+
+// A partition is checked as the disk it's on, and nothing is checked if
+// the installation device couldn't be resolved.
+func TestWriteCacheCheckDevice(t *testing.T) {
+	h := testHost()
+	h.sysBlock = "./testdata/write-cache/write-back-unknown"
+	result, err := WriteCacheCheck{Dev: "/dev/sda2"}.Evaluate(context.Background(), &Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityWarning, result.Severity)
+	assert.Contains(t, result.Message, "Disk sda (Samsung SSD 870 EVO 1TB)")
+
+	result, err = WriteCacheCheck{}.Evaluate(context.Background(), &Env{Inventory: Inventory{InstallDevice: "sda2"}, machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, SeverityWarning, result.Severity)
+
+	result, err = WriteCacheCheck{}.Evaluate(context.Background(), &Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "WriteCache"}, result)
+}
+
+func TestWriteCacheCheckMissingDevice(t *testing.T) {
+	h := testHost()
+	h.sysBlock = "./testdata/write-cache/write-through"
+	_, err := WriteCacheCheck{Dev: "sdz"}.Evaluate(context.Background(), &Env{machine: h})
+	assert.NotNil(t, err)
+}
+
+func TestAlignmentCheck(t *testing.T) {
+	h := testHost()
+
+	expectedResults := map[string]Result{
+		"./testdata/alignment/legacy": {
+			Name:     "Alignment",
+			Severity: SeverityWarning,
+			Message: "Partitions sda1 (start sector 63), sda2 (start sector 1028160) are not aligned to 1MiB boundaries, which will reduce disk performance. " +
+				"Please consider recreating them, or wiping the disk.",
+		},
+		"./testdata/alignment/modern": {
+			Name:     "Alignment",
+			Severity: SeverityOK,
+		},
+		// Partitions on a RAID volume should be aligned to its stripe
+		"./testdata/alignment/striped": {
+			Name:     "Alignment",
+			Severity: SeverityWarning,
+			Message: "Partitions sda1 (start sector 2048) are not aligned to 4MiB boundaries, which will reduce disk performance. " +
+				"Please consider recreating them, or wiping the disk.",
+		},
+	}
+
+	// sdb is misaligned in both layouts, but will be wiped, so must not
+	// be reported
+	check := AlignmentCheck{Devs: []string{"/dev/sda", "sdb"}, Wiped: []string{"/dev/sdb"}}
+	for dir, expectedResult := range expectedResults {
+		h.sysBlock = dir
+		result, err := check.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err)
+		assert.Equal(t, expectedResult, result)
+	}
+
+	// The installation device is checked too, unless it's wiped
+	h.sysBlock = "./testdata/alignment/legacy"
+	check = AlignmentCheck{Devs: []string{"/dev/sdb"}}
+	result, err := check.Evaluate(context.Background(), &Env{Inventory: Inventory{InstallDevice: "sda"}, machine: h})
+	assert.Nil(t, err)
+	assert.Contains(t, result.Message, "Partitions sdb1 (start sector 63), sda1 (start sector 63), sda2 (start sector 1028160) are not aligned")
+	check.Wiped = []string{"sda", "sdb"}
+	result, err = check.Evaluate(context.Background(), &Env{Inventory: Inventory{InstallDevice: "sda"}, machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "Alignment", Severity: SeverityOK}, result)
+}
+
+func TestPoolMembershipCheck(t *testing.T) {
+	h := testHost()
+
+	devs := []string{"sda", "sdb", "sdc"}
+	zfsPool := "ZFS pool tank (guid 1234567890123456789) has 2 member device(s) on this host: sdb1, sdc1."
+	btrfsPool := "Btrfs filesystem d41c2f6b-8a11-4e5a-9b3c-0123456789ab has 2 of 3 member devices on this host: sdb, sdc."
+	testCases := []struct {
+		fixture        string
+		check          PoolMembershipCheck
+		options        Options
+		expectedResult Result
+	}{
+		{
+			fixture: "zfs",
+			check:   PoolMembershipCheck{Devs: devs, Targets: []string{"/dev/sda"}},
+			expectedResult: Result{
+				Name:     "PoolMembership",
+				Severity: SeverityInfo,
+				Message:  zfsPool,
+			},
+		},
+		{
+			fixture: "zfs",
+			check:   PoolMembershipCheck{Devs: devs, Targets: []string{"/dev/sdb"}},
+			expectedResult: Result{
+				Name:     "PoolMembership",
+				Severity: SeverityFatal,
+				Message: zfsPool + " Installing to sdb1 would degrade or destroy these pools. " +
+					"Please remove the devices from their pools, or confirm that they should be wiped.",
+			},
+		},
+		{
+			fixture: "zfs",
+			check:   PoolMembershipCheck{Devs: devs, Targets: []string{"/dev/sdb"}},
+			options: Options{DestructiveAllowed: true},
+			expectedResult: Result{
+				Name:     "PoolMembership",
+				Severity: SeverityInfo,
+				Message:  zfsPool + " sdb1 will be wiped, which will degrade or destroy these pools.",
+			},
+		},
+		{
+			// Devs defaults to all disks
+			fixture: "zfs",
+			check:   PoolMembershipCheck{Targets: []string{"/dev/sda"}},
+			expectedResult: Result{
+				Name:     "PoolMembership",
+				Severity: SeverityInfo,
+				Message:  zfsPool,
+			},
+		},
+		{
+			fixture: "btrfs-single",
+			check:   PoolMembershipCheck{Devs: devs, Targets: []string{"/dev/sdb"}},
+			expectedResult: Result{
+				Name:     "PoolMembership",
+				Severity: SeverityOK,
+			},
+		},
+		{
+			fixture: "btrfs-multi",
+			check:   PoolMembershipCheck{Devs: devs, Targets: []string{"sdc"}},
+			expectedResult: Result{
+				Name:     "PoolMembership",
+				Severity: SeverityFatal,
+				Message: btrfsPool + " Installing to sdc would degrade or destroy these pools. " +
+					"Please remove the devices from their pools, or confirm that they should be wiped.",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		h.sysBlock = "./testdata/pool-membership/" + tc.fixture + "/sys/block"
+		h.devDir = "./testdata/pool-membership/" + tc.fixture + "/dev"
+		result, err := tc.check.Evaluate(context.Background(), &Env{Options: tc.options, machine: h})
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expectedResult, result, tc.fixture)
+	}
+}
+
+func TestResidueCheck(t *testing.T) {
+	h := testHost()
+
+	// What's in the directories debugfs is asked about on each fixture's
+	// filesystems, by device and directory
+	populated := map[string][]string{
+		"k3s":  {"sda1 /etc/rancher", "sda1 /var/lib/rancher"},
+		"ceph": {"sda1 /var/lib/ceph"},
+	}
+	cephLV := "ceph--5f8e0c3a--6a9b--4d8e--9b1a--2c3d4e5f6a7b-osd--block--0f1e2d3c--4b5a--6978--8a9b--0c1d2e3f4a5b"
+	cephFound := "Found remnants of other storage or cluster software: ceph-volume LV " + cephLV + " on sdb1, " +
+		"Ceph data in /var/lib/ceph on sda1, Ceph BlueStore OSD on sdc. These may conflict with SaftOS."
+	testCases := []struct {
+		fixture        string
+		targets        []string
+		options        Options
+		expectedResult Result
+	}{
+		{
+			fixture: "clean",
+			targets: []string{"/dev/sda"},
+			expectedResult: Result{
+				Name:     "Residue",
+				Severity: SeverityOK,
+			},
+		},
+		{
+			// The remnants aren't on the installation disk
+			fixture: "k3s",
+			targets: []string{"/dev/sdb"},
+			expectedResult: Result{
+				Name:     "Residue",
+				Severity: SeverityWarning,
+				Message: "Found remnants of other storage or cluster software: " +
+					"k3s/RKE2 configuration in /etc/rancher on sda1, k3s/RKE2 state in /var/lib/rancher on sda1. " +
+					"These may conflict with SaftOS.",
+			},
+		},
+		{
+			fixture: "k3s",
+			targets: []string{"/dev/sda"},
+			expectedResult: Result{
+				Name:     "Residue",
+				Severity: SeverityFatal,
+				Message: "Found remnants of other storage or cluster software: " +
+					"k3s/RKE2 configuration in /etc/rancher on sda1, k3s/RKE2 state in /var/lib/rancher on sda1. " +
+					"These may conflict with SaftOS. The installation target is still in use by " +
+					"k3s/RKE2 configuration in /etc/rancher on sda1, k3s/RKE2 state in /var/lib/rancher on sda1, please clean it up before installing.",
+			},
+		},
+		{
+			fixture: "ceph",
+			expectedResult: Result{
+				Name:     "Residue",
+				Severity: SeverityWarning,
+				Message:  cephFound,
+			},
+		},
+		{
+			fixture: "ceph",
+			targets: []string{"/dev/sdb"},
+			expectedResult: Result{
+				Name:     "Residue",
+				Severity: SeverityFatal,
+				Message:  cephFound + " The installation target is still in use by ceph-volume LV " + cephLV + " on sdb1, please clean it up before installing.",
+			},
+		},
+		{
+			fixture: "ceph",
+			targets: []string{"/dev/sdc"},
+			expectedResult: Result{
+				Name:     "Residue",
+				Severity: SeverityFatal,
+				Message:  cephFound + " The installation target is still in use by Ceph BlueStore OSD on sdc, please clean it up before installing.",
+			},
+		},
+		{
+			fixture: "ceph",
+			targets: []string{"/dev/sdc"},
+			options: Options{DestructiveAllowed: true},
+			expectedResult: Result{
+				Name:     "Residue",
+				Severity: SeverityWarning,
+				Message:  cephFound + " The installation target will be wiped, removing Ceph BlueStore OSD on sdc.",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		h.sysBlock = "./testdata/residue/" + tc.fixture + "/sys/block"
+		h.devDir = "./testdata/residue/" + tc.fixture + "/dev"
+		command := func(_ string, args ...string) *exec.Cmd {
+			dir := strings.TrimPrefix(args[1], "ls -p ")
+			if slices.Contains(populated[tc.fixture], filepath.Base(args[2])+" "+dir) {
+				return fakeExecCommand("debugfs-rancher-state")
+			}
+			return fakeExecCommand("debugfs-not-found")
+		}
+		env := &Env{Options: tc.options, execCommand: command, machine: h}
+		result, err := ResidueCheck{Targets: tc.targets}.Evaluate(context.Background(), env)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expectedResult, result, tc.fixture)
+	}
+}
@@ -0,0 +1,105 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// zramDevice matches the names of zram devices, which swap to compressed
+// RAM rather than a disk.
+var zramDevice = regexp.MustCompile(`^/dev/zram[0-9]+$`)
+
+// SwapCheck warns about active swap, which the kubelet refuses to start
+// with, so it must be disabled before SaftOS is installed, or the node
+// upgraded.  Swap partitions, swap files and zram devices are disabled
+// differently, so each is listed with its kind.
+type SwapCheck struct{}
+
+// A swapArea is an active swap area, from /proc/swaps.
+type swapArea struct {
+	// Name is the device or file, e.g. "/dev/sda2".
+	Name string
+	// Kind is "partition", "file" or "zram".
+	Kind string
+	// SizeKiB is how big it is.
+	SizeKiB uint64
+}
+
+func (a swapArea) String() string {
+	return fmt.Sprintf("%s (%s, %s)", a.Name, a.Kind, formatBytes(a.SizeKiB<<10))
+}
+
+// swapRemediations say how to disable each kind of swap area for good.
+var swapRemediations = map[string]string{
+	"partition": "swapoff the partition, and remove it from /etc/fstab",
+	"file":      "swapoff the file, remove it from /etc/fstab, and delete it",
+	"zram":      "swapoff the zram device, and disable zram-generator or the service which set it up",
+}
+
+func (c SwapCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c SwapCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Swap"
+	out, err := env.readFile(env.host().procSwaps)
+	if err != nil {
+		return
+	}
+	areas, err := parseSwaps(string(out))
+	if err != nil || len(areas) == 0 {
+		return
+	}
+
+	names := make([]string, len(areas))
+	listed := make([]string, len(areas))
+	var hints []string
+	for i, area := range areas {
+		names[i] = area.Name
+		listed[i] = area.String()
+		hint := swapRemediations[area.Kind]
+		if !slices.Contains(hints, hint) {
+			hints = append(hints, hint)
+		}
+	}
+	result.Severity = SeverityWarning
+	result.Message = fmt.Sprintf("Swap is active on %s. Kubernetes does not support swap, so it must be disabled before installation.",
+		strings.Join(listed, ", "))
+	result.Facts = map[string]any{"swap": names}
+	result.Remediation = &Remediation{Hint: "To disable swap, " + strings.Join(hints, "; ") + "."}
+	return
+}
+
+// parseSwaps parses the contents of /proc/swaps, e.g.
+//
+//	Filename				Type		Size		Used		Priority
+//	/dev/sda2                               partition	8388604		0		-2
+//	/dev/zram0                              partition	4194300		0		100
+//
+// Sizes are in KiB.  Names with spaces have them escaped as \040.
+func parseSwaps(out string) ([]swapArea, error) {
+	var areas []swapArea
+	for i, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || (i == 0 && fields[0] == "Filename") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, &ParseError{Where: fmt.Sprintf("line %d", i+1), Text: line, Reason: "not a swap area"}
+		}
+		size, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, &ParseError{Where: fmt.Sprintf("line %d", i+1), Text: line, Reason: "the size is not a number"}
+		}
+		area := swapArea{Name: strings.ReplaceAll(fields[0], `\040`, " "), Kind: fields[1], SizeKiB: size}
+		if zramDevice.MatchString(area.Name) {
+			area.Kind = "zram"
+		}
+		areas = append(areas, area)
+	}
+	return areas, nil
+}
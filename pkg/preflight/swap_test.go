@@ -0,0 +1,73 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwapCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture string
+		result  Result
+	}{
+		{
+			fixture: "none",
+			result:  Result{Name: "Swap"},
+		},
+		{
+			fixture: "partition",
+			result: Result{
+				Name:        "Swap",
+				Severity:    SeverityWarning,
+				Message:     "Swap is active on /dev/sda2 (partition, 8GiB). Kubernetes does not support swap, so it must be disabled before installation.",
+				Facts:       map[string]any{"swap": []string{"/dev/sda2"}},
+				Remediation: &Remediation{Hint: "To disable swap, swapoff the partition, and remove it from /etc/fstab."},
+			},
+		},
+		{
+			// It's a partition as far as the kernel is concerned
+			fixture: "zram",
+			result: Result{
+				Name:        "Swap",
+				Severity:    SeverityWarning,
+				Message:     "Swap is active on /dev/zram0 (zram, 4GiB). Kubernetes does not support swap, so it must be disabled before installation.",
+				Facts:       map[string]any{"swap": []string{"/dev/zram0"}},
+				Remediation: &Remediation{Hint: "To disable swap, swapoff the zram device, and disable zram-generator or the service which set it up."},
+			},
+		},
+		{
+			fixture: "mixed",
+			result: Result{
+				Name:     "Swap",
+				Severity: SeverityWarning,
+				Message: "Swap is active on /dev/nvme0n1p3 (partition, 2GiB), /swap file (file, 512MiB), /dev/zram0 (zram, 4GiB). " +
+					"Kubernetes does not support swap, so it must be disabled before installation.",
+				Facts: map[string]any{"swap": []string{"/dev/nvme0n1p3", "/swap file", "/dev/zram0"}},
+				Remediation: &Remediation{Hint: "To disable swap, swapoff the partition, and remove it from /etc/fstab; " +
+					"swapoff the file, remove it from /etc/fstab, and delete it; " +
+					"swapoff the zram device, and disable zram-generator or the service which set it up."},
+			},
+		},
+	}
+	for _, test := range tests {
+		h.procSwaps = "./testdata/swap/" + test.fixture
+		result, err := SwapCheck{}.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, test.result, result, test.fixture)
+	}
+
+	h.procSwaps = "./testdata/swap/malformed"
+	_, err := SwapCheck{}.Evaluate(context.Background(), &Env{machine: h})
+	assert.True(t, errors.Is(err, ErrParseFailure), err)
+	assert.EqualError(t, err, `line 2, the size is not a number: "/dev/sda2 partition lots 0 -2"`)
+
+	h.procSwaps = "./testdata/swap/missing"
+	_, err = SwapCheck{}.Evaluate(context.Background(), &Env{machine: h})
+	assert.True(t, errors.Is(err, fs.ErrNotExist), err)
+}
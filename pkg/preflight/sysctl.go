@@ -0,0 +1,121 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A SysctlRecommendation is the range of values recommended for a sysctl
+// (named as for sysctl(8), e.g. "vm.swappiness"), and why.
+type SysctlRecommendation struct {
+	Name string
+	// Allowed lists the recommended values, if set.  Otherwise, the value
+	// should be at least Min, and at most Max if that's non-zero.
+	Allowed []uint64
+	Min     uint64
+	Max     uint64
+	// Rationale explains what goes wrong when the value is outside the
+	// recommendation.
+	Rationale string
+}
+
+// DefaultMemorySysctls are the memory management settings a
+// virtualization node needs.
+var DefaultMemorySysctls = []SysctlRecommendation{
+	{
+		Name:      "vm.max_map_count",
+		Min:       262144,
+		Rationale: "Elasticsearch and similar workloads refuse to start with fewer memory map areas.",
+	},
+	{
+		Name:      "vm.overcommit_memory",
+		Allowed:   []uint64{0, 1},
+		Rationale: "Strict overcommit accounting causes allocation failures under memory pressure, even when memory is free.",
+	},
+	{
+		Name:      "vm.swappiness",
+		Max:       60,
+		Rationale: "Aggressive swapping pages out VM and storage daemon memory, which hurts latency.",
+	},
+	{
+		Name:      "vm.panic_on_oom",
+		Allowed:   []uint64{0},
+		Rationale: "Panicking on out of memory takes down every VM on the host, instead of just the offending process.",
+	},
+}
+
+// recommended describes the recommended values, e.g. "at least 262144"
+func (r SysctlRecommendation) recommended() string {
+	if len(r.Allowed) > 0 {
+		values := make([]string, len(r.Allowed))
+		for i, value := range r.Allowed {
+			values[i] = fmt.Sprint(value)
+		}
+		return strings.Join(values, " or ")
+	}
+	if r.Max == 0 {
+		return fmt.Sprintf("at least %d", r.Min)
+	}
+	if r.Min == 0 {
+		return fmt.Sprintf("at most %d", r.Max)
+	}
+	return fmt.Sprintf("%d to %d", r.Min, r.Max)
+}
+
+// nearest returns the recommended value nearest to value, which is the
+// first of Allowed, if it's set.
+func (r SysctlRecommendation) nearest(value uint64) uint64 {
+	switch {
+	case len(r.Allowed) > 0:
+		return r.Allowed[0]
+	case value < r.Min:
+		return r.Min
+	}
+	return r.Max
+}
+
+func (r SysctlRecommendation) allows(value uint64) bool {
+	if len(r.Allowed) > 0 {
+		return slices.Contains(r.Allowed, value)
+	}
+	return value >= r.Min && (r.Max == 0 || value <= r.Max)
+}
+
+// MemorySysctlCheck warns about memory management sysctls which differ
+// from the recommendations, as hardened images sometimes set them to
+// values which cause allocation failures under memory pressure.  Sysctls
+// which can't be read are ignored.
+type MemorySysctlCheck struct {
+	// Recommendations overrides DefaultMemorySysctls, if set.
+	Recommendations []SysctlRecommendation
+}
+
+func (c MemorySysctlCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "MemorySysctl"
+	recommendations := c.Recommendations
+	if recommendations == nil {
+		recommendations = DefaultMemorySysctls
+	}
+
+	var msgs []string
+	var actions []RemediationAction
+	for _, r := range recommendations {
+		value, ok := readLimit(env, r.Name)
+		if ok && !r.allows(value) {
+			msgs = append(msgs, fmt.Sprintf("%s is %d (recommended %s). %s", r.Name, value, r.recommended(), r.Rationale))
+			actions = append(actions, RemediationAction{Kind: RemediationSysctlSet, Target: r.Name, Value: fmt.Sprint(r.nearest(value))})
+		}
+	}
+	if len(msgs) > 0 {
+		result.Severity = SeverityWarning
+		result.Message = strings.Join(msgs, " ")
+		result.Remediation = &Remediation{
+			Hint: fmt.Sprintf("Set them in the live environment with: %s.  The installed system needs them in /etc/sysctl.d as well.",
+				commands(actions)),
+			Actions: actions,
+		}
+	}
+	return
+}
@@ -0,0 +1,81 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySysctlCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture         string
+		recommendations []SysctlRecommendation
+		severity        Severity
+		message         string
+		remediation     *Remediation
+	}{
+		{
+			fixture:  "suse",
+			severity: SeverityWarning,
+			message: "vm.max_map_count is 65530 (recommended at least 262144). " +
+				"Elasticsearch and similar workloads refuse to start with fewer memory map areas.",
+			remediation: &Remediation{
+				Hint: "Set them in the live environment with: /usr/sbin/sysctl -w vm.max_map_count=262144.  " +
+					"The installed system needs them in /etc/sysctl.d as well.",
+				Actions: []RemediationAction{{Kind: RemediationSysctlSet, Target: "vm.max_map_count", Value: "262144"}},
+			},
+		},
+		{
+			fixture:  "hardened",
+			severity: SeverityWarning,
+			message: "vm.overcommit_memory is 2 (recommended 0 or 1). " +
+				"Strict overcommit accounting causes allocation failures under memory pressure, even when memory is free. " +
+				"vm.swappiness is 100 (recommended at most 60). " +
+				"Aggressive swapping pages out VM and storage daemon memory, which hurts latency. " +
+				"vm.panic_on_oom is 1 (recommended 0). " +
+				"Panicking on out of memory takes down every VM on the host, instead of just the offending process.",
+			remediation: &Remediation{
+				Hint: "Set them in the live environment with: /usr/sbin/sysctl -w vm.overcommit_memory=0; " +
+					"/usr/sbin/sysctl -w vm.swappiness=60; /usr/sbin/sysctl -w vm.panic_on_oom=0.  " +
+					"The installed system needs them in /etc/sysctl.d as well.",
+				Actions: []RemediationAction{
+					{Kind: RemediationSysctlSet, Target: "vm.overcommit_memory", Value: "0"},
+					{Kind: RemediationSysctlSet, Target: "vm.swappiness", Value: "60"},
+					{Kind: RemediationSysctlSet, Target: "vm.panic_on_oom", Value: "0"},
+				},
+			},
+		},
+		{
+			fixture: "suse",
+			recommendations: []SysctlRecommendation{
+				{Name: "vm.max_map_count", Min: 65530},
+				{Name: "vm.swappiness", Min: 10, Max: 60},
+				{Name: "vm.nonexistent", Min: 1},
+			},
+		},
+		{
+			fixture: "hardened",
+			recommendations: []SysctlRecommendation{
+				{Name: "vm.swappiness", Min: 10, Max: 60, Rationale: "Custom."},
+			},
+			severity: SeverityWarning,
+			message:  "vm.swappiness is 100 (recommended 10 to 60). Custom.",
+			remediation: &Remediation{
+				Hint: "Set them in the live environment with: /usr/sbin/sysctl -w vm.swappiness=60.  " +
+					"The installed system needs them in /etc/sysctl.d as well.",
+				Actions: []RemediationAction{{Kind: RemediationSysctlSet, Target: "vm.swappiness", Value: "60"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/memory-sysctl/" + test.fixture
+		result, err := MemorySysctlCheck{Recommendations: test.recommendations}.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "MemorySysctl", Severity: test.severity, Message: test.message, Remediation: test.remediation},
+			result, test.fixture)
+	}
+}
@@ -0,0 +1,105 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultSystemdMinimum is the oldest systemd with the networkd semantics
+// and credentials support that bootstrap relies on.
+const DefaultSystemdMinimum = 249
+
+// DefaultSystemdUnits are the units the installer itself needs running.
+var DefaultSystemdUnits = []string{"dbus.service", "systemd-udevd.service"}
+
+// SystemdCheck verifies that the live environment is running systemd, that
+// it's new enough, and that the units the installer depends on are active.
+// Without systemd (e.g. in a container, or an unusual rescue image), the
+// installer can't work at all.
+type SystemdCheck struct {
+	// Minimum overrides DefaultSystemdMinimum, if set.
+	Minimum int
+	// Units overrides DefaultSystemdUnits, if set.
+	Units []string
+}
+
+func (c SystemdCheck) probes(env *Env) []toolCall {
+	units := c.Units
+	if units == nil {
+		units = DefaultSystemdUnits
+	}
+	probes := []toolCall{{"/usr/bin/systemctl", "--version"}}
+	for _, unit := range units {
+		probes = append(probes, toolCall{"/usr/bin/systemctl", "is-active", "--quiet", unit})
+	}
+	return probes
+}
+
+func (c SystemdCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Systemd"
+	minimum := c.Minimum
+	if minimum == 0 {
+		minimum = DefaultSystemdMinimum
+	}
+	units := c.Units
+	if units == nil {
+		units = DefaultSystemdUnits
+	}
+
+	// This is how sd_booted(3) tells
+	if _, err = env.stat(filepath.Join(env.host().root, "run/systemd/system")); errors.Is(err, fs.ErrNotExist) {
+		result.Severity = SeverityFatal
+		result.Message = "systemd is not running, and the installer cannot work without it."
+		return result, nil
+	} else if err != nil {
+		return
+	}
+
+	out, err := env.output("/usr/bin/systemctl", "--version")
+	if err != nil {
+		return result, fmt.Errorf("unable to run systemctl --version: %w", err)
+	}
+	version, err := parseSystemdVersion(string(out))
+	if err != nil {
+		return
+	}
+
+	var inactive []string
+	for _, unit := range units {
+		if !env.succeeds("/usr/bin/systemctl", "is-active", "--quiet", unit) {
+			inactive = append(inactive, unit)
+		}
+	}
+
+	result.Message = fmt.Sprintf("systemd %d.", version)
+	if version < minimum {
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("systemd %d is older than the minimum supported version %d.", version, minimum)
+	}
+	if len(inactive) > 0 {
+		result.Severity = SeverityFatal
+		result.Message += fmt.Sprintf(" Units the installer depends on are not active: %s.", strings.Join(inactive, ", "))
+	}
+	return
+}
+
+// parseSystemdVersion returns the version number from the output of
+// systemctl --version, the first line of which is e.g.
+// "systemd 249 (249.11+suse.124.g2bc0b2c447)".
+func parseSystemdVersion(out string) (int, error) {
+	line, _, _ := strings.Cut(out, "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "systemd" {
+		return 0, parseErrorf("unable to parse systemd version from %q", line)
+	}
+	version, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, parseErrorf("unable to parse systemd version from %q", line)
+	}
+	return version, nil
+}
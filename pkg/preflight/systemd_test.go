@@ -0,0 +1,91 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSystemdVersion(t *testing.T) {
+	tests := map[string]int{
+		"systemd 249 (249.11+suse.124.g2bc0b2c447)\n+PAM +AUDIT\n": 249,
+		"systemd 255 (255.4-1ubuntu8.4)\n+PAM +AUDIT\n":            255,
+		"systemd 234\n+PAM -AUDIT\n":                               234,
+	}
+	for out, expected := range tests {
+		version, err := parseSystemdVersion(out)
+		assert.Nil(t, err, out)
+		assert.Equal(t, expected, version, out)
+	}
+
+	_, err := parseSystemdVersion("Welcome to BusyBox\n")
+	assert.NotNil(t, err)
+}
+
+func TestSystemdCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture  string
+		version  string
+		inactive []string
+		minimum  int
+		severity Severity
+		message  string
+	}{
+		{
+			fixture: "booted",
+			version: "systemctl-version-suse",
+			message: "systemd 249.",
+		},
+		{
+			fixture:  "booted",
+			version:  "systemctl-version-suse",
+			minimum:  252,
+			severity: SeverityWarning,
+			message:  "systemd 249 is older than the minimum supported version 252.",
+		},
+		{
+			fixture:  "booted",
+			version:  "systemctl-version-old",
+			severity: SeverityWarning,
+			message:  "systemd 234 is older than the minimum supported version 249.",
+		},
+		{
+			fixture:  "booted",
+			version:  "systemctl-version-suse",
+			inactive: []string{"dbus.service"},
+			severity: SeverityFatal,
+			message:  "systemd 249. Units the installer depends on are not active: dbus.service.",
+		},
+		{
+			fixture:  "no-systemd",
+			severity: SeverityFatal,
+			message:  "systemd is not running, and the installer cannot work without it.",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/systemd/" + test.fixture
+		command := func(_ string, args ...string) *exec.Cmd {
+			if args[0] == "--version" {
+				return fakeExecCommand(test.version)
+			}
+			for _, unit := range test.inactive {
+				if args[len(args)-1] == unit {
+					return fakeExecCommand("systemctl-fail")
+				}
+			}
+			return fakeExecCommand("systemctl-active")
+		}
+		result, err := SystemdCheck{Minimum: test.minimum}.Evaluate(context.Background(), &Env{execCommand: command, machine: h})
+		assert.Nil(t, err, test.message)
+		assert.Equal(t, Result{Name: "Systemd", Severity: test.severity, Message: test.message}, result, test.message)
+	}
+
+	h.root = "./testdata/systemd/booted"
+	_, err := SystemdCheck{}.Evaluate(context.Background(), &Env{execCommand: fakeCommand("systemctl-version-garbage"), machine: h})
+	assert.NotNil(t, err)
+}
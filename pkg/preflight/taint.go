@@ -0,0 +1,120 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A TaintFlag is one bit of the kernel's taint mask, as documented in
+// Documentation/admin-guide/tainted-kernels.rst.
+type TaintFlag struct {
+	Bit         uint
+	Letter      string
+	Description string
+}
+
+// TaintFlags decodes /proc/sys/kernel/tainted.
+var TaintFlags = []TaintFlag{
+	{0, "P", "proprietary module loaded"},
+	{1, "F", "module force loaded"},
+	{2, "S", "running on an out of specification system"},
+	{3, "R", "module force unloaded"},
+	{4, "M", "machine check exception reported"},
+	{5, "B", "bad page referenced"},
+	{6, "U", "taint requested by userspace"},
+	{7, "D", "kernel oops or BUG occurred"},
+	{8, "A", "ACPI table overridden"},
+	{9, "W", "kernel issued a warning"},
+	{10, "C", "staging driver loaded"},
+	{11, "I", "working around a platform firmware bug"},
+	{12, "O", "out-of-tree module loaded"},
+	{13, "E", "unsigned module loaded"},
+	{14, "L", "soft lockup occurred"},
+	{15, "K", "kernel live patched"},
+	{16, "X", "auxiliary taint"},
+	{17, "T", "built with struct randomization"},
+	{18, "N", "in-kernel test run"},
+}
+
+// DefaultSeriousTaints are the taint flags which are warned about, by
+// letter.  An unsigned module (E) is also serious when lockdown is
+// expected.
+var DefaultSeriousTaints = []string{"D", "F", "R", "M", "B"}
+
+// DecodeTaints returns the flags set in a taint mask.  Bits without an
+// entry in TaintFlags are returned with a "?" letter.
+func DecodeTaints(mask uint64) []TaintFlag {
+	var flags []TaintFlag
+	for bit := uint(0); bit < 64; bit++ {
+		if mask&(1<<bit) == 0 {
+			continue
+		}
+		flag := TaintFlag{Bit: bit, Letter: "?", Description: fmt.Sprintf("unknown taint bit %d", bit)}
+		if i := slices.IndexFunc(TaintFlags, func(f TaintFlag) bool { return f.Bit == bit }); i >= 0 {
+			flag = TaintFlags[i]
+		}
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// TaintCheck reports why the kernel is tainted, if it is.  A tainted
+// kernel at install time is a support red flag, and some taints (a prior
+// oops, forced module loads) mean the host is already in trouble, so
+// they're warned about.  Lockdown is expected when the site requires
+// Secure Boot, or the kernel is already locked down, in which case an
+// unsigned module is also serious.
+type TaintCheck struct {
+	// Serious overrides DefaultSeriousTaints, if set.
+	Serious []string
+}
+
+func (c TaintCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c TaintCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Taint"
+	serious := c.Serious
+	if serious == nil {
+		serious = DefaultSeriousTaints
+	}
+	if env.Options.SecureBootPolicy == SecureBootRequired || lockdownActive(env) {
+		serious = append(slices.Clip(serious), "E")
+	}
+
+	mask, err := readTaintMask(env)
+	if err != nil {
+		return
+	}
+	if mask == 0 {
+		return
+	}
+
+	var flags []string
+	result.Severity = SeverityInfo
+	for _, flag := range DecodeTaints(mask) {
+		flags = append(flags, fmt.Sprintf("%s (%s)", flag.Letter, flag.Description))
+		if slices.Contains(serious, flag.Letter) {
+			result.Severity = SeverityWarning
+		}
+	}
+	result.Message = fmt.Sprintf("The kernel is tainted: %s.", strings.Join(flags, ", "))
+	if result.Severity == SeverityWarning {
+		result.Message += " Please check the kernel log, and the modules loaded, before installing."
+	}
+	return
+}
+
+// readTaintMask returns the kernel's taint mask.
+func readTaintMask(env *Env) (uint64, error) {
+	mask, err := strconv.ParseUint(env.readTrimmed(filepath.Join(env.host().root, "proc/sys/kernel/tainted")), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read kernel taint: %w", err)
+	}
+	return mask, nil
+}
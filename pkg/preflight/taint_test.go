@@ -0,0 +1,75 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeTaints(t *testing.T) {
+	assert.Empty(t, DecodeTaints(0))
+	assert.Equal(t, []TaintFlag{
+		{0, "P", "proprietary module loaded"},
+		{12, "O", "out-of-tree module loaded"},
+		{40, "?", "unknown taint bit 40"},
+	}, DecodeTaints(1<<0|1<<12|1<<40))
+}
+
+func TestTaintCheck(t *testing.T) {
+	h := testHost()
+
+	const unsigned = "The kernel is tainted: E (unsigned module loaded)."
+
+	tests := []struct {
+		fixture  string
+		serious  []string
+		policy   SecureBootPolicy
+		severity Severity
+		message  string
+	}{
+		{fixture: "clean"},
+		{
+			fixture:  "proprietary",
+			severity: SeverityInfo,
+			message:  "The kernel is tainted: P (proprietary module loaded), O (out-of-tree module loaded).",
+		},
+		{
+			fixture:  "proprietary",
+			serious:  []string{"O"},
+			severity: SeverityWarning,
+			message: "The kernel is tainted: P (proprietary module loaded), O (out-of-tree module loaded). " +
+				"Please check the kernel log, and the modules loaded, before installing.",
+		},
+		{
+			fixture:  "oops",
+			severity: SeverityWarning,
+			message: "The kernel is tainted: D (kernel oops or BUG occurred), W (kernel issued a warning). " +
+				"Please check the kernel log, and the modules loaded, before installing.",
+		},
+		{
+			fixture:  "unsigned",
+			severity: SeverityInfo,
+			message:  unsigned,
+		},
+		{
+			fixture:  "unsigned",
+			policy:   SecureBootRequired,
+			severity: SeverityWarning,
+			message:  unsigned + " Please check the kernel log, and the modules loaded, before installing.",
+		},
+		{
+			fixture:  "unsigned-lockdown",
+			severity: SeverityWarning,
+			message:  unsigned + " Please check the kernel log, and the modules loaded, before installing.",
+		},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/taint/" + test.fixture
+		env := &Env{Options: Options{SecureBootPolicy: test.policy}, machine: h}
+		result, err := TaintCheck{Serious: test.serious}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "Taint", Severity: test.severity, Message: test.message}, result, test.fixture)
+	}
+}
@@ -0,0 +1,241 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/version"
+)
+
+// telemetryTimeout is the longest sending telemetry may take.  It's
+// nobody's problem if it isn't sent, so it's much less than the other
+// checks' timeouts.
+const telemetryTimeout = 3 * time.Second
+
+// checkIDPattern matches the names of checks, as opposed to the names of
+// things on the host, which are never sent.
+var checkIDPattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// A TelemetryPayload is what's sent, if telemetry is enabled, about a
+// preflight run: which checks had which outcome, on roughly what class of
+// hardware, with which installer.  InstallID is random, so that a run
+// can't be tied to a host, only told apart from other runs.  Nothing the
+// host is known by is included, nor the checks' messages, which may
+// mention it.
+type TelemetryPayload struct {
+	InstallID        string            `json:"installId"`
+	InstallerVersion string            `json:"installerVersion"`
+	Mode             RunMode           `json:"mode"`
+	Role             Role              `json:"role,omitempty"`
+	Checks           []TelemetryCheck  `json:"checks"`
+	Hardware         TelemetryHardware `json:"hardware"`
+}
+
+// A TelemetryCheck is the outcome of a check.  ErrorKind is only set if
+// the check failed to run.
+type TelemetryCheck struct {
+	ID         string    `json:"id"`
+	Severity   Severity  `json:"severity"`
+	ErrorKind  ErrorKind `json:"errorKind,omitempty"`
+	Overridden bool      `json:"overridden,omitempty"`
+}
+
+// TelemetryHardware is the class of the host's hardware, in ranges broad
+// enough that lots of hosts share them.  Each is empty if the run didn't
+// find out.
+type TelemetryHardware struct {
+	// CPUCores is the range of the number of logical CPUs, e.g. "8-15".
+	CPUCores string `json:"cpuCores,omitempty"`
+	// Memory is the range of the amount of RAM, e.g. "32-63GiB".
+	Memory string `json:"memory,omitempty"`
+	// NICSpeed is the class of the fastest NIC, e.g. "10Gbps".
+	NICSpeed string `json:"nicSpeed,omitempty"`
+}
+
+// nicSpeedClasses are the speeds NICs are classed by, in Mbps.  A NIC is
+// in the fastest class it's at least as fast as.
+var nicSpeedClasses = []struct {
+	mbps int
+	name string
+}{
+	{400000, "400Gbps"},
+	{200000, "200Gbps"},
+	{100000, "100Gbps"},
+	{50000, "50Gbps"},
+	{40000, "40Gbps"},
+	{25000, "25Gbps"},
+	{10000, "10Gbps"},
+	{5000, "5Gbps"},
+	{2500, "2.5Gbps"},
+	{1000, "1Gbps"},
+	{1, "<1Gbps"},
+}
+
+// Anonymize returns what's sent as telemetry of report: the ID and
+// outcome of each check, and the class of the host's hardware, with
+// installID and installerVersion.  Nothing else in report is kept, so
+// that nothing the host is known by, e.g. its hostname, addresses,
+// serial numbers or anything in the checks' messages, facts or evidence,
+// can get out.  A result whose name isn't a check ID, as the names of
+// legacy checks needn't be, is left out too, as are a mode, role or
+// error kind which aren't known.  report isn't modified.
+func Anonymize(report Report, installID, installerVersion string) TelemetryPayload {
+	payload := TelemetryPayload{
+		InstallID:        installID,
+		InstallerVersion: installerVersion,
+		Checks:           []TelemetryCheck{},
+	}
+	// As a Report can be read from anywhere, only the known modes and
+	// roles are kept
+	if report.Mode == RunModeInstall || report.Mode == RunModeUpgrade {
+		payload.Mode = report.Mode
+	}
+	if report.Role == RoleManagement || report.Role == RoleWorker || report.Role == RoleWitness {
+		payload.Role = report.Role
+	}
+	for _, result := range report.Results {
+		if !checkIDPattern.MatchString(result.Name) {
+			continue
+		}
+		check := TelemetryCheck{ID: result.Name, Severity: result.Severity, Overridden: result.Overridden}
+		for _, kind := range errorKinds {
+			if result.ErrorKind == kind.kind {
+				check.ErrorKind = kind.kind
+			}
+		}
+		payload.Checks = append(payload.Checks, check)
+	}
+	if inv := report.Inventory; inv != nil {
+		if inv.LogicalCPUs > 0 {
+			payload.Hardware.CPUCores = powerOfTwoRange(uint64(inv.LogicalCPUs), "")
+		}
+		if inv.MemoryBytes > 0 {
+			payload.Hardware.Memory = powerOfTwoRange(inv.MemoryBytes>>30, "GiB")
+		}
+		fastest := 0
+		for _, nic := range inv.NICs {
+			fastest = max(fastest, nic.SpeedMbps)
+		}
+		for _, class := range nicSpeedClasses {
+			if fastest >= class.mbps {
+				payload.Hardware.NICSpeed = class.name
+				break
+			}
+		}
+	}
+	return payload
+}
+
+// powerOfTwoRange returns the range n is in, between consecutive powers of
+// two, e.g. "16-31" for 24, followed by unit.  Anything less than one is
+// in "<1".
+func powerOfTwoRange(n uint64, unit string) string {
+	if n == 0 {
+		return "<1" + unit
+	}
+	low := uint64(1)
+	for low <= n/2 {
+		low *= 2
+	}
+	if low == 1 {
+		return "1" + unit
+	}
+	return fmt.Sprintf("%d-%d%s", low, 2*low-1, unit)
+}
+
+// A TelemetrySender sends the telemetry of preflight runs to Endpoint,
+// for the install InstallID.  The zero value isn't useful; it's made by
+// NewTelemetrySender, if the configuration enables telemetry.
+type TelemetrySender struct {
+	Endpoint         string
+	InstallID        string
+	InstallerVersion string
+	// Timeout is the longest sending may take, if not telemetryTimeout.
+	Timeout time.Duration
+}
+
+// NewTelemetrySender returns a sender of telemetry to the endpoint in
+// cfg, with a new random install ID, or nil unless telemetry is enabled
+// there, as it isn't by default.
+func NewTelemetrySender(cfg *config.HarvesterConfig) *TelemetrySender {
+	if !cfg.Install.Telemetry.Enabled || cfg.Install.Telemetry.Endpoint == "" {
+		return nil
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		logrus.Infof("Not sending preflight telemetry, as there's no install ID: %v", err)
+		return nil
+	}
+	return &TelemetrySender{
+		Endpoint:         cfg.Install.Telemetry.Endpoint,
+		InstallID:        hex.EncodeToString(id),
+		InstallerVersion: version.HarvesterVersion,
+	}
+}
+
+// Send posts report, anonymised, as JSON to the endpoint, giving up after
+// the sender's timeout.  A nil sender sends nothing, and nor is anything
+// sent about an air-gapped run, as the host mustn't contact anything it
+// isn't installed from.
+func (s *TelemetrySender) Send(ctx context.Context, report Report) error {
+	if s == nil || report.AirGapped {
+		return nil
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = telemetryTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(Anonymize(report, s.InstallID, s.InstallerVersion))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+		Timeout:   timeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s responded %s", s.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// SendInBackground sends report like Send, without waiting for it, so
+// that telemetry never holds up the run, and logs rather than returns any
+// error, since it has no bearing on the outcome.  The channel returned is
+// closed once it's done, which is never longer than the sender's timeout,
+// for callers about to exit.
+func (s *TelemetrySender) SendInBackground(ctx context.Context, report Report) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := s.Send(ctx, report); err != nil {
+			logrus.Infof("Preflight telemetry wasn't sent: %v", err)
+		}
+	}()
+	return done
+}
@@ -0,0 +1,271 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// identifyingReport returns a report of a run on a host which is known by
+// all of identifying, which turn up everywhere a host's identity could.
+func identifyingReport() (Report, []string) {
+	identifying := []string{
+		"node1.example.com",
+		"192.168.1.23",
+		"fe80::5054:ff:fe12:3456",
+		"52:54:00:12:34:56",
+		"SN-4X2K9Q",
+		"4c4c4544-0042-3510-8051-b4c04f4e3132",
+		"0123456789abcdef0123456789abcdef",
+		"eth0",
+		"sda",
+		"K10secret",
+		"Dell Inc.",
+		"PowerEdge R650",
+		"rack-7",
+	}
+	report := Report{
+		DestructiveAllowed: true,
+		Production:         true,
+		Mode:               RunModeInstall,
+		Role:               RoleManagement,
+		Timestamp:          testRunTime,
+		Results: []Result{
+			{Name: "CPU", Severity: SeverityOK, Facts: map[string]any{"onlineCPUs": 24, "host": "node1.example.com"}},
+			{Name: "Memory", Severity: SeverityWarning, Message: "node1.example.com has 30GiB of memory.",
+				Facts: map[string]any{"serial": "SN-4X2K9Q"}},
+			{Name: "NetworkSpeed", Severity: SeverityWarning, Message: "Link speed of eth0 (52:54:00:12:34:56) is 1Gbps.",
+				Evidence: []Evidence{{Kind: EvidenceFile, Source: "/sys/class/net/eth0/address", Content: "52:54:00:12:34:56\n"}}},
+			{Name: "Join", Severity: SeverityFatal, Error: "192.168.1.23: token K10secret refused", ErrorKind: ErrorKind("fe80::5054:ff:fe12:3456"),
+				Overridden: true},
+			{Name: "MachineID", Severity: SeverityFatal, Error: "dmidecode: not found", ErrorKind: ErrorKindToolMissing},
+			// Legacy checks may be named anything
+			{Name: "node1.example.com", Severity: SeverityOK},
+			{Name: "sda (SN-4X2K9Q)", Severity: SeverityWarning},
+			{Name: "rack-7", Severity: SeverityOK},
+			{Name: "", Severity: SeverityOK},
+		},
+		Inventory: &Inventory{
+			InstallDevice: "sda",
+			Firmware:      &Firmware{Vendor: "Dell Inc.", Version: "SN-4X2K9Q"},
+			BMC:           &BMC{},
+			ChassisType:   "rack-7",
+			Cmdline:       "saftos.install.token=K10secret hostname=node1.example.com",
+			Nameservers:   []string{"192.168.1.23"},
+			SearchDomains: []string{"example.com"},
+			MachineID:     "0123456789abcdef0123456789abcdef",
+			LogicalCPUs:   24,
+			BlockDevices:  []string{"sda", "sda1"},
+			NICs: []NIC{
+				{Name: "eth0", HwAddr: "52:54:00:12:34:56", SpeedMbps: 1000, Duplex: "full"},
+				{Name: "eth1", HwAddr: "52:54:00:12:34:57", SpeedMbps: 25000},
+			},
+			DHCPLeases:  []DHCPLease{{Interface: "eth0", Address: "192.168.1.23"}},
+			MemoryBytes: 40 << 30,
+		},
+	}
+	return report, identifying
+}
+
+func TestAnonymize(t *testing.T) {
+	report, identifying := identifyingReport()
+	original, _ := json.Marshal(report)
+
+	payload := Anonymize(report, "install-1", "v1.4.0")
+	assert.Equal(t, TelemetryPayload{
+		InstallID:        "install-1",
+		InstallerVersion: "v1.4.0",
+		Mode:             RunModeInstall,
+		Role:             RoleManagement,
+		Checks: []TelemetryCheck{
+			{ID: "CPU", Severity: SeverityOK},
+			{ID: "Memory", Severity: SeverityWarning},
+			{ID: "NetworkSpeed", Severity: SeverityWarning},
+			{ID: "Join", Severity: SeverityFatal, Overridden: true},
+			{ID: "MachineID", Severity: SeverityFatal, ErrorKind: ErrorKindToolMissing},
+		},
+		Hardware: TelemetryHardware{CPUCores: "16-31", Memory: "32-63GiB", NICSpeed: "25Gbps"},
+	}, payload)
+
+	// Nothing the host is known by survives, anywhere in what's sent
+	data, err := json.Marshal(payload)
+	assert.Nil(t, err)
+	for _, id := range identifying {
+		assert.NotContains(t, string(data), id)
+	}
+	for _, key := range []string{"message", "error\"", "facts", "evidence", "inventory", "timestamp", "hostname", "name"} {
+		assert.NotContains(t, strings.ToLower(string(data)), key)
+	}
+
+	// Only known modes and roles are sent
+	report.Mode, report.Role = RunMode("node1.example.com"), Role("SN-4X2K9Q")
+	payload = Anonymize(report, "", "")
+	assert.Empty(t, payload.Mode)
+	assert.Empty(t, payload.Role)
+	report.Mode, report.Role = RunModeInstall, RoleManagement
+
+	// The report is left as it was
+	after, _ := json.Marshal(report)
+	assert.JSONEq(t, string(original), string(after))
+}
+
+// TestTelemetryPayloadFields pins what's sent, so that adding anything to
+// it is a deliberate decision, made here, rather than an accident.
+func TestTelemetryPayloadFields(t *testing.T) {
+	var fields []string
+	var walk func(prefix string, typ reflect.Type)
+	walk = func(prefix string, typ reflect.Type) {
+		for typ.Kind() == reflect.Slice || typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			fields = append(fields, prefix+" "+typ.String())
+			return
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			walk(prefix+"."+typ.Field(i).Name, typ.Field(i).Type)
+		}
+	}
+	walk("", reflect.TypeOf(TelemetryPayload{}))
+	assert.Equal(t, []string{
+		".InstallID string",
+		".InstallerVersion string",
+		".Mode preflight.RunMode",
+		".Role preflight.Role",
+		".Checks.ID string",
+		".Checks.Severity preflight.Severity",
+		".Checks.ErrorKind preflight.ErrorKind",
+		".Checks.Overridden bool",
+		".Hardware.CPUCores string",
+		".Hardware.Memory string",
+		".Hardware.NICSpeed string",
+	}, fields)
+}
+
+func TestAnonymizeHardware(t *testing.T) {
+	tests := []struct {
+		inventory *Inventory
+		hardware  TelemetryHardware
+	}{
+		{nil, TelemetryHardware{}},
+		{&Inventory{}, TelemetryHardware{}},
+		{&Inventory{LogicalCPUs: 1, MemoryBytes: 512 << 20, NICs: []NIC{{SpeedMbps: 100}}},
+			TelemetryHardware{CPUCores: "1", Memory: "<1GiB", NICSpeed: "<1Gbps"}},
+		{&Inventory{LogicalCPUs: 4, MemoryBytes: 8 << 30, NICs: []NIC{{SpeedMbps: 0}, {SpeedMbps: 2500}}},
+			TelemetryHardware{CPUCores: "4-7", Memory: "8-15GiB", NICSpeed: "2.5Gbps"}},
+		{&Inventory{LogicalCPUs: 7, MemoryBytes: 16<<30 - 1, NICs: []NIC{{SpeedMbps: 0}}},
+			TelemetryHardware{CPUCores: "4-7", Memory: "8-15GiB"}},
+		{&Inventory{LogicalCPUs: 256, MemoryBytes: 1536 << 30, NICs: []NIC{{SpeedMbps: 10000}, {SpeedMbps: 100000}}},
+			TelemetryHardware{CPUCores: "256-511", Memory: "1024-2047GiB", NICSpeed: "100Gbps"}},
+		{&Inventory{NICs: []NIC{{SpeedMbps: 800000}}}, TelemetryHardware{NICSpeed: "400Gbps"}},
+	}
+	for _, tt := range tests {
+		payload := Anonymize(Report{Inventory: tt.inventory}, "", "")
+		assert.Equal(t, tt.hardware, payload.Hardware, "%+v", tt.inventory)
+		assert.NotNil(t, payload.Checks)
+	}
+}
+
+func TestNewTelemetrySender(t *testing.T) {
+	// It's off unless it's asked for
+	cfg := config.NewHarvesterConfig()
+	assert.Nil(t, NewTelemetrySender(cfg))
+	cfg.Install.Telemetry.Endpoint = "https://telemetry.example.com/preflight"
+	assert.Nil(t, NewTelemetrySender(cfg))
+	cfg.Install.Telemetry = config.Telemetry{Enabled: true}
+	assert.Nil(t, NewTelemetrySender(cfg))
+	loaded, err := config.LoadHarvesterConfig([]byte("install:\n  telemetry:\n    endpoint: https://telemetry.example.com/preflight\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, NewTelemetrySender(loaded))
+
+	cfg.Install.Telemetry.Endpoint = "https://telemetry.example.com/preflight"
+	sender := NewTelemetrySender(cfg)
+	assert.NotNil(t, sender)
+	assert.Equal(t, "https://telemetry.example.com/preflight", sender.Endpoint)
+	assert.Len(t, sender.InstallID, 32)
+	assert.NotEqual(t, sender.InstallID, NewTelemetrySender(cfg).InstallID)
+
+	// A nil sender sends nothing
+	var disabled *TelemetrySender
+	assert.Nil(t, disabled.Send(context.Background(), Report{}))
+	<-disabled.SendInBackground(context.Background(), Report{})
+}
+
+func TestTelemetrySend(t *testing.T) {
+	var received TelemetryPayload
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	report, identifying := identifyingReport()
+	sender := &TelemetrySender{Endpoint: server.URL, InstallID: "install-1", InstallerVersion: "v1.4.0"}
+	assert.Nil(t, sender.Send(context.Background(), report))
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, Anonymize(report, "install-1", "v1.4.0"), received)
+	data, _ := json.Marshal(received)
+	for _, id := range identifying {
+		assert.NotContains(t, string(data), id)
+	}
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	assert.ErrorContains(t, sender.Send(context.Background(), report), "503 Service Unavailable")
+
+	// Nothing is sent about an air-gapped run
+	requests := 0
+	server.Config.Handler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		requests++
+	})
+	report.AirGapped = true
+	assert.Nil(t, sender.Send(context.Background(), report))
+	assert.Equal(t, 0, requests)
+}
+
+func TestTelemetrySendTimeout(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	sender := &TelemetrySender{Endpoint: server.URL, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	assert.ErrorIs(t, sender.Send(context.Background(), Report{}), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// Sending in the background doesn't wait, and gives up on time
+	start = time.Now()
+	done := sender.SendInBackground(context.Background(), Report{})
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending telemetry in the background didn't give up")
+	}
+	assert.Equal(t, int32(2), requests.Load())
+
+	// An unreachable endpoint doesn't matter either
+	sender.Endpoint = "http://127.0.0.1:1/preflight"
+	<-sender.SendInBackground(context.Background(), Report{})
+}
@@ -0,0 +1,150 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultThermalWarnCelsius is the idle CPU temperature above which
+	// ThermalCheck warns
+	DefaultThermalWarnCelsius = 85
+)
+
+// cpuHwmonDrivers are the hwmon drivers which report CPU core and package
+// temperatures
+var cpuHwmonDrivers = []string{"coretemp", "k10temp", "zenpower"}
+
+// cpuThermalZones are the thermal zone types which are CPU temperatures
+var cpuThermalZones = []string{"x86_pkg_temp", "cpu-thermal", "cpu_thermal"}
+
+// A TempSensor is a temperature reading.
+type TempSensor struct {
+	Name    string
+	Celsius float64
+	// CPU is true for CPU core and package sensors
+	CPU bool
+}
+
+// ThermalCheck warns if any CPU temperature is above WarnCelsius
+// (DefaultThermalWarnCelsius if zero).  While the installer is running,
+// the machine is more or less idle, so if it's already that hot, there's
+// something wrong with the cooling, and it'll likely throttle badly during
+// the install.  The hottest sensor is recorded in the inventory.  Many VMs
+// have no sensors, in which case there's nothing to report.
+type ThermalCheck struct {
+	WarnCelsius float64
+}
+
+func (c ThermalCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c ThermalCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Thermal"
+	warn := c.WarnCelsius
+	if warn == 0 {
+		warn = DefaultThermalWarnCelsius
+	}
+
+	sensors, err := readTempSensors(env)
+	if err != nil || len(sensors) == 0 {
+		return
+	}
+
+	hottest := sensors[0]
+	var hot []string
+	for _, sensor := range sensors {
+		if sensor.Celsius > hottest.Celsius {
+			hottest = sensor
+		}
+		if sensor.CPU && sensor.Celsius >= warn {
+			hot = append(hot, fmt.Sprintf("%s at %.0f°C", sensor.Name, sensor.Celsius))
+		}
+	}
+	env.Inventory.HottestSensor = &hottest
+
+	if len(hot) > 0 {
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("CPU temperatures are at or above %.0f°C while the system is idle: %s. "+
+			"Please check the cooling, as the system is likely to throttle or shut down under load.",
+			warn, strings.Join(hot, ", "))
+		return
+	}
+	result.Message = fmt.Sprintf("Hottest temperature sensor is %s at %.0f°C.", hottest.Name, hottest.Celsius)
+	return
+}
+
+// readTempSensors reads the thermal zones and hwmon temperature inputs.
+// Sensors which can't be read (some return EIO or ENODATA when idle or
+// unsupported) are skipped.
+func readTempSensors(env *Env) ([]TempSensor, error) {
+	var sensors []TempSensor
+
+	zones, err := env.glob(filepath.Join(env.host().sysClassThermal, "thermal_zone*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, zone := range zones {
+		celsius, ok := readMilliCelsius(env, filepath.Join(zone, "temp"))
+		if !ok {
+			continue
+		}
+		typ := env.readTrimmed(filepath.Join(zone, "type"))
+		sensors = append(sensors, TempSensor{
+			Name:    fmt.Sprintf("%s (%s)", filepath.Base(zone), typ),
+			Celsius: celsius,
+			CPU:     slices.Contains(cpuThermalZones, typ),
+		})
+	}
+
+	hwmons, err := env.glob(filepath.Join(env.host().sysClassHwmon, "hwmon*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, hwmon := range hwmons {
+		driver := env.readTrimmed(filepath.Join(hwmon, "name"))
+		inputs, err := env.glob(filepath.Join(hwmon, "temp*_input"))
+		if err != nil {
+			return nil, err
+		}
+		for _, input := range inputs {
+			celsius, ok := readMilliCelsius(env, input)
+			if !ok {
+				continue
+			}
+			label := env.readTrimmed(strings.TrimSuffix(input, "_input") + "_label")
+			if label == "" {
+				label = strings.TrimSuffix(filepath.Base(input), "_input")
+			}
+			sensors = append(sensors, TempSensor{
+				Name:    fmt.Sprintf("%s %s", driver, label),
+				Celsius: celsius,
+				CPU:     slices.Contains(cpuHwmonDrivers, driver),
+			})
+		}
+	}
+	return sensors, nil
+}
+
+func readMilliCelsius(env *Env, path string) (float64, bool) {
+	value, err := strconv.ParseInt(env.readTrimmed(path), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return float64(value) / 1000, true
+}
+
+// readTrimmed returns the contents of a sysfs attribute, or "" if it
+// can't be read.
+func (e *Env) readTrimmed(path string) string {
+	out, err := e.readFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
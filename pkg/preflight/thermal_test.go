@@ -0,0 +1,61 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThermalCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture string
+		warn    float64
+		hottest *TempSensor
+		result  Result
+	}{
+		{
+			fixture: "cool",
+			hottest: &TempSensor{Name: "nvme Composite", Celsius: 44.85},
+			result:  Result{Name: "Thermal", Message: "Hottest temperature sensor is nvme Composite at 45°C."},
+		},
+		{
+			fixture: "cool",
+			warn:    40,
+			hottest: &TempSensor{Name: "nvme Composite", Celsius: 44.85},
+			result: Result{
+				Name:     "Thermal",
+				Severity: SeverityWarning,
+				Message: "CPU temperatures are at or above 40°C while the system is idle: " +
+					"thermal_zone1 (x86_pkg_temp) at 41°C, coretemp Package id 0 at 41°C, coretemp Core 1 at 40°C. " +
+					"Please check the cooling, as the system is likely to throttle or shut down under load.",
+			},
+		},
+		{
+			fixture: "hot",
+			hottest: &TempSensor{Name: "k10temp Tctl", Celsius: 91.25, CPU: true},
+			result: Result{
+				Name:     "Thermal",
+				Severity: SeverityWarning,
+				Message: "CPU temperatures are at or above 85°C while the system is idle: k10temp Tctl at 91°C, k10temp Tccd1 at 88°C. " +
+					"Please check the cooling, as the system is likely to throttle or shut down under load.",
+			},
+		},
+		{
+			fixture: "vm",
+			result:  Result{Name: "Thermal"},
+		},
+	}
+
+	for _, test := range tests {
+		h.sysClassThermal = "./testdata/thermal/" + test.fixture + "/sys/class/thermal"
+		h.sysClassHwmon = "./testdata/thermal/" + test.fixture + "/sys/class/hwmon"
+		env := &Env{machine: h}
+		result, err := ThermalCheck{WarnCelsius: test.warn}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, test.result, result, test.fixture)
+		assert.Equal(t, test.hottest, env.Inventory.HottestSensor, test.fixture)
+	}
+}
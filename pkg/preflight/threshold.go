@@ -0,0 +1,139 @@
+package preflight
+
+import (
+	"cmp"
+	"context"
+	"slices"
+)
+
+// A thresholdCheck is a hardware requirement check which measures one
+// quantity and compares it against the minimum for testing and the
+// higher one for production, in the two-tier wording of BelowTest and
+// BelowProd.  Falling short of the minimum for testing is fatal, and of
+// that for production a warning, so that the installer can tell which
+// should stop it.  Adding a numeric check is a matter of writing
+// its Measure and an entry like those below.
+type thresholdCheck struct {
+	// Name is that of the check's Result, e.g. "CPU".
+	Name string
+	// Quantity and Unit are what's measured, e.g. "Memory" and "GiB",
+	// which name the minima in the Result's Thresholds, e.g.
+	// "minMemoryGiBTest".
+	Quantity string
+	Unit     string
+	// ValueUnit is the unit of the Result's Measurement, if Unit is
+	// empty because the Quantity says it, e.g. "cores".
+	ValueUnit string
+	// Minima returns the minima for testing and production, in Unit,
+	// from the node's Thresholds.
+	Minima func(Thresholds) (test, prod int)
+	// Measure measures the quantity on the host, in Unit.
+	Measure func(ctx context.Context, env *Env) (measurement, error)
+	// BelowTest and BelowProd are the messages given, with their
+	// severities, if the measurement is below the minimum for testing,
+	// or only that for production.  BelowTest is rendered from the
+	// measurement's Subject, the minima for testing and production, and
+	// the kind of node, and BelowProd from the Subject, the minimum for
+	// production, and the kind of node.
+	BelowTest MessageTemplate
+	BelowProd MessageTemplate
+}
+
+// A measurement is what a thresholdCheck's Measure found.
+type measurement struct {
+	// Value is what's compared against the minima.
+	Value float64
+	// Tolerance is the fraction of the minima which is enough, if not
+	// all of them, for measurements known to read a bit low.
+	Tolerance float64
+	// Facts are recorded in the Result.
+	Facts map[string]any
+	// Device is what was measured, if the check is of a device, e.g. the
+	// NIC.
+	Device string
+	// Subject is what the messages say was measured, e.g. "16 CPU
+	// cores".  ProdSubject replaces it in BelowProd, if set.
+	Subject     []any
+	ProdSubject []any
+	// Unmeasurable, if set, is the message given instead of comparing
+	// Value with the minima, because the quantity couldn't be measured,
+	// e.g. of a NIC without a link.  It's rendered like BelowProd.
+	Unmeasurable *MessageTemplate
+}
+
+var (
+	cpuThresholdCheck = thresholdCheck{
+		Name:      "CPU",
+		Quantity:  "CPU",
+		ValueUnit: "cores",
+		Minima:    func(t Thresholds) (int, int) { return t.MinCPUTest, t.MinCPUProd },
+		BelowTest: cpuBelowTestMessage,
+		BelowProd: cpuBelowProdMessage,
+	}
+	memoryThresholdCheck = thresholdCheck{
+		Name:      "Memory",
+		Quantity:  "Memory",
+		Unit:      "GiB",
+		Minima:    func(t Thresholds) (int, int) { return t.MinMemoryGiBTest, t.MinMemoryGiBProd },
+		BelowTest: memoryBelowTestMessage,
+		BelowProd: memoryBelowProdMessage,
+	}
+	networkSpeedThresholdCheck = thresholdCheck{
+		Name:      "NetworkSpeed",
+		Quantity:  "Network",
+		Unit:      "Gbps",
+		Minima:    func(t Thresholds) (int, int) { return t.MinNetworkGbpsTest, t.MinNetworkGbpsProd },
+		BelowTest: networkSpeedBelowTestMessage,
+		BelowProd: networkSpeedBelowProdMessage,
+	}
+)
+
+// Evaluate measures the quantity, and compares it against the minima for
+// the node's role.  The measurement's Facts and the minima are recorded
+// in the Result, as is the Measurement they were compared with, unless
+// measuring fails, or the measurement is Unmeasurable.
+func (c thresholdCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = c.Name
+	m, err := c.Measure(ctx, env)
+	if err != nil {
+		return
+	}
+	test, prod := c.Minima(env.Options.thresholds(env.host().goarch))
+	key := "min" + c.Quantity + c.Unit
+	result.Facts = m.Facts
+	result.Thresholds = map[string]any{key + "Test": test, key + "Prod": prod}
+	tolerance := m.Tolerance
+	if tolerance == 0 {
+		tolerance = 1
+	}
+	result.Device = m.Device
+	node := env.Options.roleNode()
+	if m.Unmeasurable != nil {
+		result.Severity = m.Unmeasurable.Severity
+		result.Message = m.Unmeasurable.render(append(slices.Clone(m.Subject), prod, node)...)
+		return
+	}
+	result.Measurement = &Measurement{
+		Quantity: c.Quantity,
+		Value:    m.Value,
+		Unit:     cmp.Or(c.Unit, c.ValueUnit),
+		MinTest:  test,
+		MinProd:  prod,
+	}
+	if tolerance != 1 {
+		result.Measurement.Tolerance = tolerance
+	}
+	switch {
+	case m.Value < float64(test)*tolerance:
+		result.Severity = c.BelowTest.Severity
+		result.Message = c.BelowTest.render(append(slices.Clone(m.Subject), test, prod, node)...)
+	case m.Value < float64(prod)*tolerance:
+		subject := m.Subject
+		if m.ProdSubject != nil {
+			subject = m.ProdSubject
+		}
+		result.Severity = c.BelowProd.Severity
+		result.Message = c.BelowProd.render(append(slices.Clone(subject), prod, node)...)
+	}
+	return
+}
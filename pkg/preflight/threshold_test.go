@@ -0,0 +1,91 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdCheck(t *testing.T) {
+	var m measurement
+	check := thresholdCheck{
+		Name:     "Widgets",
+		Quantity: "Widget",
+		Unit:     "Count",
+		Minima:   func(t Thresholds) (int, int) { return t.MinCPUTest, t.MinCPUProd },
+		Measure:  func(context.Context, *Env) (measurement, error) { return m, nil },
+		BelowTest: MessageTemplate{Severity: SeverityWarning,
+			Format: "Only %s widgets. At least %d for testing and %d for production use of %s."},
+		BelowProd: MessageTemplate{Severity: SeverityFatal,
+			Format: "%s widgets. At least %d for production use of %s."},
+	}
+	env := &Env{Options: Options{Thresholds: Thresholds{MinCPUTest: 8, MinCPUProd: 16}}}
+	thresholds := map[string]any{"minWidgetCountTest": 8, "minWidgetCountProd": 16}
+
+	tests := []struct {
+		value       float64
+		tolerance   float64
+		prodSubject []any
+		severity    Severity
+		message     string
+	}{
+		{value: 0, severity: SeverityWarning,
+			message: "Only 0 widgets. At least 8 for testing and 16 for production use of a management node."},
+		{value: 7.99, severity: SeverityWarning,
+			message: "Only 7.99 widgets. At least 8 for testing and 16 for production use of a management node."},
+		// The minima themselves are enough
+		{value: 8, severity: SeverityFatal, message: "8 widgets. At least 16 for production use of a management node."},
+		{value: 15.99, severity: SeverityFatal, message: "15.99 widgets. At least 16 for production use of a management node."},
+		{value: 16, severity: SeverityOK},
+		{value: 1000, severity: SeverityOK},
+		// Tolerance lowers both minima
+		{value: 7.2, tolerance: 0.9, severity: SeverityFatal,
+			message: "7.2 widgets. At least 16 for production use of a management node."},
+		{value: 7.19, tolerance: 0.9, severity: SeverityWarning,
+			message: "Only 7.19 widgets. At least 8 for testing and 16 for production use of a management node."},
+		{value: 14.4, tolerance: 0.9, severity: SeverityOK},
+		// The subject may be put differently for production
+		{value: 10, prodSubject: []any{"ten"}, severity: SeverityFatal,
+			message: "ten widgets. At least 16 for production use of a management node."},
+		{value: 1, prodSubject: []any{"ten"}, severity: SeverityWarning,
+			message: "Only 1 widgets. At least 8 for testing and 16 for production use of a management node."},
+	}
+	for _, tt := range tests {
+		m = measurement{Value: tt.value, Tolerance: tt.tolerance, Facts: map[string]any{"widgets": tt.value},
+			Device: "widget0", Subject: []any{fmt.Sprint(tt.value)}, ProdSubject: tt.prodSubject}
+		result, err := check.Evaluate(context.Background(), env)
+		assert.Nil(t, err)
+		tolerance := tt.tolerance
+		if tolerance == 1 {
+			tolerance = 0
+		}
+		assert.Equal(t, Result{
+			Name:       "Widgets",
+			Severity:   tt.severity,
+			Message:    tt.message,
+			Device:     "widget0",
+			Facts:      map[string]any{"widgets": tt.value},
+			Thresholds: thresholds,
+			Measurement: &Measurement{Quantity: "Widget", Value: tt.value, Unit: "Count", MinTest: 8, MinProd: 16,
+				Tolerance: tolerance},
+		}, result, "%g (tolerance %g)", tt.value, tt.tolerance)
+	}
+
+	// The minima are those for the node's role
+	env.Options = Options{Role: RoleWitness}
+	m = measurement{Value: 3, Subject: []any{"3"}}
+	result, err := check.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, "3 widgets. At least 4 for production use of a witness node.", result.Message)
+	assert.Equal(t, map[string]any{"minWidgetCountTest": 2, "minWidgetCountProd": 4}, result.Thresholds)
+	assert.Equal(t, &Measurement{Quantity: "Widget", Value: 3, Unit: "Count", MinTest: 2, MinProd: 4}, result.Measurement)
+
+	// If it can't be measured, there's nothing to compare
+	check.Measure = func(context.Context, *Env) (measurement, error) { return m, errors.New("oops") }
+	result, err = check.Evaluate(context.Background(), env)
+	assert.EqualError(t, err, "oops")
+	assert.Equal(t, Result{Name: "Widgets"}, result)
+}
@@ -0,0 +1,259 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// Time sync daemons, as systemd units
+const (
+	timesyncdService = "systemd-timesyncd"
+	chronydService   = "chronyd"
+)
+
+// TimeSyncServiceCheck verifies that the installed system will keep its
+// clock in sync.  The install configuration enables systemd-timesyncd
+// when it has NTP servers, but it can also enable chronyd by writing its
+// configuration and enabling it from a chroot command.  No NTP servers at
+// all, or both daemons enabled (they'll fight over the clock), are warned
+// about.  The servers the installed system will use, and the daemon the
+// live environment uses, are reported.
+type TimeSyncServiceCheck struct {
+	// NTPServers are the servers for systemd-timesyncd.
+	NTPServers []string
+	// ChronyServers are the servers (and pools) in chrony configuration
+	// written by the install configuration.
+	ChronyServers []string
+	// ChronyEnabled means the install configuration enables chronyd.
+	ChronyEnabled bool
+}
+
+// NewTimeSyncServiceCheck returns a TimeSyncServiceCheck for the time sync
+// settings in the given install configuration.
+func NewTimeSyncServiceCheck(cfg *config.HarvesterConfig) TimeSyncServiceCheck {
+	c := TimeSyncServiceCheck{NTPServers: cfg.OS.NTPServers}
+	for _, file := range cfg.OS.WriteFiles {
+		if file.Path != "/etc/chrony.conf" {
+			if matched, _ := path.Match("/etc/chrony.d/*.conf", file.Path); !matched {
+				continue
+			}
+		}
+		content, err := writeFileContent(file)
+		if err != nil {
+			continue
+		}
+		c.ChronyServers = append(c.ChronyServers, parseChronyServers(content)...)
+	}
+	for _, command := range cfg.OS.AfterInstallChrootCommands {
+		fields := strings.Fields(command)
+		if slices.Contains(fields, "systemctl") && slices.Contains(fields, "enable") &&
+			(slices.Contains(fields, chronydService) || slices.Contains(fields, chronydService+".service")) {
+			c.ChronyEnabled = true
+		}
+	}
+	return c
+}
+
+// parseChronyServers returns the addresses of the server, pool and peer
+// directives in chrony.conf(5) content.
+func parseChronyServers(content string) []string {
+	var servers []string
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && (fields[0] == "server" || fields[0] == "pool" || fields[0] == "peer") {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+func (c TimeSyncServiceCheck) probes(env *Env) []toolCall {
+	return []toolCall{
+		{"/usr/bin/systemctl", "is-active", "--quiet", timesyncdService + ".service"},
+		{"/usr/bin/systemctl", "is-active", "--quiet", chronydService + ".service"},
+	}
+}
+
+func (c TimeSyncServiceCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "TimeSyncService"
+
+	var live []string
+	for _, service := range []string{timesyncdService, chronydService} {
+		if env.succeeds("/usr/bin/systemctl", "is-active", "--quiet", service+".service") {
+			live = append(live, service)
+		}
+	}
+	liveMsg := "No time sync daemon is running in the live environment."
+	if len(live) > 0 {
+		liveMsg = fmt.Sprintf("The live environment uses %s.", strings.Join(live, " and "))
+	}
+	var hints []string
+	var actions []RemediationAction
+	defer func() {
+		// Nothing needs fixing if the installed system will be fine
+		if result.Severity < SeverityWarning {
+			return
+		}
+		if len(live) == 0 {
+			actions = append(actions, RemediationAction{Kind: RemediationServiceEnable, Target: timesyncdService + ".service"})
+			hints = append(hints, fmt.Sprintf("Start %s in the live environment with: %s.", timesyncdService, commands(actions)))
+		}
+		if len(hints) > 0 {
+			result.Remediation = &Remediation{Hint: strings.Join(hints, " "), Actions: actions}
+		}
+	}()
+
+	var enabled, servers []string
+	if len(c.NTPServers) > 0 {
+		enabled = append(enabled, timesyncdService)
+		servers = append(servers, c.NTPServers...)
+	}
+	if c.ChronyEnabled {
+		enabled = append(enabled, chronydService)
+		servers = append(servers, c.ChronyServers...)
+	}
+
+	switch {
+	case len(servers) == 0:
+		result.Severity = SeverityWarning
+		result.Message = "No NTP servers are configured, so the installed system's clock will drift. " +
+			"Please set os.ntp_servers in the install configuration."
+		hints = append(hints, "Set os.ntp_servers in the install configuration to the site's NTP servers.")
+	case len(enabled) > 1:
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("The install configuration enables both %s and %s, which will fight over the clock. "+
+			"Please use only one of them. NTP servers: %s.", timesyncdService, chronydService, strings.Join(servers, ", "))
+		hints = append(hints, fmt.Sprintf("Remove os.ntp_servers from the install configuration, or the command enabling %s from os.after_install_chroot_commands.",
+			chronydService))
+	default:
+		result.Message = fmt.Sprintf("The installed system will use %s with NTP servers: %s.",
+			enabled[0], strings.Join(servers, ", "))
+	}
+	result.Message += " " + liveMsg
+	return
+}
+
+// DefaultMaxClockOffset is how far the clock may be from the configured
+// NTP servers' before it's warned about.
+const DefaultMaxClockOffset = 30 * time.Second
+
+// TimeSyncCheck verifies that the live environment's clock is being kept
+// in sync, as a badly skewed clock makes joining a cluster fail in
+// confusing ways, through expired tokens and certificates which aren't
+// valid yet.  NTP being disabled, or the clock not being synchronized, as
+// timedatectl reports them, are warned about.  Unless SkipNetwork is set,
+// the first of the configured NTP servers which answers is queried with
+// SNTP, and an offset of more than MaxOffset from it is warned about, as
+// is having no NTP servers at all when air-gapped, since then nothing
+// will keep the clock in sync.  Whether the servers answer at all is
+// ConfiguredNTPCheck's business.
+type TimeSyncCheck struct {
+	Servers  []string
+	Resolver hostResolver
+	// Port is the NTP port, which is only changed by tests.
+	Port      int
+	Timeout   time.Duration
+	MaxOffset time.Duration
+	// SkipNetwork means no NTP server is queried, e.g. in CI.
+	SkipNetwork bool
+}
+
+// NewTimeSyncCheck returns a TimeSyncCheck for the NTP servers in the
+// given install configuration.
+func NewTimeSyncCheck(cfg *config.HarvesterConfig) TimeSyncCheck {
+	return TimeSyncCheck{
+		Servers:   cfg.OS.NTPServers,
+		Resolver:  net.DefaultResolver,
+		Port:      ntpPort,
+		Timeout:   ntpTimeout,
+		MaxOffset: DefaultMaxClockOffset,
+	}
+}
+
+func (c TimeSyncCheck) Modes() []RunMode {
+	return anyMode
+}
+
+func (c TimeSyncCheck) probes(env *Env) []toolCall {
+	return []toolCall{{"/usr/bin/timedatectl", "show", "--property=NTPSynchronized,NTP"}}
+}
+
+func (c TimeSyncCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "TimeSync"
+	var msgs []string
+	warn := func(msg string) {
+		result.Severity = SeverityWarning
+		msgs = append(msgs, msg)
+	}
+	defer func() {
+		result.Message = strings.Join(msgs, " ")
+	}()
+
+	out, err := env.output("/usr/bin/timedatectl", "show", "--property=NTPSynchronized,NTP")
+	switch {
+	case errors.Is(err, ErrToolMissing):
+		msgs = append(msgs, "Unable to determine whether the clock is synchronized, because timedatectl is not installed.")
+	case err != nil:
+		return
+	default:
+		properties := map[string]string{}
+		for _, line := range strings.Split(string(out), "\n") {
+			if key, value, ok := strings.Cut(line, "="); ok {
+				properties[key] = value
+			}
+		}
+		switch {
+		case properties["NTP"] != "yes":
+			warn("NTP is disabled, so the clock is not being kept in sync.")
+			result.Remediation = &Remediation{Hint: "Enable NTP with: timedatectl set-ntp true."}
+		case properties["NTPSynchronized"] != "yes":
+			warn("The clock is not synchronized with NTP.")
+		}
+	}
+	err = nil
+
+	switch {
+	case c.SkipNetwork:
+	case len(c.Servers) == 0 && env.Options.AirGapped:
+		warn("No NTP servers are configured, so nothing will keep the clock in sync. An air-gapped host needs NTP servers on site.")
+		result.AirGapped = true
+	case len(c.Servers) > 0:
+		queried := false
+		for _, server := range c.Servers {
+			if env.Options.AirGapped && onInternet(server) {
+				result.AirGapped = true
+				continue
+			}
+			queried = true
+			addr, offset, queryErr := queryNTPServer(ctx, c.Resolver, server, c.Port, c.Timeout, env.host().now)
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			if queryErr != nil {
+				continue
+			}
+			result.Facts = map[string]any{"server": server, "offset": offset.Seconds()}
+			if offset > c.MaxOffset || offset < -c.MaxOffset {
+				warn(fmt.Sprintf("The clock is %s off from NTP server %s (%s), more than the %s allowed.",
+					formatOffset(offset), server, addr, c.MaxOffset))
+				return
+			}
+			msgs = append(msgs, fmt.Sprintf("The clock is %s off from NTP server %s (%s).", formatOffset(offset), server, addr))
+			return
+		}
+		if queried {
+			msgs = append(msgs, "None of the configured NTP servers answered, so the clock's offset was not measured.")
+		} else {
+			msgs = append(msgs, "The configured NTP servers are all on the internet, so the clock's offset was not measured in "+airGappedMode+".")
+		}
+	}
+	return
+}
@@ -0,0 +1,195 @@
+package preflight
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestTimeSyncServiceCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture     string
+		live        []string
+		check       TimeSyncServiceCheck
+		severity    Severity
+		message     string
+		remediation *Remediation
+	}{
+		{
+			fixture: "timesyncd",
+			live:    []string{"systemd-timesyncd.service"},
+			check:   TimeSyncServiceCheck{NTPServers: []string{"0.suse.pool.ntp.org", "1.suse.pool.ntp.org"}},
+			message: "The installed system will use systemd-timesyncd with NTP servers: 0.suse.pool.ntp.org, 1.suse.pool.ntp.org. " +
+				"The live environment uses systemd-timesyncd.",
+		},
+		{
+			fixture: "chrony",
+			live:    []string{"chronyd.service"},
+			check:   TimeSyncServiceCheck{ChronyServers: []string{"ntp1.example.com", "ntp2.example.com"}, ChronyEnabled: true},
+			message: "The installed system will use chronyd with NTP servers: ntp1.example.com, ntp2.example.com. " +
+				"The live environment uses chronyd.",
+		},
+		{
+			fixture:  "nothing",
+			severity: SeverityWarning,
+			message: "No NTP servers are configured, so the installed system's clock will drift. " +
+				"Please set os.ntp_servers in the install configuration. No time sync daemon is running in the live environment.",
+			remediation: &Remediation{
+				Hint: "Set os.ntp_servers in the install configuration to the site's NTP servers. " +
+					"Start systemd-timesyncd in the live environment with: /usr/bin/systemctl enable --now systemd-timesyncd.service.",
+				Actions: []RemediationAction{{Kind: RemediationServiceEnable, Target: "systemd-timesyncd.service"}},
+			},
+		},
+		{
+			fixture: "conflicting",
+			live:    []string{"systemd-timesyncd.service"},
+			check: TimeSyncServiceCheck{
+				NTPServers:    []string{"0.suse.pool.ntp.org"},
+				ChronyServers: []string{"2.suse.pool.ntp.org"},
+				ChronyEnabled: true,
+			},
+			severity: SeverityWarning,
+			message: "The install configuration enables both systemd-timesyncd and chronyd, which will fight over the clock. " +
+				"Please use only one of them. NTP servers: 0.suse.pool.ntp.org, 2.suse.pool.ntp.org. " +
+				"The live environment uses systemd-timesyncd.",
+			remediation: &Remediation{
+				Hint: "Remove os.ntp_servers from the install configuration, or the command enabling chronyd from os.after_install_chroot_commands.",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		data, err := os.ReadFile("./testdata/time-sync/" + test.fixture + ".yaml")
+		assert.Nil(t, err, test.fixture)
+		cfg, err := config.LoadHarvesterConfig(data)
+		assert.Nil(t, err, test.fixture)
+		check := NewTimeSyncServiceCheck(cfg)
+		assert.Equal(t, test.check, check, test.fixture)
+
+		command := func(_ string, args ...string) *exec.Cmd {
+			for _, service := range test.live {
+				if args[len(args)-1] == service {
+					return fakeExecCommand("systemctl-active")
+				}
+			}
+			return fakeExecCommand("systemctl-fail")
+		}
+		result, err := check.Evaluate(context.Background(), &Env{execCommand: command, machine: h})
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "TimeSyncService", Severity: test.severity, Message: test.message, Remediation: test.remediation},
+			result, test.fixture)
+	}
+}
+
+func TestNewTimeSyncCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.OS.NTPServers = []string{"0.suse.pool.ntp.org"}
+	assert.Equal(t, TimeSyncCheck{
+		Servers:   cfg.OS.NTPServers,
+		Resolver:  net.DefaultResolver,
+		Port:      ntpPort,
+		Timeout:   ntpTimeout,
+		MaxOffset: DefaultMaxClockOffset,
+	}, NewTimeSyncCheck(cfg))
+}
+
+func TestTimeSyncCheck(t *testing.T) {
+	h := testHost()
+	fixed := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	h.now = func() time.Time { return fixed }
+
+	port := freeUDPPort(t)
+	newFakeSNTPServer(t, "127.0.0.1", port, sntpOK, fixed.Add(2*time.Second))
+	newFakeSNTPServer(t, "127.0.0.2", port, sntpOK, fixed.Add(-45*time.Second))
+	newFakeSNTPServer(t, "127.0.0.3", port, sntpSilent, fixed)
+	resolver := fakeResolver{
+		"ntp1.example.com":   {"127.0.0.1"},
+		"skewed.example.com": {"127.0.0.2"},
+		"silent.example.com": {"127.0.0.3"},
+	}
+
+	tests := []struct {
+		name        string
+		timedatectl string
+		servers     []string
+		airGapped   bool
+		skipNetwork bool
+		result      Result
+	}{
+		{
+			name:        "synchronized",
+			timedatectl: "timedatectl-synced",
+			servers:     []string{"silent.example.com", "ntp1.example.com"},
+			result: Result{Message: "The clock is +2s off from NTP server ntp1.example.com (127.0.0.1).",
+				Facts: map[string]any{"server": "ntp1.example.com", "offset": 2.0}},
+		},
+		{
+			name:        "skewed",
+			timedatectl: "timedatectl-synced",
+			servers:     []string{"skewed.example.com", "ntp1.example.com"},
+			result: Result{Severity: SeverityWarning,
+				Message: "The clock is -45s off from NTP server skewed.example.com (127.0.0.2), more than the 30s allowed.",
+				Facts:   map[string]any{"server": "skewed.example.com", "offset": -45.0}},
+		},
+		{
+			name:        "unsynchronized",
+			timedatectl: "timedatectl-unsynced",
+			servers:     []string{"silent.example.com"},
+			result: Result{Severity: SeverityWarning,
+				Message: "The clock is not synchronized with NTP. " +
+					"None of the configured NTP servers answered, so the clock's offset was not measured."},
+		},
+		{
+			name:        "NTP disabled",
+			timedatectl: "timedatectl-ntp-off",
+			servers:     []string{"skewed.example.com"},
+			skipNetwork: true,
+			result: Result{Severity: SeverityWarning, Message: "NTP is disabled, so the clock is not being kept in sync.",
+				Remediation: &Remediation{Hint: "Enable NTP with: timedatectl set-ntp true."}},
+		},
+		{
+			name:        "no servers",
+			timedatectl: "timedatectl-synced",
+		},
+		{
+			name:        "no servers air-gapped",
+			timedatectl: "timedatectl-synced",
+			airGapped:   true,
+			result: Result{Severity: SeverityWarning, AirGapped: true,
+				Message: "No NTP servers are configured, so nothing will keep the clock in sync. An air-gapped host needs NTP servers on site."},
+		},
+		{
+			name:        "internet servers air-gapped",
+			timedatectl: "timedatectl-synced",
+			servers:     []string{"0.suse.pool.ntp.org"},
+			airGapped:   true,
+			result: Result{AirGapped: true,
+				Message: "The configured NTP servers are all on the internet, so the clock's offset was not measured in air-gapped mode."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := TimeSyncCheck{Servers: tt.servers, Resolver: resolver, Port: port, Timeout: 200 * time.Millisecond,
+				MaxOffset: DefaultMaxClockOffset, SkipNetwork: tt.skipNetwork}
+			env := &Env{Options: Options{AirGapped: tt.airGapped}, execCommand: fakeCommand(tt.timedatectl), machine: h}
+			result, err := check.Evaluate(context.Background(), env)
+			assert.Nil(t, err)
+			tt.result.Name = "TimeSync"
+			assert.Equal(t, tt.result, result)
+		})
+	}
+
+	env := &Env{execCommand: func(string, ...string) *exec.Cmd { return exec.Command("/nonexistent/timedatectl") }, machine: h}
+	result, err := TimeSyncCheck{SkipNetwork: true}.Evaluate(context.Background(), env)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Name: "TimeSync", Message: "Unable to determine whether the clock is synchronized, because timedatectl is not installed."}, result)
+}
@@ -0,0 +1,151 @@
+package preflight
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	// DefaultMinTokenEntropy is the fewest bits of entropy, as estimated
+	// by passwordEntropy, the cluster token may have, unless
+	// Options.MinTokenEntropy says otherwise.  The token is all it takes
+	// to join the cluster, so it needs more than a node password.
+	DefaultMinTokenEntropy = 64
+
+	minTokenLength = 16
+	// dictionaryPath is the word list, if the live environment has one
+	dictionaryPath = "usr/share/dict/words"
+	// quoteBreakers are the characters which end or escape quoting in
+	// shell or YAML, beyond whitespace
+	quoteBreakers = "'\"`$\\"
+)
+
+// TokenCheck verifies that the cluster token in the install
+// configuration is accepted by the join machinery, and isn't guessable,
+// since it's all it takes to join a node to the cluster.  Characters the
+// join machinery doesn't accept, and whitespace, quotes, backslashes,
+// backticks and dollar signs, which break shell and YAML quoting in the
+// scripts and files the token is copied into, are fatal.  A token which
+// is short, a dictionary word, an example from the documentation, or
+// below Options.MinTokenEntropy bits of entropy is warned about.  For
+// secure tokens, only the secret after the CA hash is assessed.  Like the
+// node password, the token never appears in messages, only its length
+// and the classes of characters it's made of.
+type TokenCheck struct {
+	Token string
+}
+
+// NewTokenCheck returns a TokenCheck for the cluster token in the given
+// install configuration.
+func NewTokenCheck(cfg *config.HarvesterConfig) TokenCheck {
+	return TokenCheck{Token: cfg.Token}
+}
+
+func (c TokenCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Token"
+	if c.Token == "" {
+		result.Message = "Skipped: the install configuration does not set a cluster token."
+		return
+	}
+	secret, subject := c.Token, "The cluster token"
+	if secureJoinTokenPattern.MatchString(c.Token) {
+		// K10<CA hash>::<user>:<password>, of which only the password is
+		// secret
+		_, secret, _ = strings.Cut(c.Token, "::")
+		if i := strings.LastIndex(secret, ":"); i >= 0 {
+			secret = secret[i+1:]
+		}
+		subject = "The secret of the secure cluster token"
+	}
+	minEntropy := env.Options.MinTokenEntropy
+	if minEntropy == 0 {
+		minEntropy = DefaultMinTokenEntropy
+	}
+	entropy := passwordEntropy(secret)
+	summary := fmt.Sprintf("%s is %s of %s, with an estimated %.0f bits of entropy.",
+		subject, pluralize(len([]rune(secret)), "character", "characters"), joinWithAnd(characterClasses(secret)), entropy)
+
+	var problems, weaknesses []string
+	if problem := joinTokenProblem(c.Token); problem != "" {
+		problems = append(problems, "the join machinery rejects it: "+problem)
+	}
+	if strings.ContainsFunc(c.Token, unicode.IsSpace) {
+		problems = append(problems, "it contains whitespace, which breaks shell and YAML quoting")
+	}
+	if strings.ContainsAny(c.Token, quoteBreakers) {
+		problems = append(problems, "it contains quotes, backslashes, backticks or dollar signs, which break shell and YAML quoting")
+	}
+	if len([]rune(secret)) < minTokenLength {
+		weaknesses = append(weaknesses, fmt.Sprintf("it is shorter than %d characters", minTokenLength))
+	}
+	switch {
+	case isCommonPassword(secret):
+		weaknesses = append(weaknesses, "it is one of the most common passwords, or an example from the documentation")
+	case isDictionaryWord(env, secret):
+		weaknesses = append(weaknesses, "it is a dictionary word")
+	case entropy < float64(minEntropy):
+		weaknesses = append(weaknesses, fmt.Sprintf("it has less than %d bits of entropy; use a longer token, or a wider range of characters", minEntropy))
+	}
+
+	switch {
+	case len(problems) > 0:
+		result.Severity = SeverityFatal
+		result.Message = fmt.Sprintf("%s The token cannot be used: %s.", summary, strings.Join(problems, ", and "))
+	case len(weaknesses) > 0:
+		result.Severity = SeverityWarning
+		result.Message = fmt.Sprintf("%s The token is weak: %s. Please choose another.", summary, strings.Join(weaknesses, ", and "))
+	default:
+		result.Message = summary
+	}
+	return
+}
+
+// characterClasses returns the classes of the characters in s, which say
+// what it's made of without giving it away.
+func characterClasses(s string) []string {
+	var classes []string
+	for _, class := range []struct {
+		name string
+		is   func(rune) bool
+	}{
+		{"lower case letters", func(r rune) bool { return r <= unicode.MaxASCII && unicode.IsLower(r) }},
+		{"upper case letters", func(r rune) bool { return r <= unicode.MaxASCII && unicode.IsUpper(r) }},
+		{"digits", func(r rune) bool { return r >= '0' && r <= '9' }},
+		{"symbols", func(r rune) bool { return r <= unicode.MaxASCII && (unicode.IsPunct(r) || unicode.IsSymbol(r)) }},
+		{"whitespace", unicode.IsSpace},
+		{"other characters", func(r rune) bool { return r > unicode.MaxASCII || unicode.IsControl(r) && !unicode.IsSpace(r) }},
+	} {
+		if strings.ContainsFunc(s, class.is) {
+			classes = append(classes, class.name)
+		}
+	}
+	return classes
+}
+
+// isDictionaryWord returns whether s, ignoring case and any digits and
+// punctuation at the end, is in the live environment's word list, if it
+// has one.
+func isDictionaryWord(env *Env, s string) bool {
+	f, err := os.Open(filepath.Join(env.host().root, dictionaryPath))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	stem := strings.TrimRightFunc(strings.ToLower(s), func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if word := strings.ToLower(strings.TrimSpace(scanner.Text())); word != "" && word == stem {
+			return true
+		}
+	}
+	return false
+}
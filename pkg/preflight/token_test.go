@@ -0,0 +1,105 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewTokenCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Token = "token"
+	assert.Equal(t, TokenCheck{Token: "token"}, NewTokenCheck(cfg))
+}
+
+func TestTokenCheck(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/token"
+
+	tests := []struct {
+		name       string
+		token      string
+		minEntropy int
+		severity   Severity
+		message    string
+	}{
+		{
+			name:    "none",
+			message: "Skipped: the install configuration does not set a cluster token.",
+		},
+		{
+			name:    "strong random",
+			token:   "q8Vz3mKx7TfR2wLp9NbH4sJd",
+			message: "The cluster token is 24 characters of lower case letters, upper case letters and digits, with an estimated 143 bits of entropy.",
+		},
+		{
+			name:  "secure",
+			token: "K10" + "8d1a5a4b1e0fbd3f6a5c2e7d9b0a4c3e2f1d6b8a7c9e0f1a2b3c4d5e6f7a8b9c" + "::server:7c1b9e2f4a6d8c0e3b5a7f9d1c3e5b70",
+			message: "The secret of the secure cluster token is 32 characters of lower case letters and digits, " +
+				"with an estimated 124 bits of entropy.",
+		},
+		{
+			name:     "documentation example",
+			token:    "ClusterToken1",
+			severity: SeverityWarning,
+			message: "The cluster token is 13 characters of lower case letters, upper case letters and digits, with an estimated 74 bits of entropy. " +
+				"The token is weak: it is shorter than 16 characters, " +
+				"and it is one of the most common passwords, or an example from the documentation. Please choose another.",
+		},
+		{
+			name:     "dictionary word",
+			token:    "Watermelon2024!",
+			severity: SeverityWarning,
+			message: "The cluster token is 15 characters of lower case letters, upper case letters, digits and symbols, " +
+				"with an estimated 92 bits of entropy. " +
+				"The token is weak: it is shorter than 16 characters, and it is a dictionary word. Please choose another.",
+		},
+		{
+			name:     "short",
+			token:    "x7#Kq9",
+			severity: SeverityWarning,
+			message: "The cluster token is 6 characters of lower case letters, upper case letters, digits and symbols, " +
+				"with an estimated 39 bits of entropy. " +
+				"The token is weak: it is shorter than 16 characters, " +
+				"and it has less than 64 bits of entropy; use a longer token, or a wider range of characters. Please choose another.",
+		},
+		{
+			name:       "below the configured floor",
+			token:      "q8Vz3mKx7TfR2wLp9NbH4sJd",
+			minEntropy: 160,
+			severity:   SeverityWarning,
+			message: "The cluster token is 24 characters of lower case letters, upper case letters and digits, with an estimated 143 bits of entropy. " +
+				"The token is weak: it has less than 160 bits of entropy; use a longer token, or a wider range of characters. Please choose another.",
+		},
+		{
+			name:     "whitespace",
+			token:    "correct horse battery staple",
+			severity: SeverityFatal,
+			message: "The cluster token is 28 characters of lower case letters and whitespace, with an estimated 121 bits of entropy. " +
+				"The token cannot be used: it contains whitespace, which breaks shell and YAML quoting.",
+		},
+		{
+			name:     "quoting and rejected characters",
+			token:    "r4nd0m'T0k3n-with-é-in-1t",
+			severity: SeverityFatal,
+			message: "The cluster token is 25 characters of lower case letters, upper case letters, digits, symbols and other characters, " +
+				"with an estimated 138 bits of entropy. " +
+				"The token cannot be used: the join machinery rejects it: it must consist of letters, digits and OWASP special password characters, " +
+				"and it contains quotes, backslashes, backticks or dollar signs, which break shell and YAML quoting.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := TokenCheck{Token: tt.token}.Evaluate(context.Background(), &Env{Options: Options{MinTokenEntropy: tt.minEntropy}, machine: h})
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "Token", Severity: tt.severity, Message: tt.message}, result)
+			if tt.token != "" {
+				assert.NotContains(t, result.Message, tt.token, "the token must never appear in messages")
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxToolOutput is the most output kept from each external
+	// tool, unless Options.MaxToolOutput says otherwise.  It's far more
+	// than any of them prints on a sane host, but the live environment's
+	// RAM may be scarce, and firmware isn't always sane.
+	DefaultMaxToolOutput = 4 << 20
+
+	// gatherWorkers bounds how many external tools the inventory pass
+	// runs at once.  Most of them spend their time waiting on the kernel
+	// or firmware, so a few at a time helps even on small hosts.
+	gatherWorkers = 4
+	// maxToolLine bounds the lines scanned from a tool's output
+	maxToolLine = 64 << 10
+	// outputTruncatedMarker ends output which was cut short, so that it can't
+	// be mistaken for all of it
+	outputTruncatedMarker = "[output truncated]"
+	// maxToolStderr bounds what's kept of a tool's standard error, which
+	// is only kept as evidence
+	maxToolStderr = 16 << 10
+	// toolWaitDelay is how long a tool which was killed, because its
+	// context was done, may hold its output open, as a child which
+	// outlives it does, before it's given up on.  It's also how long a
+	// check whose time is up has to return before it's abandoned.
+	toolWaitDelay = time.Second
+)
+
+// errOutputTruncated is returned, wrapped, with output which was cut
+// short, because the tool printed more than the cap, or a longer line
+// than maxToolLine, but otherwise succeeded.  The output still ends with
+// outputTruncatedMarker.
+var errOutputTruncated = errors.New("output truncated")
+
+// An execFunc makes the command which runs an external tool, as
+// exec.Command does, which it stands in for if nil.  It's how the tools
+// the checks run are faked, without anything shared between runs.
+type execFunc func(name string, args ...string) *exec.Cmd
+
+func (f execFunc) cmd(name string, args ...string) *exec.Cmd {
+	if f == nil {
+		return exec.Command(name, args...)
+	}
+	return f(name, args...)
+}
+
+// cmdContext is like cmd, except that the command is killed if ctx is
+// done before it exits, as with exec.CommandContext.
+func (f execFunc) cmdContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return withContext(ctx, f.cmd(name, args...))
+}
+
+// withContext returns cmd made again with exec.CommandContext, keeping
+// its environment and directory, so that it's killed if ctx is done
+// before it exits, and waited for no longer than toolWaitDelay after.
+func withContext(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	if cmd.Err != nil {
+		// It can't be started anyway, and says why
+		return cmd
+	}
+	made := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+	made.Args[0] = cmd.Args[0]
+	made.Env = cmd.Env
+	made.Dir = cmd.Dir
+	made.WaitDelay = toolWaitDelay
+	return made
+}
+
+// A toolCall is an external tool, by path, followed by its arguments.
+type toolCall []string
+
+func (c toolCall) key() string {
+	return strings.Join(c, "\x00")
+}
+
+// command returns the command for the call, made by f, in the C
+// locale, since its output is parsed and has to be the same whatever the
+// live environment's locale is.  It's killed if ctx is done first.
+func (c toolCall) command(ctx context.Context, f execFunc) *exec.Cmd {
+	cmd := f.cmd(c[0], c[1:]...)
+	cmd.Env = append(cmd.Environ(), "LC_ALL=C")
+	return withContext(ctx, cmd)
+}
+
+// A toolRun is the outcome of one toolCall, shared by every check which
+// makes it.
+type toolRun struct {
+	once   sync.Once
+	out    []byte
+	stderr []byte
+	err    error
+}
+
+// A toolCache runs each distinct toolCall at most once per preflight run.
+// Forking is expensive on slow hardware, and many checks ask the same
+// tools the same questions about the host.  Only the first limit bytes
+// of each tool's output are kept.  A tool killed because the context it
+// was first run with was done stays failed, so that a tool which hangs
+// only holds up the first check which runs it.  It's safe for concurrent
+// use.
+type toolCache struct {
+	limit int64
+	exec  execFunc
+	mu    sync.Mutex
+	runs  map[string]*toolRun
+}
+
+// has returns true if call has been run, or is being run.
+func (c *toolCache) has(call toolCall) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.runs[call.key()]
+	return ok
+}
+
+func (c *toolCache) run(ctx context.Context, call toolCall) ([]byte, error) {
+	run := c.do(ctx, call)
+	return run.out, run.err
+}
+
+// do runs call with ctx, unless it's already been run, and returns its
+// outcome.
+func (c *toolCache) do(ctx context.Context, call toolCall) *toolRun {
+	c.mu.Lock()
+	if c.runs == nil {
+		c.runs = map[string]*toolRun{}
+	}
+	run, ok := c.runs[call.key()]
+	if !ok {
+		run = &toolRun{}
+		c.runs[call.key()] = run
+	}
+	c.mu.Unlock()
+	run.once.Do(func() {
+		limit := c.limit
+		if limit <= 0 {
+			limit = DefaultMaxToolOutput
+		}
+		var truncated bool
+		var stderr cappedBuffer
+		cmd := call.command(ctx, c.exec)
+		cmd.Stderr = &stderr
+		run.out, truncated, run.err = boundedOutput(cmd, limit)
+		run.stderr = stderr.Bytes()
+		run.err = toolError(call[0], run.err)
+		if truncated && run.err == nil {
+			run.err = fmt.Errorf("%s: %w after %d bytes", filepath.Base(call[0]), errOutputTruncated, limit)
+		}
+	})
+	return run
+}
+
+// A cappedBuffer keeps the first limit bytes written to it, or
+// maxToolStderr if limit is zero, and quietly drops the rest, counting
+// them.  The buffer isn't embedded, as its ReadFrom would let io.Copy
+// past the cap.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	limit   int64
+	dropped int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	limit := cmp.Or(b.limit, maxToolStderr)
+	kept := min(int64(len(p)), max(limit-int64(b.buf.Len()), 0))
+	b.buf.Write(p[:kept])
+	b.dropped += int64(len(p)) - kept
+	return len(p), nil
+}
+
+// Bytes returns what was kept.
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// boundedOutput is like cmd.Output, except that only the first limit bytes
+// of output are kept.  The rest is read and discarded, so that the
+// command isn't left blocked writing it, and the output kept ends with
+// outputTruncatedMarker.  It returns whether there was any more.  The
+// output is copied by exec, rather than read from cmd.StdoutPipe, so that
+// it's given up on with cmd.WaitDelay.
+func boundedOutput(cmd *exec.Cmd, limit int64) (out []byte, truncated bool, err error) {
+	stdout := &cappedBuffer{limit: limit}
+	cmd.Stdout = stdout
+	err = cmd.Run()
+	out = stdout.Bytes()
+	if stdout.dropped > 0 {
+		out = append(out, "\n"+outputTruncatedMarker+"\n"...)
+	}
+	return out, stdout.dropped > 0, err
+}
+
+// cache returns the Env's tool cache, creating it the first time.
+func (e *Env) cache() *toolCache {
+	if e.tools == nil {
+		e.tools = &toolCache{limit: e.Options.MaxToolOutput, exec: e.execCommand}
+	}
+	return e.tools
+}
+
+// output returns the standard output of an external tool which only reads
+// the state of the host, running it the first time it's asked for in the
+// preflight run.  The output is shared, so it mustn't be modified.  If it
+// was longer than Options.MaxToolOutput, the error wraps
+// errOutputTruncated, and if the tool isn't installed, it's
+// ErrToolMissing.
+//
+// Tools which change the host, or whose answer depends on when they're
+// asked, such as ping, arping and ip link add, are the escape hatch: the
+// checks which need them run them directly, every time, with command.
+func (e *Env) output(name string, args ...string) ([]byte, error) {
+	call := append(toolCall{name}, args...)
+	run := e.cache().do(e.context(), call)
+	e.recordCommand(call, run.out, run.stderr, run.err)
+	return run.out, run.err
+}
+
+// command returns the command which runs an external tool, for those
+// which aren't run through the Env's cache.
+func (e *Env) command(name string, args ...string) *exec.Cmd {
+	return e.execCommand.cmdContext(e.context(), name, args...)
+}
+
+// succeeds returns true if an external tool which only reads the state of
+// the host exits successfully, e.g. systemctl is-active.
+func (e *Env) succeeds(name string, args ...string) bool {
+	_, err := e.output(name, args...)
+	return err == nil
+}
+
+// scanOutput calls fn with each line of output of an external tool which
+// only reads the state of the host, until fn returns false.  It's for
+// parsers which only need a line at a time: the output isn't kept, so it
+// can be as long as it likes, although lines longer than maxToolLine are
+// cut short.  If the tool has already been run, e.g. in the inventory
+// pass, its output is scanned instead of running it again, unless the
+// tool cache kept only the first MaxToolOutput bytes of it, in which case
+// it's run again to stream the lot.  If anything was cut short, the error
+// wraps errOutputTruncated.  As with output, the error is ErrToolMissing
+// if the tool isn't installed.
+func (e *Env) scanOutput(fn func(line string) bool, name string, args ...string) (err error) {
+	call := append(toolCall{name}, args...)
+	if e.cache().has(call) {
+		if run := e.tools.do(e.context(), call); !errors.Is(run.err, errOutputTruncated) {
+			e.recordCommand(call, run.out, run.stderr, run.err)
+			err := run.err
+			if _, scanErr := scanLines(bytes.NewReader(run.out), fn); err == nil {
+				err = scanErr
+			}
+			return err
+		}
+	}
+
+	cmd := call.command(e.context(), e.execCommand)
+	if e.evidence != nil {
+		// Only what fn consumed is evidence, which can be far less than
+		// the tool printed
+		var consumed strings.Builder
+		var stderr cappedBuffer
+		cmd.Stderr = &stderr
+		scan := fn
+		fn = func(line string) bool {
+			if consumed.Len() <= e.evidence.limit {
+				consumed.WriteString(line + "\n")
+			}
+			return scan(line)
+		}
+		defer func() {
+			e.recordCommand(call, []byte(consumed.String()), stderr.Bytes(), err)
+		}()
+	}
+	// Through a pipe exec copies to, as boundedOutput's output is, so that
+	// it's given up on with cmd.WaitDelay
+	stdout, w := io.Pipe()
+	cmd.Stdout = w
+	if err := cmd.Start(); err != nil {
+		return toolError(name, err)
+	}
+	waited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		w.Close()
+		waited <- err
+	}()
+	truncated, scanErr := scanLines(stdout, fn)
+	_, discardErr := io.Copy(io.Discard, stdout)
+	err = errors.Join(<-waited, scanErr, discardErr)
+	if truncated && err == nil {
+		err = fmt.Errorf("%s: %w, with a line longer than %d bytes", filepath.Base(name), errOutputTruncated, maxToolLine)
+	}
+	return err
+}
+
+// scanLines calls fn with each line read from r, without its line ending,
+// until fn returns false.  Only a line at a time is held.  Lines longer
+// than maxToolLine are cut short, ending with outputTruncatedMarker, and the
+// rest of them skipped; it returns whether any were.
+func scanLines(r io.Reader, fn func(line string) bool) (truncated bool, err error) {
+	reader := bufio.NewReaderSize(r, maxToolLine)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line := string(chunk)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			truncated = true
+			line += " " + outputTruncatedMarker
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = reader.ReadSlice('\n')
+			}
+		}
+		if len(chunk) > 0 && !fn(strings.TrimRight(line, "\r\n")) {
+			return truncated, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return truncated, nil
+		} else if err != nil {
+			return truncated, err
+		}
+	}
+}
+
+// An inventoryProber is a check which knows up front which external tools
+// it will run, so that the Runner can run them in the inventory pass
+// before any check is evaluated.  Tools a check only runs depending on
+// what it finds are left to run when they're asked for.
+type inventoryProber interface {
+	probes(env *Env) []toolCall
+}
+
+// gather is the inventory pass: it runs each distinct tool the checks
+// probe with once, a few at a time, into the tool cache, so that the
+// checks then only read the results.  Each tool may take as long as a
+// check may, Options.CheckTimeout.
+func (e *Env) gather(ctx context.Context, checks []ResultCheck) {
+	cache := e.cache()
+	seen := map[string]bool{}
+	var calls []toolCall
+	for _, check := range checks {
+		prober, ok := check.(inventoryProber)
+		if !ok {
+			continue
+		}
+		for _, call := range prober.probes(e) {
+			if !seen[call.key()] {
+				seen[call.key()] = true
+				calls = append(calls, call)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	queue := make(chan toolCall)
+	for i := 0; i < min(gatherWorkers, len(calls)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for call := range queue {
+				callCtx, cancel := context.WithTimeout(ctx, e.Options.checkTimeout())
+				_, _ = cache.run(callCtx, call)
+				cancel()
+			}
+		}()
+	}
+	for _, call := range calls {
+		if ctx.Err() != nil {
+			// The checks will run whatever's left as they need it, and
+			// see the context is done themselves
+			break
+		}
+		queue <- call
+	}
+	close(queue)
+	wg.Wait()
+}
@@ -0,0 +1,248 @@
+package preflight
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingExecCommand fakes every tool with the output for key, or for
+// the key in tools for the tool, if any, counting the processes spawned by
+// tool and arguments.
+type countingExecCommand struct {
+	key    string
+	tools  map[string]string
+	mu     sync.Mutex
+	spawns map[string]int
+	total  atomic.Int64
+}
+
+func (c *countingExecCommand) command(name string, args ...string) *exec.Cmd {
+	c.mu.Lock()
+	if c.spawns == nil {
+		c.spawns = map[string]int{}
+	}
+	c.spawns[strings.Join(append([]string{name}, args...), " ")]++
+	c.mu.Unlock()
+	c.total.Add(1)
+	if key, ok := c.tools[filepath.Base(name)]; ok {
+		return fakeExecCommand(key)
+	}
+	return fakeExecCommand(c.key)
+}
+
+func TestEnvOutput(t *testing.T) {
+	counter := &countingExecCommand{key: "systemctl-version-suse"}
+
+	env := &Env{execCommand: counter.command}
+	for i := 0; i < 3; i++ {
+		out, err := env.output("/usr/bin/systemctl", "--version")
+		assert.Nil(t, err)
+		assert.Contains(t, string(out), "systemd 249")
+	}
+	assert.True(t, env.succeeds("/usr/bin/systemctl", "is-active", "--quiet", "dbus.service"))
+	assert.Equal(t, map[string]int{
+		"/usr/bin/systemctl --version":                      1,
+		"/usr/bin/systemctl is-active --quiet dbus.service": 1,
+	}, counter.spawns)
+
+	// Failures are kept too
+	counter = &countingExecCommand{key: "dmidecode-fail"}
+	env = &Env{execCommand: counter.command}
+	assert.False(t, env.succeeds("/usr/sbin/modprobe", "--dry-run", "vfio-pci"))
+	assert.False(t, env.succeeds("/usr/sbin/modprobe", "--dry-run", "vfio-pci"))
+	assert.EqualValues(t, 1, counter.total.Load())
+}
+
+func TestGather(t *testing.T) {
+	counter := &countingExecCommand{key: "systemctl-version-suse"}
+
+	env := &Env{execCommand: counter.command}
+	env.gather(context.Background(), []ResultCheck{
+		SystemdCheck{},
+		SystemdCheck{Units: []string{"dbus.service", "sshd.service"}},
+		TimeSyncServiceCheck{},
+		LocaleCheck{},
+		PasswordCheck{},
+	})
+	assert.Equal(t, map[string]int{
+		"/usr/bin/systemctl --version":                                   1,
+		"/usr/bin/systemctl is-active --quiet dbus.service":              1,
+		"/usr/bin/systemctl is-active --quiet systemd-udevd.service":     1,
+		"/usr/bin/systemctl is-active --quiet sshd.service":              1,
+		"/usr/bin/systemctl is-active --quiet systemd-timesyncd.service": 1,
+		"/usr/bin/systemctl is-active --quiet chronyd.service":           1,
+	}, counter.spawns)
+
+	// The checks then only read what was gathered
+	_, _ = env.output("/usr/bin/systemctl", "--version")
+	assert.True(t, env.succeeds("/usr/bin/systemctl", "is-active", "--quiet", "chronyd.service"))
+	assert.EqualValues(t, 6, counter.total.Load())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	counter = &countingExecCommand{key: "systemctl-version-suse"}
+	(&Env{execCommand: counter.command}).gather(ctx, []ResultCheck{SystemdCheck{}})
+	assert.Zero(t, counter.total.Load(), "nothing is run once the context is done")
+
+	// A tool which hangs is killed once it's taken as long as a check may
+	env = &Env{Options: Options{CheckTimeout: 100 * time.Millisecond}, execCommand: fakeCommand("hang")}
+	started := time.Now()
+	env.gather(context.Background(), []ResultCheck{SystemdCheck{}})
+	assert.Less(t, time.Since(started), 10*time.Second)
+	_, err := env.output("/usr/bin/systemctl", "--version")
+	assert.NotNil(t, err)
+}
+
+// BenchmarkSpawns compares the processes spawned by checks which share
+// tools, when each has an Env of its own, as they did before the
+// inventory pass, with a Runner's run.
+func BenchmarkSpawns(b *testing.B) {
+	h := testHost()
+	h.root = "./testdata/systemd/booted"
+	h.sysFirmwareDMITables = "./testdata/dmi/DMI"
+	h.sysFirmwareEFI = "./testdata/boot-mode/absent/sys/firmware/efi"
+	tools := map[string]string{"dmidecode": "dmidecode-uefi", "systemctl": "systemctl-version-suse"}
+
+	checks := []ResultCheck{
+		CPUCheck{},
+		MemoryCheck{},
+		BootModeCheck{},
+		ChassisCheck{},
+		FirmwareVersionCheck{},
+		SystemdCheck{},
+		TimeSyncServiceCheck{},
+		LimitsCheck{},
+		ModuleSetCheck{},
+	}
+
+	b.Run("per-check", func(b *testing.B) {
+		counter := &countingExecCommand{key: "dmidecode-fail", tools: tools}
+		for i := 0; i < b.N; i++ {
+			for _, check := range checks {
+				_, _ = check.Evaluate(context.Background(), &Env{execCommand: counter.command, machine: h})
+			}
+		}
+		b.ReportMetric(float64(counter.total.Load())/float64(b.N), "spawns/op")
+	})
+
+	b.Run("inventory-pass", func(b *testing.B) {
+		counter := &countingExecCommand{key: "dmidecode-fail", tools: tools}
+		runner := Runner{Checks: checks, ExecCommand: counter.command, machine: h}
+		for i := 0; i < b.N; i++ {
+			runner.Run(context.Background())
+		}
+		b.ReportMetric(float64(counter.total.Load())/float64(b.N), "spawns/op")
+	})
+}
+
+// A tool which was killed isn't waited for much longer, even if a child
+// of it still holds its output open.
+func TestEnvOutputOrphan(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	env := &Env{ctx: ctx, execCommand: fakeCommand("orphan")}
+	started := time.Now()
+	_, err := env.output("/usr/sbin/dmidecode")
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
+
+func TestEnvOutputBounded(t *testing.T) {
+	counter := &countingExecCommand{key: "flood-8"}
+	marker := "\n" + outputTruncatedMarker + "\n"
+
+	env := &Env{Options: Options{MaxToolOutput: 1 << 20}, execCommand: counter.command}
+	out, err := env.output("/usr/bin/flood")
+	assert.ErrorIs(t, err, errOutputTruncated)
+	assert.EqualError(t, err, "flood: output truncated after 1048576 bytes")
+	assert.Len(t, out, 1<<20+len(marker))
+	assert.True(t, strings.HasSuffix(string(out), marker))
+	assert.False(t, env.succeeds("/usr/bin/flood"), "truncated output is incomplete")
+
+	env = &Env{execCommand: counter.command}
+	out, err = env.output("/usr/bin/flood")
+	assert.ErrorIs(t, err, errOutputTruncated)
+	assert.Len(t, out, DefaultMaxToolOutput+len(marker))
+
+	// Output up to the cap is whole
+	counter.key = "flood-1"
+	env = &Env{Options: Options{MaxToolOutput: 1 << 20}, execCommand: counter.command}
+	out, err = env.output("/usr/bin/flood")
+	assert.Nil(t, err)
+	assert.Len(t, out, 1<<20)
+	assert.NotContains(t, string(out), outputTruncatedMarker)
+	assert.EqualValues(t, 3, counter.total.Load())
+}
+
+func TestEnvScanOutput(t *testing.T) {
+	counter := &countingExecCommand{key: "flood-8"}
+
+	// Streamed output isn't capped
+	env := &Env{Options: Options{MaxToolOutput: 1 << 20}, execCommand: counter.command}
+	lines := 0
+	err := env.scanOutput(func(line string) bool {
+		assert.Equal(t, "y", line)
+		lines++
+		return true
+	}, "/usr/bin/flood")
+	assert.Nil(t, err)
+	assert.Equal(t, 4<<20, lines)
+
+	// The rest is drained when the parser has what it needs
+	lines = 0
+	err = env.scanOutput(func(string) bool {
+		lines++
+		return lines < 3
+	}, "/usr/bin/flood")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, lines)
+
+	// Overlong lines are cut short
+	counter.key = "long-line"
+	var scanned []string
+	err = env.scanOutput(func(line string) bool {
+		scanned = append(scanned, line)
+		return true
+	}, "/usr/bin/long-line")
+	assert.ErrorIs(t, err, errOutputTruncated)
+	if assert.Len(t, scanned, 2) {
+		assert.Equal(t, strings.Repeat("x", maxToolLine)+" "+outputTruncatedMarker, scanned[0])
+		assert.Equal(t, "MemTotal: 1024 kB", scanned[1])
+	}
+	assert.EqualValues(t, 3, counter.total.Load())
+
+	// What's been run already is scanned rather than run again
+	counter.key = "systemctl-version-suse"
+	_, err = env.output("/usr/bin/systemctl", "--version")
+	assert.Nil(t, err)
+	scanned = nil
+	err = env.scanOutput(func(line string) bool {
+		scanned = append(scanned, line)
+		return false
+	}, "/usr/bin/systemctl", "--version")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"systemd 249 (249.11+suse.124.g2bc0b2c447)"}, scanned)
+	assert.EqualValues(t, 4, counter.total.Load())
+
+	// Unless the cache kept only the start of it, as it may of dmidecode
+	// on a big machine, in which case it's streamed after all
+	counter.key = "flood-8"
+	_, err = env.output("/usr/bin/flood")
+	assert.ErrorIs(t, err, errOutputTruncated)
+	lines = 0
+	err = env.scanOutput(func(string) bool {
+		lines++
+		return true
+	}, "/usr/bin/flood")
+	assert.Nil(t, err)
+	assert.Equal(t, 4<<20, lines)
+	assert.EqualValues(t, 6, counter.total.Load())
+}
@@ -0,0 +1,148 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// NetworkTopologyCheck verifies that the management network the install
+// configuration asks for, a bond of the given members with an optional
+// VLAN on top, can be set up on this host.  The members must exist, be
+// physical interfaces rather than VLANs (the VLAN goes on the bond), and
+// not already be enslaved to something else.  The VLAN ID must be valid,
+// and the MTU must be within every member's supported range.  Whether
+// the bond mode suits the members is up to BondModeCheck.  Each problem
+// is reported separately, prefixed with the configuration field to fix.
+type NetworkTopologyCheck struct {
+	// Members are interface names or MAC addresses.
+	Members  []string
+	BondMode string
+	VlanID   int
+	MTU      int
+}
+
+// NewNetworkTopologyCheck returns a NetworkTopologyCheck for the
+// management network in the given install configuration.
+func NewNetworkTopologyCheck(cfg *config.HarvesterConfig) NetworkTopologyCheck {
+	mgmt := cfg.Install.ManagementInterface
+	check := NetworkTopologyCheck{
+		BondMode: mgmt.BondOptions["mode"],
+		VlanID:   mgmt.VlanID,
+		MTU:      mgmt.MTU,
+	}
+	for _, iface := range mgmt.Interfaces {
+		member := iface.Name
+		if member == "" {
+			member = iface.HwAddr
+		}
+		check.Members = append(check.Members, member)
+	}
+	return check
+}
+
+const topologyPath = "install.management_interface"
+
+func (c NetworkTopologyCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "NetworkTopology"
+	if len(c.Members) == 0 {
+		return
+	}
+	if env.Inventory.NICs == nil {
+		if env.Inventory.NICs, err = env.listNICs(); err != nil {
+			return
+		}
+	}
+
+	var problems []string
+	problem := func(severity Severity, field, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		problems = append(problems, fmt.Sprintf("%s.%s: %s", topologyPath, field, fmt.Sprintf(format, args...)))
+	}
+
+	var members []NIC
+	for i, member := range c.Members {
+		if slices.Contains(c.Members[:i], member) {
+			problem(SeverityFatal, "interfaces", "%s is listed more than once.", member)
+			continue
+		}
+		index := slices.IndexFunc(env.Inventory.NICs, func(nic NIC) bool {
+			return nic.Name == member || strings.EqualFold(nic.HwAddr, member)
+		})
+		if index < 0 {
+			if _, err := env.stat(filepath.Join(env.host().root, "proc/net/vlan", member)); err == nil {
+				problem(SeverityFatal, "interfaces", "%s is a VLAN interface, but the VLAN has to be on the bond. Use vlan_id instead.", member)
+			} else {
+				problem(SeverityFatal, "interfaces", "%s does not exist.", member)
+			}
+			continue
+		}
+		nic := env.Inventory.NICs[index]
+		if nic.Master != "" && nic.Master != config.MgmtBondInterfaceName {
+			problem(SeverityFatal, "interfaces", "%s is already enslaved to %s.", member, nic.Master)
+		}
+		members = append(members, nic)
+	}
+
+	// 0 is untagged, and VLAN 1 is the switch's default, so can't be set
+	if c.VlanID != 0 && (c.VlanID < 2 || c.VlanID > 4094) {
+		problem(SeverityFatal, "vlan_id", "%d is not a valid VLAN ID. VLAN IDs are from 2 to 4094, or 0 for none.", c.VlanID)
+	}
+
+	if c.MTU != 0 && len(members) > 0 {
+		ranges, err := mtuRanges(env)
+		if err != nil {
+			return result, err
+		}
+		for _, nic := range members {
+			r, ok := ranges[nic.Name]
+			if ok && (c.MTU < r[0] || c.MTU > r[1]) {
+				problem(SeverityFatal, "mtu", "%d is outside the range %s supports, which is %d to %d.", c.MTU, nic.Name, r[0], r[1])
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		result.Message = strings.Join(problems, " ")
+		return
+	}
+	var names []string
+	for _, nic := range members {
+		names = append(names, nic.Name)
+	}
+	result.Message = fmt.Sprintf("The management bond of %s in %s mode can be set up", strings.Join(names, ", "), bondMode(c.BondMode))
+	if c.VlanID > 1 {
+		result.Message += fmt.Sprintf(", with VLAN %d", c.VlanID)
+	}
+	result.Message += "."
+	return
+}
+
+// mtuRanges returns the minimum and maximum MTU of each interface, as
+// reported by ip.  Interfaces whose drivers don't say are left out.
+func mtuRanges(env *Env) (map[string][2]int, error) {
+	out, err := env.output("/usr/sbin/ip", "-json", "-details", "link", "show")
+	if err != nil {
+		return nil, err
+	}
+	var links []struct {
+		IfName string `json:"ifname"`
+		MinMTU int    `json:"min_mtu"`
+		MaxMTU int    `json:"max_mtu"`
+	}
+	if err := json.Unmarshal(out, &links); err != nil {
+		return nil, err
+	}
+	ranges := map[string][2]int{}
+	for _, link := range links {
+		if link.MaxMTU > 0 {
+			ranges[link.IfName] = [2]int{link.MinMTU, link.MaxMTU}
+		}
+	}
+	return ranges, nil
+}
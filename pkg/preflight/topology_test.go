@@ -0,0 +1,89 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewNetworkTopologyCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.ManagementInterface = config.Network{
+		Interfaces:  []config.NetworkInterface{{Name: "eno1"}, {HwAddr: "3c:ec:ef:12:34:57"}},
+		BondOptions: map[string]string{"mode": "802.3ad", "miimon": "100"},
+		VlanID:      100,
+		MTU:         9000,
+	}
+	assert.Equal(t, NetworkTopologyCheck{
+		Members:  []string{"eno1", "3c:ec:ef:12:34:57"},
+		BondMode: "802.3ad",
+		VlanID:   100,
+		MTU:      9000,
+	}, NewNetworkTopologyCheck(cfg))
+}
+
+func TestNetworkTopologyCheck(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/network-topology"
+
+	inventory := Inventory{NICs: []NIC{
+		{Name: "eno1", HwAddr: "3c:ec:ef:12:34:56", SpeedMbps: 10000},
+		{Name: "eno2", HwAddr: "3c:ec:ef:12:34:57", SpeedMbps: 10000},
+		{Name: "eno3", HwAddr: "3c:ec:ef:12:34:58", SpeedMbps: 1000},
+		{Name: "ens1f0", HwAddr: "b8:59:9f:00:00:01", SpeedMbps: 25000, Master: "bond0"},
+		{Name: "ens1f1", HwAddr: "b8:59:9f:00:00:02", Master: "mgmt-bo"},
+	}}
+
+	tests := []struct {
+		name     string
+		check    NetworkTopologyCheck
+		severity Severity
+		message  string
+	}{
+		{
+			name:    "valid",
+			check:   NetworkTopologyCheck{Members: []string{"eno1", "3C:EC:EF:12:34:57"}, BondMode: "802.3ad", VlanID: 100, MTU: 9000},
+			message: "The management bond of eno1, eno2 in 802.3ad mode can be set up, with VLAN 100.",
+		},
+		{
+			name:    "reinstall",
+			check:   NetworkTopologyCheck{Members: []string{"ens1f1"}},
+			message: "The management bond of ens1f1 in balance-tlb mode can be set up.",
+		},
+		{
+			name:    "mixed speeds",
+			check:   NetworkTopologyCheck{Members: []string{"eno1", "eno3"}, BondMode: "active-backup"},
+			message: "The management bond of eno1, eno3 in active-backup mode can be set up.",
+		},
+		{
+			name:     "violations",
+			check:    NetworkTopologyCheck{Members: []string{"eno1", "eno9", "eno1.100", "ens1f0", "eno1", "eno3"}, BondMode: "lacp", VlanID: 5000, MTU: 9000},
+			severity: SeverityFatal,
+			message: "install.management_interface.interfaces: eno9 does not exist. " +
+				"install.management_interface.interfaces: eno1.100 is a VLAN interface, but the VLAN has to be on the bond. Use vlan_id instead. " +
+				"install.management_interface.interfaces: ens1f0 is already enslaved to bond0. " +
+				"install.management_interface.interfaces: eno1 is listed more than once. " +
+				"install.management_interface.vlan_id: 5000 is not a valid VLAN ID. VLAN IDs are from 2 to 4094, or 0 for none. " +
+				"install.management_interface.mtu: 9000 is outside the range eno3 supports, which is 68 to 1500.",
+		},
+		{
+			name:     "default VLAN",
+			check:    NetworkTopologyCheck{Members: []string{"eno1"}, BondMode: "active-backup", VlanID: 1},
+			severity: SeverityFatal,
+			message:  "install.management_interface.vlan_id: 1 is not a valid VLAN ID. VLAN IDs are from 2 to 4094, or 0 for none.",
+		},
+		{
+			name: "nothing configured",
+		},
+	}
+
+	for _, test := range tests {
+		env := &Env{Inventory: inventory, execCommand: fakeCommand("ip-link"), machine: h}
+		result, err := test.check.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, Result{Name: "NetworkTopology", Severity: test.severity, Message: test.message}, result, test.name)
+	}
+}
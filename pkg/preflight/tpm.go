@@ -0,0 +1,137 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// Why a TPMCheck's Result is a warning, or fatal, as recorded in its
+// Facts, for callers which need to tell them apart.
+const (
+	// TPMMissing means there's no TPM device
+	TPMMissing = "missing"
+	// TPMUnsupportedVersion means the TPM isn't a TPM 2.0
+	TPMUnsupportedVersion = "unsupported-version"
+	// TPMUnknownVersion means the TPM's version couldn't be read
+	TPMUnknownVersion = "unknown-version"
+)
+
+// TPM describes the host's TPM.
+type TPM struct {
+	// Version is the TPM family, "2.0" or "1.2", or empty if unknown
+	Version string
+	// Kind is "firmware" for firmware TPMs (AMD fTPM, Intel PTT),
+	// "discrete" for separate chips, or empty if unknown
+	Kind string
+}
+
+// TPMCheck looks for a TPM, and records its version and kind in the
+// inventory.  Encrypted installs seal their disk key to a TPM 2.0, so a
+// host without one, or with only a TPM 1.2, or whose TPM's version can't
+// be read from sysfs, gets a warning that they won't be able to unlock
+// their disks automatically, which says which of those it is in the
+// "tpm" Fact.  If the Options say a TPM is required, as they do for
+// encrypted installs, those are fatal instead.
+type TPMCheck struct{}
+
+func (c TPMCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "TPM"
+
+	var present bool
+	for _, dev := range []string{env.host().devTPMRM, env.host().devTPM} {
+		if _, err = env.stat(dev); err == nil {
+			present = true
+			break
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+	}
+	err = nil
+
+	problem := func(kind, msg string) {
+		result.Severity = SeverityWarning
+		if env.Options.TPMRequired {
+			result.Severity = SeverityFatal
+		}
+		result.Message = msg + " Encrypted installs will not be able to unlock their disks automatically, as that needs a TPM 2.0."
+		result.Facts = map[string]any{"tpm": kind}
+	}
+	if !present {
+		problem(TPMMissing, "No TPM detected.")
+		return
+	}
+
+	tpm := &TPM{
+		Version: tpmVersion(env, filepath.Join(env.host().sysClassTPM, "tpm0")),
+		Kind:    tpmKind(env, filepath.Join(env.host().sysClassTPM, "tpm0")),
+	}
+	env.Inventory.TPM = tpm
+
+	desc := "TPM"
+	if tpm.Version != "" {
+		desc += " " + tpm.Version
+	}
+	if tpm.Kind != "" {
+		desc += fmt.Sprintf(" (%s)", tpm.Kind)
+	}
+	switch tpm.Version {
+	case "2.0":
+		result.Message = desc + " detected."
+	case "":
+		problem(TPMUnknownVersion, desc+" detected, but its version could not be read from sysfs.")
+	default:
+		problem(TPMUnsupportedVersion, desc+" detected.")
+	}
+	return
+}
+
+// tpmVersion reads the TPM family from sysfs.  tpm_version_major is only
+// present on newer kernels, so fall back to the TPM 1.2 capabilities or
+// the ACPI device description if it's missing.
+func tpmVersion(env *Env, dir string) string {
+	if out, err := env.readFile(filepath.Join(dir, "tpm_version_major")); err == nil {
+		switch strings.TrimSpace(string(out)) {
+		case "1":
+			return "1.2"
+		case "2":
+			return "2.0"
+		}
+	}
+	if out, err := env.readFile(filepath.Join(dir, "device", "caps")); err == nil {
+		if strings.Contains(string(out), "TCG version: 1.2") {
+			return "1.2"
+		}
+	}
+	if out, err := env.readFile(filepath.Join(dir, "device", "description")); err == nil {
+		if strings.Contains(string(out), "2.0") {
+			return "2.0"
+		}
+		if strings.Contains(string(out), "1.2") {
+			return "1.2"
+		}
+	}
+	return ""
+}
+
+// tpmKind guesses whether a TPM is a firmware or discrete one from the
+// driver bound to it.  Firmware TPMs use the Command Response Buffer
+// interface, while discrete chips sit on LPC, SPI or I2C.  This isn't
+// definitive (the spec allows discrete CRB TPMs), but holds in practice.
+func tpmKind(env *Env, dir string) string {
+	link, err := env.readlink(filepath.Join(dir, "device", "driver"))
+	if err != nil {
+		return ""
+	}
+	driver := filepath.Base(link)
+	switch {
+	case driver == "tpm_crb":
+		return "firmware"
+	case strings.HasPrefix(driver, "tpm_tis"), strings.HasPrefix(driver, "tpm_i2c"):
+		return "discrete"
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTPMCheck(t *testing.T) {
+	h := testHost()
+
+	const unlock = " Encrypted installs will not be able to unlock their disks automatically, as that needs a TPM 2.0."
+	tests := []struct {
+		fixture  string
+		required bool
+		tpm      *TPM
+		severity Severity
+		message  string
+		problem  string
+	}{
+		{"tpm2", false, &TPM{Version: "2.0", Kind: "firmware"}, SeverityOK, "TPM 2.0 (firmware) detected.", ""},
+		{"tpm2", true, &TPM{Version: "2.0", Kind: "firmware"}, SeverityOK, "TPM 2.0 (firmware) detected.", ""},
+		// Which has no resource manager
+		{"tpm12", false, &TPM{Version: "1.2", Kind: "discrete"}, SeverityWarning,
+			"TPM 1.2 (discrete) detected." + unlock, TPMUnsupportedVersion},
+		{"tpm12", true, &TPM{Version: "1.2", Kind: "discrete"}, SeverityFatal,
+			"TPM 1.2 (discrete) detected." + unlock, TPMUnsupportedVersion},
+		{"tpm12-version-major", false, &TPM{Version: "1.2"}, SeverityWarning,
+			"TPM 1.2 detected." + unlock, TPMUnsupportedVersion},
+		{"unknown-version", false, &TPM{}, SeverityWarning,
+			"TPM detected, but its version could not be read from sysfs." + unlock, TPMUnknownVersion},
+		{"unknown-version", true, &TPM{}, SeverityFatal,
+			"TPM detected, but its version could not be read from sysfs." + unlock, TPMUnknownVersion},
+		{"none", false, nil, SeverityWarning, "No TPM detected." + unlock, TPMMissing},
+		{"none", true, nil, SeverityFatal, "No TPM detected." + unlock, TPMMissing},
+	}
+
+	for _, test := range tests {
+		h.sysClassTPM = "./testdata/tpm/" + test.fixture + "/sys/class/tpm"
+		h.devTPMRM = "./testdata/tpm/" + test.fixture + "/dev/tpmrm0"
+		h.devTPM = "./testdata/tpm/" + test.fixture + "/dev/tpm0"
+		env := &Env{Options: Options{TPMRequired: test.required}, machine: h}
+		result, err := TPMCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err)
+		expected := Result{Name: "TPM", Severity: test.severity, Message: test.message}
+		if test.problem != "" {
+			expected.Facts = map[string]any{"tpm": test.problem}
+		}
+		assert.Equal(t, expected, result, test.fixture)
+		assert.Equal(t, test.tpm, env.Inventory.TPM, test.fixture)
+	}
+}
@@ -0,0 +1,92 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// upgradeStateMount is where an installed node mounts COS_STATE,
+	// which holds the OS images
+	upgradeStateMount = "/run/initramfs/cos-state"
+	// upgradeActiveImage is the running OS image, in COS_STATE
+	upgradeActiveImage = "cOS/active.img"
+	// upgradeDataMount is where the new release's container images are
+	// unpacked to, in COS_PERSISTENT
+	upgradeDataMount = "/usr/local"
+	// minUpgradeDataFreeGiB is how much room the new release's container
+	// images need, as per the upgrade documentation
+	minUpgradeDataFreeGiB = 30
+)
+
+// statfsAvailable returns the bytes available to unprivileged users on
+// the filesystem path is on.
+func statfsAvailable(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// UpgradeSpaceCheck verifies that an installed node has room for an
+// upgrade, which otherwise fails part way through, leaving the node
+// cordoned.  The new OS image is written next to the active one in
+// COS_STATE, so that needs as much free space as the active image takes,
+// and the new release's container images need minUpgradeDataFreeGiB in
+// COS_PERSISTENT.  Nodes which don't have COS_STATE mounted aren't
+// installed nodes, so they're skipped.
+type UpgradeSpaceCheck struct{}
+
+func (c UpgradeSpaceCheck) Modes() []RunMode {
+	return []RunMode{RunModeUpgrade}
+}
+
+func (c UpgradeSpaceCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "UpgradeSpace"
+
+	mounts, err := readMountTypes(env, filepath.Join(env.host().root, "proc/self/mountinfo"))
+	if err != nil {
+		return
+	}
+	if _, ok := mounts[upgradeStateMount]; !ok {
+		result.Message = fmt.Sprintf("Skipped: %s is not mounted, so this is not an installed node.", upgradeStateMount)
+		return
+	}
+
+	var findings []string
+	check := func(description, mount string, needBytes uint64, purpose string) error {
+		free, err := env.host().statFS(filepath.Join(env.host().root, mount))
+		if err != nil {
+			return err
+		}
+		if free < needBytes {
+			result.Severity = SeverityFatal
+			findings = append(findings, fmt.Sprintf("%s (%s) has only %s free, but upgrading needs %s for %s.",
+				description, mount, formatBytes(free), formatBytes(needBytes), purpose))
+		} else {
+			findings = append(findings, fmt.Sprintf("%s (%s) has %s free.", description, mount, formatBytes(free)))
+		}
+		return nil
+	}
+
+	image, err := env.stat(filepath.Join(env.host().root, upgradeStateMount, upgradeActiveImage))
+	if errors.Is(err, fs.ErrNotExist) {
+		findings = append(findings, fmt.Sprintf("The state partition has no %s, so the space the new OS image needs is unknown.", upgradeActiveImage))
+		result.Severity = SeverityWarning
+	} else if err != nil {
+		return
+	} else if err = check("The state partition", upgradeStateMount, uint64(image.Size()), "the new OS image"); err != nil {
+		return
+	}
+	if err = check("The persistent partition", upgradeDataMount, minUpgradeDataFreeGiB*gib, "the new release's container images"); err != nil {
+		return
+	}
+	result.Message = strings.Join(findings, " ")
+	return
+}
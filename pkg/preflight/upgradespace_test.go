@@ -0,0 +1,69 @@
+package preflight
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgradeSpaceCheck(t *testing.T) {
+	h := testHost()
+
+	tests := []struct {
+		fixture   string
+		stateFree uint64
+		dataFree  uint64
+		severity  Severity
+		message   string
+	}{
+		{
+			fixture: "live",
+			message: "Skipped: /run/initramfs/cos-state is not mounted, so this is not an installed node.",
+		},
+		{
+			fixture:   "installed",
+			stateFree: 4096,
+			dataFree:  64 * gib,
+			message:   "The state partition (/run/initramfs/cos-state) has 4KiB free. The persistent partition (/usr/local) has 64GiB free.",
+		},
+		{
+			fixture:   "installed",
+			stateFree: 1024,
+			dataFree:  64 * gib,
+			severity:  SeverityFatal,
+			message: "The state partition (/run/initramfs/cos-state) has only 1KiB free, but upgrading needs 2KiB for the new OS image. " +
+				"The persistent partition (/usr/local) has 64GiB free.",
+		},
+		{
+			fixture:   "installed",
+			stateFree: 4096,
+			dataFree:  12 * gib,
+			severity:  SeverityFatal,
+			message: "The state partition (/run/initramfs/cos-state) has 4KiB free. " +
+				"The persistent partition (/usr/local) has only 12GiB free, but upgrading needs 30GiB for the new release's container images.",
+		},
+		{
+			fixture:  "no-image",
+			dataFree: 64 * gib,
+			severity: SeverityWarning,
+			message: "The state partition has no cOS/active.img, so the space the new OS image needs is unknown. " +
+				"The persistent partition (/usr/local) has 64GiB free.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			h.root = "./testdata/upgrade-space/" + tt.fixture
+			h.statFS = func(path string) (uint64, error) {
+				return map[string]uint64{
+					filepath.Join(h.root, upgradeStateMount): tt.stateFree,
+					filepath.Join(h.root, upgradeDataMount):  tt.dataFree,
+				}[path], nil
+			}
+			result, err := UpgradeSpaceCheck{}.Evaluate(context.Background(), &Env{machine: h})
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "UpgradeSpace", Severity: tt.severity, Message: tt.message}, result)
+		})
+	}
+}
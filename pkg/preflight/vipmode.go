@@ -0,0 +1,190 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// A DHCPLease is an IPv4 lease the live environment got from a DHCP
+// server.
+type DHCPLease struct {
+	Interface string
+	Server    string
+	Address   string
+}
+
+// VIPModeCheck verifies that the way the install configuration asks for
+// the VIP fits the management network, because otherwise the cluster
+// never gets a stable address.  A static VIP must be inside the static
+// management subnet, and must be neither the node's address nor the
+// gateway.  A VIP from DHCP needs a DHCP server on the management
+// segment, which is the case if the live environment got a lease on one
+// of the management interfaces.  Each inconsistency is reported
+// separately, prefixed with the configuration field to fix.
+type VIPModeCheck struct {
+	VIP     string
+	VIPMode string
+	Network config.Network
+}
+
+// NewVIPModeCheck returns a VIPModeCheck for the given install
+// configuration.
+func NewVIPModeCheck(cfg *config.HarvesterConfig) VIPModeCheck {
+	return VIPModeCheck{
+		VIP:     cfg.Vip,
+		VIPMode: cfg.VipMode,
+		Network: cfg.ManagementInterface,
+	}
+}
+
+func (c VIPModeCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "VIPMode"
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+
+	switch c.VIPMode {
+	case config.NetworkMethodStatic:
+		vip := net.ParseIP(c.VIP)
+		if vip == nil {
+			finding(SeverityFatal, "install.vip: %q is not an IP address.", c.VIP)
+			break
+		}
+		if c.Network.Method != config.NetworkMethodStatic {
+			finding(SeverityInfo, "install.vip: the management network uses DHCP, so whether %s is in its subnet cannot be checked.", vip)
+			break
+		}
+		ip, mask := net.ParseIP(c.Network.IP), net.ParseIP(c.Network.SubnetMask).To4()
+		if ip == nil || mask == nil {
+			// The schema check reports these
+			break
+		}
+		subnet := net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+		inside := subnet.Contains(vip)
+		if !inside {
+			finding(SeverityFatal, "install.vip: %s is outside the management subnet %s, so it will not be reachable.", vip, subnet.String())
+		}
+		if vip.Equal(ip) {
+			finding(SeverityFatal, "install.vip: %s is the node's own address (install.management_interface.ip).", vip)
+		}
+		if gateway := net.ParseIP(c.Network.Gateway); vip.Equal(gateway) {
+			finding(SeverityFatal, "install.vip: %s is the gateway (install.management_interface.gateway).", vip)
+		}
+		if len(findings) == 0 {
+			finding(SeverityOK, "install.vip: %s is inside the management subnet %s.", vip, subnet.String())
+		}
+	case config.NetworkMethodDHCP:
+		if env.Inventory.NICs == nil {
+			if env.Inventory.NICs, err = env.listNICs(); err != nil {
+				return
+			}
+		}
+		if env.Inventory.DHCPLeases == nil {
+			if env.Inventory.DHCPLeases, err = listDHCPLeases(env); err != nil {
+				return
+			}
+		}
+		members := c.members(env.Inventory.NICs)
+		var servers []string
+		for _, lease := range env.Inventory.DHCPLeases {
+			if (len(members) == 0 || slices.Contains(members, lease.Interface)) && lease.Server != "" {
+				servers = append(servers, fmt.Sprintf("%s on %s", lease.Server, lease.Interface))
+			}
+		}
+		segment := "the management segment"
+		if len(members) > 0 {
+			segment = strings.Join(members, ", ")
+		}
+		if len(servers) == 0 {
+			finding(SeverityFatal, "install.vip_mode: the VIP is to come from DHCP, but no DHCP server has answered on %s, "+
+				"so it will never get an address. Use a static VIP, or set up DHCP on the segment.", segment)
+		} else {
+			finding(SeverityOK, "install.vip_mode: DHCP servers answered on %s (%s).", segment, strings.Join(servers, ", "))
+		}
+	}
+
+	result.Message = strings.Join(findings, " ")
+	return
+}
+
+// members returns the names of the management interfaces which exist.
+func (c VIPModeCheck) members(nics []NIC) []string {
+	var names []string
+	for _, iface := range c.Network.Interfaces {
+		for _, nic := range nics {
+			if nic.Name == iface.Name || (iface.HwAddr != "" && strings.EqualFold(nic.HwAddr, iface.HwAddr)) {
+				names = append(names, nic.Name)
+			}
+		}
+	}
+	return names
+}
+
+// listDHCPLeases returns the IPv4 leases wicked has got in the live
+// environment.
+func listDHCPLeases(env *Env) ([]DHCPLease, error) {
+	paths, err := env.glob(filepath.Join(env.host().root, "var/lib/wicked/lease-*-dhcp-ipv4.xml"))
+	if err != nil {
+		return nil, err
+	}
+	leases := []DHCPLease{}
+	for _, path := range paths {
+		lease, err := readWickedLease(env, path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// readWickedLease reads a lease file written by wicked, whose name is
+// lease-<interface>-dhcp-ipv4.xml.
+func readWickedLease(env *Env, path string) (lease DHCPLease, err error) {
+	name := filepath.Base(path)
+	lease.Interface = strings.TrimSuffix(strings.TrimPrefix(name, "lease-"), "-dhcp-ipv4.xml")
+
+	data, err := env.readFile(path)
+	if err != nil {
+		return
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var element string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return lease, nil
+		} else if err != nil {
+			return lease, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			element = token.Name.Local
+		case xml.EndElement:
+			element = ""
+		case xml.CharData:
+			value := strings.TrimSpace(string(token))
+			switch {
+			case element == "server-id" && lease.Server == "":
+				lease.Server = value
+			case element == "address" && lease.Address == "":
+				lease.Address = value
+			}
+		}
+	}
+}
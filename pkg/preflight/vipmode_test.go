@@ -0,0 +1,131 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestNewVIPModeCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Vip = "192.168.1.100"
+	cfg.VipMode = config.NetworkMethodStatic
+	cfg.ManagementInterface = config.Network{Method: config.NetworkMethodDHCP}
+	assert.Equal(t, VIPModeCheck{
+		VIP:     "192.168.1.100",
+		VIPMode: config.NetworkMethodStatic,
+		Network: config.Network{Method: config.NetworkMethodDHCP},
+	}, NewVIPModeCheck(cfg))
+}
+
+func TestVIPModeCheck(t *testing.T) {
+	h := testHost()
+
+	static := config.Network{
+		Method:     config.NetworkMethodStatic,
+		IP:         "192.168.1.21",
+		SubnetMask: "255.255.255.0",
+		Gateway:    "192.168.1.1",
+	}
+	tests := []struct {
+		name     string
+		check    VIPModeCheck
+		fixture  string
+		severity Severity
+		message  string
+	}{
+		{
+			name:  "no VIP",
+			check: VIPModeCheck{Network: static},
+		},
+		{
+			name:    "static inside the subnet",
+			check:   VIPModeCheck{VIP: "192.168.1.100", VIPMode: config.NetworkMethodStatic, Network: static},
+			message: "install.vip: 192.168.1.100 is inside the management subnet 192.168.1.0/24.",
+		},
+		{
+			name:     "static outside the subnet",
+			check:    VIPModeCheck{VIP: "192.168.2.100", VIPMode: config.NetworkMethodStatic, Network: static},
+			severity: SeverityFatal,
+			message:  "install.vip: 192.168.2.100 is outside the management subnet 192.168.1.0/24, so it will not be reachable.",
+		},
+		{
+			name:     "static node address",
+			check:    VIPModeCheck{VIP: "192.168.1.21", VIPMode: config.NetworkMethodStatic, Network: static},
+			severity: SeverityFatal,
+			message:  "install.vip: 192.168.1.21 is the node's own address (install.management_interface.ip).",
+		},
+		{
+			name: "static gateway outside the subnet",
+			check: VIPModeCheck{VIP: "10.0.0.1", VIPMode: config.NetworkMethodStatic, Network: config.Network{
+				Method:     config.NetworkMethodStatic,
+				IP:         "192.168.1.21",
+				SubnetMask: "255.255.255.0",
+				Gateway:    "10.0.0.1",
+			}},
+			severity: SeverityFatal,
+			message: "install.vip: 10.0.0.1 is outside the management subnet 192.168.1.0/24, so it will not be reachable. " +
+				"install.vip: 10.0.0.1 is the gateway (install.management_interface.gateway).",
+		},
+		{
+			name:     "static with DHCP management network",
+			check:    VIPModeCheck{VIP: "192.168.1.100", VIPMode: config.NetworkMethodStatic, Network: config.Network{Method: config.NetworkMethodDHCP}},
+			severity: SeverityInfo,
+			message:  "install.vip: the management network uses DHCP, so whether 192.168.1.100 is in its subnet cannot be checked.",
+		},
+		{
+			name:     "static invalid",
+			check:    VIPModeCheck{VIP: "192.168.1", VIPMode: config.NetworkMethodStatic, Network: static},
+			severity: SeverityFatal,
+			message:  "install.vip: \"192.168.1\" is not an IP address.",
+		},
+		{
+			name: "DHCP with a server",
+			check: VIPModeCheck{VIPMode: config.NetworkMethodDHCP, Network: config.Network{
+				Method:     config.NetworkMethodDHCP,
+				Interfaces: []config.NetworkInterface{{HwAddr: "52:54:00:12:34:57"}},
+			}},
+			fixture: "lease",
+			message: "install.vip_mode: DHCP servers answered on eth1 (192.168.1.1 on eth1).",
+		},
+		{
+			name: "DHCP with a server on another segment",
+			check: VIPModeCheck{VIPMode: config.NetworkMethodDHCP, Network: config.Network{
+				Method:     config.NetworkMethodDHCP,
+				Interfaces: []config.NetworkInterface{{Name: "eth0"}},
+			}},
+			fixture:  "lease",
+			severity: SeverityFatal,
+			message: "install.vip_mode: the VIP is to come from DHCP, but no DHCP server has answered on eth0, " +
+				"so it will never get an address. Use a static VIP, or set up DHCP on the segment.",
+		},
+		{
+			name:     "DHCP without a server",
+			check:    VIPModeCheck{VIPMode: config.NetworkMethodDHCP, Network: static},
+			fixture:  "no-lease",
+			severity: SeverityFatal,
+			message: "install.vip_mode: the VIP is to come from DHCP, but no DHCP server has answered on the management segment, " +
+				"so it will never get an address. Use a static VIP, or set up DHCP on the segment.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.root = "./testdata/vip-mode/" + tt.fixture
+			result, err := tt.check.Evaluate(context.Background(), &Env{machine: h})
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Name: "VIPMode", Severity: tt.severity, Message: tt.message}, result)
+		})
+	}
+}
+
+func TestListDHCPLeases(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/vip-mode/lease"
+
+	leases, err := listDHCPLeases(&Env{machine: h})
+	assert.Nil(t, err)
+	assert.Equal(t, []DHCPLease{{Interface: "eth1", Server: "192.168.1.1", Address: "192.168.1.50"}}, leases)
+}
@@ -0,0 +1,97 @@
+package preflight
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// CPUVirtExtCheck looks for the CPU's hardware virtualization extensions,
+// so that when KVMHostCheck finds no /dev/kvm, the user knows whether to
+// enable them in the firmware or whether the CPU hasn't got them at all.
+// On x86 they're the vmx (Intel VT-x) or svm (AMD-V) CPU flags.  arm64
+// has no such flag, so there only /dev/kvm is looked for, which the
+// kernel only creates if it was booted at EL2.  Whether the kernel has the
+// KVM modules at all is ModuleSetCheck's business.  Other architectures
+// pass silently.
+type CPUVirtExtCheck struct {
+	// DevicePath is the KVM device, or /dev/kvm if empty.
+	DevicePath string
+}
+
+// NewCPUVirtExtCheck returns a CPUVirtExtCheck which looks for /dev/kvm.
+func NewCPUVirtExtCheck() CPUVirtExtCheck {
+	return CPUVirtExtCheck{DevicePath: defaultDevKvm}
+}
+
+// cpuVirtExtensions are the names of the x86 virtualization extensions,
+// by their CPU flag.
+var cpuVirtExtensions = map[string]string{"vmx": "VT-x", "svm": "AMD-V"}
+
+func (c CPUVirtExtCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "CPUVirtExt"
+	_, machine, err := env.host().unameRelease()
+	if err != nil {
+		return
+	}
+	_, err = env.stat(cmp.Or(c.DevicePath, defaultDevKvm))
+	kvm := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	err = nil
+
+	switch machine {
+	case "x86_64", "i686":
+		var flags []string
+		if flags, err = readCPUFlags(env, env.host().procCPUInfo); err != nil {
+			return
+		}
+		extension := ""
+		for _, flag := range flags {
+			if name, ok := cpuVirtExtensions[flag]; ok {
+				extension = name
+				result.Facts = map[string]any{"flag": flag}
+				break
+			}
+		}
+		switch {
+		case extension == "":
+			result.Severity = SeverityWarning
+			result.Message = "The CPU lacks hardware virtualization (neither VT-x nor AMD-V is present), so SaftOS cannot run virtual machines on it. " +
+				"If this is a virtual machine, enable nested virtualization on its host."
+		case !kvm:
+			result.Severity = SeverityWarning
+			result.Message = "The CPU supports " + extension + ", but /dev/kvm does not exist. Enable " + extension +
+				" in the firmware setup (BIOS/UEFI) and reboot."
+			result.Remediation = &Remediation{Hint: "Enable " + extension + " (it may be called Virtualization Technology or SVM Mode) in the firmware setup."}
+		}
+	case "aarch64":
+		if kvm {
+			return
+		}
+		result.Severity = SeverityWarning
+		result.Message = "/dev/kvm does not exist, so SaftOS cannot run virtual machines. " +
+			"On arm64 this means the firmware did not boot the kernel in hypervisor mode (EL2), or the kernel has no KVM support."
+	}
+	return
+}
+
+// readCPUFlags returns the flags of the first CPU in path, which is in the
+// format of /proc/cpuinfo, e.g. "flags : fpu vme de pse ...".  They're
+// the same for every CPU.
+func readCPUFlags(env *Env, path string) ([]string, error) {
+	out, err := env.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(key) == "flags" {
+			return strings.Fields(value), nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,83 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUVirtExtCheck(t *testing.T) {
+	h := testHost()
+
+	const (
+		kvm   = "./testdata/dev-kvm"
+		noKVM = "./testdata/dev-kvm-does-not-exist"
+	)
+	tests := []struct {
+		name     string
+		machine  string
+		fixture  string
+		device   string
+		severity Severity
+		message  string
+		flag     string
+	}{
+		{name: "intel", machine: "x86_64", fixture: "intel", device: kvm, flag: "vmx"},
+		{name: "amd", machine: "x86_64", fixture: "amd", device: kvm, flag: "svm"},
+		{
+			name:     "intel disabled in firmware",
+			machine:  "x86_64",
+			fixture:  "intel",
+			device:   noKVM,
+			severity: SeverityWarning,
+			message:  "The CPU supports VT-x, but /dev/kvm does not exist. Enable VT-x in the firmware setup (BIOS/UEFI) and reboot.",
+			flag:     "vmx",
+		},
+		{
+			name:     "amd disabled in firmware",
+			machine:  "x86_64",
+			fixture:  "amd",
+			device:   noKVM,
+			severity: SeverityWarning,
+			message:  "The CPU supports AMD-V, but /dev/kvm does not exist. Enable AMD-V in the firmware setup (BIOS/UEFI) and reboot.",
+			flag:     "svm",
+		},
+		{
+			// A VM without nested virtualization
+			name:     "vm",
+			machine:  "x86_64",
+			fixture:  "vm",
+			device:   noKVM,
+			severity: SeverityWarning,
+			message: "The CPU lacks hardware virtualization (neither VT-x nor AMD-V is present), so SaftOS cannot run virtual machines on it. " +
+				"If this is a virtual machine, enable nested virtualization on its host.",
+		},
+		{name: "arm64 with /dev/kvm", machine: "aarch64", fixture: "vm", device: kvm},
+		{
+			name:     "arm64 without KVM",
+			machine:  "aarch64",
+			fixture:  "vm",
+			device:   noKVM,
+			severity: SeverityWarning,
+			message: "/dev/kvm does not exist, so SaftOS cannot run virtual machines. " +
+				"On arm64 this means the firmware did not boot the kernel in hypervisor mode (EL2), or the kernel has no KVM support.",
+		},
+		{name: "other architectures", machine: "s390x", fixture: "vm", device: noKVM},
+	}
+
+	for _, test := range tests {
+		h.root = "./testdata/cpu-virt/" + test.fixture
+		h.procCPUInfo = h.root + "/cpuinfo"
+		h.unameRelease = func() (string, string, error) { return "6.4.0", test.machine, nil }
+		result, err := CPUVirtExtCheck{DevicePath: test.device}.Evaluate(context.Background(), &Env{machine: h})
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, "CPUVirtExt", result.Name, test.name)
+		assert.Equal(t, test.severity, result.Severity, test.name)
+		assert.Equal(t, test.message, result.Message, test.name)
+		if test.flag != "" {
+			assert.Equal(t, map[string]any{"flag": test.flag}, result.Facts, test.name)
+		}
+		assert.Equal(t, test.device == noKVM && test.flag != "", result.Remediation != nil, test.name)
+	}
+}
@@ -0,0 +1,528 @@
+package preflight
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	vlanProbeTimeout = 5 * time.Second
+	vlanLeaseTimeout = 15 * time.Second
+	// vlanLinkPrefix names the temporary VLAN interface, which is named
+	// after the VLAN rather than its parent, so it can't clash with an
+	// interface the live environment set up, and fits in IFNAMSIZ
+	vlanLinkPrefix = "preflight"
+)
+
+var arpingReplyPattern = regexp.MustCompile(`\[([0-9A-Fa-f]{2}(?::[0-9A-Fa-f]{2}){5})\]`)
+
+// netInterfaceAddrs returns the addresses of the host's interfaces, by
+// interface name.
+func netInterfaceAddrs() (map[string][]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	addrs := map[string][]*net.IPNet{}
+	for _, iface := range ifaces {
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range ifaceAddrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				addrs[iface.Name] = append(addrs[iface.Name], ipNet)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// A vlanLease is a DHCP lease got on a VLAN, which hasn't been applied.
+type vlanLease struct {
+	Address    *net.IPNet
+	Gateway    net.IP
+	Server     string
+	DNSServers []string
+}
+
+// A vlanLinker sets up and tears down a temporary VLAN interface.
+type vlanLinker interface {
+	// Add creates the interface name for VLAN id on parent, and brings it
+	// up.
+	Add(ctx context.Context, parent, name string, id int) error
+	// Lease gets a DHCP lease on the interface without applying it.
+	Lease(ctx context.Context, name string) (vlanLease, error)
+	// Assign adds an address to the interface.
+	Assign(ctx context.Context, name string, addr *net.IPNet) error
+	// Route routes a single address via gateway on the interface.
+	Route(ctx context.Context, name string, dest, gateway net.IP) error
+	// Delete removes the interface, and with it its addresses and routes.
+	Delete(name string) error
+}
+
+// A vlanProber probes services from a VLAN interface, regardless of the
+// routing table.
+type vlanProber interface {
+	// ARP returns the MAC address target answers an ARP request with.
+	ARP(ctx context.Context, iface string, source, target net.IP) (string, error)
+	DNS(ctx context.Context, iface, server, name string) (dnsResponse, error)
+	// Connect opens and closes a TCP connection to address.
+	Connect(ctx context.Context, iface, address string) error
+}
+
+// VLANServiceCheck verifies that the services the node needs are
+// reachable on the management network's tagged VLAN, which
+// NetworkTopologyCheck only validates the configuration of.  A switch port
+// which doesn't trunk the VLAN, or a VLAN without the expected gateway,
+// otherwise only shows up as an unreachable node after installation.  A
+// temporary VLAN interface is set up on the first management interface
+// which exists, with the configured static address, or one leased by
+// DHCP but not applied by the DHCP client.  From it, the gateway is sent
+// an ARP request, the configured DNS servers (or those from the lease)
+// are queried, and when joining a cluster, a TCP connection is made to
+// the join server, via host routes through the gateway.  The interface is
+// removed afterwards, whatever happened.  The check refuses to run if the
+// VLAN is already set up on the parent, or the address conflicts with
+// one the host already has, since the temporary interface would disturb
+// the live environment's networking.
+type VLANServiceCheck struct {
+	// Members are interface names or MAC addresses.
+	Members []string
+	Network config.Network
+	// DNSServers are those the installed system will use.
+	DNSServers []string
+	// ServerURL is that of the join server, if joining a cluster.
+	ServerURL string
+	Linker    vlanLinker
+	Prober    vlanProber
+	Resolver  hostResolver
+}
+
+// NewVLANServiceCheck returns a VLANServiceCheck for the management
+// network in the given install configuration.
+func NewVLANServiceCheck(cfg *config.HarvesterConfig) VLANServiceCheck {
+	check := VLANServiceCheck{
+		Members:    NewNetworkTopologyCheck(cfg).Members,
+		Network:    cfg.ManagementInterface,
+		DNSServers: cfg.OS.DNSNameservers,
+		Linker:     ipVLANLinker{},
+		Prober:     deviceProber{Timeout: vlanProbeTimeout},
+		Resolver:   net.DefaultResolver,
+	}
+	if cfg.Install.Mode == config.ModeJoin {
+		check.ServerURL = cfg.ServerURL
+	}
+	return check
+}
+
+func (c VLANServiceCheck) Evaluate(ctx context.Context, env *Env) (result Result, err error) {
+	result.Name = "VLANService"
+	id := c.Network.VlanID
+	if id < 2 || id > 4094 {
+		result.Message = "Skipped: the management network is not on a tagged VLAN."
+		return
+	}
+	var static *net.IPNet
+	switch c.Network.Method {
+	case config.NetworkMethodDHCP:
+	case config.NetworkMethodStatic:
+		ip := net.ParseIP(c.Network.IP).To4()
+		mask := net.ParseIP(c.Network.SubnetMask).To4()
+		if ip == nil || mask == nil {
+			result.Message = "Skipped: the static management address is not valid."
+			return
+		}
+		static = &net.IPNet{IP: ip, Mask: net.IPMask(mask)}
+	default:
+		result.Message = "Skipped: the management network has no address to probe the VLAN with."
+		return
+	}
+
+	if env.Inventory.NICs == nil {
+		if env.Inventory.NICs, err = env.listNICs(); err != nil {
+			return
+		}
+	}
+	parent := ""
+	for _, member := range c.Members {
+		index := slices.IndexFunc(env.Inventory.NICs, func(nic NIC) bool {
+			return nic.Name == member || strings.EqualFold(nic.HwAddr, member)
+		})
+		if index >= 0 {
+			parent = env.Inventory.NICs[index].Name
+			break
+		}
+	}
+	if parent == "" {
+		result.Message = "Skipped: none of the management interfaces exist on this host."
+		return
+	}
+	if existing := existingVLAN(env, parent, id); existing != "" {
+		result.Message = fmt.Sprintf("Skipped: VLAN %d is already set up on %s as %s, so a temporary interface would conflict with it.",
+			id, parent, existing)
+		return
+	}
+	addrs, err := env.host().interfaceAddrs()
+	if err != nil {
+		return
+	}
+	if static != nil {
+		if conflict := addressConflict(static, addrs); conflict != "" {
+			result.Message = fmt.Sprintf("Skipped: %s, so the VLAN was not probed.", conflict)
+			return
+		}
+	}
+
+	var findings []string
+	finding := func(severity Severity, format string, args ...interface{}) {
+		result.Severity = max(result.Severity, severity)
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+	defer func() {
+		if len(findings) > 0 {
+			result.Message = strings.Join(findings, " ")
+		}
+	}()
+
+	link := fmt.Sprintf("%s%d", vlanLinkPrefix, id)
+	// Add may fail halfway, so the interface is removed regardless
+	defer func() {
+		if deleteErr := c.Linker.Delete(link); deleteErr != nil && err == nil {
+			err = fmt.Errorf("cannot remove the temporary VLAN interface %s: %w", link, deleteErr)
+		}
+	}()
+	if err = c.Linker.Add(ctx, parent, link, id); err != nil {
+		return
+	}
+
+	source, gateway, dnsServers := static, net.ParseIP(c.Network.Gateway).To4(), c.DNSServers
+	if static == nil {
+		lease, err := c.Linker.Lease(ctx, link)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if err != nil {
+			finding(SeverityFatal, "VLAN %d on %s: no DHCP lease: %v.", id, parent, err)
+			return result, nil
+		}
+		if conflict := addressConflict(lease.Address, addrs); conflict != "" {
+			result.Message = fmt.Sprintf("Skipped: the DHCP lease on VLAN %d is for %s, but %s, so the VLAN was not probed.",
+				id, lease.Address, conflict)
+			return result, nil
+		}
+		source, gateway = lease.Address, lease.Gateway
+		if len(dnsServers) == 0 {
+			dnsServers = lease.DNSServers
+		}
+		finding(SeverityOK, "VLAN %d on %s: leased %s from DHCP server %s.", id, parent, lease.Address, lease.Server)
+	} else {
+		finding(SeverityOK, "VLAN %d on %s: using %s.", id, parent, static)
+	}
+	if err = c.Linker.Assign(ctx, link, source); err != nil {
+		return
+	}
+
+	routed := map[string]bool{}
+	route := func(dest net.IP) error {
+		switch {
+		case source.Contains(dest) || routed[dest.String()]:
+			return nil
+		case gateway == nil:
+			return errors.New("it is off the VLAN's subnet, and there is no gateway")
+		}
+		if err := c.Linker.Route(ctx, link, dest, gateway); err != nil {
+			return err
+		}
+		routed[dest.String()] = true
+		return nil
+	}
+
+	if gateway == nil {
+		finding(SeverityWarning, "There is no gateway, so only the VLAN's own subnet is reachable.")
+	} else if mac, err := c.Prober.ARP(ctx, link, source.IP, gateway); err != nil {
+		finding(SeverityFatal, "Gateway %s: no ARP reply: %v.", gateway, err)
+	} else {
+		finding(SeverityOK, "Gateway %s: answered ARP from %s.", gateway, mac)
+	}
+
+	c.probeDNS(ctx, env, &result, link, dnsServers, route, finding)
+	c.probeJoinServer(ctx, env, &result, link, route, finding)
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	return
+}
+
+// probeDNS queries each DNS server from the VLAN, for the DNS probe name.
+func (c VLANServiceCheck) probeDNS(ctx context.Context, env *Env, result *Result, link string, servers []string,
+	route func(net.IP) error, finding func(Severity, string, ...interface{})) {
+	if len(servers) == 0 {
+		finding(SeverityWarning, "There are no DNS servers to query.")
+		return
+	}
+	name := cmp.Or(env.Options.DNSProbeName, DefaultDNSProbeName)
+	answering, queried := 0, 0
+	for _, server := range servers {
+		ip := net.ParseIP(server)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case ip == nil:
+			continue
+		case env.Options.AirGapped && onInternet(server):
+			finding(SeverityOK, "%s", notContacted("DNS server "+server))
+			result.AirGapped = true
+			continue
+		}
+		queried++
+		if err := route(ip); err != nil {
+			finding(SeverityWarning, "DNS server %s: cannot be routed to: %v.", server, err)
+			continue
+		}
+		resp, err := c.Prober.DNS(ctx, link, server, name)
+		if err != nil {
+			finding(SeverityWarning, "DNS server %s: %v.", server, err)
+			continue
+		}
+		rcode := dnsRCodeNames[resp.RCode]
+		if rcode == "" {
+			rcode = fmt.Sprintf("RCODE %d", resp.RCode)
+		}
+		latency := resp.Latency.Round(time.Millisecond)
+		if resp.RCode == dnsRCodeSuccess || resp.RCode == dnsRCodeNameError {
+			answering++
+			finding(SeverityOK, "DNS server %s: answered %s for %s in %s.", server, rcode, name, latency)
+		} else {
+			finding(SeverityWarning, "DNS server %s: responded %s for %s in %s.", server, rcode, name, latency)
+		}
+	}
+	if queried > 0 && answering == 0 {
+		finding(SeverityFatal, "No DNS server answered on the VLAN.")
+	}
+}
+
+// probeJoinServer connects to the join server from the VLAN, when joining
+// a cluster.
+func (c VLANServiceCheck) probeJoinServer(ctx context.Context, env *Env, result *Result, link string,
+	route func(net.IP) error, finding func(Severity, string, ...interface{})) {
+	if c.ServerURL == "" || ctx.Err() != nil {
+		return
+	}
+	u, problem := parseServerURL(c.ServerURL)
+	if problem != "" {
+		// JoinCheck reports the problem
+		return
+	}
+	host := u.Hostname()
+	if env.Options.AirGapped && onInternet(host) {
+		finding(SeverityOK, "%s", notContacted("Join server "+u.Host))
+		result.AirGapped = true
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		lookupCtx, cancel := context.WithTimeout(ctx, vlanProbeTimeout)
+		addrs, err := c.Resolver.LookupHost(lookupCtx, host)
+		cancel()
+		if index := slices.IndexFunc(addrs, func(addr string) bool { return net.ParseIP(addr).To4() != nil }); index >= 0 {
+			ip = net.ParseIP(addrs[index])
+		} else {
+			if err == nil {
+				err = errors.New("it has no IPv4 address")
+			}
+			finding(SeverityFatal, "Join server %s: cannot resolve: %v.", u.Host, err)
+			return
+		}
+	}
+	address := net.JoinHostPort(ip.String(), u.Port())
+	if err := route(ip); err != nil {
+		finding(SeverityFatal, "Join server %s (%s): cannot be routed to: %v.", u.Host, ip, err)
+	} else if err := c.Prober.Connect(ctx, link, address); err != nil {
+		finding(SeverityFatal, "Join server %s (%s): no TCP connection: %v.", u.Host, ip, err)
+	} else {
+		finding(SeverityOK, "Join server %s (%s): accepted a TCP connection.", u.Host, ip)
+	}
+}
+
+// existingVLAN returns the name of the interface for VLAN id on parent, if
+// there is one.
+func existingVLAN(env *Env, parent string, id int) string {
+	data, err := env.readFile(filepath.Join(env.host().root, "proc/net/vlan/config"))
+	if err != nil {
+		return ""
+	}
+	// After two header lines, each line is "name | id | parent"
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 3 {
+			continue
+		}
+		if vid, err := strconv.Atoi(strings.TrimSpace(fields[1])); err == nil && vid == id && strings.TrimSpace(fields[2]) == parent {
+			return strings.TrimSpace(fields[0])
+		}
+	}
+	return ""
+}
+
+// addressConflict returns how addr conflicts with the addresses the host
+// already has, if it does: it can't be one of them, and its subnet can't
+// overlap one of theirs, or traffic for it would be routed to the VLAN.
+func addressConflict(addr *net.IPNet, addrs map[string][]*net.IPNet) string {
+	names := make([]string, 0, len(addrs))
+	for name := range addrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, existing := range addrs[name] {
+			if existing.IP.To4() == nil || existing.IP.IsLoopback() {
+				continue
+			}
+			switch {
+			case existing.IP.Equal(addr.IP):
+				return fmt.Sprintf("%s is already assigned to %s", addr.IP, name)
+			case existing.Contains(addr.IP) || addr.Contains(existing.IP):
+				return fmt.Sprintf("%s overlaps %s on %s", addr, existing, name)
+			}
+		}
+	}
+	return ""
+}
+
+// bindToDevice returns a net.Dialer Control function which binds sockets
+// to device, or nil if device is empty.
+func bindToDevice(device string) func(network, address string, conn syscall.RawConn) error {
+	if device == "" {
+		return nil
+	}
+	return func(_, _ string, conn syscall.RawConn) error {
+		var sockErr error
+		if err := conn.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, device)
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// ipVLANLinker sets up VLAN interfaces with ip(8), and leases addresses
+// with wicked's DHCP test mode, which doesn't apply them.
+type ipVLANLinker struct {
+	// Exec makes the commands, or is exec.Command if nil.
+	Exec execFunc
+}
+
+func (l ipVLANLinker) Add(_ context.Context, parent, name string, id int) error {
+	if err := l.runIP("link", "add", "link", parent, "name", name, "type", "vlan", "id", strconv.Itoa(id)); err != nil {
+		return err
+	}
+	return l.runIP("link", "set", "dev", name, "up")
+}
+
+func (l ipVLANLinker) Lease(_ context.Context, name string) (vlanLease, error) {
+	out, err := l.Exec.cmd("/usr/sbin/wicked", "test", "dhcp4", "--timeout", strconv.Itoa(int(vlanLeaseTimeout.Seconds())),
+		"--format", "info", name).Output()
+	if err != nil {
+		return vlanLease{}, fmt.Errorf("no lease within %s", vlanLeaseTimeout)
+	}
+	return parseWickedLeaseInfo(string(out))
+}
+
+func (l ipVLANLinker) Assign(_ context.Context, name string, addr *net.IPNet) error {
+	return l.runIP("address", "add", addr.String(), "dev", name)
+}
+
+func (l ipVLANLinker) Route(_ context.Context, name string, dest, gateway net.IP) error {
+	return l.runIP("route", "add", dest.String()+"/32", "via", gateway.String(), "dev", name)
+}
+
+func (l ipVLANLinker) Delete(name string) error {
+	err := l.runIP("link", "delete", "dev", name)
+	if err != nil && !strings.Contains(err.Error(), "Cannot find device") {
+		return err
+	}
+	return nil
+}
+
+// runIP runs ip(8) with args, returning what it says on failure.
+func (l ipVLANLinker) runIP(args ...string) error {
+	out, err := l.Exec.cmd("/usr/sbin/ip", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("ip %s: %s", strings.Join(args, " "), msg)
+		}
+		return fmt.Errorf("ip %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// parseWickedLeaseInfo parses the lease wicked's DHCP test mode prints in
+// its info format, which is shell variable assignments.
+func parseWickedLeaseInfo(info string) (lease vlanLease, err error) {
+	vars := map[string]string{}
+	for _, line := range strings.Split(info, "\n") {
+		if name, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			vars[name] = strings.Trim(value, `'"`)
+		}
+	}
+	ip, ipNet, err := net.ParseCIDR(vars["IPADDR"])
+	if err != nil {
+		return lease, errors.New("the lease has no address")
+	}
+	lease.Address = &net.IPNet{IP: ip, Mask: ipNet.Mask}
+	if gateways := strings.Fields(vars["GATEWAYS"]); len(gateways) > 0 {
+		lease.Gateway = net.ParseIP(gateways[0]).To4()
+	}
+	lease.Server = cmp.Or(vars["SERVERID"], "unknown")
+	lease.DNSServers = strings.Fields(vars["DNSSERVERS"])
+	return lease, nil
+}
+
+// deviceProber probes services from an interface, with Timeout for each
+// probe.
+type deviceProber struct {
+	Timeout time.Duration
+	// Exec makes the arping commands, or is exec.Command if nil.
+	Exec execFunc
+}
+
+func (p deviceProber) ARP(_ context.Context, iface string, source, target net.IP) (string, error) {
+	out, err := p.Exec.cmd("/usr/sbin/arping", "-c", "1", "-w", strconv.Itoa(int(p.Timeout.Seconds())),
+		"-I", iface, "-s", source.String(), target.String()).Output()
+	match := arpingReplyPattern.FindStringSubmatch(string(out))
+	if err != nil || match == nil {
+		return "", fmt.Errorf("no reply within %s", p.Timeout)
+	}
+	return strings.ToLower(match[1]), nil
+}
+
+func (p deviceProber) DNS(ctx context.Context, iface, server, name string) (dnsResponse, error) {
+	return udpDNSClient{Port: dnsPort, Timeout: p.Timeout, Device: iface}.Query(ctx, server, name)
+}
+
+func (p deviceProber) Connect(ctx context.Context, iface, address string) error {
+	dialer := net.Dialer{Timeout: p.Timeout, Control: bindToDevice(iface)}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if isTimeout(err) {
+		return fmt.Errorf("no response within %s", p.Timeout)
+	} else if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,394 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// fakeVLANLinker records what it's asked to do, failing the operations in
+// errs.
+type fakeVLANLinker struct {
+	lease vlanLease
+	errs  map[string]error
+	calls []string
+}
+
+func (l *fakeVLANLinker) Add(_ context.Context, parent, name string, id int) error {
+	l.calls = append(l.calls, fmt.Sprintf("add %s on %s as VLAN %d", name, parent, id))
+	return l.errs["add"]
+}
+
+func (l *fakeVLANLinker) Lease(_ context.Context, name string) (vlanLease, error) {
+	l.calls = append(l.calls, "lease on "+name)
+	return l.lease, l.errs["lease"]
+}
+
+func (l *fakeVLANLinker) Assign(_ context.Context, name string, addr *net.IPNet) error {
+	l.calls = append(l.calls, fmt.Sprintf("assign %s to %s", addr, name))
+	return l.errs["assign"]
+}
+
+func (l *fakeVLANLinker) Route(_ context.Context, name string, dest, gateway net.IP) error {
+	l.calls = append(l.calls, fmt.Sprintf("route %s via %s on %s", dest, gateway, name))
+	return l.errs["route"]
+}
+
+func (l *fakeVLANLinker) Delete(name string) error {
+	l.calls = append(l.calls, "delete "+name)
+	return l.errs["delete"]
+}
+
+// fakeVLANProber answers ARP for the addresses in arp, DNS queries as
+// dns does, and accepts connections to the addresses in listening.  If
+// cancel is set, it's called by the first probe.
+type fakeVLANProber struct {
+	arp       map[string]string
+	dns       fakeDNSClient
+	listening []string
+	cancel    context.CancelFunc
+}
+
+func (p fakeVLANProber) ARP(_ context.Context, _ string, _, target net.IP) (string, error) {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	if mac, ok := p.arp[target.String()]; ok {
+		return mac, nil
+	}
+	return "", errors.New("no reply within 5s")
+}
+
+func (p fakeVLANProber) DNS(ctx context.Context, _, server, name string) (dnsResponse, error) {
+	return p.dns.Query(ctx, server, name)
+}
+
+func (p fakeVLANProber) Connect(_ context.Context, _, address string) error {
+	for _, listening := range p.listening {
+		if listening == address {
+			return nil
+		}
+	}
+	return errors.New("connection refused")
+}
+
+func TestNewVLANServiceCheck(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.Mode = config.ModeJoin
+	cfg.ServerURL = "https://rancher.example.com"
+	cfg.OS.DNSNameservers = []string{"10.0.0.2"}
+	cfg.ManagementInterface = config.Network{
+		Interfaces: []config.NetworkInterface{{Name: "eno1"}, {HwAddr: "3c:ec:ef:12:34:57"}},
+		Method:     config.NetworkMethodDHCP,
+		VlanID:     100,
+	}
+	check := NewVLANServiceCheck(cfg)
+	assert.Equal(t, []string{"eno1", "3c:ec:ef:12:34:57"}, check.Members)
+	assert.Equal(t, "https://rancher.example.com", check.ServerURL)
+	assert.Equal(t, []string{"10.0.0.2"}, check.DNSServers)
+
+	cfg.Install.Mode = config.ModeCreate
+	assert.Empty(t, NewVLANServiceCheck(cfg).ServerURL)
+}
+
+func TestVLANServiceCheck(t *testing.T) {
+	h := testHost()
+	h.root = "./testdata/vlan-service"
+	h.interfaceAddrs = func() (map[string][]*net.IPNet, error) {
+		return map[string][]*net.IPNet{
+			"lo":   {{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(8, 32)}},
+			"eno1": {{IP: net.IPv4(10, 0, 0, 50), Mask: net.CIDRMask(24, 32)}},
+		}, nil
+	}
+
+	nics := []NIC{
+		{Name: "eno1", HwAddr: "3c:ec:ef:12:34:56"},
+		{Name: "eno2", HwAddr: "3c:ec:ef:12:34:57"},
+	}
+	static := config.Network{
+		Method:     config.NetworkMethodStatic,
+		IP:         "192.168.100.20",
+		SubnetMask: "255.255.255.0",
+		Gateway:    "192.168.100.1",
+		VlanID:     100,
+	}
+	dhcp := config.Network{Method: config.NetworkMethodDHCP, VlanID: 100}
+	lease := vlanLease{
+		Address:    &net.IPNet{IP: net.IPv4(192, 168, 100, 57).To4(), Mask: net.CIDRMask(24, 32)},
+		Gateway:    net.IPv4(192, 168, 100, 1).To4(),
+		Server:     "192.168.100.1",
+		DNSServers: []string{"192.168.100.2", "192.168.100.3"},
+	}
+	prober := fakeVLANProber{
+		arp: map[string]string{"192.168.100.1": "00:1c:73:00:00:99"},
+		dns: fakeDNSClient{
+			"192.168.100.2": {response: dnsResponse{RecursionAvailable: true, Answers: 1, Latency: 3 * time.Millisecond}},
+			"10.0.0.2":      {response: dnsResponse{RCode: dnsRCodeNameError, RecursionAvailable: true, Latency: 12 * time.Millisecond}},
+			"192.168.100.3": {err: errors.New("no response within 5s over UDP")},
+		},
+		listening: []string{"10.0.5.5:443"},
+	}
+	resolver := fakeResolver{"rancher.example.com": {"2001:db8::5", "10.0.5.5"}}
+
+	tests := []struct {
+		name       string
+		members    []string
+		network    config.Network
+		dnsServers []string
+		serverURL  string
+		lease      vlanLease
+		errs       map[string]error
+		prober     fakeVLANProber
+		options    Options
+		severity   Severity
+		message    string
+		err        string
+		calls      []string
+	}{
+		{
+			name:       "static, everything reachable",
+			members:    []string{"3c:ec:ef:12:34:56", "eno2"},
+			network:    static,
+			dnsServers: []string{"192.168.100.2", "10.0.0.2"},
+			serverURL:  "rancher.example.com",
+			prober:     prober,
+			message: "VLAN 100 on eno1: using 192.168.100.20/24. " +
+				"Gateway 192.168.100.1: answered ARP from 00:1c:73:00:00:99. " +
+				"DNS server 192.168.100.2: answered NOERROR for docker.io in 3ms. " +
+				"DNS server 10.0.0.2: answered NXDOMAIN for docker.io in 12ms. " +
+				"Join server rancher.example.com:443 (10.0.5.5): accepted a TCP connection.",
+			calls: []string{
+				"add preflight100 on eno1 as VLAN 100",
+				"assign 192.168.100.20/24 to preflight100",
+				"route 10.0.0.2 via 192.168.100.1 on preflight100",
+				"route 10.0.5.5 via 192.168.100.1 on preflight100",
+				"delete preflight100",
+			},
+		},
+		{
+			name:     "DHCP, with the lease's DNS servers",
+			members:  []string{"eno9", "eno2"},
+			network:  dhcp,
+			lease:    lease,
+			prober:   prober,
+			severity: SeverityWarning,
+			message: "VLAN 100 on eno2: leased 192.168.100.57/24 from DHCP server 192.168.100.1. " +
+				"Gateway 192.168.100.1: answered ARP from 00:1c:73:00:00:99. " +
+				"DNS server 192.168.100.2: answered NOERROR for docker.io in 3ms. " +
+				"DNS server 192.168.100.3: no response within 5s over UDP.",
+			calls: []string{
+				"add preflight100 on eno2 as VLAN 100",
+				"lease on preflight100",
+				"assign 192.168.100.57/24 to preflight100",
+				"delete preflight100",
+			},
+		},
+		{
+			name:       "nothing reachable",
+			members:    []string{"eno1"},
+			network:    static,
+			dnsServers: []string{"192.168.100.3"},
+			serverURL:  "https://10.0.9.9:6443",
+			prober:     prober,
+			severity:   SeverityFatal,
+			message: "VLAN 100 on eno1: using 192.168.100.20/24. " +
+				"Gateway 192.168.100.1: answered ARP from 00:1c:73:00:00:99. " +
+				"DNS server 192.168.100.3: no response within 5s over UDP. " +
+				"No DNS server answered on the VLAN. " +
+				"Join server 10.0.9.9:6443 (10.0.9.9): no TCP connection: connection refused.",
+			calls: []string{
+				"add preflight100 on eno1 as VLAN 100",
+				"assign 192.168.100.20/24 to preflight100",
+				"route 10.0.9.9 via 192.168.100.1 on preflight100",
+				"delete preflight100",
+			},
+		},
+		{
+			name:       "no gateway",
+			members:    []string{"eno1"},
+			network:    config.Network{Method: config.NetworkMethodStatic, IP: "192.168.100.20", SubnetMask: "255.255.255.0", VlanID: 100},
+			dnsServers: []string{"10.0.0.2"},
+			prober:     fakeVLANProber{arp: map[string]string{}},
+			severity:   SeverityFatal,
+			message: "VLAN 100 on eno1: using 192.168.100.20/24. " +
+				"There is no gateway, so only the VLAN's own subnet is reachable. " +
+				"DNS server 10.0.0.2: cannot be routed to: it is off the VLAN's subnet, and there is no gateway. " +
+				"No DNS server answered on the VLAN.",
+			calls: []string{
+				"add preflight100 on eno1 as VLAN 100",
+				"assign 192.168.100.20/24 to preflight100",
+				"delete preflight100",
+			},
+		},
+		{
+			name:       "gateway silent, air-gapped",
+			members:    []string{"eno1"},
+			network:    static,
+			dnsServers: []string{"8.8.8.8"},
+			serverURL:  "https://rancher.example.com",
+			prober:     fakeVLANProber{listening: []string{"10.0.5.5:443"}},
+			options:    Options{AirGapped: true},
+			severity:   SeverityFatal,
+			message: "VLAN 100 on eno1: using 192.168.100.20/24. " +
+				"Gateway 192.168.100.1: no ARP reply: no reply within 5s. " +
+				"DNS server 8.8.8.8 is on the internet, so it was not contacted in air-gapped mode. " +
+				"Join server rancher.example.com:443 (10.0.5.5): accepted a TCP connection.",
+			calls: []string{
+				"add preflight100 on eno1 as VLAN 100",
+				"assign 192.168.100.20/24 to preflight100",
+				"route 10.0.5.5 via 192.168.100.1 on preflight100",
+				"delete preflight100",
+			},
+		},
+		{
+			name:     "no DHCP lease",
+			members:  []string{"eno1"},
+			network:  dhcp,
+			errs:     map[string]error{"lease": errors.New("no lease within 15s")},
+			severity: SeverityFatal,
+			message:  "VLAN 100 on eno1: no DHCP lease: no lease within 15s.",
+			calls:    []string{"add preflight100 on eno1 as VLAN 100", "lease on preflight100", "delete preflight100"},
+		},
+		{
+			name:    "leased address conflicts",
+			members: []string{"eno1"},
+			network: dhcp,
+			lease:   vlanLease{Address: &net.IPNet{IP: net.IPv4(10, 0, 0, 77).To4(), Mask: net.CIDRMask(16, 32)}},
+			message: "Skipped: the DHCP lease on VLAN 100 is for 10.0.0.77/16, but 10.0.0.77/16 overlaps 10.0.0.50/24 on eno1, " +
+				"so the VLAN was not probed.",
+			calls: []string{"add preflight100 on eno1 as VLAN 100", "lease on preflight100", "delete preflight100"},
+		},
+		{
+			name:    "adding the interface fails",
+			members: []string{"eno1"},
+			network: static,
+			errs:    map[string]error{"add": errors.New("ip link set dev preflight100 up: RTNETLINK answers: Operation not permitted")},
+			err:     "ip link set dev preflight100 up: RTNETLINK answers: Operation not permitted",
+			calls:   []string{"add preflight100 on eno1 as VLAN 100", "delete preflight100"},
+		},
+		{
+			name:    "assigning the address fails",
+			members: []string{"eno1"},
+			network: static,
+			errs:    map[string]error{"assign": errors.New("ip address add 192.168.100.20/24 dev preflight100: RTNETLINK answers: File exists")},
+			message: "VLAN 100 on eno1: using 192.168.100.20/24.",
+			err:     "ip address add 192.168.100.20/24 dev preflight100: RTNETLINK answers: File exists",
+			calls:   []string{"add preflight100 on eno1 as VLAN 100", "assign 192.168.100.20/24 to preflight100", "delete preflight100"},
+		},
+		{
+			name:    "removing the interface fails",
+			members: []string{"eno1"},
+			network: static,
+			errs:    map[string]error{"delete": errors.New("ip link delete dev preflight100: RTNETLINK answers: Device or resource busy")},
+			prober:  prober,
+			message: "VLAN 100 on eno1: using 192.168.100.20/24. " +
+				"Gateway 192.168.100.1: answered ARP from 00:1c:73:00:00:99. " +
+				"There are no DNS servers to query.",
+			severity: SeverityWarning,
+			err:      "cannot remove the temporary VLAN interface preflight100: ip link delete dev preflight100: RTNETLINK answers: Device or resource busy",
+			calls:    []string{"add preflight100 on eno1 as VLAN 100", "assign 192.168.100.20/24 to preflight100", "delete preflight100"},
+		},
+		{
+			name:    "static address conflicts",
+			members: []string{"eno1"},
+			network: config.Network{Method: config.NetworkMethodStatic, IP: "10.0.0.50", SubnetMask: "255.255.255.0", VlanID: 100},
+			message: "Skipped: 10.0.0.50 is already assigned to eno1, so the VLAN was not probed.",
+		},
+		{
+			name:    "VLAN already set up",
+			members: []string{"eno2"},
+			network: config.Network{Method: config.NetworkMethodDHCP, VlanID: 200},
+			message: "Skipped: VLAN 200 is already set up on eno2 as eno2.200, so a temporary interface would conflict with it.",
+		},
+		{
+			name:    "untagged",
+			members: []string{"eno1"},
+			network: config.Network{Method: config.NetworkMethodDHCP, VlanID: 1},
+			message: "Skipped: the management network is not on a tagged VLAN.",
+		},
+		{
+			name:    "no management interfaces",
+			members: []string{"eno9"},
+			network: dhcp,
+			message: "Skipped: none of the management interfaces exist on this host.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			linker := &fakeVLANLinker{lease: tt.lease, errs: tt.errs}
+			check := VLANServiceCheck{
+				Members:    tt.members,
+				Network:    tt.network,
+				DNSServers: tt.dnsServers,
+				ServerURL:  tt.serverURL,
+				Linker:     linker,
+				Prober:     tt.prober,
+				Resolver:   resolver,
+			}
+			env := &Env{Options: tt.options, Inventory: Inventory{NICs: nics}, machine: h}
+			result, err := check.Evaluate(context.Background(), env)
+			if tt.err == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+			assert.Equal(t, Result{Name: "VLANService", Severity: tt.severity, Message: tt.message, AirGapped: tt.options.AirGapped}, result)
+			assert.Equal(t, tt.calls, linker.calls)
+		})
+	}
+}
+
+// The interface is removed even when the check is cancelled mid-probe.
+func TestVLANServiceCheckCancelled(t *testing.T) {
+	h := testHost()
+	h.interfaceAddrs = func() (map[string][]*net.IPNet, error) { return nil, nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	linker := &fakeVLANLinker{}
+	check := VLANServiceCheck{
+		Members: []string{"eno1"},
+		Network: config.Network{Method: config.NetworkMethodStatic, IP: "192.168.100.20", SubnetMask: "255.255.255.0",
+			Gateway: "192.168.100.1", VlanID: 100},
+		DNSServers: []string{"192.168.100.2"},
+		Linker:     linker,
+		Prober:     fakeVLANProber{cancel: cancel},
+	}
+	_, err := check.Evaluate(ctx, &Env{Inventory: Inventory{NICs: []NIC{{Name: "eno1"}}}, machine: h})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{
+		"add preflight100 on eno1 as VLAN 100",
+		"assign 192.168.100.20/24 to preflight100",
+		"delete preflight100",
+	}, linker.calls)
+}
+
+func TestParseWickedLeaseInfo(t *testing.T) {
+	lease, err := parseWickedLeaseInfo(`INTERFACE='preflight100'
+TYPE='dhcp'
+FAMILY='ipv4'
+IPADDR='192.168.100.57/24'
+NETMASK='255.255.255.0'
+GATEWAYS='192.168.100.1 192.168.100.254'
+DNSSERVERS='192.168.100.2 192.168.100.3'
+SERVERID='192.168.100.1'
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, vlanLease{
+		Address:    &net.IPNet{IP: net.ParseIP("192.168.100.57"), Mask: net.CIDRMask(24, 32)},
+		Gateway:    net.IPv4(192, 168, 100, 1).To4(),
+		Server:     "192.168.100.1",
+		DNSServers: []string{"192.168.100.2", "192.168.100.3"},
+	}, lease)
+
+	_, err = parseWickedLeaseInfo("INTERFACE='preflight100'\n")
+	assert.EqualError(t, err, "the lease has no address")
+}
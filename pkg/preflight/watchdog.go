@@ -0,0 +1,109 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// Watchdog describes a watchdog device.  Timeouts are in seconds, and
+// zero if the driver doesn't report them.
+type Watchdog struct {
+	Name       string
+	Identity   string
+	Timeout    uint64
+	MinTimeout uint64
+	MaxTimeout uint64
+}
+
+// softdog reports whether this is the kernel's software watchdog, which
+// can't reset a machine whose kernel has hung.
+func (w Watchdog) softdog() bool {
+	return w.Identity == "Software Watchdog"
+}
+
+// WatchdogCheck lists the watchdog devices, which HA relies on for
+// fencing.  In production mode, it warns if there are none, or only
+// softdog.  It only looks at sysfs, because opening the device would arm
+// the watchdog.
+type WatchdogCheck struct{}
+
+func (c WatchdogCheck) Evaluate(_ context.Context, env *Env) (result Result, err error) {
+	result.Name = "Watchdog"
+
+	watchdogs, err := listWatchdogs(env)
+	if err != nil {
+		return
+	}
+	env.Inventory.Watchdogs = watchdogs
+
+	if len(watchdogs) == 0 {
+		result.Message = "No watchdog device detected."
+		if env.Options.Production {
+			result.Severity = SeverityWarning
+			result.Message += " A hardware watchdog is required for reliable fencing in production use."
+		}
+		return
+	}
+
+	var descs []string
+	hardware := false
+	for _, w := range watchdogs {
+		details := []string{w.Identity}
+		if w.Timeout > 0 {
+			details = append(details, fmt.Sprintf("timeout %ds", w.Timeout))
+		}
+		if w.MaxTimeout > 0 {
+			details = append(details, fmt.Sprintf("max %ds", w.MaxTimeout))
+		}
+		descs = append(descs, fmt.Sprintf("%s (%s)", w.Name, strings.Join(details, ", ")))
+		if !w.softdog() {
+			hardware = true
+		}
+	}
+	result.Message = fmt.Sprintf("Watchdog devices: %s.", strings.Join(descs, ", "))
+	if !hardware && env.Options.Production {
+		result.Severity = SeverityWarning
+		result.Message += " Only the software watchdog is available, which can't recover from a hung kernel. " +
+			"A hardware watchdog is required for reliable fencing in production use."
+	}
+	return
+}
+
+// listWatchdogs returns the watchdogs which have device nodes, with their
+// details from sysfs.
+func listWatchdogs(env *Env) ([]Watchdog, error) {
+	entries, err := env.readDir(env.host().sysClassWatchdog)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var watchdogs []Watchdog
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "watchdog") {
+			continue
+		}
+		if _, err := env.stat(filepath.Join(env.host().devDir, name)); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(env.host().sysClassWatchdog, name)
+		w := Watchdog{Name: name, Identity: "unknown"}
+		if out, err := env.readFile(filepath.Join(dir, "identity")); err == nil {
+			w.Identity = strings.TrimSpace(string(out))
+		}
+		// These are all optional, depending on the driver
+		w.Timeout, _ = env.readSysfsUint(filepath.Join(dir, "timeout"))
+		w.MinTimeout, _ = env.readSysfsUint(filepath.Join(dir, "min_timeout"))
+		w.MaxTimeout, _ = env.readSysfsUint(filepath.Join(dir, "max_timeout"))
+		watchdogs = append(watchdogs, w)
+	}
+	return watchdogs, nil
+}
@@ -0,0 +1,67 @@
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdogCheck(t *testing.T) {
+	h := testHost()
+
+	itco := Watchdog{Name: "watchdog0", Identity: "iTCO_wdt", Timeout: 30, MinTimeout: 2, MaxTimeout: 613}
+	softdog := Watchdog{Name: "watchdog1", Identity: "Software Watchdog", Timeout: 60, MinTimeout: 1, MaxTimeout: 65535}
+	softdogOnly := softdog
+	softdogOnly.Name = "watchdog0"
+
+	tests := []struct {
+		fixture    string
+		production bool
+		watchdogs  []Watchdog
+		severity   Severity
+		message    string
+	}{
+		{
+			fixture:    "itco",
+			production: true,
+			watchdogs:  []Watchdog{itco, softdog},
+			message: "Watchdog devices: watchdog0 (iTCO_wdt, timeout 30s, max 613s), " +
+				"watchdog1 (Software Watchdog, timeout 60s, max 65535s).",
+		},
+		{
+			fixture:   "softdog",
+			watchdogs: []Watchdog{softdogOnly},
+			message:   "Watchdog devices: watchdog0 (Software Watchdog, timeout 60s, max 65535s).",
+		},
+		{
+			fixture:    "softdog",
+			production: true,
+			watchdogs:  []Watchdog{softdogOnly},
+			severity:   SeverityWarning,
+			message: "Watchdog devices: watchdog0 (Software Watchdog, timeout 60s, max 65535s). " +
+				"Only the software watchdog is available, which can't recover from a hung kernel. " +
+				"A hardware watchdog is required for reliable fencing in production use.",
+		},
+		{
+			fixture: "none",
+			message: "No watchdog device detected.",
+		},
+		{
+			fixture:    "none",
+			production: true,
+			severity:   SeverityWarning,
+			message:    "No watchdog device detected. A hardware watchdog is required for reliable fencing in production use.",
+		},
+	}
+
+	for _, test := range tests {
+		h.devDir = "./testdata/watchdog/" + test.fixture + "/dev"
+		h.sysClassWatchdog = "./testdata/watchdog/" + test.fixture + "/sys/class/watchdog"
+		env := &Env{Options: Options{Production: test.production}, machine: h}
+		result, err := WatchdogCheck{}.Evaluate(context.Background(), env)
+		assert.Nil(t, err, test.fixture)
+		assert.Equal(t, Result{Name: "Watchdog", Severity: test.severity, Message: test.message}, result, test.fixture)
+		assert.Equal(t, test.watchdogs, env.Inventory.Watchdogs, test.fixture)
+	}
+}
@@ -3,4 +3,5 @@ package version
 var (
 	Version          = "dev"
 	HarvesterVersion = "dev" // Will be replaced by ldflags
+	BuildDate        = ""    // RFC 3339, will be replaced by ldflags
 )
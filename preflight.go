@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
+)
+
+// exitCancelled is the exit status of the "preflight" subcommand when it's
+// interrupted, as a shell reports a command killed by SIGINT.
+const exitCancelled = 130
+
+// errPreflightCancelled is returned by runPreflight when the run was
+// interrupted, after what it had found was reported.
+var errPreflightCancelled = errors.New("preflight run cancelled, so the report is partial")
+
+// runPreflight implements the "preflight" subcommand, which runs the
+// preflight checks against an install configuration without starting
+// the console.
+func runPreflight(args []string) error {
+	flags := flag.NewFlagSet("preflight", flag.ExitOnError)
+	configFile := flags.String("config", "", "install configuration to check (default: read from the kernel command line, or "+preflight.InstalledConfigPath+" for upgrades)")
+	mode := flags.String("mode", "", "what the checks are run ahead of, \"install\" or \"upgrade\" (default: install)")
+	allowDestructive := flags.Bool("allow-destructive", false, "treat existing data on the target disks as disposable")
+	production := flags.Bool("production", false, "check that the host is fit for production use, not just testing")
+	secureBoot := flags.String("secure-boot", "", "Secure Boot policy to enforce, \"required\" or \"must-be-off\" (default: any)")
+	requireTPM := flags.Bool("require-tpm", false, "fail if the host doesn't have a TPM 2.0 device, as encrypted installs need")
+	lsm := flags.String("lsm", "", "SELinux policy to enforce, \"require-enforcing\" or \"require-permissive-or-off\" (default: any)")
+	knownMachineIDs := flags.String("known-machine-ids", "", "comma-separated machine IDs of known clone sources")
+	fleetInventory := flags.String("fleet-inventory", "", "file listing the machine IDs of existing hosts, one per line")
+	clusterVersion := flags.String("cluster-version", "", "version of the cluster being joined (default: ask the join server)")
+	clusterNodes := flags.String("cluster-nodes", "", "comma-separated names of the nodes of the cluster being joined (default: ask the join server)")
+	clusterAPITokenFile := flags.String("cluster-api-token-file", "", "file holding an API token for the cluster being joined, for listing its nodes")
+	maxVersionSkew := flags.Int("max-version-skew", preflight.DefaultMaxVersionSkew, "how many minor versions the installer may be from the cluster being joined")
+	airGapped := flags.Bool("air-gapped", false, "the host has no internet access, so nothing can be fetched from outside the site (also set by install.air_gapped)")
+	dnsProbeName := flags.String("dns-probe-name", preflight.DefaultDNSProbeName, "name the configured DNS servers are asked to resolve; use an on-site name when air-gapped")
+	weakPasswordFatal := flags.Bool("weak-password-fatal", false, "with --production, fail rather than warn when the node password is weak")
+	minTokenEntropy := flags.Int("min-token-entropy", preflight.DefaultMinTokenEntropy, "fewest bits of entropy the cluster token may have without being warned about")
+	role := flags.String("role", "", "role of the node, \"management\", \"worker\" or \"witness\", which sets the hardware requirements (default: from the configuration)")
+	caExpiryWindow := flags.Duration("ca-expiry-window", preflight.DefaultCAExpiryWindow, "how soon before they expire additional CA certificates are warned about")
+	maxToolOutput := flags.Int64("max-tool-output", preflight.DefaultMaxToolOutput, "most bytes of output kept from each external tool the checks run")
+	registryProbeManifest := flags.String("registry-probe-manifest", "", "manifest, e.g. library/busybox:1.36, registry mirrors must allow pulling (default: only check that they accept the credentials)")
+	captureEvidence := flags.Bool("capture-evidence", false, "record in the JSON report what each check read from the host, with credentials masked, for support")
+	autoRemediate := flags.Bool("auto-remediate", false, "load missing kernel modules, set recommended sysctls and start services in the live environment where checks say to, then check again; nothing destructive is ever done")
+	benchmarks := flags.Bool("benchmarks", false, "also run the benchmarks, which load the host to measure how it performs, and take a while")
+	benchmarkBudget := flags.Duration("benchmark-budget", preflight.DefaultBenchmarkBudget, "how long the benchmarks may take altogether; those which wouldn't fit are skipped")
+	parallel := flags.Int("parallel", 1, "how many checks may run at once; those which depend on what others find still wait for them")
+	quiet := flags.Bool("quiet", false, "only show the checks which didn't pass, and the summary")
+	verbose := flags.Bool("verbose", false, "also show what each check measured, its thresholds, how to fix what it found, how long it took, and why it was skipped or couldn't run")
+	debug := flags.Bool("debug", os.Getenv("DEBUG") == "true", "cross-check what the checks find with other tools, and log in detail")
+	output := flags.String("output", preflight.DefaultReportPath, "where to write the JSON report")
+	nodeStatusOutput := flags.String("node-status-output", preflight.DefaultNodeStatusPath, "where to write the report as node conditions and annotations")
+	annotationLimit := flags.Int("annotation-limit", preflight.DefaultAnnotationLimit, "most bytes the report's node annotation may take")
+	sign := flags.Bool("sign", false, "sign the JSON report with install.report_signing.key, or a key generated for the session, whose public half is shown (also set by install.report_signing.enabled)")
+	verify := flags.String("verify", "", "rather than running the checks, verify that this JSON report hasn't been modified since it was written")
+	publicKey := flags.String("public-key", "", "with --verify, the base64 public key the report must have been signed with (default: only check its hash)")
+	bundle := flags.String("bundle", "", "also write a support bundle, a tarball of the report and what the host says about itself, with credentials masked, here")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *verify != "" {
+		return verifyPreflightReport(*verify, *publicKey)
+	}
+	runMode, err := preflight.ParseRunMode(*mode)
+	if err != nil {
+		return err
+	}
+	renderMode, err := preflight.ParseRenderMode(*quiet, *verbose)
+	if err != nil {
+		return err
+	}
+	if *configFile == "" && runMode == preflight.RunModeUpgrade {
+		*configFile = preflight.InstalledConfigPath
+	}
+
+	// A configuration file is validated as a whole first, so that every
+	// problem with it is reported, even if it can't be loaded at all
+	var checks []preflight.ResultCheck
+	cfg, data, err := loadPreflightConfig(*configFile)
+	if data != nil {
+		checks = append(checks, preflight.ConfigSchemaCheck{Data: data})
+	}
+	switch {
+	case err == nil:
+		checks = append(checks, preflight.ConfigChecks(cfg)...)
+	case data != nil:
+		cfg = config.NewHarvesterConfig()
+	default:
+		return err
+	}
+
+	opts := preflight.OptionsFromConfig(cfg)
+	if *allowDestructive {
+		opts.DestructiveAllowed = true
+	}
+	opts.Production = *production
+	if *requireTPM {
+		opts.TPMRequired = true
+	}
+	if *knownMachineIDs != "" {
+		opts.KnownMachineIDs = strings.Split(*knownMachineIDs, ",")
+	}
+	opts.FleetInventory = *fleetInventory
+	opts.ClusterVersion = *clusterVersion
+	if *clusterNodes != "" {
+		for _, name := range strings.Split(*clusterNodes, ",") {
+			opts.ClusterNodes = append(opts.ClusterNodes, preflight.ClusterNode{Name: strings.TrimSpace(name)})
+		}
+	}
+	if *clusterAPITokenFile != "" {
+		token, err := os.ReadFile(*clusterAPITokenFile)
+		if err != nil {
+			return err
+		}
+		opts.ClusterAPIToken = strings.TrimSpace(string(token))
+	}
+	opts.MaxVersionSkew = *maxVersionSkew
+	opts.AirGapped = opts.AirGapped || *airGapped
+	opts.DNSProbeName = *dnsProbeName
+	opts.WeakPasswordFatal = *weakPasswordFatal
+	opts.MinTokenEntropy = *minTokenEntropy
+	opts.CAExpiryWindow = *caExpiryWindow
+	opts.RegistryProbeManifest = *registryProbeManifest
+	opts.MaxToolOutput = *maxToolOutput
+	opts.CaptureEvidence = *captureEvidence
+	opts.AutoRemediate = *autoRemediate
+	opts.Benchmarks = *benchmarks
+	opts.BenchmarkBudget = *benchmarkBudget
+	if opts.Debug = *debug; opts.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	if opts.SecureBootPolicy, err = preflight.ParseSecureBootPolicy(*secureBoot); err != nil {
+		return err
+	}
+	if opts.LSMPolicy, err = preflight.ParseLSMPolicy(*lsm); err != nil {
+		return err
+	}
+	if *role != "" {
+		if opts.Role, err = preflight.ParseRole(*role); err != nil {
+			return err
+		}
+	}
+	runner := preflight.Runner{Checks: checks, Options: opts, Mode: runMode}
+	// Interrupting the run cancels the check in flight, and what the
+	// checks which had run found is still reported.  Once it's done, a
+	// second interrupt stops the subcommand as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	report := runner.RunParallel(ctx, *parallel)
+	stop()
+	// Telemetry is sent while the report is written, if it's enabled
+	sent := preflight.NewTelemetrySender(cfg).SendInBackground(context.Background(), report)
+	defer func() { <-sent }()
+
+	if err := preflight.NewRenderer(os.Stdout, renderMode).Render(os.Stdout, report); err != nil {
+		return err
+	}
+	cfg.Install.ReportSigning.Enabled = cfg.Install.ReportSigning.Enabled || *sign
+	key, generated, err := preflight.ReportSigningKey(cfg)
+	if err != nil {
+		return err
+	}
+	if generated {
+		fmt.Printf("The report is signed with a key generated for this session, whose public key is %s\n",
+			base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	}
+	if err := report.WriteSignedFile(*output, key); err != nil {
+		return err
+	}
+	if *bundle != "" {
+		bundleOpts := preflight.BundleOptions{
+			ReportPath: *output,
+			Secrets:    []string{opts.ClusterAPIToken, cfg.Token, cfg.OS.Password, cfg.Install.ReportSigning.Key},
+		}
+		if _, err := preflight.CollectSupportBundle(*bundle, bundleOpts); err != nil {
+			return err
+		}
+	}
+	if err := report.WriteNodeStatusFile(*nodeStatusOutput, *annotationLimit); err != nil {
+		return err
+	}
+	if report.Cancelled {
+		return errPreflightCancelled
+	}
+	return nil
+}
+
+// verifyPreflightReport implements --verify, saying whether the report at
+// path is as it was written, and signed by publicKey, if it's given.
+func verifyPreflightReport(path, publicKey string) error {
+	var key ed25519.PublicKey
+	if publicKey != "" {
+		var err error
+		if key, err = preflight.ParsePublicKey(publicKey); err != nil {
+			return err
+		}
+	}
+	report, err := preflight.VerifyReport(path, key)
+	if err != nil {
+		return err
+	}
+	switch {
+	case key != nil:
+		fmt.Printf("%s is as it was written, and signed by %s.\n", path, publicKey)
+	case report.Integrity.Signature != "":
+		fmt.Printf("%s matches its hash.  Give --public-key to check that it was signed by %s.\n", path, report.Integrity.PublicKey)
+	default:
+		fmt.Printf("%s matches its hash, but isn't signed.\n", path)
+	}
+	return nil
+}
+
+// loadPreflightConfig loads the install configuration from path, or the
+// kernel command line.  The document itself is returned too, if there is
+// one, even if it can't be loaded.
+func loadPreflightConfig(path string) (*config.HarvesterConfig, []byte, error) {
+	if path == "" {
+		cfg, err := config.ReadConfig()
+		return &cfg, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := config.LoadHarvesterConfig(data)
+	return cfg, data, err
+}